@@ -51,6 +51,14 @@ func RunPair(logger zerolog.Logger) error {
 			return
 		}
 		fmt.Println("\nSession saved to", sessionPath)
+
+		phoneID := data.GetMobile().GetSourceID()
+		info, err := client.RecordPairing(app.PairingPath(dataDir), phoneID, time.Now())
+		if err != nil {
+			logger.Warn().Err(err).Msg("Failed to save pairing info")
+		} else if info.PhoneChanged() {
+			fmt.Printf("Note: this is a different phone than before (was %s)\n", info.PreviousPhoneID)
+		}
 		fmt.Println("You can now run: openmessage serve")
 	}
 	cli.GM.PairCallback.Store(&pairCB)
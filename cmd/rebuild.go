@@ -0,0 +1,28 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/rs/zerolog"
+
+	"github.com/maxghenis/openmessage/internal/app"
+	"github.com/maxghenis/openmessage/internal/db"
+)
+
+// RunRebuild repopulates derived tables from the stored messages without
+// connecting to the phone.
+func RunRebuild(logger zerolog.Logger) error {
+	a, err := app.New(logger)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+
+	_, err = a.Store.Rebuild(func(s db.RebuildStep) {
+		fmt.Printf("Rebuilt %s (%d rows)\n", s.Name, s.Rows)
+	})
+	if err != nil {
+		return fmt.Errorf("rebuild: %w", err)
+	}
+	return nil
+}
@@ -3,11 +3,10 @@ package cmd
 import (
 	"fmt"
 
-	"github.com/google/uuid"
 	"github.com/rs/zerolog"
-	"go.mau.fi/mautrix-gmessages/pkg/libgm/gmproto"
 
 	"github.com/maxghenis/openmessage/internal/app"
+	"github.com/maxghenis/openmessage/internal/client"
 )
 
 func RunSend(logger zerolog.Logger, conversationID, message string) error {
@@ -21,38 +20,14 @@ func RunSend(logger zerolog.Logger, conversationID, message string) error {
 		return fmt.Errorf("connect: %w", err)
 	}
 
-	// Look up conversation to get outgoing participant ID
-	conv, err := a.Store.GetConversation(conversationID)
-	if err != nil {
-		return fmt.Errorf("get conversation: %w", err)
-	}
-	if conv == nil {
-		return fmt.Errorf("conversation %s not found", conversationID)
-	}
-
-	tmpID := uuid.NewString()
-	_, err = a.Client.GM.SendMessage(&gmproto.SendMessageRequest{
-		ConversationID: conversationID,
-		TmpID:          tmpID,
-		MessagePayload: &gmproto.MessagePayload{
-			TmpID:          tmpID,
-			TmpID2:         tmpID,
-			ConversationID: conversationID,
-			MessageInfo: []*gmproto.MessageInfo{
-				{
-					Data: &gmproto.MessageInfo_MessageContent{
-						MessageContent: &gmproto.MessageContent{
-							Content: message,
-						},
-					},
-				},
-			},
-		},
-	})
+	res, err := client.SendText(a.Store, a.Client.GM, conversationID, message, "")
 	if err != nil {
 		return fmt.Errorf("send: %w", err)
 	}
+	if !res.Success {
+		return fmt.Errorf("send: %s", res.FailureReason)
+	}
 
-	logger.Info().Str("conversation", conversationID).Msg("Message sent")
+	logger.Info().Str("conversation", conversationID).Str("tmp_id", res.TmpID).Msg("Message sent")
 	return nil
 }
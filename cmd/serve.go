@@ -1,28 +1,46 @@
 package cmd
 
 import (
+	"context"
 	"encoding/hex"
 	"fmt"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
+	"github.com/mark3labs/mcp-go/mcp"
 	mcpserver "github.com/mark3labs/mcp-go/server"
 	"github.com/rs/zerolog"
 
 	"github.com/maxghenis/openmessage/internal/app"
+	"github.com/maxghenis/openmessage/internal/client"
 	"github.com/maxghenis/openmessage/internal/tools"
 	"github.com/maxghenis/openmessage/internal/web"
 )
 
 func RunServe(logger zerolog.Logger) error {
+	// Refuse an unsafe bind before opening the database or connecting to
+	// the phone, so a misconfigured deployment fails fast.
+	host := ListenHost()
+	apiKey := os.Getenv("OPENMESSAGES_API_KEY")
+	if err := checkListenSafety(host, apiKey != "", os.Getenv("OPENMESSAGES_ALLOW_INSECURE") == "1"); err != nil {
+		return err
+	}
+	if !isLoopbackHost(host) && apiKey == "" {
+		logger.Warn().Str("bind", host).Msg("!!! Serving on a non-loopback address without OPENMESSAGES_API_KEY: anyone who can reach this port can read and send your messages !!!")
+	}
+
 	a, err := app.New(logger)
 	if err != nil {
 		return fmt.Errorf("init app: %w", err)
 	}
 	defer a.Close()
+	a.StartRetentionSweeper()
 
 	// Connect to Google Messages (skip in demo mode)
 	if os.Getenv("OPENMESSAGES_DEMO") == "" {
@@ -46,22 +64,30 @@ func RunServe(logger zerolog.Logger) error {
 		port = "7007"
 	}
 
-	// Create MCP server
+	// Create MCP server. Media resources are re-listed on each
+	// resources/list so new attachments show up.
+	hooks := &mcpserver.Hooks{}
 	mcpSrv := mcpserver.NewMCPServer(
 		"openmessage",
 		"0.1.0",
 		mcpserver.WithToolCapabilities(true),
+		mcpserver.WithResourceCapabilities(false, false),
+		mcpserver.WithHooks(hooks),
 	)
+	hooks.AddBeforeListResources(func(ctx context.Context, id any, req *mcp.ListResourcesRequest) {
+		if err := tools.RefreshMediaResources(mcpSrv, a); err != nil {
+			logger.Warn().Err(err).Msg("Failed to refresh media resources")
+		}
+	})
 	tools.Register(mcpSrv, a)
 
 	// Create SSE transport for MCP, mounted at /mcp/
-	sseSrv := mcpserver.NewSSEServer(mcpSrv,
-		mcpserver.WithBaseURL(fmt.Sprintf("http://localhost:%s", port)),
-		mcpserver.WithStaticBasePath("/mcp"),
-	)
+	sseSrv := newMCPSSEServer(mcpSrv, port, HeartbeatInterval())
 
 	var mediaUploader web.MediaUploader
+	var retrySync web.SyncRetrier
 	if a.Supabase != nil {
+		retrySync = a.RetrySync
 		mediaUploader = func(messageID string) (string, error) {
 			msg, err := a.Store.GetMessageByID(messageID)
 			if err != nil {
@@ -82,36 +108,122 @@ func RunServe(logger zerolog.Logger) error {
 			if ext := mimeToExt(msg.MimeType); ext != "" {
 				path += ext
 			}
-			return a.Supabase.UploadMedia(path, data, msg.MimeType)
+			url, err := client.UploadMediaOnce(a.Store, a.Supabase, path, data, msg.MimeType)
+			if url != "" && err != nil {
+				a.Logger.Warn().Err(err).Str("msg_id", messageID).Msg("Uploaded media but failed to record it")
+				err = nil
+			}
+			return url, err
 		}
 	}
 
-	httpHandler := web.APIHandlerFull(a.Store, a.Client, logger, sseSrv,
-		func() bool { return a.Connected.Load() },
-		a.Unpair,
-		mediaUploader,
-		a.DeepBackfill,
-	)
-	ln, err := net.Listen("tcp", ":"+port)
+	opts := web.Options{
+		IsConnected:          func() bool { return a.Connected.Load() },
+		Unpair:               a.Unpair,
+		MediaUploader:        mediaUploader,
+		OnDeepBackfill:       a.StartDeepBackfill,
+		BackfillConversation: a.StartBackfillConversation,
+		BackfillProgress:     a.ConversationBackfillProgress,
+		RetrySync:            retrySync,
+		PairingPath:          a.PairingPath,
+		MaxMediaDownloads:    MaxMediaDownloads(),
+		SearchLimit:          a.SearchLimit,
+		MaxUploadBytes:       MaxUploadBytes(),
+		SendReadReceipts:     a.SendReadReceipts,
+		AccessLog:            os.Getenv("OPENMESSAGES_ACCESS_LOG") == "1",
+		Typing:               a.Typing,
+		Deliveries:           a.Deliveries,
+		Events:               a.Events,
+		Publish:              a.Events.Publish,
+		Go:                   a.Go,
+		Sync:                 a,
+		APIKey:               apiKey,
+		CORSOrigins:          CORSOrigins(),
+	}
+	if a.Supabase != nil {
+		// Only assign when set: a nil *Writer in the interface is non-nil.
+		opts.Supabase = a.Supabase
+	}
+	httpHandler := web.APIHandlerWithOptions(a.Store, a.Client, logger, sseSrv, opts)
+	ln, err := listen(host, port)
 	if err != nil {
-		return fmt.Errorf("listen on port %s: %w", port, err)
+		return err
+	}
+	logger.Info().Str("addr", ln.Addr().String()).Msg("Listening")
+	logger.Info().Str("port", port).Msg("Web UI available at http://localhost:" + port)
+	logger.Info().Str("port", port).Msg("MCP SSE available at http://localhost:" + port + "/mcp/sse")
+
+	// Serve until a signal, then drain requests before the deferred
+	// a.Close() shuts the database.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	return runHTTPServer(ln, httpHandler, ctx.Done(), shutdownTimeout, logger)
+}
+
+// shutdownTimeout is how long in-flight requests get to finish on shutdown.
+const shutdownTimeout = 10 * time.Second
+
+// runHTTPServer serves h on ln until stop is closed, then stops accepting
+// connections and waits up to timeout for in-flight requests to finish.
+// Streaming requests such as /api/events and /mcp/sse are disconnected,
+// and long-polls such as /api/typing?wait= and /api/send's
+// wait_for_delivery stop waiting, rather than hold up the shutdown; other
+// requests are left to finish. It returns early with the server's error if serving fails.
+func runHTTPServer(ln net.Listener, h http.Handler, stop <-chan struct{}, timeout time.Duration, logger zerolog.Logger) error {
+	shutdown, closeStreams := context.WithCancel(context.Background())
+	defer closeStreams()
+	srv := &http.Server{Handler: closeStreamsOn(shutdown, h)}
+	srv.RegisterOnShutdown(closeStreams)
+
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- srv.Serve(ln) }()
+	select {
+	case err := <-serveErr:
+		return fmt.Errorf("http server: %w", err)
+	case <-stop:
 	}
-	go func() {
-		logger.Info().Str("port", port).Msg("Web UI available at http://localhost:" + port)
-		logger.Info().Str("port", port).Msg("MCP SSE available at http://localhost:" + port + "/mcp/sse")
-		if err := http.Serve(ln, httpHandler); err != nil {
-			logger.Error().Err(err).Msg("HTTP server error")
-		}
-	}()
 
-	// Block until signal
-	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
-	<-sigCh
 	logger.Info().Msg("Shutting down")
+	ctx, done := context.WithTimeout(context.Background(), timeout)
+	defer done()
+	if err := srv.Shutdown(ctx); err != nil {
+		return fmt.Errorf("shutdown http server: %w", err)
+	}
 	return nil
 }
 
+// streamPaths are the endpoints that may hold a response open longer than
+// shutdownTimeout: the event streams, which run indefinitely, and the
+// long-polls, which wait up to 30s (/api/typing) or 120s (/api/send) and
+// answer with what they have once their context is done.
+var streamPaths = map[string]bool{"/api/events": true, "/mcp/sse": true, "/api/typing": true, "/api/send": true}
+
+// closeStreamsOn cancels the context of requests to streamPaths once
+// shutdown is done, so their handlers return. Other requests keep their
+// own context.
+func closeStreamsOn(shutdown context.Context, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !streamPaths[r.URL.Path] {
+			h.ServeHTTP(w, r)
+			return
+		}
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		defer context.AfterFunc(shutdown, cancel)()
+		h.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// newMCPSSEServer creates the SSE transport for MCP, mounted at /mcp/. Idle
+// streams get a ping every heartbeat so reverse proxies don't close them.
+func newMCPSSEServer(mcpSrv *mcpserver.MCPServer, port string, heartbeat time.Duration) *mcpserver.SSEServer {
+	return mcpserver.NewSSEServer(mcpSrv,
+		mcpserver.WithBaseURL(fmt.Sprintf("http://localhost:%s", port)),
+		mcpserver.WithStaticBasePath("/mcp"),
+		mcpserver.WithKeepAliveInterval(heartbeat),
+	)
+}
+
 func mimeToExt(mime string) string {
 	switch mime {
 	case "image/jpeg":
@@ -133,6 +245,98 @@ func mimeToExt(mime string) string {
 	}
 }
 
+// ListenHost returns the interface to bind from OPENMESSAGES_BIND,
+// defaulting to loopback so messages aren't exposed to the network by
+// accident.
+func ListenHost() string {
+	if h, ok := os.LookupEnv("OPENMESSAGES_BIND"); ok {
+		return h
+	}
+	return "127.0.0.1"
+}
+
+// netListen is net.Listen, replaced in tests.
+var netListen = net.Listen
+
+// listen opens the web server's TCP listener on host and port.
+func listen(host, port string) (net.Listener, error) {
+	addr := net.JoinHostPort(host, port)
+	ln, err := netListen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("listen on %s: %w", addr, err)
+	}
+	return ln, nil
+}
+
+// checkListenSafety refuses to serve unauthenticated plaintext HTTP on a
+// non-loopback address unless the user explicitly allows it.
+func checkListenSafety(host string, hasToken, allowInsecure bool) error {
+	if isLoopbackHost(host) || hasToken || allowInsecure {
+		return nil
+	}
+	shown := host
+	if shown == "" {
+		shown = "all interfaces"
+	}
+	return fmt.Errorf("refusing to listen on %s without authentication: anyone on the network "+
+		"could read and send your messages. Bind to 127.0.0.1, set OPENMESSAGES_API_KEY "+
+		"to require a key, or set OPENMESSAGES_ALLOW_INSECURE=1 if the network is trusted", shown)
+}
+
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// defaultHeartbeat is below the 30-60s idle timeout common in reverse proxies.
+const defaultHeartbeat = 25 * time.Second
+
+// HeartbeatInterval returns the keepalive interval for streaming endpoints
+// based on OPENMESSAGES_HEARTBEAT_SECONDS, defaulting to 25s.
+func HeartbeatInterval() time.Duration {
+	if s := os.Getenv("OPENMESSAGES_HEARTBEAT_SECONDS"); s != "" {
+		if n, err := strconv.Atoi(s); err == nil && n > 0 {
+			return time.Duration(n) * time.Second
+		}
+	}
+	return defaultHeartbeat
+}
+
+// MaxMediaDownloads returns the cap on concurrent media downloads from
+// OPENMESSAGES_MAX_MEDIA_DOWNLOADS. Zero means the web package default.
+func MaxMediaDownloads() int {
+	n, err := strconv.Atoi(os.Getenv("OPENMESSAGES_MAX_MEDIA_DOWNLOADS"))
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+// MaxUploadBytes returns the /api/send-media size limit from
+// OPENMESSAGES_MAX_UPLOAD_MB. Zero means the web package default.
+func MaxUploadBytes() int64 {
+	n, err := strconv.ParseInt(os.Getenv("OPENMESSAGES_MAX_UPLOAD_MB"), 10, 64)
+	if err != nil || n <= 0 {
+		return 0
+	}
+	return n << 20
+}
+
+// CORSOrigins returns the origins allowed to call the API from a browser,
+// from the comma-separated OPENMESSAGES_CORS_ORIGINS.
+func CORSOrigins() []string {
+	var origins []string
+	for _, o := range strings.Split(os.Getenv("OPENMESSAGES_CORS_ORIGINS"), ",") {
+		if o = strings.TrimSpace(o); o != "" {
+			origins = append(origins, o)
+		}
+	}
+	return origins
+}
+
 // LogLevel returns the zerolog level based on OPENMESSAGES_LOG_LEVEL env var.
 func LogLevel() zerolog.Level {
 	switch os.Getenv("OPENMESSAGES_LOG_LEVEL") {
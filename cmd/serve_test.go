@@ -1,11 +1,18 @@
 package cmd
 
 import (
+	"bufio"
 	"io"
 	"net"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
 	"testing"
 	"time"
+
+	mcpserver "github.com/mark3labs/mcp-go/server"
+	"github.com/rs/zerolog"
 )
 
 func TestHTTPServerSurvivesIndependently(t *testing.T) {
@@ -47,3 +54,244 @@ func TestHTTPServerSurvivesIndependently(t *testing.T) {
 		t.Fatalf("got %q, want %q", body, "ok")
 	}
 }
+
+func TestMCPSSEServerSendsHeartbeat(t *testing.T) {
+	mcpSrv := mcpserver.NewMCPServer("openmessage-test", "0.1.0")
+	srv := httptest.NewServer(newMCPSSEServer(mcpSrv, "7007", 50*time.Millisecond))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/mcp/sse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				t.Fatal("stream closed before heartbeat")
+			}
+			if strings.Contains(line, `"method":"ping"`) {
+				return
+			}
+		case <-timeout:
+			t.Fatal("no heartbeat on idle stream within 2s")
+		}
+	}
+}
+
+func TestHeartbeatInterval(t *testing.T) {
+	t.Setenv("OPENMESSAGES_HEARTBEAT_SECONDS", "")
+	if got := HeartbeatInterval(); got != defaultHeartbeat {
+		t.Errorf("default: got %v, want %v", got, defaultHeartbeat)
+	}
+
+	t.Setenv("OPENMESSAGES_HEARTBEAT_SECONDS", "10")
+	if got := HeartbeatInterval(); got != 10*time.Second {
+		t.Errorf("configured: got %v, want 10s", got)
+	}
+
+	t.Setenv("OPENMESSAGES_HEARTBEAT_SECONDS", "bogus")
+	if got := HeartbeatInterval(); got != defaultHeartbeat {
+		t.Errorf("invalid: got %v, want %v", got, defaultHeartbeat)
+	}
+}
+
+func TestMaxUploadBytes(t *testing.T) {
+	for env, want := range map[string]int64{"": 0, "25": 25 << 20, "0": 0, "-5": 0, "lots": 0} {
+		t.Setenv("OPENMESSAGES_MAX_UPLOAD_MB", env)
+		if got := MaxUploadBytes(); got != want {
+			t.Errorf("OPENMESSAGES_MAX_UPLOAD_MB=%q: got %d, want %d", env, got, want)
+		}
+	}
+}
+
+func TestCheckListenSafety(t *testing.T) {
+	tests := []struct {
+		host          string
+		hasToken      bool
+		allowInsecure bool
+		wantErr       bool
+	}{
+		{"127.0.0.1", false, false, false},
+		{"::1", false, false, false},
+		{"localhost", false, false, false},
+		{"0.0.0.0", false, false, true},
+		{"", false, false, true},
+		{"192.168.1.20", false, false, true},
+		{"0.0.0.0", false, true, false},
+		{"0.0.0.0", true, false, false},
+	}
+	for _, tt := range tests {
+		err := checkListenSafety(tt.host, tt.hasToken, tt.allowInsecure)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("checkListenSafety(%q, token=%v, insecure=%v) = %v, wantErr %v",
+				tt.host, tt.hasToken, tt.allowInsecure, err, tt.wantErr)
+		}
+	}
+}
+
+func TestListenHostDefaultsToLoopback(t *testing.T) {
+	t.Setenv("OPENMESSAGES_BIND", "")
+	if got := ListenHost(); got != "" {
+		t.Errorf("explicit empty host: got %q", got)
+	}
+	os.Unsetenv("OPENMESSAGES_BIND")
+	if got := ListenHost(); got != "127.0.0.1" {
+		t.Errorf("default: got %q, want 127.0.0.1", got)
+	}
+}
+
+func TestListenHostBind(t *testing.T) {
+	t.Setenv("OPENMESSAGES_BIND", "0.0.0.0")
+	if got := ListenHost(); got != "0.0.0.0" {
+		t.Errorf("OPENMESSAGES_BIND: got %q", got)
+	}
+	t.Setenv("OPENMESSAGES_HOST", "192.168.1.5")
+	os.Unsetenv("OPENMESSAGES_BIND")
+	if got := ListenHost(); got != "127.0.0.1" {
+		t.Errorf("OPENMESSAGES_HOST is no longer read: got %q", got)
+	}
+}
+
+func TestListenComposesAddress(t *testing.T) {
+	var got string
+	netListen = func(network, addr string) (net.Listener, error) {
+		got = addr
+		return net.Listen(network, "127.0.0.1:0")
+	}
+	defer func() { netListen = net.Listen }()
+
+	for _, tt := range []struct{ host, port, want string }{
+		{"127.0.0.1", "7007", "127.0.0.1:7007"},
+		{"0.0.0.0", "8080", "0.0.0.0:8080"},
+		{"", "7007", ":7007"},
+		{"::1", "7007", "[::1]:7007"},
+	} {
+		ln, err := listen(tt.host, tt.port)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ln.Close()
+		if got != tt.want {
+			t.Errorf("listen(%q, %q) used %q, want %q", tt.host, tt.port, got, tt.want)
+		}
+	}
+}
+
+func TestRunHTTPServerDrainsOnShutdown(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started := make(chan struct{}, 3)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		select {
+		case <-time.After(200 * time.Millisecond):
+			w.Write([]byte("done"))
+		case <-r.Context().Done():
+			w.Write([]byte("cancelled"))
+		}
+	})
+	mux.HandleFunc("/api/typing", func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		select {
+		case <-time.After(time.Minute):
+			w.Write([]byte("changed"))
+		case <-r.Context().Done():
+			w.Write([]byte("unchanged"))
+		}
+	})
+	mux.HandleFunc("/api/events", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		started <- struct{}{}
+		<-r.Context().Done()
+	})
+
+	stop := make(chan struct{})
+	result := make(chan error, 1)
+	go func() { result <- runHTTPServer(ln, mux, stop, 5*time.Second, zerolog.Nop()) }()
+
+	base := "http://" + ln.Addr().String()
+	stream, err := http.Get(base + "/api/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Body.Close()
+	get := func(path string) <-chan string {
+		got := make(chan string, 1)
+		go func() {
+			resp, err := http.Get(base + path)
+			if err != nil {
+				got <- err.Error()
+				return
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			got <- string(body)
+		}()
+		return got
+	}
+	slow := get("/slow")
+	poll := get("/api/typing?wait=30")
+	<-started
+	<-started
+	<-started
+
+	close(stop)
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Fatalf("runHTTPServer: %v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("shutdown did not return; the stream should have been disconnected")
+	}
+	if got := <-slow; got != "done" {
+		t.Errorf("in-flight request got %q, want it to finish", got)
+	}
+	if got := <-poll; got != "unchanged" {
+		t.Errorf("long-poll got %q, want it to stop waiting and answer", got)
+	}
+	if _, err := http.Get(base + "/slow"); err == nil {
+		t.Error("server still accepting connections after shutdown")
+	}
+}
+
+func TestRunHTTPServerReturnsServeError(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.Close()
+	if err := runHTTPServer(ln, http.NewServeMux(), make(chan struct{}), time.Second, zerolog.Nop()); err == nil {
+		t.Error("expected an error serving on a closed listener")
+	}
+}
+
+func TestCORSOrigins(t *testing.T) {
+	t.Setenv("OPENMESSAGES_CORS_ORIGINS", "")
+	if got := CORSOrigins(); got != nil {
+		t.Errorf("unset: got %v, want none", got)
+	}
+	t.Setenv("OPENMESSAGES_CORS_ORIGINS", " https://a.example , ,https://b.example")
+	got := CORSOrigins()
+	if len(got) != 2 || got[0] != "https://a.example" || got[1] != "https://b.example" {
+		t.Errorf("got %v", got)
+	}
+}
@@ -4,7 +4,10 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
+	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/rs/zerolog"
 
@@ -21,7 +24,65 @@ type App struct {
 	Logger       zerolog.Logger
 	DataDir      string
 	SessionPath  string
+	PairingPath  string
 	Connected    atomic.Bool
+
+	syncRetrier *client.SyncRetrier
+
+	// backfilling is set while Backfill or a deep backfill runs.
+	backfilling atomic.Bool
+
+	// background tracks work started with Go, which Close waits for.
+	background sync.WaitGroup
+
+	// convBackfills holds the latest StartBackfillConversation run for each
+	// conversation.
+	convBackfillMu sync.Mutex
+	convBackfills  map[string]*conversationBackfill
+
+	// Typing holds live typing indicators for the web UI.
+	Typing *client.TypingTracker
+
+	// Deliveries lets senders wait for a sent message to be delivered.
+	Deliveries *client.DeliveryWaiter
+
+	// Events carries store updates to /api/events subscribers.
+	Events *Broker
+
+	// SyncGate pauses inbound event processing and backfill.
+	SyncGate *client.SyncGate
+
+	// StoreRawPayloads keeps the JSON proto of messages with no parseable
+	// text or media (OPENMESSAGES_STORE_RAW=1).
+	StoreRawPayloads bool
+
+	// AutoContacts creates contacts for unknown inbound senders
+	// (OPENMESSAGES_AUTO_CONTACTS=1).
+	AutoContacts bool
+
+	// SendReadReceipts tells the phone when a conversation is marked read
+	// on open (OPENMESSAGES_SEND_READ_RECEIPTS=1).
+	SendReadReceipts bool
+
+	// BackfillDelay is the pause between Google requests during deep
+	// backfill, so a large account doesn't get throttled
+	// (OPENMESSAGES_BACKFILL_DELAY_MS).
+	BackfillDelay time.Duration
+
+	// SearchLimit is the default number of search results
+	// (OPENMESSAGES_SEARCH_LIMIT). Zero leaves each caller's own default.
+	SearchLimit int
+
+	// RetentionDays is how long messages are kept before the retention
+	// sweeper prunes them (OPENMESSAGES_RETENTION_DAYS). Zero keeps all.
+	RetentionDays int
+
+	// RetentionKeepMedia exempts messages with attachments from pruning
+	// (OPENMESSAGES_RETENTION_KEEP_MEDIA=1).
+	RetentionKeepMedia bool
+
+	// done is closed by Close to stop background loops.
+	done chan struct{}
 }
 
 func DefaultDataDir() string {
@@ -32,6 +93,34 @@ func DefaultDataDir() string {
 	return filepath.Join(home, ".local", "share", "openmessage")
 }
 
+// searchLimit reads OPENMESSAGES_SEARCH_LIMIT, returning 0 when unset or
+// invalid.
+func searchLimit() int {
+	n, err := strconv.Atoi(os.Getenv("OPENMESSAGES_SEARCH_LIMIT"))
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+// defaultBackfillDelay keeps deep backfill to about five requests a second.
+const defaultBackfillDelay = 200 * time.Millisecond
+
+// backfillDelay reads OPENMESSAGES_BACKFILL_DELAY_MS, falling back to
+// defaultBackfillDelay when unset or invalid. Zero disables the delay.
+func backfillDelay() time.Duration {
+	ms, err := strconv.Atoi(os.Getenv("OPENMESSAGES_BACKFILL_DELAY_MS"))
+	if err != nil || ms < 0 {
+		return defaultBackfillDelay
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+// PairingPath is where non-secret pairing metadata is stored in dataDir.
+func PairingPath(dataDir string) string {
+	return filepath.Join(dataDir, "pairing.json")
+}
+
 func New(logger zerolog.Logger) (*App, error) {
 	dataDir := DefaultDataDir()
 	if err := os.MkdirAll(dataDir, 0700); err != nil {
@@ -48,11 +137,17 @@ func New(logger zerolog.Logger) (*App, error) {
 		dbPath = filepath.Join(tmpDir, "demo.db")
 	}
 
-	store, err := db.New(dbPath)
+	store, err := db.NewWithRegion(dbPath, os.Getenv("OPENMESSAGES_DEFAULT_REGION"))
 	if err != nil {
 		return nil, fmt.Errorf("open db: %w", err)
 	}
 
+	if n, err := store.FixFutureTimestamps(time.Now()); err != nil {
+		logger.Warn().Err(err).Msg("Failed to fix future message timestamps")
+	} else if n > 0 {
+		logger.Info().Int64("messages", n).Msg("Clamped far-future message timestamps")
+	}
+
 	// Seed demo data
 	if os.Getenv("OPENMESSAGES_DEMO") != "" {
 		if err := store.SeedDemo(); err != nil {
@@ -71,11 +166,27 @@ func New(logger zerolog.Logger) (*App, error) {
 	sessionPath := filepath.Join(dataDir, "session.json")
 
 	app := &App{
-		Store:       store,
-		Supabase:    sb,
-		Logger:      logger,
-		DataDir:     dataDir,
-		SessionPath: sessionPath,
+		Store:              store,
+		Supabase:           sb,
+		Logger:             logger,
+		DataDir:            dataDir,
+		SessionPath:        sessionPath,
+		PairingPath:        PairingPath(dataDir),
+		StoreRawPayloads:   os.Getenv("OPENMESSAGES_STORE_RAW") == "1",
+		AutoContacts:       os.Getenv("OPENMESSAGES_AUTO_CONTACTS") == "1",
+		SendReadReceipts:   os.Getenv("OPENMESSAGES_SEND_READ_RECEIPTS") == "1",
+		SearchLimit:        searchLimit(),
+		BackfillDelay:      backfillDelay(),
+		RetentionDays:      retentionDays(),
+		RetentionKeepMedia: os.Getenv("OPENMESSAGES_RETENTION_KEEP_MEDIA") == "1",
+		done:               make(chan struct{}),
+		Typing:             client.NewTypingTracker(client.TypingTTL),
+		Deliveries:         client.NewDeliveryWaiter(),
+		Events:             NewBroker(),
+		SyncGate:           &client.SyncGate{},
+	}
+	if sb != nil {
+		app.syncRetrier = &client.SyncRetrier{Store: store, Supabase: sb}
 	}
 	return app, nil
 }
@@ -94,14 +205,24 @@ func (a *App) LoadAndConnect() error {
 
 	a.EventHandler = &client.EventHandler{
 		Store:       a.Store,
-		Supabase:    a.Supabase,
 		Logger:      a.Logger,
 		SessionPath: a.SessionPath,
+		PairingPath: a.PairingPath,
 		Client:      cli,
 		OnDisconnect: func() {
 			a.Connected.Store(false)
 			a.Logger.Warn().Msg("Disconnected from Google Messages")
 		},
+		StoreRawPayloads: a.StoreRawPayloads,
+		AutoContacts:     a.AutoContacts,
+		Typing:           a.Typing,
+		Deliveries:       a.Deliveries,
+		Publish:          a.Events.Publish,
+		Gate:             a.SyncGate,
+	}
+	if a.Supabase != nil {
+		// Only assign when set: a nil *Writer in the interface is non-nil.
+		a.EventHandler.Supabase = a.Supabase
 	}
 	cli.GM.SetEventHandler(a.EventHandler.Handle)
 
@@ -127,7 +248,63 @@ func (a *App) Unpair() error {
 	return nil
 }
 
+// RetrySync immediately re-sends pending and failed Supabase upserts.
+func (a *App) RetrySync() (succeeded, failed int, err error) {
+	if a.syncRetrier == nil {
+		return 0, 0, fmt.Errorf("supabase sync not configured")
+	}
+	return a.syncRetrier.Retry()
+}
+
+// PauseSync stops processing inbound messages and conversations, buffering
+// them for ResumeSync when buffer is set and dropping them otherwise.
+// Backfill is refused while paused.
+func (a *App) PauseSync(buffer bool) {
+	a.SyncGate.Pause(buffer)
+	a.Logger.Info().Bool("buffer", buffer).Msg("Sync paused")
+}
+
+// ResumeSync handles any buffered events and resumes live processing.
+func (a *App) ResumeSync() int {
+	var n int
+	if a.EventHandler != nil {
+		n = a.EventHandler.ResumeSync()
+	} else {
+		n = a.SyncGate.Resume(func(any) {})
+	}
+	a.Logger.Info().Int("replayed", n).Msg("Sync resumed")
+	return n
+}
+
+// SyncState reports whether sync is paused and how many events are held.
+func (a *App) SyncState() client.SyncGateState {
+	return a.SyncGate.State()
+}
+
+// SupabaseSync returns the Supabase writer as a client.SupabaseSync, or nil
+// when sync is disabled. A nil *supabase.Writer in the interface is non-nil.
+func (a *App) SupabaseSync() client.SupabaseSync {
+	if a.Supabase == nil {
+		return nil
+	}
+	return a.Supabase
+}
+
+// Go runs fn in the background. Close waits for it to return before
+// disconnecting and closing the store.
+func (a *App) Go(fn func()) {
+	a.background.Add(1)
+	go func() {
+		defer a.background.Done()
+		fn()
+	}()
+}
+
 func (a *App) Close() {
+	if a.done != nil {
+		close(a.done)
+	}
+	a.background.Wait()
 	if a.Client != nil {
 		a.Client.GM.Disconnect()
 	}
@@ -1,23 +1,31 @@
 package app
 
 import (
-	"encoding/hex"
-	"encoding/json"
 	"fmt"
+	"sync"
 	"time"
 
+	"github.com/rs/zerolog"
 	"go.mau.fi/mautrix-gmessages/pkg/libgm/gmproto"
 
 	"github.com/maxghenis/openmessage/internal/client"
 	"github.com/maxghenis/openmessage/internal/db"
+	"github.com/maxghenis/openmessage/internal/supabase"
 )
 
 // Backfill fetches existing conversations and recent messages from
 // Google Messages and stores them in the local database.
 func (a *App) Backfill() error {
 	if a.Client == nil {
-		return fmt.Errorf("client not connected")
+		return client.ErrNotConnected
 	}
+	if a.SyncGate != nil && a.SyncGate.Paused() {
+		return client.ErrSyncPaused
+	}
+	if !a.backfilling.CompareAndSwap(false, true) {
+		return client.ErrBackfillRunning
+	}
+	defer a.backfilling.Store(false)
 
 	a.Logger.Info().Msg("Starting backfill of conversations and messages")
 
@@ -29,6 +37,7 @@ func (a *App) Backfill() error {
 	convos := resp.GetConversations()
 	a.Logger.Info().Int("count", len(convos)).Msg("Fetched conversations")
 
+	batch := a.newMessageBatch()
 	for _, conv := range convos {
 		if err := a.storeConversation(conv); err != nil {
 			a.Logger.Error().Err(err).Str("conv_id", conv.GetConversationID()).Msg("Failed to store conversation")
@@ -43,219 +52,422 @@ func (a *App) Backfill() error {
 		}
 
 		for _, msg := range msgResp.GetMessages() {
-			a.storeMessage(msg)
+			a.storeMessage(msg, batch)
 		}
+		batch.flush()
 	}
 
 	a.Logger.Info().Int("conversations", len(convos)).Msg("Backfill complete")
+
+	if a.Supabase != nil {
+		pulled, pushed, err := client.ReconcileStarred(a.Store, a.Supabase)
+		if err != nil {
+			a.Logger.Warn().Err(err).Msg("Failed to reconcile starred messages with Supabase")
+		} else if pulled > 0 || pushed > 0 {
+			a.Logger.Info().Int("pulled", pulled).Int("pushed", pushed).Msg("Reconciled starred messages")
+		}
+		pulled, pushed, err = client.ReconcileReadPositions(a.Store, a.Supabase)
+		if err != nil {
+			a.Logger.Warn().Err(err).Msg("Failed to reconcile read positions with Supabase")
+		} else if pulled > 0 || pushed > 0 {
+			a.Logger.Info().Int("pulled", pulled).Int("pushed", pushed).Msg("Reconciled read positions")
+		}
+	}
 	return nil
 }
 
-// DeepBackfill fetches ALL conversations and ALL messages with pagination.
-// Runs in the background and logs progress.
-func (a *App) DeepBackfill() {
+// historySource is the subset of *libgm.Client used by deep backfill.
+type historySource interface {
+	ListConversations(count int, folder gmproto.ListConversationsRequest_Folder) (*gmproto.ListConversationsResponse, error)
+	client.MessageFetcher
+}
+
+// StartDeepBackfill fetches ALL conversations and ALL messages with
+// pagination in the background, logging progress. Progress is saved per
+// conversation, so a later run resumes where this one stopped and skips
+// conversations whose history is already complete unless force is set.
+// Requests to Google are spaced by BackfillDelay. It returns
+// client.ErrBackfillRunning while another backfill runs.
+func (a *App) StartDeepBackfill(force bool) error {
 	if a.Client == nil {
-		a.Logger.Error().Msg("Deep backfill: client not connected")
-		return
+		return client.ErrNotConnected
+	}
+	if a.SyncGate != nil && a.SyncGate.Paused() {
+		return client.ErrSyncPaused
 	}
+	return a.startDeepBackfill(a.Client.GM, force)
+}
 
+// startDeepBackfill claims the backfill slot and runs deepBackfill with gm in
+// the background.
+func (a *App) startDeepBackfill(gm historySource, force bool) error {
+	if !a.backfilling.CompareAndSwap(false, true) {
+		return client.ErrBackfillRunning
+	}
+	go func() {
+		defer a.backfilling.Store(false)
+		a.deepBackfill(gm, force)
+	}()
+	return nil
+}
+
+// deepBackfill backfills every listed conversation. With force, completed
+// conversations are fetched again from the newest message.
+func (a *App) deepBackfill(gm historySource, force bool) {
 	a.Logger.Info().Msg("Starting deep backfill of all messages")
 
+	// Only the first page of conversations is fetched. libgm's
+	// ListConversations builds the request itself and takes no cursor, so
+	// resp.GetCursor() can't be passed back to ask for the next page.
+	resp, err := gm.ListConversations(100, gmproto.ListConversationsRequest_INBOX)
+	if err != nil {
+		a.Logger.Error().Err(err).Msg("Deep backfill: list conversations failed")
+		return
+	}
+	convos := resp.GetConversations()
+
 	totalConvos := 0
 	totalMsgs := 0
+	skipped := 0
+	for i, conv := range convos {
+		convID := conv.GetConversationID()
+		if err := a.storeConversation(conv); err != nil {
+			a.Logger.Error().Err(err).Str("conv_id", convID).Msg("Deep backfill: store conversation failed")
+			continue
+		}
+		totalConvos++
 
-	// Paginate through all conversations
-	var cursor *gmproto.Cursor
-	for {
-		resp, err := a.Client.GM.ListConversations(100, gmproto.ListConversationsRequest_INBOX)
+		st, err := a.Store.GetBackfillState(convID)
 		if err != nil {
-			a.Logger.Error().Err(err).Msg("Deep backfill: list conversations failed")
-			break
+			a.Logger.Warn().Err(err).Str("conv_id", convID).Msg("Deep backfill: get state failed")
 		}
-
-		convos := resp.GetConversations()
-		if len(convos) == 0 {
-			break
+		if force {
+			st = nil
 		}
-
-		for _, conv := range convos {
-			if err := a.storeConversation(conv); err != nil {
-				a.Logger.Error().Err(err).Str("conv_id", conv.GetConversationID()).Msg("Deep backfill: store conversation failed")
-				continue
+		if st != nil && st.Complete {
+			skipped++
+		} else {
+			if i > 0 {
+				a.backfillPause()
 			}
-			totalConvos++
-
-			// Paginate through all messages in this conversation
-			n := a.deepBackfillConversation(conv.GetConversationID())
-			totalMsgs += n
+			totalMsgs += a.deepBackfillConversation(gm, convID, st)
 		}
 
-		cursor = resp.GetCursor()
-		if cursor == nil {
-			break
-		}
-		// ListConversations doesn't take a cursor param in the current API,
-		// so we only get the first page. Break after first batch.
-		break
+		a.Logger.Info().
+			Int("done", i+1).
+			Int("total", len(convos)).
+			Int("percent", (i+1)*100/len(convos)).
+			Msg("Deep backfill: progress")
 	}
 
 	a.Logger.Info().
 		Int("conversations", totalConvos).
+		Int("skipped", skipped).
 		Int("messages", totalMsgs).
 		Msg("Deep backfill complete")
 }
 
-// deepBackfillConversation fetches all messages in a conversation using cursor pagination.
-func (a *App) deepBackfillConversation(convID string) int {
+// deepBackfillConversation fetches all messages in a conversation using
+// cursor pagination, starting from st when a previous run left off there.
+func (a *App) deepBackfillConversation(gm client.MessageFetcher, convID string, st *db.BackfillState) int {
+	if st == nil {
+		st = &db.BackfillState{ConversationID: convID}
+	}
+	total, err := a.backfillPages(gm, st, 0, nil)
+	if err != nil {
+		a.Logger.Warn().Err(err).Str("conv_id", convID).Msg("Deep backfill: conversation incomplete")
+	}
+	if total > 0 {
+		a.Logger.Info().
+			Str("conv_id", convID).
+			Int("messages", total).
+			Msg("Deep backfill: conversation complete")
+	}
+	return total
+}
+
+// BackfillConversation fetches the history of one conversation from
+// Google, newest first, and stores it. It stops after maxMessages (0 for
+// no limit) or when the history runs out, and returns how many messages
+// were stored; on error that is the count stored before it. A conversation
+// whose whole history was already fetched is skipped unless force is set,
+// and an unfinished earlier run is resumed; force starts over from the
+// newest message.
+func (a *App) BackfillConversation(convID string, maxMessages int, force bool) (int, error) {
+	if a.Client == nil {
+		return 0, client.ErrNotConnected
+	}
+	if a.SyncGate != nil && a.SyncGate.Paused() {
+		return 0, client.ErrSyncPaused
+	}
+	if !a.backfilling.CompareAndSwap(false, true) {
+		return 0, client.ErrBackfillRunning
+	}
+	defer a.backfilling.Store(false)
+	return a.backfillConversation(a.Client.GM, convID, maxMessages, force, nil)
+}
+
+// StartBackfillConversation runs BackfillConversation in the background,
+// logging how many messages it stored. ConversationBackfillProgress reports
+// the count as it goes. It returns client.ErrBackfillRunning while another
+// backfill runs.
+func (a *App) StartBackfillConversation(convID string, maxMessages int, force bool) error {
+	if a.Client == nil {
+		return client.ErrNotConnected
+	}
+	if a.SyncGate != nil && a.SyncGate.Paused() {
+		return client.ErrSyncPaused
+	}
+	if !a.backfilling.CompareAndSwap(false, true) {
+		return client.ErrBackfillRunning
+	}
+	gm := a.Client.GM
+	run := a.trackConversationBackfill(convID)
+	go func() {
+		defer a.backfilling.Store(false)
+		n, err := a.backfillConversation(gm, convID, maxMessages, force, run.progress)
+		run.finish(n)
+		if err != nil {
+			a.Logger.Warn().Err(err).Str("conv_id", convID).Int("messages", n).Msg("Backfill: conversation incomplete")
+			return
+		}
+		a.Logger.Info().Str("conv_id", convID).Int("messages", n).Msg("Backfill: conversation done")
+	}()
+	return nil
+}
+
+// ConversationBackfillProgress reports how many messages the latest
+// StartBackfillConversation run for convID has stored, and whether it is
+// still running. Both are zero if none was started since launch.
+func (a *App) ConversationBackfillProgress(convID string) (stored int, running bool) {
+	a.convBackfillMu.Lock()
+	run := a.convBackfills[convID]
+	a.convBackfillMu.Unlock()
+	if run == nil {
+		return 0, false
+	}
+	return run.get()
+}
+
+// trackConversationBackfill records a new run for convID, replacing the
+// previous one.
+func (a *App) trackConversationBackfill(convID string) *conversationBackfill {
+	run := &conversationBackfill{running: true}
+	a.convBackfillMu.Lock()
+	defer a.convBackfillMu.Unlock()
+	if a.convBackfills == nil {
+		a.convBackfills = make(map[string]*conversationBackfill)
+	}
+	a.convBackfills[convID] = run
+	return run
+}
+
+// conversationBackfill is the progress of one background conversation
+// backfill.
+type conversationBackfill struct {
+	mu      sync.Mutex
+	stored  int
+	running bool
+}
+
+func (r *conversationBackfill) progress(stored int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stored = stored
+}
+
+func (r *conversationBackfill) finish(stored int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stored, r.running = stored, false
+}
+
+func (r *conversationBackfill) get() (int, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.stored, r.running
+}
+
+// backfillConversation is BackfillConversation with gm; progress, if set,
+// is called with the running count after each page.
+func (a *App) backfillConversation(gm client.MessageFetcher, convID string, maxMessages int, force bool, progress func(stored int)) (int, error) {
+	st, err := a.Store.GetBackfillState(convID)
+	if err != nil {
+		return 0, fmt.Errorf("get backfill state: %w", err)
+	}
+	if force || st == nil {
+		st = &db.BackfillState{ConversationID: convID}
+	} else if st.Complete {
+		return 0, nil
+	}
+	return a.backfillPages(gm, st, maxMessages, progress)
+}
+
+// backfillPageSize is how many messages each FetchMessages call asks for.
+const backfillPageSize = 50
+
+// backfillPages pages back through st's conversation from st's cursor (or
+// the newest message), storing each page and saving st after it. It stops
+// once maxMessages are stored (0 for no limit), the server returns no
+// cursor, or a fetch fails, and returns how many messages were stored.
+// progress, if set, is called with that count after each page.
+func (a *App) backfillPages(gm client.MessageFetcher, st *db.BackfillState, maxMessages int, progress func(stored int)) (int, error) {
+	convID := st.ConversationID
 	total := 0
 	var cursor *gmproto.Cursor
+	if st.LastItemID != "" {
+		cursor = &gmproto.Cursor{LastItemID: st.LastItemID, LastItemTimestamp: st.LastItemTS}
+	}
+	batch := a.newMessageBatch()
+	defer batch.flush()
 
 	for {
-		resp, err := a.Client.GM.FetchMessages(convID, 50, cursor)
+		count := backfillPageSize
+		if maxMessages > 0 && maxMessages-total < count {
+			count = maxMessages - total
+		}
+		resp, err := gm.FetchMessages(convID, int64(count), cursor)
 		if err != nil {
-			a.Logger.Warn().Err(err).Str("conv_id", convID).Msg("Deep backfill: fetch messages failed")
-			break
+			return total, fmt.Errorf("fetch messages: %w", err)
 		}
 
 		msgs := resp.GetMessages()
-		if len(msgs) == 0 {
-			break
-		}
-
 		for _, msg := range msgs {
-			a.storeMessage(msg)
+			a.storeMessage(msg, batch)
+			if ts := msg.GetTimestamp() / 1000; st.OldestTS == 0 || ts < st.OldestTS {
+				st.OldestTS = ts
+			}
 			total++
 		}
 
 		cursor = resp.GetCursor()
-		if cursor == nil {
-			break
+		if len(msgs) == 0 || cursor == nil {
+			st.Complete = true
+		} else {
+			st.LastItemID, st.LastItemTS = cursor.GetLastItemID(), cursor.GetLastItemTimestamp()
+		}
+		if err := a.Store.SaveBackfillState(st); err != nil {
+			a.Logger.Warn().Err(err).Str("conv_id", convID).Msg("Backfill: save state failed")
+		}
+		if progress != nil {
+			progress(total)
+		}
+		if st.Complete || (maxMessages > 0 && total >= maxMessages) {
+			return total, nil
 		}
 
 		a.Logger.Debug().
 			Str("conv_id", convID).
 			Int("batch", len(msgs)).
 			Int("total_so_far", total).
-			Msg("Deep backfill: fetched message batch")
+			Msg("Backfill: fetched message batch")
+		a.backfillPause()
 	}
+}
 
-	if total > 0 {
-		a.Logger.Info().
-			Str("conv_id", convID).
-			Int("messages", total).
-			Msg("Deep backfill: conversation complete")
+// backfillPause waits BackfillDelay between deep backfill requests.
+func (a *App) backfillPause() {
+	if a.BackfillDelay > 0 {
+		time.Sleep(a.BackfillDelay)
 	}
-
-	return total
 }
 
 func (a *App) storeConversation(conv *gmproto.Conversation) error {
-	participantsJSON := "[]"
-	if ps := conv.GetParticipants(); len(ps) > 0 {
-		type pInfo struct {
-			Name   string `json:"name"`
-			Number string `json:"number"`
-			IsMe   bool   `json:"is_me,omitempty"`
-		}
-		var infos []pInfo
-		for _, p := range ps {
-			info := pInfo{
-				Name: p.GetFullName(),
-				IsMe: p.GetIsMe(),
-			}
-			if id := p.GetID(); id != nil {
-				info.Number = id.GetNumber()
-			}
-			if info.Number == "" {
-				info.Number = p.GetFormattedNumber()
-			}
-			infos = append(infos, info)
-		}
-		if b, err := json.Marshal(infos); err == nil {
-			participantsJSON = string(b)
-		}
-	}
-
-	unread := 0
-	if conv.GetUnread() {
-		unread = 1
-	}
-
-	if err := a.Store.UpsertConversation(&db.Conversation{
-		ConversationID: conv.GetConversationID(),
-		Name:           conv.GetName(),
-		IsGroup:        conv.GetIsGroupChat(),
-		Participants:   participantsJSON,
-		LastMessageTS:  conv.GetLastMessageTimestamp() / 1000,
-		UnreadCount:    unread,
-	}); err != nil {
+	dbConv := client.ConversationToDB(conv, a.Store)
+	if err := a.Store.UpsertConversation(dbConv); err != nil {
 		return err
 	}
 
+	// Upserted inline rather than queued: the conversation's message batches
+	// follow on this goroutine and need its row in place first.
 	if a.Supabase != nil {
-		ts := time.UnixMilli(conv.GetLastMessageTimestamp() / 1000)
-		go func() {
-			if err := a.Supabase.UpsertConversation(
-				conv.GetConversationID(), conv.GetName(),
-				ts, conv.GetIsGroupChat(), "",
-			); err != nil {
-				a.Logger.Warn().Err(err).Msg("Supabase backfill conversation sync failed")
-			}
-		}()
+		a.Store.MarkSyncPending(db.SyncKindConversation, dbConv.ConversationID)
+		if err := client.SyncConversation(a.Store, a.Supabase, dbConv); err != nil {
+			a.Logger.Warn().Err(err).Msg("Supabase backfill conversation sync failed")
+		}
 	}
 
 	return nil
 }
 
-func (a *App) storeMessage(msg *gmproto.Message) {
-	body := client.ExtractMessageBody(msg)
-	senderName, senderNumber := client.ExtractSenderInfo(msg)
-
-	status := "unknown"
-	if ms := msg.GetMessageStatus(); ms != nil {
-		status = ms.GetStatus().String()
+// storeMessage stores a backfilled message and queues it in batch for
+// Supabase. batch may be nil when sync is disabled. Messages past the
+// retention window are skipped.
+func (a *App) storeMessage(msg *gmproto.Message, batch *messageBatch) {
+	dbMsg := client.MessageToDB(msg, a.StoreRawPayloads)
+	if a.pastRetention(dbMsg, time.Now()) {
+		return
 	}
 
-	dbMsg := &db.Message{
-		MessageID:      msg.GetMessageID(),
-		ConversationID: msg.GetConversationID(),
-		SenderName:     senderName,
-		SenderNumber:   senderNumber,
-		Body:           body,
-		TimestampMS:    msg.GetTimestamp() / 1000,
-		Status:         status,
-		IsFromMe:       msg.GetSenderParticipant() != nil && msg.GetSenderParticipant().GetIsMe(),
+	if err := a.Store.UpsertMessage(dbMsg); err != nil {
+		a.Logger.Error().Err(err).Str("msg_id", dbMsg.MessageID).Msg("Failed to store backfill message")
+		return
 	}
+	batch.add(dbMsg)
+}
 
-	if media := client.ExtractMediaInfo(msg); media != nil {
-		dbMsg.MediaID = media.MediaID
-		dbMsg.MimeType = media.MimeType
-		dbMsg.DecryptionKey = hex.EncodeToString(media.DecryptionKey)
-	}
+// messageBatchSize is how many backfilled messages go to Supabase per call.
+const messageBatchSize = 100
 
-	if reactions := client.ExtractReactions(msg); reactions != nil {
-		if b, err := json.Marshal(reactions); err == nil {
-			dbMsg.Reactions = string(b)
-		}
+// messageBatchWriter is the subset of *supabase.Writer used by messageBatch.
+type messageBatchWriter interface {
+	UpsertMessagesBatch(rows []supabase.MessageRow) error
+}
+
+// messageBatch collects backfilled messages and upserts them to Supabase
+// messageBatchSize at a time, instead of one call per message. The live
+// event path still syncs messages one by one. A nil *messageBatch is a no-op.
+type messageBatch struct {
+	store  *db.Store
+	sb     messageBatchWriter
+	logger zerolog.Logger
+	rows   []supabase.MessageRow
+}
+
+// newMessageBatch returns a batch for backfilling, or nil when Supabase sync
+// is disabled.
+func (a *App) newMessageBatch() *messageBatch {
+	if a.Supabase == nil {
+		return nil
 	}
-	dbMsg.ReplyToID = client.ExtractReplyToID(msg)
+	return &messageBatch{store: a.Store, sb: a.Supabase, logger: a.Logger}
+}
 
-	if err := a.Store.UpsertMessage(dbMsg); err != nil {
-		a.Logger.Error().Err(err).Str("msg_id", dbMsg.MessageID).Msg("Failed to store backfill message")
+// add queues m, flushing once the batch is full.
+func (b *messageBatch) add(m *db.Message) {
+	if b == nil {
 		return
 	}
+	b.store.MarkSyncPending(db.SyncKindMessage, m.MessageID)
+	b.rows = append(b.rows, supabase.MessageRow{
+		ID:             m.MessageID,
+		ConversationID: m.ConversationID,
+		SenderName:     m.SenderName,
+		SenderNumber:   m.SenderNumber,
+		Content:        m.Body,
+		Timestamp:      time.UnixMilli(m.TimestampMS),
+		IsFromMe:       m.IsFromMe,
+		MediaType:      m.MimeType,
+		Reactions:      supabase.ReactionsJSON(m.Reactions),
+		ReplyToID:      m.ReplyToID,
+	})
+	if len(b.rows) >= messageBatchSize {
+		b.flush()
+	}
+}
 
-	if a.Supabase != nil {
-		ts := time.UnixMilli(dbMsg.TimestampMS)
-		go func() {
-			if err := a.Supabase.UpsertMessage(
-				dbMsg.MessageID, dbMsg.ConversationID,
-				dbMsg.SenderName, dbMsg.SenderNumber,
-				dbMsg.Body, ts, dbMsg.IsFromMe,
-				dbMsg.MimeType, "",
-			); err != nil {
-				a.Logger.Warn().Err(err).Msg("Supabase backfill message sync failed")
-			}
-		}()
+// flush upserts the queued messages and records the outcome for each in the
+// sync state, so a failed batch is picked up by the sync retrier.
+func (b *messageBatch) flush() {
+	if b == nil || len(b.rows) == 0 {
+		return
+	}
+	err := b.sb.UpsertMessagesBatch(b.rows)
+	for _, r := range b.rows {
+		b.store.MarkSyncResult(db.SyncKindMessage, r.ID, err)
+	}
+	if err != nil {
+		b.logger.Warn().Err(err).Int("messages", len(b.rows)).Msg("Supabase backfill message batch failed")
 	}
+	b.rows = b.rows[:0]
 }
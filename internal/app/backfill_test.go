@@ -1,11 +1,17 @@
 package app
 
 import (
+	"errors"
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/rs/zerolog"
+	"go.mau.fi/mautrix-gmessages/pkg/libgm/gmproto"
 
+	"github.com/maxghenis/openmessage/internal/client"
 	"github.com/maxghenis/openmessage/internal/db"
+	"github.com/maxghenis/openmessage/internal/supabase"
 )
 
 func TestBackfillStoresConversationsAndMessages(t *testing.T) {
@@ -73,3 +79,276 @@ func TestBackfillPopulatesDB(t *testing.T) {
 		t.Fatalf("got body %q", msgs[0].Body)
 	}
 }
+
+type fakeBatchWriter struct {
+	sizes []int
+	err   error
+}
+
+func (f *fakeBatchWriter) UpsertMessagesBatch(rows []supabase.MessageRow) error {
+	f.sizes = append(f.sizes, len(rows))
+	return f.err
+}
+
+func TestMessageBatchFlushesFullAndPartialBatches(t *testing.T) {
+	store, err := db.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	sb := &fakeBatchWriter{}
+	b := &messageBatch{store: store, sb: sb, logger: zerolog.Nop()}
+	for i := 0; i < 250; i++ {
+		b.add(&db.Message{MessageID: fmt.Sprintf("m%d", i), ConversationID: "c1", Body: "hi"})
+	}
+	if len(sb.sizes) != 2 || sb.sizes[0] != 100 || sb.sizes[1] != 100 {
+		t.Fatalf("batches before flush = %v, want [100 100]", sb.sizes)
+	}
+	if it, _ := store.GetSyncItem(db.SyncKindMessage, "m249"); it == nil || it.Status != db.SyncPending {
+		t.Errorf("queued message sync state = %+v, want pending", it)
+	}
+
+	// The end of a conversation flushes what's left.
+	b.flush()
+	if len(sb.sizes) != 3 || sb.sizes[2] != 50 {
+		t.Fatalf("batches = %v, want a final batch of 50", sb.sizes)
+	}
+	if it, _ := store.GetSyncItem(db.SyncKindMessage, "m249"); it != nil {
+		t.Errorf("flushed message sync state = %+v, want none once synced", it)
+	}
+
+	sb.err = errors.New("supabase unavailable")
+	b.add(&db.Message{MessageID: "late", ConversationID: "c1", Body: "hi"})
+	b.flush()
+	if it, _ := store.GetSyncItem(db.SyncKindMessage, "late"); it == nil || it.Status != db.SyncFailed {
+		t.Errorf("failed batch sync state = %+v, want failed", it)
+	}
+
+	var none *messageBatch
+	none.add(&db.Message{MessageID: "x"})
+	none.flush()
+}
+
+// fakeHistory serves two pages of messages per conversation. Fetches for a
+// conversation in failAfterFirst fail after its first page.
+type fakeHistory struct {
+	convs          []string
+	failAfterFirst map[string]bool
+	fetches        []string      // "convID@cursor" per FetchMessages call
+	block          chan struct{} // if set, ListConversations waits for it
+}
+
+func (f *fakeHistory) ListConversations(count int, folder gmproto.ListConversationsRequest_Folder) (*gmproto.ListConversationsResponse, error) {
+	if f.block != nil {
+		<-f.block
+	}
+	resp := &gmproto.ListConversationsResponse{}
+	for _, id := range f.convs {
+		resp.Conversations = append(resp.Conversations, &gmproto.Conversation{ConversationID: id, Name: id})
+	}
+	return resp, nil
+}
+
+func (f *fakeHistory) FetchMessages(convID string, count int64, cursor *gmproto.Cursor) (*gmproto.ListMessagesResponse, error) {
+	f.fetches = append(f.fetches, convID+"@"+cursor.GetLastItemID())
+	if cursor == nil {
+		return &gmproto.ListMessagesResponse{
+			Messages: []*gmproto.Message{{MessageID: convID + "-new", ConversationID: convID, Timestamp: 2000000}},
+			Cursor:   &gmproto.Cursor{LastItemID: convID + "-new", LastItemTimestamp: 2000000},
+		}, nil
+	}
+	if f.failAfterFirst[convID] {
+		return nil, errors.New("phone offline")
+	}
+	return &gmproto.ListMessagesResponse{
+		Messages: []*gmproto.Message{{MessageID: convID + "-old", ConversationID: convID, Timestamp: 1000000}},
+	}, nil
+}
+
+func TestDeepBackfillResumesAndSkipsCompleted(t *testing.T) {
+	store, err := db.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	a := &App{Store: store, Logger: zerolog.Nop()}
+
+	gm := &fakeHistory{convs: []string{"c1", "c2"}, failAfterFirst: map[string]bool{"c2": true}}
+	a.deepBackfill(gm, false)
+
+	if st, _ := store.GetBackfillState("c1"); st == nil || !st.Complete || st.OldestTS != 1000 {
+		t.Fatalf("c1 state after first run = %+v, want complete with oldest 1000", st)
+	}
+	if st, _ := store.GetBackfillState("c2"); st == nil || st.Complete || st.LastItemID != "c2-new" {
+		t.Fatalf("c2 state after first run = %+v, want cursor at c2-new", st)
+	}
+
+	gm.fetches = nil
+	gm.failAfterFirst = nil
+	a.deepBackfill(gm, false)
+
+	// c1 is skipped entirely; c2 picks up from its saved cursor.
+	if fmt.Sprint(gm.fetches) != "[c2@c2-new]" {
+		t.Errorf("second run fetches = %v, want [c2@c2-new]", gm.fetches)
+	}
+	if st, _ := store.GetBackfillState("c2"); st == nil || !st.Complete || st.OldestTS != 1000 {
+		t.Errorf("c2 state after second run = %+v, want complete with oldest 1000", st)
+	}
+	if msgs, _ := store.GetMessagesByConversation("c2", 10); len(msgs) != 2 {
+		t.Errorf("c2 has %d messages, want 2", len(msgs))
+	}
+}
+
+func TestDeepBackfillRejectsConcurrentRuns(t *testing.T) {
+	store, err := db.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	a := &App{Store: store, Logger: zerolog.Nop()}
+
+	gm := &fakeHistory{convs: []string{"c1"}, block: make(chan struct{})}
+	if err := a.startDeepBackfill(gm, false); err != nil {
+		t.Fatalf("first start: %v", err)
+	}
+	if err := a.startDeepBackfill(gm, false); !errors.Is(err, client.ErrBackfillRunning) {
+		t.Errorf("second start: err = %v, want ErrBackfillRunning", err)
+	}
+
+	close(gm.block)
+	for a.backfilling.Load() {
+		time.Sleep(time.Millisecond)
+	}
+	if err := a.startDeepBackfill(gm, false); err != nil {
+		t.Errorf("start after the first finished: %v", err)
+	}
+	for a.backfilling.Load() {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// countingFetcher returns pages of two messages, with a cursor only while
+// pages remain.
+type countingFetcher struct {
+	pages int
+	calls int
+}
+
+func (f *countingFetcher) FetchMessages(convID string, count int64, cursor *gmproto.Cursor) (*gmproto.ListMessagesResponse, error) {
+	f.calls++
+	resp := &gmproto.ListMessagesResponse{}
+	for i := 0; i < 2; i++ {
+		id := fmt.Sprintf("%s-%d-%d", convID, f.calls, i)
+		resp.Messages = append(resp.Messages, &gmproto.Message{MessageID: id, ConversationID: convID, Timestamp: int64(1000000 - f.calls*1000 - i)})
+	}
+	if f.calls < f.pages {
+		resp.Cursor = &gmproto.Cursor{LastItemID: fmt.Sprintf("%s-%d-1", convID, f.calls)}
+	}
+	return resp, nil
+}
+
+func TestBackfillPagesStopsWithoutCursor(t *testing.T) {
+	store, err := db.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	a := &App{Store: store, Logger: zerolog.Nop()}
+
+	gm := &countingFetcher{pages: 1}
+	n, err := a.backfillPages(gm, &db.BackfillState{ConversationID: "c1"}, 0, nil)
+	if err != nil || n != 2 || gm.calls != 1 {
+		t.Errorf("got %d stored in %d calls (err %v), want 2 in 1", n, gm.calls, err)
+	}
+	if st, _ := store.GetBackfillState("c1"); st == nil || !st.Complete {
+		t.Errorf("state = %+v, want complete", st)
+	}
+
+	// maxMessages stops paging even though more history remains.
+	gm = &countingFetcher{pages: 10}
+	n, err = a.backfillPages(gm, &db.BackfillState{ConversationID: "c2"}, 3, nil)
+	if err != nil || n != 4 || gm.calls != 2 {
+		t.Errorf("got %d stored in %d calls (err %v), want 4 in 2", n, gm.calls, err)
+	}
+	if st, _ := store.GetBackfillState("c2"); st == nil || st.Complete || st.LastItemID != "c2-2-1" {
+		t.Errorf("state = %+v, want incomplete at c2-2-1", st)
+	}
+}
+
+func TestBackfillConversationMarksComplete(t *testing.T) {
+	store, err := db.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	store.UpsertConversation(&db.Conversation{ConversationID: "c1", Name: "Alice"})
+	a := &App{Store: store, Logger: zerolog.Nop()}
+
+	gm := &countingFetcher{pages: 2}
+	if n, err := a.backfillConversation(gm, "c1", 0, false, nil); err != nil || n != 4 {
+		t.Fatalf("first backfill: stored %d, err %v; want 4", n, err)
+	}
+	if st, _ := store.GetBackfillState("c1"); st == nil || !st.Complete {
+		t.Fatalf("state = %+v, want complete after the history ran out", st)
+	}
+
+	// A completed conversation is skipped...
+	gm = &countingFetcher{pages: 1}
+	if n, err := a.backfillConversation(gm, "c1", 0, false, nil); err != nil || n != 0 || gm.calls != 0 {
+		t.Errorf("repeat backfill: stored %d in %d calls, err %v; want skipped", n, gm.calls, err)
+	}
+	// ...unless forced, which starts again from the newest message.
+	if n, err := a.backfillConversation(gm, "c1", 0, true, nil); err != nil || n != 2 || gm.calls != 1 {
+		t.Errorf("forced backfill: stored %d in %d calls, err %v; want 2 in 1", n, gm.calls, err)
+	}
+}
+
+func TestConversationBackfillProgress(t *testing.T) {
+	store, err := db.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	a := &App{Store: store, Logger: zerolog.Nop()}
+
+	if stored, running := a.ConversationBackfillProgress("c1"); stored != 0 || running {
+		t.Errorf("before any run: stored %d, running %v; want 0, false", stored, running)
+	}
+
+	run := a.trackConversationBackfill("c1")
+	var pages []int
+	n, err := a.backfillConversation(&countingFetcher{pages: 2}, "c1", 0, false, func(stored int) {
+		pages = append(pages, stored)
+		run.progress(stored)
+	})
+	if err != nil || fmt.Sprint(pages) != "[2 4]" {
+		t.Fatalf("progress = %v (err %v), want [2 4]", pages, err)
+	}
+	if stored, running := a.ConversationBackfillProgress("c1"); stored != 4 || !running {
+		t.Errorf("while running: stored %d, running %v; want 4, true", stored, running)
+	}
+	run.finish(n)
+	if stored, running := a.ConversationBackfillProgress("c1"); stored != 4 || running {
+		t.Errorf("after finishing: stored %d, running %v; want 4, false", stored, running)
+	}
+}
+
+func TestBackfillWhilePaused(t *testing.T) {
+	gate := &client.SyncGate{}
+	gate.Pause(true)
+	a := &App{Client: &client.Client{}, SyncGate: gate, Logger: zerolog.Nop()}
+
+	if err := a.Backfill(); !errors.Is(err, client.ErrSyncPaused) {
+		t.Errorf("Backfill: err = %v, want ErrSyncPaused", err)
+	}
+	if err := a.StartDeepBackfill(false); !errors.Is(err, client.ErrSyncPaused) {
+		t.Errorf("StartDeepBackfill: err = %v, want ErrSyncPaused", err)
+	}
+	if _, err := a.BackfillConversation("c1", 0, false); !errors.Is(err, client.ErrSyncPaused) {
+		t.Errorf("BackfillConversation: err = %v, want ErrSyncPaused", err)
+	}
+	if err := a.StartBackfillConversation("c1", 0, false); !errors.Is(err, client.ErrSyncPaused) {
+		t.Errorf("StartBackfillConversation: err = %v, want ErrSyncPaused", err)
+	}
+}
@@ -0,0 +1,62 @@
+package app
+
+import (
+	"sync"
+
+	"github.com/maxghenis/openmessage/internal/client"
+)
+
+// subscriberBuffer is how many events a subscriber may fall behind before
+// it is dropped.
+const subscriberBuffer = 64
+
+// Broker fans out store events to any number of subscribers. Publish never
+// blocks: a subscriber that falls too far behind has its channel closed and
+// must resubscribe and refetch.
+type Broker struct {
+	mu   sync.Mutex
+	subs map[chan client.StoreEvent]struct{}
+}
+
+// NewBroker returns a Broker with no subscribers.
+func NewBroker() *Broker {
+	return &Broker{subs: make(map[chan client.StoreEvent]struct{})}
+}
+
+// Publish sends evt to every subscriber.
+func (b *Broker) Publish(evt client.StoreEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs {
+		select {
+		case ch <- evt:
+		default:
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// Subscribe returns a channel of events and a function that unsubscribes.
+// The channel is closed on unsubscribe or if the subscriber falls behind.
+func (b *Broker) Subscribe() (<-chan client.StoreEvent, func()) {
+	ch := make(chan client.StoreEvent, subscriberBuffer)
+	b.mu.Lock()
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subs[ch]; ok {
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// Subscribers returns the number of active subscribers.
+func (b *Broker) Subscribers() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subs)
+}
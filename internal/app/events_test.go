@@ -0,0 +1,53 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/maxghenis/openmessage/internal/client"
+)
+
+func TestBrokerFanOut(t *testing.T) {
+	b := NewBroker()
+	a, unsubA := b.Subscribe()
+	c, unsubC := b.Subscribe()
+	defer unsubC()
+
+	evt := client.StoreEvent{Type: client.StoreEventMessage, ConversationID: "c1", MessageID: "m1"}
+	b.Publish(evt)
+	if got := <-a; got != evt {
+		t.Errorf("subscriber a got %+v", got)
+	}
+	if got := <-c; got != evt {
+		t.Errorf("subscriber c got %+v", got)
+	}
+
+	unsubA()
+	unsubA() // safe to call twice
+	if _, ok := <-a; ok {
+		t.Error("channel should be closed after unsubscribe")
+	}
+	if n := b.Subscribers(); n != 1 {
+		t.Errorf("subscribers = %d, want 1", n)
+	}
+}
+
+func TestBrokerDropsSlowSubscriber(t *testing.T) {
+	b := NewBroker()
+	slow, unsub := b.Subscribe()
+	defer unsub()
+
+	// Publishing past the buffer must not block.
+	for i := 0; i <= subscriberBuffer; i++ {
+		b.Publish(client.StoreEvent{Type: client.StoreEventMessage, MessageID: "m"})
+	}
+	if n := b.Subscribers(); n != 0 {
+		t.Errorf("subscribers = %d, want the slow one dropped", n)
+	}
+	drained := 0
+	for range slow {
+		drained++
+	}
+	if drained != subscriberBuffer {
+		t.Errorf("drained %d buffered events, want %d", drained, subscriberBuffer)
+	}
+}
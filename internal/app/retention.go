@@ -0,0 +1,94 @@
+package app
+
+import (
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/maxghenis/openmessage/internal/db"
+)
+
+// retentionSweepInterval is how often messages past the retention window
+// are pruned.
+const retentionSweepInterval = 24 * time.Hour
+
+// vacuumFraction is the share of messages a sweep must remove before the
+// database file is compacted.
+const vacuumFraction = 0.2
+
+// retentionDays reads OPENMESSAGES_RETENTION_DAYS, returning 0 (keep
+// everything) when unset or invalid.
+func retentionDays() int {
+	n, err := strconv.Atoi(os.Getenv("OPENMESSAGES_RETENTION_DAYS"))
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+// PruneOldMessages deletes messages older than RetentionDays before now and
+// returns how many were removed. The database is vacuumed if that was a
+// significant share of all messages. It does nothing when RetentionDays is 0.
+func (a *App) PruneOldMessages(now time.Time) (int64, error) {
+	if a.RetentionDays <= 0 {
+		return 0, nil
+	}
+	total, err := a.Store.CountMessages()
+	if err != nil {
+		return 0, err
+	}
+	n, err := a.Store.PruneMessagesOlderThan(a.retentionCutoff(now), a.RetentionKeepMedia)
+	if err != nil {
+		return 0, err
+	}
+	a.Logger.Info().Int64("messages", n).Int("retention_days", a.RetentionDays).Msg("Pruned old messages")
+	if total > 0 && float64(n)/float64(total) >= vacuumFraction {
+		if err := a.Store.Vacuum(); err != nil {
+			a.Logger.Warn().Err(err).Msg("Failed to vacuum database after pruning")
+		}
+	}
+	return n, nil
+}
+
+// retentionCutoff returns the time (ms) before which messages are pruned.
+func (a *App) retentionCutoff(now time.Time) int64 {
+	return now.AddDate(0, 0, -a.RetentionDays).UnixMilli()
+}
+
+// pastRetention reports whether the sweeper would prune m, so backfill
+// doesn't store messages only for the next sweep to delete them. It follows
+// the sweeper's exemptions except for pinned and starred messages, which
+// Google doesn't report.
+func (a *App) pastRetention(m *db.Message, now time.Time) bool {
+	if a.RetentionDays <= 0 || m.TimestampMS <= 0 || m.TimestampMS >= a.retentionCutoff(now) {
+		return false
+	}
+	if a.RetentionKeepMedia && m.MediaID != "" {
+		return false
+	}
+	c, err := a.Store.GetConversation(m.ConversationID)
+	return err != nil || !c.Pinned
+}
+
+// StartRetentionSweeper prunes old messages now and then every
+// retentionSweepInterval in the background, until Close is called. It does
+// nothing when RetentionDays is 0.
+func (a *App) StartRetentionSweeper() {
+	if a.RetentionDays <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(retentionSweepInterval)
+		defer ticker.Stop()
+		for {
+			if _, err := a.PruneOldMessages(time.Now()); err != nil {
+				a.Logger.Warn().Err(err).Msg("Failed to prune old messages")
+			}
+			select {
+			case <-ticker.C:
+			case <-a.done:
+				return
+			}
+		}
+	}()
+}
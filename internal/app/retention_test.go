@@ -0,0 +1,77 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"go.mau.fi/mautrix-gmessages/pkg/libgm/gmproto"
+
+	"github.com/maxghenis/openmessage/internal/db"
+)
+
+func TestPruneOldMessages(t *testing.T) {
+	store, err := db.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	now := time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+	store.UpsertMessage(&db.Message{MessageID: "old", ConversationID: "c1", TimestampMS: now.AddDate(0, 0, -31).UnixMilli()})
+	store.UpsertMessage(&db.Message{MessageID: "recent", ConversationID: "c1", TimestampMS: now.AddDate(0, 0, -29).UnixMilli()})
+
+	a := &App{Store: store, Logger: zerolog.Nop()}
+	if n, err := a.PruneOldMessages(now); err != nil || n != 0 {
+		t.Errorf("without retention: pruned %d, err %v; want nothing", n, err)
+	}
+
+	a.RetentionDays = 30
+	n, err := a.PruneOldMessages(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("pruned %d messages, want 1", n)
+	}
+	if m, _ := store.GetMessageByID("recent"); m == nil {
+		t.Error("message inside the window was pruned")
+	}
+}
+
+func TestPastRetention(t *testing.T) {
+	store, err := db.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	store.UpsertConversation(&db.Conversation{ConversationID: "pinned"})
+	store.SetConversationPinned("pinned", true)
+	now := time.Now()
+	old := now.AddDate(0, 0, -31).UnixMilli()
+
+	a := &App{Store: store, Logger: zerolog.Nop()}
+	if a.pastRetention(&db.Message{ConversationID: "c1", TimestampMS: old}, now) {
+		t.Error("skipped a message with retention off")
+	}
+	a.RetentionDays, a.RetentionKeepMedia = 30, true
+	for _, tc := range []struct {
+		m    *db.Message
+		want bool
+	}{
+		{&db.Message{ConversationID: "c1", TimestampMS: old}, true},
+		{&db.Message{ConversationID: "c1", TimestampMS: now.AddDate(0, 0, -29).UnixMilli()}, false},
+		{&db.Message{ConversationID: "c1"}, false},
+		{&db.Message{ConversationID: "c1", TimestampMS: old, MediaID: "m"}, false},
+		{&db.Message{ConversationID: "pinned", TimestampMS: old}, false},
+	} {
+		if got := a.pastRetention(tc.m, now); got != tc.want {
+			t.Errorf("pastRetention(%+v) = %v, want %v", tc.m, got, tc.want)
+		}
+	}
+
+	// Backfill doesn't store what the sweeper would prune.
+	a.storeMessage(&gmproto.Message{MessageID: "ancient", ConversationID: "c1", Timestamp: old * 1000}, nil)
+	if m, _ := store.GetMessageByID("ancient"); m != nil {
+		t.Error("backfill stored a message past retention")
+	}
+}
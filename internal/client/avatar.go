@@ -0,0 +1,108 @@
+package client
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+
+	"go.mau.fi/mautrix-gmessages/pkg/libgm/gmproto"
+
+	"github.com/maxghenis/openmessage/internal/db"
+)
+
+// ParticipantThumbnailer is the subset of *libgm.Client used to fetch
+// conversation participants' photos.
+type ParticipantThumbnailer interface {
+	GetParticipantThumbnail(participantIDs ...string) (*gmproto.GetThumbnailResponse, error)
+}
+
+// StoreThumbnails saves each photo in resp as the avatar of the number its
+// identifier maps to in numbers. Thumbnails without image data or for an
+// unknown identifier are skipped. It returns how many avatars were stored.
+func StoreThumbnails(store *db.Store, resp *gmproto.GetThumbnailResponse, numbers map[string]string) (int, error) {
+	stored := 0
+	var errs []error
+	for _, t := range resp.GetThumbnail() {
+		number := numbers[t.GetIdentifier()]
+		data := t.GetData().GetImageBuffer()
+		if number == "" || len(data) == 0 {
+			continue
+		}
+		mimeType := http.DetectContentType(data)
+		if !strings.HasPrefix(mimeType, "image/") {
+			continue
+		}
+		if err := store.SetAvatar(number, data, mimeType); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		stored++
+	}
+	return stored, errors.Join(errs...)
+}
+
+// FetchParticipantAvatars asks the phone for the photos of conv's other
+// participants that have no stored avatar and stores those it returns.
+// skip, if non-nil, is called with each participant ID before it's
+// requested and can return true to leave it out. On success it returns the
+// IDs the phone was asked about, including those it had no photo for.
+func FetchParticipantAvatars(store *db.Store, gm ParticipantThumbnailer, conv *gmproto.Conversation, skip func(participantID string) bool) ([]string, error) {
+	numbers := map[string]string{}
+	var ids []string
+	for _, p := range conv.GetParticipants() {
+		id := p.GetID().GetParticipantID()
+		number := p.GetID().GetNumber()
+		if number == "" {
+			number = p.GetFormattedNumber()
+		}
+		if p.GetIsMe() || id == "" || number == "" {
+			continue
+		}
+		if has, err := store.HasAvatar(number); err != nil || has {
+			continue
+		}
+		if skip != nil && skip(id) {
+			continue
+		}
+		numbers[id] = number
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	resp, err := gm.GetParticipantThumbnail(ids...)
+	if err != nil {
+		return nil, err
+	}
+	_, err = StoreThumbnails(store, resp, numbers)
+	return ids, err
+}
+
+// maxAvatarsAsked bounds how many participant IDs idSet remembers; past it
+// the set starts over, so at worst a participant is asked about again.
+const maxAvatarsAsked = 4096
+
+// idSet is a bounded, concurrency-safe set of IDs.
+type idSet struct {
+	mu  sync.Mutex
+	ids map[string]struct{}
+}
+
+func (s *idSet) has(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.ids[id]
+	return ok
+}
+
+func (s *idSet) add(ids ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ids == nil || len(s.ids)+len(ids) > maxAvatarsAsked {
+		s.ids = make(map[string]struct{}, len(ids))
+	}
+	for _, id := range ids {
+		s.ids[id] = struct{}{}
+	}
+}
@@ -0,0 +1,127 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"go.mau.fi/mautrix-gmessages/pkg/libgm/gmproto"
+
+	"github.com/maxghenis/openmessage/internal/db"
+)
+
+// pngHeader is enough of a PNG for content sniffing.
+var pngHeader = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+type fakeThumbnailer struct {
+	requested [][]string
+	photos    map[string][]byte
+	err       error
+}
+
+func (f *fakeThumbnailer) GetParticipantThumbnail(ids ...string) (*gmproto.GetThumbnailResponse, error) {
+	f.requested = append(f.requested, ids)
+	if f.err != nil {
+		return nil, f.err
+	}
+	resp := &gmproto.GetThumbnailResponse{}
+	for _, id := range ids {
+		resp.Thumbnail = append(resp.Thumbnail, &gmproto.GetThumbnailResponse_Thumbnail{
+			Identifier: id,
+			Data:       &gmproto.ThumbnailData{ImageBuffer: f.photos[id]},
+		})
+	}
+	return resp, nil
+}
+
+func TestFetchParticipantAvatars(t *testing.T) {
+	store, err := db.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	store.SetAvatar("+15553333333", pngHeader, "image/png")
+
+	conv := &gmproto.Conversation{Participants: []*gmproto.Participant{
+		{ID: &gmproto.SmallInfo{ParticipantID: "p1", Number: "+15551111111"}},
+		{ID: &gmproto.SmallInfo{ParticipantID: "p2", Number: "+15552222222"}},
+		{ID: &gmproto.SmallInfo{ParticipantID: "p3", Number: "+15553333333"}},
+		{ID: &gmproto.SmallInfo{ParticipantID: "me", Number: "+15550000000"}, IsMe: true},
+	}}
+	gm := &fakeThumbnailer{photos: map[string][]byte{"p1": pngHeader, "p2": []byte("not an image")}}
+	asked, err := FetchParticipantAvatars(store, gm, conv, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(asked) != 2 {
+		t.Errorf("asked = %v, want p1 and p2", asked)
+	}
+	// p3 already has a photo and the user's own isn't needed.
+	if len(gm.requested) != 1 || len(gm.requested[0]) != 2 {
+		t.Fatalf("requested = %v, want [[p1 p2]]", gm.requested)
+	}
+	if a, _ := store.GetAvatar("5551111111"); a == nil || a.MimeType != "image/png" {
+		t.Errorf("p1 avatar = %+v, want image/png", a)
+	}
+	if a, _ := store.GetAvatar("+15552222222"); a != nil {
+		t.Errorf("stored non-image data as avatar: %+v", a)
+	}
+
+	skipAll := func(string) bool { return true }
+	if _, err := FetchParticipantAvatars(store, gm, conv, skipAll); err != nil {
+		t.Fatal(err)
+	}
+	if len(gm.requested) != 1 {
+		t.Errorf("requested skipped participants: %v", gm.requested)
+	}
+}
+
+func TestFetchAvatarsRemembersAnsweredRequests(t *testing.T) {
+	store, err := db.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	conv := &gmproto.Conversation{Participants: []*gmproto.Participant{
+		{ID: &gmproto.SmallInfo{ParticipantID: "p1", Number: "+15551111111"}},
+	}}
+	h := &EventHandler{Store: store, Logger: zerolog.Nop()}
+	run := func(gm *fakeThumbnailer) {
+		h.avatarJobs = make(chan *gmproto.Conversation, 1)
+		h.avatarJobs <- conv
+		close(h.avatarJobs)
+		h.fetchAvatars(gm)
+	}
+
+	run(&fakeThumbnailer{err: errors.New("phone offline")})
+	if h.avatarAsked.has("p1") {
+		t.Error("participant remembered after a failed request")
+	}
+	run(&fakeThumbnailer{})
+	if !h.avatarAsked.has("p1") {
+		t.Error("participant not remembered after the phone answered")
+	}
+	gm := &fakeThumbnailer{}
+	run(gm)
+	if len(gm.requested) != 0 {
+		t.Errorf("asked again about a participant without a photo: %v", gm.requested)
+	}
+}
+
+func TestIDSetIsBounded(t *testing.T) {
+	var s idSet
+	s.add("a", "b")
+	if !s.has("a") || s.has("c") {
+		t.Fatal("membership wrong after add")
+	}
+	for i := 0; i < maxAvatarsAsked; i++ {
+		s.add(fmt.Sprint(i))
+	}
+	if len(s.ids) > maxAvatarsAsked {
+		t.Errorf("set grew to %d, cap %d", len(s.ids), maxAvatarsAsked)
+	}
+	if s.has("a") {
+		t.Error("set kept its oldest IDs past the cap")
+	}
+}
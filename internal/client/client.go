@@ -1,12 +1,18 @@
 package client
 
 import (
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"slices"
+	"strings"
 
 	"github.com/rs/zerolog"
 	"go.mau.fi/mautrix-gmessages/pkg/libgm"
 	"go.mau.fi/mautrix-gmessages/pkg/libgm/gmproto"
+	"google.golang.org/protobuf/encoding/protojson"
+
+	"github.com/maxghenis/openmessage/internal/db"
 )
 
 type Client struct {
@@ -76,6 +82,7 @@ type MediaInfo struct {
 	ThumbnailMediaID        string
 	ThumbnailDecryptionKey  []byte
 	InlineData              []byte // Inline thumbnail bytes from mediaData field
+	Subtype                 string // db.SubtypeGIF, db.SubtypeSticker, or ""
 }
 
 // ExtractMediaInfo extracts media content from a protobuf Message.
@@ -103,6 +110,7 @@ func ExtractMediaInfo(msg *gmproto.Message) *MediaInfo {
 				ThumbnailMediaID:      mc.GetThumbnailMediaID(),
 				ThumbnailDecryptionKey: mc.GetThumbnailDecryptionKey(),
 				InlineData:            mc.GetMediaData(),
+				Subtype:               MediaSubtype(mime, mc.GetMediaName()),
 			}
 
 			// If no full-size MediaID, fall back to thumbnail
@@ -117,6 +125,32 @@ func ExtractMediaInfo(msg *gmproto.Message) *MediaInfo {
 	return nil
 }
 
+// CountAttachments returns how many media attachments a message carries.
+func CountAttachments(msg *gmproto.Message) int {
+	n := 0
+	for _, info := range msg.GetMessageInfo() {
+		if info.GetMediaContent() != nil {
+			n++
+		}
+	}
+	return n
+}
+
+// MediaSubtype classifies an attachment as an animated GIF or a sticker from
+// its MIME type and file name, returning "" for ordinary media. The phone
+// doesn't flag stickers, but keyboards name their files after them, e.g.
+// "sticker_1234.webp".
+func MediaSubtype(mimeType, name string) string {
+	mimeType = strings.ToLower(mimeType)
+	switch {
+	case strings.Contains(mimeType, "sticker"), strings.HasPrefix(mimeType, "image/") && strings.Contains(strings.ToLower(name), "sticker"):
+		return db.SubtypeSticker
+	case mimeType == "image/gif":
+		return db.SubtypeGIF
+	}
+	return ""
+}
+
 // Reaction holds an emoji and how many people reacted with it.
 type Reaction struct {
 	Emoji string `json:"emoji"`
@@ -173,3 +207,220 @@ func ExtractSenderInfo(msg *gmproto.Message) (name, number string) {
 	}
 	return
 }
+
+// ContactNamer returns the saved contact name for a phone number, or "".
+type ContactNamer func(number string) string
+
+// StoreContactNamer looks names up in the store's contacts.
+func StoreContactNamer(store *db.Store) ContactNamer {
+	return func(number string) string {
+		name, _ := store.ContactNameByNumber(number)
+		return name
+	}
+}
+
+// NumberMatcher reports whether two phone numbers are the same number.
+type NumberMatcher func(a, b string) bool
+
+// ConversationName picks a conversation's display name: the name the phone
+// gives it, else its other participants' names joined with ", ". Each
+// participant is named by the first non-empty of their saved contact name
+// (via contactName, which may be nil), full name, formatted number, and raw
+// number.
+func ConversationName(conv *gmproto.Conversation, contactName ContactNamer) string {
+	if name := strings.TrimSpace(conv.GetName()); name != "" {
+		return name
+	}
+	var names []string
+	for _, p := range conv.GetParticipants() {
+		if p.GetIsMe() {
+			continue
+		}
+		if name := participantName(p, contactName); name != "" {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// IsSelfChat reports whether conv is a "note to self" thread: not a group,
+// with me as a participant and nobody else except entries for my own number,
+// compared with match. A nil match only accepts identical numbers.
+func IsSelfChat(conv *gmproto.Conversation, match NumberMatcher) bool {
+	if match == nil {
+		match = func(a, b string) bool { return a != "" && a == b }
+	}
+	if conv.GetIsGroupChat() {
+		return false
+	}
+	var mine []string
+	var others []*gmproto.Participant
+	for _, p := range conv.GetParticipants() {
+		if p.GetIsMe() {
+			mine = append(mine, p.GetID().GetNumber(), p.GetFormattedNumber())
+		} else {
+			others = append(others, p)
+		}
+	}
+	if len(mine) == 0 {
+		return false
+	}
+	for _, p := range others {
+		if !slices.ContainsFunc(mine, func(n string) bool {
+			return match(n, p.GetID().GetNumber()) || match(n, p.GetFormattedNumber())
+		}) {
+			return false
+		}
+	}
+	return true
+}
+
+func participantName(p *gmproto.Participant, contactName ContactNamer) string {
+	number := p.GetID().GetNumber()
+	if contactName != nil {
+		for _, n := range []string{number, p.GetFormattedNumber()} {
+			if n == "" {
+				continue
+			}
+			if name := contactName(n); name != "" {
+				return name
+			}
+		}
+	}
+	for _, name := range []string{p.GetFullName(), p.GetFormattedNumber(), number} {
+		if name = strings.TrimSpace(name); name != "" {
+			return name
+		}
+	}
+	return ""
+}
+
+// ConversationToDB converts a protobuf Conversation into a database row,
+// named by ConversationName with store's contacts and with numbers compared
+// by store. store may be nil. Participants are stored as a JSON array of
+// {name, number, is_me}.
+func ConversationToDB(conv *gmproto.Conversation, store *db.Store) *db.Conversation {
+	var contactName ContactNamer
+	var match NumberMatcher
+	if store != nil {
+		contactName = StoreContactNamer(store)
+		match = store.NumbersMatch
+	}
+
+	participantsJSON := "[]"
+	if ps := conv.GetParticipants(); len(ps) > 0 {
+		type pInfo struct {
+			Name   string `json:"name"`
+			Number string `json:"number"`
+			IsMe   bool   `json:"is_me,omitempty"`
+		}
+		var infos []pInfo
+		for _, p := range ps {
+			info := pInfo{
+				Name: p.GetFullName(),
+				IsMe: p.GetIsMe(),
+			}
+			if id := p.GetID(); id != nil {
+				info.Number = id.GetNumber()
+			}
+			if info.Number == "" {
+				info.Number = p.GetFormattedNumber()
+			}
+			infos = append(infos, info)
+		}
+		if b, err := json.Marshal(infos); err == nil {
+			participantsJSON = string(b)
+		}
+	}
+
+	unread := 0
+	if conv.GetUnread() {
+		unread = 1
+	}
+
+	return &db.Conversation{
+		ConversationID: conv.GetConversationID(),
+		Name:           ConversationName(conv, contactName),
+		IsGroup:        conv.GetIsGroupChat(),
+		IsSelfChat:     IsSelfChat(conv, match),
+		Participants:   participantsJSON,
+		LastMessageTS:  conv.GetLastMessageTimestamp() / 1000, // microseconds to milliseconds
+		UnreadCount:    unread,
+		Transport:      conversationTransport(conv.GetType()),
+	}
+}
+
+// conversationTransport maps a conversation's type to its transport, or ""
+// when the phone doesn't say.
+func conversationTransport(t gmproto.ConversationType) string {
+	switch t {
+	case gmproto.ConversationType_RCS:
+		return db.TransportRCS
+	case gmproto.ConversationType_SMS:
+		return db.TransportSMS
+	}
+	return ""
+}
+
+// MessageToDB converts a protobuf Message into a database row. When keepRaw is
+// set, messages with neither text nor media keep their JSON-encoded proto in
+// RawPayload so unsupported types (polls, rich cards) aren't silently dropped.
+func MessageToDB(msg *gmproto.Message, keepRaw bool) *db.Message {
+	senderName, senderNumber := ExtractSenderInfo(msg)
+
+	status := "unknown"
+	if ms := msg.GetMessageStatus(); ms != nil {
+		status = ms.GetStatus().String()
+	}
+
+	dbMsg := &db.Message{
+		MessageID:      msg.GetMessageID(),
+		ConversationID: msg.GetConversationID(),
+		SenderName:     senderName,
+		SenderNumber:   senderNumber,
+		Body:           ExtractMessageBody(msg),
+		TimestampMS:    msg.GetTimestamp() / 1000, // proto timestamp is microseconds
+		Status:         status,
+		IsFromMe:       msg.GetSenderParticipant() != nil && msg.GetSenderParticipant().GetIsMe(),
+		Deleted:        msg.GetMessageStatus().GetStatus() == gmproto.MessageStatusType_MESSAGE_DELETED,
+	}
+	// Our own messages echo back with the tmp ID they were sent under.
+	if dbMsg.IsFromMe && msg.GetTmpID() != msg.GetMessageID() {
+		dbMsg.TmpID = msg.GetTmpID()
+	}
+
+	media := ExtractMediaInfo(msg)
+	if media != nil {
+		dbMsg.MediaID = media.MediaID
+		dbMsg.MimeType = media.MimeType
+		dbMsg.DecryptionKey = hex.EncodeToString(media.DecryptionKey)
+		dbMsg.MediaSize = media.Size
+		dbMsg.Subtype = media.Subtype
+		dbMsg.AttachmentCount = CountAttachments(msg)
+		if media.ThumbnailMediaID != media.MediaID {
+			dbMsg.ThumbnailMediaID = media.ThumbnailMediaID
+			dbMsg.ThumbnailDecryptionKey = hex.EncodeToString(media.ThumbnailDecryptionKey)
+		}
+	}
+
+	if reactions := ExtractReactions(msg); reactions != nil {
+		if b, err := json.Marshal(reactions); err == nil {
+			dbMsg.Reactions = string(b)
+		}
+	}
+	dbMsg.ReplyToID = ExtractReplyToID(msg)
+	dbMsg.Location = ExtractLocation(msg)
+	dbMsg.SharedContact = ExtractSharedContact(msg)
+	if dbMsg.SharedContact != nil && dbMsg.Body == "" {
+		// A card has no text of its own; describe it so it reads and searches.
+		dbMsg.Body = dbMsg.SharedContact.Summary()
+	}
+
+	if keepRaw && dbMsg.Body == "" && media == nil && dbMsg.Location == nil {
+		if b, err := protojson.Marshal(msg); err == nil {
+			dbMsg.RawPayload = string(b)
+		}
+	}
+
+	return dbMsg
+}
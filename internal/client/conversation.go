@@ -0,0 +1,107 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mau.fi/mautrix-gmessages/pkg/libgm/gmproto"
+
+	"github.com/maxghenis/openmessage/internal/db"
+)
+
+// ConversationCreator is the subset of *libgm.Client used to start conversations.
+type ConversationCreator interface {
+	GetOrCreateConversation(req *gmproto.GetOrCreateConversationRequest) (*gmproto.GetOrCreateConversationResponse, error)
+}
+
+// StartConversation gets or creates the conversation with numbers: a 1:1
+// thread for one number, or a group for several. name is used for new RCS
+// groups and as the group's local name when the phone doesn't give one. The
+// conversation is stored locally with the current time as its last activity,
+// so it sorts to the top of the list. It returns both the phone's
+// conversation and the stored row.
+func StartConversation(store *db.Store, gm ConversationCreator, numbers []string, name string) (*gmproto.Conversation, *db.Conversation, error) {
+	numbers = uniqueNumbers(store, numbers)
+	if len(numbers) == 0 {
+		return nil, nil, errors.New("at least one phone number is required")
+	}
+	group := len(numbers) > 1
+
+	req := &gmproto.GetOrCreateConversationRequest{}
+	for _, n := range numbers {
+		// The web client uses 7 for a single recipient and 2 for group members.
+		kind := int32(7)
+		if group {
+			kind = 2
+		}
+		req.Numbers = append(req.Numbers, &gmproto.ContactNumber{MysteriousInt: kind, Number: n, Number2: n})
+	}
+	if group && name != "" {
+		req.RCSGroupName = &name
+	}
+
+	resp, err := gm.GetOrCreateConversation(req)
+	if err == nil && group && resp.GetStatus() == gmproto.GetOrCreateConversationResponse_CREATE_RCS {
+		// The phone asks for confirmation before creating an RCS group.
+		create := true
+		if req.RCSGroupName == nil {
+			req.RCSGroupName = &name
+		}
+		req.CreateRCSGroup = &create
+		resp, err = gm.GetOrCreateConversation(req)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get/create conversation: %w", err)
+	}
+	conv := resp.GetConversation()
+	if conv.GetConversationID() == "" {
+		return nil, nil, fmt.Errorf("no conversation returned (status: %s)", resp.GetStatus())
+	}
+
+	dbConv := ConversationToDB(conv, store)
+	if group {
+		dbConv.IsGroup = true
+		if strings.TrimSpace(conv.GetName()) == "" && name != "" {
+			dbConv.Name = name
+		}
+	}
+	if dbConv.Participants == "[]" {
+		dbConv.Participants = participantsForNumbers(numbers)
+	}
+	if dbConv.Name == "" {
+		dbConv.Name = strings.Join(numbers, ", ")
+	}
+	dbConv.LastMessageTS = time.Now().UnixMilli()
+	if err := store.UpsertConversation(dbConv); err != nil {
+		return nil, nil, fmt.Errorf("store conversation: %w", err)
+	}
+	return conv, dbConv, nil
+}
+
+// uniqueNumbers normalizes numbers (see db.Store.NormalizeNumber) and drops
+// blanks and duplicates, keeping order.
+func uniqueNumbers(store *db.Store, numbers []string) []string {
+	var out []string
+	seen := map[string]bool{}
+	for _, n := range numbers {
+		n = store.NormalizeNumber(n)
+		if n == "" || seen[n] {
+			continue
+		}
+		seen[n] = true
+		out = append(out, n)
+	}
+	return out
+}
+
+// participantsForNumbers builds the participants JSON for a conversation the
+// phone returned without participant details.
+func participantsForNumbers(numbers []string) string {
+	ps := make([]*gmproto.Participant, len(numbers))
+	for i, n := range numbers {
+		ps[i] = &gmproto.Participant{ID: &gmproto.SmallInfo{Number: n}}
+	}
+	return ConversationToDB(&gmproto.Conversation{Participants: ps}, nil).Participants
+}
@@ -0,0 +1,125 @@
+package client
+
+import (
+	"encoding/json"
+	"testing"
+
+	"go.mau.fi/mautrix-gmessages/pkg/libgm/gmproto"
+)
+
+// fakeCreator returns conv for every request, first answering CREATE_RCS
+// when askRCS is set.
+type fakeCreator struct {
+	conv   *gmproto.Conversation
+	askRCS bool
+	reqs   []*gmproto.GetOrCreateConversationRequest
+}
+
+func (f *fakeCreator) GetOrCreateConversation(req *gmproto.GetOrCreateConversationRequest) (*gmproto.GetOrCreateConversationResponse, error) {
+	f.reqs = append(f.reqs, req)
+	if f.askRCS && len(f.reqs) == 1 {
+		return &gmproto.GetOrCreateConversationResponse{Status: gmproto.GetOrCreateConversationResponse_CREATE_RCS}, nil
+	}
+	return &gmproto.GetOrCreateConversationResponse{Conversation: f.conv}, nil
+}
+
+func TestStartConversation_Group(t *testing.T) {
+	store := newTestHandler(t).Store
+	numbers := []string{"+15551110000", "+15552220000", "+15553330000"}
+	fake := &fakeCreator{askRCS: true, conv: &gmproto.Conversation{
+		ConversationID: "g1",
+		IsGroupChat:    true,
+		Participants: []*gmproto.Participant{
+			{IsMe: true, ID: &gmproto.SmallInfo{Number: "+15559990000"}},
+			{FullName: "Ann", ID: &gmproto.SmallInfo{Number: numbers[0]}},
+			{FullName: "Bob", ID: &gmproto.SmallInfo{Number: numbers[1]}},
+			{ID: &gmproto.SmallInfo{Number: numbers[2]}},
+		},
+	}}
+
+	_, conv, err := StartConversation(store, fake, append(numbers, " +15552220000 "), "Hiking")
+	if err != nil {
+		t.Fatalf("StartConversation: %v", err)
+	}
+
+	if len(fake.reqs) != 2 {
+		t.Fatalf("made %d requests, want 2 (CREATE_RCS confirmation)", len(fake.reqs))
+	}
+	req := fake.reqs[1]
+	if !req.GetCreateRCSGroup() || req.GetRCSGroupName() != "Hiking" {
+		t.Errorf("confirmation request = %+v", req)
+	}
+	if len(req.Numbers) != 3 {
+		t.Errorf("sent %d numbers, want 3 (duplicates dropped)", len(req.Numbers))
+	}
+	for _, n := range req.Numbers {
+		if n.MysteriousInt != 2 {
+			t.Errorf("group member %s has MysteriousInt %d, want 2", n.Number, n.MysteriousInt)
+		}
+	}
+
+	stored, _ := store.GetConversation("g1")
+	if stored == nil || !stored.IsGroup || stored.Name != "Hiking" || stored.LastMessageTS == 0 {
+		t.Fatalf("stored conversation = %+v", stored)
+	}
+	var ps []struct {
+		Name   string `json:"name"`
+		Number string `json:"number"`
+		IsMe   bool   `json:"is_me"`
+	}
+	if err := json.Unmarshal([]byte(stored.Participants), &ps); err != nil {
+		t.Fatalf("participants JSON: %v", err)
+	}
+	if len(ps) != 4 || !ps[0].IsMe || ps[1].Name != "Ann" || ps[3].Number != numbers[2] {
+		t.Errorf("participants = %+v", ps)
+	}
+	if conv.ConversationID != "g1" {
+		t.Errorf("returned conversation = %+v", conv)
+	}
+}
+
+func TestStartConversation_ParticipantsFromNumbers(t *testing.T) {
+	store := newTestHandler(t).Store
+	numbers := []string{"+15551110000", "+15552220000", "+15553330000"}
+	fake := &fakeCreator{conv: &gmproto.Conversation{ConversationID: "g2"}}
+
+	if _, _, err := StartConversation(store, fake, numbers, ""); err != nil {
+		t.Fatal(err)
+	}
+	stored, _ := store.GetConversation("g2")
+	ps, err := store.ConversationParticipants("g2")
+	if err != nil || len(ps) != 3 {
+		t.Fatalf("participants = %+v, %v", ps, err)
+	}
+	for i, p := range ps {
+		if p.Number != numbers[i] {
+			t.Errorf("participant %d number = %q, want %q", i, p.Number, numbers[i])
+		}
+	}
+	if !stored.IsGroup || stored.Name != "+15551110000, +15552220000, +15553330000" {
+		t.Errorf("stored = %+v", stored)
+	}
+}
+
+func TestStartConversation_Single(t *testing.T) {
+	store := newTestHandler(t).Store
+	fake := &fakeCreator{conv: &gmproto.Conversation{
+		ConversationID: "c1",
+		Participants:   []*gmproto.Participant{{FullName: "Ann", ID: &gmproto.SmallInfo{Number: "+15551110000"}}},
+	}}
+
+	_, conv, err := StartConversation(store, fake, []string{"+15551110000"}, "ignored")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conv.IsGroup || conv.Name != "Ann" {
+		t.Errorf("conversation = %+v", conv)
+	}
+	if req := fake.reqs[0]; req.Numbers[0].MysteriousInt != 7 || req.RCSGroupName != nil {
+		t.Errorf("request = %+v", req)
+	}
+
+	if _, _, err := StartConversation(store, fake, []string{" "}, ""); err == nil {
+		t.Error("expected error for no numbers")
+	}
+}
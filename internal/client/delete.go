@@ -0,0 +1,74 @@
+package client
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.mau.fi/mautrix-gmessages/pkg/libgm/gmproto"
+
+	"github.com/maxghenis/openmessage/internal/db"
+)
+
+// ErrMessageNotFound is returned by DeleteMessage for unknown message IDs.
+var ErrMessageNotFound = db.ErrMessageNotFound
+
+// MessageDeleter is the subset of *libgm.Client used to delete messages.
+type MessageDeleter interface {
+	DeleteMessage(messageID string) (*gmproto.DeleteMessageResponse, error)
+}
+
+// DeleteResult reports what DeleteMessage did.
+type DeleteResult struct {
+	MessageID string `json:"message_id"`
+	// RemoteSkipped is set when the phone wasn't asked to delete the
+	// message: no client is connected, or it was a tmp_ placeholder that
+	// never reached the server.
+	RemoteSkipped bool `json:"remote_skipped"`
+	// AffectedReplies lists messages whose reply_to_id now points at the
+	// deleted message.
+	AffectedReplies []string `json:"affected_replies"`
+}
+
+// DeleteMessage deletes a message on the phone when gm is non-nil, then
+// removes it from the local store. If the remote delete fails, the local
+// copy is kept so the two don't drift apart.
+func DeleteMessage(store *db.Store, gm MessageDeleter, messageID string) (*DeleteResult, error) {
+	m, err := store.GetMessageByID(messageID)
+	if err != nil {
+		return nil, fmt.Errorf("get message: %w", err)
+	}
+	if m == nil {
+		return nil, ErrMessageNotFound
+	}
+	replies, err := store.ReplyIDs(messageID)
+	if err != nil {
+		return nil, fmt.Errorf("find replies: %w", err)
+	}
+	if replies == nil {
+		replies = []string{}
+	}
+	res := &DeleteResult{MessageID: messageID, AffectedReplies: replies}
+
+	if gm == nil || strings.HasPrefix(messageID, "tmp_") {
+		res.RemoteSkipped = true
+	} else {
+		resp, err := gm.DeleteMessage(messageID)
+		if err != nil {
+			return nil, fmt.Errorf("delete on phone: %w", err)
+		}
+		if !resp.GetSuccess() {
+			return nil, fmt.Errorf("delete on phone: not accepted")
+		}
+	}
+
+	if err := store.DeleteMessage(messageID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			// Removed concurrently, e.g. by a tmp_ cleanup; nothing left to do.
+			return res, nil
+		}
+		return nil, fmt.Errorf("delete locally: %w", err)
+	}
+	return res, nil
+}
@@ -0,0 +1,75 @@
+package client
+
+import (
+	"errors"
+	"testing"
+
+	"go.mau.fi/mautrix-gmessages/pkg/libgm/gmproto"
+
+	"github.com/maxghenis/openmessage/internal/db"
+)
+
+type fakeDeleter struct {
+	deleted []string
+	err     error
+}
+
+func (f *fakeDeleter) DeleteMessage(messageID string) (*gmproto.DeleteMessageResponse, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	f.deleted = append(f.deleted, messageID)
+	return &gmproto.DeleteMessageResponse{Success: true}, nil
+}
+
+func TestDeleteMessage(t *testing.T) {
+	store, err := db.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	for _, m := range []*db.Message{
+		{MessageID: "m1", ConversationID: "c1"},
+		{MessageID: "m2", ConversationID: "c1", ReplyToID: "m1"},
+		{MessageID: "m3", ConversationID: "c1"},
+		{MessageID: "tmp_000000000001", ConversationID: "c1"},
+	} {
+		store.UpsertMessage(m)
+	}
+
+	gm := &fakeDeleter{}
+	res, err := DeleteMessage(store, gm, "m1")
+	if err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if res.RemoteSkipped || len(gm.deleted) != 1 {
+		t.Errorf("remote delete not sent: %+v, calls %v", res, gm.deleted)
+	}
+	if len(res.AffectedReplies) != 1 || res.AffectedReplies[0] != "m2" {
+		t.Errorf("affected replies = %v, want [m2]", res.AffectedReplies)
+	}
+
+	// Placeholders never reached the server, so only the local row goes.
+	res, err = DeleteMessage(store, gm, "tmp_000000000001")
+	if err != nil || !res.RemoteSkipped || len(gm.deleted) != 1 {
+		t.Errorf("tmp delete: res=%+v err=%v calls=%v", res, err, gm.deleted)
+	}
+
+	// A failed remote delete keeps the local copy.
+	gm.err = errors.New("phone offline")
+	if _, err := DeleteMessage(store, gm, "m3"); err == nil {
+		t.Error("expected error when the phone delete fails")
+	}
+	if m, _ := store.GetMessageByID("m3"); m == nil {
+		t.Error("m3 was deleted locally despite remote failure")
+	}
+
+	res, err = DeleteMessage(store, nil, "m3")
+	if err != nil || !res.RemoteSkipped {
+		t.Errorf("offline delete: res=%+v err=%v", res, err)
+	}
+
+	if _, err := DeleteMessage(store, nil, "missing"); !errors.Is(err, ErrMessageNotFound) {
+		t.Errorf("missing: err = %v, want ErrMessageNotFound", err)
+	}
+}
@@ -0,0 +1,127 @@
+package client
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DeliveryUpdate is a status change for a message being watched.
+type DeliveryUpdate struct {
+	MessageID string // the server ID once the echo has arrived, else the tmp ID
+	Status    string
+}
+
+// Delivered reports whether the recipient's phone has the message.
+func (u DeliveryUpdate) Delivered() bool {
+	return u.Status == "OUTGOING_DELIVERED" || u.Status == "OUTGOING_DISPLAYED"
+}
+
+// Failed reports whether the message will not be delivered.
+func (u DeliveryUpdate) Failed() bool {
+	return strings.HasPrefix(u.Status, "OUTGOING_FAILED") || u.Status == "OUTGOING_CANCELED"
+}
+
+// DeliveryWaiter lets senders wait for a message's status to change. Each
+// watch is keyed by the tmp ID chosen at send time; when the echo arrives
+// with the server ID, later status events for that ID reach the same watch.
+type DeliveryWaiter struct {
+	mu    sync.Mutex
+	byTmp map[string]*DeliveryWatch
+	byID  map[string]*DeliveryWatch
+}
+
+// NewDeliveryWaiter returns an empty DeliveryWaiter.
+func NewDeliveryWaiter() *DeliveryWaiter {
+	return &DeliveryWaiter{
+		byTmp: make(map[string]*DeliveryWatch),
+		byID:  make(map[string]*DeliveryWatch),
+	}
+}
+
+// DeliveryWatch receives status updates for one sent message.
+type DeliveryWatch struct {
+	w       *DeliveryWaiter
+	tmpID   string
+	updates chan DeliveryUpdate
+	last    DeliveryUpdate
+}
+
+// Watch starts watching tmpID. Call it before sending so a fast echo isn't
+// missed, and Close the watch when done.
+func (dw *DeliveryWaiter) Watch(tmpID string) *DeliveryWatch {
+	w := &DeliveryWatch{
+		w:       dw,
+		tmpID:   tmpID,
+		updates: make(chan DeliveryUpdate, 8),
+		last:    DeliveryUpdate{MessageID: tmpID, Status: "OUTGOING_SENDING"},
+	}
+	dw.mu.Lock()
+	dw.byTmp[tmpID] = w
+	dw.mu.Unlock()
+	return w
+}
+
+// Observe routes a message event to its watch, if any. tmpID is the echo's
+// tmp ID, which is empty on later status-only updates.
+func (dw *DeliveryWaiter) Observe(tmpID, messageID, status string) {
+	dw.mu.Lock()
+	w := dw.byID[messageID]
+	if w == nil && tmpID != "" {
+		if w = dw.byTmp[tmpID]; w != nil {
+			dw.byID[messageID] = w
+		}
+	}
+	dw.mu.Unlock()
+	if w == nil {
+		return
+	}
+	// The event handler must never block on a waiter that has fallen
+	// behind. The waiter only needs the latest status, so make room by
+	// dropping the oldest queued update rather than the new one.
+	u := DeliveryUpdate{MessageID: messageID, Status: status}
+	for {
+		select {
+		case w.updates <- u:
+			return
+		default:
+		}
+		select {
+		case <-w.updates:
+		default:
+		}
+	}
+}
+
+// Wait returns once the message is delivered or has failed, or when timeout
+// or ctx ends. It returns the latest status seen and whether it is final.
+func (w *DeliveryWatch) Wait(ctx context.Context, timeout time.Duration) (DeliveryUpdate, bool) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case u := <-w.updates:
+			w.last = u
+			if u.Delivered() || u.Failed() {
+				return u, true
+			}
+		case <-timer.C:
+			return w.last, false
+		case <-ctx.Done():
+			return w.last, false
+		}
+	}
+}
+
+// Close stops the watch.
+func (w *DeliveryWatch) Close() {
+	w.w.mu.Lock()
+	defer w.w.mu.Unlock()
+	delete(w.w.byTmp, w.tmpID)
+	for id, other := range w.w.byID {
+		if other == w {
+			delete(w.w.byID, id)
+		}
+	}
+}
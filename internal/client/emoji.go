@@ -0,0 +1,89 @@
+package client
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"go.mau.fi/mautrix-gmessages/pkg/libgm/gmproto"
+)
+
+// reactionShortcodes maps common :shortcode: names to the emoji Google
+// Messages offers as standard reactions, plus a few other popular ones.
+var reactionShortcodes = map[string]string{
+	"thumbsup":              "👍",
+	"+1":                    "👍",
+	"like":                  "👍",
+	"thumbsdown":            "👎",
+	"-1":                    "👎",
+	"dislike":               "👎",
+	"heart":                 "❤️",
+	"red_heart":             "❤️",
+	"heart_eyes":            "😍",
+	"love":                  "😍",
+	"joy":                   "😂",
+	"laugh":                 "😂",
+	"laughing":              "😂",
+	"open_mouth":            "😮",
+	"surprised":             "😮",
+	"wow":                   "😮",
+	"disappointed_relieved": "😥",
+	"sad":                   "😥",
+	"angry":                 "😠",
+	"rage":                  "😡",
+	"pouting_face":          "😡",
+	"thinking":              "🤔",
+	"thinking_face":         "🤔",
+	"cry":                   "😢",
+	"crying_face":           "😢",
+	"tada":                  "🎉",
+	"fire":                  "🔥",
+	"clap":                  "👏",
+	"pray":                  "🙏",
+	"ok_hand":               "👌",
+	"smile":                 "😄",
+}
+
+// NormalizeReactionEmoji turns user input into the emoji form Google
+// Messages expects. It resolves :shortcodes:, and drops variation selectors
+// so "👍️" (with VS16) matches the standard 👍 reaction. Emoji that aren't
+// standard reactions are kept and sent as custom reactions. Input that
+// isn't an emoji or a known shortcode is an error.
+func NormalizeReactionEmoji(input string) (string, error) {
+	s := strings.TrimSpace(input)
+	if s == "" {
+		return "", fmt.Errorf("emoji is required")
+	}
+	if len(s) > 2 && strings.HasPrefix(s, ":") && strings.HasSuffix(s, ":") {
+		name := strings.ToLower(strings.Trim(s, ":"))
+		e, ok := reactionShortcodes[name]
+		if !ok {
+			return "", fmt.Errorf("unknown emoji shortcode %q", s)
+		}
+		return e, nil
+	}
+
+	bare := strings.Map(func(r rune) rune {
+		if r == '\uFE0F' || r == '\uFE0E' { // emoji and text variation selectors
+			return -1
+		}
+		return r
+	}, s)
+	if t := gmproto.UnicodeToEmojiType(bare); t != gmproto.EmojiType_CUSTOM {
+		return t.Unicode(), nil
+	}
+
+	hasSymbol := false
+	for _, r := range s {
+		if unicode.IsLetter(r) || unicode.IsSpace(r) || unicode.IsPunct(r) {
+			return "", fmt.Errorf("%q is not an emoji", input)
+		}
+		if unicode.Is(unicode.So, r) {
+			hasSymbol = true
+		}
+	}
+	if !hasSymbol {
+		return "", fmt.Errorf("%q is not an emoji", input)
+	}
+	return s, nil
+}
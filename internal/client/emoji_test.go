@@ -0,0 +1,43 @@
+package client
+
+import (
+	"testing"
+
+	"go.mau.fi/mautrix-gmessages/pkg/libgm/gmproto"
+)
+
+func TestNormalizeReactionEmoji(t *testing.T) {
+	tests := []struct {
+		in       string
+		want     string
+		wantType gmproto.EmojiType
+	}{
+		{":thumbsup:", "👍", gmproto.EmojiType_LIKE},
+		{":+1:", "👍", gmproto.EmojiType_LIKE},
+		{" :Heart: ", "❤️", gmproto.EmojiType_RED_HEART},
+		{"👍️", "👍", gmproto.EmojiType_LIKE},      // VS16 variant
+		{"❤", "❤️", gmproto.EmojiType_RED_HEART}, // bare heart gains VS16
+		{"😂", "😂", gmproto.EmojiType_LAUGH},
+		{"🎉", "🎉", gmproto.EmojiType_CUSTOM},
+		{"👍🏽", "👍🏽", gmproto.EmojiType_CUSTOM}, // skin tone kept as custom
+	}
+	for _, tt := range tests {
+		got, err := NormalizeReactionEmoji(tt.in)
+		if err != nil {
+			t.Errorf("%q: %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%q = %q, want %q", tt.in, got, tt.want)
+		}
+		if rd := gmproto.MakeReactionData(got); rd.GetType() != tt.wantType {
+			t.Errorf("%q: reaction type = %v, want %v", tt.in, rd.GetType(), tt.wantType)
+		}
+	}
+
+	for _, bad := range []string{"", "  ", ":nope:", "thumbsup", "ok!", "123"} {
+		if _, err := NormalizeReactionEmoji(bad); err == nil {
+			t.Errorf("%q: expected error", bad)
+		}
+	}
+}
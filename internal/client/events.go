@@ -1,9 +1,10 @@
 package client
 
 import (
-	"encoding/hex"
-	"encoding/json"
+	"database/sql"
+	"errors"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/rs/zerolog"
@@ -20,8 +21,19 @@ type OnDisconnect func()
 // SupabaseSync is an optional writer that syncs data to Supabase.
 type SupabaseSync interface {
 	UpsertConversation(convID, name string, lastMessageTime time.Time, isGroup bool, lastPreview string) error
-	UpsertMessage(id, conversationID, senderName, senderNumber, content string, timestamp time.Time, isFromMe bool, mediaType, mediaURL string) error
+	UpsertMessage(id, conversationID, senderName, senderNumber, content string, timestamp time.Time, isFromMe bool, mediaType, mediaURL, reactions, replyToID string) error
 	UpsertContact(number, name string) error
+	SetMessageStarred(id, conversationID string, starred bool) error
+	SetMessageDeleted(id, conversationID string) error
+	ListStarredMessageIDs() ([]string, error)
+	MessageStars(ids []string) (map[string]bool, error)
+	SetConversationRead(convID string, lastRead time.Time) error
+	ListConversationReads() (map[string]time.Time, error)
+
+	// Enqueue runs job in the background without blocking, as
+	// *supabase.Writer does on its worker pool. It reports false, dropping
+	// job, when it can't take more work.
+	Enqueue(job func()) bool
 }
 
 type EventHandler struct {
@@ -29,11 +41,75 @@ type EventHandler struct {
 	Supabase     SupabaseSync
 	Logger       zerolog.Logger
 	SessionPath  string
+	PairingPath  string
 	Client       *Client
 	OnDisconnect OnDisconnect
+
+	// StoreRawPayloads keeps the JSON proto of messages we can't parse.
+	StoreRawPayloads bool
+
+	// AutoContacts creates a contact for inbound senders not yet in contacts.
+	AutoContacts bool
+
+	// Typing receives typing indicators. Nil drops them.
+	Typing *TypingTracker
+
+	// Deliveries is told about status changes of messages we sent.
+	Deliveries *DeliveryWaiter
+
+	// Publish, if set, is called after a message or conversation is stored.
+	// It must not block.
+	Publish func(StoreEvent)
+
+	// Gate, if set, can pause processing of inbound data events.
+	Gate *SyncGate
+
+	// avatarAsked holds participant IDs the phone has answered a photo
+	// request for, so participants without one aren't asked about on every
+	// update.
+	avatarAsked idSet
+
+	// avatarJobs feeds conversations to the single worker fetching their
+	// participants' photos; startAvatars starts it on first use.
+	avatarJobs   chan *gmproto.Conversation
+	startAvatars sync.Once
+}
+
+// avatarQueueSize is how many conversations may wait for their
+// participants' photos. Updates past it are dropped; the conversation's
+// next update asks again.
+const avatarQueueSize = 64
+
+// Store event types.
+const (
+	StoreEventMessage      = "message"
+	StoreEventConversation = "conversation"
+)
+
+// StoreEvent announces that a message or conversation was stored.
+type StoreEvent struct {
+	Type           string `json:"type"`
+	ConversationID string `json:"conversation_id"`
+	MessageID      string `json:"message_id,omitempty"`
 }
 
 func (h *EventHandler) Handle(rawEvt any) {
+	if h.Gate != nil && !h.Gate.admit(rawEvt) {
+		return
+	}
+	h.dispatch(rawEvt)
+}
+
+// ResumeSync opens the gate, first handling the events it buffered while
+// paused. It returns how many buffered events were handled.
+func (h *EventHandler) ResumeSync() int {
+	if h.Gate == nil {
+		return 0
+	}
+	return h.Gate.Resume(h.dispatch)
+}
+
+func (h *EventHandler) dispatch(rawEvt any) {
 	switch evt := rawEvt.(type) {
 	case *events.ClientReady:
 		h.handleClientReady(evt)
@@ -41,10 +117,12 @@ func (h *EventHandler) Handle(rawEvt any) {
 		h.handleMessage(evt)
 	case *gmproto.Conversation:
 		h.handleConversation(evt)
+	case *gmproto.TypingData:
+		h.handleTyping(evt)
 	case *events.AuthTokenRefreshed:
 		h.handleAuthRefresh()
 	case *events.PairSuccessful:
-		h.Logger.Info().Str("phone_id", evt.PhoneID).Msg("Pairing successful")
+		h.handlePairSuccessful(evt)
 	case *events.ListenFatalError:
 		h.Logger.Error().Err(evt.Error).Msg("Listen fatal error")
 		if h.OnDisconnect != nil {
@@ -63,6 +141,21 @@ func (h *EventHandler) Handle(rawEvt any) {
 	}
 }
 
+func (h *EventHandler) handlePairSuccessful(evt *events.PairSuccessful) {
+	h.Logger.Info().Str("phone_id", evt.PhoneID).Msg("Pairing successful")
+	if h.PairingPath == "" {
+		return
+	}
+	info, err := RecordPairing(h.PairingPath, evt.PhoneID, time.Now())
+	if err != nil {
+		h.Logger.Warn().Err(err).Msg("Failed to save pairing info")
+		return
+	}
+	if info.PhoneChanged() {
+		h.Logger.Warn().Str("previous_phone_id", info.PreviousPhoneID).Msg("Paired with a different phone than before")
+	}
+}
+
 func (h *EventHandler) handleClientReady(evt *events.ClientReady) {
 	h.Logger.Info().
 		Str("session_id", evt.SessionID).
@@ -75,147 +168,211 @@ func (h *EventHandler) handleClientReady(evt *events.ClientReady) {
 }
 
 func (h *EventHandler) handleMessage(evt *libgm.WrappedMessage) {
-	msg := evt.Message
-	body := ExtractMessageBody(msg)
-	senderName, senderNumber := ExtractSenderInfo(msg)
+	dbMsg := MessageToDB(evt.Message, h.StoreRawPayloads)
+	if dbMsg.Deleted {
+		h.handleMessageDeleted(dbMsg)
+		return
+	}
 
-	status := "unknown"
-	if ms := msg.GetMessageStatus(); ms != nil {
-		status = ms.GetStatus().String()
+	if err := h.Store.UpsertMessage(dbMsg); err != nil {
+		h.Logger.Error().Err(err).Str("msg_id", dbMsg.MessageID).Msg("Failed to store message")
+		return
 	}
 
-	dbMsg := &db.Message{
-		MessageID:      msg.GetMessageID(),
-		ConversationID: msg.GetConversationID(),
-		SenderName:     senderName,
-		SenderNumber:   senderNumber,
-		Body:           body,
-		TimestampMS:    msg.GetTimestamp() / 1000, // proto timestamp is microseconds
-		Status:         status,
-		IsFromMe:       msg.GetSenderParticipant() != nil && msg.GetSenderParticipant().GetIsMe(),
+	// History replayed during backfill would be stamped with the backfill
+	// time, so only live updates are recorded.
+	if !evt.IsOld {
+		if _, err := h.Store.RecordStatusChange(dbMsg.MessageID, dbMsg.Status, time.Now()); err != nil {
+			h.Logger.Warn().Err(err).Str("msg_id", dbMsg.MessageID).Msg("Failed to record status change")
+		}
 	}
 
-	if media := ExtractMediaInfo(msg); media != nil {
-		dbMsg.MediaID = media.MediaID
-		dbMsg.MimeType = media.MimeType
-		dbMsg.DecryptionKey = hex.EncodeToString(media.DecryptionKey)
+	if h.Deliveries != nil && dbMsg.IsFromMe {
+		h.Deliveries.Observe(evt.Message.GetTmpID(), dbMsg.MessageID, dbMsg.Status)
 	}
 
-	if reactions := ExtractReactions(msg); reactions != nil {
-		if b, err := json.Marshal(reactions); err == nil {
-			dbMsg.Reactions = string(b)
-		}
+	if h.Publish != nil {
+		h.Publish(StoreEvent{Type: StoreEventMessage, ConversationID: dbMsg.ConversationID, MessageID: dbMsg.MessageID})
 	}
-	dbMsg.ReplyToID = ExtractReplyToID(msg)
 
-	if err := h.Store.UpsertMessage(dbMsg); err != nil {
-		h.Logger.Error().Err(err).Str("msg_id", dbMsg.MessageID).Msg("Failed to store message")
-		return
+	if h.AutoContacts && !dbMsg.IsFromMe {
+		if created, err := h.Store.EnsureAutoContact(dbMsg.SenderNumber, dbMsg.SenderName); err != nil {
+			h.Logger.Warn().Err(err).Str("number", dbMsg.SenderNumber).Msg("Failed to create contact for unknown sender")
+		} else if created {
+			h.Logger.Debug().Str("number", dbMsg.SenderNumber).Msg("Created contact for unknown sender")
+		}
 	}
 
 	if h.Supabase != nil {
-		ts := time.UnixMilli(dbMsg.TimestampMS)
-		go func() {
-			if err := h.Supabase.UpsertMessage(
-				dbMsg.MessageID, dbMsg.ConversationID,
-				dbMsg.SenderName, dbMsg.SenderNumber,
-				dbMsg.Body, ts, dbMsg.IsFromMe,
-				dbMsg.MimeType, "",
-			); err != nil {
+		h.Store.MarkSyncPending(db.SyncKindMessage, dbMsg.MessageID)
+		h.syncInBackground(func() {
+			if err := SyncMessage(h.Store, h.Supabase, dbMsg); err != nil {
 				h.Logger.Warn().Err(err).Msg("Supabase message sync failed")
 			}
-		}()
+		})
 	}
 
 	// When our sent message echoes back with a real server ID, clean up the
 	// tmp_ placeholder we stored at send time to avoid duplicates in the UI.
+	// Other sends in the conversation may still be pending, so only the
+	// placeholder this echo maps to goes.
 	if dbMsg.IsFromMe && !strings.HasPrefix(dbMsg.MessageID, "tmp_") {
-		if n, err := h.Store.DeleteTmpMessages(dbMsg.ConversationID); err == nil && n > 0 {
-			h.Logger.Debug().Int64("deleted", n).Str("conv_id", dbMsg.ConversationID).Msg("Cleaned up tmp messages")
+		h.recordSendLatency(evt.Message.GetTmpID(), dbMsg)
+		if dbMsg.TmpID != "" {
+			if err := h.Store.DeleteMessage(dbMsg.TmpID); err == nil {
+				h.Logger.Debug().Str("tmp_id", dbMsg.TmpID).Str("msg_id", dbMsg.MessageID).Msg("Cleaned up tmp message")
+			} else if !errors.Is(err, sql.ErrNoRows) {
+				h.Logger.Warn().Err(err).Str("tmp_id", dbMsg.TmpID).Msg("Failed to clean up tmp message")
+			}
 		}
 	}
 
 	h.Logger.Debug().
 		Str("msg_id", dbMsg.MessageID).
-		Str("from", senderName).
+		Str("from", dbMsg.SenderName).
 		Bool("is_old", evt.IsOld).
 		Msg("Stored message")
 }
 
-func (h *EventHandler) handleConversation(conv *gmproto.Conversation) {
-	participantsJSON := "[]"
-	if ps := conv.GetParticipants(); len(ps) > 0 {
-		type pInfo struct {
-			Name   string `json:"name"`
-			Number string `json:"number"`
-			IsMe   bool   `json:"is_me,omitempty"`
-		}
-		var infos []pInfo
-		for _, p := range ps {
-			info := pInfo{
-				Name: p.GetFullName(),
-				IsMe: p.GetIsMe(),
-			}
-			if id := p.GetID(); id != nil {
-				info.Number = id.GetNumber()
-			}
-			if info.Number == "" {
-				info.Number = p.GetFormattedNumber()
-			}
-			infos = append(infos, info)
-		}
-		if b, err := json.Marshal(infos); err == nil {
-			participantsJSON = string(b)
+// handleMessageDeleted flags a message its sender unsent. The update carries
+// no content, so it isn't stored as a message of its own.
+func (h *EventHandler) handleMessageDeleted(m *db.Message) {
+	if err := h.Store.MarkMessageDeleted(m.MessageID); err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			h.Logger.Error().Err(err).Str("msg_id", m.MessageID).Msg("Failed to mark message deleted")
 		}
+		return
 	}
 
-	unread := 0
-	if conv.GetUnread() {
-		unread = 1
+	if h.Publish != nil {
+		h.Publish(StoreEvent{Type: StoreEventMessage, ConversationID: m.ConversationID, MessageID: m.MessageID})
 	}
 
-	dbConv := &db.Conversation{
-		ConversationID: conv.GetConversationID(),
-		Name:           conv.GetName(),
-		IsGroup:        conv.GetIsGroupChat(),
-		Participants:   participantsJSON,
-		LastMessageTS:  conv.GetLastMessageTimestamp() / 1000, // microseconds to milliseconds
-		UnreadCount:    unread,
+	if h.Supabase != nil {
+		h.Store.MarkSyncPending(db.SyncKindMessage, m.MessageID)
+		h.syncInBackground(func() {
+			deleted := &db.Message{MessageID: m.MessageID, ConversationID: m.ConversationID, Deleted: true}
+			if err := SyncMessage(h.Store, h.Supabase, deleted); err != nil {
+				h.Logger.Warn().Err(err).Str("msg_id", m.MessageID).Msg("Supabase message deletion sync failed")
+			}
+		})
 	}
 
+	h.Logger.Debug().Str("msg_id", m.MessageID).Msg("Message deleted by sender")
+}
+
+// recordSendLatency records how long the echo of a message we sent took,
+// measured from when its tmp_ placeholder was stored.
+func (h *EventHandler) recordSendLatency(tmpID string, echo *db.Message) {
+	if !strings.HasPrefix(tmpID, "tmp_") {
+		return
+	}
+	tmp, err := h.Store.GetMessageByID(tmpID)
+	if err != nil || tmp == nil || tmp.TimestampMS <= 0 {
+		return
+	}
+	latency := time.Since(time.UnixMilli(tmp.TimestampMS))
+	if err := h.Store.RecordSendLatency(echo.MessageID, echo.ConversationID, latency); err != nil {
+		h.Logger.Warn().Err(err).Str("msg_id", echo.MessageID).Msg("Failed to record send latency")
+	}
+}
+
+func (h *EventHandler) handleConversation(conv *gmproto.Conversation) {
+	dbConv := ConversationToDB(conv, h.Store)
+
+	// The store holds muted conversations' unread count at zero.
 	if err := h.Store.UpsertConversation(dbConv); err != nil {
 		h.Logger.Error().Err(err).Str("conv_id", dbConv.ConversationID).Msg("Failed to store conversation")
 		return
 	}
 
+	if h.Publish != nil {
+		h.Publish(StoreEvent{Type: StoreEventConversation, ConversationID: dbConv.ConversationID})
+	}
+
+	if h.Client != nil && h.Client.GM != nil {
+		h.queueAvatars(conv)
+	}
+
 	if h.Supabase != nil {
-		ts := time.UnixMilli(dbConv.LastMessageTS)
-		go func() {
-			if err := h.Supabase.UpsertConversation(
-				dbConv.ConversationID, dbConv.Name,
-				ts, dbConv.IsGroup, "",
-			); err != nil {
+		h.Store.MarkSyncPending(db.SyncKindConversation, dbConv.ConversationID)
+		h.syncInBackground(func() {
+			if err := SyncConversation(h.Store, h.Supabase, dbConv); err != nil {
 				h.Logger.Warn().Err(err).Msg("Supabase conversation sync failed")
 			}
-			// Sync participant contacts
-			var participants []struct {
-				Name   string `json:"name"`
-				Number string `json:"number"`
-				IsMe   bool   `json:"is_me,omitempty"`
-			}
-			if err := json.Unmarshal([]byte(participantsJSON), &participants); err == nil {
-				for _, p := range participants {
-					if p.Number != "" && !p.IsMe {
-						h.Supabase.UpsertContact(p.Number, p.Name)
-					}
-				}
-			}
-		}()
+		})
 	}
 
 	h.Logger.Debug().Str("conv_id", dbConv.ConversationID).Str("name", dbConv.Name).Msg("Stored conversation")
 }
 
+// queueAvatars hands conv to the avatar worker without blocking.
+func (h *EventHandler) queueAvatars(conv *gmproto.Conversation) {
+	h.startAvatars.Do(func() {
+		h.avatarJobs = make(chan *gmproto.Conversation, avatarQueueSize)
+		go h.fetchAvatars(h.Client.GM)
+	})
+	select {
+	case h.avatarJobs <- conv:
+	default:
+		h.Logger.Debug().Str("conv_id", conv.GetConversationID()).Msg("Avatar queue full, skipped participant photos")
+	}
+}
+
+// fetchAvatars fetches the photos of queued conversations' participants one
+// conversation at a time. A participant is only remembered as asked about
+// once the phone has answered, so a failed request is tried again.
+func (h *EventHandler) fetchAvatars(gm ParticipantThumbnailer) {
+	for conv := range h.avatarJobs {
+		asked, err := FetchParticipantAvatars(h.Store, gm, conv, h.avatarAsked.has)
+		h.avatarAsked.add(asked...)
+		if err != nil {
+			h.Logger.Warn().Err(err).Str("conv_id", conv.GetConversationID()).Msg("Failed to fetch participant avatars")
+		}
+	}
+}
+
+// syncInBackground runs a Supabase write on the writer's queue. A write the
+// queue refuses, because it is full or closed, stays pending in the store
+// for the sync retrier, so the event handler never waits on Supabase.
+func (h *EventHandler) syncInBackground(job func()) {
+	if !h.Supabase.Enqueue(job) {
+		h.Logger.Debug().Msg("Supabase queue full, write left pending for retry")
+	}
+}
+
+func (h *EventHandler) handleTyping(evt *gmproto.TypingData) {
+	if h.Typing == nil {
+		return
+	}
+	convID := evt.GetConversationID()
+	if evt.GetType() != gmproto.TypingTypes_STARTED_TYPING {
+		h.Typing.Stop(convID)
+		return
+	}
+	number := evt.GetUser().GetNumber()
+	h.Typing.Start(convID, number, h.typingName(convID, number))
+}
+
+// typingName resolves a typing participant's display name from the
+// conversation, preferring the saved contact name.
+func (h *EventHandler) typingName(convID, number string) string {
+	ps, err := h.Store.ConversationParticipants(convID)
+	if err != nil {
+		return ""
+	}
+	for _, p := range ps {
+		if p.IsMe || !h.Store.NumbersMatch(p.Number, number) {
+			continue
+		}
+		if p.ContactName != "" {
+			return p.ContactName
+		}
+		return p.Name
+	}
+	return ""
+}
+
 func (h *EventHandler) handleAuthRefresh() {
 	if h.Client == nil || h.SessionPath == "" {
 		return
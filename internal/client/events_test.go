@@ -0,0 +1,466 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"go.mau.fi/mautrix-gmessages/pkg/libgm"
+	"go.mau.fi/mautrix-gmessages/pkg/libgm/gmproto"
+
+	"github.com/maxghenis/openmessage/internal/db"
+)
+
+func newTestHandler(t *testing.T) *EventHandler {
+	t.Helper()
+	store, err := db.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return &EventHandler{Store: store, Logger: zerolog.Nop()}
+}
+
+// unparseableMessage has no text or media content, like a poll or rich card.
+func unparseableMessage(id string) *libgm.WrappedMessage {
+	return &libgm.WrappedMessage{Message: &gmproto.Message{
+		MessageID:      id,
+		ConversationID: "c1",
+		Timestamp:      1_700_000_000_000_000,
+		MessageInfo: []*gmproto.MessageInfo{
+			{ActionMessageID: strPtr("poll-1")},
+		},
+	}}
+}
+
+func TestHandleMessage_StoresRawPayload(t *testing.T) {
+	h := newTestHandler(t)
+	h.StoreRawPayloads = true
+
+	h.Handle(unparseableMessage("m1"))
+
+	got, err := h.Store.GetMessageByID("m1")
+	if err != nil || got == nil {
+		t.Fatalf("get message: %v, %v", got, err)
+	}
+	if got.RawPayload == "" {
+		t.Fatal("expected raw payload for unparseable message")
+	}
+}
+
+func TestHandleMessage_RawPayloadDisabled(t *testing.T) {
+	h := newTestHandler(t)
+
+	h.Handle(unparseableMessage("m1"))
+
+	got, err := h.Store.GetMessageByID("m1")
+	if err != nil || got == nil {
+		t.Fatalf("get message: %v, %v", got, err)
+	}
+	if got.RawPayload != "" {
+		t.Errorf("expected no raw payload when disabled, got %q", got.RawPayload)
+	}
+}
+
+func TestHandleMessage_TextSkipsRawPayload(t *testing.T) {
+	h := newTestHandler(t)
+	h.StoreRawPayloads = true
+
+	h.Handle(&libgm.WrappedMessage{Message: &gmproto.Message{
+		MessageID:      "m1",
+		ConversationID: "c1",
+		MessageInfo: []*gmproto.MessageInfo{
+			{Data: &gmproto.MessageInfo_MessageContent{
+				MessageContent: &gmproto.MessageContent{Content: "hello"},
+			}},
+		},
+	}})
+
+	got, _ := h.Store.GetMessageByID("m1")
+	if got == nil || got.RawPayload != "" {
+		t.Errorf("expected text message without raw payload, got %+v", got)
+	}
+}
+
+func inboundMessage(id, number string) *libgm.WrappedMessage {
+	return &libgm.WrappedMessage{Message: &gmproto.Message{
+		MessageID:      id,
+		ConversationID: "c1",
+		SenderParticipant: &gmproto.Participant{
+			ID: &gmproto.SmallInfo{Number: number},
+		},
+		MessageInfo: []*gmproto.MessageInfo{
+			{Data: &gmproto.MessageInfo_MessageContent{
+				MessageContent: &gmproto.MessageContent{Content: "hi"},
+			}},
+		},
+	}}
+}
+
+func TestHandleMessage_AutoContacts(t *testing.T) {
+	h := newTestHandler(t)
+	h.AutoContacts = true
+
+	h.Handle(inboundMessage("m1", "+15559999999"))
+	h.Handle(inboundMessage("m2", "+15559999999"))
+
+	contacts, err := h.Store.ListContacts("", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(contacts) != 1 || contacts[0].Number != "+15559999999" {
+		t.Fatalf("expected exactly one contact for the sender, got %+v", contacts)
+	}
+}
+
+func TestHandleMessage_AutoContactsDisabled(t *testing.T) {
+	h := newTestHandler(t)
+
+	h.Handle(inboundMessage("m1", "+15559999999"))
+
+	contacts, _ := h.Store.ListContacts("", 10)
+	if len(contacts) != 0 {
+		t.Errorf("expected no contacts when disabled, got %+v", contacts)
+	}
+}
+
+func TestHandleMessage_RecordsSendLatency(t *testing.T) {
+	h := newTestHandler(t)
+
+	sentAt := time.Now().Add(-1500 * time.Millisecond)
+	h.Store.UpsertMessage(&db.Message{
+		MessageID: "tmp_000000000001", ConversationID: "c1", Body: "hi",
+		IsFromMe: true, TimestampMS: sentAt.UnixMilli(), Status: "sending",
+	})
+
+	h.Handle(&libgm.WrappedMessage{Message: &gmproto.Message{
+		MessageID:         "real-1",
+		TmpID:             "tmp_000000000001",
+		ConversationID:    "c1",
+		Timestamp:         time.Now().UnixMicro(),
+		SenderParticipant: &gmproto.Participant{IsMe: true},
+		MessageInfo: []*gmproto.MessageInfo{
+			{Data: &gmproto.MessageInfo_MessageContent{
+				MessageContent: &gmproto.MessageContent{Content: "hi"},
+			}},
+		},
+	}})
+
+	stats, err := h.Store.SendLatencyStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Samples != 1 {
+		t.Fatalf("samples = %d, want 1", stats.Samples)
+	}
+	if stats.MaxMS < 1500 || stats.MaxMS > 10000 {
+		t.Errorf("latency = %dms, want about 1500ms", stats.MaxMS)
+	}
+	if tmp, _ := h.Store.GetMessageByID("tmp_000000000001"); tmp != nil {
+		t.Error("tmp placeholder should be removed after the echo")
+	}
+}
+
+func TestHandleMessage_ReconcilesTmpID(t *testing.T) {
+	h := newTestHandler(t)
+	h.Store.UpsertMessage(&db.Message{
+		MessageID: "tmp_000000000002", ConversationID: "c1", Body: "hi", IsFromMe: true, Status: "OUTGOING_SENDING",
+	})
+	if m, _ := h.Store.GetMessageByTmpID("tmp_000000000002"); m == nil || m.MessageID != "tmp_000000000002" {
+		t.Fatalf("before echo: got %+v, want the placeholder", m)
+	}
+
+	echo := &gmproto.Message{
+		MessageID:         "real-2",
+		TmpID:             "tmp_000000000002",
+		ConversationID:    "c1",
+		Timestamp:         time.Now().UnixMicro(),
+		SenderParticipant: &gmproto.Participant{IsMe: true},
+		MessageStatus:     &gmproto.MessageStatus{Status: gmproto.MessageStatusType_OUTGOING_COMPLETE},
+	}
+	h.Handle(&libgm.WrappedMessage{Message: echo})
+
+	m, err := h.Store.GetMessageByTmpID("tmp_000000000002")
+	if err != nil || m == nil || m.MessageID != "real-2" || m.TmpID != "tmp_000000000002" {
+		t.Fatalf("after echo: got %+v, %v, want real-2", m, err)
+	}
+
+	// Later updates that don't carry the tmp ID keep the mapping.
+	echo.TmpID = ""
+	echo.MessageStatus.Status = gmproto.MessageStatusType_OUTGOING_DELIVERED
+	h.Handle(&libgm.WrappedMessage{Message: echo})
+	if m, _ := h.Store.GetMessageByTmpID("tmp_000000000002"); m == nil || m.MessageID != "real-2" {
+		t.Errorf("after update: got %+v, want real-2", m)
+	}
+}
+
+func TestHandleMessage_KeepsOtherPendingPlaceholders(t *testing.T) {
+	h := newTestHandler(t)
+	for _, id := range []string{"tmp_000000000003", "tmp_000000000004"} {
+		h.Store.UpsertMessage(&db.Message{
+			MessageID: id, ConversationID: "c1", Body: "hi", IsFromMe: true, Status: StatusUploading,
+		})
+	}
+
+	h.Handle(&libgm.WrappedMessage{Message: &gmproto.Message{
+		MessageID:         "real-3",
+		TmpID:             "tmp_000000000003",
+		ConversationID:    "c1",
+		Timestamp:         time.Now().UnixMicro(),
+		SenderParticipant: &gmproto.Participant{IsMe: true},
+		MessageStatus:     &gmproto.MessageStatus{Status: gmproto.MessageStatusType_OUTGOING_COMPLETE},
+	}})
+
+	if m, _ := h.Store.GetMessageByID("tmp_000000000003"); m != nil {
+		t.Error("echoed placeholder should be removed")
+	}
+	if m, _ := h.Store.GetMessageByID("tmp_000000000004"); m == nil {
+		t.Error("placeholder for a send still in flight should be kept")
+	}
+}
+
+func TestHandleMessage_RecordsStatusTransitions(t *testing.T) {
+	h := newTestHandler(t)
+	send := func(status gmproto.MessageStatusType, old bool) {
+		h.Handle(&libgm.WrappedMessage{IsOld: old, Message: &gmproto.Message{
+			MessageID:         "m1",
+			ConversationID:    "c1",
+			Timestamp:         time.Now().UnixMicro(),
+			SenderParticipant: &gmproto.Participant{IsMe: true},
+			MessageStatus:     &gmproto.MessageStatus{Status: status},
+		}})
+	}
+
+	send(gmproto.MessageStatusType_OUTGOING_COMPLETE, true) // backfill: not recorded
+	send(gmproto.MessageStatusType_OUTGOING_COMPLETE, false)
+	send(gmproto.MessageStatusType_OUTGOING_COMPLETE, false) // unchanged
+	send(gmproto.MessageStatusType_OUTGOING_DELIVERED, false)
+	send(gmproto.MessageStatusType_OUTGOING_DISPLAYED, false)
+
+	events, err := h.Store.GetStatusHistory("m1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"OUTGOING_COMPLETE", "OUTGOING_DELIVERED", "OUTGOING_DISPLAYED"}
+	if len(events) != len(want) {
+		t.Fatalf("events = %+v, want statuses %v", events, want)
+	}
+	for i, e := range events {
+		if e.Status != want[i] || e.TimestampMS == 0 {
+			t.Errorf("event %d = %+v, want %s", i, e, want[i])
+		}
+	}
+}
+
+func TestHandleMessage_SenderDeletion(t *testing.T) {
+	h := newTestHandler(t)
+	sb := &fakeSupabase{}
+	h.Supabase = sb
+	h.Store.UpsertMessage(&db.Message{MessageID: "m1", ConversationID: "c1", Body: "oops", MediaID: "media-1", TimestampMS: 1000})
+	h.Store.UpsertMessage(&db.Message{MessageID: "m2", ConversationID: "c1", Body: "reply", ReplyToID: "m1", TimestampMS: 2000})
+
+	h.Handle(&libgm.WrappedMessage{Message: &gmproto.Message{
+		MessageID:      "m1",
+		ConversationID: "c1",
+		MessageStatus:  &gmproto.MessageStatus{Status: gmproto.MessageStatusType_MESSAGE_DELETED},
+	}})
+
+	got, err := h.Store.GetMessageByID("m1")
+	if err != nil || got == nil {
+		t.Fatalf("get message: %v, %v", got, err)
+	}
+	if !got.Deleted || got.Body != "" || got.MediaID != "" {
+		t.Errorf("message = %+v, want flagged deleted with content cleared", got)
+	}
+	if got.TimestampMS != 1000 {
+		t.Errorf("timestamp = %d, want the original kept", got.TimestampMS)
+	}
+	if results, _ := h.Store.SearchMessages("oops", "", 10); len(results) != 0 {
+		t.Errorf("deleted message still searchable: %+v", results)
+	}
+
+	// A later sync of the same message doesn't resurrect it.
+	h.Store.UpsertMessage(&db.Message{MessageID: "m1", ConversationID: "c1", TimestampMS: 1000})
+	if got, _ := h.Store.GetMessageByID("m1"); !got.Deleted {
+		t.Error("deleted flag lost on re-sync")
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		sb.mu.Lock()
+		n := len(sb.deleted)
+		sb.mu.Unlock()
+		if n == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("deletion not pushed to Supabase: %v", sb.deleted)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// Deletions of messages we never stored are ignored.
+	h.Handle(&libgm.WrappedMessage{Message: &gmproto.Message{
+		MessageID:      "unknown",
+		ConversationID: "c1",
+		MessageStatus:  &gmproto.MessageStatus{Status: gmproto.MessageStatusType_MESSAGE_DELETED},
+	}})
+	if got, _ := h.Store.GetMessageByID("unknown"); got != nil {
+		t.Errorf("stored a tombstone for an unknown message: %+v", got)
+	}
+}
+
+func TestHandleConversation_PreservesColor(t *testing.T) {
+	h := newTestHandler(t)
+	h.Handle(&gmproto.Conversation{ConversationID: "c1", Name: "Alice"})
+	if err := h.Store.SetConversationColor("c1", "#a142f4"); err != nil {
+		t.Fatal(err)
+	}
+
+	h.Handle(&gmproto.Conversation{ConversationID: "c1", Name: "Alice Smith", Unread: true})
+
+	c, err := h.Store.GetConversation("c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Color != "#a142f4" || c.Name != "Alice Smith" {
+		t.Errorf("after sync: color=%q name=%q", c.Color, c.Name)
+	}
+}
+
+func TestHandleTyping(t *testing.T) {
+	h := newTestHandler(t)
+	h.Typing = NewTypingTracker(TypingTTL)
+	now := time.Now()
+	h.Typing.now = func() time.Time { return now }
+	h.Store.UpsertConversation(&db.Conversation{ConversationID: "c1",
+		Participants: `[{"name":"Alice","number":"+15551234567"}]`})
+
+	h.Handle(&gmproto.TypingData{
+		ConversationID: "c1",
+		User:           &gmproto.User{Number: "+15551234567"},
+		Type:           gmproto.TypingTypes_STARTED_TYPING,
+	})
+	active := h.Typing.Active()
+	if len(active) != 1 || active[0].ConversationID != "c1" || active[0].Name != "Alice" {
+		t.Fatalf("active = %+v, want Alice typing in c1", active)
+	}
+
+	h.Handle(&gmproto.TypingData{ConversationID: "c1", Type: gmproto.TypingTypes_STOPPED_TYPING})
+	if active := h.Typing.Active(); len(active) != 0 {
+		t.Errorf("after stop: %+v", active)
+	}
+
+	// Without a stop event the indicator expires on its own.
+	h.Handle(&gmproto.TypingData{ConversationID: "c1", Type: gmproto.TypingTypes_STARTED_TYPING})
+	now = now.Add(TypingTTL)
+	if active := h.Typing.Active(); len(active) != 0 {
+		t.Errorf("after expiry: %+v", active)
+	}
+}
+
+func TestHandleMessage_ResolvesDeliveryWatch(t *testing.T) {
+	h := newTestHandler(t)
+	h.Deliveries = NewDeliveryWaiter()
+	watch := h.Deliveries.Watch("tmp_000000000001")
+	defer watch.Close()
+
+	echo := func(tmpID string, status gmproto.MessageStatusType) {
+		h.Handle(&libgm.WrappedMessage{Message: &gmproto.Message{
+			MessageID:         "real-1",
+			TmpID:             tmpID,
+			ConversationID:    "c1",
+			Timestamp:         time.Now().UnixMicro(),
+			SenderParticipant: &gmproto.Participant{IsMe: true},
+			MessageStatus:     &gmproto.MessageStatus{Status: status},
+		}})
+	}
+	go func() {
+		echo("tmp_000000000001", gmproto.MessageStatusType_OUTGOING_COMPLETE)
+		// Later status updates carry only the server ID.
+		echo("", gmproto.MessageStatusType_OUTGOING_DELIVERED)
+	}()
+
+	u, final := watch.Wait(context.Background(), 5*time.Second)
+	if !final || u.Status != "OUTGOING_DELIVERED" || u.MessageID != "real-1" {
+		t.Errorf("wait = %+v (final %v), want real-1 OUTGOING_DELIVERED", u, final)
+	}
+}
+
+func TestDeliveryWatchTimesOut(t *testing.T) {
+	dw := NewDeliveryWaiter()
+	watch := dw.Watch("tmp_1")
+	defer watch.Close()
+	dw.Observe("tmp_1", "real-1", "OUTGOING_COMPLETE")
+
+	u, final := watch.Wait(context.Background(), 20*time.Millisecond)
+	if final || u.Status != "OUTGOING_COMPLETE" {
+		t.Errorf("wait = %+v (final %v), want last status OUTGOING_COMPLETE, not final", u, final)
+	}
+}
+
+func TestDeliveryWatchKeepsNewestWhenFull(t *testing.T) {
+	dw := NewDeliveryWaiter()
+	watch := dw.Watch("tmp_1")
+	defer watch.Close()
+	for i := 0; i < cap(watch.updates)+3; i++ {
+		dw.Observe("tmp_1", "real-1", "OUTGOING_COMPLETE")
+	}
+	dw.Observe("tmp_1", "real-1", "OUTGOING_DELIVERED")
+
+	u, final := watch.Wait(context.Background(), 20*time.Millisecond)
+	if !final || u.Status != "OUTGOING_DELIVERED" {
+		t.Errorf("wait = %+v (final %v), want the newest status OUTGOING_DELIVERED", u, final)
+	}
+}
+
+func TestHandle_PublishesStoreEvents(t *testing.T) {
+	h := newTestHandler(t)
+	var got []StoreEvent
+	h.Publish = func(e StoreEvent) { got = append(got, e) }
+
+	h.Handle(inboundMessage("m1", "+15559999999"))
+	h.Handle(&gmproto.Conversation{ConversationID: "c9", Name: "Bob"})
+
+	if len(got) != 2 {
+		t.Fatalf("events = %+v, want 2", got)
+	}
+	if got[0].Type != StoreEventMessage || got[0].MessageID != "m1" || got[0].ConversationID == "" {
+		t.Errorf("message event = %+v", got[0])
+	}
+	if got[1] != (StoreEvent{Type: StoreEventConversation, ConversationID: "c9"}) {
+		t.Errorf("conversation event = %+v", got[1])
+	}
+}
+
+func TestHandleConversation_CachesTransport(t *testing.T) {
+	h := newTestHandler(t)
+	h.Handle(&gmproto.Conversation{ConversationID: "c1", Type: gmproto.ConversationType_RCS})
+	h.Handle(&gmproto.Conversation{ConversationID: "c2", Type: gmproto.ConversationType_SMS})
+
+	for id, want := range map[string]string{"c1": db.TransportRCS, "c2": db.TransportSMS} {
+		if c, _ := h.Store.GetConversation(id); c == nil || c.Transport != want {
+			t.Errorf("%s transport = %+v, want %s", id, c, want)
+		}
+	}
+}
+
+func TestHandleConversation_MutedStaysRead(t *testing.T) {
+	h := newTestHandler(t)
+	h.Handle(&gmproto.Conversation{ConversationID: "c1", Name: "Group"})
+	if err := h.Store.SetConversationMuted("c1", true); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		h.Handle(&gmproto.Conversation{ConversationID: "c1", Name: "Group", Unread: true, LastMessageTimestamp: int64(i+1) * 1000})
+	}
+	c, _ := h.Store.GetConversation("c1")
+	if c.UnreadCount != 0 {
+		t.Errorf("unread = %d, want 0 for muted conversation", c.UnreadCount)
+	}
+	if n, _ := h.Store.TotalUnread(); n != 0 {
+		t.Errorf("TotalUnread = %d, want 0", n)
+	}
+}
@@ -0,0 +1,112 @@
+package client
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/maxghenis/openmessage/internal/db"
+)
+
+// ExportRecord is one message in a JSON or CSV export.
+type ExportRecord struct {
+	MessageID    string `json:"message_id"`
+	Timestamp    string `json:"timestamp"`
+	TimestampMS  int64  `json:"timestamp_ms"`
+	Sender       string `json:"sender"`
+	SenderNumber string `json:"sender_number,omitempty"`
+	Direction    string `json:"direction"` // "incoming" or "outgoing"
+	Body         string `json:"body"`
+	HasMedia     bool   `json:"has_media"`
+	MimeType     string `json:"mime_type,omitempty"`
+}
+
+func newExportRecord(m *db.Message) ExportRecord {
+	rec := ExportRecord{
+		MessageID:    m.MessageID,
+		TimestampMS:  m.TimestampMS,
+		Sender:       ExportSender(m),
+		SenderNumber: m.SenderNumber,
+		Direction:    "incoming",
+		Body:         m.Body,
+		HasMedia:     m.MediaID != "",
+	}
+	if m.TimestampMS > 0 {
+		rec.Timestamp = time.UnixMilli(m.TimestampMS).UTC().Format(time.RFC3339)
+	}
+	if m.IsFromMe {
+		rec.Direction = "outgoing"
+	}
+	if rec.HasMedia {
+		rec.MimeType = m.MimeType
+	}
+	return rec
+}
+
+// ExportSender names who sent a message: "Me", the sender's name, or
+// failing that their number.
+func ExportSender(m *db.Message) string {
+	if m.IsFromMe {
+		return "Me"
+	}
+	if m.SenderName != "" {
+		return m.SenderName
+	}
+	return m.SenderNumber
+}
+
+// WriteConversationJSON writes a conversation and all its stored messages,
+// oldest first, as {"conversation": ..., "messages": [...]}. Messages are
+// encoded as they are read rather than collected first.
+func WriteConversationJSON(w io.Writer, store *db.Store, conv *db.Conversation) error {
+	head, err := json.Marshal(conv)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, `{"conversation":%s,"messages":[`, head); err != nil {
+		return err
+	}
+	sep := ""
+	err = store.EachConversationMessage(conv.ConversationID, func(m *db.Message) error {
+		rec, err := json.Marshal(newExportRecord(m))
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n%s", sep, rec)
+		sep = ","
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "]}\n")
+	return err
+}
+
+// exportCSVHeader names the columns written by WriteConversationCSV.
+var exportCSVHeader = []string{"timestamp", "sender", "sender_number", "direction", "body", "has_media", "mime_type", "message_id"}
+
+// WriteConversationCSV writes a conversation's stored messages, oldest first,
+// one row per message. encoding/csv quotes bodies containing commas, quotes
+// or newlines.
+func WriteConversationCSV(w io.Writer, store *db.Store, convID string) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(exportCSVHeader); err != nil {
+		return err
+	}
+	err := store.EachConversationMessage(convID, func(m *db.Message) error {
+		rec := newExportRecord(m)
+		return cw.Write([]string{
+			rec.Timestamp, rec.Sender, rec.SenderNumber, rec.Direction, rec.Body,
+			strconv.FormatBool(rec.HasMedia), rec.MimeType, rec.MessageID,
+		})
+	})
+	if err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
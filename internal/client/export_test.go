@@ -0,0 +1,29 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/maxghenis/openmessage/internal/db"
+)
+
+func TestWriteConversationJSONEmpty(t *testing.T) {
+	store, err := db.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	var buf bytes.Buffer
+	if err := WriteConversationJSON(&buf, store, &db.Conversation{ConversationID: "c1"}); err != nil {
+		t.Fatal(err)
+	}
+	var out map[string]json.RawMessage
+	if err := json.Unmarshal(buf.Bytes(), &out); err != nil {
+		t.Fatalf("invalid JSON %q: %v", buf.String(), err)
+	}
+	if string(out["messages"]) != "[]" {
+		t.Errorf("messages = %s, want []", out["messages"])
+	}
+}
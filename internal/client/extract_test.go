@@ -4,6 +4,8 @@ import (
 	"testing"
 
 	"go.mau.fi/mautrix-gmessages/pkg/libgm/gmproto"
+
+	"github.com/maxghenis/openmessage/internal/db"
 )
 
 func TestExtractMediaInfo_NoMedia(t *testing.T) {
@@ -92,6 +94,77 @@ func TestExtractMediaInfo_TextAndImage(t *testing.T) {
 	}
 }
 
+func TestMediaSubtype(t *testing.T) {
+	tests := []struct {
+		mime, name, want string
+	}{
+		{"image/gif", "funny.gif", db.SubtypeGIF},
+		{"IMAGE/GIF", "", db.SubtypeGIF},
+		{"image/webp", "sticker_1234.webp", db.SubtypeSticker},
+		{"image/png", "Sticker.png", db.SubtypeSticker},
+		{"image/gif", "sticker_party.gif", db.SubtypeSticker},
+		{"application/vnd.example.sticker+png", "", db.SubtypeSticker},
+		{"image/jpeg", "photo.jpg", ""},
+		{"image/webp", "IMG_0001.webp", ""},
+		{"video/mp4", "clip.mp4", ""},
+		{"application/pdf", "sticker-prices.pdf", ""},
+	}
+	for _, tt := range tests {
+		if got := MediaSubtype(tt.mime, tt.name); got != tt.want {
+			t.Errorf("MediaSubtype(%q, %q) = %q, want %q", tt.mime, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMessageToDBSubtype(t *testing.T) {
+	media := func(mime, name string) *gmproto.Message {
+		return &gmproto.Message{
+			MessageID: "m1",
+			MessageInfo: []*gmproto.MessageInfo{
+				{Data: &gmproto.MessageInfo_MediaContent{
+					MediaContent: &gmproto.MediaContent{MediaID: "mid-1", MimeType: mime, MediaName: name},
+				}},
+			},
+		}
+	}
+	if m := MessageToDB(media("image/webp", "sticker_42.webp"), false); m.Subtype != db.SubtypeSticker || m.MimeType != "image/webp" {
+		t.Errorf("sticker: Subtype = %q, MimeType = %q", m.Subtype, m.MimeType)
+	}
+	if m := MessageToDB(media("image/gif", "dance.gif"), false); m.Subtype != db.SubtypeGIF {
+		t.Errorf("GIF: Subtype = %q, want %q", m.Subtype, db.SubtypeGIF)
+	}
+	if m := MessageToDB(media("image/jpeg", "photo.jpg"), false); m.Subtype != "" || m.MediaID != "mid-1" {
+		t.Errorf("photo: Subtype = %q, MediaID = %q", m.Subtype, m.MediaID)
+	}
+}
+
+func TestMessageToDBVideoThumbnail(t *testing.T) {
+	msg := &gmproto.Message{
+		MessageID: "m1",
+		MessageInfo: []*gmproto.MessageInfo{
+			{Data: &gmproto.MessageInfo_MediaContent{
+				MediaContent: &gmproto.MediaContent{
+					MediaID:                "mid-video",
+					MimeType:               "video/mp4",
+					DecryptionKey:          []byte{0x01},
+					ThumbnailMediaID:       "mid-poster",
+					ThumbnailDecryptionKey: []byte{0xab, 0xcd},
+				},
+			}},
+		},
+	}
+	m := MessageToDB(msg, false)
+	if m.ThumbnailMediaID != "mid-poster" || m.ThumbnailDecryptionKey != "abcd" {
+		t.Errorf("thumbnail = %q, %q", m.ThumbnailMediaID, m.ThumbnailDecryptionKey)
+	}
+
+	// When only the thumbnail was sent it stands in for the media itself.
+	msg.MessageInfo[0].GetMediaContent().MediaID = ""
+	if m := MessageToDB(msg, false); m.MediaID != "mid-poster" || m.ThumbnailMediaID != "" {
+		t.Errorf("thumbnail only: MediaID = %q, ThumbnailMediaID = %q", m.MediaID, m.ThumbnailMediaID)
+	}
+}
+
 func TestExtractReactions_None(t *testing.T) {
 	msg := &gmproto.Message{}
 	reactions := ExtractReactions(msg)
@@ -149,4 +222,139 @@ func TestExtractReplyToID_WithReply(t *testing.T) {
 	}
 }
 
+func TestExtractLocation_PushLocation(t *testing.T) {
+	doc := `<?xml version="1.0" encoding="UTF-8"?>
+<rcsenvelope xmlns="urn:gsma:params:xml:ns:rcs:rcs:geolocation" xmlns:gp="urn:ietf:params:xml:ns:pidf:geopriv10" xmlns:gml="http://www.opengis.net/gml" xmlns:gs="http://www.opengis.net/pidflo/1.0">
+<rcspushlocation id="a1" label="Blue Bottle Coffee">
+<gp:geopriv><gp:location-info><gs:Circle srsName="urn:ogc:def:crs:EPSG::4326">
+<gml:pos>37.7763 -122.4233</gml:pos>
+<gs:radius uom="urn:ogc:def:uom:EPSG::9001">10</gs:radius>
+</gs:Circle></gp:location-info></gp:geopriv>
+</rcspushlocation>
+</rcsenvelope>`
+	msg := &gmproto.Message{
+		MessageID: "m1",
+		MessageInfo: []*gmproto.MessageInfo{
+			{Data: &gmproto.MessageInfo_MediaContent{
+				MediaContent: &gmproto.MediaContent{
+					MimeType:  "application/vnd.gsma.rcspushlocation+xml",
+					MediaData: []byte(doc),
+				},
+			}},
+		},
+	}
+	loc := ExtractLocation(msg)
+	if loc == nil {
+		t.Fatal("expected location, got nil")
+	}
+	if loc.Latitude != 37.7763 || loc.Longitude != -122.4233 || loc.Name != "Blue Bottle Coffee" {
+		t.Errorf("location = %+v", loc)
+	}
+	if got := MessageToDB(msg, false).Location; got == nil || *got != *loc {
+		t.Errorf("MessageToDB Location = %+v, want %+v", got, loc)
+	}
+}
+
+func TestExtractLocation_MapsLink(t *testing.T) {
+	tests := []struct {
+		body string
+		want *db.Location
+	}{
+		{"Ferry Building\nhttps://maps.google.com/?q=37.7955,-122.3937", &db.Location{Latitude: 37.7955, Longitude: -122.3937, Name: "Ferry Building"}},
+		{"https://www.google.com/maps/@-33.8568,151.2153,15z", &db.Location{Latitude: -33.8568, Longitude: 151.2153}},
+		{"https://www.google.com/maps/search/?api=1&query=48.8584%2C2.2945", &db.Location{Latitude: 48.8584, Longitude: 2.2945}},
+		{"https://www.google.com/maps/place/Eiffel+Tower", nil},
+		{"https://maps.google.com/?q=123,45", nil},
+		{"meet at 37.7955,-122.3937", nil},
+	}
+	for _, tt := range tests {
+		msg := &gmproto.Message{
+			MessageInfo: []*gmproto.MessageInfo{
+				{Data: &gmproto.MessageInfo_MessageContent{
+					MessageContent: &gmproto.MessageContent{Content: tt.body},
+				}},
+			},
+		}
+		got := ExtractLocation(msg)
+		if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+			t.Errorf("ExtractLocation(%q) = %+v, want %+v", tt.body, got, tt.want)
+		}
+	}
+}
+
 func strPtr(s string) *string { return &s }
+
+func TestConversationName(t *testing.T) {
+	contacts := ContactNamer(func(number string) string {
+		if number == "+15551110000" {
+			return "Mom"
+		}
+		return ""
+	})
+	other := func(full, formatted, number string) *gmproto.Participant {
+		return &gmproto.Participant{
+			FullName:        full,
+			FormattedNumber: formatted,
+			ID:              &gmproto.SmallInfo{Number: number},
+		}
+	}
+	me := &gmproto.Participant{IsMe: true, FullName: "Me", ID: &gmproto.SmallInfo{Number: "+15559990000"}}
+
+	tests := []struct {
+		name string
+		conv *gmproto.Conversation
+		want string
+	}{
+		{"conversation name", &gmproto.Conversation{Name: "Family", Participants: []*gmproto.Participant{other("Ann", "", "+15551110000")}}, "Family"},
+		{"contact name", &gmproto.Conversation{Participants: []*gmproto.Participant{me, other("Ann", "(555) 111-0000", "+15551110000")}}, "Mom"},
+		{"full name", &gmproto.Conversation{Participants: []*gmproto.Participant{other("Bob Lee", "(555) 222-0000", "+15552220000")}}, "Bob Lee"},
+		{"formatted number", &gmproto.Conversation{Participants: []*gmproto.Participant{other("", "(555) 222-0000", "+15552220000")}}, "(555) 222-0000"},
+		{"raw number", &gmproto.Conversation{Participants: []*gmproto.Participant{other("", "", "+15552220000")}}, "+15552220000"},
+		{"unnamed group", &gmproto.Conversation{IsGroupChat: true, Participants: []*gmproto.Participant{
+			me, other("", "", "+15551110000"), other("Bob Lee", "", "+15552220000"), other("", "", "+15553330000"),
+		}}, "Mom, Bob Lee, +15553330000"},
+		{"blank name falls through", &gmproto.Conversation{Name: "  ", Participants: []*gmproto.Participant{other("Bob Lee", "", "")}}, "Bob Lee"},
+	}
+	for _, tt := range tests {
+		if got := ConversationName(tt.conv, contacts); got != tt.want {
+			t.Errorf("%s: ConversationName = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+	if got := ConversationName(&gmproto.Conversation{Participants: []*gmproto.Participant{other("Ann", "", "+15551110000")}}, nil); got != "Ann" {
+		t.Errorf("nil ContactNamer: got %q, want Ann", got)
+	}
+}
+
+func TestIsSelfChat(t *testing.T) {
+	store, err := db.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	me := &gmproto.Participant{IsMe: true, ID: &gmproto.SmallInfo{Number: "+15559990000"}}
+	meAgain := &gmproto.Participant{FormattedNumber: "(555) 999-0000", ID: &gmproto.SmallInfo{Number: "5559990000"}}
+	ann := &gmproto.Participant{FullName: "Ann", ID: &gmproto.SmallInfo{Number: "+15551110000"}}
+
+	tests := []struct {
+		name string
+		conv *gmproto.Conversation
+		want bool
+	}{
+		{"only me", &gmproto.Conversation{Participants: []*gmproto.Participant{me}}, true},
+		{"me and my own number", &gmproto.Conversation{Participants: []*gmproto.Participant{me, meAgain}}, true},
+		{"one-to-one", &gmproto.Conversation{Participants: []*gmproto.Participant{me, ann}}, false},
+		{"group", &gmproto.Conversation{IsGroupChat: true, Participants: []*gmproto.Participant{me}}, false},
+		{"no participants", &gmproto.Conversation{}, false},
+		{"me missing", &gmproto.Conversation{Participants: []*gmproto.Participant{ann}}, false},
+	}
+	for _, tt := range tests {
+		if got := IsSelfChat(tt.conv, store.NumbersMatch); got != tt.want {
+			t.Errorf("%s: IsSelfChat = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	dbConv := ConversationToDB(&gmproto.Conversation{ConversationID: "self", Participants: []*gmproto.Participant{me}}, nil)
+	if !dbConv.IsSelfChat {
+		t.Error("ConversationToDB should flag a conversation with only me as a self-chat")
+	}
+}
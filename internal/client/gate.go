@@ -0,0 +1,101 @@
+package client
+
+import (
+	"sync"
+
+	"go.mau.fi/mautrix-gmessages/pkg/libgm"
+	"go.mau.fi/mautrix-gmessages/pkg/libgm/events"
+	"go.mau.fi/mautrix-gmessages/pkg/libgm/gmproto"
+)
+
+// maxPausedEvents bounds how many events a paused SyncGate buffers; later
+// events are dropped.
+const maxPausedEvents = 10000
+
+// SyncGateState reports whether a SyncGate is paused and what it held back.
+type SyncGateState struct {
+	Paused    bool `json:"paused"`
+	Buffering bool `json:"buffering"`
+	Buffered  int  `json:"buffered"`
+	Dropped   int  `json:"dropped"`
+}
+
+// SyncGate pauses processing of inbound data events (messages,
+// conversations and the initial conversation list). While paused those
+// events are buffered for replay on resume, or dropped when buffering is
+// off. Connection and pairing events always pass. The zero value is an open
+// gate.
+type SyncGate struct {
+	mu        sync.Mutex
+	paused    bool
+	buffering bool
+	pending   []any
+	dropped   int
+}
+
+// Pause stops data events. With buffer set they are kept for Resume.
+func (g *SyncGate) Pause(buffer bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.paused = true
+	g.buffering = buffer
+}
+
+// Paused reports whether the gate is paused.
+func (g *SyncGate) Paused() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.paused
+}
+
+// State returns the gate's current state.
+func (g *SyncGate) State() SyncGateState {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return SyncGateState{Paused: g.paused, Buffering: g.buffering, Buffered: len(g.pending), Dropped: g.dropped}
+}
+
+// Resume passes buffered events to replay in arrival order, then opens the
+// gate. Events arriving during the replay are queued behind it, so order is
+// kept. It returns how many events were replayed.
+func (g *SyncGate) Resume(replay func(evt any)) int {
+	n := 0
+	for {
+		g.mu.Lock()
+		batch := g.pending
+		g.pending = nil
+		if len(batch) == 0 {
+			g.paused = false
+			g.buffering = false
+			g.dropped = 0
+			g.mu.Unlock()
+			return n
+		}
+		g.mu.Unlock()
+		for _, evt := range batch {
+			replay(evt)
+		}
+		n += len(batch)
+	}
+}
+
+// admit reports whether evt should be handled now. Held-back events are
+// buffered or dropped.
+func (g *SyncGate) admit(evt any) bool {
+	switch evt.(type) {
+	case *libgm.WrappedMessage, *gmproto.Conversation, *events.ClientReady:
+	default:
+		return true
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.paused {
+		return true
+	}
+	if g.buffering && len(g.pending) < maxPausedEvents {
+		g.pending = append(g.pending, evt)
+	} else {
+		g.dropped++
+	}
+	return false
+}
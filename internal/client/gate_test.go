@@ -0,0 +1,59 @@
+package client
+
+import (
+	"testing"
+
+	"go.mau.fi/mautrix-gmessages/pkg/libgm/events"
+)
+
+func TestSyncGateBuffersUntilResume(t *testing.T) {
+	h := newTestHandler(t)
+	h.Gate = &SyncGate{}
+	h.Gate.Pause(true)
+
+	h.Handle(inboundMessage("m1", "+15551110000"))
+	h.Handle(inboundMessage("m2", "+15551110000"))
+	// Lifecycle events still pass while paused.
+	h.Handle(&events.PhoneNotResponding{})
+
+	if m, _ := h.Store.GetMessageByID("m1"); m != nil {
+		t.Fatal("message stored while paused")
+	}
+	if st := h.Gate.State(); !st.Paused || st.Buffered != 2 {
+		t.Errorf("state while paused = %+v, want 2 buffered", st)
+	}
+
+	if n := h.ResumeSync(); n != 2 {
+		t.Errorf("replayed %d events, want 2", n)
+	}
+	for _, id := range []string{"m1", "m2"} {
+		if m, _ := h.Store.GetMessageByID(id); m == nil {
+			t.Errorf("%s not stored after resume", id)
+		}
+	}
+	if st := h.Gate.State(); st.Paused || st.Buffered != 0 {
+		t.Errorf("state after resume = %+v", st)
+	}
+
+	h.Handle(inboundMessage("m3", "+15551110000"))
+	if m, _ := h.Store.GetMessageByID("m3"); m == nil {
+		t.Error("live message not stored after resume")
+	}
+}
+
+func TestSyncGateDropsWithoutBuffering(t *testing.T) {
+	h := newTestHandler(t)
+	h.Gate = &SyncGate{}
+	h.Gate.Pause(false)
+
+	h.Handle(inboundMessage("m1", "+15551110000"))
+	if st := h.Gate.State(); st.Buffered != 0 || st.Dropped != 1 {
+		t.Errorf("state = %+v, want 1 dropped", st)
+	}
+	if n := h.ResumeSync(); n != 0 {
+		t.Errorf("replayed %d events, want 0", n)
+	}
+	if m, _ := h.Store.GetMessageByID("m1"); m != nil {
+		t.Error("dropped message was stored")
+	}
+}
@@ -0,0 +1,127 @@
+package client
+
+import (
+	"bytes"
+	"encoding/xml"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"go.mau.fi/mautrix-gmessages/pkg/libgm/gmproto"
+
+	"github.com/maxghenis/openmessage/internal/db"
+)
+
+// pushLocationMimeType is the RCS geolocation push attachment type.
+const pushLocationMimeType = "application/vnd.gsma.rcspushlocation+xml"
+
+// mapsLinkRe matches a Google Maps link. parseMapsLink reads coordinates
+// from its q, ll or query parameter, or from after an "@" in the path.
+var mapsLinkRe = regexp.MustCompile(`https?://(?:www\.)?(?:maps\.google\.[a-z.]+|google\.[a-z.]+/maps)\S*`)
+
+// coordsRe matches a "lat,lng" pair at the start of a string.
+var coordsRe = regexp.MustCompile(`^(-?\d{1,3}(?:\.\d+)?),\s*(-?\d{1,3}(?:\.\d+)?)`)
+
+// ExtractLocation extracts a shared place from a protobuf Message: either an
+// RCS geolocation push attachment sent inline, or a Google Maps link with
+// coordinates in the text. Returns nil if the message shares no location.
+func ExtractLocation(msg *gmproto.Message) *db.Location {
+	for _, info := range msg.GetMessageInfo() {
+		mc := info.GetMediaContent()
+		if mc == nil || !strings.EqualFold(mc.GetMimeType(), pushLocationMimeType) {
+			continue
+		}
+		if loc := parsePushLocation(mc.GetMediaData()); loc != nil {
+			return loc
+		}
+	}
+	return parseMapsLink(ExtractMessageBody(msg))
+}
+
+// parsePushLocation reads the label and first gml:pos of an rcspushlocation
+// document. Elements are matched by local name, ignoring namespaces.
+func parsePushLocation(data []byte) *db.Location {
+	if len(data) == 0 {
+		return nil
+	}
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	var label string
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch start.Name.Local {
+		case "rcspushlocation":
+			for _, attr := range start.Attr {
+				if attr.Name.Local == "label" {
+					label = strings.TrimSpace(attr.Value)
+				}
+			}
+		case "pos":
+			var pos string
+			if err := dec.DecodeElement(&pos, &start); err != nil {
+				return nil
+			}
+			fields := strings.Fields(pos)
+			if len(fields) != 2 {
+				return nil
+			}
+			return newLocation(fields[0], fields[1], label)
+		}
+	}
+}
+
+// parseMapsLink finds a Google Maps link with coordinates in body. The rest
+// of the first line that isn't the link, if any, names the place.
+func parseMapsLink(body string) *db.Location {
+	link := mapsLinkRe.FindString(body)
+	if link == "" {
+		return nil
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		return nil
+	}
+	var m []string
+	for _, key := range []string{"q", "ll", "query"} {
+		if m = coordsRe.FindStringSubmatch(u.Query().Get(key)); m != nil {
+			break
+		}
+	}
+	if m == nil {
+		if _, after, ok := strings.Cut(u.Path, "/@"); ok {
+			m = coordsRe.FindStringSubmatch(after)
+		}
+	}
+	if m == nil {
+		return nil
+	}
+	var name string
+	for _, line := range strings.Split(strings.Replace(body, link, "", 1), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			name = line
+			break
+		}
+	}
+	return newLocation(m[1], m[2], name)
+}
+
+// newLocation parses a latitude and longitude, returning nil if either is
+// malformed or out of range.
+func newLocation(lat, lng, name string) *db.Location {
+	la, err := strconv.ParseFloat(lat, 64)
+	if err != nil || la < -90 || la > 90 {
+		return nil
+	}
+	lo, err := strconv.ParseFloat(lng, 64)
+	if err != nil || lo < -180 || lo > 180 {
+		return nil
+	}
+	return &db.Location{Latitude: la, Longitude: lo, Name: name}
+}
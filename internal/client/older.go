@@ -0,0 +1,104 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+
+	"go.mau.fi/mautrix-gmessages/pkg/libgm/gmproto"
+
+	"github.com/maxghenis/openmessage/internal/db"
+)
+
+// ErrBackfillRunning is returned when a backfill is started while another
+// one is still running.
+var ErrBackfillRunning = errors.New("backfill already running")
+
+// ErrNotConnected is returned when a backfill is started without a
+// connection to Google Messages.
+var ErrNotConnected = errors.New("not connected to Google Messages")
+
+// ErrSyncPaused is returned when a backfill is started while sync is
+// paused.
+var ErrSyncPaused = errors.New("sync paused")
+
+// MessageFetcher is the subset of *libgm.Client used to page history.
+type MessageFetcher interface {
+	FetchMessages(conversationID string, count int64, cursor *gmproto.Cursor) (*gmproto.ListMessagesResponse, error)
+}
+
+// FetchOlderMessages returns up to limit messages older than beforeTS,
+// newest first. When the local store has fewer than limit and gm is non-nil,
+// the next page is fetched from Google, stored, and merged in. fetched is
+// the number of messages Google returned. Fetched messages are queued for
+// Supabase when sb is non-nil, and stay pending for the sync retrier until
+// they are pushed.
+//
+// The per-conversation cursor is saved so later calls continue where the
+// last one stopped, and once the server runs out of history it isn't asked
+// again.
+func FetchOlderMessages(store *db.Store, gm MessageFetcher, sb SupabaseSync, convID string, beforeTS int64, limit int) (msgs []*db.Message, fetched int, err error) {
+	msgs, err = store.GetMessagesByConversationBefore(convID, beforeTS, limit)
+	if err != nil {
+		return nil, 0, err
+	}
+	if gm == nil || len(msgs) >= limit {
+		return msgs, 0, nil
+	}
+
+	saved, err := store.GetFetchCursor(convID)
+	if err != nil {
+		return nil, 0, fmt.Errorf("get cursor: %w", err)
+	}
+	if saved != nil && saved.Exhausted {
+		return msgs, 0, nil
+	}
+	cursor, err := olderCursor(store, convID, saved)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	resp, err := gm.FetchMessages(convID, int64(limit), cursor)
+	if err != nil {
+		return nil, 0, fmt.Errorf("fetch messages: %w", err)
+	}
+	for _, m := range resp.GetMessages() {
+		dbMsg := MessageToDB(m, false)
+		if err := store.UpsertMessage(dbMsg); err != nil {
+			return nil, 0, fmt.Errorf("store message: %w", err)
+		}
+		if sb != nil {
+			store.MarkSyncPending(db.SyncKindMessage, dbMsg.MessageID)
+			sb.Enqueue(func() { SyncMessage(store, sb, dbMsg) })
+		}
+	}
+
+	next := &db.FetchCursor{ConversationID: convID}
+	if c := resp.GetCursor(); c != nil && len(resp.GetMessages()) > 0 {
+		next.LastItemID, next.LastItemTS = c.GetLastItemID(), c.GetLastItemTimestamp()
+	} else {
+		next.Exhausted = true
+	}
+	if err := store.SaveFetchCursor(next); err != nil {
+		return nil, 0, fmt.Errorf("save cursor: %w", err)
+	}
+
+	msgs, err = store.GetMessagesByConversationBefore(convID, beforeTS, limit)
+	return msgs, len(resp.GetMessages()), err
+}
+
+// olderCursor picks where the next page starts: the saved cursor if there
+// is one, otherwise the oldest message already stored. A nil cursor asks
+// for the newest page.
+func olderCursor(store *db.Store, convID string, saved *db.FetchCursor) (*gmproto.Cursor, error) {
+	if saved != nil && saved.LastItemID != "" {
+		return &gmproto.Cursor{LastItemID: saved.LastItemID, LastItemTimestamp: saved.LastItemTS}, nil
+	}
+	oldest, err := store.OldestMessage(convID)
+	if err != nil {
+		return nil, fmt.Errorf("find oldest message: %w", err)
+	}
+	if oldest == nil {
+		return nil, nil
+	}
+	return &gmproto.Cursor{LastItemID: oldest.MessageID, LastItemTimestamp: oldest.TimestampMS * 1000}, nil
+}
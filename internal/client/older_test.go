@@ -0,0 +1,102 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"go.mau.fi/mautrix-gmessages/pkg/libgm/gmproto"
+
+	"github.com/maxghenis/openmessage/internal/db"
+)
+
+// fakeFetcher serves one page of older messages, then reports no more.
+type fakeFetcher struct {
+	page    []*gmproto.Message
+	cursors []*gmproto.Cursor
+}
+
+func (f *fakeFetcher) FetchMessages(conversationID string, count int64, cursor *gmproto.Cursor) (*gmproto.ListMessagesResponse, error) {
+	f.cursors = append(f.cursors, cursor)
+	if len(f.cursors) > 1 {
+		return &gmproto.ListMessagesResponse{}, nil
+	}
+	last := f.page[len(f.page)-1]
+	return &gmproto.ListMessagesResponse{
+		Messages: f.page,
+		Cursor:   &gmproto.Cursor{LastItemID: last.MessageID, LastItemTimestamp: last.Timestamp},
+	}, nil
+}
+
+func olderMessage(id string, ts time.Time) *gmproto.Message {
+	return &gmproto.Message{
+		MessageID:      id,
+		ConversationID: "c1",
+		Timestamp:      ts.UnixMicro(),
+		MessageInfo: []*gmproto.MessageInfo{
+			{Data: &gmproto.MessageInfo_MessageContent{
+				MessageContent: &gmproto.MessageContent{Content: "old " + id},
+			}},
+		},
+	}
+}
+
+func TestFetchOlderMessages(t *testing.T) {
+	store, err := db.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	base := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	store.UpsertMessage(&db.Message{MessageID: "m3", ConversationID: "c1", TimestampMS: base.Add(3 * time.Minute).UnixMilli()})
+	store.UpsertMessage(&db.Message{MessageID: "m4", ConversationID: "c1", TimestampMS: base.Add(4 * time.Minute).UnixMilli()})
+
+	gm := &fakeFetcher{page: []*gmproto.Message{
+		olderMessage("m2", base.Add(2*time.Minute)),
+		olderMessage("m1", base.Add(1*time.Minute)),
+	}}
+	sb := &fakeSupabase{}
+
+	// Offline: only what's stored locally.
+	msgs, fetched, err := FetchOlderMessages(store, nil, nil, "c1", 0, 10)
+	if err != nil || fetched != 0 || len(msgs) != 2 {
+		t.Fatalf("offline: %d msgs, fetched %d, err %v", len(msgs), fetched, err)
+	}
+
+	beforeTS := base.Add(3 * time.Minute).UnixMilli()
+	msgs, fetched, err = FetchOlderMessages(store, gm, sb, "c1", beforeTS, 10)
+	if err != nil {
+		t.Fatalf("fetch: %v", err)
+	}
+	if fetched != 2 || len(msgs) != 2 || msgs[0].MessageID != "m2" || msgs[1].MessageID != "m1" {
+		t.Errorf("got %v (fetched %d), want [m2 m1]", messageIDs(msgs), fetched)
+	}
+	if c := gm.cursors[0]; c == nil || c.LastItemID != "m3" {
+		t.Errorf("first cursor = %v, want anchored at the oldest local message m3", c)
+	}
+	if len(sb.messages) != 2 {
+		t.Errorf("pushed %v to Supabase, want the 2 fetched messages", sb.messages)
+	}
+	if saved, _ := store.GetFetchCursor("c1"); saved == nil || saved.LastItemID != "m1" {
+		t.Errorf("saved cursor = %+v, want m1", saved)
+	}
+
+	// The next page continues from the saved cursor and finds nothing.
+	msgs, fetched, _ = FetchOlderMessages(store, gm, sb, "c1", base.Add(time.Minute).UnixMilli(), 10)
+	if fetched != 0 || len(msgs) != 0 || gm.cursors[1].GetLastItemID() != "m1" {
+		t.Errorf("second page: %v, fetched %d, cursor %v", messageIDs(msgs), fetched, gm.cursors[1])
+	}
+	// History is exhausted, so Google isn't asked again.
+	FetchOlderMessages(store, gm, sb, "c1", base.Add(time.Minute).UnixMilli(), 10)
+	if len(gm.cursors) != 2 {
+		t.Errorf("fetched %d times, want 2 after exhaustion", len(gm.cursors))
+	}
+}
+
+func messageIDs(msgs []*db.Message) []string {
+	var ids []string
+	for _, m := range msgs {
+		ids = append(ids, m.MessageID)
+	}
+	return ids
+}
@@ -0,0 +1,70 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// PairingInfo is non-secret metadata about the paired phone, kept next to
+// the session so status displays don't need the auth data.
+type PairingInfo struct {
+	PhoneID  string    `json:"phone_id"`
+	PairedAt time.Time `json:"paired_at"`
+
+	// PreviousPhoneID is set when this pairing replaced a different phone.
+	PreviousPhoneID string `json:"previous_phone_id,omitempty"`
+}
+
+// PhoneChanged reports whether the latest pairing was with a different phone
+// than the one before it.
+func (p *PairingInfo) PhoneChanged() bool {
+	return p.PreviousPhoneID != "" && p.PreviousPhoneID != p.PhoneID
+}
+
+func SavePairingInfo(path string, info *PairingInfo) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("create dir: %w", err)
+	}
+	b, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal: %w", err)
+	}
+	if err := os.WriteFile(path, b, 0600); err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+	return nil
+}
+
+// LoadPairingInfo reads pairing metadata. It returns nil, nil if the file
+// doesn't exist (paired before metadata was recorded, or never paired).
+func LoadPairingInfo(path string) (*PairingInfo, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read: %w", err)
+	}
+	var info PairingInfo
+	if err := json.Unmarshal(b, &info); err != nil {
+		return nil, fmt.Errorf("unmarshal: %w", err)
+	}
+	return &info, nil
+}
+
+// RecordPairing saves metadata for a successful pairing with phoneID,
+// remembering the previously paired phone if it was a different one.
+func RecordPairing(path, phoneID string, pairedAt time.Time) (*PairingInfo, error) {
+	info := &PairingInfo{PhoneID: phoneID, PairedAt: pairedAt}
+	if prev, err := LoadPairingInfo(path); err == nil && prev != nil && prev.PhoneID != phoneID {
+		info.PreviousPhoneID = prev.PhoneID
+	}
+	if err := SavePairingInfo(path, info); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
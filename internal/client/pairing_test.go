@@ -0,0 +1,46 @@
+package client
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRecordPairing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pairing.json")
+
+	info, err := LoadPairingInfo(path)
+	if err != nil || info != nil {
+		t.Fatalf("missing file: got %+v, %v; want nil, nil", info, err)
+	}
+
+	first := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	if _, err := RecordPairing(path, "phone-a", first); err != nil {
+		t.Fatalf("record: %v", err)
+	}
+	info, err = LoadPairingInfo(path)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if info.PhoneID != "phone-a" || !info.PairedAt.Equal(first) {
+		t.Errorf("got %+v", info)
+	}
+	if info.PhoneChanged() {
+		t.Error("first pairing should not count as a phone change")
+	}
+
+	// Re-pairing the same phone is not a change.
+	info, _ = RecordPairing(path, "phone-a", first.Add(time.Hour))
+	if info.PhoneChanged() {
+		t.Error("same phone re-paired should not count as a change")
+	}
+
+	info, _ = RecordPairing(path, "phone-b", first.Add(2*time.Hour))
+	if !info.PhoneChanged() || info.PreviousPhoneID != "phone-a" {
+		t.Errorf("different phone: got %+v", info)
+	}
+	loaded, _ := LoadPairingInfo(path)
+	if loaded.PreviousPhoneID != "phone-a" {
+		t.Errorf("reloaded previous phone: got %q", loaded.PreviousPhoneID)
+	}
+}
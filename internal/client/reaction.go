@@ -0,0 +1,68 @@
+package client
+
+import (
+	"errors"
+	"strings"
+
+	"go.mau.fi/mautrix-gmessages/pkg/libgm/gmproto"
+
+	"github.com/maxghenis/openmessage/internal/db"
+)
+
+// BuildReactionPayload constructs a SendReactionRequest using gmproto.MakeReactionData
+// for proper emoji type mapping, matching the mautrix bridge format.
+func BuildReactionPayload(messageID, emoji, action string, sim *gmproto.SIMPayload) *gmproto.SendReactionRequest {
+	var a gmproto.SendReactionRequest_Action
+	switch strings.ToLower(action) {
+	case "remove":
+		a = gmproto.SendReactionRequest_REMOVE
+	case "switch":
+		a = gmproto.SendReactionRequest_SWITCH
+	default:
+		a = gmproto.SendReactionRequest_ADD
+	}
+	return &gmproto.SendReactionRequest{
+		MessageID:    messageID,
+		ReactionData: gmproto.MakeReactionData(emoji),
+		Action:       a,
+		SIMPayload:   sim,
+	}
+}
+
+// ReactionClient is the subset of *libgm.Client used to send reactions.
+type ReactionClient interface {
+	GetConversation(conversationID string) (*gmproto.Conversation, error)
+	SendReaction(payload *gmproto.SendReactionRequest) (*gmproto.SendReactionResponse, error)
+}
+
+// ErrReactionPending is returned for reactions to an outgoing message the
+// phone hasn't acknowledged yet, which has no ID the phone would recognize.
+var ErrReactionPending = errors.New("message is still sending; react once it has a permanent ID")
+
+// ReactionSIM returns the SIM payload of the conversation, or nil if it can't
+// be looked up. A reaction always goes out on the conversation's SIM,
+// whether the message it targets was sent or received.
+func ReactionSIM(gm ReactionClient, convID string) *gmproto.SIMPayload {
+	if convID == "" {
+		return nil
+	}
+	conv, err := gm.GetConversation(convID)
+	if err != nil {
+		return nil
+	}
+	return conv.GetSimCard().GetSIMData().GetSIMPayload()
+}
+
+// SendReaction sends one reaction to a message. When convID is empty the
+// conversation is taken from the stored message, so the SIM is still found.
+func SendReaction(store *db.Store, gm ReactionClient, convID, messageID, emoji, action string) (*gmproto.SendReactionResponse, error) {
+	if strings.HasPrefix(messageID, "tmp_") {
+		return nil, ErrReactionPending
+	}
+	if convID == "" {
+		if msg, err := store.GetMessageByID(messageID); err == nil && msg != nil {
+			convID = msg.ConversationID
+		}
+	}
+	return gm.SendReaction(BuildReactionPayload(messageID, emoji, action, ReactionSIM(gm, convID)))
+}
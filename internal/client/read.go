@@ -0,0 +1,71 @@
+package client
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/maxghenis/openmessage/internal/db"
+)
+
+// ReadMarker is the subset of *libgm.Client used to send read receipts.
+type ReadMarker interface {
+	MarkRead(conversationID, messageID string) error
+}
+
+// MarkConversationRead clears a conversation's local unread count. If gm is
+// non-nil and sendReceipt is set, the phone is also told the thread was read
+// up to the newest synced message in newestFirst, except for self-chats,
+// which need no receipt. If sb is non-nil the new read position is queued
+// for Supabase; the push never fails the local read.
+func MarkConversationRead(store *db.Store, gm ReadMarker, sb SupabaseSync, convID string, newestFirst []*db.Message, sendReceipt bool) error {
+	if err := store.MarkConversationRead(convID); err != nil {
+		return fmt.Errorf("mark read: %w", err)
+	}
+	if sb != nil {
+		QueueReadPosition(store, sb, convID)
+	}
+	if gm != nil && sendReceipt && !isSelfChat(store, convID) {
+		if err := sendReadReceipt(gm, convID, newestFirst); err != nil {
+			return fmt.Errorf("send read receipt: %w", err)
+		}
+	}
+	return nil
+}
+
+// isSelfChat reports whether the stored conversation is a note to self.
+func isSelfChat(store *db.Store, convID string) bool {
+	conv, err := store.GetConversation(convID)
+	return err == nil && conv.IsSelfChat
+}
+
+// sendReadReceipt marks the newest message in newestFirst that has a server
+// ID as read on the phone.
+func sendReadReceipt(gm ReadMarker, convID string, newestFirst []*db.Message) error {
+	for _, m := range newestFirst {
+		// Placeholders for messages still sending have no server ID yet.
+		if strings.HasPrefix(m.MessageID, "tmp_") {
+			continue
+		}
+		return gm.MarkRead(convID, m.MessageID)
+	}
+	return nil
+}
+
+// SyncReadPosition pushes a conversation's local last-read position to
+// Supabase. Conversations that were never read are skipped. A failed push is
+// repaired by the next ReconcileReadPositions.
+func SyncReadPosition(store *db.Store, sb SupabaseSync, convID string) error {
+	ts, err := store.ConversationLastRead(convID)
+	if err != nil || ts == 0 {
+		return err
+	}
+	return sb.SetConversationRead(convID, time.UnixMilli(ts))
+}
+
+// QueueReadPosition runs SyncReadPosition on sb's queue. The writer logs a
+// failed push, and a push that fails or doesn't fit in the queue is repaired
+// by the next ReconcileReadPositions.
+func QueueReadPosition(store *db.Store, sb SupabaseSync, convID string) {
+	sb.Enqueue(func() { SyncReadPosition(store, sb, convID) })
+}
@@ -0,0 +1,91 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/maxghenis/openmessage/internal/db"
+)
+
+type fakeReadMarker struct {
+	marked []string
+}
+
+func (f *fakeReadMarker) MarkRead(conversationID, messageID string) error {
+	f.marked = append(f.marked, conversationID+"/"+messageID)
+	return nil
+}
+
+func TestMarkConversationRead(t *testing.T) {
+	store, err := db.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	store.UpsertConversation(&db.Conversation{ConversationID: "c1", UnreadCount: 3})
+	msgs := []*db.Message{{MessageID: "tmp_000000000001"}, {MessageID: "m2"}, {MessageID: "m1"}}
+
+	gm := &fakeReadMarker{}
+	if err := MarkConversationRead(store, gm, nil, "c1", msgs, false); err != nil {
+		t.Fatal(err)
+	}
+	if c, _ := store.GetConversation("c1"); c.UnreadCount != 0 {
+		t.Errorf("unread = %d, want 0", c.UnreadCount)
+	}
+	if len(gm.marked) != 0 {
+		t.Errorf("sent receipt while disabled: %v", gm.marked)
+	}
+
+	if err := MarkConversationRead(store, gm, nil, "c1", msgs, true); err != nil {
+		t.Fatal(err)
+	}
+	if len(gm.marked) != 1 || gm.marked[0] != "c1/m2" {
+		t.Errorf("receipts = %v, want [c1/m2]", gm.marked)
+	}
+}
+
+func TestMarkConversationReadSkipsSelfChatReceipt(t *testing.T) {
+	store, err := db.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	store.UpsertConversation(&db.Conversation{ConversationID: "self", IsSelfChat: true, UnreadCount: 1})
+
+	gm := &fakeReadMarker{}
+	if err := MarkConversationRead(store, gm, nil, "self", []*db.Message{{MessageID: "m1"}}, true); err != nil {
+		t.Fatal(err)
+	}
+	if len(gm.marked) != 0 {
+		t.Errorf("sent receipt for a self-chat: %v", gm.marked)
+	}
+	if c, _ := store.GetConversation("self"); c.UnreadCount != 0 {
+		t.Errorf("unread = %d, want 0", c.UnreadCount)
+	}
+}
+
+func TestMarkConversationReadSyncsPosition(t *testing.T) {
+	store, err := db.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	store.UpsertConversation(&db.Conversation{ConversationID: "c1", UnreadCount: 2, LastMessageTS: 5000})
+
+	sb := &fakeSupabase{}
+	if err := MarkConversationRead(store, nil, sb, "c1", nil, false); err != nil {
+		t.Fatal(err)
+	}
+	if got := sb.reads["c1"]; got.UnixMilli() != 5000 {
+		t.Errorf("pushed read position = %v, want 5000ms", got)
+	}
+
+	// A failed push doesn't fail the local read.
+	store.UpsertConversation(&db.Conversation{ConversationID: "c2", UnreadCount: 1, LastMessageTS: 6000})
+	sb.down = true
+	if err := MarkConversationRead(store, nil, sb, "c2", nil, false); err != nil {
+		t.Errorf("mark read while Supabase is down: %v", err)
+	}
+	if c, _ := store.GetConversation("c2"); c.UnreadCount != 0 {
+		t.Errorf("c2 unread = %d, want 0", c.UnreadCount)
+	}
+}
@@ -0,0 +1,405 @@
+package client
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"go.mau.fi/mautrix-gmessages/pkg/libgm/gmproto"
+
+	"github.com/maxghenis/openmessage/internal/db"
+)
+
+// SendResult is the outcome of sending a message, shared by the HTTP API and
+// the MCP tools.
+type SendResult struct {
+	// Status is libgm's SendMessageResponse status, e.g. "SUCCESS", or
+	// StatusUploading for media still being sent in the background.
+	Status  string `json:"status"`
+	Success bool   `json:"success"`
+	TmpID   string `json:"tmp_id"`
+
+	// Message is the locally stored copy, set when the send succeeded.
+	Message *db.Message `json:"message,omitempty"`
+
+	// Segments is how many SMS parts the text needs; 0 for attachments.
+	Segments int `json:"segments,omitempty"`
+
+	// FailureReason explains why the phone rejected the message.
+	FailureReason string `json:"failure_reason,omitempty"`
+}
+
+// TextClient is the subset of *libgm.Client used to send text.
+type TextClient interface {
+	GetConversation(conversationID string) (*gmproto.Conversation, error)
+	SendMessage(payload *gmproto.SendMessageRequest) (*gmproto.SendMessageResponse, error)
+}
+
+// SendText sends body to a conversation, as a reply to replyToID if set,
+// using the conversation's send_as preference. On success the message is
+// stored as OUTGOING_SENDING and the conversation moves to the top of the
+// list. An error means the request didn't reach the phone; a rejected send
+// is reported in the result.
+func SendText(store *db.Store, gm TextClient, convID, body, replyToID string) (*SendResult, error) {
+	return SendTextWithTmpID(store, gm, convID, NewTmpID(), body, replyToID)
+}
+
+// SendTextWithTmpID is SendText with a caller-chosen tmp ID, so the caller
+// can watch for delivery before the message goes out.
+func SendTextWithTmpID(store *db.Store, gm TextClient, convID, tmpID, body, replyToID string) (*SendResult, error) {
+	conv, err := gm.GetConversation(convID)
+	if err != nil {
+		return nil, fmt.Errorf("get conversation: %w", err)
+	}
+	participantID, sim := senderIdentity(conv)
+	payload := BuildSendPayload(convID, body, replyToID, participantID, sim)
+	setTmpID(payload, tmpID)
+	ApplySendAs(payload, ConversationSendAs(store, convID))
+	return sendPayload(store, gm, payload, &db.Message{
+		MessageID:      tmpID,
+		ConversationID: convID,
+		Body:           body,
+		IsFromMe:       true,
+		ReplyToID:      replyToID,
+	})
+}
+
+// MaxBulkSend caps the conversations one SendBulk call sends to.
+const MaxBulkSend = 100
+
+// Codes reported in BulkSendResult.Code. They match the HTTP API's error
+// codes for the same failures.
+const (
+	BulkCodeNotConnected = "not_connected"
+	BulkCodeUpstream     = "upstream_error"
+)
+
+// BulkSendResult is one conversation's outcome in SendBulk. Send is nil when
+// the message didn't reach the phone; Error and Code then say why, with Code
+// one of the BulkCode constants.
+type BulkSendResult struct {
+	ConversationID string      `json:"conversation_id"`
+	Success        bool        `json:"success"`
+	Send           *SendResult `json:"result,omitempty"`
+	Error          string      `json:"error,omitempty"`
+	Code           string      `json:"code,omitempty"`
+}
+
+// SendBulk sends body to each conversation in turn, as SendText does,
+// carrying on past failures. Duplicate and empty IDs are skipped. A nil gm
+// fails every conversation as not connected.
+func SendBulk(store *db.Store, gm TextClient, convIDs []string, body string) []BulkSendResult {
+	seen := make(map[string]bool, len(convIDs))
+	results := make([]BulkSendResult, 0, len(convIDs))
+	for _, id := range convIDs {
+		if id == "" || seen[id] {
+			continue
+		}
+		seen[id] = true
+		r := BulkSendResult{ConversationID: id}
+		if gm == nil {
+			r.Error, r.Code = "not connected to Google Messages", BulkCodeNotConnected
+			results = append(results, r)
+			continue
+		}
+		res, err := SendText(store, gm, id, body, "")
+		switch {
+		case err != nil:
+			r.Error, r.Code = err.Error(), BulkCodeUpstream
+		case !res.Success:
+			r.Send = res
+			r.Error, r.Code = res.FailureReason, BulkCodeUpstream
+		default:
+			r.Send = res
+			r.Success = true
+		}
+		results = append(results, r)
+	}
+	return results
+}
+
+// sendPayload sends payload and, on success, stores local as the sent
+// message under the payload's tmp ID.
+func sendPayload(store *db.Store, gm TextClient, payload *gmproto.SendMessageRequest, local *db.Message) (*SendResult, error) {
+	resp, err := gm.SendMessage(payload)
+	if err != nil {
+		return nil, fmt.Errorf("send message: %w", err)
+	}
+	res := &SendResult{
+		Status:  resp.GetStatus().String(),
+		Success: resp.GetStatus() == gmproto.SendMessageResponse_SUCCESS,
+		TmpID:   payload.TmpID,
+	}
+	if local.MediaID == "" {
+		res.Segments = smsSegments(local.Body)
+	}
+	if !res.Success {
+		res.FailureReason = "phone rejected the message with status " + res.Status
+		return res, nil
+	}
+
+	now := time.Now().UnixMilli()
+	local.TimestampMS = now
+	local.Status = StatusSending
+	store.UpsertMessage(local)
+	store.UpdateConversationTimestamp(local.ConversationID, now)
+	res.Message = local
+	return res, nil
+}
+
+// gsm7Basic and gsm7Extended are the characters of the GSM 03.38 default
+// alphabet; extended characters take two septets.
+const (
+	gsm7Basic    = "@£$¥èéùìòÇ\nØø\rÅåΔ_ΦΓΛΩΠΨΣΘΞÆæßÉ !\"#¤%&'()*+,-./0123456789:;<=>?¡ABCDEFGHIJKLMNOPQRSTUVWXYZÄÖÑÜ§¿abcdefghijklmnopqrstuvwxyzäöñüà"
+	gsm7Extended = "^{}\\[~]|€\f"
+)
+
+// smsSegments returns how many SMS parts body needs: 160 GSM-7 characters
+// fit in one, 153 per part when split; anything else is sent as UCS-2, 70
+// per message or 67 per part.
+func smsSegments(body string) int {
+	if body == "" {
+		return 0
+	}
+	septets, gsm := 0, true
+	for _, r := range body {
+		switch {
+		case strings.ContainsRune(gsm7Basic, r):
+			septets++
+		case strings.ContainsRune(gsm7Extended, r):
+			septets += 2
+		default:
+			gsm = false
+		}
+	}
+	units, single, multi := septets, 160, 153
+	if !gsm {
+		units, single, multi = len(utf16.Encode([]rune(body))), 70, 67
+	}
+	if units <= single {
+		return 1
+	}
+	return (units + multi - 1) / multi
+}
+
+// Local statuses for outgoing media, alongside libgm's OUTGOING_* statuses.
+const (
+	StatusUploading = "OUTGOING_UPLOADING"
+	StatusSending   = "OUTGOING_SENDING"
+	StatusFailed    = "OUTGOING_FAILED_GENERIC"
+)
+
+// MediaClient is the subset of *libgm.Client used to send media.
+type MediaClient interface {
+	UploadMedia(data []byte, fileName, mime string) (*gmproto.MediaContent, error)
+	GetConversation(conversationID string) (*gmproto.Conversation, error)
+	SendMessage(payload *gmproto.SendMessageRequest) (*gmproto.SendMessageResponse, error)
+}
+
+// MediaFile is an attachment to upload and send.
+type MediaFile struct {
+	Data     []byte
+	Filename string
+	MimeType string
+}
+
+// SendFiles uploads files and sends them as one message to a conversation
+// under tmpID, with caption as accompanying text if non-empty. body is the
+// text stored with the local copy, which records the first attachment and
+// how many there were. On success the message is stored as
+// OUTGOING_SENDING; on failure an existing placeholder row for tmpID is
+// marked OUTGOING_FAILED_GENERIC.
+func SendFiles(store *db.Store, gm MediaClient, convID, tmpID string, files []MediaFile, caption, body string) (*SendResult, error) {
+	fail := func(err error) (*SendResult, error) {
+		store.UpdateMessageStatus(tmpID, StatusFailed)
+		return nil, err
+	}
+	if len(files) == 0 {
+		return fail(errors.New("no attachments to send"))
+	}
+
+	media := make([]*gmproto.MediaContent, len(files))
+	for i, f := range files {
+		mc, err := gm.UploadMedia(f.Data, f.Filename, f.MimeType)
+		if err != nil {
+			return fail(fmt.Errorf("upload media %s: %w", f.Filename, err))
+		}
+		media[i] = mc
+	}
+
+	// Get SIM and participant info
+	conv, err := gm.GetConversation(convID)
+	if err != nil {
+		return fail(fmt.Errorf("get conversation: %w", err))
+	}
+	myParticipantID, simPayload := senderIdentity(conv)
+
+	payload := BuildSendMediaPayload(convID, media, caption, myParticipantID, simPayload)
+	setTmpID(payload, tmpID)
+	ApplySendAs(payload, ConversationSendAs(store, convID))
+
+	res, err := sendPayload(store, gm, payload, &db.Message{
+		MessageID:       tmpID,
+		ConversationID:  convID,
+		Body:            body,
+		IsFromMe:        true,
+		MediaID:         media[0].MediaID,
+		MimeType:        media[0].MimeType,
+		DecryptionKey:   hex.EncodeToString(media[0].DecryptionKey),
+		MediaSize:       int64(len(files[0].Data)),
+		AttachmentCount: len(files),
+	})
+	if err != nil {
+		return fail(err)
+	}
+	if !res.Success {
+		store.UpdateMessageStatus(tmpID, StatusFailed)
+	}
+	return res, nil
+}
+
+// SendContactCard shares a contact as a vCard attachment. The local copy of
+// the message is labeled with the contact's name and number.
+func SendContactCard(store *db.Store, gm MediaClient, convID, name, number string) (*SendResult, error) {
+	card, err := BuildVCard(name, number)
+	if err != nil {
+		return nil, err
+	}
+	label := fmt.Sprintf("Contact: %s (%s)", strings.TrimSpace(name), strings.TrimSpace(number))
+	return SendFiles(store, gm, convID, NewTmpID(), []MediaFile{{Data: card, Filename: vcardFilename(name), MimeType: "text/vcard"}}, "", label)
+}
+
+// SendMedia uploads data as an attachment and sends it to a conversation,
+// with an optional caption.
+func SendMedia(store *db.Store, gm MediaClient, convID string, data []byte, filename, mime, caption string) (*SendResult, error) {
+	return SendFiles(store, gm, convID, NewTmpID(), []MediaFile{{Data: data, Filename: filename, MimeType: mime}}, caption, caption)
+}
+
+// vcardFilename turns a contact name into a safe .vcf file name.
+func vcardFilename(name string) string {
+	safe := strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r < ' ' {
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(name))
+	return safe + ".vcf"
+}
+
+// senderIdentity finds our participant ID and SIM payload in a conversation,
+// falling back to the conversation's SIM card.
+func senderIdentity(conv *gmproto.Conversation) (participantID string, sim *gmproto.SIMPayload) {
+	for _, p := range conv.GetParticipants() {
+		if p.GetIsMe() {
+			if id := p.GetID(); id != nil {
+				participantID = id.GetNumber()
+			}
+			sim = p.GetSimPayload()
+			break
+		}
+	}
+	if sim == nil {
+		if sc := conv.GetSimCard(); sc != nil {
+			sim = sc.GetSIMData().GetSIMPayload()
+		}
+	}
+	return participantID, sim
+}
+
+// NewTmpID returns a fresh ID for an outgoing message, used until the phone
+// assigns its own.
+func NewTmpID() string {
+	return fmt.Sprintf("tmp_%012d", rand.Int63n(1e12))
+}
+
+// setTmpID overrides the generated tmp ID in all three places libgm expects it.
+func setTmpID(req *gmproto.SendMessageRequest, tmpID string) {
+	req.TmpID = tmpID
+	req.MessagePayload.TmpID = tmpID
+	req.MessagePayload.TmpID2 = tmpID
+}
+
+// BuildSendPayload constructs a SendMessageRequest matching the format used by
+// the mautrix bridge: MessageInfo array (not MessagePayloadContent), TmpID in 3
+// places, SIMPayload, and ParticipantID.
+func BuildSendPayload(conversationID, message, replyToID, participantID string, sim *gmproto.SIMPayload) *gmproto.SendMessageRequest {
+	tmpID := NewTmpID()
+	req := &gmproto.SendMessageRequest{
+		ConversationID: conversationID,
+		MessagePayload: &gmproto.MessagePayload{
+			TmpID:                 tmpID,
+			MessagePayloadContent: nil,
+			MessageInfo: []*gmproto.MessageInfo{{
+				Data: &gmproto.MessageInfo_MessageContent{MessageContent: &gmproto.MessageContent{
+					Content: message,
+				}},
+			}},
+			ConversationID: conversationID,
+			ParticipantID:  participantID,
+			TmpID2:         tmpID,
+		},
+		SIMPayload: sim,
+		TmpID:      tmpID,
+	}
+	if replyToID != "" {
+		req.Reply = &gmproto.ReplyPayload{
+			MessageID: replyToID,
+		}
+	}
+	return req
+}
+
+// BuildSendMediaPayload constructs a SendMessageRequest with one MediaContent
+// attachment per entry of media, followed by caption as a MessageContent if
+// non-empty. Attachments come before the text, matching what Google Messages
+// itself sends for a captioned photo. Uses the same MessageInfo array format as
+// BuildSendPayload.
+func BuildSendMediaPayload(conversationID string, media []*gmproto.MediaContent, caption, participantID string, sim *gmproto.SIMPayload) *gmproto.SendMessageRequest {
+	tmpID := NewTmpID()
+	infos := make([]*gmproto.MessageInfo, 0, len(media)+1)
+	for _, mc := range media {
+		infos = append(infos, &gmproto.MessageInfo{
+			Data: &gmproto.MessageInfo_MediaContent{MediaContent: mc},
+		})
+	}
+	if caption != "" {
+		infos = append(infos, &gmproto.MessageInfo{
+			Data: &gmproto.MessageInfo_MessageContent{MessageContent: &gmproto.MessageContent{Content: caption}},
+		})
+	}
+	return &gmproto.SendMessageRequest{
+		ConversationID: conversationID,
+		MessagePayload: &gmproto.MessagePayload{
+			TmpID:                 tmpID,
+			MessagePayloadContent: nil,
+			MessageInfo:           infos,
+			ConversationID:        conversationID,
+			ParticipantID:         participantID,
+			TmpID2:                tmpID,
+		},
+		SIMPayload: sim,
+		TmpID:      tmpID,
+	}
+}
+
+// ApplySendAs sets the transport hint on an outgoing message from a
+// conversation's send_as preference. libgm can only force RCS; "sms" and
+// "auto" both leave the choice to the phone, which falls back to SMS when
+// RCS is unavailable.
+func ApplySendAs(req *gmproto.SendMessageRequest, sendAs string) {
+	req.ForceRCS = sendAs == db.SendAsRCS
+}
+
+// ConversationSendAs returns the stored send_as preference for a
+// conversation, or "auto" if the conversation isn't in the local DB yet.
+func ConversationSendAs(store *db.Store, convID string) string {
+	conv, err := store.GetConversation(convID)
+	if err != nil || conv == nil {
+		return db.SendAsAuto
+	}
+	return conv.SendAs
+}
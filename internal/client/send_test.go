@@ -0,0 +1,648 @@
+package client
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"go.mau.fi/mautrix-gmessages/pkg/libgm/gmproto"
+
+	"github.com/maxghenis/openmessage/internal/db"
+)
+
+// fakeMediaClient stands in for libgm when sending media. UploadMedia blocks
+// until release is closed, if set.
+type fakeMediaClient struct {
+	release   chan struct{}
+	uploadErr error
+	status    gmproto.SendMessageResponse_Status // SUCCESS if zero
+	sent      []*gmproto.SendMessageRequest
+}
+
+func (f *fakeMediaClient) UploadMedia(data []byte, fileName, mime string) (*gmproto.MediaContent, error) {
+	if f.release != nil {
+		<-f.release
+	}
+	if f.uploadErr != nil {
+		return nil, f.uploadErr
+	}
+	return &gmproto.MediaContent{MediaID: "uploaded-1", MimeType: mime, DecryptionKey: []byte{0x01}}, nil
+}
+
+func (f *fakeMediaClient) GetConversation(conversationID string) (*gmproto.Conversation, error) {
+	return &gmproto.Conversation{ConversationID: conversationID}, nil
+}
+
+func (f *fakeMediaClient) SendMessage(payload *gmproto.SendMessageRequest) (*gmproto.SendMessageResponse, error) {
+	f.sent = append(f.sent, payload)
+	if f.status != gmproto.SendMessageResponse_UNKNOWN {
+		return &gmproto.SendMessageResponse{Status: f.status}, nil
+	}
+	return &gmproto.SendMessageResponse{Status: gmproto.SendMessageResponse_SUCCESS}, nil
+}
+
+type fakeReactionClient struct {
+	convLookups int
+	sim         *gmproto.SIMPayload // returned on every conversation, if set
+	sent        []*gmproto.SendReactionRequest
+}
+
+func (f *fakeReactionClient) GetConversation(conversationID string) (*gmproto.Conversation, error) {
+	f.convLookups++
+	conv := &gmproto.Conversation{ConversationID: conversationID}
+	if f.sim != nil {
+		conv.SimCard = &gmproto.SIMCard{SIMData: &gmproto.SIMData{SIMPayload: f.sim}}
+	}
+	return conv, nil
+}
+
+func (f *fakeReactionClient) SendReaction(payload *gmproto.SendReactionRequest) (*gmproto.SendReactionResponse, error) {
+	if payload.MessageID == "m-fail" {
+		return nil, errors.New("phone offline")
+	}
+	f.sent = append(f.sent, payload)
+	return &gmproto.SendReactionResponse{Success: true}, nil
+}
+
+// rejectingClient is a TextClient whose phone rejects sends to reject.
+type rejectingClient struct {
+	reject string
+}
+
+func (c rejectingClient) GetConversation(conversationID string) (*gmproto.Conversation, error) {
+	return &gmproto.Conversation{ConversationID: conversationID}, nil
+}
+
+func (c rejectingClient) SendMessage(payload *gmproto.SendMessageRequest) (*gmproto.SendMessageResponse, error) {
+	if payload.ConversationID == c.reject {
+		return &gmproto.SendMessageResponse{Status: gmproto.SendMessageResponse_FAILURE_2}, nil
+	}
+	return &gmproto.SendMessageResponse{Status: gmproto.SendMessageResponse_SUCCESS}, nil
+}
+
+func TestSendBulkPartialFailure(t *testing.T) {
+	store, err := db.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	results := SendBulk(store, rejectingClient{reject: "c2"}, []string{"c1", "c2", "c3"}, "hello all")
+	if len(results) != 3 {
+		t.Fatalf("got %d results, want 3", len(results))
+	}
+	for i, wantOK := range []bool{true, false, true} {
+		if results[i].Success != wantOK {
+			t.Errorf("results[%d] = %+v, want success %v", i, results[i], wantOK)
+		}
+	}
+	if results[1].Code != BulkCodeUpstream || results[1].Send == nil {
+		t.Errorf("rejected result = %+v, want upstream_error with the send result", results[1])
+	}
+	for _, id := range []string{"c1", "c3"} {
+		msgs, _ := store.GetMessagesByConversation(id, 10)
+		if len(msgs) != 1 || msgs[0].Body != "hello all" {
+			t.Errorf("%s stored %+v, want the sent message", id, msgs)
+		}
+	}
+}
+
+func TestBuildReactionPayload(t *testing.T) {
+	sim := &gmproto.SIMPayload{SIMNumber: 1}
+
+	// ADD reaction
+	payload := BuildReactionPayload("msg-123", "😂", "add", sim)
+	if payload.MessageID != "msg-123" {
+		t.Errorf("MessageID = %q, want msg-123", payload.MessageID)
+	}
+	if payload.ReactionData == nil || payload.ReactionData.Unicode != "😂" {
+		t.Errorf("ReactionData.Unicode = %v, want 😂", payload.ReactionData)
+	}
+	if payload.Action != gmproto.SendReactionRequest_ADD {
+		t.Errorf("Action = %v, want ADD", payload.Action)
+	}
+	if payload.SIMPayload == nil || payload.SIMPayload.SIMNumber != 1 {
+		t.Error("SIMPayload not set correctly")
+	}
+
+	// REMOVE reaction
+	payload2 := BuildReactionPayload("msg-456", "👍", "remove", sim)
+	if payload2.Action != gmproto.SendReactionRequest_REMOVE {
+		t.Errorf("Action = %v, want REMOVE", payload2.Action)
+	}
+
+	// Default to ADD
+	payload3 := BuildReactionPayload("msg-789", "❤️", "", sim)
+	if payload3.Action != gmproto.SendReactionRequest_ADD {
+		t.Errorf("Action = %v, want ADD for empty action string", payload3.Action)
+	}
+}
+
+func TestBuildSendPayload(t *testing.T) {
+	sim := &gmproto.SIMPayload{SIMNumber: 1}
+	payload := BuildSendPayload("conv-1", "Hello world", "", "+15551234567", sim)
+
+	// Must use MessageInfo array (not MessagePayloadContent)
+	if payload.MessagePayload.MessagePayloadContent != nil {
+		t.Error("MessagePayloadContent must be nil; use MessageInfo instead")
+	}
+	if len(payload.MessagePayload.MessageInfo) != 1 {
+		t.Fatalf("expected 1 MessageInfo entry, got %d", len(payload.MessagePayload.MessageInfo))
+	}
+	mc := payload.MessagePayload.MessageInfo[0].GetMessageContent()
+	if mc == nil || mc.Content != "Hello world" {
+		t.Errorf("MessageContent mismatch: %+v", mc)
+	}
+
+	// TmpID format: tmp_ followed by 12 digits
+	if !strings.HasPrefix(payload.TmpID, "tmp_") || len(payload.TmpID) != 16 {
+		t.Errorf("TmpID format wrong: %q (want tmp_ + 12 digits)", payload.TmpID)
+	}
+	// TmpID must be in all 3 places
+	if payload.MessagePayload.TmpID != payload.TmpID {
+		t.Error("MessagePayload.TmpID must match root TmpID")
+	}
+	if payload.MessagePayload.TmpID2 != payload.TmpID {
+		t.Error("MessagePayload.TmpID2 must match root TmpID")
+	}
+
+	// SIM payload must be set
+	if payload.SIMPayload == nil {
+		t.Error("SIMPayload must not be nil")
+	}
+	if payload.SIMPayload.SIMNumber != 1 {
+		t.Errorf("SIMNumber = %d, want 1", payload.SIMPayload.SIMNumber)
+	}
+
+	// ParticipantID
+	if payload.MessagePayload.ParticipantID != "+15551234567" {
+		t.Errorf("ParticipantID = %q, want +15551234567", payload.MessagePayload.ParticipantID)
+	}
+
+	// ConversationID in both places
+	if payload.ConversationID != "conv-1" {
+		t.Errorf("root ConversationID = %q", payload.ConversationID)
+	}
+	if payload.MessagePayload.ConversationID != "conv-1" {
+		t.Errorf("payload ConversationID = %q", payload.MessagePayload.ConversationID)
+	}
+}
+
+func TestBuildSendPayloadWithReply(t *testing.T) {
+	payload := BuildSendPayload("conv-1", "Reply text", "orig-msg-id", "+15551234567", nil)
+	if payload.Reply == nil {
+		t.Fatal("Reply must be set when replyToID is provided")
+	}
+	if payload.Reply.MessageID != "orig-msg-id" {
+		t.Errorf("Reply.MessageID = %q, want orig-msg-id", payload.Reply.MessageID)
+	}
+}
+
+func TestBuildSendPayloadNoReply(t *testing.T) {
+	payload := BuildSendPayload("conv-1", "No reply", "", "+15551234567", nil)
+	if payload.Reply != nil {
+		t.Error("Reply must be nil when replyToID is empty")
+	}
+}
+
+func TestBuildSendMediaPayload(t *testing.T) {
+	sim := &gmproto.SIMPayload{SIMNumber: 1}
+	media := &gmproto.MediaContent{
+		Format:    4, // image
+		MediaID:   "media-abc-123",
+		MediaName: "photo.jpg",
+		Size:      54321,
+		MimeType:  "image/jpeg",
+	}
+	payload := BuildSendMediaPayload("conv-1", []*gmproto.MediaContent{media}, "", "+15551234567", sim)
+
+	// Must use MessageInfo with MediaContent (not MessageContent)
+	if payload.MessagePayload.MessagePayloadContent != nil {
+		t.Error("MessagePayloadContent must be nil; use MessageInfo instead")
+	}
+	if len(payload.MessagePayload.MessageInfo) != 1 {
+		t.Fatalf("expected 1 MessageInfo entry, got %d", len(payload.MessagePayload.MessageInfo))
+	}
+
+	// Should have MediaContent, not MessageContent
+	mc := payload.MessagePayload.MessageInfo[0].GetMessageContent()
+	if mc != nil {
+		t.Error("MessageContent should be nil for media messages")
+	}
+	mediaCont := payload.MessagePayload.MessageInfo[0].GetMediaContent()
+	if mediaCont == nil {
+		t.Fatal("MediaContent must be set")
+	}
+	if mediaCont.MediaID != "media-abc-123" {
+		t.Errorf("MediaID = %q, want media-abc-123", mediaCont.MediaID)
+	}
+	if mediaCont.MimeType != "image/jpeg" {
+		t.Errorf("MimeType = %q, want image/jpeg", mediaCont.MimeType)
+	}
+
+	// TmpID format: tmp_ followed by 12 digits
+	if !strings.HasPrefix(payload.TmpID, "tmp_") || len(payload.TmpID) != 16 {
+		t.Errorf("TmpID format wrong: %q (want tmp_ + 12 digits)", payload.TmpID)
+	}
+	// TmpID must be in all 3 places
+	if payload.MessagePayload.TmpID != payload.TmpID {
+		t.Error("MessagePayload.TmpID must match root TmpID")
+	}
+	if payload.MessagePayload.TmpID2 != payload.TmpID {
+		t.Error("MessagePayload.TmpID2 must match root TmpID")
+	}
+
+	// SIM payload must be set
+	if payload.SIMPayload == nil || payload.SIMPayload.SIMNumber != 1 {
+		t.Error("SIMPayload not set correctly")
+	}
+
+	// ParticipantID and ConversationID
+	if payload.MessagePayload.ParticipantID != "+15551234567" {
+		t.Errorf("ParticipantID = %q, want +15551234567", payload.MessagePayload.ParticipantID)
+	}
+	if payload.ConversationID != "conv-1" {
+		t.Errorf("root ConversationID = %q", payload.ConversationID)
+	}
+	if payload.MessagePayload.ConversationID != "conv-1" {
+		t.Errorf("payload ConversationID = %q", payload.MessagePayload.ConversationID)
+	}
+}
+
+func TestUploadAndSendMediaStatusTransitions(t *testing.T) {
+	store, err := db.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	// Placeholder stored by the async handler before the upload starts
+	store.UpsertMessage(&db.Message{
+		MessageID: "tmp_000000000001", ConversationID: "c1", IsFromMe: true, Status: StatusUploading,
+	})
+
+	fake := &fakeMediaClient{release: make(chan struct{})}
+	done := make(chan error)
+	go func() {
+		_, err := SendFiles(store, fake, "c1", "tmp_000000000001", []MediaFile{{Data: []byte("img"), Filename: "a.png", MimeType: "image/png"}}, "", "")
+		done <- err
+	}()
+
+	msg, _ := store.GetMessageByID("tmp_000000000001")
+	if msg.Status != StatusUploading {
+		t.Errorf("during upload: status = %q, want %q", msg.Status, StatusUploading)
+	}
+
+	close(fake.release)
+	if err := <-done; err != nil {
+		t.Fatalf("SendFiles: %v", err)
+	}
+
+	msg, _ = store.GetMessageByID("tmp_000000000001")
+	if msg.Status != StatusSending {
+		t.Errorf("after send: status = %q, want %q", msg.Status, StatusSending)
+	}
+	if msg.MediaID != "uploaded-1" {
+		t.Errorf("MediaID = %q, want uploaded-1", msg.MediaID)
+	}
+	if len(fake.sent) != 1 || fake.sent[0].TmpID != "tmp_000000000001" {
+		t.Fatalf("expected one send with the placeholder tmp ID, got %+v", fake.sent)
+	}
+	if fake.sent[0].MessagePayload.TmpID2 != "tmp_000000000001" {
+		t.Error("TmpID2 must match the placeholder tmp ID")
+	}
+}
+
+func TestUploadAndSendMediaFailure(t *testing.T) {
+	store, err := db.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	store.UpsertMessage(&db.Message{
+		MessageID: "tmp_000000000002", ConversationID: "c1", IsFromMe: true, Status: StatusUploading,
+	})
+
+	fake := &fakeMediaClient{uploadErr: errors.New("timeout")}
+	if _, err := SendFiles(store, fake, "c1", "tmp_000000000002", []MediaFile{{Data: []byte("img"), Filename: "a.png", MimeType: "image/png"}}, "", ""); err == nil {
+		t.Fatal("expected upload error")
+	}
+
+	msg, _ := store.GetMessageByID("tmp_000000000002")
+	if msg.Status != StatusFailed {
+		t.Errorf("status = %q, want %q", msg.Status, StatusFailed)
+	}
+	if len(fake.sent) != 0 {
+		t.Error("should not send after failed upload")
+	}
+}
+
+func TestSendMediaCaption(t *testing.T) {
+	store, err := db.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	fake := &fakeMediaClient{}
+	res, err := SendMedia(store, fake, "c1", []byte("img"), "a.png", "image/png", "look at this")
+	if err != nil || res.Status != gmproto.SendMessageResponse_SUCCESS.String() {
+		t.Fatalf("SendMedia = %+v, %v", res, err)
+	}
+	infos := fake.sent[0].GetMessagePayload().GetMessageInfo()
+	if len(infos) != 2 || infos[0].GetMediaContent() == nil || infos[1].GetMessageContent().GetContent() != "look at this" {
+		t.Errorf("MessageInfo = %+v, want media then caption", infos)
+	}
+	if msg, _ := store.GetMessageByID(res.TmpID); msg == nil || msg.Body != "look at this" {
+		t.Errorf("stored message = %+v, want caption as body", msg)
+	}
+}
+
+func TestBuildSendMediaPayloadCaption(t *testing.T) {
+	media := &gmproto.MediaContent{MediaID: "media-1", MimeType: "image/jpeg"}
+	payload := BuildSendMediaPayload("conv-1", []*gmproto.MediaContent{media}, "look at this", "+15551234567", nil)
+
+	infos := payload.MessagePayload.MessageInfo
+	if len(infos) != 2 {
+		t.Fatalf("expected 2 MessageInfo entries, got %d", len(infos))
+	}
+	if infos[0].GetMediaContent().GetMediaID() != "media-1" {
+		t.Errorf("MessageInfo[0] = %+v, want the MediaContent first", infos[0])
+	}
+	if infos[1].GetMessageContent().GetContent() != "look at this" {
+		t.Errorf("MessageInfo[1] = %+v, want the caption MessageContent", infos[1])
+	}
+
+	// No caption means no MessageContent entry.
+	payload = BuildSendMediaPayload("conv-1", []*gmproto.MediaContent{media}, "", "+15551234567", nil)
+	if n := len(payload.MessagePayload.MessageInfo); n != 1 {
+		t.Errorf("expected 1 MessageInfo entry without a caption, got %d", n)
+	}
+}
+
+func TestBuildSendMediaPayloadMultiple(t *testing.T) {
+	media := []*gmproto.MediaContent{
+		{MediaID: "media-1", MimeType: "image/jpeg"},
+		{MediaID: "media-2", MimeType: "image/png"},
+		{MediaID: "media-3", MimeType: "video/mp4"},
+	}
+	payload := BuildSendMediaPayload("conv-1", media, "from the trip", "+15551234567", nil)
+
+	infos := payload.MessagePayload.MessageInfo
+	if len(infos) != 4 {
+		t.Fatalf("expected 4 MessageInfo entries, got %d", len(infos))
+	}
+	for i, want := range []string{"media-1", "media-2", "media-3"} {
+		if got := infos[i].GetMediaContent().GetMediaID(); got != want {
+			t.Errorf("MessageInfo[%d] MediaID = %q, want %q", i, got, want)
+		}
+	}
+	if got := infos[3].GetMessageContent().GetContent(); got != "from the trip" {
+		t.Errorf("caption = %q, want %q", got, "from the trip")
+	}
+}
+
+func TestUploadAndSendMediaMultiple(t *testing.T) {
+	store, err := db.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	fake := &fakeMediaClient{}
+	files := []MediaFile{
+		{Data: []byte("first"), Filename: "a.jpg", MimeType: "image/jpeg"},
+		{Data: []byte("second"), Filename: "b.png", MimeType: "image/png"},
+	}
+	res, err := SendFiles(store, fake, "c1", "tmp_000000000005", files, "", "")
+	if err != nil {
+		t.Fatalf("SendFiles: %v", err)
+	}
+	infos := fake.sent[0].GetMessagePayload().GetMessageInfo()
+	if len(infos) != 2 || infos[0].GetMediaContent().GetMimeType() != "image/jpeg" || infos[1].GetMediaContent().GetMimeType() != "image/png" {
+		t.Errorf("MessageInfo = %+v, want both attachments in order", infos)
+	}
+	msg, _ := store.GetMessageByID(res.TmpID)
+	if msg == nil {
+		t.Fatal("sent message not stored")
+	}
+	if msg.AttachmentCount != 2 || msg.MimeType != "image/jpeg" || msg.MediaSize != int64(len("first")) {
+		t.Errorf("stored message = %+v, want the first attachment and a count of 2", msg)
+	}
+}
+
+func TestSendContactCard(t *testing.T) {
+	store, err := db.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	fake := &fakeMediaClient{}
+	res, err := SendContactCard(store, fake, "c1", "Sarah Chen", "+15551234567")
+	if err != nil {
+		t.Fatalf("SendContactCard: %v", err)
+	}
+	if res.Status != gmproto.SendMessageResponse_SUCCESS.String() {
+		t.Errorf("status = %v", res.Status)
+	}
+	if len(fake.sent) != 1 {
+		t.Fatalf("expected one send, got %d", len(fake.sent))
+	}
+
+	msg, _ := store.GetMessageByID(res.TmpID)
+	if msg == nil {
+		t.Fatal("sent contact card not stored")
+	}
+	if msg.MimeType != "text/vcard" {
+		t.Errorf("MimeType = %q, want text/vcard", msg.MimeType)
+	}
+	if msg.Body != "Contact: Sarah Chen (+15551234567)" {
+		t.Errorf("Body = %q", msg.Body)
+	}
+
+	if _, err := SendContactCard(store, fake, "c1", "", "+15551234567"); err == nil {
+		t.Error("expected error for missing name")
+	}
+	if len(fake.sent) != 1 {
+		t.Error("should not send an invalid contact card")
+	}
+}
+
+func TestSendTextResult(t *testing.T) {
+	store, err := db.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	fake := &fakeMediaClient{}
+	res, err := SendText(store, fake, "c1", "hello", "m0")
+	if err != nil {
+		t.Fatalf("SendText: %v", err)
+	}
+	if !res.Success || res.Status != "SUCCESS" || res.Segments != 1 || res.FailureReason != "" {
+		t.Errorf("result = %+v", res)
+	}
+	if len(fake.sent) != 1 || fake.sent[0].TmpID != res.TmpID || fake.sent[0].GetReply().GetMessageID() != "m0" {
+		t.Fatalf("sent = %+v, want one reply under %s", fake.sent, res.TmpID)
+	}
+	if res.Message == nil || res.Message.MessageID != res.TmpID || res.Message.Status != StatusSending {
+		t.Errorf("result message = %+v", res.Message)
+	}
+	msg, _ := store.GetMessageByID(res.TmpID)
+	if msg == nil || msg.Body != "hello" || msg.ReplyToID != "m0" || !msg.IsFromMe {
+		t.Errorf("stored message = %+v", msg)
+	}
+}
+
+func TestSendTextRejected(t *testing.T) {
+	store, err := db.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	fake := &fakeMediaClient{status: gmproto.SendMessageResponse_FAILURE_2}
+	res, err := SendText(store, fake, "c1", "hello", "")
+	if err != nil {
+		t.Fatalf("SendText: %v", err)
+	}
+	if res.Success || res.Message != nil || !strings.Contains(res.FailureReason, "FAILURE_2") {
+		t.Errorf("result = %+v, want failure with reason", res)
+	}
+	if msg, _ := store.GetMessageByID(res.TmpID); msg != nil {
+		t.Errorf("rejected message stored: %+v", msg)
+	}
+}
+
+func TestSendMediaResult(t *testing.T) {
+	store, err := db.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	fake := &fakeMediaClient{}
+	res, err := SendMedia(store, fake, "c1", []byte("img"), "a.png", "image/png", "")
+	if err != nil {
+		t.Fatalf("SendMedia: %v", err)
+	}
+	if !res.Success || res.Segments != 0 || res.Message == nil || res.Message.MediaID != "uploaded-1" {
+		t.Errorf("result = %+v", res)
+	}
+
+	store.UpsertMessage(&db.Message{
+		MessageID: "tmp_000000000004", ConversationID: "c1", IsFromMe: true, Status: StatusUploading,
+	})
+	fake.status = gmproto.SendMessageResponse_FAILURE_2
+	res, err = SendFiles(store, fake, "c1", "tmp_000000000004", []MediaFile{{Data: []byte("img"), Filename: "a.png", MimeType: "image/png"}}, "", "")
+	if err != nil {
+		t.Fatalf("SendFiles: %v", err)
+	}
+	if res.Success || res.FailureReason == "" || res.TmpID != "tmp_000000000004" {
+		t.Errorf("result = %+v, want failure", res)
+	}
+	if msg, _ := store.GetMessageByID("tmp_000000000004"); msg.Status != StatusFailed {
+		t.Errorf("status = %q, want %q", msg.Status, StatusFailed)
+	}
+}
+
+func TestSMSSegments(t *testing.T) {
+	tests := []struct {
+		body string
+		want int
+	}{
+		{"", 0},
+		{"hi", 1},
+		{strings.Repeat("a", 160), 1},
+		{strings.Repeat("a", 161), 2},
+		{strings.Repeat("a", 306), 2},
+		{strings.Repeat("a", 307), 3},
+		{strings.Repeat("€", 80), 1}, // extended characters take two septets
+		{strings.Repeat("€", 81), 2},
+		{strings.Repeat("é", 70) + "😀", 2}, // emoji forces UCS-2
+		{strings.Repeat("ł", 70), 1},
+		{strings.Repeat("ł", 71), 2},
+	}
+	for _, tt := range tests {
+		if got := smsSegments(tt.body); got != tt.want {
+			t.Errorf("smsSegments(%d runes) = %d, want %d", len([]rune(tt.body)), got, tt.want)
+		}
+	}
+}
+
+func TestSendReactionToOwnMessage(t *testing.T) {
+	store, err := db.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	store.UpsertMessage(&db.Message{MessageID: "m1", ConversationID: "c1", Body: "hi", TimestampMS: 1000, IsFromMe: true})
+	store.UpsertMessage(&db.Message{MessageID: "tmp_1", ConversationID: "c1", Body: "sending", TimestampMS: 2000, IsFromMe: true})
+
+	sim := &gmproto.SIMPayload{Two: 2, SIMNumber: 1}
+	fake := &fakeReactionClient{sim: sim}
+
+	// No conversation_id: it comes from the stored outgoing message.
+	resp, err := SendReaction(store, fake, "", "m1", "❤️", "")
+	if err != nil || !resp.GetSuccess() {
+		t.Fatalf("sendReaction = %v, %v", resp, err)
+	}
+	if len(fake.sent) != 1 {
+		t.Fatalf("sent %d reactions, want 1", len(fake.sent))
+	}
+	got := fake.sent[0]
+	if got.MessageID != "m1" || got.Action != gmproto.SendReactionRequest_ADD {
+		t.Errorf("payload = %+v", got)
+	}
+	if got.SIMPayload != sim {
+		t.Errorf("SIMPayload = %v, want the conversation's SIM", got.SIMPayload)
+	}
+	if got.GetReactionData().GetUnicode() != "❤️" || got.GetReactionData().GetType() == gmproto.EmojiType_REACTION_TYPE_UNSPECIFIED {
+		t.Errorf("ReactionData = %+v", got.GetReactionData())
+	}
+
+	if _, err := SendReaction(store, fake, "c1", "tmp_1", "👍", ""); !errors.Is(err, ErrReactionPending) {
+		t.Errorf("tmp_ message: err = %v, want ErrReactionPending", err)
+	}
+	if len(fake.sent) != 1 {
+		t.Errorf("tmp_ reaction was sent to the phone")
+	}
+}
+
+func TestApplySendAs(t *testing.T) {
+	for _, tc := range []struct {
+		sendAs string
+		want   bool
+	}{
+		{db.SendAsAuto, false},
+		{db.SendAsSMS, false},
+		{db.SendAsRCS, true},
+	} {
+		payload := BuildSendPayload("conv-1", "hi", "", "+15551234567", nil)
+		ApplySendAs(payload, tc.sendAs)
+		if payload.ForceRCS != tc.want {
+			t.Errorf("send_as %q: ForceRCS = %v, want %v", tc.sendAs, payload.ForceRCS, tc.want)
+		}
+	}
+}
+
+func TestUploadAndSendMediaHonorsSendAs(t *testing.T) {
+	store, err := db.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	store.UpsertConversation(&db.Conversation{ConversationID: "c1"})
+	store.SetConversationSendAs("c1", db.SendAsRCS)
+
+	fake := &fakeMediaClient{}
+	if _, err := SendFiles(store, fake, "c1", "tmp_000000000003", []MediaFile{{Data: []byte("img"), Filename: "a.png", MimeType: "image/png"}}, "", ""); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.sent) != 1 || !fake.sent[0].ForceRCS {
+		t.Errorf("expected ForceRCS on the sent payload, got %+v", fake.sent)
+	}
+}
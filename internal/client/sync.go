@@ -0,0 +1,274 @@
+package client
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/maxghenis/openmessage/internal/db"
+)
+
+// ErrSyncInProgress is returned by SyncRetrier.Retry while another retry runs.
+var ErrSyncInProgress = errors.New("sync retry already in progress")
+
+// maxRetryBatch bounds how many items a single retry run attempts.
+const maxRetryBatch = 1000
+
+// SyncMessage upserts a message to Supabase, or flags it deleted there if
+// its sender unsent it, and records the outcome in the local sync state.
+func SyncMessage(store *db.Store, sb SupabaseSync, m *db.Message) error {
+	if m.Deleted {
+		err := sb.SetMessageDeleted(m.MessageID, m.ConversationID)
+		store.MarkSyncResult(db.SyncKindMessage, m.MessageID, err)
+		return err
+	}
+	err := sb.UpsertMessage(
+		m.MessageID, m.ConversationID,
+		m.SenderName, m.SenderNumber,
+		m.Body, time.UnixMilli(m.TimestampMS), m.IsFromMe,
+		m.MimeType, "",
+		m.Reactions, m.ReplyToID,
+	)
+	store.MarkSyncResult(db.SyncKindMessage, m.MessageID, err)
+	return err
+}
+
+// SyncConversation upserts a conversation and its participant contacts to
+// Supabase and records the outcome in the local sync state. Contact failures
+// are not tracked; they are retried with the conversation's next sync.
+func SyncConversation(store *db.Store, sb SupabaseSync, c *db.Conversation) error {
+	err := sb.UpsertConversation(
+		c.ConversationID, c.Name,
+		time.UnixMilli(c.LastMessageTS), c.IsGroup, "",
+	)
+	store.MarkSyncResult(db.SyncKindConversation, c.ConversationID, err)
+	if err != nil {
+		return err
+	}
+
+	var participants []struct {
+		Name   string `json:"name"`
+		Number string `json:"number"`
+		IsMe   bool   `json:"is_me,omitempty"`
+	}
+	if err := json.Unmarshal([]byte(c.Participants), &participants); err == nil {
+		for _, p := range participants {
+			if p.Number != "" && !p.IsMe {
+				sb.UpsertContact(p.Number, p.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// SyncStarred pushes a message's starred flag to Supabase and records the
+// outcome in the local sync state. A star on a message Supabase doesn't have
+// yet fails and stays there until the message is upserted.
+func SyncStarred(store *db.Store, sb SupabaseSync, m *db.Message) error {
+	err := sb.SetMessageStarred(m.MessageID, m.ConversationID, m.Starred)
+	store.MarkSyncResult(db.SyncKindStar, m.MessageID, err)
+	return err
+}
+
+// ReconcileStarred merges starred flags between the local store and Supabase.
+// Local stars and unstars still waiting to sync win and are pushed. Otherwise
+// Supabase wins: remote stars are applied locally, and local stars on
+// messages Supabase has unstarred are cleared. Local stars on messages
+// Supabase hasn't seen are pushed. Remote stars for messages not stored
+// locally yet are picked up by a later reconcile.
+func ReconcileStarred(store *db.Store, sb SupabaseSync) (pulled, pushed int, err error) {
+	unsynced, err := store.ListUnsynced(maxRetryBatch)
+	if err != nil {
+		return 0, 0, fmt.Errorf("list unsynced stars: %w", err)
+	}
+	pending := make(map[string]bool)
+	for _, it := range unsynced {
+		if it.Kind != db.SyncKindStar {
+			continue
+		}
+		pending[it.ItemID] = true
+		m, err := store.GetMessageByID(it.ItemID)
+		if err != nil {
+			return pulled, pushed, fmt.Errorf("get message: %w", err)
+		}
+		if m == nil {
+			store.MarkSyncResult(it.Kind, it.ItemID, nil)
+			continue
+		}
+		// A failed push stays in the sync state for the retrier.
+		if SyncStarred(store, sb, m) == nil {
+			pushed++
+		}
+	}
+
+	remote, err := sb.ListStarredMessageIDs()
+	if err != nil {
+		return pulled, pushed, fmt.Errorf("list remote stars: %w", err)
+	}
+	local, err := store.ListStarredMessages(maxRetryBatch)
+	if err != nil {
+		return pulled, pushed, fmt.Errorf("list local stars: %w", err)
+	}
+
+	remoteSet := make(map[string]bool, len(remote))
+	for _, id := range remote {
+		remoteSet[id] = true
+	}
+	localSet := make(map[string]bool, len(local))
+	var missing []*db.Message
+	for _, m := range local {
+		localSet[m.MessageID] = true
+		if !remoteSet[m.MessageID] && !pending[m.MessageID] {
+			missing = append(missing, m)
+		}
+	}
+	for _, id := range remote {
+		if localSet[id] || pending[id] {
+			continue
+		}
+		err := store.SetMessageStarred(id, true)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return pulled, pushed, fmt.Errorf("set local star: %w", err)
+		}
+		pulled++
+	}
+	if len(missing) == 0 {
+		return pulled, pushed, nil
+	}
+
+	ids := make([]string, len(missing))
+	for i, m := range missing {
+		ids[i] = m.MessageID
+	}
+	stars, err := sb.MessageStars(ids)
+	if err != nil {
+		return pulled, pushed, fmt.Errorf("get remote stars: %w", err)
+	}
+	for _, m := range missing {
+		if _, synced := stars[m.MessageID]; synced {
+			// Unstarred in Supabase since the last reconcile.
+			if err := store.SetMessageStarred(m.MessageID, false); err != nil {
+				return pulled, pushed, fmt.Errorf("clear local star: %w", err)
+			}
+			pulled++
+			continue
+		}
+		store.MarkSyncPending(db.SyncKindStar, m.MessageID)
+		if SyncStarred(store, sb, m) == nil {
+			pushed++
+		}
+	}
+	return pulled, pushed, nil
+}
+
+// ReconcileReadPositions merges last-read positions between the local store
+// and Supabase; the later position wins on each side. Remote positions for
+// conversations not stored locally yet are picked up by a later reconcile.
+func ReconcileReadPositions(store *db.Store, sb SupabaseSync) (pulled, pushed int, err error) {
+	remote, err := sb.ListConversationReads()
+	if err != nil {
+		return 0, 0, fmt.Errorf("list remote read positions: %w", err)
+	}
+	local, err := store.ConversationReads()
+	if err != nil {
+		return 0, 0, fmt.Errorf("list local read positions: %w", err)
+	}
+
+	for id, ts := range local {
+		if r, ok := remote[id]; ok && r.UnixMilli() >= ts {
+			continue
+		}
+		if err := sb.SetConversationRead(id, time.UnixMilli(ts)); err != nil {
+			return pulled, pushed, fmt.Errorf("push read position: %w", err)
+		}
+		pushed++
+	}
+	for id, r := range remote {
+		ts := r.UnixMilli()
+		if ts <= local[id] {
+			continue
+		}
+		err := store.AdvanceConversationRead(id, ts)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return pulled, pushed, fmt.Errorf("set local read position: %w", err)
+		}
+		pulled++
+	}
+	return pulled, pushed, nil
+}
+
+// SyncRetrier re-sends pending and failed Supabase upserts. Only one retry
+// runs at a time.
+type SyncRetrier struct {
+	Store    *db.Store
+	Supabase SupabaseSync
+
+	running atomic.Bool
+}
+
+// Retry re-attempts every unsynced item and reports how many succeeded and
+// failed. Items whose local row no longer exists are marked synced and not
+// counted.
+func (r *SyncRetrier) Retry() (succeeded, failed int, err error) {
+	if !r.running.CompareAndSwap(false, true) {
+		return 0, 0, ErrSyncInProgress
+	}
+	defer r.running.Store(false)
+
+	items, err := r.Store.ListUnsynced(maxRetryBatch)
+	if err != nil {
+		return 0, 0, fmt.Errorf("list unsynced: %w", err)
+	}
+	for _, it := range items {
+		var syncErr error
+		switch it.Kind {
+		case db.SyncKindMessage:
+			m, err := r.Store.GetMessageByID(it.ItemID)
+			if err != nil {
+				return succeeded, failed, fmt.Errorf("get message: %w", err)
+			}
+			if m == nil {
+				r.Store.MarkSyncResult(it.Kind, it.ItemID, nil)
+				continue
+			}
+			syncErr = SyncMessage(r.Store, r.Supabase, m)
+		case db.SyncKindConversation:
+			c, err := r.Store.GetConversation(it.ItemID)
+			if errors.Is(err, sql.ErrNoRows) {
+				r.Store.MarkSyncResult(it.Kind, it.ItemID, nil)
+				continue
+			}
+			if err != nil {
+				return succeeded, failed, fmt.Errorf("get conversation: %w", err)
+			}
+			syncErr = SyncConversation(r.Store, r.Supabase, c)
+		case db.SyncKindStar:
+			m, err := r.Store.GetMessageByID(it.ItemID)
+			if err != nil {
+				return succeeded, failed, fmt.Errorf("get message: %w", err)
+			}
+			if m == nil {
+				r.Store.MarkSyncResult(it.Kind, it.ItemID, nil)
+				continue
+			}
+			syncErr = SyncStarred(r.Store, r.Supabase, m)
+		default:
+			continue
+		}
+		if syncErr != nil {
+			failed++
+		} else {
+			succeeded++
+		}
+	}
+	return succeeded, failed, nil
+}
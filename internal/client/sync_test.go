@@ -0,0 +1,344 @@
+package client
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/maxghenis/openmessage/internal/db"
+)
+
+// fakeSupabase records upserts and fails them while down is set.
+type fakeSupabase struct {
+	mu       sync.Mutex
+	down     bool
+	block    chan struct{}
+	messages []string
+	convs    []string
+	contacts []string
+	starred  map[string]bool
+	reads    map[string]time.Time
+	extras   map[string][2]string // message ID -> reactions, reply-to ID
+	deleted  []string
+}
+
+func (f *fakeSupabase) UpsertConversation(convID, name string, lastMessageTime time.Time, isGroup bool, lastPreview string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.down {
+		return errors.New("supabase unavailable")
+	}
+	f.convs = append(f.convs, convID)
+	return nil
+}
+
+func (f *fakeSupabase) UpsertMessage(id, conversationID, senderName, senderNumber, content string, timestamp time.Time, isFromMe bool, mediaType, mediaURL, reactions, replyToID string) error {
+	if f.block != nil {
+		<-f.block
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.down {
+		return errors.New("supabase unavailable")
+	}
+	f.messages = append(f.messages, id)
+	if f.extras == nil {
+		f.extras = map[string][2]string{}
+	}
+	f.extras[id] = [2]string{reactions, replyToID}
+	return nil
+}
+
+func (f *fakeSupabase) UpsertContact(number, name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.contacts = append(f.contacts, number)
+	return nil
+}
+
+func (f *fakeSupabase) SetMessageStarred(id, conversationID string, starred bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.down {
+		return errors.New("supabase unavailable")
+	}
+	if f.starred == nil {
+		f.starred = map[string]bool{}
+	}
+	f.starred[id] = starred
+	return nil
+}
+
+func (f *fakeSupabase) SetMessageDeleted(id, conversationID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.down {
+		return errors.New("supabase unavailable")
+	}
+	f.deleted = append(f.deleted, id)
+	return nil
+}
+
+func (f *fakeSupabase) ListStarredMessageIDs() ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var ids []string
+	for id, starred := range f.starred {
+		if starred {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// MessageStars reports the flags of messages in the starred map; others are
+// treated as not synced.
+func (f *fakeSupabase) MessageStars(ids []string) (map[string]bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	stars := map[string]bool{}
+	for _, id := range ids {
+		if starred, ok := f.starred[id]; ok {
+			stars[id] = starred
+		}
+	}
+	return stars, nil
+}
+
+func (f *fakeSupabase) SetConversationRead(convID string, lastRead time.Time) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.down {
+		return errors.New("supabase unavailable")
+	}
+	if f.reads == nil {
+		f.reads = map[string]time.Time{}
+	}
+	if lastRead.After(f.reads[convID]) {
+		f.reads[convID] = lastRead
+	}
+	return nil
+}
+
+func (f *fakeSupabase) ListConversationReads() (map[string]time.Time, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	reads := make(map[string]time.Time, len(f.reads))
+	for id, t := range f.reads {
+		reads[id] = t
+	}
+	return reads, nil
+}
+
+// Enqueue runs job inline, as a Writer without a worker pool does.
+func (f *fakeSupabase) Enqueue(job func()) bool {
+	job()
+	return true
+}
+
+func TestSyncRetrierRetriesFailedItems(t *testing.T) {
+	store, err := db.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	conv := &db.Conversation{ConversationID: "c1", Name: "Alice", Participants: `[{"name":"Alice","number":"+15551234567"}]`}
+	msg := &db.Message{MessageID: "m1", ConversationID: "c1", Body: "hi", TimestampMS: 1000}
+	store.UpsertConversation(conv)
+	store.UpsertMessage(msg)
+
+	sb := &fakeSupabase{down: true}
+	if err := SyncConversation(store, sb, conv); err == nil {
+		t.Fatal("expected conversation sync to fail while down")
+	}
+	if err := SyncMessage(store, sb, msg); err == nil {
+		t.Fatal("expected message sync to fail while down")
+	}
+	// A pending item whose row was since deleted is dropped, not counted.
+	store.MarkSyncPending(db.SyncKindMessage, "tmp_gone")
+
+	sb.down = false
+	r := &SyncRetrier{Store: store, Supabase: sb}
+	succeeded, failed, err := r.Retry()
+	if err != nil {
+		t.Fatalf("retry: %v", err)
+	}
+	if succeeded != 2 || failed != 0 {
+		t.Errorf("got %d succeeded, %d failed; want 2, 0", succeeded, failed)
+	}
+	if len(sb.messages) != 1 || len(sb.convs) != 1 || len(sb.contacts) != 1 {
+		t.Errorf("upserts: messages=%v convs=%v contacts=%v", sb.messages, sb.convs, sb.contacts)
+	}
+
+	if it, _ := store.GetSyncItem(db.SyncKindMessage, "m1"); it != nil {
+		t.Errorf("message sync state after success: %+v", it)
+	}
+	if left, _ := store.ListUnsynced(10); len(left) != 0 {
+		t.Errorf("unsynced after retry: %d items", len(left))
+	}
+}
+
+func TestSyncMessagePassesReactionsAndReply(t *testing.T) {
+	store, err := db.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	reactions := `[{"emoji":"👍","count":1}]`
+	msg := &db.Message{MessageID: "m2", ConversationID: "c1", Body: "agreed", TimestampMS: 2000, Reactions: reactions, ReplyToID: "m1"}
+	store.UpsertMessage(msg)
+
+	sb := &fakeSupabase{}
+	if err := SyncMessage(store, sb, msg); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	if got := sb.extras["m2"]; got != [2]string{reactions, "m1"} {
+		t.Errorf("reactions, reply = %q", got)
+	}
+}
+
+func TestSyncRetrierRejectsConcurrentRuns(t *testing.T) {
+	store, err := db.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	store.UpsertMessage(&db.Message{MessageID: "m1", ConversationID: "c1"})
+	store.MarkSyncPending(db.SyncKindMessage, "m1")
+
+	sb := &fakeSupabase{block: make(chan struct{})}
+	r := &SyncRetrier{Store: store, Supabase: sb}
+
+	done := make(chan struct{})
+	go func() {
+		r.Retry()
+		close(done)
+	}()
+	for !r.running.Load() {
+		time.Sleep(time.Millisecond)
+	}
+
+	if _, _, err := r.Retry(); !errors.Is(err, ErrSyncInProgress) {
+		t.Errorf("second retry: err = %v, want ErrSyncInProgress", err)
+	}
+	close(sb.block)
+	<-done
+}
+
+func TestReconcileStarred(t *testing.T) {
+	store, err := db.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	for _, id := range []string{"m1", "m2", "m3"} {
+		store.UpsertMessage(&db.Message{MessageID: id, ConversationID: "c1", Body: "hi", TimestampMS: 1000})
+	}
+	store.SetMessageStarred("m1", true)
+
+	sb := &fakeSupabase{starred: map[string]bool{"m2": true, "not-local": true}}
+	pulled, pushed, err := ReconcileStarred(store, sb)
+	if err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	if pulled != 1 || pushed != 1 {
+		t.Errorf("pulled=%d pushed=%d, want 1 and 1", pulled, pushed)
+	}
+	if !sb.starred["m1"] {
+		t.Error("local star on m1 was not pushed")
+	}
+	if m, _ := store.GetMessageByID("m2"); !m.Starred {
+		t.Error("remote star on m2 was not applied locally")
+	}
+	if m, _ := store.GetMessageByID("m3"); m.Starred {
+		t.Error("m3 should not be starred")
+	}
+}
+
+func TestReconcileStarredUnstars(t *testing.T) {
+	store, err := db.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	for _, id := range []string{"m1", "m2", "m3"} {
+		store.UpsertMessage(&db.Message{MessageID: id, ConversationID: "c1", Body: "hi", TimestampMS: 1000})
+	}
+	// m1 was unstarred on another device; m2 was unstarred here while
+	// Supabase was unreachable; m3 was starred here and hasn't synced.
+	store.SetMessageStarred("m1", true)
+	store.MarkSyncPending(db.SyncKindStar, "m2")
+	store.SetMessageStarred("m3", true)
+	store.MarkSyncPending(db.SyncKindStar, "m3")
+
+	sb := &fakeSupabase{starred: map[string]bool{"m1": false, "m2": true}}
+	sb.down = true
+	pulled, pushed, err := ReconcileStarred(store, sb)
+	if err != nil {
+		t.Fatalf("reconcile while down: %v", err)
+	}
+	if pulled != 1 || pushed != 0 {
+		t.Errorf("while down: pulled=%d pushed=%d, want 1 and 0", pulled, pushed)
+	}
+	if m, _ := store.GetMessageByID("m1"); m.Starred {
+		t.Error("remote unstar on m1 was not applied locally")
+	}
+	if it, _ := store.GetSyncItem(db.SyncKindStar, "m2"); it == nil || it.Status != db.SyncFailed {
+		t.Errorf("m2 star sync state = %+v, want failed", it)
+	}
+	sb.down = false
+
+	pulled, pushed, err = ReconcileStarred(store, sb)
+	if err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	if pulled != 0 || pushed != 2 {
+		t.Errorf("pulled=%d pushed=%d, want 0 and 2", pulled, pushed)
+	}
+	if m, _ := store.GetMessageByID("m2"); m.Starred || sb.starred["m2"] {
+		t.Error("pending local unstar on m2 lost to the remote star")
+	}
+	if !sb.starred["m3"] {
+		t.Error("pending local star on m3 was not pushed")
+	}
+	if left, _ := store.ListUnsynced(10); len(left) != 0 {
+		t.Errorf("%d items left unsynced", len(left))
+	}
+}
+
+func TestReconcileReadPositions(t *testing.T) {
+	store, err := db.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	store.UpsertConversation(&db.Conversation{ConversationID: "local-ahead", LastMessageTS: 3000})
+	store.UpsertConversation(&db.Conversation{ConversationID: "remote-ahead", LastMessageTS: 4000, UnreadCount: 2})
+	store.MarkConversationRead("local-ahead")
+
+	sb := &fakeSupabase{reads: map[string]time.Time{
+		"local-ahead":  time.UnixMilli(1000),
+		"remote-ahead": time.UnixMilli(4000),
+		"not-local":    time.UnixMilli(9000),
+	}}
+	pulled, pushed, err := ReconcileReadPositions(store, sb)
+	if err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	if pulled != 1 || pushed != 1 {
+		t.Errorf("pulled=%d pushed=%d, want 1 and 1", pulled, pushed)
+	}
+	if got := sb.reads["local-ahead"].UnixMilli(); got != 3000 {
+		t.Errorf("remote local-ahead = %d, want 3000", got)
+	}
+	if ts, _ := store.ConversationLastRead("remote-ahead"); ts != 4000 {
+		t.Errorf("local remote-ahead = %d, want 4000", ts)
+	}
+	if c, _ := store.GetConversation("remote-ahead"); c.UnreadCount != 0 {
+		t.Errorf("remote-ahead unread = %d, want 0 once read up to the newest message", c.UnreadCount)
+	}
+}
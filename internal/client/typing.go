@@ -0,0 +1,111 @@
+package client
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// TypingTTL is how long a typing indicator lasts without a refresh. The
+// phone sends no stop event when the other side just walks away.
+const TypingTTL = 6 * time.Second
+
+// TypingState is someone currently typing in a conversation.
+type TypingState struct {
+	ConversationID string    `json:"conversation_id"`
+	Number         string    `json:"number"`
+	Name           string    `json:"name,omitempty"`
+	ExpiresAt      time.Time `json:"expires_at"`
+}
+
+// TypingTracker holds short-lived typing indicators keyed by conversation.
+// It is safe for concurrent use.
+type TypingTracker struct {
+	ttl time.Duration
+	now func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]TypingState
+	changed chan struct{} // closed and replaced on every change
+}
+
+// NewTypingTracker returns a tracker whose entries expire after ttl.
+func NewTypingTracker(ttl time.Duration) *TypingTracker {
+	return &TypingTracker{
+		ttl:     ttl,
+		now:     time.Now,
+		entries: make(map[string]TypingState),
+		changed: make(chan struct{}),
+	}
+}
+
+// Start records that number is typing in convID, extending any existing
+// indicator.
+func (t *TypingTracker) Start(convID, number, name string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.entries[convID] = TypingState{
+		ConversationID: convID,
+		Number:         number,
+		Name:           name,
+		ExpiresAt:      t.now().Add(t.ttl),
+	}
+	t.notifyLocked()
+}
+
+// Stop clears the typing indicator for convID.
+func (t *TypingTracker) Stop(convID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.entries[convID]; !ok {
+		return
+	}
+	delete(t.entries, convID)
+	t.notifyLocked()
+}
+
+// Active returns unexpired indicators ordered by conversation ID, dropping
+// expired ones.
+func (t *TypingTracker) Active() []TypingState {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	out := []TypingState{}
+	for id, st := range t.entries {
+		if !now.Before(st.ExpiresAt) {
+			delete(t.entries, id)
+			continue
+		}
+		out = append(out, st)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ConversationID < out[j].ConversationID })
+	return out
+}
+
+// Wait blocks until an indicator starts, stops, or expires, or until
+// timeout or ctx ends, whichever is first.
+func (t *TypingTracker) Wait(ctx context.Context, timeout time.Duration) {
+	t.mu.Lock()
+	changed := t.changed
+	now := t.now()
+	for _, st := range t.entries {
+		if d := st.ExpiresAt.Sub(now); d < timeout {
+			timeout = max(d, 0)
+		}
+	}
+	t.mu.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-changed:
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
+
+func (t *TypingTracker) notifyLocked() {
+	close(t.changed)
+	t.changed = make(chan struct{})
+}
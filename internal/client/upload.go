@@ -0,0 +1,38 @@
+package client
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/maxghenis/openmessage/internal/db"
+)
+
+// MediaStorage is the subset of *supabase.Writer used to upload attachments.
+type MediaStorage interface {
+	UploadMedia(path string, data []byte, contentType string) (string, error)
+}
+
+// UploadMediaOnce uploads data to path in storage and returns its public
+// URL. Bytes that were uploaded before, under any path, aren't sent again;
+// the earlier URL is returned instead. Uploads are remembered by SHA-256;
+// if one can't be recorded, its URL is returned along with the error.
+func UploadMediaOnce(store *db.Store, storage MediaStorage, path string, data []byte, contentType string) (string, error) {
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	url, err := store.MediaUploadURL(hash)
+	if err != nil {
+		return "", fmt.Errorf("look up upload: %w", err)
+	}
+	if url != "" {
+		return url, nil
+	}
+	url, err = storage.UploadMedia(path, data, contentType)
+	if err != nil {
+		return "", err
+	}
+	if err := store.RecordMediaUpload(hash, url, int64(len(data))); err != nil {
+		return url, fmt.Errorf("record upload: %w", err)
+	}
+	return url, nil
+}
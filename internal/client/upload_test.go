@@ -0,0 +1,62 @@
+package client
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/maxghenis/openmessage/internal/db"
+)
+
+type fakeMediaStorage struct {
+	uploads []string
+	err     error
+}
+
+func (f *fakeMediaStorage) UploadMedia(path string, data []byte, contentType string) (string, error) {
+	if f.err != nil {
+		return "", f.err
+	}
+	f.uploads = append(f.uploads, path)
+	return "https://cdn.example/" + path, nil
+}
+
+func TestUploadMediaOnce(t *testing.T) {
+	store, err := db.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	storage := &fakeMediaStorage{}
+
+	url, err := UploadMediaOnce(store, storage, "c1/m1.jpg", []byte("photo"), "image/jpeg")
+	if err != nil || url != "https://cdn.example/c1/m1.jpg" {
+		t.Fatalf("first upload = %q, %v", url, err)
+	}
+
+	// The same bytes under another path reuse the first upload.
+	url, err = UploadMediaOnce(store, storage, "c2/m9.jpg", []byte("photo"), "image/jpeg")
+	if err != nil || url != "https://cdn.example/c1/m1.jpg" {
+		t.Errorf("repeat upload = %q, %v; want the first URL", url, err)
+	}
+	if len(storage.uploads) != 1 {
+		t.Errorf("uploaded %d times, want once: %v", len(storage.uploads), storage.uploads)
+	}
+
+	// Different bytes are uploaded.
+	if _, err := UploadMediaOnce(store, storage, "c1/m2.jpg", []byte("other"), "image/jpeg"); err != nil {
+		t.Fatal(err)
+	}
+	if len(storage.uploads) != 2 {
+		t.Errorf("uploads = %v, want a second one for new bytes", storage.uploads)
+	}
+
+	// Failed uploads aren't remembered.
+	storage.err = errors.New("storage down")
+	if _, err := UploadMediaOnce(store, storage, "c1/m3.jpg", []byte("new"), "image/jpeg"); err == nil {
+		t.Error("upload error not returned")
+	}
+	storage.err = nil
+	if url, _ := UploadMediaOnce(store, storage, "c1/m3.jpg", []byte("new"), "image/jpeg"); url != "https://cdn.example/c1/m3.jpg" {
+		t.Errorf("retry after failure = %q", url)
+	}
+}
@@ -0,0 +1,144 @@
+package client
+
+import (
+	"errors"
+	"slices"
+	"strings"
+
+	"go.mau.fi/mautrix-gmessages/pkg/libgm/gmproto"
+
+	"github.com/maxghenis/openmessage/internal/db"
+)
+
+// BuildVCard returns a vCard 3.0 contact card for sharing over RCS/MMS.
+// Both name and number are required, and the number must contain a digit.
+func BuildVCard(name, number string) ([]byte, error) {
+	name = strings.TrimSpace(name)
+	number = strings.TrimSpace(number)
+	if name == "" {
+		return nil, errors.New("contact name is required")
+	}
+	if !strings.ContainsAny(number, "0123456789") {
+		return nil, errors.New("contact number must contain digits")
+	}
+
+	var sb strings.Builder
+	sb.WriteString("BEGIN:VCARD\r\n")
+	sb.WriteString("VERSION:3.0\r\n")
+	sb.WriteString("FN:" + escapeVCard(name) + "\r\n")
+	sb.WriteString("N:" + escapeVCard(name) + ";;;;\r\n")
+	sb.WriteString("TEL;TYPE=CELL:" + escapeVCard(number) + "\r\n")
+	sb.WriteString("END:VCARD\r\n")
+	return []byte(sb.String()), nil
+}
+
+// escapeVCard escapes text values per RFC 6350 section 3.4.
+func escapeVCard(s string) string {
+	return strings.NewReplacer(
+		`\`, `\\`,
+		",", `\,`,
+		";", `\;`,
+		"\r\n", `\n`,
+		"\n", `\n`,
+	).Replace(s)
+}
+
+// vcardMimeTypes are the attachment types a shared contact card arrives as.
+var vcardMimeTypes = []string{"text/vcard", "text/x-vcard", "text/directory"}
+
+// ExtractSharedContact extracts a contact card sent inline as an attachment.
+// Returns nil if the message has no card or its bytes weren't sent inline.
+func ExtractSharedContact(msg *gmproto.Message) *db.SharedContact {
+	for _, info := range msg.GetMessageInfo() {
+		mc := info.GetMediaContent()
+		if mc == nil || len(mc.GetMediaData()) == 0 {
+			continue
+		}
+		mime, _, _ := strings.Cut(strings.ToLower(mc.GetMimeType()), ";")
+		if slices.Contains(vcardMimeTypes, strings.TrimSpace(mime)) {
+			return ParseVCard(mc.GetMediaData())
+		}
+	}
+	return nil
+}
+
+// ParseVCard reads the name and phone numbers from a vCard. It never fails:
+// a card with neither is returned with its text in Raw.
+func ParseVCard(card []byte) *db.SharedContact {
+	c := &db.SharedContact{}
+	var structured string
+	for _, line := range unfoldVCard(string(card)) {
+		prop, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		name, _, _ := strings.Cut(prop, ";")
+		if i := strings.LastIndex(name, "."); i >= 0 {
+			name = name[i+1:] // drop a group prefix such as "item1."
+		}
+		switch strings.ToUpper(strings.TrimSpace(name)) {
+		case "FN":
+			if c.Name == "" {
+				c.Name = strings.TrimSpace(unescapeVCard(value))
+			}
+		case "N":
+			if structured == "" {
+				structured = structuredVCardName(value)
+			}
+		case "TEL":
+			number := strings.TrimSpace(unescapeVCard(value))
+			number = strings.TrimPrefix(number, "tel:")
+			if number != "" && !slices.Contains(c.Numbers, number) {
+				c.Numbers = append(c.Numbers, number)
+			}
+		}
+	}
+	if c.Name == "" {
+		c.Name = structured
+	}
+	if c.Name == "" && len(c.Numbers) == 0 {
+		c.Raw = strings.TrimSpace(string(card))
+	}
+	return c
+}
+
+// unfoldVCard splits a card into logical lines, joining folded continuation
+// lines (those starting with a space or tab) per RFC 6350 section 3.2.
+func unfoldVCard(card string) []string {
+	var lines []string
+	for _, line := range strings.Split(strings.ReplaceAll(card, "\r\n", "\n"), "\n") {
+		if len(lines) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// structuredVCardName turns an N value ("Family;Given;Additional;Prefix;
+// Suffix") into a display name such as "Dr. Jane Doe".
+func structuredVCardName(value string) string {
+	fields := strings.Split(value, ";")
+	order := []int{3, 1, 2, 0, 4}
+	var parts []string
+	for _, i := range order {
+		if i < len(fields) {
+			if f := strings.TrimSpace(unescapeVCard(fields[i])); f != "" {
+				parts = append(parts, f)
+			}
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// unescapeVCard reverses escapeVCard.
+func unescapeVCard(s string) string {
+	return strings.NewReplacer(
+		`\\`, `\`,
+		`\,`, ",",
+		`\;`, ";",
+		`\n`, "\n",
+		`\N`, "\n",
+	).Replace(s)
+}
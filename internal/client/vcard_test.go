@@ -0,0 +1,88 @@
+package client
+
+import (
+	"strings"
+	"testing"
+
+	"go.mau.fi/mautrix-gmessages/pkg/libgm/gmproto"
+)
+
+func TestBuildVCard(t *testing.T) {
+	card, err := BuildVCard(" Sarah Chen ", "+1 (415) 555-1234")
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	want := "BEGIN:VCARD\r\nVERSION:3.0\r\nFN:Sarah Chen\r\nN:Sarah Chen;;;;\r\nTEL;TYPE=CELL:+1 (415) 555-1234\r\nEND:VCARD\r\n"
+	if string(card) != want {
+		t.Errorf("got %q\nwant %q", card, want)
+	}
+
+	card, _ = BuildVCard("Smith, Jo; Jr.", "555")
+	if !strings.Contains(string(card), `FN:Smith\, Jo\; Jr.`) {
+		t.Errorf("special characters not escaped: %q", card)
+	}
+
+	if _, err := BuildVCard("", "+15551234567"); err == nil {
+		t.Error("expected error for empty name")
+	}
+	if _, err := BuildVCard("Sarah", "call me"); err == nil {
+		t.Error("expected error for number without digits")
+	}
+}
+
+func TestParseVCard(t *testing.T) {
+	card := "BEGIN:VCARD\r\nVERSION:3.0\r\nN:Doe;Jane;;Dr.;\r\nFN:Jane Doe\r\n" +
+		"item1.TEL;TYPE=CELL:+1 555 010\r\n 0200\r\nTEL;VALUE=uri:tel:+15550100300\r\nTEL:+1 555 0100200\r\nEND:VCARD\r\n"
+	c := ParseVCard([]byte(card))
+	if c.Name != "Jane Doe" {
+		t.Errorf("Name = %q, want Jane Doe", c.Name)
+	}
+	if len(c.Numbers) != 2 || c.Numbers[0] != "+1 555 0100200" || c.Numbers[1] != "+15550100300" {
+		t.Errorf("Numbers = %q", c.Numbers)
+	}
+	if c.Raw != "" {
+		t.Errorf("Raw = %q for a parsed card, want empty", c.Raw)
+	}
+	if got := c.Summary(); got != "Shared contact: Jane Doe +1 555 0100200 +15550100300" {
+		t.Errorf("Summary = %q", got)
+	}
+
+	// Without FN the structured name is used.
+	if c := ParseVCard([]byte("BEGIN:VCARD\nN:Doe;Jane;;Dr.;\nEND:VCARD\n")); c.Name != "Dr. Jane Doe" {
+		t.Errorf("Name from N = %q, want Dr. Jane Doe", c.Name)
+	}
+
+	// A malformed card is kept as text rather than rejected.
+	c = ParseVCard([]byte("not a vcard at all"))
+	if c.Raw != "not a vcard at all" || c.Name != "" || c.Numbers != nil {
+		t.Errorf("malformed card = %+v", c)
+	}
+	if got := c.Summary(); got != "Shared contact" {
+		t.Errorf("malformed Summary = %q", got)
+	}
+}
+
+func TestMessageToDBSharedContact(t *testing.T) {
+	card, _ := BuildVCard("Sarah Chen", "+14155551234")
+	msg := &gmproto.Message{
+		MessageID: "m1",
+		MessageInfo: []*gmproto.MessageInfo{
+			{Data: &gmproto.MessageInfo_MediaContent{
+				MediaContent: &gmproto.MediaContent{MediaID: "media-1", MimeType: "text/x-vcard", MediaData: card},
+			}},
+		},
+	}
+	m := MessageToDB(msg, false)
+	if m.SharedContact == nil || m.SharedContact.Name != "Sarah Chen" {
+		t.Fatalf("SharedContact = %+v", m.SharedContact)
+	}
+	if m.Body != "Shared contact: Sarah Chen +14155551234" {
+		t.Errorf("Body = %q", m.Body)
+	}
+
+	// Cards not sent inline have nothing to parse.
+	msg.MessageInfo[0].GetMediaContent().MediaData = nil
+	if m := MessageToDB(msg, false); m.SharedContact != nil || m.Body != "" {
+		t.Errorf("card without inline data: SharedContact = %+v, Body = %q", m.SharedContact, m.Body)
+	}
+}
@@ -0,0 +1,56 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Avatar is a contact's photo, as the phone sent it.
+type Avatar struct {
+	Number    string
+	Data      []byte
+	MimeType  string
+	UpdatedAt int64
+}
+
+// SetAvatar stores the photo for number, replacing any earlier one for the
+// same normalized number (see NormalizeNumber). Empty numbers are ignored.
+func (s *Store) SetAvatar(number string, data []byte, mimeType string) error {
+	key := s.NormalizeNumber(number)
+	if key == "" {
+		return nil
+	}
+	_, err := s.db.Exec(`
+		INSERT OR REPLACE INTO avatars (number_key, number, data, mime_type, updated_at)
+		VALUES (?, ?, ?, ?, ?)
+	`, key, number, data, mimeType, time.Now().UnixMilli())
+	return err
+}
+
+// GetAvatar returns the photo stored for a number matching number, or nil
+// if there is none.
+func (s *Store) GetAvatar(number string) (*Avatar, error) {
+	key := s.NormalizeNumber(number)
+	if key == "" {
+		return nil, nil
+	}
+	a := &Avatar{}
+	err := s.db.QueryRow(`
+		SELECT number, data, mime_type, updated_at FROM avatars WHERE number_key = ?
+	`, key).Scan(&a.Number, &a.Data, &a.MimeType, &a.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// HasAvatar reports whether a photo is stored for a number matching number.
+func (s *Store) HasAvatar(number string) (bool, error) {
+	var n int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM avatars WHERE number_key = ?`, s.NormalizeNumber(number)).Scan(&n)
+	return n > 0, err
+}
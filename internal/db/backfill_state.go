@@ -0,0 +1,45 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// BackfillState records how far deep backfill has paged back through a
+// conversation, so an interrupted or repeated run resumes instead of
+// re-fetching everything.
+type BackfillState struct {
+	ConversationID string
+	LastItemID     string
+	LastItemTS     int64 // microseconds, as the server reports it
+	OldestTS       int64 // oldest message timestamp seen, in milliseconds
+	Complete       bool  // the whole history has been fetched
+	UpdatedAt      int64
+}
+
+// GetBackfillState returns the saved deep backfill state for a conversation,
+// or nil if none has been saved.
+func (s *Store) GetBackfillState(convID string) (*BackfillState, error) {
+	st := &BackfillState{ConversationID: convID}
+	err := s.db.QueryRow(`
+		SELECT last_item_id, last_item_ts, oldest_ts, complete, updated_at
+		FROM backfill_state WHERE conversation_id = ?
+	`, convID).Scan(&st.LastItemID, &st.LastItemTS, &st.OldestTS, &st.Complete, &st.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// SaveBackfillState stores st as the conversation's deep backfill state.
+func (s *Store) SaveBackfillState(st *BackfillState) error {
+	_, err := s.db.Exec(`
+		INSERT OR REPLACE INTO backfill_state (conversation_id, last_item_id, last_item_ts, oldest_ts, complete, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, st.ConversationID, st.LastItemID, st.LastItemTS, st.OldestTS, st.Complete, time.Now().UnixMilli())
+	return err
+}
@@ -0,0 +1,97 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// Actions accepted by BulkConversations.
+const (
+	BulkArchive = "archive"
+	BulkMute    = "mute"
+	BulkRead    = "read"
+	BulkDelete  = "delete"
+)
+
+// bulkActions applies each bulk action to one conversation inside tx,
+// returning sql.ErrNoRows if the conversation doesn't exist.
+var bulkActions = map[string]func(s *Store, tx *sql.Tx, id string) error{
+	BulkArchive: func(s *Store, tx *sql.Tx, id string) error { return setConversationArchived(tx, id, true) },
+	BulkMute:    func(s *Store, tx *sql.Tx, id string) error { return setConversationMuted(tx, id, true) },
+	BulkRead:    func(s *Store, tx *sql.Tx, id string) error { return markConversationRead(tx, id) },
+	BulkDelete:  (*Store).deleteConversation,
+}
+
+// ConversationResult reports the outcome of a bulk action on one conversation.
+type ConversationResult struct {
+	ConversationID string `json:"conversation_id"`
+	Success        bool   `json:"success"`
+	Error          string `json:"error,omitempty"`
+}
+
+// BulkConversations applies action to each conversation in ids in a single
+// transaction. IDs that don't exist are reported as failures without
+// affecting the rest; a database error rolls everything back. Deleting
+// removes only the local copy along with its messages and drafts.
+func (s *Store) BulkConversations(ids []string, action string) ([]ConversationResult, error) {
+	apply, ok := bulkActions[action]
+	if !ok {
+		return nil, fmt.Errorf("unknown action %q", action)
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	results := make([]ConversationResult, len(ids))
+	for i, id := range ids {
+		results[i].ConversationID = id
+		err := apply(s, tx, id)
+		if errors.Is(err, sql.ErrNoRows) {
+			results[i].Error = "conversation not found"
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		results[i].Success = true
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// deleteConversation removes the local copy of a conversation inside tx,
+// with its messages and everything kept per message or per conversation.
+// It returns sql.ErrNoRows if the conversation doesn't exist.
+func (s *Store) deleteConversation(tx *sql.Tx, id string) error {
+	if s.fts {
+		if _, err := tx.Exec(`
+			DELETE FROM messages_fts WHERE rowid IN (
+				SELECT rowid FROM messages WHERE conversation_id = ?
+			)`, id); err != nil {
+			return fmt.Errorf("update search index: %w", err)
+		}
+	}
+	if _, err := tx.Exec(`
+		DELETE FROM sync_state WHERE (kind IN (?, ?) AND item_id IN (
+			SELECT message_id FROM messages WHERE conversation_id = ?
+		)) OR (kind = ? AND item_id = ?)`,
+		SyncKindMessage, SyncKindStar, id, SyncKindConversation, id); err != nil {
+		return err
+	}
+	for _, table := range []string{"message_status_events", "message_edits"} {
+		if _, err := tx.Exec(`DELETE FROM `+table+` WHERE message_id IN (SELECT message_id FROM messages WHERE conversation_id = ?)`, id); err != nil {
+			return err
+		}
+	}
+	for _, table := range []string{"messages", "send_latency", "drafts", "fetch_cursors", "backfill_state", "participants"} {
+		if _, err := tx.Exec(`DELETE FROM `+table+` WHERE conversation_id = ?`, id); err != nil {
+			return err
+		}
+	}
+	return execOne(tx, `DELETE FROM conversations WHERE conversation_id = ?`, id)
+}
@@ -0,0 +1,115 @@
+package db
+
+import "testing"
+
+func TestBulkConversations(t *testing.T) {
+	setup := func(t *testing.T) *Store {
+		store := newTestStore(t)
+		for _, id := range []string{"c1", "c2", "c3"} {
+			store.UpsertConversation(&Conversation{ConversationID: id, UnreadCount: 2, LastMessageTS: 1000})
+			store.UpsertMessage(&Message{MessageID: id + "-m", ConversationID: id, Body: "hello " + id, TimestampMS: 1000})
+		}
+		return store
+	}
+	ids := []string{"c1", "missing", "c2"}
+	checkResults := func(t *testing.T, results []ConversationResult) {
+		t.Helper()
+		want := []bool{true, false, true}
+		if len(results) != len(want) {
+			t.Fatalf("got %d results, want %d", len(results), len(want))
+		}
+		for i, r := range results {
+			if r.ConversationID != ids[i] || r.Success != want[i] {
+				t.Errorf("result %d = %+v, want success=%v", i, r, want[i])
+			}
+		}
+		if results[1].Error == "" {
+			t.Error("missing conversation reported without an error")
+		}
+	}
+
+	t.Run("archive", func(t *testing.T) {
+		store := setup(t)
+		results, err := store.BulkConversations(ids, BulkArchive)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkResults(t, results)
+		convs, _ := store.ListConversations(10)
+		if got := convIDs(convs); len(got) != 1 || got[0] != "c3" {
+			t.Errorf("unarchived = %v, want [c3]", got)
+		}
+	})
+
+	t.Run("mute", func(t *testing.T) {
+		store := setup(t)
+		results, err := store.BulkConversations(ids, BulkMute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkResults(t, results)
+		for id, want := range map[string]bool{"c1": true, "c2": true, "c3": false} {
+			if c, _ := store.GetConversation(id); c.Muted != want {
+				t.Errorf("%s muted = %v, want %v", id, c.Muted, want)
+			}
+		}
+		if n, _ := store.TotalUnread(); n != 2 {
+			t.Errorf("TotalUnread = %d, want 2", n)
+		}
+	})
+
+	t.Run("read", func(t *testing.T) {
+		store := setup(t)
+		results, err := store.BulkConversations(ids, BulkRead)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkResults(t, results)
+		for id, want := range map[string]int{"c1": 0, "c2": 0, "c3": 2} {
+			if c, _ := store.GetConversation(id); c.UnreadCount != want {
+				t.Errorf("%s unread = %d, want %d", id, c.UnreadCount, want)
+			}
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		store := setup(t)
+		store.UpsertDraft(&Draft{DraftID: "d1", ConversationID: "c1", Body: "later"})
+		store.SaveBackfillState(&BackfillState{ConversationID: "c1", Complete: true})
+		store.MarkSyncPending(SyncKindMessage, "c1-m")
+		store.MarkSyncPending(SyncKindConversation, "c1")
+		results, err := store.BulkConversations(ids, BulkDelete)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkResults(t, results)
+		for id, want := range map[string]bool{"c1": false, "c2": false, "c3": true} {
+			c, _ := store.GetConversation(id)
+			if (c != nil) != want {
+				t.Errorf("%s exists = %v, want %v", id, c != nil, want)
+			}
+			if m, _ := store.GetMessageByID(id + "-m"); (m != nil) != want {
+				t.Errorf("%s message exists = %v, want %v", id, m != nil, want)
+			}
+		}
+		if got, _ := store.SearchMessages("hello", "", 10); len(got) != 1 {
+			t.Errorf("search after delete = %v, want only c3's message", messageIDs(got))
+		}
+		if d, _ := store.GetDraft("d1"); d != nil {
+			t.Error("draft of deleted conversation still exists")
+		}
+		if st, _ := store.GetBackfillState("c1"); st != nil {
+			t.Error("backfill state of deleted conversation still exists")
+		}
+		if left, _ := store.ListUnsynced(10); len(left) != 0 {
+			t.Errorf("sync state of deleted conversation left: %+v", left)
+		}
+	})
+
+	t.Run("unknown action", func(t *testing.T) {
+		store := setup(t)
+		if _, err := store.BulkConversations(ids, "explode"); err == nil {
+			t.Error("expected error for unknown action")
+		}
+	})
+}
@@ -1,34 +1,121 @@
 package db
 
-import "encoding/json"
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+)
 
+// UpsertContact stores c with its number normalized (see NormalizeNumber).
+// The number as given is kept as the display number unless c.DisplayNumber
+// is set. c itself is not modified.
 func (s *Store) UpsertContact(c *Contact) error {
+	stored := *c
+	if stored.DisplayNumber == "" {
+		stored.DisplayNumber = c.Number
+	}
+	stored.Number = s.NormalizeNumber(c.Number)
 	_, err := s.db.Exec(`
-		INSERT INTO contacts (contact_id, name, number)
-		VALUES (?, ?, ?)
+		INSERT INTO contacts (contact_id, name, number, display_number)
+		VALUES (?, ?, ?, ?)
 		ON CONFLICT(contact_id) DO UPDATE SET
 			name=excluded.name,
-			number=excluded.number
-	`, c.ContactID, c.Name, c.Number)
+			number=excluded.number,
+			display_number=excluded.display_number
+	`, stored.ContactID, stored.Name, stored.Number, stored.DisplayNumber)
 	return err
 }
 
+// AutoContactPrefix marks contacts created for unknown senders rather than
+// synced from the phone's address book.
+const AutoContactPrefix = "auto:"
+
+// EnsureAutoContact creates a contact for number unless one with that number
+// already exists, comparing normalized numbers. name may be empty, in which
+// case the number is used. It reports whether a contact was created.
+func (s *Store) EnsureAutoContact(number, name string) (bool, error) {
+	if number == "" {
+		return false, nil
+	}
+	if name == "" {
+		name = number
+	}
+	normalized := s.NormalizeNumber(number)
+	res, err := s.db.Exec(`
+		INSERT INTO contacts (contact_id, name, number, display_number)
+		SELECT ?, ?, ?, ?
+		WHERE NOT EXISTS (SELECT 1 FROM contacts WHERE number = ?)
+	`, AutoContactPrefix+number, name, normalized, number, normalized)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// RenameAutoContact sets the name of an auto-created contact. Returns
+// sql.ErrNoRows if contactID isn't an auto-created contact.
+func (s *Store) RenameAutoContact(contactID, name string) error {
+	res, err := s.db.Exec(`
+		UPDATE contacts SET name = ? WHERE contact_id = ? AND contact_id LIKE ?
+	`, name, contactID, AutoContactPrefix+"%")
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// GetContact returns a contact by ID, or nil if it doesn't exist.
+func (s *Store) GetContact(contactID string) (*Contact, error) {
+	c := &Contact{}
+	err := s.db.QueryRow(`
+		SELECT contact_id, name, number, display_number FROM contacts WHERE contact_id = ?
+	`, contactID).Scan(&c.ContactID, &c.Name, &c.Number, &c.DisplayNumber)
+	if err != nil {
+		if err.Error() == "sql: no rows in result set" {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return c, nil
+}
+
+// ContactNameByNumber returns the name of a saved contact whose number
+// matches number (see NumbersMatch), or "" if there is none.
+func (s *Store) ContactNameByNumber(number string) (string, error) {
+	normalized := s.NormalizeNumber(number)
+	if normalized == "" {
+		return "", nil
+	}
+	var name string
+	err := s.db.QueryRow(`
+		SELECT name FROM contacts WHERE number = ? AND name != '' LIMIT 1
+	`, normalized).Scan(&name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return name, err
+}
+
 func (s *Store) ListContacts(query string, limit int) ([]*Contact, error) {
 	var rows_query string
 	var args []any
 
 	if query != "" {
 		rows_query = `
-			SELECT contact_id, name, number FROM contacts
-			WHERE name LIKE ? OR number LIKE ?
+			SELECT contact_id, name, number, display_number FROM contacts
+			WHERE name LIKE ? OR number LIKE ? OR display_number LIKE ?
 			ORDER BY name
 			LIMIT ?
 		`
 		like := "%" + query + "%"
-		args = []any{like, like, limit}
+		args = []any{like, like, like, limit}
 	} else {
 		rows_query = `
-			SELECT contact_id, name, number FROM contacts
+			SELECT contact_id, name, number, display_number FROM contacts
 			ORDER BY name
 			LIMIT ?
 		`
@@ -44,7 +131,7 @@ func (s *Store) ListContacts(query string, limit int) ([]*Contact, error) {
 	var contacts []*Contact
 	for rows.Next() {
 		c := &Contact{}
-		if err := rows.Scan(&c.ContactID, &c.Name, &c.Number); err != nil {
+		if err := rows.Scan(&c.ContactID, &c.Name, &c.Number, &c.DisplayNumber); err != nil {
 			return nil, err
 		}
 		contacts = append(contacts, c)
@@ -52,6 +139,80 @@ func (s *Store) ListContacts(query string, limit int) ([]*Contact, error) {
 	return contacts, rows.Err()
 }
 
+// contactActivityQuery selects each contact with the timestamp of the latest
+// message in any conversation listing their number as a participant.
+const contactActivityQuery = `
+	SELECT c.contact_id, c.name, c.number, c.display_number, COALESCE(MAX(m.timestamp_ms), 0) AS last_ts
+	FROM contacts c
+	LEFT JOIN participants p ON c.number != '' AND p.number_key = c.number
+	LEFT JOIN messages m ON m.conversation_id = p.conversation_id
+`
+
+// ListRecentContacts is like ListContacts but orders contacts by the most
+// recent message in any conversation they participate in. Contacts with no
+// messages come last, alphabetically.
+func (s *Store) ListRecentContacts(query string, limit int) ([]*Contact, error) {
+	where := ""
+	args := []any{}
+	if query != "" {
+		where = "WHERE c.name LIKE ? OR c.number LIKE ? OR c.display_number LIKE ?"
+		like := "%" + query + "%"
+		args = append(args, like, like, like)
+	}
+	args = append(args, limit)
+
+	activity, err := s.queryContactActivity(contactActivityQuery+where+`
+		GROUP BY c.contact_id
+		ORDER BY last_ts DESC, c.name
+		LIMIT ?
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	contacts := make([]*Contact, len(activity))
+	for i, a := range activity {
+		contacts[i] = &Contact{ContactID: a.ContactID, Name: a.Name, Number: a.Number, DisplayNumber: a.DisplayNumber}
+	}
+	return contacts, nil
+}
+
+// ListContactActivity returns when each contact was last messaged, longest
+// ago first, with never-messaged contacts at the end. If number is set only
+// that contact is returned.
+func (s *Store) ListContactActivity(number string, limit int) ([]*ContactActivity, error) {
+	where := ""
+	args := []any{}
+	if number != "" {
+		where = "WHERE c.number = ?"
+		args = append(args, s.NormalizeNumber(number))
+	}
+	args = append(args, limit)
+
+	return s.queryContactActivity(contactActivityQuery+where+`
+		GROUP BY c.contact_id
+		ORDER BY last_ts = 0, last_ts, c.name
+		LIMIT ?
+	`, args...)
+}
+
+func (s *Store) queryContactActivity(query string, args ...any) ([]*ContactActivity, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*ContactActivity
+	for rows.Next() {
+		a := &ContactActivity{}
+		if err := rows.Scan(&a.ContactID, &a.Name, &a.Number, &a.DisplayNumber, &a.LastMessageTS); err != nil {
+			return nil, err
+		}
+		out = append(out, a)
+	}
+	return out, rows.Err()
+}
+
 // ListContactsFromConversations extracts contacts from conversation participants
 // as a fallback when the contacts table is empty.
 func (s *Store) ListContactsFromConversations(query string, limit int) ([]*Contact, error) {
@@ -246,3 +246,85 @@ func TestListContacts_QueryMatchesBothNameAndNumber(t *testing.T) {
 		t.Errorf("count: got %d, want 2 (matches both name and number)", len(got))
 	}
 }
+
+func TestListRecentContacts(t *testing.T) {
+	store := newTestStore(t)
+
+	for _, c := range []Contact{
+		{ContactID: "c1", Name: "Alice", Number: "+15551111111"},
+		{ContactID: "c2", Name: "Bob", Number: "+15552222222"},
+		{ContactID: "c3", Name: "Carol", Number: "+15553333333"},
+	} {
+		c := c
+		if err := store.UpsertContact(&c); err != nil {
+			t.Fatalf("upsert contact: %v", err)
+		}
+	}
+	store.UpsertConversation(&Conversation{ConversationID: "conv-a", Participants: `[{"name":"Alice","number":"+15551111111"}]`})
+	store.UpsertConversation(&Conversation{ConversationID: "conv-b", Participants: `[{"name":"Bob","number":"+15552222222"}]`})
+	store.UpsertMessage(&Message{MessageID: "m1", ConversationID: "conv-a", Body: "old", TimestampMS: 1000})
+	store.UpsertMessage(&Message{MessageID: "m2", ConversationID: "conv-b", Body: "new", TimestampMS: 5000})
+
+	got, err := store.ListRecentContacts("", 10)
+	if err != nil {
+		t.Fatalf("list recent: %v", err)
+	}
+	var names []string
+	for _, c := range got {
+		names = append(names, c.Name)
+	}
+	if fmt.Sprint(names) != "[Bob Alice Carol]" {
+		t.Errorf("order = %v, want [Bob Alice Carol]", names)
+	}
+
+	got, err = store.ListRecentContacts("ali", 10)
+	if err != nil {
+		t.Fatalf("list recent with query: %v", err)
+	}
+	if len(got) != 1 || got[0].Name != "Alice" {
+		t.Errorf("query filter: got %v", got)
+	}
+}
+
+func TestEnsureAutoContact(t *testing.T) {
+	store := newTestStore(t)
+
+	store.UpsertContact(&Contact{ContactID: "c1", Name: "Alice", Number: "+15551111111"})
+	if created, err := store.EnsureAutoContact("+15551111111", "Alice"); err != nil || created {
+		t.Errorf("known number: created=%v err=%v, want no contact", created, err)
+	}
+
+	if created, err := store.EnsureAutoContact("+15559999999", ""); err != nil || !created {
+		t.Fatalf("unknown number: created=%v err=%v", created, err)
+	}
+	if created, _ := store.EnsureAutoContact("+15559999999", "Someone"); created {
+		t.Error("second call should not create a duplicate")
+	}
+
+	c, _ := store.GetContact(AutoContactPrefix + "+15559999999")
+	if c == nil || c.Name != "+15559999999" {
+		t.Fatalf("auto contact = %+v, want name defaulting to number", c)
+	}
+
+	if err := store.RenameAutoContact(c.ContactID, "Plumber"); err != nil {
+		t.Fatalf("rename: %v", err)
+	}
+	c, _ = store.GetContact(c.ContactID)
+	if c.Name != "Plumber" {
+		t.Errorf("name after rename = %q", c.Name)
+	}
+	if err := store.RenameAutoContact("c1", "Bob"); err == nil {
+		t.Error("expected error renaming a phone contact")
+	}
+}
+
+func TestContactNameByNumber(t *testing.T) {
+	store := newTestStore(t)
+	store.UpsertContact(&Contact{ContactID: "c1", Name: "Mom", Number: "+1 (555) 111-0000"})
+
+	for number, want := range map[string]string{"+15551110000": "Mom", "5551110000": "Mom", "+15552220000": "", "": ""} {
+		if got, err := store.ContactNameByNumber(number); err != nil || got != want {
+			t.Errorf("ContactNameByNumber(%q) = %q, %v; want %q", number, got, err, want)
+		}
+	}
+}
@@ -1,29 +1,73 @@
 package db
 
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Values accepted for a conversation's send_as preference.
+const (
+	SendAsAuto = "auto"
+	SendAsSMS  = "sms"
+	SendAsRCS  = "rcs"
+)
+
+// Values for a conversation's cached transport capability.
+const (
+	TransportRCS = "rcs"
+	TransportSMS = "sms"
+)
+
+// conversationColumns lists the conversations table columns in the order
+// scanConversation expects.
+const conversationColumns = `conversation_id, name, is_group, participants, last_message_ts, unread_count, send_as, color, archived, transport, pinned, muted, is_self_chat`
+
+// UpsertConversation inserts or updates a conversation from sync data.
+// The user's send_as, color, archived, pinned, and muted settings are never
+// overwritten by an update, and an unknown transport doesn't clear a cached one.
+// Muted conversations keep an unread count of zero. The participants table
+// is rewritten from c.Participants in the same transaction.
 func (s *Store) UpsertConversation(c *Conversation) error {
-	_, err := s.db.Exec(`
-		INSERT INTO conversations (conversation_id, name, is_group, participants, last_message_ts, unread_count)
-		VALUES (?, ?, ?, ?, ?, ?)
+	sendAs := c.SendAs
+	if sendAs == "" {
+		sendAs = SendAsAuto
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(`
+		INSERT INTO conversations (`+conversationColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 		ON CONFLICT(conversation_id) DO UPDATE SET
 			name=excluded.name,
 			is_group=excluded.is_group,
+			is_self_chat=excluded.is_self_chat,
 			participants=excluded.participants,
 			last_message_ts=excluded.last_message_ts,
-			unread_count=excluded.unread_count
-	`, c.ConversationID, c.Name, c.IsGroup, c.Participants, c.LastMessageTS, c.UnreadCount)
-	return err
+			unread_count=CASE WHEN conversations.muted THEN 0 ELSE excluded.unread_count END,
+			transport=CASE WHEN excluded.transport != '' THEN excluded.transport ELSE conversations.transport END
+	`, c.ConversationID, c.Name, c.IsGroup, c.Participants, c.LastMessageTS, c.UnreadCount, sendAs, c.Color, c.Archived, c.Transport, c.Pinned, c.Muted, c.IsSelfChat)
+	if err != nil {
+		return err
+	}
+	if err := s.replaceParticipants(tx, c.ConversationID, c.Participants); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 func (s *Store) GetConversation(id string) (*Conversation, error) {
-	c := &Conversation{}
-	err := s.db.QueryRow(`
-		SELECT conversation_id, name, is_group, participants, last_message_ts, unread_count
+	row := s.db.QueryRow(`
+		SELECT `+conversationColumns+`
 		FROM conversations WHERE conversation_id = ?
-	`, id).Scan(&c.ConversationID, &c.Name, &c.IsGroup, &c.Participants, &c.LastMessageTS, &c.UnreadCount)
-	if err != nil {
-		return nil, err
-	}
-	return c, nil
+	`, id)
+	return scanConversation(row)
 }
 
 func (s *Store) UpdateConversationTimestamp(id string, ts int64) error {
@@ -31,16 +75,259 @@ func (s *Store) UpdateConversationTimestamp(id string, ts int64) error {
 	return err
 }
 
+// execer runs a statement on the database or inside a transaction, so a
+// setter can also run as one step of a bulk change.
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// execOne runs a statement that targets one row and returns sql.ErrNoRows if
+// it matched none.
+func execOne(e execer, query string, args ...any) error {
+	res, err := e.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// MarkConversationRead clears a conversation's unread count and moves its
+// last-read position up to its newest message.
 func (s *Store) MarkConversationRead(id string) error {
-	_, err := s.db.Exec(`UPDATE conversations SET unread_count = 0 WHERE conversation_id = ?`, id)
-	return err
+	if err := markConversationRead(s.db, id); !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	return nil
+}
+
+func markConversationRead(e execer, id string) error {
+	return execOne(e, `
+		UPDATE conversations
+		SET unread_count = 0, last_read_ts = MAX(last_read_ts, last_message_ts)
+		WHERE conversation_id = ?
+	`, id)
+}
+
+// ConversationLastRead returns the timestamp (ms) a conversation has been
+// read up to, or 0 if it never was. It returns sql.ErrNoRows if the
+// conversation doesn't exist.
+func (s *Store) ConversationLastRead(id string) (int64, error) {
+	var ts int64
+	err := s.db.QueryRow(`SELECT last_read_ts FROM conversations WHERE conversation_id = ?`, id).Scan(&ts)
+	return ts, err
+}
+
+// AdvanceConversationRead moves a conversation's last-read position forward
+// to ts; an older ts is ignored. When ts reaches the newest message the
+// unread count is cleared too. It returns sql.ErrNoRows if the conversation
+// doesn't exist.
+func (s *Store) AdvanceConversationRead(id string, ts int64) error {
+	res, err := s.db.Exec(`
+		UPDATE conversations
+		SET last_read_ts = MAX(last_read_ts, ?1),
+			unread_count = CASE WHEN ?1 >= last_message_ts THEN 0 ELSE unread_count END
+		WHERE conversation_id = ?2
+	`, ts, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// ConversationReads returns the last-read position (ms) of every conversation
+// that has been read, keyed by conversation ID.
+func (s *Store) ConversationReads() (map[string]int64, error) {
+	rows, err := s.db.Query(`SELECT conversation_id, last_read_ts FROM conversations WHERE last_read_ts > 0`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	reads := map[string]int64{}
+	for rows.Next() {
+		var id string
+		var ts int64
+		if err := rows.Scan(&id, &ts); err != nil {
+			return nil, err
+		}
+		reads[id] = ts
+	}
+	return reads, rows.Err()
+}
+
+// SetConversationArchived archives or unarchives a conversation. Only the
+// flag changes; unread count and other state are kept. It returns
+// sql.ErrNoRows if the conversation doesn't exist.
+func (s *Store) SetConversationArchived(convID string, archived bool) error {
+	return setConversationArchived(s.db, convID, archived)
+}
+
+func setConversationArchived(e execer, convID string, archived bool) error {
+	return execOne(e, `UPDATE conversations SET archived = ? WHERE conversation_id = ?`, archived, convID)
+}
+
+// SetConversationPinned pins or unpins a conversation. It returns
+// sql.ErrNoRows if the conversation doesn't exist.
+func (s *Store) SetConversationPinned(convID string, pinned bool) error {
+	res, err := s.db.Exec(`UPDATE conversations SET pinned = ? WHERE conversation_id = ?`, pinned, convID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// SetConversationMuted mutes or unmutes a conversation. Muting clears its
+// unread count. It returns sql.ErrNoRows if the conversation doesn't exist.
+func (s *Store) SetConversationMuted(convID string, muted bool) error {
+	return setConversationMuted(s.db, convID, muted)
+}
+
+func setConversationMuted(e execer, convID string, muted bool) error {
+	return execOne(e, `
+		UPDATE conversations
+		SET muted = ?1, unread_count = CASE WHEN ?1 THEN 0 ELSE unread_count END
+		WHERE conversation_id = ?2
+	`, muted, convID)
+}
+
+// TotalUnread returns the sum of unread counts across unmuted conversations.
+func (s *Store) TotalUnread() (int, error) {
+	var n int
+	err := s.db.QueryRow(`SELECT COALESCE(SUM(unread_count), 0) FROM conversations WHERE muted = 0`).Scan(&n)
+	return n, err
+}
+
+// colorPattern accepts #rgb or #rrggbb hex colors and lowercase theme names.
+var colorPattern = regexp.MustCompile(`^(#[0-9a-fA-F]{3}|#[0-9a-fA-F]{6}|[a-z][a-z0-9-]{0,31})$`)
+
+// SetConversationColor stores a conversation's UI color: a hex color such as
+// "#3367d6", or a theme name such as "ocean". An empty color resets it.
+func (s *Store) SetConversationColor(id, color string) error {
+	if color != "" && !colorPattern.MatchString(color) {
+		return fmt.Errorf("invalid color %q (want #rrggbb, #rgb or a lowercase theme name)", color)
+	}
+	res, err := s.db.Exec(`UPDATE conversations SET color = ? WHERE conversation_id = ?`, color, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// SetConversationSendAs stores the preferred transport for outgoing messages
+// in a conversation. sendAs must be one of SendAsAuto, SendAsSMS or SendAsRCS.
+func (s *Store) SetConversationSendAs(id, sendAs string) error {
+	switch sendAs {
+	case SendAsAuto, SendAsSMS, SendAsRCS:
+	default:
+		return fmt.Errorf("invalid send_as %q (want auto, sms or rcs)", sendAs)
+	}
+	res, err := s.db.Exec(`UPDATE conversations SET send_as = ? WHERE conversation_id = ?`, sendAs, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// SearchConversations finds conversations whose name or participants match
+// query, most recent first.
+func (s *Store) SearchConversations(query string, limit int) ([]*Conversation, error) {
+	like := "%" + query + "%"
+	rows, err := s.db.Query(`
+		SELECT `+conversationColumns+`
+		FROM conversations
+		WHERE name LIKE ? OR participants LIKE ?
+		ORDER BY last_message_ts = 0, last_message_ts DESC
+		LIMIT ?
+	`, like, like, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanConversations(rows)
 }
 
 func (s *Store) ListConversations(limit int) ([]*Conversation, error) {
+	return s.ListConversationsActiveSince(0, limit)
+}
+
+// ListConversationsActiveSince returns unarchived conversations whose last
+// message is at or after sinceMS: pinned first, then most recent first. A
+// zero sinceMS returns all of them.
+func (s *Store) ListConversationsActiveSince(sinceMS int64, limit int) ([]*Conversation, error) {
+	return s.ListConversationsPage(sinceMS, false, 0, limit)
+}
+
+// ListConversationsIncludingArchived is ListConversationsActiveSince with
+// archived conversations included.
+func (s *Store) ListConversationsIncludingArchived(sinceMS int64, limit int) ([]*Conversation, error) {
+	return s.ListConversationsPage(sinceMS, true, 0, limit)
+}
+
+// ListConversationsPage returns up to limit conversations, skipping the first
+// offset, in the order of ListConversationsActiveSince. Ties are broken by
+// conversation ID so pages don't overlap or skip.
+func (s *Store) ListConversationsPage(sinceMS int64, includeArchived bool, offset, limit int) ([]*Conversation, error) {
+	rows, err := s.db.Query(`
+		SELECT `+conversationColumns+`
+		FROM conversations
+		WHERE `+conversationListWhere(includeArchived)+`
+		ORDER BY pinned DESC, last_message_ts = 0, last_message_ts DESC, conversation_id
+		LIMIT ? OFFSET ?
+	`, sinceMS, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanConversations(rows)
+}
+
+// CountConversations counts the conversations ListConversationsPage pages
+// through.
+func (s *Store) CountConversations(sinceMS int64, includeArchived bool) (int, error) {
+	var n int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM conversations WHERE `+conversationListWhere(includeArchived), sinceMS).Scan(&n)
+	return n, err
+}
+
+func conversationListWhere(includeArchived bool) string {
+	where := "last_message_ts >= ?"
+	if !includeArchived {
+		where += " AND archived = 0"
+	}
+	return where
+}
+
+// unreadSnippetLen caps LastSnippet in ListUnreadConversations, in characters.
+const unreadSnippetLen = 100
+
+// ListUnreadConversations returns conversations with unread messages, most
+// recent first, each with a snippet of its latest message. Muted
+// conversations are left out, as in TotalUnread.
+func (s *Store) ListUnreadConversations(limit int) ([]*UnreadConversation, error) {
+	const latest = `FROM messages m WHERE m.conversation_id = conversations.conversation_id ORDER BY m.timestamp_ms DESC LIMIT 1`
 	rows, err := s.db.Query(`
-		SELECT conversation_id, name, is_group, participants, last_message_ts, unread_count
+		SELECT `+conversationColumns+`,
+			COALESCE((SELECT m.body `+latest+`), ''),
+			COALESCE((SELECT m.media_id `+latest+`), ''),
+			COALESCE((SELECT m.sender_name `+latest+`), ''),
+			COALESCE((SELECT m.is_from_me `+latest+`), 0)
 		FROM conversations
-		ORDER BY last_message_ts DESC
+		WHERE unread_count > 0 AND muted = 0
+		ORDER BY last_message_ts = 0, last_message_ts DESC
 		LIMIT ?
 	`, limit)
 	if err != nil {
@@ -48,13 +335,226 @@ func (s *Store) ListConversations(limit int) ([]*Conversation, error) {
 	}
 	defer rows.Close()
 
-	var convs []*Conversation
+	var out []*UnreadConversation
 	for rows.Next() {
 		c := &Conversation{}
-		if err := rows.Scan(&c.ConversationID, &c.Name, &c.IsGroup, &c.Participants, &c.LastMessageTS, &c.UnreadCount); err != nil {
+		u := &UnreadConversation{Conversation: c}
+		var body, mediaID string
+		dest := append(conversationDest(c), &body, &mediaID, &u.LastSender, &u.LastFromMe)
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		if u.LastFromMe {
+			u.LastSender = ""
+		}
+		u.LastSnippet = snippet(body, unreadSnippetLen)
+		if u.LastSnippet == "" && mediaID != "" {
+			u.LastSnippet = "[attachment]"
+		}
+		out = append(out, u)
+	}
+	return out, rows.Err()
+}
+
+// snippet collapses whitespace in s and cuts it to n characters, marking a
+// cut with an ellipsis.
+func snippet(s string, n int) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if runes := []rune(s); len(runes) > n {
+		return string(runes[:n]) + "…"
+	}
+	return s
+}
+
+// ListConversationsNeedingReply returns conversations whose latest message was
+// received rather than sent, most recent first.
+func (s *Store) ListConversationsNeedingReply(limit int) ([]*Conversation, error) {
+	rows, err := s.db.Query(`
+		SELECT `+conversationColumns+`
+		FROM conversations c
+		WHERE (
+			SELECT m.is_from_me FROM messages m
+			WHERE m.conversation_id = c.conversation_id
+			ORDER BY m.timestamp_ms DESC
+			LIMIT 1
+		) = 0
+		ORDER BY last_message_ts = 0, last_message_ts DESC
+		LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanConversations(rows)
+}
+
+// GetConversationStats counts the stored messages in a conversation. Messages
+// with an unknown (zero) timestamp don't affect the first/last times.
+func (s *Store) GetConversationStats(convID string) (*ConversationStats, error) {
+	st := &ConversationStats{}
+	err := s.db.QueryRow(`
+		SELECT COUNT(*),
+			COALESCE(SUM(is_from_me), 0),
+			COALESCE(MIN(NULLIF(timestamp_ms, 0)), 0),
+			COALESCE(MAX(timestamp_ms), 0)
+		FROM messages WHERE conversation_id = ?
+	`, convID).Scan(&st.MessageCount, &st.SentCount, &st.FirstMessageTS, &st.LastMessageTS)
+	if err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// GetMessageTypeCounts counts a conversation's stored messages by kind and
+// its media messages by MIME type.
+func (s *Store) GetMessageTypeCounts(convID string) (*MessageTypeCounts, error) {
+	rows, err := s.db.Query(`
+		SELECT CASE
+				WHEN media_id != '' THEN 'media'
+				WHEN body != '' THEN 'text'
+				WHEN reactions NOT IN ('', '[]') THEN 'reaction'
+				ELSE 'system'
+			END AS kind, COUNT(*)
+		FROM messages WHERE conversation_id = ?
+		GROUP BY kind
+	`, convID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tc := &MessageTypeCounts{MimeTypes: map[string]int{}}
+	for rows.Next() {
+		var kind string
+		var n int
+		if err := rows.Scan(&kind, &n); err != nil {
+			return nil, err
+		}
+		switch kind {
+		case "media":
+			tc.Media = n
+		case "text":
+			tc.Text = n
+		case "reaction":
+			tc.ReactionOnly = n
+		default:
+			tc.System = n
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	mimeRows, err := s.db.Query(`
+		SELECT mime_type, COUNT(*) FROM messages
+		WHERE conversation_id = ? AND media_id != ''
+		GROUP BY mime_type
+	`, convID)
+	if err != nil {
+		return nil, err
+	}
+	defer mimeRows.Close()
+	for mimeRows.Next() {
+		var mime string
+		var n int
+		if err := mimeRows.Scan(&mime, &n); err != nil {
+			return nil, err
+		}
+		tc.MimeTypes[mime] = n
+	}
+	return tc, mimeRows.Err()
+}
+
+func scanConversation(row interface{ Scan(...any) error }) (*Conversation, error) {
+	c := &Conversation{}
+	if err := row.Scan(conversationDest(c)...); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// conversationDest returns scan destinations for conversationColumns.
+func conversationDest(c *Conversation) []any {
+	return []any{&c.ConversationID, &c.Name, &c.IsGroup, &c.Participants, &c.LastMessageTS, &c.UnreadCount, &c.SendAs, &c.Color, &c.Archived, &c.Transport, &c.Pinned, &c.Muted, &c.IsSelfChat}
+}
+
+func scanConversations(rows *sql.Rows) ([]*Conversation, error) {
+	var convs []*Conversation
+	for rows.Next() {
+		c, err := scanConversation(rows)
+		if err != nil {
 			return nil, err
 		}
 		convs = append(convs, c)
 	}
 	return convs, rows.Err()
 }
+
+// DefaultGapThreshold is the silence between consecutive messages that
+// ConversationGaps reports when no threshold is given.
+const DefaultGapThreshold = 7 * 24 * time.Hour
+
+// ConversationGaps returns gaps of at least threshold between consecutive
+// stored messages in a conversation, oldest first. Messages with unknown
+// timestamps are ignored.
+func (s *Store) ConversationGaps(convID string, threshold time.Duration) ([]*TimelineGap, error) {
+	if threshold <= 0 {
+		threshold = DefaultGapThreshold
+	}
+	rows, err := s.db.Query(`
+		SELECT prev_id, message_id, prev_ts, timestamp_ms FROM (
+			SELECT message_id, timestamp_ms,
+				LAG(message_id) OVER w AS prev_id,
+				LAG(timestamp_ms) OVER w AS prev_ts
+			FROM messages
+			WHERE conversation_id = ? AND timestamp_ms > 0
+			WINDOW w AS (ORDER BY timestamp_ms, message_id)
+		)
+		WHERE prev_ts IS NOT NULL AND timestamp_ms - prev_ts >= ?
+		ORDER BY timestamp_ms
+	`, convID, threshold.Milliseconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var gaps []*TimelineGap
+	for rows.Next() {
+		g := &TimelineGap{}
+		if err := rows.Scan(&g.AfterMessageID, &g.BeforeMessageID, &g.StartMS, &g.EndMS); err != nil {
+			return nil, err
+		}
+		gaps = append(gaps, g)
+	}
+	return gaps, rows.Err()
+}
+
+// ConversationParticipants returns a conversation's participants, each
+// flagged with whether their number matches a saved contact.
+func (s *Store) ConversationParticipants(convID string) ([]*Participant, error) {
+	if _, err := s.GetConversation(convID); err != nil {
+		return nil, err
+	}
+	rows, err := s.db.Query(`
+		SELECT p.name, p.number, p.is_me, c.name IS NOT NULL, COALESCE(c.name, '')
+		FROM participants p
+		LEFT JOIN contacts c ON c.contact_id = (
+			SELECT contact_id FROM contacts
+			WHERE number = p.number_key AND p.number_key != ''
+			LIMIT 1
+		)
+		WHERE p.conversation_id = ? ORDER BY p.position
+	`, convID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var participants []*Participant
+	for rows.Next() {
+		p := &Participant{}
+		if err := rows.Scan(&p.Name, &p.Number, &p.IsMe, &p.Known, &p.ContactName); err != nil {
+			return nil, err
+		}
+		participants = append(participants, p)
+	}
+	return participants, rows.Err()
+}
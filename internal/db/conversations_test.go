@@ -1,8 +1,12 @@
 package db
 
 import (
+	"database/sql"
+	"errors"
 	"fmt"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestUpsertConversation_InsertAndUpdate(t *testing.T) {
@@ -165,6 +169,91 @@ func TestListConversations_Ordering(t *testing.T) {
 	})
 }
 
+func TestListConversationsUnknownTimeLast(t *testing.T) {
+	store := newTestStore(t)
+	for _, c := range []*Conversation{
+		{ConversationID: "c-unknown", LastMessageTS: 0},
+		{ConversationID: "c-bogus", LastMessageTS: -5000},
+		{ConversationID: "c-recent", LastMessageTS: 5000},
+	} {
+		store.UpsertConversation(c)
+	}
+	got, err := store.SearchConversations("", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, c := range got {
+		ids = append(ids, c.ConversationID)
+	}
+	if strings.Join(ids, ",") != "c-recent,c-bogus,c-unknown" {
+		t.Errorf("order = %v, want the unknown time last", ids)
+	}
+}
+
+func TestListConversationsActiveSince(t *testing.T) {
+	store := newTestStore(t)
+
+	for _, c := range []Conversation{
+		{ConversationID: "c-old", Name: "Old", LastMessageTS: 1000},
+		{ConversationID: "c-cutoff", Name: "Cutoff", LastMessageTS: 3000},
+		{ConversationID: "c-new", Name: "New", LastMessageTS: 5000},
+	} {
+		if err := store.UpsertConversation(&c); err != nil {
+			t.Fatalf("upsert: %v", err)
+		}
+	}
+
+	got, err := store.ListConversationsActiveSince(3000, 100)
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("count: got %d, want 2", len(got))
+	}
+	if got[0].Name != "New" || got[1].Name != "Cutoff" {
+		t.Errorf("order: got %q, %q; want New, Cutoff", got[0].Name, got[1].Name)
+	}
+
+	got, err = store.ListConversationsActiveSince(3000, 1)
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if len(got) != 1 || got[0].Name != "New" {
+		t.Errorf("limit: got %d results", len(got))
+	}
+}
+
+func TestSearchConversations(t *testing.T) {
+	store := newTestStore(t)
+
+	store.UpsertConversation(&Conversation{ConversationID: "c1", Name: "Book Club", LastMessageTS: 100})
+	store.UpsertConversation(&Conversation{
+		ConversationID: "c2", Name: "", LastMessageTS: 200,
+		Participants: `[{"name":"Jane Book","number":"+15551234567"}]`,
+	})
+	store.UpsertConversation(&Conversation{ConversationID: "c3", Name: "Other", LastMessageTS: 300})
+
+	got, err := store.SearchConversations("book", 10)
+	if err != nil {
+		t.Fatalf("search: %v", err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("count: got %d, want 2", len(got))
+	}
+	if got[0].ConversationID != "c2" || got[1].ConversationID != "c1" {
+		t.Errorf("order: got %s, %s; want c2, c1", got[0].ConversationID, got[1].ConversationID)
+	}
+
+	got, err = store.SearchConversations("+1555123", 10)
+	if err != nil {
+		t.Fatalf("search: %v", err)
+	}
+	if len(got) != 1 || got[0].ConversationID != "c2" {
+		t.Errorf("number search: got %+v", got)
+	}
+}
+
 func TestListConversations_Empty(t *testing.T) {
 	store := newTestStore(t)
 
@@ -291,3 +380,476 @@ func TestListConversations_ManyEntries(t *testing.T) {
 		}
 	})
 }
+
+func TestListConversationsPage(t *testing.T) {
+	store := newTestStore(t)
+
+	// Equal timestamps make the order depend on the conversation ID tiebreak.
+	for i := 0; i < 25; i++ {
+		store.UpsertConversation(&Conversation{
+			ConversationID: fmt.Sprintf("conv-%03d", i),
+			LastMessageTS:  int64(i/5) * 100,
+		})
+	}
+
+	seen := map[string]bool{}
+	for offset := 0; offset < 25; offset += 10 {
+		page, err := store.ListConversationsPage(0, false, offset, 10)
+		if err != nil {
+			t.Fatalf("page at %d: %v", offset, err)
+		}
+		for _, c := range page {
+			if seen[c.ConversationID] {
+				t.Errorf("%s returned on more than one page", c.ConversationID)
+			}
+			seen[c.ConversationID] = true
+		}
+	}
+	if len(seen) != 25 {
+		t.Errorf("pages covered %d conversations, want 25", len(seen))
+	}
+
+	page, err := store.ListConversationsPage(0, false, 100, 10)
+	if err != nil {
+		t.Fatalf("page beyond the end: %v", err)
+	}
+	if len(page) != 0 {
+		t.Errorf("offset beyond the end returned %d conversations, want none", len(page))
+	}
+
+	if n, err := store.CountConversations(0, false); err != nil || n != 25 {
+		t.Errorf("CountConversations = %d, %v; want 25", n, err)
+	}
+	if n, _ := store.CountConversations(300, false); n != 10 {
+		t.Errorf("CountConversations since 300 = %d, want 10", n)
+	}
+}
+
+func TestConversationSendAs(t *testing.T) {
+	store := newTestStore(t)
+	store.UpsertConversation(&Conversation{ConversationID: "c1", Name: "Alice", LastMessageTS: 100})
+
+	got, _ := store.GetConversation("c1")
+	if got.SendAs != SendAsAuto {
+		t.Errorf("default send_as: got %q, want auto", got.SendAs)
+	}
+
+	if err := store.SetConversationSendAs("c1", SendAsSMS); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	// A sync update must not reset the user's preference.
+	store.UpsertConversation(&Conversation{ConversationID: "c1", Name: "Alice", LastMessageTS: 200})
+	got, _ = store.GetConversation("c1")
+	if got.SendAs != SendAsSMS {
+		t.Errorf("after upsert: got %q, want sms", got.SendAs)
+	}
+
+	if err := store.SetConversationSendAs("c1", "carrier-pigeon"); err == nil {
+		t.Error("expected error for invalid send_as")
+	}
+	if err := store.SetConversationSendAs("missing", SendAsRCS); err != sql.ErrNoRows {
+		t.Errorf("missing conversation: got %v, want sql.ErrNoRows", err)
+	}
+}
+
+func TestListConversationsNeedingReply(t *testing.T) {
+	store := newTestStore(t)
+
+	store.UpsertConversation(&Conversation{ConversationID: "theirs", Name: "Theirs", LastMessageTS: 300})
+	store.UpsertConversation(&Conversation{ConversationID: "mine", Name: "Mine", LastMessageTS: 400})
+	store.UpsertConversation(&Conversation{ConversationID: "empty", Name: "Empty", LastMessageTS: 500})
+
+	store.UpsertMessage(&Message{MessageID: "t1", ConversationID: "theirs", IsFromMe: true, TimestampMS: 100})
+	store.UpsertMessage(&Message{MessageID: "t2", ConversationID: "theirs", IsFromMe: false, TimestampMS: 300})
+	store.UpsertMessage(&Message{MessageID: "m1", ConversationID: "mine", IsFromMe: false, TimestampMS: 200})
+	store.UpsertMessage(&Message{MessageID: "m2", ConversationID: "mine", IsFromMe: true, TimestampMS: 400})
+
+	got, err := store.ListConversationsNeedingReply(10)
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if len(got) != 1 || got[0].ConversationID != "theirs" {
+		t.Errorf("got %+v, want only 'theirs'", got)
+	}
+}
+
+func TestListUnreadConversations(t *testing.T) {
+	store := newTestStore(t)
+
+	store.UpsertConversation(&Conversation{ConversationID: "read", Name: "Read", LastMessageTS: 500})
+	store.UpsertConversation(&Conversation{ConversationID: "older", Name: "Older", LastMessageTS: 200, UnreadCount: 1})
+	store.UpsertConversation(&Conversation{ConversationID: "newer", Name: "Newer", LastMessageTS: 400, UnreadCount: 3})
+	store.UpsertConversation(&Conversation{ConversationID: "photo", Name: "Photo", LastMessageTS: 300, UnreadCount: 1})
+	store.UpsertConversation(&Conversation{ConversationID: "muted", Name: "Muted", LastMessageTS: 600, UnreadCount: 5})
+	store.SetConversationMuted("muted", true)
+	store.UpsertConversation(&Conversation{ConversationID: "muted", Name: "Muted", LastMessageTS: 600, UnreadCount: 5})
+
+	store.UpsertMessage(&Message{MessageID: "r1", ConversationID: "read", Body: "seen", TimestampMS: 500})
+	store.UpsertMessage(&Message{MessageID: "o1", ConversationID: "older", SenderName: "Bob", Body: "lunch?", TimestampMS: 200})
+	store.UpsertMessage(&Message{MessageID: "n1", ConversationID: "newer", SenderName: "Ann", Body: "first", TimestampMS: 300})
+	store.UpsertMessage(&Message{MessageID: "n2", ConversationID: "newer", SenderName: "Ann", Body: strings.Repeat("long ", 40), TimestampMS: 400})
+	store.UpsertMessage(&Message{MessageID: "p1", ConversationID: "photo", SenderName: "Cy", MediaID: "media-1", MimeType: "image/jpeg", TimestampMS: 300})
+
+	got, err := store.ListUnreadConversations(10)
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	var ids []string
+	for _, c := range got {
+		ids = append(ids, c.ConversationID)
+	}
+	if strings.Join(ids, ",") != "newer,photo,older" {
+		t.Fatalf("got %v, want newer,photo,older", ids)
+	}
+	if got[0].LastSender != "Ann" || !strings.HasPrefix(got[0].LastSnippet, "long long") || !strings.HasSuffix(got[0].LastSnippet, "…") {
+		t.Errorf("newer = %+v, want Ann's latest message shortened", got[0])
+	}
+	if got[1].LastSnippet != "[attachment]" {
+		t.Errorf("photo snippet = %q, want [attachment]", got[1].LastSnippet)
+	}
+	if got[2].LastSnippet != "lunch?" || got[2].UnreadCount != 1 {
+		t.Errorf("older = %+v", got[2])
+	}
+}
+
+func TestGetConversationStats(t *testing.T) {
+	store := newTestStore(t)
+
+	store.UpsertMessage(&Message{MessageID: "m1", ConversationID: "c1", TimestampMS: 0})
+	store.UpsertMessage(&Message{MessageID: "m2", ConversationID: "c1", TimestampMS: 200, IsFromMe: true})
+	store.UpsertMessage(&Message{MessageID: "m3", ConversationID: "c1", TimestampMS: 300})
+	store.UpsertMessage(&Message{MessageID: "m4", ConversationID: "c2", TimestampMS: 900})
+
+	st, err := store.GetConversationStats("c1")
+	if err != nil {
+		t.Fatalf("stats: %v", err)
+	}
+	want := ConversationStats{MessageCount: 3, SentCount: 1, FirstMessageTS: 200, LastMessageTS: 300}
+	if *st != want {
+		t.Errorf("got %+v, want %+v", *st, want)
+	}
+
+	st, _ = store.GetConversationStats("empty")
+	if *st != (ConversationStats{}) {
+		t.Errorf("empty: got %+v", *st)
+	}
+}
+
+func TestConversationGaps(t *testing.T) {
+	store := newTestStore(t)
+
+	day := int64(24 * time.Hour / time.Millisecond)
+	base := time.Now().Add(-90 * 24 * time.Hour).UnixMilli()
+	for i, offset := range []int64{0, 1, 2, 40, 41} {
+		store.UpsertMessage(&Message{
+			MessageID:      fmt.Sprintf("m%d", i),
+			ConversationID: "c1",
+			Body:           "hi",
+			TimestampMS:    base + offset*day,
+		})
+	}
+	// Unknown timestamps and other conversations don't create gaps.
+	store.UpsertMessage(&Message{MessageID: "m-zero", ConversationID: "c1", Body: "?", TimestampMS: 0})
+	store.UpsertMessage(&Message{MessageID: "other", ConversationID: "c2", Body: "hi", TimestampMS: base + 20*day})
+
+	gaps, err := store.ConversationGaps("c1", 0)
+	if err != nil {
+		t.Fatalf("gaps: %v", err)
+	}
+	if len(gaps) != 1 {
+		t.Fatalf("got %d gaps, want 1: %+v", len(gaps), gaps)
+	}
+	g := gaps[0]
+	if g.AfterMessageID != "m2" || g.BeforeMessageID != "m3" {
+		t.Errorf("gap between %s and %s, want m2 and m3", g.AfterMessageID, g.BeforeMessageID)
+	}
+	if g.EndMS-g.StartMS != 38*day {
+		t.Errorf("gap length = %d days, want 38", (g.EndMS-g.StartMS)/day)
+	}
+
+	gaps, _ = store.ConversationGaps("c1", 50*24*time.Hour)
+	if len(gaps) != 0 {
+		t.Errorf("expected no gaps above a 50-day threshold, got %+v", gaps)
+	}
+}
+
+func TestConversationsForNumberGroups(t *testing.T) {
+	store := newTestStore(t)
+
+	store.UpsertConversation(&Conversation{ConversationID: "dm", Name: "Alice", LastMessageTS: 3000,
+		Participants: `[{"name":"Alice","number":"+15551234567"},{"name":"Me","number":"+15550000000","is_me":true}]`})
+	store.UpsertConversation(&Conversation{ConversationID: "g1", Name: "Family", IsGroup: true, LastMessageTS: 2000,
+		Participants: `[{"name":"Alice","number":"+1 (555) 123-4567"},{"name":"Bob","number":"+15552222222"}]`})
+	store.UpsertConversation(&Conversation{ConversationID: "g2", Name: "Book club", IsGroup: true, LastMessageTS: 1000,
+		Participants: `[{"name":"Carol","number":"+15553333333"},{"name":"Alice","number":"555-123-4567"}]`})
+	store.UpsertConversation(&Conversation{ConversationID: "other", Name: "Bob", LastMessageTS: 4000,
+		Participants: `[{"name":"Bob","number":"+15552222222"}]`})
+
+	convs, err := store.ConversationsForNumber("+15551234567")
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	var ids []string
+	for _, c := range convs {
+		ids = append(ids, c.ConversationID)
+	}
+	if fmt.Sprint(ids) != "[dm g1 g2]" {
+		t.Errorf("conversations = %v, want [dm g1 g2]", ids)
+	}
+
+	// Our own number is not a participant match.
+	if convs, _ := store.ConversationsForNumber("+15550000000"); len(convs) != 0 {
+		t.Errorf("matched own number: %+v", convs)
+	}
+}
+
+func TestConversationParticipants(t *testing.T) {
+	store := newTestStore(t)
+	store.UpsertContact(&Contact{ContactID: "c1", Name: "Alice Smith", Number: "+15551234567"})
+	store.UpsertConversation(&Conversation{ConversationID: "g1", IsGroup: true,
+		Participants: `[{"name":"Alice","number":"(555) 123-4567"},{"name":"","number":"+15559999999"}]`})
+
+	ps, err := store.ConversationParticipants("g1")
+	if err != nil {
+		t.Fatalf("participants: %v", err)
+	}
+	if len(ps) != 2 {
+		t.Fatalf("got %d participants, want 2", len(ps))
+	}
+	if !ps[0].Known || ps[0].ContactName != "Alice Smith" {
+		t.Errorf("Alice = %+v, want known as Alice Smith", ps[0])
+	}
+	if ps[1].Known || ps[1].ContactName != "" {
+		t.Errorf("stranger = %+v, want unknown", ps[1])
+	}
+
+	if _, err := store.ConversationParticipants("missing"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("missing conversation: err = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func TestSetConversationColor(t *testing.T) {
+	store := newTestStore(t)
+	store.UpsertConversation(&Conversation{ConversationID: "c1", Name: "Alice"})
+
+	if err := store.SetConversationColor("c1", "#3367d6"); err != nil {
+		t.Fatalf("set color: %v", err)
+	}
+	// A sync upsert carries no color and must not clear the user's choice.
+	store.UpsertConversation(&Conversation{ConversationID: "c1", Name: "Alice B", UnreadCount: 2})
+	c, _ := store.GetConversation("c1")
+	if c.Color != "#3367d6" || c.Name != "Alice B" {
+		t.Errorf("after sync: color=%q name=%q, want #3367d6 and the synced name", c.Color, c.Name)
+	}
+
+	if err := store.SetConversationColor("c1", "ocean"); err != nil {
+		t.Errorf("theme name: %v", err)
+	}
+	if err := store.SetConversationColor("c1", "red; drop"); err == nil {
+		t.Error("expected error for invalid color")
+	}
+	if err := store.SetConversationColor("c1", ""); err != nil {
+		t.Errorf("reset: %v", err)
+	}
+	if c, _ := store.GetConversation("c1"); c.Color != "" {
+		t.Errorf("color after reset = %q, want empty", c.Color)
+	}
+	if err := store.SetConversationColor("missing", "#fff"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("missing conversation: err = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func TestSetConversationArchived(t *testing.T) {
+	store := newTestStore(t)
+	store.UpsertConversation(&Conversation{ConversationID: "c1", Name: "Stale", LastMessageTS: 1000, UnreadCount: 4})
+	store.UpsertConversation(&Conversation{ConversationID: "c2", Name: "Active", LastMessageTS: 2000})
+
+	if err := store.SetConversationArchived("c1", true); err != nil {
+		t.Fatalf("archive: %v", err)
+	}
+	got, _ := store.ListConversations(10)
+	if len(got) != 1 || got[0].ConversationID != "c2" {
+		t.Errorf("default list = %v, want only c2", convIDs(got))
+	}
+	got, _ = store.ListConversationsIncludingArchived(0, 10)
+	if len(got) != 2 {
+		t.Errorf("list with archived = %v, want both", convIDs(got))
+	}
+
+	// Sync upserts keep the flag; archiving keeps the unread count.
+	store.UpsertConversation(&Conversation{ConversationID: "c1", Name: "Stale", LastMessageTS: 1500, UnreadCount: 4})
+	c, _ := store.GetConversation("c1")
+	if !c.Archived || c.UnreadCount != 4 {
+		t.Errorf("after sync: archived=%v unread=%d, want true and 4", c.Archived, c.UnreadCount)
+	}
+
+	if err := store.SetConversationArchived("c1", false); err != nil {
+		t.Fatalf("unarchive: %v", err)
+	}
+	if got, _ := store.ListConversations(10); len(got) != 2 {
+		t.Errorf("after unarchive = %v, want both", convIDs(got))
+	}
+	if err := store.SetConversationArchived("missing", true); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("missing: err = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func convIDs(convs []*Conversation) []string {
+	var ids []string
+	for _, c := range convs {
+		ids = append(ids, c.ConversationID)
+	}
+	return ids
+}
+
+func TestConversationTransportCached(t *testing.T) {
+	store := newTestStore(t)
+	store.UpsertConversation(&Conversation{ConversationID: "c1", Transport: TransportRCS})
+	// An update that doesn't know the transport keeps the cached value.
+	store.UpsertConversation(&Conversation{ConversationID: "c1", Name: "Alice"})
+	if c, _ := store.GetConversation("c1"); c.Transport != TransportRCS {
+		t.Errorf("transport = %q, want rcs kept", c.Transport)
+	}
+	store.UpsertConversation(&Conversation{ConversationID: "c1", Transport: TransportSMS})
+	if c, _ := store.GetConversation("c1"); c.Transport != TransportSMS {
+		t.Errorf("transport = %q, want sms after the phone reports it", c.Transport)
+	}
+}
+
+func TestConversationSelfChatStored(t *testing.T) {
+	store := newTestStore(t)
+	store.UpsertConversation(&Conversation{ConversationID: "c1", IsSelfChat: true})
+	if c, _ := store.GetConversation("c1"); !c.IsSelfChat {
+		t.Error("IsSelfChat not stored")
+	}
+	store.UpsertConversation(&Conversation{ConversationID: "c1", Name: "Alice"})
+	if c, _ := store.GetConversation("c1"); c.IsSelfChat {
+		t.Error("IsSelfChat should follow the latest sync")
+	}
+}
+
+func TestPinnedConversationsSortFirst(t *testing.T) {
+	store := newTestStore(t)
+	store.UpsertConversation(&Conversation{ConversationID: "old", LastMessageTS: 1000})
+	store.UpsertConversation(&Conversation{ConversationID: "mid", LastMessageTS: 2000})
+	store.UpsertConversation(&Conversation{ConversationID: "new", LastMessageTS: 3000})
+
+	if err := store.SetConversationPinned("old", true); err != nil {
+		t.Fatalf("pin: %v", err)
+	}
+	got, _ := store.ListConversations(10)
+	if ids := convIDs(got); len(ids) != 3 || ids[0] != "old" || ids[1] != "new" || ids[2] != "mid" {
+		t.Errorf("order = %v, want [old new mid]", ids)
+	}
+
+	// Sync upserts keep the pin.
+	store.UpsertConversation(&Conversation{ConversationID: "old", LastMessageTS: 1100})
+	if c, _ := store.GetConversation("old"); !c.Pinned {
+		t.Error("pin lost after sync upsert")
+	}
+
+	store.SetConversationPinned("old", false)
+	got, _ = store.ListConversations(10)
+	if ids := convIDs(got); ids[0] != "new" || ids[2] != "old" {
+		t.Errorf("order after unpin = %v, want timestamp order", ids)
+	}
+	if err := store.SetConversationPinned("missing", true); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("missing: err = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func TestMutedConversationUnread(t *testing.T) {
+	store := newTestStore(t)
+	store.UpsertConversation(&Conversation{ConversationID: "group", UnreadCount: 3})
+	store.UpsertConversation(&Conversation{ConversationID: "friend", UnreadCount: 2})
+
+	if err := store.SetConversationMuted("group", true); err != nil {
+		t.Fatalf("mute: %v", err)
+	}
+	if c, _ := store.GetConversation("group"); !c.Muted || c.UnreadCount != 0 {
+		t.Errorf("after mute: %+v, want muted with 0 unread", c)
+	}
+	store.UpsertConversation(&Conversation{ConversationID: "group", UnreadCount: 1})
+	if c, _ := store.GetConversation("group"); !c.Muted || c.UnreadCount != 0 {
+		t.Errorf("after sync: %+v, want muted with 0 unread", c)
+	}
+	if n, err := store.TotalUnread(); err != nil || n != 2 {
+		t.Errorf("TotalUnread = %d, %v; want 2", n, err)
+	}
+
+	store.SetConversationMuted("group", false)
+	store.UpsertConversation(&Conversation{ConversationID: "group", UnreadCount: 1})
+	if n, _ := store.TotalUnread(); n != 3 {
+		t.Errorf("TotalUnread after unmute = %d, want 3", n)
+	}
+	if err := store.SetConversationMuted("missing", true); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("missing: err = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func TestConversationReadPosition(t *testing.T) {
+	store := newTestStore(t)
+	store.UpsertConversation(&Conversation{ConversationID: "c1", LastMessageTS: 2000, UnreadCount: 2})
+
+	if err := store.MarkConversationRead("c1"); err != nil {
+		t.Fatal(err)
+	}
+	if ts, _ := store.ConversationLastRead("c1"); ts != 2000 {
+		t.Errorf("after mark read: last read = %d, want 2000", ts)
+	}
+
+	// Older positions never move it back.
+	store.AdvanceConversationRead("c1", 1000)
+	if ts, _ := store.ConversationLastRead("c1"); ts != 2000 {
+		t.Errorf("after older advance: last read = %d, want 2000", ts)
+	}
+
+	// A position short of the newest message keeps the unread count.
+	store.UpsertConversation(&Conversation{ConversationID: "c1", LastMessageTS: 4000, UnreadCount: 1})
+	store.AdvanceConversationRead("c1", 3000)
+	if c, _ := store.GetConversation("c1"); c.UnreadCount != 1 {
+		t.Errorf("unread = %d, want 1", c.UnreadCount)
+	}
+	reads, err := store.ConversationReads()
+	if err != nil || len(reads) != 1 || reads["c1"] != 3000 {
+		t.Errorf("ConversationReads = %v, %v", reads, err)
+	}
+	if err := store.AdvanceConversationRead("missing", 1); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("missing: err = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func TestGetMessageTypeCounts(t *testing.T) {
+	store := newTestStore(t)
+	seed := []*Message{
+		{MessageID: "t1", Body: "hi"},
+		{MessageID: "t2", Body: "lunch?", Reactions: `[{"emoji":"👍","count":1}]`},
+		{MessageID: "p1", MediaID: "a", MimeType: "image/jpeg", Body: "caption"},
+		{MessageID: "p2", MediaID: "b", MimeType: "image/jpeg"},
+		{MessageID: "v1", MediaID: "c", MimeType: "video/mp4"},
+		{MessageID: "r1", Reactions: `[{"emoji":"❤️","count":2}]`},
+		{MessageID: "s1", RawPayload: `{"poll":{}}`},
+		{MessageID: "s2", Reactions: "[]"},
+	}
+	for _, m := range seed {
+		m.ConversationID = "c1"
+		store.UpsertMessage(m)
+	}
+	store.UpsertMessage(&Message{MessageID: "other", ConversationID: "c2", MediaID: "d", MimeType: "audio/ogg"})
+
+	tc, err := store.GetMessageTypeCounts("c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tc.Text != 2 || tc.Media != 3 || tc.ReactionOnly != 1 || tc.System != 2 {
+		t.Errorf("counts = %+v, want 2 text, 3 media, 1 reaction-only, 2 system", tc)
+	}
+	if len(tc.MimeTypes) != 2 || tc.MimeTypes["image/jpeg"] != 2 || tc.MimeTypes["video/mp4"] != 1 {
+		t.Errorf("mime types = %v", tc.MimeTypes)
+	}
+
+	empty, err := store.GetMessageTypeCounts("none")
+	if err != nil || empty.Text+empty.Media+empty.System+empty.ReactionOnly != 0 || empty.MimeTypes == nil {
+		t.Errorf("empty conversation = %+v, %v", empty, err)
+	}
+}
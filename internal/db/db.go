@@ -1,23 +1,34 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"strings"
 
 	_ "modernc.org/sqlite"
 )
 
 type Store struct {
-	db *sql.DB
+	db          *sql.DB
+	fts         bool   // messages_fts is available for SearchMessages
+	callingCode string // assumed for national numbers, see NormalizeNumber
 }
 
 type Conversation struct {
 	ConversationID string
 	Name           string
 	IsGroup        bool
+	IsSelfChat     bool   // "note to self": the only participant is me
 	Participants   string // JSON array
 	LastMessageTS  int64
 	UnreadCount    int
+	SendAs         string // "auto", "sms" or "rcs"
+	Color          string // local UI color or theme name; empty means default
+	Archived       bool   // hidden from the default conversation list
+	Transport      string // TransportRCS, TransportSMS, or "" if not yet known
+	Pinned         bool   // sorted above unpinned conversations
+	Muted          bool   // unread count held at zero
 }
 
 type Message struct {
@@ -34,12 +45,135 @@ type Message struct {
 	DecryptionKey  string `json:"-"` // hex-encoded, never exposed in API
 	Reactions      string `json:",omitempty"` // JSON array of {emoji, count}
 	ReplyToID      string `json:",omitempty"`
+	RawPayload     string `json:",omitempty"` // JSON proto, only for messages we couldn't parse
+	Pinned         bool   `json:",omitempty"` // pinned within its conversation; local only
+	MediaSize      int64  `json:",omitempty"` // attachment size in bytes, as reported by the phone
+	Starred        bool   `json:",omitempty"` // starred by me; synced to Supabase when configured
+	HasLink        bool   `json:",omitempty"` // body contains a URL; set by UpsertMessage
+	MediaCloudURL  string `json:",omitempty"` // uploaded copy of the attachment; set by SetMessageMediaCloudURL
+	Deleted        bool   `json:",omitempty"` // unsent by its sender; body and media are cleared
+	TmpID          string `json:",omitempty"` // tmp_ ID the message was sent under, once reconciled
+	EditedAtMS     int64  `json:",omitempty"` // when the body last changed; earlier bodies are in message_edits
+
+	Location      *Location      `json:",omitempty"` // shared place; stored as JSON in the location column
+	SharedContact *SharedContact `json:",omitempty"` // shared contact card; stored as JSON in the shared_contact column
+	Subtype       string         `json:",omitempty"` // SubtypeGIF or SubtypeSticker for media shown specially; "" otherwise
+
+	// AttachmentCount is how many attachments the message carried. MediaID
+	// and the other media fields describe the first of them.
+	AttachmentCount int `json:",omitempty"`
+
+	// The attachment's preview image, e.g. a video's poster frame, if the
+	// phone sent one. The key is hex-encoded.
+	ThumbnailMediaID       string `json:"-"`
+	ThumbnailDecryptionKey string `json:"-"`
+}
+
+// Message subtypes for media that is displayed differently from a plain
+// image or video.
+const (
+	SubtypeGIF     = "gif"
+	SubtypeSticker = "sticker"
+)
+
+// Location is a place shared in a message.
+type Location struct {
+	Latitude  float64 `json:"lat"`
+	Longitude float64 `json:"lng"`
+	Name      string  `json:"name,omitempty"`
+}
+
+// SharedContact is a contact card shared in a message. Raw keeps the card's
+// text when neither a name nor a number could be read from it.
+type SharedContact struct {
+	Name    string   `json:"name,omitempty"`
+	Numbers []string `json:"numbers,omitempty"`
+	Raw     string   `json:"raw,omitempty"`
+}
+
+// Summary describes the card as "Shared contact: <name> <numbers>".
+func (c *SharedContact) Summary() string {
+	parts := c.Numbers
+	if c.Name != "" {
+		parts = append([]string{c.Name}, parts...)
+	}
+	if len(parts) == 0 {
+		return "Shared contact"
+	}
+	return "Shared contact: " + strings.Join(parts, " ")
 }
 
 type Contact struct {
-	ContactID string
-	Name      string
-	Number    string
+	ContactID     string
+	Name          string
+	Number        string // E.164, see NormalizeNumber
+	DisplayNumber string // as the phone formatted it
+}
+
+// Participant is a conversation member matched against saved contacts.
+type Participant struct {
+	Name        string `json:"name"`
+	Number      string `json:"number"`
+	IsMe        bool   `json:"is_me,omitempty"`
+	Known       bool   `json:"known"`                  // number matches a saved contact
+	ContactName string `json:"contact_name,omitempty"` // the matching contact's name
+}
+
+// ContactActivity is when a contact was last messaged, in either direction.
+// LastMessageTS is 0 if no messages with them are stored.
+type ContactActivity struct {
+	ContactID     string
+	Name          string
+	Number        string
+	DisplayNumber string
+	LastMessageTS int64
+}
+
+// TimelineGap is a stretch with no stored messages between two consecutive
+// messages in a conversation, which often means history wasn't backfilled.
+type TimelineGap struct {
+	AfterMessageID  string // last message before the gap
+	BeforeMessageID string // first message after the gap
+	StartMS         int64
+	EndMS           int64
+}
+
+// UnreadConversation is a conversation with unread messages and a snippet of
+// its latest message.
+type UnreadConversation struct {
+	*Conversation
+	LastSender  string `json:",omitempty"` // sender of the latest message; empty if it was mine
+	LastFromMe  bool   `json:",omitempty"`
+	LastSnippet string // the latest message's text, shortened, or "[attachment]"
+}
+
+// ConversationStats summarizes the stored messages in a conversation.
+type ConversationStats struct {
+	MessageCount   int
+	SentCount      int
+	FirstMessageTS int64
+	LastMessageTS  int64
+}
+
+// MessageTypeCounts breaks a conversation's messages down by kind. A message
+// with an attachment counts as media even if it has a caption; a message with
+// neither text nor media but with reactions is reaction-only; anything else
+// (calls, group changes, unparsed content) is system.
+type MessageTypeCounts struct {
+	Text         int
+	Media        int
+	System       int
+	ReactionOnly int
+	// MimeTypes counts media messages by MIME type, e.g. "image/jpeg".
+	MimeTypes map[string]int
+}
+
+// MediaUsage is the total size of media attachments in one conversation.
+type MediaUsage struct {
+	ConversationID string
+	Name           string
+	MediaCount     int
+	TotalBytes     int64
 }
 
 type Draft struct {
@@ -49,7 +183,19 @@ type Draft struct {
 	CreatedAt      int64
 }
 
+// New opens the database at dsn, normalizing phone numbers for the US.
 func New(dsn string) (*Store, error) {
+	return NewWithRegion(dsn, "")
+}
+
+// NewWithRegion is like New but treats national phone numbers as belonging
+// to region, such as "GB". Numbers already stored keep the form they were
+// normalized to.
+func NewWithRegion(dsn, region string) (*Store, error) {
+	code, err := callingCode(region)
+	if err != nil {
+		return nil, err
+	}
 	db, err := sql.Open("sqlite", dsn)
 	if err != nil {
 		return nil, fmt.Errorf("open db: %w", err)
@@ -64,7 +210,7 @@ func New(dsn string) (*Store, error) {
 		db.Close()
 		return nil, fmt.Errorf("enable foreign keys: %w", err)
 	}
-	s := &Store{db: db}
+	s := &Store{db: db, callingCode: code}
 	if err := s.migrate(); err != nil {
 		db.Close()
 		return nil, fmt.Errorf("migrate: %w", err)
@@ -76,68 +222,97 @@ func (s *Store) Close() error {
 	return s.db.Close()
 }
 
+// Ping checks that the database answers a query.
+func (s *Store) Ping(ctx context.Context) error {
+	var one int
+	return s.db.QueryRowContext(ctx, "SELECT 1").Scan(&one)
+}
+
+// CountMessages returns the number of stored messages.
+func (s *Store) CountMessages() (int, error) {
+	var n int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM messages").Scan(&n)
+	return n, err
+}
+
 // SeedDemo populates the database with fake data for screenshots/demos.
 func (s *Store) SeedDemo() error {
 	inserts := `
-INSERT OR IGNORE INTO conversations VALUES('conv3','Weekend Hiking Group',1,'[{"name":"Emily Park","number":"+13105553456"},{"name":"David Kim","number":"+14085557890"},{"name":"Alex Thompson","number":"+17185552222"}]',1738960200000,0);
-INSERT OR IGNORE INTO conversations VALUES('conv1','Sarah Chen',0,'[{"name":"Sarah Chen","number":"+14155551234"}]',1738958400000,0);
-INSERT OR IGNORE INTO conversations VALUES('conv2','Marcus Johnson',0,'[{"name":"Marcus Johnson","number":"+12125559876"}]',1738956600000,2);
-INSERT OR IGNORE INTO conversations VALUES('conv4','Emily Park',0,'[{"name":"Emily Park","number":"+13105553456"}]',1738951200000,0);
-INSERT OR IGNORE INTO conversations VALUES('conv5','Lisa Rodriguez',0,'[{"name":"Lisa Rodriguez","number":"+12025551111"}]',1738947600000,1);
-INSERT OR IGNORE INTO conversations VALUES('conv6','David Kim',0,'[{"name":"David Kim","number":"+14085557890"}]',1738944000000,0);
-INSERT OR IGNORE INTO conversations VALUES('conv7','Rachel Green',0,'[{"name":"Rachel Green","number":"+16505553333"}]',1738940400000,0);
-INSERT OR IGNORE INTO conversations VALUES('conv8','Alex Thompson',0,'[{"name":"Alex Thompson","number":"+17185552222"}]',1738936800000,0);
-
-INSERT OR IGNORE INTO messages VALUES('m3a','conv3','Emily Park','+13105553456','Anyone up for a hike this Saturday? Weather looks amazing',1738951200000,'delivered',0,'','','','','');
-INSERT OR IGNORE INTO messages VALUES('m3b','conv3','David Kim','+14085557890','I''m in! Lands End or Battery to Bluffs?',1738953000000,'delivered',0,'','','','','');
-INSERT OR IGNORE INTO messages VALUES('m3c','conv3','Alex Thompson','+17185552222','Lands End! The wildflowers should be gorgeous right now',1738955400000,'delivered',0,'','','','','');
-INSERT OR IGNORE INTO messages VALUES('m3d','conv3','Emily Park','+13105553456','Lands End it is! 9am at the trailhead?',1738957800000,'delivered',0,'','','','','');
-INSERT OR IGNORE INTO messages VALUES('m3e','conv3','David Kim','+14085557890','Perfect. I''ll bring coffee for everyone',1738960200000,'delivered',0,'','','','','');
-
-INSERT OR IGNORE INTO messages VALUES('m1a','conv1','Sarah Chen','+14155551234','Hey! Are you free for dinner tonight?',1738951200000,'delivered',0,'','','','','');
-INSERT OR IGNORE INTO messages VALUES('m1b','conv1','Me','+15551234567','Yes! What did you have in mind?',1738952100000,'delivered',1,'','','','','');
-INSERT OR IGNORE INTO messages VALUES('m1c','conv1','Sarah Chen','+14155551234','There is a new Thai place on Valencia that just opened. Heard great things about their pad see ew',1738953000000,'delivered',0,'','','','','');
-INSERT OR IGNORE INTO messages VALUES('m1d','conv1','Me','+15551234567','That sounds perfect! What time works for you?',1738954800000,'delivered',1,'','','','','');
-INSERT OR IGNORE INTO messages VALUES('m1e','conv1','Sarah Chen','+14155551234','How about 7:30? I can make a reservation',1738956600000,'delivered',0,'','','','','');
-INSERT OR IGNORE INTO messages VALUES('m1f','conv1','Me','+15551234567','Perfect, see you there!',1738958400000,'delivered',1,'','','','','');
-
-INSERT OR IGNORE INTO messages VALUES('m2a','conv2','Marcus Johnson','+12125559876','Quick update on the project - we hit our Q1 milestone early!',1738944000000,'delivered',0,'','','','','');
-INSERT OR IGNORE INTO messages VALUES('m2b','conv2','Me','+15551234567','That is awesome news! The team did a great job.',1738945800000,'delivered',1,'','','','','');
-INSERT OR IGNORE INTO messages VALUES('m2c','conv2','Marcus Johnson','+12125559876','Agreed. Want to hop on a call Monday to discuss next steps?',1738947600000,'delivered',0,'','','','','');
-INSERT OR IGNORE INTO messages VALUES('m2d','conv2','Marcus Johnson','+12125559876','Also, I sent over the slide deck to review when you get a chance',1738956600000,'delivered',0,'','','','','');
-
-INSERT OR IGNORE INTO messages VALUES('m4a','conv4','Emily Park','+13105553456','Thanks for the book recommendation! I am already halfway through it',1738940400000,'delivered',0,'','','','','');
-INSERT OR IGNORE INTO messages VALUES('m4b','conv4','Me','+15551234567','Glad you are enjoying it! The second half gets even better',1738951200000,'delivered',1,'','','','','');
-
-INSERT OR IGNORE INTO messages VALUES('m5a','conv5','Lisa Rodriguez','+12025551111','Are we still on for coffee tomorrow morning?',1738936800000,'delivered',0,'','','','','');
-INSERT OR IGNORE INTO messages VALUES('m5b','conv5','Me','+15551234567','Absolutely! Blue Bottle at 10?',1738938600000,'delivered',1,'','','','','');
-INSERT OR IGNORE INTO messages VALUES('m5c','conv5','Lisa Rodriguez','+12025551111','Sounds great! I have some exciting news to share',1738947600000,'delivered',0,'','','','','');
-
-INSERT OR IGNORE INTO messages VALUES('m6a','conv6','Me','+15551234567','Hey, did you see the Warriors game last night?',1738933200000,'delivered',1,'','','','','');
-INSERT OR IGNORE INTO messages VALUES('m6b','conv6','David Kim','+14085557890','Incredible comeback! Curry was unreal in the 4th quarter',1738936800000,'delivered',0,'','','','','');
-INSERT OR IGNORE INTO messages VALUES('m6c','conv6','Me','+15551234567','We should catch the next home game together',1738944000000,'delivered',1,'','','','','');
-
-INSERT OR IGNORE INTO messages VALUES('m7a','conv7','Rachel Green','+16505553333','Just landed! Flight was smooth. Thanks for the ride to the airport',1738929600000,'delivered',0,'','','','','');
-INSERT OR IGNORE INTO messages VALUES('m7b','conv7','Me','+15551234567','Anytime! Have an amazing trip',1738940400000,'delivered',1,'','','','','');
-
-INSERT OR IGNORE INTO messages VALUES('m8a','conv8','Alex Thompson','+17185552222','Found that restaurant we were talking about - it is called Nopa',1738929600000,'delivered',0,'','','','','');
-INSERT OR IGNORE INTO messages VALUES('m8b','conv8','Me','+15551234567','Nice find! Let us go next week',1738936800000,'delivered',1,'','','','','');
-
-INSERT OR IGNORE INTO contacts VALUES('c1','Sarah Chen','+14155551234');
-INSERT OR IGNORE INTO contacts VALUES('c2','Marcus Johnson','+12125559876');
-INSERT OR IGNORE INTO contacts VALUES('c3','Emily Park','+13105553456');
-INSERT OR IGNORE INTO contacts VALUES('c4','David Kim','+14085557890');
-INSERT OR IGNORE INTO contacts VALUES('c5','Lisa Rodriguez','+12025551111');
-INSERT OR IGNORE INTO contacts VALUES('c6','Alex Thompson','+17185552222');
-INSERT OR IGNORE INTO contacts VALUES('c7','Rachel Green','+16505553333');
-
-INSERT OR IGNORE INTO drafts VALUES('draft1','conv3','Count me in for Saturday! Lands End trail looks clear — 62°F and sunny. Want me to bring snacks?',1738961000000);
+INSERT OR IGNORE INTO conversations(conversation_id, name, is_group, participants, last_message_ts, unread_count) VALUES('conv3','Weekend Hiking Group',1,'[{"name":"Emily Park","number":"+13105553456"},{"name":"David Kim","number":"+14085557890"},{"name":"Alex Thompson","number":"+17185552222"}]',1738960200000,0);
+INSERT OR IGNORE INTO conversations(conversation_id, name, is_group, participants, last_message_ts, unread_count) VALUES('conv1','Sarah Chen',0,'[{"name":"Sarah Chen","number":"+14155551234"}]',1738958400000,0);
+INSERT OR IGNORE INTO conversations(conversation_id, name, is_group, participants, last_message_ts, unread_count) VALUES('conv2','Marcus Johnson',0,'[{"name":"Marcus Johnson","number":"+12125559876"}]',1738956600000,2);
+INSERT OR IGNORE INTO conversations(conversation_id, name, is_group, participants, last_message_ts, unread_count) VALUES('conv4','Emily Park',0,'[{"name":"Emily Park","number":"+13105553456"}]',1738951200000,0);
+INSERT OR IGNORE INTO conversations(conversation_id, name, is_group, participants, last_message_ts, unread_count) VALUES('conv5','Lisa Rodriguez',0,'[{"name":"Lisa Rodriguez","number":"+12025551111"}]',1738947600000,1);
+INSERT OR IGNORE INTO conversations(conversation_id, name, is_group, participants, last_message_ts, unread_count) VALUES('conv6','David Kim',0,'[{"name":"David Kim","number":"+14085557890"}]',1738944000000,0);
+INSERT OR IGNORE INTO conversations(conversation_id, name, is_group, participants, last_message_ts, unread_count) VALUES('conv7','Rachel Green',0,'[{"name":"Rachel Green","number":"+16505553333"}]',1738940400000,0);
+INSERT OR IGNORE INTO conversations(conversation_id, name, is_group, participants, last_message_ts, unread_count) VALUES('conv8','Alex Thompson',0,'[{"name":"Alex Thompson","number":"+17185552222"}]',1738936800000,0);
+
+INSERT OR IGNORE INTO messages(message_id, conversation_id, sender_name, sender_number, body, timestamp_ms, status, is_from_me, media_id, mime_type, decryption_key, reactions, reply_to_id) VALUES('m3a','conv3','Emily Park','+13105553456','Anyone up for a hike this Saturday? Weather looks amazing',1738951200000,'delivered',0,'','','','','');
+INSERT OR IGNORE INTO messages(message_id, conversation_id, sender_name, sender_number, body, timestamp_ms, status, is_from_me, media_id, mime_type, decryption_key, reactions, reply_to_id) VALUES('m3b','conv3','David Kim','+14085557890','I''m in! Lands End or Battery to Bluffs?',1738953000000,'delivered',0,'','','','','');
+INSERT OR IGNORE INTO messages(message_id, conversation_id, sender_name, sender_number, body, timestamp_ms, status, is_from_me, media_id, mime_type, decryption_key, reactions, reply_to_id) VALUES('m3c','conv3','Alex Thompson','+17185552222','Lands End! The wildflowers should be gorgeous right now',1738955400000,'delivered',0,'','','','','');
+INSERT OR IGNORE INTO messages(message_id, conversation_id, sender_name, sender_number, body, timestamp_ms, status, is_from_me, media_id, mime_type, decryption_key, reactions, reply_to_id) VALUES('m3d','conv3','Emily Park','+13105553456','Lands End it is! 9am at the trailhead?',1738957800000,'delivered',0,'','','','','');
+INSERT OR IGNORE INTO messages(message_id, conversation_id, sender_name, sender_number, body, timestamp_ms, status, is_from_me, media_id, mime_type, decryption_key, reactions, reply_to_id) VALUES('m3e','conv3','David Kim','+14085557890','Perfect. I''ll bring coffee for everyone',1738960200000,'delivered',0,'','','','','');
+
+INSERT OR IGNORE INTO messages(message_id, conversation_id, sender_name, sender_number, body, timestamp_ms, status, is_from_me, media_id, mime_type, decryption_key, reactions, reply_to_id) VALUES('m1a','conv1','Sarah Chen','+14155551234','Hey! Are you free for dinner tonight?',1738951200000,'delivered',0,'','','','','');
+INSERT OR IGNORE INTO messages(message_id, conversation_id, sender_name, sender_number, body, timestamp_ms, status, is_from_me, media_id, mime_type, decryption_key, reactions, reply_to_id) VALUES('m1b','conv1','Me','+15551234567','Yes! What did you have in mind?',1738952100000,'delivered',1,'','','','','');
+INSERT OR IGNORE INTO messages(message_id, conversation_id, sender_name, sender_number, body, timestamp_ms, status, is_from_me, media_id, mime_type, decryption_key, reactions, reply_to_id) VALUES('m1c','conv1','Sarah Chen','+14155551234','There is a new Thai place on Valencia that just opened. Heard great things about their pad see ew',1738953000000,'delivered',0,'','','','','');
+INSERT OR IGNORE INTO messages(message_id, conversation_id, sender_name, sender_number, body, timestamp_ms, status, is_from_me, media_id, mime_type, decryption_key, reactions, reply_to_id) VALUES('m1d','conv1','Me','+15551234567','That sounds perfect! What time works for you?',1738954800000,'delivered',1,'','','','','');
+INSERT OR IGNORE INTO messages(message_id, conversation_id, sender_name, sender_number, body, timestamp_ms, status, is_from_me, media_id, mime_type, decryption_key, reactions, reply_to_id) VALUES('m1e','conv1','Sarah Chen','+14155551234','How about 7:30? I can make a reservation',1738956600000,'delivered',0,'','','','','');
+INSERT OR IGNORE INTO messages(message_id, conversation_id, sender_name, sender_number, body, timestamp_ms, status, is_from_me, media_id, mime_type, decryption_key, reactions, reply_to_id) VALUES('m1f','conv1','Me','+15551234567','Perfect, see you there!',1738958400000,'delivered',1,'','','','','');
+
+INSERT OR IGNORE INTO messages(message_id, conversation_id, sender_name, sender_number, body, timestamp_ms, status, is_from_me, media_id, mime_type, decryption_key, reactions, reply_to_id) VALUES('m2a','conv2','Marcus Johnson','+12125559876','Quick update on the project - we hit our Q1 milestone early!',1738944000000,'delivered',0,'','','','','');
+INSERT OR IGNORE INTO messages(message_id, conversation_id, sender_name, sender_number, body, timestamp_ms, status, is_from_me, media_id, mime_type, decryption_key, reactions, reply_to_id) VALUES('m2b','conv2','Me','+15551234567','That is awesome news! The team did a great job.',1738945800000,'delivered',1,'','','','','');
+INSERT OR IGNORE INTO messages(message_id, conversation_id, sender_name, sender_number, body, timestamp_ms, status, is_from_me, media_id, mime_type, decryption_key, reactions, reply_to_id) VALUES('m2c','conv2','Marcus Johnson','+12125559876','Agreed. Want to hop on a call Monday to discuss next steps?',1738947600000,'delivered',0,'','','','','');
+INSERT OR IGNORE INTO messages(message_id, conversation_id, sender_name, sender_number, body, timestamp_ms, status, is_from_me, media_id, mime_type, decryption_key, reactions, reply_to_id) VALUES('m2d','conv2','Marcus Johnson','+12125559876','Also, I sent over the slide deck to review when you get a chance',1738956600000,'delivered',0,'','','','','');
+
+INSERT OR IGNORE INTO messages(message_id, conversation_id, sender_name, sender_number, body, timestamp_ms, status, is_from_me, media_id, mime_type, decryption_key, reactions, reply_to_id) VALUES('m4a','conv4','Emily Park','+13105553456','Thanks for the book recommendation! I am already halfway through it',1738940400000,'delivered',0,'','','','','');
+INSERT OR IGNORE INTO messages(message_id, conversation_id, sender_name, sender_number, body, timestamp_ms, status, is_from_me, media_id, mime_type, decryption_key, reactions, reply_to_id) VALUES('m4b','conv4','Me','+15551234567','Glad you are enjoying it! The second half gets even better',1738951200000,'delivered',1,'','','','','');
+
+INSERT OR IGNORE INTO messages(message_id, conversation_id, sender_name, sender_number, body, timestamp_ms, status, is_from_me, media_id, mime_type, decryption_key, reactions, reply_to_id) VALUES('m5a','conv5','Lisa Rodriguez','+12025551111','Are we still on for coffee tomorrow morning?',1738936800000,'delivered',0,'','','','','');
+INSERT OR IGNORE INTO messages(message_id, conversation_id, sender_name, sender_number, body, timestamp_ms, status, is_from_me, media_id, mime_type, decryption_key, reactions, reply_to_id) VALUES('m5b','conv5','Me','+15551234567','Absolutely! Blue Bottle at 10?',1738938600000,'delivered',1,'','','','','');
+INSERT OR IGNORE INTO messages(message_id, conversation_id, sender_name, sender_number, body, timestamp_ms, status, is_from_me, media_id, mime_type, decryption_key, reactions, reply_to_id) VALUES('m5c','conv5','Lisa Rodriguez','+12025551111','Sounds great! I have some exciting news to share',1738947600000,'delivered',0,'','','','','');
+
+INSERT OR IGNORE INTO messages(message_id, conversation_id, sender_name, sender_number, body, timestamp_ms, status, is_from_me, media_id, mime_type, decryption_key, reactions, reply_to_id) VALUES('m6a','conv6','Me','+15551234567','Hey, did you see the Warriors game last night?',1738933200000,'delivered',1,'','','','','');
+INSERT OR IGNORE INTO messages(message_id, conversation_id, sender_name, sender_number, body, timestamp_ms, status, is_from_me, media_id, mime_type, decryption_key, reactions, reply_to_id) VALUES('m6b','conv6','David Kim','+14085557890','Incredible comeback! Curry was unreal in the 4th quarter',1738936800000,'delivered',0,'','','','','');
+INSERT OR IGNORE INTO messages(message_id, conversation_id, sender_name, sender_number, body, timestamp_ms, status, is_from_me, media_id, mime_type, decryption_key, reactions, reply_to_id) VALUES('m6c','conv6','Me','+15551234567','We should catch the next home game together',1738944000000,'delivered',1,'','','','','');
+
+INSERT OR IGNORE INTO messages(message_id, conversation_id, sender_name, sender_number, body, timestamp_ms, status, is_from_me, media_id, mime_type, decryption_key, reactions, reply_to_id) VALUES('m7a','conv7','Rachel Green','+16505553333','Just landed! Flight was smooth. Thanks for the ride to the airport',1738929600000,'delivered',0,'','','','','');
+INSERT OR IGNORE INTO messages(message_id, conversation_id, sender_name, sender_number, body, timestamp_ms, status, is_from_me, media_id, mime_type, decryption_key, reactions, reply_to_id) VALUES('m7b','conv7','Me','+15551234567','Anytime! Have an amazing trip',1738940400000,'delivered',1,'','','','','');
+
+INSERT OR IGNORE INTO messages(message_id, conversation_id, sender_name, sender_number, body, timestamp_ms, status, is_from_me, media_id, mime_type, decryption_key, reactions, reply_to_id) VALUES('m8a','conv8','Alex Thompson','+17185552222','Found that restaurant we were talking about - it is called Nopa',1738929600000,'delivered',0,'','','','','');
+INSERT OR IGNORE INTO messages(message_id, conversation_id, sender_name, sender_number, body, timestamp_ms, status, is_from_me, media_id, mime_type, decryption_key, reactions, reply_to_id) VALUES('m8b','conv8','Me','+15551234567','Nice find! Let us go next week',1738936800000,'delivered',1,'','','','','');
+
+INSERT OR IGNORE INTO contacts(contact_id, name, number) VALUES('c1','Sarah Chen','+14155551234');
+INSERT OR IGNORE INTO contacts(contact_id, name, number) VALUES('c2','Marcus Johnson','+12125559876');
+INSERT OR IGNORE INTO contacts(contact_id, name, number) VALUES('c3','Emily Park','+13105553456');
+INSERT OR IGNORE INTO contacts(contact_id, name, number) VALUES('c4','David Kim','+14085557890');
+INSERT OR IGNORE INTO contacts(contact_id, name, number) VALUES('c5','Lisa Rodriguez','+12025551111');
+INSERT OR IGNORE INTO contacts(contact_id, name, number) VALUES('c6','Alex Thompson','+17185552222');
+INSERT OR IGNORE INTO contacts(contact_id, name, number) VALUES('c7','Rachel Green','+16505553333');
+
+INSERT OR IGNORE INTO drafts(draft_id, conversation_id, body, created_at) VALUES('draft1','conv3','Count me in for Saturday! Lands End trail looks clear — 62°F and sunny. Want me to bring snacks?',1738961000000);
 	`
-	_, err := s.db.Exec(inserts)
-	return err
+	if _, err := s.db.Exec(inserts); err != nil {
+		return err
+	}
+	if err := s.backfillParticipants(); err != nil {
+		return err
+	}
+	if err := s.backfillNormalizedNumbers(); err != nil {
+		return err
+	}
+	return s.backfillFTS()
 }
 
 func (s *Store) migrate() error {
+	// Conversations stored before the participants table only have the JSON.
+	var hasParticipants bool
+	if err := s.db.QueryRow(`
+		SELECT COUNT(*) > 0 FROM sqlite_master WHERE type = 'table' AND name = 'participants'
+	`).Scan(&hasParticipants); err != nil {
+		return err
+	}
+
 	schema := `
 	CREATE TABLE IF NOT EXISTS conversations (
 		conversation_id TEXT PRIMARY KEY,
@@ -145,9 +320,19 @@ func (s *Store) migrate() error {
 		is_group INTEGER NOT NULL DEFAULT 0,
 		participants TEXT NOT NULL DEFAULT '[]',
 		last_message_ts INTEGER NOT NULL DEFAULT 0,
-		unread_count INTEGER NOT NULL DEFAULT 0
+		unread_count INTEGER NOT NULL DEFAULT 0,
+		send_as TEXT NOT NULL DEFAULT 'auto',
+		color TEXT NOT NULL DEFAULT '',
+		archived INTEGER NOT NULL DEFAULT 0,
+		transport TEXT NOT NULL DEFAULT '',
+		pinned INTEGER NOT NULL DEFAULT 0,
+		muted INTEGER NOT NULL DEFAULT 0,
+		last_read_ts INTEGER NOT NULL DEFAULT 0,
+		is_self_chat INTEGER NOT NULL DEFAULT 0
 	);
 
+	CREATE INDEX IF NOT EXISTS idx_conversations_last_ts ON conversations(last_message_ts DESC);
+
 	CREATE TABLE IF NOT EXISTS messages (
 		message_id TEXT PRIMARY KEY,
 		conversation_id TEXT NOT NULL DEFAULT '',
@@ -161,7 +346,23 @@ func (s *Store) migrate() error {
 		mime_type TEXT NOT NULL DEFAULT '',
 		decryption_key TEXT NOT NULL DEFAULT '',
 		reactions TEXT NOT NULL DEFAULT '',
-		reply_to_id TEXT NOT NULL DEFAULT ''
+		reply_to_id TEXT NOT NULL DEFAULT '',
+		raw_payload TEXT NOT NULL DEFAULT '',
+		pinned INTEGER NOT NULL DEFAULT 0,
+		media_size INTEGER NOT NULL DEFAULT 0,
+		starred INTEGER NOT NULL DEFAULT 0,
+		has_link INTEGER NOT NULL DEFAULT 0,
+		media_cloud_url TEXT NOT NULL DEFAULT '',
+		deleted INTEGER NOT NULL DEFAULT 0,
+		tmp_id TEXT NOT NULL DEFAULT '',
+		edited_at_ms INTEGER NOT NULL DEFAULT 0,
+		location TEXT NOT NULL DEFAULT '',
+		shared_contact TEXT NOT NULL DEFAULT '',
+		subtype TEXT NOT NULL DEFAULT '',
+		thumbnail_media_id TEXT NOT NULL DEFAULT '',
+		thumbnail_decryption_key TEXT NOT NULL DEFAULT '',
+		attachment_count INTEGER NOT NULL DEFAULT 0,
+		sender_e164 TEXT NOT NULL DEFAULT ''
 	);
 
 	CREATE INDEX IF NOT EXISTS idx_messages_conv_ts ON messages(conversation_id, timestamp_ms);
@@ -170,7 +371,60 @@ func (s *Store) migrate() error {
 	CREATE TABLE IF NOT EXISTS contacts (
 		contact_id TEXT PRIMARY KEY,
 		name TEXT NOT NULL DEFAULT '',
-		number TEXT NOT NULL DEFAULT ''
+		number TEXT NOT NULL DEFAULT '',
+		display_number TEXT NOT NULL DEFAULT ''
+	);
+	CREATE INDEX IF NOT EXISTS idx_contacts_number ON contacts(number);
+
+	CREATE TABLE IF NOT EXISTS sync_state (
+		kind TEXT NOT NULL,
+		item_id TEXT NOT NULL,
+		status TEXT NOT NULL DEFAULT 'pending',
+		attempts INTEGER NOT NULL DEFAULT 0,
+		last_error TEXT NOT NULL DEFAULT '',
+		updated_at INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (kind, item_id)
+	);
+
+	CREATE TABLE IF NOT EXISTS send_latency (
+		message_id TEXT PRIMARY KEY,
+		conversation_id TEXT NOT NULL,
+		latency_ms INTEGER NOT NULL,
+		recorded_at INTEGER NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_send_latency_recorded ON send_latency(recorded_at DESC);
+
+	CREATE TABLE IF NOT EXISTS message_status_events (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		message_id TEXT NOT NULL,
+		status TEXT NOT NULL,
+		ts INTEGER NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_status_events_msg ON message_status_events(message_id, ts);
+
+	CREATE TABLE IF NOT EXISTS message_edits (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		message_id TEXT NOT NULL,
+		body TEXT NOT NULL,
+		edited_at_ms INTEGER NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_message_edits_msg ON message_edits(message_id, edited_at_ms);
+
+	CREATE TABLE IF NOT EXISTS fetch_cursors (
+		conversation_id TEXT PRIMARY KEY,
+		last_item_id TEXT NOT NULL DEFAULT '',
+		last_item_ts INTEGER NOT NULL DEFAULT 0,
+		exhausted INTEGER NOT NULL DEFAULT 0,
+		updated_at INTEGER NOT NULL DEFAULT 0
+	);
+
+	CREATE TABLE IF NOT EXISTS backfill_state (
+		conversation_id TEXT PRIMARY KEY,
+		last_item_id TEXT NOT NULL DEFAULT '',
+		last_item_ts INTEGER NOT NULL DEFAULT 0,
+		oldest_ts INTEGER NOT NULL DEFAULT 0,
+		complete INTEGER NOT NULL DEFAULT 0,
+		updated_at INTEGER NOT NULL DEFAULT 0
 	);
 
 	CREATE TABLE IF NOT EXISTS drafts (
@@ -179,6 +433,32 @@ func (s *Store) migrate() error {
 		body TEXT NOT NULL DEFAULT '',
 		created_at INTEGER NOT NULL DEFAULT 0
 	);
+
+	CREATE TABLE IF NOT EXISTS participants (
+		conversation_id TEXT NOT NULL,
+		position INTEGER NOT NULL,
+		number TEXT NOT NULL DEFAULT '',
+		number_key TEXT NOT NULL DEFAULT '',
+		name TEXT NOT NULL DEFAULT '',
+		is_me INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (conversation_id, position)
+	);
+	CREATE INDEX IF NOT EXISTS idx_participants_number ON participants(number_key);
+
+	CREATE TABLE IF NOT EXISTS media_uploads (
+		sha256 TEXT PRIMARY KEY,
+		url TEXT NOT NULL,
+		size INTEGER NOT NULL DEFAULT 0,
+		uploaded_at INTEGER NOT NULL DEFAULT 0
+	);
+
+	CREATE TABLE IF NOT EXISTS avatars (
+		number_key TEXT PRIMARY KEY,
+		number TEXT NOT NULL DEFAULT '',
+		data BLOB NOT NULL,
+		mime_type TEXT NOT NULL DEFAULT '',
+		updated_at INTEGER NOT NULL DEFAULT 0
+	);
 	`
 	if _, err := s.db.Exec(schema); err != nil {
 		return err
@@ -190,8 +470,57 @@ func (s *Store) migrate() error {
 		"ALTER TABLE messages ADD COLUMN decryption_key TEXT NOT NULL DEFAULT ''",
 		"ALTER TABLE messages ADD COLUMN reactions TEXT NOT NULL DEFAULT ''",
 		"ALTER TABLE messages ADD COLUMN reply_to_id TEXT NOT NULL DEFAULT ''",
+		"ALTER TABLE messages ADD COLUMN raw_payload TEXT NOT NULL DEFAULT ''",
+		"ALTER TABLE messages ADD COLUMN pinned INTEGER NOT NULL DEFAULT 0",
+		"ALTER TABLE messages ADD COLUMN media_size INTEGER NOT NULL DEFAULT 0",
+		"ALTER TABLE messages ADD COLUMN starred INTEGER NOT NULL DEFAULT 0",
+		"ALTER TABLE messages ADD COLUMN media_cloud_url TEXT NOT NULL DEFAULT ''",
+		"ALTER TABLE messages ADD COLUMN deleted INTEGER NOT NULL DEFAULT 0",
+		"ALTER TABLE messages ADD COLUMN tmp_id TEXT NOT NULL DEFAULT ''",
+		"ALTER TABLE messages ADD COLUMN edited_at_ms INTEGER NOT NULL DEFAULT 0",
+		"ALTER TABLE messages ADD COLUMN location TEXT NOT NULL DEFAULT ''",
+		"ALTER TABLE messages ADD COLUMN shared_contact TEXT NOT NULL DEFAULT ''",
+		"ALTER TABLE messages ADD COLUMN subtype TEXT NOT NULL DEFAULT ''",
+		"ALTER TABLE messages ADD COLUMN thumbnail_media_id TEXT NOT NULL DEFAULT ''",
+		"ALTER TABLE messages ADD COLUMN thumbnail_decryption_key TEXT NOT NULL DEFAULT ''",
+		"ALTER TABLE messages ADD COLUMN attachment_count INTEGER NOT NULL DEFAULT 0",
+		"ALTER TABLE messages ADD COLUMN sender_e164 TEXT NOT NULL DEFAULT ''",
+		"ALTER TABLE contacts ADD COLUMN display_number TEXT NOT NULL DEFAULT ''",
+		"ALTER TABLE conversations ADD COLUMN send_as TEXT NOT NULL DEFAULT 'auto'",
+		"ALTER TABLE conversations ADD COLUMN color TEXT NOT NULL DEFAULT ''",
+		"ALTER TABLE conversations ADD COLUMN archived INTEGER NOT NULL DEFAULT 0",
+		"ALTER TABLE conversations ADD COLUMN transport TEXT NOT NULL DEFAULT ''",
+		"ALTER TABLE conversations ADD COLUMN pinned INTEGER NOT NULL DEFAULT 0",
+		"ALTER TABLE conversations ADD COLUMN muted INTEGER NOT NULL DEFAULT 0",
+		"ALTER TABLE conversations ADD COLUMN last_read_ts INTEGER NOT NULL DEFAULT 0",
+		"ALTER TABLE conversations ADD COLUMN is_self_chat INTEGER NOT NULL DEFAULT 0",
 	} {
 		s.db.Exec(col) // ignore "duplicate column" errors
 	}
-	return nil
+	// Indexes on added columns can only be created once the columns exist.
+	if _, err := s.db.Exec(`CREATE INDEX IF NOT EXISTS idx_messages_tmp_id ON messages(tmp_id) WHERE tmp_id != ''`); err != nil {
+		return fmt.Errorf("create tmp_id index: %w", err)
+	}
+	if _, err := s.db.Exec(`CREATE INDEX IF NOT EXISTS idx_messages_sender_e164 ON messages(sender_e164)`); err != nil {
+		return fmt.Errorf("create sender_e164 index: %w", err)
+	}
+	// has_link is derived from the body, so existing rows need computing once.
+	if _, err := s.db.Exec("ALTER TABLE messages ADD COLUMN has_link INTEGER NOT NULL DEFAULT 0"); err == nil {
+		if err := s.backfillLinks(); err != nil {
+			return fmt.Errorf("backfill has_link: %w", err)
+		}
+	}
+	// Synced items used to be kept in sync_state; only outstanding ones are now.
+	if _, err := s.db.Exec(`DELETE FROM sync_state WHERE status = 'synced'`); err != nil {
+		return fmt.Errorf("prune sync state: %w", err)
+	}
+	if !hasParticipants {
+		if err := s.backfillParticipants(); err != nil {
+			return fmt.Errorf("backfill participants: %w", err)
+		}
+	}
+	if err := s.backfillNormalizedNumbers(); err != nil {
+		return fmt.Errorf("normalize numbers: %w", err)
+	}
+	return s.initFTS()
 }
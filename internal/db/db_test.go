@@ -530,3 +530,22 @@ func TestGetMessagesNoFilters(t *testing.T) {
 		t.Errorf("expected 3, got %d", len(msgs))
 	}
 }
+
+func TestSeedDemo(t *testing.T) {
+	store, err := New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if err := store.SeedDemo(); err != nil {
+		t.Fatalf("seed demo: %v", err)
+	}
+	convs, err := store.ListConversations(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(convs) != 8 {
+		t.Errorf("got %d demo conversations, want 8", len(convs))
+	}
+}
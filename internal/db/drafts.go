@@ -1,5 +1,18 @@
 package db
 
+import (
+	"database/sql"
+	"errors"
+)
+
+// ErrConversationNotFound is returned by MoveDraft when the target
+// conversation isn't stored.
+var ErrConversationNotFound = errors.New("conversation not found")
+
+// ErrDraftExists is returned by MoveDraft when the target conversation
+// already has a draft.
+var ErrDraftExists = errors.New("conversation already has a draft")
+
 func (s *Store) UpsertDraft(d *Draft) error {
 	_, err := s.db.Exec(`
 		INSERT INTO drafts (draft_id, conversation_id, body, created_at)
@@ -12,6 +25,44 @@ func (s *Store) UpsertDraft(d *Draft) error {
 	return err
 }
 
+// SaveConversationDraft stores d as its conversation's draft. If the
+// conversation already has one, the newest is updated with d's body and time
+// and d.DraftID is set to its ID; otherwise d is inserted as given. This keeps
+// autosave to one draft per conversation.
+func (s *Store) SaveConversationDraft(d *Draft) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var existing string
+	err = tx.QueryRow(`
+		SELECT draft_id FROM drafts WHERE conversation_id = ?
+		ORDER BY created_at DESC LIMIT 1
+	`, d.ConversationID).Scan(&existing)
+	switch {
+	case err == nil:
+		d.DraftID = existing
+		_, err = tx.Exec(`UPDATE drafts SET body = ?, created_at = ? WHERE draft_id = ?`, d.Body, d.CreatedAt, d.DraftID)
+	case errors.Is(err, sql.ErrNoRows):
+		_, err = tx.Exec(`
+			INSERT INTO drafts (draft_id, conversation_id, body, created_at)
+			VALUES (?, ?, ?, ?)
+		`, d.DraftID, d.ConversationID, d.Body, d.CreatedAt)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteConversationDrafts removes every draft of a conversation.
+func (s *Store) DeleteConversationDrafts(conversationID string) error {
+	_, err := s.db.Exec(`DELETE FROM drafts WHERE conversation_id = ?`, conversationID)
+	return err
+}
+
 func (s *Store) ListDrafts(conversationID string) ([]*Draft, error) {
 	rows, err := s.db.Query(`
 		SELECT draft_id, conversation_id, body, created_at
@@ -55,3 +106,51 @@ func (s *Store) DeleteDraft(draftID string) error {
 	_, err := s.db.Exec(`DELETE FROM drafts WHERE draft_id = ?`, draftID)
 	return err
 }
+
+// MoveDraft retargets a draft to another conversation. Returns sql.ErrNoRows
+// if the draft doesn't exist, ErrConversationNotFound if the target
+// conversation doesn't, and ErrDraftExists if the target already has a
+// draft, since a conversation keeps only one.
+func (s *Store) MoveDraft(draftID, newConvID string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	if err := tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM conversations WHERE conversation_id = ?)`, newConvID).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return ErrConversationNotFound
+	}
+	var taken bool
+	if err := tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM drafts WHERE conversation_id = ? AND draft_id != ?)`, newConvID, draftID).Scan(&taken); err != nil {
+		return err
+	}
+	if taken {
+		return ErrDraftExists
+	}
+	res, err := tx.Exec(`UPDATE drafts SET conversation_id = ? WHERE draft_id = ?`, newConvID, draftID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return tx.Commit()
+}
+
+// UpdateDraftBody replaces a draft's text. Returns sql.ErrNoRows if the
+// draft doesn't exist.
+func (s *Store) UpdateDraftBody(draftID, body string) error {
+	res, err := s.db.Exec(`UPDATE drafts SET body = ? WHERE draft_id = ?`, body, draftID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+)
+
+func TestMoveDraft(t *testing.T) {
+	store := newTestStore(t)
+	store.UpsertConversation(&Conversation{ConversationID: "c1", Name: "Alice"})
+	store.UpsertConversation(&Conversation{ConversationID: "c2", Name: "Bob"})
+	store.UpsertDraft(&Draft{DraftID: "d1", ConversationID: "c1", Body: "hello", CreatedAt: 1000})
+
+	if err := store.MoveDraft("d1", "c2"); err != nil {
+		t.Fatalf("move: %v", err)
+	}
+	d, _ := store.GetDraft("d1")
+	if d.ConversationID != "c2" || d.Body != "hello" {
+		t.Errorf("after move: %+v", d)
+	}
+	if drafts, _ := store.ListDrafts("c1"); len(drafts) != 0 {
+		t.Errorf("draft still listed under old conversation: %+v", drafts)
+	}
+
+	if err := store.MoveDraft("d1", "nope"); !errors.Is(err, ErrConversationNotFound) {
+		t.Errorf("missing conversation: err = %v, want ErrConversationNotFound", err)
+	}
+	if err := store.MoveDraft("missing", "c1"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("missing draft: err = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func TestMoveDraftIntoConversationWithDraft(t *testing.T) {
+	store := newTestStore(t)
+	store.UpsertConversation(&Conversation{ConversationID: "c1", Name: "Alice"})
+	store.UpsertConversation(&Conversation{ConversationID: "c2", Name: "Bob"})
+	store.UpsertDraft(&Draft{DraftID: "d1", ConversationID: "c1", Body: "hello", CreatedAt: 1000})
+	store.UpsertDraft(&Draft{DraftID: "d2", ConversationID: "c2", Body: "hi", CreatedAt: 2000})
+
+	if err := store.MoveDraft("d1", "c2"); !errors.Is(err, ErrDraftExists) {
+		t.Fatalf("err = %v, want ErrDraftExists", err)
+	}
+	if d, _ := store.GetDraft("d1"); d.ConversationID != "c1" {
+		t.Errorf("d1 moved to %q despite the conflict", d.ConversationID)
+	}
+	if drafts, _ := store.ListDrafts("c2"); len(drafts) != 1 || drafts[0].DraftID != "d2" {
+		t.Errorf("c2 drafts = %+v, want only d2", drafts)
+	}
+
+	// Moving a draft to the conversation it's already in is not a conflict.
+	if err := store.MoveDraft("d2", "c2"); err != nil {
+		t.Errorf("move in place: %v", err)
+	}
+}
+
+func TestUpdateDraftBody(t *testing.T) {
+	store := newTestStore(t)
+	store.UpsertDraft(&Draft{DraftID: "d1", ConversationID: "c1", Body: "hello", CreatedAt: 1000})
+
+	if err := store.UpdateDraftBody("d1", "hello there"); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if d, _ := store.GetDraft("d1"); d.Body != "hello there" {
+		t.Errorf("body = %q", d.Body)
+	}
+	if err := store.UpdateDraftBody("missing", "x"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("missing draft: err = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func TestSaveConversationDraft(t *testing.T) {
+	store := newTestStore(t)
+
+	d := &Draft{DraftID: "d1", ConversationID: "c1", Body: "on my", CreatedAt: 1000}
+	if err := store.SaveConversationDraft(d); err != nil {
+		t.Fatalf("first save: %v", err)
+	}
+	d = &Draft{DraftID: "d2", ConversationID: "c1", Body: "on my way", CreatedAt: 2000}
+	if err := store.SaveConversationDraft(d); err != nil {
+		t.Fatalf("second save: %v", err)
+	}
+	if d.DraftID != "d1" {
+		t.Errorf("DraftID = %q, want the existing d1", d.DraftID)
+	}
+	drafts, _ := store.ListDrafts("c1")
+	if len(drafts) != 1 || drafts[0].Body != "on my way" || drafts[0].CreatedAt != 2000 {
+		t.Errorf("drafts = %+v, want one updated draft", drafts)
+	}
+
+	// Another conversation gets its own draft.
+	if err := store.SaveConversationDraft(&Draft{DraftID: "d3", ConversationID: "c2", Body: "hi", CreatedAt: 3000}); err != nil {
+		t.Fatalf("save c2: %v", err)
+	}
+	if drafts, _ := store.ListDrafts("c2"); len(drafts) != 1 || drafts[0].DraftID != "d3" {
+		t.Errorf("c2 drafts = %+v", drafts)
+	}
+
+	if err := store.DeleteConversationDrafts("c1"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if drafts, _ := store.ListDrafts("c1"); len(drafts) != 0 {
+		t.Errorf("c1 drafts after delete = %+v", drafts)
+	}
+}
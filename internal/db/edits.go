@@ -0,0 +1,29 @@
+package db
+
+// MessageEdit is an earlier body of an edited message.
+type MessageEdit struct {
+	Body       string `json:"body"`
+	EditedAtMS int64  `json:"edited_at_ms"` // when this body was replaced
+}
+
+// GetMessageEdits returns a message's earlier bodies, oldest first.
+func (s *Store) GetMessageEdits(messageID string) ([]MessageEdit, error) {
+	rows, err := s.db.Query(`
+		SELECT body, edited_at_ms FROM message_edits
+		WHERE message_id = ?
+		ORDER BY edited_at_ms ASC, id ASC
+	`, messageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var edits []MessageEdit
+	for rows.Next() {
+		var e MessageEdit
+		if err := rows.Scan(&e.Body, &e.EditedAtMS); err != nil {
+			return nil, err
+		}
+		edits = append(edits, e)
+	}
+	return edits, rows.Err()
+}
@@ -0,0 +1,43 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// FetchCursor tracks how far back a conversation's history has been paged
+// from Google beyond what sync delivered.
+type FetchCursor struct {
+	ConversationID string
+	LastItemID     string
+	LastItemTS     int64 // microseconds, as the server reports it
+	Exhausted      bool  // the server has no older messages
+	UpdatedAt      int64
+}
+
+// GetFetchCursor returns the saved cursor for a conversation, or nil if
+// none has been saved.
+func (s *Store) GetFetchCursor(convID string) (*FetchCursor, error) {
+	c := &FetchCursor{ConversationID: convID}
+	err := s.db.QueryRow(`
+		SELECT last_item_id, last_item_ts, exhausted, updated_at
+		FROM fetch_cursors WHERE conversation_id = ?
+	`, convID).Scan(&c.LastItemID, &c.LastItemTS, &c.Exhausted, &c.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// SaveFetchCursor stores c as the conversation's cursor.
+func (s *Store) SaveFetchCursor(c *FetchCursor) error {
+	_, err := s.db.Exec(`
+		INSERT OR REPLACE INTO fetch_cursors (conversation_id, last_item_id, last_item_ts, exhausted, updated_at)
+		VALUES (?, ?, ?, ?, ?)
+	`, c.ConversationID, c.LastItemID, c.LastItemTS, c.Exhausted, time.Now().UnixMilli())
+	return err
+}
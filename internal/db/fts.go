@@ -0,0 +1,50 @@
+package db
+
+import (
+	"strings"
+	"unicode"
+)
+
+// messages_fts indexes message bodies for ranked full-text search. Its rowid
+// is the messages rowid, which stays stable across upserts.
+const ftsSchema = `CREATE VIRTUAL TABLE IF NOT EXISTS messages_fts USING fts5(body)`
+
+// initFTS creates and backfills the full-text index. If the SQLite build
+// lacks FTS5, search falls back to LIKE and s.fts stays false.
+func (s *Store) initFTS() error {
+	if _, err := s.db.Exec(ftsSchema); err != nil {
+		return nil
+	}
+	s.fts = true
+	return s.backfillFTS()
+}
+
+// backfillFTS indexes any messages not yet in messages_fts, e.g. rows that
+// predate the index or were inserted without going through UpsertMessage.
+func (s *Store) backfillFTS() error {
+	if !s.fts {
+		return nil
+	}
+	_, err := s.db.Exec(`
+		INSERT INTO messages_fts(rowid, body)
+		SELECT rowid, body FROM messages
+		WHERE rowid NOT IN (SELECT rowid FROM messages_fts)
+	`)
+	return err
+}
+
+// ftsQuery turns free text into an FTS5 MATCH expression that requires
+// every word as a prefix. ok is false when the text has no indexable words
+// (punctuation, emoji), in which case LIKE is the better tool.
+func ftsQuery(text string) (q string, ok bool) {
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	if len(words) == 0 {
+		return "", false
+	}
+	for i, w := range words {
+		words[i] = `"` + w + `"*`
+	}
+	return strings.Join(words, " "), true
+}
@@ -0,0 +1,71 @@
+package db
+
+import (
+	"regexp"
+	"strings"
+)
+
+// linkPattern matches URLs with a scheme, www. hosts, and bare domains under
+// common TLDs. It errs toward missing odd links rather than flagging
+// abbreviations like "e.g." as links.
+var linkPattern = regexp.MustCompile(`(?i)\bhttps?://\S+|\bwww\.[a-z0-9-]+\.[a-z]{2,}|\b[a-z0-9][a-z0-9-]*\.(?:com|org|net|io|co|ly|me|app|dev|gov|edu|us|uk|ca|gl|gg|tv|info|link)\b(?:/\S*)?`)
+
+// ContainsLink reports whether text contains something that looks like a URL.
+func ContainsLink(text string) bool {
+	return strings.Contains(text, ".") && linkPattern.MatchString(text)
+}
+
+// MessageFilter narrows message queries to messages with links or media.
+// The zero value matches everything.
+type MessageFilter struct {
+	HasLink  bool
+	HasMedia bool
+}
+
+// conditions returns the SQL WHERE conditions for f.
+func (f MessageFilter) conditions() []string {
+	var c []string
+	if f.HasLink {
+		c = append(c, "has_link = 1")
+	}
+	if f.HasMedia {
+		c = append(c, "media_id != ''")
+	}
+	return c
+}
+
+// backfillLinks sets has_link on stored messages, for databases that predate
+// the column.
+func (s *Store) backfillLinks() error {
+	rows, err := s.db.Query(`SELECT message_id, body FROM messages WHERE body LIKE '%.%'`)
+	if err != nil {
+		return err
+	}
+	var ids []string
+	for rows.Next() {
+		var id, body string
+		if err := rows.Scan(&id, &body); err != nil {
+			rows.Close()
+			return err
+		}
+		if ContainsLink(body) {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, id := range ids {
+		if _, err := tx.Exec(`UPDATE messages SET has_link = 1 WHERE message_id = ?`, id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
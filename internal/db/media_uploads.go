@@ -0,0 +1,28 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// MediaUploadURL returns the public URL that media with the given SHA-256
+// (hex) was uploaded to, or "" if it hasn't been uploaded.
+func (s *Store) MediaUploadURL(hash string) (string, error) {
+	var url string
+	err := s.db.QueryRow(`SELECT url FROM media_uploads WHERE sha256 = ?`, hash).Scan(&url)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return url, err
+}
+
+// RecordMediaUpload remembers that media with the given SHA-256 (hex) and
+// size was uploaded to url, replacing any earlier URL for it.
+func (s *Store) RecordMediaUpload(hash, url string, size int64) error {
+	_, err := s.db.Exec(`
+		INSERT OR REPLACE INTO media_uploads (sha256, url, size, uploaded_at)
+		VALUES (?, ?, ?, ?)
+	`, hash, url, size, time.Now().UnixMilli())
+	return err
+}
@@ -1,18 +1,70 @@
 package db
 
 import (
+	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"regexp"
 	"strings"
+	"time"
 )
 
+// messageColumns lists the messages table columns in the order scanMessage expects.
+const messageColumns = `message_id, conversation_id, sender_name, sender_number, body, timestamp_ms, status, is_from_me, media_id, mime_type, decryption_key, reactions, reply_to_id, raw_payload, pinned, media_size, starred, has_link, media_cloud_url, deleted, tmp_id, edited_at_ms, location, shared_contact, subtype, thumbnail_media_id, thumbnail_decryption_key, attachment_count`
+
+// ErrMessageNotFound is returned when a referenced message doesn't exist.
+var ErrMessageNotFound = errors.New("message not found")
+
+// maxClockSkew is how far in the future a message timestamp may be before
+// it's considered bogus and clamped to the time we stored it.
+const maxClockSkew = 24 * time.Hour
+
+// sanitizeTimestamp clamps absurd future timestamps to now. Zero means the
+// time is unknown and is kept as-is, so such messages sort oldest.
+func sanitizeTimestamp(ts int64, now time.Time) int64 {
+	if ts > now.Add(maxClockSkew).UnixMilli() {
+		return now.UnixMilli()
+	}
+	return ts
+}
+
+// UpsertMessage inserts or updates a message from sync data. Local-only
+// state such as the pinned and starred flags is kept on update, a deleted
+// message stays deleted, and a known tmp ID is never cleared. When an
+// update replaces a non-empty body with different text, the old body is
+// kept in message_edits and m.EditedAtMS is set. Far-future timestamps are
+// clamped to the current time, and m.TimestampMS is updated to match.
+// m.HasLink is computed from the body.
 func (s *Store) UpsertMessage(m *Message) error {
-	_, err := s.db.Exec(`
-		INSERT INTO messages (message_id, conversation_id, sender_name, sender_number, body, timestamp_ms, status, is_from_me, media_id, mime_type, decryption_key, reactions, reply_to_id)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	m.TimestampMS = sanitizeTimestamp(m.TimestampMS, time.Now())
+	m.HasLink = ContainsLink(m.Body)
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var prevBody string
+	var prevEditedAt int64
+	err = tx.QueryRow(`SELECT body, edited_at_ms FROM messages WHERE message_id = ?`, m.MessageID).Scan(&prevBody, &prevEditedAt)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	m.EditedAtMS = prevEditedAt
+	if prevBody != "" && m.Body != "" && m.Body != prevBody {
+		m.EditedAtMS = time.Now().UnixMilli()
+		if _, err := tx.Exec(`INSERT INTO message_edits (message_id, body, edited_at_ms) VALUES (?, ?, ?)`, m.MessageID, prevBody, m.EditedAtMS); err != nil {
+			return fmt.Errorf("record edit: %w", err)
+		}
+	}
+	_, err = tx.Exec(`
+		INSERT INTO messages (`+messageColumns+`, sender_e164)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 		ON CONFLICT(message_id) DO UPDATE SET
 			conversation_id=excluded.conversation_id,
 			sender_name=excluded.sender_name,
 			sender_number=excluded.sender_number,
+			sender_e164=excluded.sender_e164,
 			body=excluded.body,
 			timestamp_ms=excluded.timestamp_ms,
 			status=excluded.status,
@@ -21,19 +73,150 @@ func (s *Store) UpsertMessage(m *Message) error {
 			mime_type=excluded.mime_type,
 			decryption_key=excluded.decryption_key,
 			reactions=excluded.reactions,
-			reply_to_id=excluded.reply_to_id
-	`, m.MessageID, m.ConversationID, m.SenderName, m.SenderNumber, m.Body, m.TimestampMS, m.Status, m.IsFromMe, m.MediaID, m.MimeType, m.DecryptionKey, m.Reactions, m.ReplyToID)
-	return err
+			reply_to_id=excluded.reply_to_id,
+			raw_payload=excluded.raw_payload,
+			media_size=excluded.media_size,
+			has_link=excluded.has_link,
+			deleted=MAX(messages.deleted, excluded.deleted),
+			tmp_id=CASE WHEN excluded.tmp_id != '' THEN excluded.tmp_id ELSE messages.tmp_id END,
+			edited_at_ms=excluded.edited_at_ms,
+			location=excluded.location,
+			shared_contact=excluded.shared_contact,
+			subtype=excluded.subtype,
+			thumbnail_media_id=excluded.thumbnail_media_id,
+			thumbnail_decryption_key=excluded.thumbnail_decryption_key,
+			attachment_count=excluded.attachment_count
+	`, m.MessageID, m.ConversationID, m.SenderName, m.SenderNumber, m.Body, m.TimestampMS, m.Status, m.IsFromMe, m.MediaID, m.MimeType, m.DecryptionKey, m.Reactions, m.ReplyToID, m.RawPayload, m.Pinned, m.MediaSize, m.Starred, m.HasLink, m.MediaCloudURL, m.Deleted, m.TmpID, m.EditedAtMS, encodeJSON(m.Location), encodeJSON(m.SharedContact), m.Subtype, m.ThumbnailMediaID, m.ThumbnailDecryptionKey, m.AttachmentCount, s.NormalizeNumber(m.SenderNumber))
+	if err != nil {
+		return err
+	}
+	if s.fts {
+		var rowid int64
+		if err := tx.QueryRow(`SELECT rowid FROM messages WHERE message_id = ?`, m.MessageID).Scan(&rowid); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`DELETE FROM messages_fts WHERE rowid = ?`, rowid); err != nil {
+			return fmt.Errorf("update search index: %w", err)
+		}
+		if _, err := tx.Exec(`INSERT INTO messages_fts(rowid, body) VALUES (?, ?)`, rowid, m.Body); err != nil {
+			return fmt.Errorf("update search index: %w", err)
+		}
+	}
+	return tx.Commit()
 }
 
 func (s *Store) GetMessagesByConversation(conversationID string, limit int) ([]*Message, error) {
+	return s.GetMessagesByConversationBefore(conversationID, 0, limit)
+}
+
+// GetMessagesByConversationBefore returns up to limit messages older than
+// beforeTS, newest first. A zero beforeTS returns the newest page.
+func (s *Store) GetMessagesByConversationBefore(conversationID string, beforeTS int64, limit int) ([]*Message, error) {
+	return s.GetMessagesByConversationFiltered(conversationID, beforeTS, limit, MessageFilter{})
+}
+
+// GetMessagesByConversationFiltered is GetMessagesByConversationBefore
+// restricted to messages matching f.
+func (s *Store) GetMessagesByConversationFiltered(conversationID string, beforeTS int64, limit int, f MessageFilter) ([]*Message, error) {
+	conditions := append([]string{"conversation_id = ?"}, f.conditions()...)
+	args := []any{conversationID}
+	if beforeTS > 0 {
+		conditions = append(conditions, "timestamp_ms < ?")
+		args = append(args, beforeTS)
+	}
+	args = append(args, limit)
 	rows, err := s.db.Query(`
-		SELECT message_id, conversation_id, sender_name, sender_number, body, timestamp_ms, status, is_from_me, media_id, mime_type, decryption_key, reactions, reply_to_id
+		SELECT `+messageColumns+`
 		FROM messages
-		WHERE conversation_id = ?
+		WHERE `+strings.Join(conditions, " AND ")+`
 		ORDER BY timestamp_ms DESC
 		LIMIT ?
-	`, conversationID, limit)
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanMessages(rows)
+}
+
+// eachMessagePageSize is how many rows EachConversationMessage reads per query.
+const eachMessagePageSize = 500
+
+// EachConversationMessage calls fn for every stored message in a
+// conversation, oldest first, stopping at the first error fn returns. Rows
+// are read a page at a time so the connection isn't held while fn runs.
+func (s *Store) EachConversationMessage(conversationID string, fn func(*Message) error) error {
+	var afterTS int64 = -1 << 63
+	afterID := ""
+	for {
+		rows, err := s.db.Query(`
+			SELECT `+messageColumns+`
+			FROM messages
+			WHERE conversation_id = ? AND (timestamp_ms, message_id) > (?, ?)
+			ORDER BY timestamp_ms ASC, message_id ASC
+			LIMIT ?
+		`, conversationID, afterTS, afterID, eachMessagePageSize)
+		if err != nil {
+			return err
+		}
+		page, err := scanMessages(rows)
+		rows.Close()
+		if err != nil {
+			return err
+		}
+		for _, m := range page {
+			if err := fn(m); err != nil {
+				return err
+			}
+		}
+		if len(page) < eachMessagePageSize {
+			return nil
+		}
+		last := page[len(page)-1]
+		afterTS, afterID = last.TimestampMS, last.MessageID
+	}
+}
+
+// OldestMessage returns the oldest stored server message in a conversation,
+// ignoring tmp_ placeholders and messages with unknown times. It returns
+// nil if there is none.
+func (s *Store) OldestMessage(convID string) (*Message, error) {
+	row := s.db.QueryRow(`
+		SELECT `+messageColumns+`
+		FROM messages
+		WHERE conversation_id = ? AND timestamp_ms > 0 AND message_id NOT LIKE 'tmp_%'
+		ORDER BY timestamp_ms ASC
+		LIMIT 1
+	`, convID)
+	m, err := scanMessage(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return m, err
+}
+
+// GetMessagesAfterID returns up to limit messages in convID that come
+// strictly after the anchor message, oldest first. Messages sharing the
+// anchor's timestamp are ordered by ID. It returns ErrMessageNotFound if the
+// anchor isn't in the conversation.
+func (s *Store) GetMessagesAfterID(convID, afterID string, limit int) ([]*Message, error) {
+	var anchorTS int64
+	err := s.db.QueryRow(`SELECT timestamp_ms FROM messages WHERE message_id = ? AND conversation_id = ?`,
+		afterID, convID).Scan(&anchorTS)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.db.Query(`
+		SELECT `+messageColumns+`
+		FROM messages
+		WHERE conversation_id = ?
+			AND (timestamp_ms > ? OR (timestamp_ms = ? AND message_id > ?))
+		ORDER BY timestamp_ms ASC, message_id ASC
+		LIMIT ?
+	`, convID, anchorTS, anchorTS, afterID, limit)
 	if err != nil {
 		return nil, err
 	}
@@ -42,23 +225,29 @@ func (s *Store) GetMessagesByConversation(conversationID string, limit int) ([]*
 }
 
 func (s *Store) GetMessages(phoneNumber string, afterMS, beforeMS int64, limit int) ([]*Message, error) {
-	var conditions []string
+	return s.GetMessagesFiltered(phoneNumber, afterMS, beforeMS, limit, MessageFilter{})
+}
+
+// GetMessagesFiltered is GetMessages restricted to messages matching f.
+func (s *Store) GetMessagesFiltered(phoneNumber string, afterMS, beforeMS int64, limit int, f MessageFilter) ([]*Message, error) {
+	conditions := f.conditions()
 	var args []any
 
 	if phoneNumber != "" {
-		conditions = append(conditions, "sender_number = ?")
-		args = append(args, phoneNumber)
+		conditions = append(conditions, "sender_e164 = ?")
+		args = append(args, s.NormalizeNumber(phoneNumber))
 	}
 	if afterMS > 0 {
 		conditions = append(conditions, "timestamp_ms >= ?")
 		args = append(args, afterMS)
 	}
 	if beforeMS > 0 {
-		conditions = append(conditions, "timestamp_ms <= ?")
+		// Messages with an unknown (zero) time can't be placed before anything.
+		conditions = append(conditions, "timestamp_ms > 0", "timestamp_ms <= ?")
 		args = append(args, beforeMS)
 	}
 
-	query := `SELECT message_id, conversation_id, sender_name, sender_number, body, timestamp_ms, status, is_from_me, media_id, mime_type, decryption_key, reactions, reply_to_id FROM messages`
+	query := `SELECT ` + messageColumns + ` FROM messages`
 	if len(conditions) > 0 {
 		query += " WHERE " + strings.Join(conditions, " AND ")
 	}
@@ -73,7 +262,86 @@ func (s *Store) GetMessages(phoneNumber string, afterMS, beforeMS int64, limit i
 	return scanMessages(rows)
 }
 
+// SearchMessages finds messages whose body matches query, optionally only
+// from phoneNumber, newest first. Free-text queries use the FTS index, where
+// each word matches as a prefix. Queries with no indexable words, queries
+// the index can't match (e.g. the middle of a word), and builds without
+// FTS5 fall back to a LIKE substring scan. Use SearchMessagesOrdered for
+// relevance ranking.
 func (s *Store) SearchMessages(query, phoneNumber string, limit int) ([]*Message, error) {
+	return s.SearchMessagesOrdered(query, phoneNumber, limit, SearchNewest)
+}
+
+// SearchOrder is how SearchMessagesOrdered sorts matches.
+type SearchOrder string
+
+const (
+	// SearchRelevance puts the best full-text matches first, newest first
+	// among equals. LIKE fallback results have no score and come newest first.
+	SearchRelevance SearchOrder = "relevance"
+	SearchNewest    SearchOrder = "newest"
+	SearchOldest    SearchOrder = "oldest"
+)
+
+// ParseSearchOrder parses an order name. Empty means SearchNewest.
+func ParseSearchOrder(s string) (SearchOrder, error) {
+	switch o := SearchOrder(s); o {
+	case "":
+		return SearchNewest, nil
+	case SearchRelevance, SearchNewest, SearchOldest:
+		return o, nil
+	default:
+		return "", fmt.Errorf("unknown order %q (want relevance, newest or oldest)", s)
+	}
+}
+
+// orderBy returns the ORDER BY terms for o. scored is whether the query
+// selects a bm25 score column.
+func (o SearchOrder) orderBy(scored bool) string {
+	switch {
+	case o == SearchOldest:
+		return "timestamp_ms ASC"
+	case o == SearchRelevance && scored:
+		return "score, timestamp_ms DESC"
+	default:
+		return "timestamp_ms DESC"
+	}
+}
+
+// SearchMessagesOrdered is SearchMessages with the matches sorted by order.
+func (s *Store) SearchMessagesOrdered(query, phoneNumber string, limit int, order SearchOrder) ([]*Message, error) {
+	if match, ok := ftsQuery(query); ok && s.fts {
+		msgs, err := s.searchFTS(match, phoneNumber, limit, order)
+		if err != nil || len(msgs) > 0 {
+			return msgs, err
+		}
+	}
+	return s.searchLike(query, phoneNumber, limit, order)
+}
+
+func (s *Store) searchFTS(match, phoneNumber string, limit int, order SearchOrder) ([]*Message, error) {
+	q := `SELECT ` + messageColumns + ` FROM messages
+		JOIN (
+			SELECT rowid AS fts_rowid, bm25(messages_fts) AS score
+			FROM messages_fts WHERE messages_fts MATCH ?
+		) ON messages.rowid = fts_rowid`
+	args := []any{match}
+	if phoneNumber != "" {
+		q += " WHERE sender_e164 = ?"
+		args = append(args, s.NormalizeNumber(phoneNumber))
+	}
+	q += " ORDER BY " + order.orderBy(true) + " LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := s.db.Query(q, args...)
+	if err != nil {
+		return nil, fmt.Errorf("full-text search: %w", err)
+	}
+	defer rows.Close()
+	return scanMessages(rows)
+}
+
+func (s *Store) searchLike(query, phoneNumber string, limit int, order SearchOrder) ([]*Message, error) {
 	var conditions []string
 	var args []any
 
@@ -81,15 +349,15 @@ func (s *Store) SearchMessages(query, phoneNumber string, limit int) ([]*Message
 	args = append(args, "%"+query+"%")
 
 	if phoneNumber != "" {
-		conditions = append(conditions, "sender_number = ?")
-		args = append(args, phoneNumber)
+		conditions = append(conditions, "sender_e164 = ?")
+		args = append(args, s.NormalizeNumber(phoneNumber))
 	}
 
-	q := `SELECT message_id, conversation_id, sender_name, sender_number, body, timestamp_ms, status, is_from_me, media_id, mime_type, decryption_key, reactions, reply_to_id FROM messages`
+	q := `SELECT ` + messageColumns + ` FROM messages`
 	if len(conditions) > 0 {
 		q += " WHERE " + strings.Join(conditions, " AND ")
 	}
-	q += " ORDER BY timestamp_ms DESC LIMIT ?"
+	q += " ORDER BY " + order.orderBy(false) + " LIMIT ?"
 	args = append(args, limit)
 
 	rows, err := s.db.Query(q, args...)
@@ -100,13 +368,111 @@ func (s *Store) SearchMessages(query, phoneNumber string, limit int) ([]*Message
 	return scanMessages(rows)
 }
 
+// SearchScope restricts CountSearchMatches to a sender, a conversation and a
+// time range (inclusive, in ms). Zero fields don't restrict.
+type SearchScope struct {
+	PhoneNumber    string
+	ConversationID string
+	AfterMS        int64
+	BeforeMS       int64
+}
+
+func (sc SearchScope) conditions(s *Store) ([]string, []any) {
+	var conditions []string
+	var args []any
+	if sc.PhoneNumber != "" {
+		conditions = append(conditions, "sender_e164 = ?")
+		args = append(args, s.NormalizeNumber(sc.PhoneNumber))
+	}
+	if sc.ConversationID != "" {
+		conditions = append(conditions, "conversation_id = ?")
+		args = append(args, sc.ConversationID)
+	}
+	if sc.AfterMS > 0 {
+		conditions = append(conditions, "timestamp_ms >= ?")
+		args = append(args, sc.AfterMS)
+	}
+	if sc.BeforeMS > 0 {
+		conditions = append(conditions, "timestamp_ms > 0", "timestamp_ms <= ?")
+		args = append(args, sc.BeforeMS)
+	}
+	return conditions, args
+}
+
+// CountSearchMatches counts the messages in scope that SearchMessages would
+// match for query, without loading them. Like SearchMessages it uses the FTS
+// index and falls back to a LIKE scan when the index finds nothing.
+func (s *Store) CountSearchMatches(query string, scope SearchScope) (int, error) {
+	conditions, args := scope.conditions(s)
+	if match, ok := ftsQuery(query); ok && s.fts {
+		n, err := s.countMessages(
+			append([]string{"rowid IN (SELECT rowid FROM messages_fts WHERE messages_fts MATCH ?)"}, conditions...),
+			append([]any{match}, args...))
+		if err != nil || n > 0 {
+			return n, err
+		}
+	}
+	return s.countMessages(append([]string{"body LIKE ?"}, conditions...), append([]any{"%" + query + "%"}, args...))
+}
+
+func (s *Store) countMessages(conditions []string, args []any) (int, error) {
+	var n int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM messages WHERE `+strings.Join(conditions, " AND "), args...).Scan(&n)
+	return n, err
+}
+
+// maxRegexScan bounds how many candidate rows SearchMessagesRegex examines,
+// so a rare pattern can't force a full scan of a huge database.
+const maxRegexScan = 50000
+
+// SearchMessagesRegex returns up to limit messages whose body matches re,
+// most recent first, optionally only from phoneNumber. When the pattern has
+// a literal prefix, only bodies containing it are considered. At most
+// maxRegexScan of the most recent candidates are examined.
+func (s *Store) SearchMessagesRegex(re *regexp.Regexp, phoneNumber string, limit int) ([]*Message, error) {
+	conditions := []string{"body != ''"}
+	var args []any
+	if prefix, _ := re.LiteralPrefix(); prefix != "" {
+		conditions = append(conditions, `body LIKE ? ESCAPE '\'`)
+		args = append(args, "%"+escapeLike(prefix)+"%")
+	}
+	if phoneNumber != "" {
+		conditions = append(conditions, "sender_e164 = ?")
+		args = append(args, s.NormalizeNumber(phoneNumber))
+	}
+	args = append(args, maxRegexScan)
+
+	rows, err := s.db.Query(`SELECT `+messageColumns+` FROM messages
+		WHERE `+strings.Join(conditions, " AND ")+`
+		ORDER BY timestamp_ms DESC LIMIT ?`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var msgs []*Message
+	for rows.Next() && len(msgs) < limit {
+		m, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		if re.MatchString(m.Body) {
+			msgs = append(msgs, m)
+		}
+	}
+	return msgs, rows.Err()
+}
+
+// escapeLike escapes LIKE wildcards in s for use with ESCAPE '\'.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
 func (s *Store) GetMessageByID(messageID string) (*Message, error) {
 	row := s.db.QueryRow(`
-		SELECT message_id, conversation_id, sender_name, sender_number, body, timestamp_ms, status, is_from_me, media_id, mime_type, decryption_key, reactions, reply_to_id
+		SELECT `+messageColumns+`
 		FROM messages WHERE message_id = ?
 	`, messageID)
-	m := &Message{}
-	err := row.Scan(&m.MessageID, &m.ConversationID, &m.SenderName, &m.SenderNumber, &m.Body, &m.TimestampMS, &m.Status, &m.IsFromMe, &m.MediaID, &m.MimeType, &m.DecryptionKey, &m.Reactions, &m.ReplyToID)
+	m, err := scanMessage(row)
 	if err != nil {
 		if err.Error() == "sql: no rows in result set" {
 			return nil, nil
@@ -116,17 +482,153 @@ func (s *Store) GetMessageByID(messageID string) (*Message, error) {
 	return m, nil
 }
 
-// DeleteTmpMessages removes locally-created tmp_ messages for a conversation.
-// Called when the server echo arrives with a real message ID.
+// GetMessageByTmpID returns the message sent under tmpID: the real message
+// once its echo has been reconciled, else the tmp_ placeholder itself. It
+// returns nil if neither is stored.
+func (s *Store) GetMessageByTmpID(tmpID string) (*Message, error) {
+	row := s.db.QueryRow(`
+		SELECT `+messageColumns+`
+		FROM messages
+		WHERE tmp_id = ? OR message_id = ?
+		ORDER BY message_id = ? ASC
+		LIMIT 1
+	`, tmpID, tmpID, tmpID)
+	m, err := scanMessage(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return m, err
+}
+
+// UpdateMessageStatus sets the status of an existing message. It is a no-op
+// if the message doesn't exist.
+func (s *Store) UpdateMessageStatus(messageID, status string) error {
+	_, err := s.db.Exec(`UPDATE messages SET status = ? WHERE message_id = ?`, status, messageID)
+	return err
+}
+
+// DeleteMessage removes a message, its search index entry, and its status
+// history. It returns sql.ErrNoRows if the message doesn't exist. Replies
+// that quote the message keep their reply_to_id; use ReplyIDs first to find
+// them.
+func (s *Store) DeleteMessage(messageID string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if s.fts {
+		if _, err := tx.Exec(`
+			DELETE FROM messages_fts WHERE rowid IN (
+				SELECT rowid FROM messages WHERE message_id = ?
+			)`, messageID); err != nil {
+			return fmt.Errorf("update search index: %w", err)
+		}
+	}
+	if _, err := tx.Exec(`DELETE FROM message_status_events WHERE message_id = ?`, messageID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM message_edits WHERE message_id = ?`, messageID); err != nil {
+		return err
+	}
+	result, err := tx.Exec(`DELETE FROM messages WHERE message_id = ?`, messageID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+	return tx.Commit()
+}
+
+// MarkMessageDeleted flags a message as unsent by its sender and clears its
+// text and attachment, keeping the row so replies and the timeline still
+// make sense. Its edit history goes too. Returns sql.ErrNoRows if the
+// message doesn't exist.
+func (s *Store) MarkMessageDeleted(messageID string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if s.fts {
+		if _, err := tx.Exec(`
+			DELETE FROM messages_fts WHERE rowid IN (
+				SELECT rowid FROM messages WHERE message_id = ?
+			)`, messageID); err != nil {
+			return fmt.Errorf("update search index: %w", err)
+		}
+	}
+	result, err := tx.Exec(`
+		UPDATE messages
+		SET deleted = 1, body = '', media_id = '', mime_type = '', decryption_key = '',
+			media_size = 0, media_cloud_url = '', raw_payload = '', has_link = 0,
+			location = '', shared_contact = '', subtype = '',
+			thumbnail_media_id = '', thumbnail_decryption_key = '', attachment_count = 0
+		WHERE message_id = ?
+	`, messageID)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM message_edits WHERE message_id = ?`, messageID); err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+	return tx.Commit()
+}
+
+// ReplyIDs returns the IDs of messages that reply to messageID.
+func (s *Store) ReplyIDs(messageID string) ([]string, error) {
+	rows, err := s.db.Query(`SELECT message_id FROM messages WHERE reply_to_id = ? ORDER BY timestamp_ms`, messageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// DeleteTmpMessages removes every locally-created tmp_ message in a
+// conversation, including sends still in flight.
 func (s *Store) DeleteTmpMessages(conversationID string) (int64, error) {
-	result, err := s.db.Exec(
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	if s.fts {
+		if _, err := tx.Exec(`
+			DELETE FROM messages_fts WHERE rowid IN (
+				SELECT rowid FROM messages WHERE conversation_id = ? AND message_id LIKE 'tmp_%'
+			)`, conversationID); err != nil {
+			return 0, fmt.Errorf("update search index: %w", err)
+		}
+	}
+	result, err := tx.Exec(
 		`DELETE FROM messages WHERE conversation_id = ? AND message_id LIKE 'tmp_%'`,
 		conversationID,
 	)
 	if err != nil {
 		return 0, err
 	}
-	return result.RowsAffected()
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return n, tx.Commit()
 }
 
 func scanMessages(rows interface {
@@ -136,11 +638,183 @@ func scanMessages(rows interface {
 }) ([]*Message, error) {
 	var msgs []*Message
 	for rows.Next() {
-		m := &Message{}
-		if err := rows.Scan(&m.MessageID, &m.ConversationID, &m.SenderName, &m.SenderNumber, &m.Body, &m.TimestampMS, &m.Status, &m.IsFromMe, &m.MediaID, &m.MimeType, &m.DecryptionKey, &m.Reactions, &m.ReplyToID); err != nil {
+		m, err := scanMessage(rows)
+		if err != nil {
 			return nil, err
 		}
 		msgs = append(msgs, m)
 	}
 	return msgs, rows.Err()
 }
+
+// scanMessage scans a single row selected with messageColumns.
+func scanMessage(row interface{ Scan(...any) error }) (*Message, error) {
+	m := &Message{}
+	var location, contact string
+	err := row.Scan(&m.MessageID, &m.ConversationID, &m.SenderName, &m.SenderNumber, &m.Body, &m.TimestampMS, &m.Status, &m.IsFromMe, &m.MediaID, &m.MimeType, &m.DecryptionKey, &m.Reactions, &m.ReplyToID, &m.RawPayload, &m.Pinned, &m.MediaSize, &m.Starred, &m.HasLink, &m.MediaCloudURL, &m.Deleted, &m.TmpID, &m.EditedAtMS, &location, &contact, &m.Subtype, &m.ThumbnailMediaID, &m.ThumbnailDecryptionKey, &m.AttachmentCount)
+	if err == nil {
+		m.Location = decodeJSON[Location](location)
+		m.SharedContact = decodeJSON[SharedContact](contact)
+	}
+	return m, err
+}
+
+// encodeJSON returns v as stored in a JSON column, or "" for nil.
+func encodeJSON[T any](v *T) string {
+	if v == nil {
+		return ""
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+// decodeJSON parses a JSON column, returning nil if it's empty or invalid.
+func decodeJSON[T any](s string) *T {
+	if s == "" {
+		return nil
+	}
+	v := new(T)
+	if json.Unmarshal([]byte(s), v) != nil {
+		return nil
+	}
+	return v
+}
+
+// SetMessagePinned pins or unpins a message within its conversation.
+// Returns sql.ErrNoRows if the message doesn't exist.
+func (s *Store) SetMessagePinned(messageID string, pinned bool) error {
+	res, err := s.db.Exec(`UPDATE messages SET pinned = ? WHERE message_id = ?`, pinned, messageID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// SetMessageMediaCloudURL records where a copy of the message's attachment
+// was uploaded, for serving it once Google's copy has expired.
+// Returns sql.ErrNoRows if the message doesn't exist.
+func (s *Store) SetMessageMediaCloudURL(messageID, url string) error {
+	res, err := s.db.Exec(`UPDATE messages SET media_cloud_url = ? WHERE message_id = ?`, url, messageID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// ListPinnedMessages returns the pinned messages in a conversation, oldest first.
+func (s *Store) ListPinnedMessages(conversationID string) ([]*Message, error) {
+	rows, err := s.db.Query(`
+		SELECT `+messageColumns+`
+		FROM messages
+		WHERE conversation_id = ? AND pinned = 1
+		ORDER BY timestamp_ms
+	`, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanMessages(rows)
+}
+
+// SetConversationMessagesStatus sets status on messages in a conversation
+// whose status is empty or "unknown", or on every message if force is set.
+// It returns how many messages were changed.
+func (s *Store) SetConversationMessagesStatus(convID, status string, force bool) (int64, error) {
+	query := `UPDATE messages SET status = ? WHERE conversation_id = ?`
+	if !force {
+		query += ` AND status IN ('', 'unknown')`
+	}
+	res, err := s.db.Exec(query, status, convID)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// SetMessageStarred stars or unstars a message.
+// Returns sql.ErrNoRows if the message doesn't exist.
+func (s *Store) SetMessageStarred(messageID string, starred bool) error {
+	res, err := s.db.Exec(`UPDATE messages SET starred = ? WHERE message_id = ?`, starred, messageID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// ListStarredMessages returns starred messages across all conversations,
+// newest first.
+func (s *Store) ListStarredMessages(limit int) ([]*Message, error) {
+	rows, err := s.db.Query(`
+		SELECT `+messageColumns+`
+		FROM messages
+		WHERE starred = 1
+		ORDER BY timestamp_ms DESC
+		LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanMessages(rows)
+}
+
+// FixFutureTimestamps clamps stored message timestamps that are further than
+// maxClockSkew past now, returning how many rows were changed.
+func (s *Store) FixFutureTimestamps(now time.Time) (int64, error) {
+	res, err := s.db.Exec(`UPDATE messages SET timestamp_ms = ? WHERE timestamp_ms > ?`,
+		now.UnixMilli(), now.Add(maxClockSkew).UnixMilli())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// ConversationMediaUsage returns the number of media messages in a
+// conversation and the sum of their sizes in bytes.
+func (s *Store) ConversationMediaUsage(convID string) (count int, bytes int64, err error) {
+	err = s.db.QueryRow(`
+		SELECT COUNT(*), COALESCE(SUM(media_size), 0)
+		FROM messages
+		WHERE conversation_id = ? AND media_id != ''
+	`, convID).Scan(&count, &bytes)
+	return count, bytes, err
+}
+
+// ListMediaUsage returns media usage for every conversation with media,
+// largest first.
+func (s *Store) ListMediaUsage() ([]*MediaUsage, error) {
+	rows, err := s.db.Query(`
+		SELECT m.conversation_id, COALESCE(c.name, ''), COUNT(*), COALESCE(SUM(m.media_size), 0) AS total
+		FROM messages m
+		LEFT JOIN conversations c ON c.conversation_id = m.conversation_id
+		WHERE m.media_id != ''
+		GROUP BY m.conversation_id
+		ORDER BY total DESC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var usage []*MediaUsage
+	for rows.Next() {
+		u := &MediaUsage{}
+		if err := rows.Scan(&u.ConversationID, &u.Name, &u.MediaCount, &u.TotalBytes); err != nil {
+			return nil, err
+		}
+		usage = append(usage, u)
+	}
+	return usage, rows.Err()
+}
@@ -1,8 +1,14 @@
 package db
 
 import (
+	"database/sql"
+	"errors"
 	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
 )
 
 // newTestStore creates an in-memory Store for testing.
@@ -305,6 +311,161 @@ func TestGetMessagesByConversation(t *testing.T) {
 	})
 }
 
+func TestEachConversationMessage(t *testing.T) {
+	store := newTestStore(t)
+
+	// More than one page, with shared timestamps across the page boundary.
+	n := eachMessagePageSize*2 + 3
+	for i := 0; i < n; i++ {
+		store.UpsertMessage(&Message{
+			MessageID:      fmt.Sprintf("m%04d", i),
+			ConversationID: "conv-1",
+			TimestampMS:    int64(1000 + i/10),
+		})
+	}
+	store.UpsertMessage(&Message{MessageID: "other", ConversationID: "conv-2", TimestampMS: 1})
+
+	var ids []string
+	err := store.EachConversationMessage("conv-1", func(m *Message) error {
+		ids = append(ids, m.MessageID)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("each: %v", err)
+	}
+	if len(ids) != n {
+		t.Fatalf("visited %d messages, want %d", len(ids), n)
+	}
+	for i, id := range ids {
+		if want := fmt.Sprintf("m%04d", i); id != want {
+			t.Fatalf("ids[%d] = %s, want %s (oldest first, no repeats)", i, id, want)
+		}
+	}
+
+	stop := errors.New("stop")
+	calls := 0
+	err = store.EachConversationMessage("conv-1", func(m *Message) error {
+		calls++
+		return stop
+	})
+	if err != stop || calls != 1 {
+		t.Errorf("err = %v after %d calls, want stop after 1", err, calls)
+	}
+}
+
+func TestUpsertMessageRecordsEdits(t *testing.T) {
+	store := newTestStore(t)
+	store.UpsertMessage(&Message{MessageID: "m1", ConversationID: "c1", Body: "see you at 7", Status: "INCOMING_COMPLETE"})
+
+	// Status-only updates re-upsert the same body and aren't edits.
+	store.UpsertMessage(&Message{MessageID: "m1", ConversationID: "c1", Body: "see you at 7", Status: "INCOMING_DISPLAYED"})
+	if edits, _ := store.GetMessageEdits("m1"); len(edits) != 0 {
+		t.Fatalf("edits after status update = %+v, want none", edits)
+	}
+	if m, _ := store.GetMessageByID("m1"); m.EditedAtMS != 0 {
+		t.Errorf("EditedAtMS = %d after status update, want 0", m.EditedAtMS)
+	}
+
+	store.UpsertMessage(&Message{MessageID: "m1", ConversationID: "c1", Body: "see you at 8"})
+	store.UpsertMessage(&Message{MessageID: "m1", ConversationID: "c1", Body: "see you at 9"})
+	edits, err := store.GetMessageEdits("m1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(edits) != 2 || edits[0].Body != "see you at 7" || edits[1].Body != "see you at 8" {
+		t.Fatalf("edits = %+v, want the two earlier bodies oldest first", edits)
+	}
+	m, _ := store.GetMessageByID("m1")
+	if m.Body != "see you at 9" || m.EditedAtMS == 0 {
+		t.Errorf("message = %+v, want latest body and EditedAtMS set", m)
+	}
+
+	// A later status update keeps the edit time.
+	store.UpsertMessage(&Message{MessageID: "m1", ConversationID: "c1", Body: "see you at 9", Status: "INCOMING_DISPLAYED"})
+	if got, _ := store.GetMessageByID("m1"); got.EditedAtMS != m.EditedAtMS {
+		t.Errorf("EditedAtMS = %d after status update, want %d kept", got.EditedAtMS, m.EditedAtMS)
+	}
+
+	if err := store.DeleteMessage("m1"); err != nil {
+		t.Fatal(err)
+	}
+	if edits, _ := store.GetMessageEdits("m1"); len(edits) != 0 {
+		t.Errorf("edits kept after delete: %+v", edits)
+	}
+}
+
+func TestUpsertMessageLocation(t *testing.T) {
+	store := newTestStore(t)
+	loc := &Location{Latitude: 37.7955, Longitude: -122.3937, Name: "Ferry Building"}
+	store.UpsertMessage(&Message{MessageID: "m1", ConversationID: "c1", Location: loc})
+	store.UpsertMessage(&Message{MessageID: "m2", ConversationID: "c1", Body: "hi"})
+
+	m, err := store.GetMessageByID("m1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Location == nil || *m.Location != *loc {
+		t.Errorf("Location = %+v, want %+v", m.Location, loc)
+	}
+	if m, _ := store.GetMessageByID("m2"); m.Location != nil {
+		t.Errorf("Location = %+v for a text message, want nil", m.Location)
+	}
+
+	if err := store.MarkMessageDeleted("m1"); err != nil {
+		t.Fatal(err)
+	}
+	if m, _ := store.GetMessageByID("m1"); m.Location != nil {
+		t.Errorf("Location = %+v after deletion, want cleared", m.Location)
+	}
+}
+
+func TestUpsertMessageSubtype(t *testing.T) {
+	store := newTestStore(t)
+	store.UpsertMessage(&Message{MessageID: "m1", ConversationID: "c1", MediaID: "media-1", MimeType: "image/gif", Subtype: SubtypeGIF})
+	if m, _ := store.GetMessageByID("m1"); m.Subtype != SubtypeGIF {
+		t.Errorf("Subtype = %q, want %q", m.Subtype, SubtypeGIF)
+	}
+	if err := store.MarkMessageDeleted("m1"); err != nil {
+		t.Fatal(err)
+	}
+	if m, _ := store.GetMessageByID("m1"); m.Subtype != "" {
+		t.Errorf("Subtype = %q after deletion, want cleared", m.Subtype)
+	}
+}
+
+func TestUpsertMessageSharedContact(t *testing.T) {
+	store := newTestStore(t)
+	card := &SharedContact{Name: "Jane Doe", Numbers: []string{"+15550100200", "+15550100300"}}
+	store.UpsertMessage(&Message{MessageID: "m1", ConversationID: "c1", Body: card.Summary(), SharedContact: card})
+
+	m, err := store.GetMessageByID("m1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.SharedContact == nil || m.SharedContact.Name != "Jane Doe" || len(m.SharedContact.Numbers) != 2 {
+		t.Errorf("SharedContact = %+v, want %+v", m.SharedContact, card)
+	}
+	if msgs, _ := store.SearchMessages("Jane", "", 10); len(msgs) != 1 {
+		t.Errorf("search for the contact's name found %d messages, want 1", len(msgs))
+	}
+}
+
+func TestMarkMessageDeleted(t *testing.T) {
+	store := newTestStore(t)
+	store.UpsertMessage(&Message{MessageID: "m1", ConversationID: "c1", Body: "see https://example.com", MediaID: "media-1", MimeType: "image/png", DecryptionKey: "01"})
+
+	if err := store.MarkMessageDeleted("m1"); err != nil {
+		t.Fatalf("mark deleted: %v", err)
+	}
+	m, _ := store.GetMessageByID("m1")
+	if !m.Deleted || m.Body != "" || m.MediaID != "" || m.MimeType != "" || m.DecryptionKey != "" || m.HasLink {
+		t.Errorf("message = %+v, want deleted with content cleared", m)
+	}
+	if err := store.MarkMessageDeleted("missing"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("missing message: err = %v, want sql.ErrNoRows", err)
+	}
+}
+
 func TestSearchMessages_Comprehensive(t *testing.T) {
 	store := newTestStore(t)
 
@@ -418,6 +579,218 @@ func TestSearchMessages_Comprehensive(t *testing.T) {
 	})
 }
 
+func TestSearchMessagesOldestFirst(t *testing.T) {
+	store := newTestStore(t)
+	for i, ts := range []int64{3000, 1000, 2000} {
+		store.UpsertMessage(&Message{MessageID: fmt.Sprintf("m%d", i), Body: "order #42", TimestampMS: ts})
+	}
+
+	// "#" has no indexable words, so this runs the LIKE scan.
+	got, err := store.SearchMessagesOrdered("#", "", 2, SearchOldest)
+	if err != nil {
+		t.Fatalf("search: %v", err)
+	}
+	if len(got) != 2 || got[0].MessageID != "m1" || got[1].MessageID != "m2" {
+		t.Errorf("got %v, want the two earliest [m1 m2]", messageIDs(got))
+	}
+
+	for _, s := range []string{"", "relevance", "newest", "oldest"} {
+		if _, err := ParseSearchOrder(s); err != nil {
+			t.Errorf("ParseSearchOrder(%q): %v", s, err)
+		}
+	}
+	if o, _ := ParseSearchOrder(""); o != SearchNewest {
+		t.Errorf("default order = %q, want newest", o)
+	}
+	if _, err := ParseSearchOrder("random"); err == nil {
+		t.Error("expected error for unknown order")
+	}
+}
+
+func TestSearchMessagesFTS(t *testing.T) {
+	store := newTestStore(t)
+	if !store.fts {
+		t.Skip("FTS5 not available in this SQLite build")
+	}
+	msgs := []Message{
+		{MessageID: "f1", SenderNumber: "+1111", Body: "dinner", TimestampMS: 3000},
+		{MessageID: "f2", SenderNumber: "+2222", Body: "the plan for dinner is pizza at the place on main street tonight", TimestampMS: 4000},
+		{MessageID: "f3", SenderNumber: "+1111", Body: "Dinner plans? dinner at eight", TimestampMS: 1000},
+	}
+	for i := range msgs {
+		store.UpsertMessage(&msgs[i])
+	}
+
+	t.Run("ranks by relevance over recency", func(t *testing.T) {
+		got, err := store.SearchMessagesOrdered("dinner", "", 10, SearchRelevance)
+		if err != nil {
+			t.Fatalf("search: %v", err)
+		}
+		if len(got) != 3 || got[0].MessageID != "f1" || got[2].MessageID != "f2" {
+			t.Errorf("order = %v, want f1 first and the long f2 last", messageIDs(got))
+		}
+	})
+
+	t.Run("newest first by default", func(t *testing.T) {
+		got, err := store.SearchMessages("dinner", "", 10)
+		if err != nil {
+			t.Fatalf("search: %v", err)
+		}
+		if fmt.Sprint(messageIDs(got)) != "[f2 f1 f3]" {
+			t.Errorf("order = %v, want [f2 f1 f3]", messageIDs(got))
+		}
+	})
+
+	t.Run("ordered by time", func(t *testing.T) {
+		got, err := store.SearchMessagesOrdered("dinner", "", 2, SearchOldest)
+		if err != nil {
+			t.Fatalf("search: %v", err)
+		}
+		if len(got) != 2 || got[0].MessageID != "f3" || got[1].MessageID != "f1" {
+			t.Errorf("oldest = %v, want [f3 f1]", messageIDs(got))
+		}
+		got, _ = store.SearchMessagesOrdered("dinner", "", 10, SearchNewest)
+		if len(got) != 3 || got[0].MessageID != "f2" || got[2].MessageID != "f3" {
+			t.Errorf("newest = %v, want f2 first and f3 last", messageIDs(got))
+		}
+	})
+
+	t.Run("phone filter", func(t *testing.T) {
+		got, _ := store.SearchMessages("dinner", "+2222", 10)
+		if len(got) != 1 || got[0].MessageID != "f2" {
+			t.Errorf("got %v, want [f2]", messageIDs(got))
+		}
+	})
+
+	t.Run("index follows body edits", func(t *testing.T) {
+		store.UpsertMessage(&Message{MessageID: "f1", SenderNumber: "+1111", Body: "lunch", TimestampMS: 3000})
+		got, _ := store.SearchMessages("lunch", "", 10)
+		if len(got) != 1 || got[0].MessageID != "f1" {
+			t.Errorf("lunch: got %v, want [f1]", messageIDs(got))
+		}
+		if got, _ := store.SearchMessages("dinner", "", 10); len(got) != 2 {
+			t.Errorf("dinner after edit: got %v, want 2 results", messageIDs(got))
+		}
+	})
+
+	t.Run("tmp deletes leave the index", func(t *testing.T) {
+		store.UpsertMessage(&Message{MessageID: "tmp_1", ConversationID: "c1", Body: "pending kumquat"})
+		store.DeleteTmpMessages("c1")
+		var n int
+		store.db.QueryRow(`SELECT COUNT(*) FROM messages_fts WHERE messages_fts MATCH 'kumquat'`).Scan(&n)
+		if n != 0 {
+			t.Errorf("index still has %d rows for deleted tmp message", n)
+		}
+	})
+
+	t.Run("falls back to LIKE without FTS", func(t *testing.T) {
+		store.fts = false
+		defer func() { store.fts = true }()
+		got, err := store.SearchMessages("inne", "", 10)
+		if err != nil {
+			t.Fatalf("search: %v", err)
+		}
+		if len(got) != 2 {
+			t.Errorf("got %v, want 2 LIKE matches", messageIDs(got))
+		}
+	})
+}
+
+func TestGetMessagesAfterID(t *testing.T) {
+	store := newTestStore(t)
+	for _, m := range []*Message{
+		{MessageID: "a", ConversationID: "c1", TimestampMS: 1000},
+		{MessageID: "b", ConversationID: "c1", TimestampMS: 2000},
+		{MessageID: "c", ConversationID: "c1", TimestampMS: 2000}, // same time as the anchor
+		{MessageID: "d", ConversationID: "c1", TimestampMS: 3000},
+		{MessageID: "e", ConversationID: "c2", TimestampMS: 4000},
+	} {
+		store.UpsertMessage(m)
+	}
+
+	got, err := store.GetMessagesAfterID("c1", "b", 10)
+	if err != nil {
+		t.Fatalf("after b: %v", err)
+	}
+	if ids := messageIDs(got); len(ids) != 2 || ids[0] != "c" || ids[1] != "d" {
+		t.Errorf("after b = %v, want [c d]", ids)
+	}
+
+	got, _ = store.GetMessagesAfterID("c1", "a", 1)
+	if ids := messageIDs(got); len(ids) != 1 || ids[0] != "b" {
+		t.Errorf("after a, limit 1 = %v, want [b]", ids)
+	}
+
+	if got, _ := store.GetMessagesAfterID("c1", "d", 10); len(got) != 0 {
+		t.Errorf("after newest = %v, want none", messageIDs(got))
+	}
+
+	if _, err := store.GetMessagesAfterID("c1", "missing", 10); !errors.Is(err, ErrMessageNotFound) {
+		t.Errorf("missing anchor: err = %v, want ErrMessageNotFound", err)
+	}
+	if _, err := store.GetMessagesAfterID("c1", "e", 10); !errors.Is(err, ErrMessageNotFound) {
+		t.Errorf("anchor in other conversation: err = %v, want ErrMessageNotFound", err)
+	}
+}
+
+func TestDeleteMessage(t *testing.T) {
+	store := newTestStore(t)
+	store.UpsertMessage(&Message{MessageID: "m1", ConversationID: "c1", Body: "original walnut", TimestampMS: 1000})
+	store.UpsertMessage(&Message{MessageID: "m2", ConversationID: "c1", Body: "reply", ReplyToID: "m1", TimestampMS: 2000})
+
+	replies, err := store.ReplyIDs("m1")
+	if err != nil {
+		t.Fatalf("reply ids: %v", err)
+	}
+	if len(replies) != 1 || replies[0] != "m2" {
+		t.Errorf("replies = %v, want [m2]", replies)
+	}
+
+	if err := store.DeleteMessage("m1"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if m, _ := store.GetMessageByID("m1"); m != nil {
+		t.Error("m1 still present after delete")
+	}
+	if got, _ := store.SearchMessages("walnut", "", 10); len(got) != 0 {
+		t.Errorf("deleted message still searchable: %v", messageIDs(got))
+	}
+	if m, _ := store.GetMessageByID("m2"); m == nil || m.ReplyToID != "m1" {
+		t.Errorf("reply should be kept with its reply_to_id, got %+v", m)
+	}
+
+	if err := store.DeleteMessage("m1"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("second delete: err = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func TestFTSBackfillOnStartup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fts.db")
+	store, err := New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !store.fts {
+		store.Close()
+		t.Skip("FTS5 not available in this SQLite build")
+	}
+	// Simulate rows written before the index existed.
+	store.db.Exec(`INSERT INTO messages (message_id, body) VALUES ('old1', 'legacy quince message')`)
+	store.db.Exec(`DROP TABLE messages_fts`)
+	store.Close()
+
+	store, err = New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	var n int
+	store.db.QueryRow(`SELECT COUNT(*) FROM messages_fts WHERE messages_fts MATCH 'quince'`).Scan(&n)
+	if n != 1 {
+		t.Errorf("backfilled index matches = %d, want 1", n)
+	}
+}
+
 func TestGetMessageByID_EdgeCases(t *testing.T) {
 	store := newTestStore(t)
 
@@ -567,3 +940,345 @@ func TestUpsertMessage_EmptyBody(t *testing.T) {
 		t.Errorf("MediaID: got %q, want mid-1", got.MediaID)
 	}
 }
+
+func TestPinnedMessages(t *testing.T) {
+	store := newTestStore(t)
+
+	store.UpsertMessage(&Message{MessageID: "m1", ConversationID: "c1", Body: "first", TimestampMS: 100})
+	store.UpsertMessage(&Message{MessageID: "m2", ConversationID: "c1", Body: "second", TimestampMS: 200})
+	store.UpsertMessage(&Message{MessageID: "m3", ConversationID: "c2", Body: "elsewhere", TimestampMS: 300})
+
+	for _, id := range []string{"m2", "m1", "m3"} {
+		if err := store.SetMessagePinned(id, true); err != nil {
+			t.Fatalf("pin %s: %v", id, err)
+		}
+	}
+
+	// A sync update must not unpin.
+	store.UpsertMessage(&Message{MessageID: "m2", ConversationID: "c1", Body: "second (edited)", TimestampMS: 200})
+
+	got, err := store.ListPinnedMessages("c1")
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if len(got) != 2 || got[0].MessageID != "m1" || got[1].MessageID != "m2" {
+		t.Fatalf("pinned in c1: got %+v", got)
+	}
+	if !got[1].Pinned {
+		t.Error("Pinned flag not scanned")
+	}
+
+	if err := store.SetMessagePinned("m1", false); err != nil {
+		t.Fatalf("unpin: %v", err)
+	}
+	got, _ = store.ListPinnedMessages("c1")
+	if len(got) != 1 || got[0].MessageID != "m2" {
+		t.Errorf("after unpin: got %+v", got)
+	}
+
+	if err := store.SetMessagePinned("missing", true); err != sql.ErrNoRows {
+		t.Errorf("missing message: got %v, want sql.ErrNoRows", err)
+	}
+}
+
+func TestMessageTimestampSanitization(t *testing.T) {
+	store := newTestStore(t)
+	now := time.Now()
+
+	store.UpsertMessage(&Message{MessageID: "normal", ConversationID: "c1", TimestampMS: now.Add(-time.Hour).UnixMilli()})
+	store.UpsertMessage(&Message{MessageID: "zero", ConversationID: "c1", TimestampMS: 0})
+	future := &Message{MessageID: "future", ConversationID: "c1", TimestampMS: now.AddDate(5, 0, 0).UnixMilli()}
+	store.UpsertMessage(future)
+
+	got, _ := store.GetMessageByID("future")
+	if got.TimestampMS > time.Now().UnixMilli() || got.TimestampMS < now.UnixMilli() {
+		t.Errorf("future timestamp not clamped to receipt time: %d", got.TimestampMS)
+	}
+	if future.TimestampMS != got.TimestampMS {
+		t.Error("caller's message not updated with the stored timestamp")
+	}
+
+	// Zero sorts last in newest-first listings.
+	msgs, _ := store.GetMessagesByConversation("c1", 10)
+	if len(msgs) != 3 || msgs[0].MessageID != "future" || msgs[2].MessageID != "zero" {
+		t.Errorf("order: got %v", messageIDs(msgs))
+	}
+
+	// Zero is excluded from date-bounded queries.
+	msgs, _ = store.GetMessages("", 0, now.Add(-time.Minute).UnixMilli(), 10)
+	if len(msgs) != 1 || msgs[0].MessageID != "normal" {
+		t.Errorf("before filter: got %v", messageIDs(msgs))
+	}
+}
+
+func TestFixFutureTimestamps(t *testing.T) {
+	store := newTestStore(t)
+	now := time.Now()
+	later := now.AddDate(1, 0, 0)
+
+	// Store with a clock a year ahead, then fix from the real present.
+	store.UpsertMessage(&Message{MessageID: "ok", ConversationID: "c1", TimestampMS: now.UnixMilli()})
+	store.db.Exec(`INSERT INTO messages (message_id, conversation_id, timestamp_ms) VALUES ('bad', 'c1', ?)`, later.UnixMilli())
+
+	n, err := store.FixFutureTimestamps(now)
+	if err != nil {
+		t.Fatalf("fix: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("fixed %d rows, want 1", n)
+	}
+	got, _ := store.GetMessageByID("bad")
+	if got.TimestampMS != now.UnixMilli() {
+		t.Errorf("bad timestamp: got %d, want %d", got.TimestampMS, now.UnixMilli())
+	}
+}
+
+func messageIDs(msgs []*Message) []string {
+	var ids []string
+	for _, m := range msgs {
+		ids = append(ids, m.MessageID)
+	}
+	return ids
+}
+
+func TestMediaUsage(t *testing.T) {
+	store := newTestStore(t)
+
+	store.UpsertConversation(&Conversation{ConversationID: "c1", Name: "Photos"})
+	store.UpsertConversation(&Conversation{ConversationID: "c2", Name: "Voice notes"})
+	store.UpsertMessage(&Message{MessageID: "m1", ConversationID: "c1", MediaID: "a", MediaSize: 1500})
+	store.UpsertMessage(&Message{MessageID: "m2", ConversationID: "c1", MediaID: "b", MediaSize: 2500})
+	store.UpsertMessage(&Message{MessageID: "m3", ConversationID: "c1", Body: "text only"})
+	store.UpsertMessage(&Message{MessageID: "m4", ConversationID: "c2", MediaID: "c", MediaSize: 700})
+
+	count, bytes, err := store.ConversationMediaUsage("c1")
+	if err != nil {
+		t.Fatalf("usage: %v", err)
+	}
+	if count != 2 || bytes != 4000 {
+		t.Errorf("c1: got %d items, %d bytes; want 2, 4000", count, bytes)
+	}
+
+	count, bytes, _ = store.ConversationMediaUsage("empty")
+	if count != 0 || bytes != 0 {
+		t.Errorf("empty: got %d items, %d bytes", count, bytes)
+	}
+
+	all, err := store.ListMediaUsage()
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if len(all) != 2 || all[0].ConversationID != "c1" || all[0].Name != "Photos" || all[1].TotalBytes != 700 {
+		t.Errorf("list: got %+v, %+v", all[0], all[1])
+	}
+}
+
+func TestSetConversationMessagesStatus(t *testing.T) {
+	store := newTestStore(t)
+	for _, m := range []*Message{
+		{MessageID: "m1", ConversationID: "c1", Body: "a", TimestampMS: 100, Status: "unknown"},
+		{MessageID: "m2", ConversationID: "c1", Body: "b", TimestampMS: 200, Status: ""},
+		{MessageID: "m3", ConversationID: "c1", Body: "c", TimestampMS: 300, Status: "OUTGOING_DELIVERED"},
+		{MessageID: "m4", ConversationID: "c2", Body: "d", TimestampMS: 400, Status: "unknown"},
+	} {
+		store.UpsertMessage(m)
+	}
+
+	n, err := store.SetConversationMessagesStatus("c1", "INCOMING_COMPLETE", false)
+	if err != nil {
+		t.Fatalf("set status: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("updated %d messages, want 2", n)
+	}
+	for id, want := range map[string]string{
+		"m1": "INCOMING_COMPLETE",
+		"m2": "INCOMING_COMPLETE",
+		"m3": "OUTGOING_DELIVERED",
+		"m4": "unknown",
+	} {
+		if m, _ := store.GetMessageByID(id); m.Status != want {
+			t.Errorf("%s status = %q, want %q", id, m.Status, want)
+		}
+	}
+
+	n, _ = store.SetConversationMessagesStatus("c1", "OUTGOING_COMPLETE", true)
+	if n != 3 {
+		t.Errorf("forced update changed %d messages, want 3", n)
+	}
+}
+
+func TestContainsLink(t *testing.T) {
+	tests := []struct {
+		text string
+		want bool
+	}{
+		{"see https://example.com/a?b=c", true},
+		{"HTTP://EXAMPLE.ORG", true},
+		{"try www.nopa-sf.com tonight", true},
+		{"it's on maps.app.goo.gl/xyz", true},
+		{"check youtube.com", true},
+		{"plain text, no link", false},
+		{"bring snacks e.g. chips", false},
+		{"meet at 5.30", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := ContainsLink(tt.text); got != tt.want {
+			t.Errorf("ContainsLink(%q) = %v, want %v", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestMessageFilters(t *testing.T) {
+	store := newTestStore(t)
+	msgs := []*Message{
+		{MessageID: "text", ConversationID: "c1", Body: "hello", TimestampMS: 1000},
+		{MessageID: "link", ConversationID: "c1", Body: "look https://example.com", TimestampMS: 2000},
+		{MessageID: "photo", ConversationID: "c1", MediaID: "media-1", MimeType: "image/jpeg", TimestampMS: 3000},
+		{MessageID: "both", ConversationID: "c1", Body: "example.com screenshot", MediaID: "media-2", TimestampMS: 4000},
+		{MessageID: "other", ConversationID: "c2", Body: "www.example.org", TimestampMS: 5000},
+	}
+	for _, m := range msgs {
+		if err := store.UpsertMessage(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, _ := store.GetMessageByID("link"); !got.HasLink {
+		t.Error("HasLink not stored")
+	}
+
+	tests := []struct {
+		name   string
+		filter MessageFilter
+		want   []string
+	}{
+		{"none", MessageFilter{}, []string{"both", "photo", "link", "text"}},
+		{"link", MessageFilter{HasLink: true}, []string{"both", "link"}},
+		{"media", MessageFilter{HasMedia: true}, []string{"both", "photo"}},
+		{"link and media", MessageFilter{HasLink: true, HasMedia: true}, []string{"both"}},
+	}
+	for _, tt := range tests {
+		got, err := store.GetMessagesByConversationFiltered("c1", 0, 10, tt.filter)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if ids := messageIDs(got); strings.Join(ids, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("%s: got %v, want %v", tt.name, ids, tt.want)
+		}
+	}
+
+	got, _ := store.GetMessagesFiltered("", 0, 0, 10, MessageFilter{HasLink: true})
+	if ids := messageIDs(got); strings.Join(ids, ",") != "other,both,link" {
+		t.Errorf("GetMessagesFiltered links = %v", ids)
+	}
+}
+
+func TestHasLinkBackfillOnMigration(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "links.db")
+	store, err := New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Simulate rows written before has_link existed.
+	store.db.Exec(`INSERT INTO messages (message_id, body) VALUES ('old1', 'menu at nopa.com'), ('old2', 'see you soon')`)
+	if _, err := store.db.Exec(`ALTER TABLE messages DROP COLUMN has_link`); err != nil {
+		store.Close()
+		t.Skipf("DROP COLUMN unsupported: %v", err)
+	}
+	store.Close()
+
+	store, err = New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	for id, want := range map[string]bool{"old1": true, "old2": false} {
+		if m, _ := store.GetMessageByID(id); m == nil || m.HasLink != want {
+			t.Errorf("%s: HasLink = %+v, want %v", id, m, want)
+		}
+	}
+}
+
+func TestSearchMessagesRegex(t *testing.T) {
+	store := newTestStore(t)
+	for i, body := range []string{"Flight UA123 boards at gate B7", "flight ua9 delayed", "no flights today", "50% off_sale"} {
+		store.UpsertMessage(&Message{MessageID: fmt.Sprintf("m%d", i), ConversationID: "c1", Body: body, SenderNumber: "+1555", TimestampMS: int64(1000 * (i + 1))})
+	}
+
+	tests := []struct {
+		pattern string
+		want    string
+	}{
+		{`(?i)flight [a-z]{2}\d+`, "m1,m0"},
+		{`gate [A-Z]\d`, "m0"},
+		{`flights?\b`, "m2,m1"},
+		{`50% off_`, "m3"}, // literal prefix containing LIKE wildcards
+		{`^nothing$`, ""},
+	}
+	for _, tt := range tests {
+		got, err := store.SearchMessagesRegex(regexp.MustCompile(tt.pattern), "", 10)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.pattern, err)
+		}
+		if ids := strings.Join(messageIDs(got), ","); ids != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.pattern, ids, tt.want)
+		}
+	}
+
+	got, _ := store.SearchMessagesRegex(regexp.MustCompile(`(?i)flight`), "", 1)
+	if len(got) != 1 {
+		t.Errorf("limit 1 returned %d messages", len(got))
+	}
+	got, _ = store.SearchMessagesRegex(regexp.MustCompile(`flight`), "+1999", 10)
+	if len(got) != 0 {
+		t.Errorf("phone filter returned %v", messageIDs(got))
+	}
+}
+
+func TestCountSearchMatches(t *testing.T) {
+	store := newTestStore(t)
+	seed := []struct {
+		conv, phone, body string
+		ts                int64
+	}{
+		{"c1", "+1555", "invoice attached", 1000},
+		{"c1", "+1555", "did you get the invoice?", 2000},
+		{"c2", "+1666", "Invoices are due friday", 3000},
+		{"c2", "+1666", "see you friday", 4000},
+		{"c3", "+1777", "invoice #12", 0},
+	}
+	for i, m := range seed {
+		store.UpsertMessage(&Message{MessageID: fmt.Sprintf("m%d", i), ConversationID: m.conv, SenderNumber: m.phone, Body: m.body, TimestampMS: m.ts})
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		scope SearchScope
+		want  int
+	}{
+		{"all", "invoice", SearchScope{}, 4},
+		{"phone", "invoice", SearchScope{PhoneNumber: "+1555"}, 2},
+		{"conversation", "invoice", SearchScope{ConversationID: "c2"}, 1},
+		{"after", "invoice", SearchScope{AfterMS: 2000}, 2},
+		{"before excludes unknown time", "invoice", SearchScope{BeforeMS: 2000}, 2},
+		{"substring fallback", "nvoic", SearchScope{}, 4},
+		{"none", "receipt", SearchScope{}, 0},
+	}
+	for _, tt := range tests {
+		n, err := store.CountSearchMatches(tt.query, tt.scope)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if n != tt.want {
+			t.Errorf("%s: count = %d, want %d", tt.name, n, tt.want)
+		}
+		if tt.scope == (SearchScope{}) {
+			msgs, _ := store.SearchMessages(tt.query, "", 100)
+			if len(msgs) != n {
+				t.Errorf("%s: count %d disagrees with %d search results", tt.name, n, len(msgs))
+			}
+		}
+	}
+}
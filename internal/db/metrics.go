@@ -0,0 +1,93 @@
+package db
+
+import (
+	"sort"
+	"time"
+)
+
+// maxLatencySamples bounds how many recent samples are kept and read by
+// SendLatencyStats.
+const maxLatencySamples = 1000
+
+// LatencyStats summarizes recent send latencies in milliseconds.
+type LatencyStats struct {
+	Samples int   `json:"samples"`
+	AvgMS   int64 `json:"avg_ms"`
+	P50MS   int64 `json:"p50_ms"`
+	P95MS   int64 `json:"p95_ms"`
+	MaxMS   int64 `json:"max_ms"`
+}
+
+// RecordSendLatency stores how long a sent message took to echo back from
+// the phone with its real ID. Only the newest maxLatencySamples samples are
+// kept.
+func (s *Store) RecordSendLatency(messageID, conversationID string, latency time.Duration) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`
+		INSERT OR REPLACE INTO send_latency (message_id, conversation_id, latency_ms, recorded_at)
+		VALUES (?, ?, ?, ?)
+	`, messageID, conversationID, latency.Milliseconds(), time.Now().UnixMilli()); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`
+		DELETE FROM send_latency WHERE rowid NOT IN (
+			SELECT rowid FROM send_latency ORDER BY recorded_at DESC, rowid DESC LIMIT ?
+		)
+	`, maxLatencySamples); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SendLatencyStats aggregates the most recent send latency samples.
+func (s *Store) SendLatencyStats() (*LatencyStats, error) {
+	rows, err := s.db.Query(`
+		SELECT latency_ms FROM send_latency
+		ORDER BY recorded_at DESC
+		LIMIT ?
+	`, maxLatencySamples)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var samples []int64
+	for rows.Next() {
+		var ms int64
+		if err := rows.Scan(&ms); err != nil {
+			return nil, err
+		}
+		samples = append(samples, ms)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	stats := &LatencyStats{Samples: len(samples)}
+	if len(samples) == 0 {
+		return stats, nil
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	var sum int64
+	for _, ms := range samples {
+		sum += ms
+	}
+	stats.AvgMS = sum / int64(len(samples))
+	stats.P50MS = percentile(samples, 50)
+	stats.P95MS = percentile(samples, 95)
+	stats.MaxMS = samples[len(samples)-1]
+	return stats, nil
+}
+
+// percentile returns the nearest-rank percentile of sorted samples.
+func percentile(sorted []int64, p int) int64 {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
@@ -0,0 +1,50 @@
+package db
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSendLatencyStats(t *testing.T) {
+	store := newTestStore(t)
+
+	stats, err := store.SendLatencyStats()
+	if err != nil {
+		t.Fatalf("stats: %v", err)
+	}
+	if stats.Samples != 0 {
+		t.Errorf("empty store: samples = %d", stats.Samples)
+	}
+
+	for i := 1; i <= 20; i++ {
+		if err := store.RecordSendLatency(string(rune('a'+i)), "c1", time.Duration(i*100)*time.Millisecond); err != nil {
+			t.Fatalf("record: %v", err)
+		}
+	}
+	stats, _ = store.SendLatencyStats()
+	if stats.Samples != 20 || stats.AvgMS != 1050 || stats.P50MS != 1000 || stats.P95MS != 1900 || stats.MaxMS != 2000 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestSendLatencyIsCapped(t *testing.T) {
+	store := newTestStore(t)
+	for i := 0; i < maxLatencySamples+5; i++ {
+		if err := store.RecordSendLatency(fmt.Sprint("m", i), "c1", time.Second); err != nil {
+			t.Fatalf("record: %v", err)
+		}
+	}
+	var n int
+	if err := store.db.QueryRow(`SELECT COUNT(*) FROM send_latency`).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != maxLatencySamples {
+		t.Errorf("kept %d samples, want %d", n, maxLatencySamples)
+	}
+	var oldest int
+	store.db.QueryRow(`SELECT COUNT(*) FROM send_latency WHERE message_id = 'm0'`).Scan(&oldest)
+	if oldest != 0 {
+		t.Error("oldest sample was kept")
+	}
+}
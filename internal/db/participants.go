@@ -0,0 +1,93 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+)
+
+// replaceParticipants rewrites convID's participants rows from the
+// conversation's participants JSON, keyed by each number's normalized form.
+// JSON that doesn't parse leaves the conversation with no rows.
+func (s *Store) replaceParticipants(tx *sql.Tx, convID, participantsJSON string) error {
+	if _, err := tx.Exec(`DELETE FROM participants WHERE conversation_id = ?`, convID); err != nil {
+		return err
+	}
+	var participants []Participant
+	if err := json.Unmarshal([]byte(participantsJSON), &participants); err != nil {
+		return nil
+	}
+	for i, p := range participants {
+		if _, err := tx.Exec(`
+			INSERT INTO participants (conversation_id, position, number, number_key, name, is_me)
+			VALUES (?, ?, ?, ?, ?, ?)
+		`, convID, i, p.Number, s.NormalizeNumber(p.Number), p.Name, p.IsMe); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backfillParticipants fills the participants table for conversations that
+// have no rows yet. migrate runs it once, when it creates the table, and
+// SeedDemo after inserting conversations directly.
+func (s *Store) backfillParticipants() error {
+	rows, err := s.db.Query(`
+		SELECT conversation_id, participants FROM conversations
+		WHERE conversation_id NOT IN (SELECT conversation_id FROM participants)
+	`)
+	if err != nil {
+		return err
+	}
+	pending := map[string]string{}
+	for rows.Next() {
+		var id, participants string
+		if err := rows.Scan(&id, &participants); err != nil {
+			rows.Close()
+			return err
+		}
+		pending[id] = participants
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for id, participants := range pending {
+		if err := s.replaceParticipants(tx, id, participants); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ConversationsForNumber returns every conversation, 1:1 or group, with a
+// participant other than the user whose number matches number (see
+// NumbersMatch), most recent first.
+func (s *Store) ConversationsForNumber(number string) ([]*Conversation, error) {
+	key := s.NormalizeNumber(number)
+	if key == "" {
+		return nil, nil
+	}
+	rows, err := s.db.Query(`
+		SELECT `+conversationColumns+`
+		FROM conversations
+		WHERE conversation_id IN (
+			SELECT conversation_id FROM participants
+			WHERE number_key = ? AND is_me = 0
+		)
+		ORDER BY last_message_ts = 0, last_message_ts DESC
+	`, key)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanConversations(rows)
+}
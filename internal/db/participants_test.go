@@ -0,0 +1,105 @@
+package db
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func conversationIDs(convs []*Conversation) string {
+	var ids []string
+	for _, c := range convs {
+		ids = append(ids, c.ConversationID)
+	}
+	return fmt.Sprint(ids)
+}
+
+func TestConversationsForNumber(t *testing.T) {
+	store := newTestStore(t)
+	store.UpsertConversation(&Conversation{ConversationID: "dm", LastMessageTS: 2000,
+		Participants: `[{"name":"Alice","number":"+15551234567"}]`})
+	store.UpsertConversation(&Conversation{ConversationID: "g1", IsGroup: true, LastMessageTS: 1000,
+		Participants: `[{"name":"Bob","number":"+15552222222"},{"name":"Alice","number":"(555) 123-4567"}]`})
+	store.UpsertConversation(&Conversation{ConversationID: "short", LastMessageTS: 3000,
+		Participants: `[{"name":"Bank","number":"12345"}]`})
+
+	convs, err := store.ConversationsForNumber("1-555-123-4567")
+	if err != nil {
+		t.Fatalf("lookup: %v", err)
+	}
+	if got := conversationIDs(convs); got != "[dm g1]" {
+		t.Errorf("conversations = %v, want [dm g1]", got)
+	}
+
+	// Short codes match exactly, not on a suffix.
+	if convs, _ := store.ConversationsForNumber("2345"); len(convs) != 0 {
+		t.Errorf("suffix of short code matched: %v", conversationIDs(convs))
+	}
+	if convs, _ := store.ConversationsForNumber("12345"); conversationIDs(convs) != "[short]" {
+		t.Errorf("short code = %v, want [short]", conversationIDs(convs))
+	}
+	if convs, _ := store.ConversationsForNumber("no digits"); convs != nil {
+		t.Errorf("number without digits matched: %v", conversationIDs(convs))
+	}
+
+	// Updating a conversation replaces its participants.
+	store.UpsertConversation(&Conversation{ConversationID: "g1", IsGroup: true, LastMessageTS: 1000,
+		Participants: `[{"name":"Bob","number":"+15552222222"}]`})
+	convs, _ = store.ConversationsForNumber("+15551234567")
+	if got := conversationIDs(convs); got != "[dm]" {
+		t.Errorf("after Alice left g1: conversations = %v, want [dm]", got)
+	}
+
+	// Deleting a conversation removes its participants.
+	if _, err := store.BulkConversations([]string{"dm"}, BulkDelete); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	var n int
+	store.db.QueryRow(`SELECT COUNT(*) FROM participants WHERE conversation_id = 'dm'`).Scan(&n)
+	if n != 0 {
+		t.Errorf("%d participant rows left for deleted conversation", n)
+	}
+}
+
+func TestParticipantsBackfilledOnStartup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "messages.db")
+	store, err := New(path)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	// A database from before the participants table only has the JSON.
+	if _, err := store.db.Exec(`DROP TABLE participants`); err != nil {
+		t.Fatalf("drop: %v", err)
+	}
+	if _, err := store.db.Exec(`
+		INSERT INTO conversations (conversation_id, name, participants, last_message_ts)
+		VALUES ('c1', 'Alice', '[{"name":"Alice","number":"+15551234567"},{"name":"Me","number":"+15550000000","is_me":true}]', 1000)
+	`); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	store.Close()
+
+	store, err = New(path)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer store.Close()
+
+	convs, err := store.ConversationsForNumber("5551234567")
+	if err != nil {
+		t.Fatalf("lookup: %v", err)
+	}
+	if got := conversationIDs(convs); got != "[c1]" {
+		t.Errorf("conversations = %v, want [c1]", got)
+	}
+	if convs, _ := store.ConversationsForNumber("+15550000000"); len(convs) != 0 {
+		t.Errorf("matched own number: %v", conversationIDs(convs))
+	}
+	ps, err := store.ConversationParticipants("c1")
+	if err != nil {
+		t.Fatalf("participants: %v", err)
+	}
+	if len(ps) != 2 || ps[0].Name != "Alice" || !ps[1].IsMe {
+		t.Errorf("participants = %+v", ps)
+	}
+}
@@ -0,0 +1,146 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+)
+
+// regionCallingCodes maps the regions accepted by NewWithRegion to their
+// country calling codes. Regions sharing code 1 use the North American
+// numbering plan.
+var regionCallingCodes = map[string]string{
+	"US": "1", "CA": "1",
+	"GB": "44", "IE": "353",
+	"DE": "49", "FR": "33", "ES": "34", "IT": "39", "NL": "31",
+	"AU": "61", "NZ": "64",
+	"IN": "91", "BR": "55", "MX": "52",
+}
+
+// minFullNumberDigits is the fewest digits a number needs to be treated as a
+// full phone number rather than a short code.
+const minFullNumberDigits = 7
+
+// callingCode returns the calling code of region, such as "US" or "GB". An
+// empty region means "US".
+func callingCode(region string) (string, error) {
+	if region == "" {
+		region = "US"
+	}
+	code, ok := regionCallingCodes[strings.ToUpper(region)]
+	if !ok {
+		return "", fmt.Errorf("unknown region %q", region)
+	}
+	return code, nil
+}
+
+// NormalizeNumber returns number in E.164 form, such as "+14155551234", so
+// differently formatted copies of a number compare equal. National numbers
+// get the calling code of the Store's region (see NewWithRegion). Short
+// codes are reduced to their digits, and anything without digits, such as
+// an email address, is returned trimmed but otherwise unchanged.
+func (s *Store) NormalizeNumber(number string) string {
+	return normalizeNumber(number, s.callingCode)
+}
+
+// NumbersMatch reports whether a and b are the same number once normalized
+// (see NormalizeNumber).
+func (s *Store) NumbersMatch(a, b string) bool {
+	na := s.NormalizeNumber(a)
+	return na != "" && na == s.NormalizeNumber(b)
+}
+
+func normalizeNumber(number, code string) string {
+	number = strings.TrimSpace(number)
+	d := digitsOnly(number)
+	if d == "" || strings.Contains(number, "@") {
+		return number
+	}
+	if len(d) < minFullNumberDigits {
+		return d
+	}
+	if strings.HasPrefix(number, "+") {
+		return "+" + d
+	}
+	if code == "1" {
+		switch {
+		case strings.HasPrefix(d, "011"):
+			return "+" + d[3:]
+		case len(d) == 10:
+			return "+1" + d
+		case len(d) == 11 && d[0] == '1', len(d) > 11:
+			return "+" + d
+		}
+		// Seven-digit local numbers can't be placed without an area code.
+		return d
+	}
+	switch {
+	case strings.HasPrefix(d, "00"):
+		return "+" + d[2:]
+	case d[0] == '0':
+		return "+" + code + d[1:]
+	case strings.HasPrefix(d, code) && len(d) > 10:
+		return "+" + d
+	}
+	return "+" + code + d
+}
+
+func digitsOnly(s string) string {
+	b := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] >= '0' && s[i] <= '9' {
+			b = append(b, s[i])
+		}
+	}
+	return string(b)
+}
+
+// backfillNormalizedNumbers fills in the normalized numbers of messages and
+// contacts stored before normalization, or inserted directly by SeedDemo.
+// A contact's number as stored becomes its display number.
+func (s *Store) backfillNormalizedNumbers() error {
+	type row struct{ id, number string }
+	collect := func(query string) ([]row, error) {
+		rows, err := s.db.Query(query)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		var out []row
+		for rows.Next() {
+			var r row
+			if err := rows.Scan(&r.id, &r.number); err != nil {
+				return nil, err
+			}
+			out = append(out, r)
+		}
+		return out, rows.Err()
+	}
+	senders, err := collect(`SELECT message_id, sender_number FROM messages WHERE sender_e164 = '' AND sender_number != ''`)
+	if err != nil {
+		return err
+	}
+	contacts, err := collect(`SELECT contact_id, number FROM contacts WHERE display_number = '' AND number != ''`)
+	if err != nil {
+		return err
+	}
+	if len(senders) == 0 && len(contacts) == 0 {
+		return nil
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, r := range senders {
+		if _, err := tx.Exec(`UPDATE messages SET sender_e164 = ? WHERE message_id = ?`, s.NormalizeNumber(r.number), r.id); err != nil {
+			return err
+		}
+	}
+	for _, r := range contacts {
+		if _, err := tx.Exec(`UPDATE contacts SET number = ?, display_number = ? WHERE contact_id = ?`, s.NormalizeNumber(r.number), r.number, r.id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,124 @@
+package db
+
+import "testing"
+
+func TestNormalizeNumber(t *testing.T) {
+	store := newTestStore(t)
+	for _, in := range []string{
+		"+14155551234",
+		"(415) 555-1234",
+		"4155551234",
+		"415.555.1234",
+		"1-415-555-1234",
+		"+1 (415) 555-1234",
+		"011 1 415 555 1234",
+		" 415 555 1234 ",
+	} {
+		if got := store.NormalizeNumber(in); got != "+14155551234" {
+			t.Errorf("NormalizeNumber(%q) = %q, want +14155551234", in, got)
+		}
+	}
+	for in, want := range map[string]string{
+		"+1111":             "1111",
+		"1111":              "1111",
+		"+44 20 7946 0958":  "+442079460958",
+		"555-1234":          "5551234",
+		"alice@example.com": "alice@example.com",
+		"":                  "",
+	} {
+		if got := store.NormalizeNumber(in); got != want {
+			t.Errorf("NormalizeNumber(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestNormalizeNumberRegion(t *testing.T) {
+	store, err := NewWithRegion(":memory:", "gb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	for _, in := range []string{"020 7946 0958", "+44 20 7946 0958", "0044 20 7946 0958", "442079460958"} {
+		if got := store.NormalizeNumber(in); got != "+442079460958" {
+			t.Errorf("NormalizeNumber(%q) = %q, want +442079460958", in, got)
+		}
+	}
+	if _, err := NewWithRegion(":memory:", "XX"); err == nil {
+		t.Error("unknown region accepted")
+	}
+}
+
+func TestGetMessagesNormalizesNumber(t *testing.T) {
+	store := newTestStore(t)
+	store.UpsertMessage(&Message{MessageID: "m1", ConversationID: "c1", SenderNumber: "1111", TimestampMS: 1000})
+	store.UpsertMessage(&Message{MessageID: "m2", ConversationID: "c1", SenderNumber: "+14155551234", TimestampMS: 2000})
+
+	for number, want := range map[string]string{
+		"+1111":          "m1",
+		"(415) 555-1234": "m2",
+		"4155551234":     "m2",
+	} {
+		msgs, err := store.GetMessages(number, 0, 0, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(msgs) != 1 || msgs[0].MessageID != want {
+			t.Errorf("GetMessages(%q) = %d messages, want only %s", number, len(msgs), want)
+		}
+	}
+	// The number is still shown as it arrived.
+	if msg, _ := store.GetMessageByID("m1"); msg.SenderNumber != "1111" {
+		t.Errorf("SenderNumber = %q, want 1111", msg.SenderNumber)
+	}
+}
+
+func TestContactNumbersNormalized(t *testing.T) {
+	store := newTestStore(t)
+	c := &Contact{ContactID: "c1", Name: "Alice", Number: "(415) 555-1234"}
+	store.UpsertContact(c)
+	if c.Number != "(415) 555-1234" || c.DisplayNumber != "" {
+		t.Errorf("UpsertContact modified its argument: %+v", c)
+	}
+
+	c, err := store.GetContact("c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Number != "+14155551234" || c.DisplayNumber != "(415) 555-1234" {
+		t.Errorf("contact = %+v, want number +14155551234 shown as (415) 555-1234", c)
+	}
+
+	// The same number in another format isn't a new contact.
+	if created, err := store.EnsureAutoContact("+1 415-555-1234", ""); err != nil || created {
+		t.Errorf("EnsureAutoContact created = %v, err = %v; want existing contact reused", created, err)
+	}
+
+	// Contacts stored before normalization are converted on startup.
+	store.db.Exec(`INSERT INTO contacts (contact_id, name, number) VALUES ('old', 'Bob', '212-555-9876')`)
+	if err := store.backfillNormalizedNumbers(); err != nil {
+		t.Fatal(err)
+	}
+	if c, _ := store.GetContact("old"); c.Number != "+12125559876" || c.DisplayNumber != "212-555-9876" {
+		t.Errorf("backfilled contact = %+v", c)
+	}
+}
+
+func TestNumbersMatch(t *testing.T) {
+	store := newTestStore(t)
+	for _, tc := range []struct {
+		a, b string
+		want bool
+	}{
+		{"+15551234567", "5551234567", true},
+		{"+1 (555) 123-4567", "+15551234567", true},
+		{"+15551234567", "+15551234568", false},
+		{"12345", "12345", true},
+		{"12345", "2345", false},
+		{"+445551234567", "5551234567", false},
+		{"", "", false},
+	} {
+		if got := store.NumbersMatch(tc.a, tc.b); got != tc.want {
+			t.Errorf("NumbersMatch(%q, %q) = %v, want %v", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
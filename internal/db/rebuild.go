@@ -0,0 +1,119 @@
+package db
+
+import "database/sql"
+
+// RebuildStep reports one derived table or column that Rebuild repopulated.
+type RebuildStep struct {
+	Name string `json:"name"`
+	Rows int64  `json:"rows"`
+}
+
+// rebuildSteps run in order inside Rebuild's transaction, each either
+// executing stmts or calling run. Unread counts come from the phone, so they
+// aren't rebuilt.
+var rebuildSteps = []struct {
+	name  string
+	fts   bool // only when the FTS index exists
+	stmts []string
+	run   func(s *Store, tx *sql.Tx) (int64, error)
+}{
+	{
+		name: "messages_fts",
+		fts:  true,
+		stmts: []string{
+			`DELETE FROM messages_fts`,
+			`INSERT INTO messages_fts(rowid, body) SELECT rowid, body FROM messages`,
+		},
+	},
+	{
+		name: "last_message_ts",
+		stmts: []string{`
+			UPDATE conversations SET last_message_ts = (
+				SELECT MAX(timestamp_ms) FROM messages m
+				WHERE m.conversation_id = conversations.conversation_id
+			)
+			WHERE EXISTS (
+				SELECT 1 FROM messages m
+				WHERE m.conversation_id = conversations.conversation_id
+			)`,
+		},
+	},
+	{
+		name: "participants",
+		run:  (*Store).rebuildParticipants,
+	},
+}
+
+// Rebuild repopulates derived data (the full-text index, each conversation's
+// last message timestamp, and the participants table) in a single
+// transaction. progress, if non-nil, is called after each step.
+func (s *Store) Rebuild(progress func(RebuildStep)) ([]RebuildStep, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var done []RebuildStep
+	for _, step := range rebuildSteps {
+		if step.fts && !s.fts {
+			continue
+		}
+		rs := RebuildStep{Name: step.name}
+		if step.run != nil {
+			if rs.Rows, err = step.run(s, tx); err != nil {
+				return nil, err
+			}
+		} else {
+			var res sql.Result
+			for _, stmt := range step.stmts {
+				if res, err = tx.Exec(stmt); err != nil {
+					return nil, err
+				}
+			}
+			rs.Rows, _ = res.RowsAffected()
+		}
+		done = append(done, rs)
+		if progress != nil {
+			progress(rs)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return done, nil
+}
+
+// rebuildParticipants rewrites every conversation's participants rows from
+// its participants JSON, returning how many rows it wrote.
+func (s *Store) rebuildParticipants(tx *sql.Tx) (int64, error) {
+	rows, err := tx.Query(`SELECT conversation_id, participants FROM conversations`)
+	if err != nil {
+		return 0, err
+	}
+	all := map[string]string{}
+	for rows.Next() {
+		var id, participants string
+		if err := rows.Scan(&id, &participants); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		all[id] = participants
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM participants`); err != nil {
+		return 0, err
+	}
+	for id, participants := range all {
+		if err := s.replaceParticipants(tx, id, participants); err != nil {
+			return 0, err
+		}
+	}
+	var n int64
+	err = tx.QueryRow(`SELECT COUNT(*) FROM participants`).Scan(&n)
+	return n, err
+}
@@ -0,0 +1,95 @@
+package db
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestRebuild(t *testing.T) {
+	store := newTestStore(t)
+	store.UpsertConversation(&Conversation{ConversationID: "c1", LastMessageTS: 1000})
+	store.UpsertConversation(&Conversation{ConversationID: "empty", LastMessageTS: 500})
+	store.UpsertMessage(&Message{MessageID: "m1", ConversationID: "c1", Body: "lunch tomorrow", TimestampMS: 2000})
+	store.UpsertMessage(&Message{MessageID: "m2", ConversationID: "c1", Body: "sounds good", TimestampMS: 3000})
+
+	// Simulate a database whose derived data has drifted from its messages.
+	if _, err := store.db.Exec(`DELETE FROM messages_fts`); err != nil {
+		t.Fatal(err)
+	}
+	store.db.Exec(`UPDATE conversations SET last_message_ts = 0 WHERE conversation_id = 'c1'`)
+
+	var reported []string
+	steps, err := store.Rebuild(func(s RebuildStep) { reported = append(reported, s.Name) })
+	if err != nil {
+		t.Fatalf("Rebuild: %v", err)
+	}
+	if len(steps) != 3 || len(reported) != 3 {
+		t.Fatalf("steps = %+v, reported = %v", steps, reported)
+	}
+	if steps[0].Name != "messages_fts" || steps[0].Rows != 2 {
+		t.Errorf("fts step = %+v, want 2 rows", steps[0])
+	}
+
+	var n int
+	store.db.QueryRow(`SELECT COUNT(*) FROM messages_fts WHERE messages_fts MATCH 'lunch'`).Scan(&n)
+	if n != 1 {
+		t.Errorf("fts matches for lunch = %d, want 1", n)
+	}
+	if c, _ := store.GetConversation("c1"); c.LastMessageTS != 3000 {
+		t.Errorf("c1 last_message_ts = %d, want 3000", c.LastMessageTS)
+	}
+	// Conversations with no cached messages keep the phone's timestamp.
+	if c, _ := store.GetConversation("empty"); c.LastMessageTS != 500 {
+		t.Errorf("empty last_message_ts = %d, want 500", c.LastMessageTS)
+	}
+}
+
+func TestRebuildParticipants(t *testing.T) {
+	store := newTestStore(t)
+	store.UpsertConversation(&Conversation{ConversationID: "g1", IsGroup: true,
+		Participants: `[{"name":"Alice","number":"(555) 123-4567"},{"name":"Me","number":"+15550000000","is_me":true}]`})
+	store.UpsertConversation(&Conversation{ConversationID: "dm",
+		Participants: `[{"name":"Bob","number":"+15552222222"}]`})
+
+	// Simulate a participants table that has drifted from the JSON: rows
+	// lost, rows left over from a deleted conversation, and a stale key.
+	store.db.Exec(`DELETE FROM participants WHERE conversation_id = 'dm'`)
+	store.db.Exec(`INSERT INTO participants (conversation_id, position, number, number_key) VALUES ('gone', 0, '1', '1')`)
+	store.db.Exec(`UPDATE participants SET number_key = '5551234567' WHERE conversation_id = 'g1'`)
+
+	steps, err := store.Rebuild(nil)
+	if err != nil {
+		t.Fatalf("Rebuild: %v", err)
+	}
+	if last := steps[len(steps)-1]; last.Name != "participants" || last.Rows != 3 {
+		t.Errorf("participants step = %+v, want 3 rows", last)
+	}
+
+	for convID, want := range map[string]int{"g1": 2, "dm": 1} {
+		c, _ := store.GetConversation(convID)
+		var fromJSON []*Participant
+		if err := json.Unmarshal([]byte(c.Participants), &fromJSON); err != nil {
+			t.Fatal(err)
+		}
+		got, err := store.ConversationParticipants(convID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != len(fromJSON) || len(got) != want {
+			t.Fatalf("%s: %d rows, %d in JSON, want %d", convID, len(got), len(fromJSON), want)
+		}
+		for i, p := range got {
+			if p.Name != fromJSON[i].Name || p.Number != fromJSON[i].Number || p.IsMe != fromJSON[i].IsMe {
+				t.Errorf("%s[%d] = %+v, JSON has %+v", convID, i, p, fromJSON[i])
+			}
+		}
+	}
+	if convs, _ := store.ConversationsForNumber("+15551234567"); len(convs) != 1 || convs[0].ConversationID != "g1" {
+		t.Errorf("lookup after rebuild = %d conversations, want g1", len(convs))
+	}
+	var n int
+	store.db.QueryRow(`SELECT COUNT(*) FROM participants WHERE conversation_id = 'gone'`).Scan(&n)
+	if n != 0 {
+		t.Errorf("%d rows left for a conversation that doesn't exist", n)
+	}
+}
@@ -0,0 +1,58 @@
+package db
+
+import "fmt"
+
+// pruneWhere selects the messages PruneMessagesOlderThan removes. Messages
+// with an unknown (zero) time, pinned or starred ones, and everything in a
+// pinned conversation are always kept.
+const pruneWhere = `
+	timestamp_ms > 0 AND timestamp_ms < ?
+	AND pinned = 0 AND starred = 0
+	AND conversation_id NOT IN (SELECT conversation_id FROM conversations WHERE pinned = 1)
+`
+
+// PruneMessagesOlderThan deletes messages sent before ts (ms), along with
+// their status history, edits, send latencies, sync state and search index
+// entries, and returns how many messages were removed. With keepMedia,
+// messages with an attachment are kept too.
+func (s *Store) PruneMessagesOlderThan(ts int64, keepMedia bool) (int64, error) {
+	where := pruneWhere
+	if keepMedia {
+		where += ` AND media_id = ''`
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if s.fts {
+		if _, err := tx.Exec(`DELETE FROM messages_fts WHERE rowid IN (SELECT rowid FROM messages WHERE `+where+`)`, ts); err != nil {
+			return 0, fmt.Errorf("update search index: %w", err)
+		}
+	}
+	for _, table := range []string{"message_status_events", "message_edits", "send_latency"} {
+		if _, err := tx.Exec(`DELETE FROM `+table+` WHERE message_id IN (SELECT message_id FROM messages WHERE `+where+`)`, ts); err != nil {
+			return 0, err
+		}
+	}
+	if _, err := tx.Exec(`DELETE FROM sync_state WHERE kind IN (?, ?) AND item_id IN (SELECT message_id FROM messages WHERE `+where+`)`,
+		SyncKindMessage, SyncKindStar, ts); err != nil {
+		return 0, err
+	}
+	res, err := tx.Exec(`DELETE FROM messages WHERE `+where, ts)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return n, tx.Commit()
+}
+
+// Vacuum rebuilds the database file to return space freed by deletions.
+func (s *Store) Vacuum() error {
+	_, err := s.db.Exec(`VACUUM`)
+	return err
+}
@@ -0,0 +1,80 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+)
+
+func remainingMessageIDs(t *testing.T, store *Store) string {
+	t.Helper()
+	msgs, err := store.GetMessages("", 0, 0, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, m := range msgs {
+		ids = append(ids, m.MessageID)
+	}
+	sort.Strings(ids)
+	return fmt.Sprint(ids)
+}
+
+func TestPruneMessagesOlderThan(t *testing.T) {
+	store := newTestStore(t)
+	store.UpsertConversation(&Conversation{ConversationID: "c1"})
+	store.UpsertConversation(&Conversation{ConversationID: "pinned"})
+	store.SetConversationPinned("pinned", true)
+	for _, m := range []*Message{
+		{MessageID: "old", ConversationID: "c1", Body: "ancient history", TimestampMS: 1000},
+		{MessageID: "old-media", ConversationID: "c1", MediaID: "media-1", TimestampMS: 1500},
+		{MessageID: "old-starred", ConversationID: "c1", TimestampMS: 1500},
+		{MessageID: "old-pinned-conv", ConversationID: "pinned", TimestampMS: 1000},
+		{MessageID: "undated", ConversationID: "c1", TimestampMS: 0},
+		{MessageID: "cutoff", ConversationID: "c1", TimestampMS: 2000},
+		{MessageID: "new", ConversationID: "c1", TimestampMS: 3000},
+	} {
+		if err := store.UpsertMessage(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store.SetMessageStarred("old-starred", true)
+	store.MarkSyncPending(SyncKindMessage, "old")
+	store.MarkSyncPending(SyncKindStar, "old")
+	store.MarkSyncPending(SyncKindMessage, "new")
+	store.RecordSendLatency("old", "c1", time.Second)
+
+	n, err := store.PruneMessagesOlderThan(2000, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("pruned %d messages, want 1", n)
+	}
+	if got := remainingMessageIDs(t, store); got != "[cutoff new old-media old-pinned-conv old-starred undated]" {
+		t.Errorf("remaining = %v", got)
+	}
+	if hits, _ := store.SearchMessages("ancient", "", 10); len(hits) != 0 {
+		t.Errorf("pruned message still searchable: %+v", hits)
+	}
+	if left, _ := store.ListUnsynced(10); len(left) != 1 || left[0].ItemID != "new" {
+		t.Errorf("sync state left = %+v, want only new", left)
+	}
+	var latencies int
+	store.db.QueryRow(`SELECT COUNT(*) FROM send_latency`).Scan(&latencies)
+	if latencies != 0 {
+		t.Errorf("%d send latencies left for pruned messages", latencies)
+	}
+
+	// Without keepMedia, old attachments go too.
+	if n, _ := store.PruneMessagesOlderThan(2000, false); n != 1 {
+		t.Errorf("pruned %d messages with media, want 1", n)
+	}
+	if got := remainingMessageIDs(t, store); got != "[cutoff new old-pinned-conv old-starred undated]" {
+		t.Errorf("remaining = %v", got)
+	}
+	if err := store.Vacuum(); err != nil {
+		t.Errorf("vacuum: %v", err)
+	}
+}
@@ -0,0 +1,52 @@
+package db
+
+import "time"
+
+// StatusEvent is one observed change in a message's delivery status.
+type StatusEvent struct {
+	Status      string `json:"status"`
+	TimestampMS int64  `json:"timestamp_ms"`
+}
+
+// RecordStatusChange appends a status event for messageID if status differs
+// from the last one recorded. It reports whether an event was written.
+func (s *Store) RecordStatusChange(messageID, status string, at time.Time) (bool, error) {
+	if status == "" {
+		return false, nil
+	}
+	result, err := s.db.Exec(`
+		INSERT INTO message_status_events (message_id, status, ts)
+		SELECT ?, ?, ?
+		WHERE COALESCE((
+			SELECT status FROM message_status_events
+			WHERE message_id = ? ORDER BY ts DESC, id DESC LIMIT 1
+		), '') != ?
+	`, messageID, status, at.UnixMilli(), messageID, status)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// GetStatusHistory returns a message's status events, oldest first.
+func (s *Store) GetStatusHistory(messageID string) ([]StatusEvent, error) {
+	rows, err := s.db.Query(`
+		SELECT status, ts FROM message_status_events
+		WHERE message_id = ?
+		ORDER BY ts ASC, id ASC
+	`, messageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var events []StatusEvent
+	for rows.Next() {
+		var e StatusEvent
+		if err := rows.Scan(&e.Status, &e.TimestampMS); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
@@ -0,0 +1,101 @@
+package db
+
+import "time"
+
+// Kinds of items mirrored to Supabase, tracked in sync_state.
+const (
+	SyncKindMessage      = "message"
+	SyncKindConversation = "conversation"
+	SyncKindStar         = "star" // a message's starred flag
+)
+
+// Sync states. Items start pending and move to failed after a failed
+// attempt; a successful attempt removes the item, so sync_state only holds
+// work that is still outstanding.
+const (
+	SyncPending = "pending"
+	SyncFailed  = "failed"
+)
+
+// SyncItem is the Supabase sync state of one local row.
+type SyncItem struct {
+	Kind      string
+	ItemID    string
+	Status    string
+	Attempts  int
+	LastError string
+	UpdatedAt int64
+}
+
+// MarkSyncPending records that an item is about to be sent to Supabase.
+func (s *Store) MarkSyncPending(kind, itemID string) error {
+	_, err := s.db.Exec(`
+		INSERT INTO sync_state (kind, item_id, status, updated_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(kind, item_id) DO UPDATE SET
+			status=excluded.status,
+			updated_at=excluded.updated_at
+	`, kind, itemID, SyncPending, time.Now().UnixMilli())
+	return err
+}
+
+// MarkSyncResult records the outcome of a sync attempt. A nil syncErr
+// means the item is synced, and its sync state is deleted.
+func (s *Store) MarkSyncResult(kind, itemID string, syncErr error) error {
+	if syncErr == nil {
+		_, err := s.db.Exec(`DELETE FROM sync_state WHERE kind = ? AND item_id = ?`, kind, itemID)
+		return err
+	}
+	_, err := s.db.Exec(`
+		INSERT INTO sync_state (kind, item_id, status, attempts, last_error, updated_at)
+		VALUES (?, ?, ?, 1, ?, ?)
+		ON CONFLICT(kind, item_id) DO UPDATE SET
+			status=excluded.status,
+			attempts=sync_state.attempts + 1,
+			last_error=excluded.last_error,
+			updated_at=excluded.updated_at
+	`, kind, itemID, SyncFailed, syncErr.Error(), time.Now().UnixMilli())
+	return err
+}
+
+// GetSyncItem returns the sync state of an item, or nil if it has nothing
+// outstanding: it was never queued or has been synced.
+func (s *Store) GetSyncItem(kind, itemID string) (*SyncItem, error) {
+	it := &SyncItem{}
+	err := s.db.QueryRow(`
+		SELECT kind, item_id, status, attempts, last_error, updated_at
+		FROM sync_state WHERE kind = ? AND item_id = ?
+	`, kind, itemID).Scan(&it.Kind, &it.ItemID, &it.Status, &it.Attempts, &it.LastError, &it.UpdatedAt)
+	if err != nil {
+		if err.Error() == "sql: no rows in result set" {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return it, nil
+}
+
+// ListUnsynced returns pending and failed items, oldest first.
+func (s *Store) ListUnsynced(limit int) ([]*SyncItem, error) {
+	rows, err := s.db.Query(`
+		SELECT kind, item_id, status, attempts, last_error, updated_at
+		FROM sync_state
+		WHERE status IN (?, ?)
+		ORDER BY updated_at
+		LIMIT ?
+	`, SyncPending, SyncFailed, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []*SyncItem
+	for rows.Next() {
+		it := &SyncItem{}
+		if err := rows.Scan(&it.Kind, &it.ItemID, &it.Status, &it.Attempts, &it.LastError, &it.UpdatedAt); err != nil {
+			return nil, err
+		}
+		items = append(items, it)
+	}
+	return items, rows.Err()
+}
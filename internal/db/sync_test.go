@@ -0,0 +1,43 @@
+package db
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSyncState(t *testing.T) {
+	store := newTestStore(t)
+
+	if it, err := store.GetSyncItem(SyncKindMessage, "m1"); err != nil || it != nil {
+		t.Fatalf("unknown item: got %+v, %v", it, err)
+	}
+
+	store.MarkSyncPending(SyncKindMessage, "m1")
+	store.MarkSyncPending(SyncKindMessage, "m2")
+	store.MarkSyncResult(SyncKindMessage, "m2", errors.New("timeout"))
+	store.MarkSyncResult(SyncKindConversation, "c1", nil)
+
+	it, _ := store.GetSyncItem(SyncKindMessage, "m2")
+	if it.Status != SyncFailed || it.LastError != "timeout" || it.Attempts != 1 {
+		t.Errorf("failed item: %+v", it)
+	}
+
+	unsynced, err := store.ListUnsynced(10)
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if len(unsynced) != 2 {
+		t.Fatalf("unsynced: got %d, want 2", len(unsynced))
+	}
+
+	// Synced items leave nothing behind.
+	store.MarkSyncResult(SyncKindMessage, "m2", nil)
+	if it, _ := store.GetSyncItem(SyncKindMessage, "m2"); it != nil {
+		t.Errorf("after success: %+v, want no row", it)
+	}
+	var n int
+	store.db.QueryRow(`SELECT COUNT(*) FROM sync_state`).Scan(&n)
+	if n != 1 {
+		t.Errorf("%d sync_state rows, want only pending m1", n)
+	}
+}
@@ -0,0 +1,16 @@
+-- Starred messages, synced so stars survive reinstalls and other devices
+
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS starred BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE INDEX IF NOT EXISTS idx_messages_starred ON messages(starred) WHERE starred;
+
+CREATE OR REPLACE FUNCTION set_message_starred(
+    p_id TEXT,
+    p_conversation_id TEXT,
+    p_starred BOOLEAN
+) RETURNS VOID AS $$
+BEGIN
+    UPDATE messages SET starred = p_starred
+    WHERE id = p_id AND conversation_id = p_conversation_id;
+END;
+$$ LANGUAGE plpgsql;
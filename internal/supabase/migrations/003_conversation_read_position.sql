@@ -0,0 +1,17 @@
+-- Last-read position per conversation, synced so read state matches across devices
+
+ALTER TABLE conversations ADD COLUMN IF NOT EXISTS last_read_at TIMESTAMPTZ;
+
+-- The read position only moves forward, so a device that is behind can't
+-- mark newer messages unread again.
+CREATE OR REPLACE FUNCTION set_conversation_read(
+    p_conversation_id TEXT,
+    p_last_read_at TIMESTAMPTZ
+) RETURNS VOID AS $$
+BEGIN
+    INSERT INTO conversations (conversation_id, last_read_at)
+    VALUES (p_conversation_id, p_last_read_at)
+    ON CONFLICT (conversation_id) DO UPDATE SET
+        last_read_at = GREATEST(conversations.last_read_at, p_last_read_at);
+END;
+$$ LANGUAGE plpgsql;
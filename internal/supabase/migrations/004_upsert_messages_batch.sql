@@ -0,0 +1,25 @@
+-- Bulk message upsert for backfill: one call per batch instead of per message
+
+CREATE OR REPLACE FUNCTION upsert_messages(
+    p_messages JSONB
+) RETURNS VOID AS $$
+BEGIN
+    INSERT INTO messages (id, conversation_id, sender_name, sender_number, content, timestamp, is_from_me, media_type, media_url)
+    SELECT m.id, m.conversation_id, m.sender_name, m.sender_number, m.content, m.timestamp, m.is_from_me, m.media_type, m.media_url
+    FROM jsonb_to_recordset(p_messages) AS m(
+        id TEXT,
+        conversation_id TEXT,
+        sender_name TEXT,
+        sender_number TEXT,
+        content TEXT,
+        timestamp TIMESTAMPTZ,
+        is_from_me BOOLEAN,
+        media_type TEXT,
+        media_url TEXT
+    )
+    ON CONFLICT (id, conversation_id) DO UPDATE SET
+        content = COALESCE(NULLIF(EXCLUDED.content, ''), messages.content),
+        media_type = COALESCE(NULLIF(EXCLUDED.media_type, ''), messages.media_type),
+        media_url = COALESCE(NULLIF(EXCLUDED.media_url, ''), messages.media_url);
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,62 @@
+-- Reactions and reply targets on synced messages
+
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS reactions JSONB;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS reply_to_id TEXT;
+
+-- Replace rather than overload upsert_message, so PostgREST can still pick
+-- it for callers that send only the original parameters. The new parameters
+-- default to NULL, which keeps the stored values.
+DROP FUNCTION IF EXISTS upsert_message(TEXT, TEXT, TEXT, TEXT, TEXT, TIMESTAMPTZ, BOOLEAN, TEXT, TEXT);
+
+CREATE OR REPLACE FUNCTION upsert_message(
+    p_id TEXT,
+    p_conversation_id TEXT,
+    p_sender_name TEXT,
+    p_sender_number TEXT,
+    p_content TEXT,
+    p_timestamp TIMESTAMPTZ,
+    p_is_from_me BOOLEAN,
+    p_media_type TEXT,
+    p_media_url TEXT,
+    p_reactions JSONB DEFAULT NULL,
+    p_reply_to_id TEXT DEFAULT NULL
+) RETURNS VOID AS $$
+BEGIN
+    INSERT INTO messages (id, conversation_id, sender_name, sender_number, content, timestamp, is_from_me, media_type, media_url, reactions, reply_to_id)
+    VALUES (p_id, p_conversation_id, p_sender_name, p_sender_number, p_content, p_timestamp, p_is_from_me, p_media_type, p_media_url, p_reactions, NULLIF(p_reply_to_id, ''))
+    ON CONFLICT (id, conversation_id) DO UPDATE SET
+        content = COALESCE(NULLIF(p_content, ''), messages.content),
+        media_type = COALESCE(NULLIF(p_media_type, ''), messages.media_type),
+        media_url = COALESCE(NULLIF(p_media_url, ''), messages.media_url),
+        reactions = COALESCE(p_reactions, messages.reactions),
+        reply_to_id = COALESCE(NULLIF(p_reply_to_id, ''), messages.reply_to_id);
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION upsert_messages(
+    p_messages JSONB
+) RETURNS VOID AS $$
+BEGIN
+    INSERT INTO messages (id, conversation_id, sender_name, sender_number, content, timestamp, is_from_me, media_type, media_url, reactions, reply_to_id)
+    SELECT m.id, m.conversation_id, m.sender_name, m.sender_number, m.content, m.timestamp, m.is_from_me, m.media_type, m.media_url, m.reactions, NULLIF(m.reply_to_id, '')
+    FROM jsonb_to_recordset(p_messages) AS m(
+        id TEXT,
+        conversation_id TEXT,
+        sender_name TEXT,
+        sender_number TEXT,
+        content TEXT,
+        timestamp TIMESTAMPTZ,
+        is_from_me BOOLEAN,
+        media_type TEXT,
+        media_url TEXT,
+        reactions JSONB,
+        reply_to_id TEXT
+    )
+    ON CONFLICT (id, conversation_id) DO UPDATE SET
+        content = COALESCE(NULLIF(EXCLUDED.content, ''), messages.content),
+        media_type = COALESCE(NULLIF(EXCLUDED.media_type, ''), messages.media_type),
+        media_url = COALESCE(NULLIF(EXCLUDED.media_url, ''), messages.media_url),
+        reactions = COALESCE(EXCLUDED.reactions, messages.reactions),
+        reply_to_id = COALESCE(EXCLUDED.reply_to_id, messages.reply_to_id);
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,13 @@
+-- Messages unsent by their sender keep their row but lose their content
+
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS deleted BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE OR REPLACE FUNCTION set_message_deleted(
+    p_id TEXT,
+    p_conversation_id TEXT
+) RETURNS VOID AS $$
+BEGIN
+    UPDATE messages SET deleted = TRUE, content = '', media_type = NULL, media_url = NULL
+    WHERE id = p_id AND conversation_id = p_conversation_id;
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,16 @@
+-- set_message_starred reports whether the message exists, so a star on a
+-- message not synced yet stays pending locally instead of being lost
+
+DROP FUNCTION IF EXISTS set_message_starred(TEXT, TEXT, BOOLEAN);
+
+CREATE FUNCTION set_message_starred(
+    p_id TEXT,
+    p_conversation_id TEXT,
+    p_starred BOOLEAN
+) RETURNS BOOLEAN AS $$
+BEGIN
+    UPDATE messages SET starred = p_starred
+    WHERE id = p_id AND conversation_id = p_conversation_id;
+    RETURN FOUND;
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,65 @@
+package supabase
+
+import "sync"
+
+// Queue defaults, overridable with SUPABASE_WORKERS and SUPABASE_QUEUE_SIZE.
+const (
+	defaultWorkers   = 4
+	defaultQueueSize = 1024
+)
+
+// Queue runs background writes on a fixed pool of workers, so a burst of
+// synced messages doesn't open a request per message at once. Enqueue never
+// blocks: when the buffer is full the job is refused, and callers leave the
+// item pending in the local sync state for the next retry.
+type Queue struct {
+	jobs chan func()
+	wg   sync.WaitGroup
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewQueue starts workers goroutines draining a buffer of size jobs.
+func NewQueue(workers, size int) *Queue {
+	q := &Queue{jobs: make(chan func(), size)}
+	q.wg.Add(workers)
+	for range workers {
+		go func() {
+			defer q.wg.Done()
+			for job := range q.jobs {
+				job()
+			}
+		}()
+	}
+	return q
+}
+
+// Enqueue adds job to the queue without waiting. It reports false, dropping
+// job, when the queue is full or closed.
+func (q *Queue) Enqueue(job func()) bool {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		return false
+	}
+	select {
+	case q.jobs <- job:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close stops accepting jobs and waits for the queued ones to finish.
+func (q *Queue) Close() {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return
+	}
+	q.closed = true
+	close(q.jobs)
+	q.mu.Unlock()
+	q.wg.Wait()
+}
@@ -0,0 +1,93 @@
+package supabase
+
+import (
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestQueueBoundsConcurrency(t *testing.T) {
+	const jobs, workers = 200, 3
+	before := runtime.NumGoroutine()
+	q := NewQueue(workers, jobs)
+
+	var done, running, peak, peakGoroutines atomic.Int64
+	for range jobs {
+		ok := q.Enqueue(func() {
+			n := running.Add(1)
+			for {
+				p := peak.Load()
+				if n <= p || peak.CompareAndSwap(p, n) {
+					break
+				}
+			}
+			if g := int64(runtime.NumGoroutine()); g > peakGoroutines.Load() {
+				peakGoroutines.Store(g)
+			}
+			time.Sleep(100 * time.Microsecond)
+			running.Add(-1)
+			done.Add(1)
+		})
+		if !ok {
+			t.Fatal("Enqueue refused a job on an open queue")
+		}
+	}
+	q.Close()
+
+	if got := done.Load(); got != jobs {
+		t.Errorf("processed %d jobs, want %d", got, jobs)
+	}
+	if got := peak.Load(); got > workers {
+		t.Errorf("%d jobs ran at once, want at most %d", got, workers)
+	}
+	if extra := peakGoroutines.Load() - int64(before); extra > workers+1 {
+		t.Errorf("queue ran %d extra goroutines, want about %d", extra, workers)
+	}
+}
+
+func TestQueueCloseDrains(t *testing.T) {
+	q := NewQueue(1, 100)
+	var done atomic.Int64
+	for range 50 {
+		q.Enqueue(func() {
+			time.Sleep(100 * time.Microsecond)
+			done.Add(1)
+		})
+	}
+	q.Close()
+	if got := done.Load(); got != 50 {
+		t.Errorf("Close returned with %d of 50 jobs done", got)
+	}
+	if q.Enqueue(func() { t.Error("job ran after Close") }) {
+		t.Error("Enqueue accepted a job after Close")
+	}
+	q.Close() // closing twice is harmless
+}
+
+func TestQueueRefusesWhenFull(t *testing.T) {
+	q := NewQueue(1, 1)
+	release := make(chan struct{})
+	started := make(chan struct{})
+	q.Enqueue(func() {
+		close(started)
+		<-release
+	})
+	<-started
+	if !q.Enqueue(func() {}) {
+		t.Fatal("Enqueue refused a job with room in the buffer")
+	}
+
+	refused := make(chan bool)
+	go func() { refused <- !q.Enqueue(func() { t.Error("refused job ran") }) }()
+	select {
+	case ok := <-refused:
+		if !ok {
+			t.Error("Enqueue accepted a job on a full queue")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Enqueue blocked on a full queue")
+	}
+	close(release)
+	q.Close()
+}
@@ -5,12 +5,16 @@ import (
 	"database/sql"
 	"embed"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"math/rand/v2"
 	"net/http"
+	"net/url"
 	"os"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -29,8 +33,22 @@ type Writer struct {
 	url    string
 	key    string
 	client *http.Client
+
+	// retries is how many times a request failing with 429, 5xx or a
+	// connection error is re-sent; retryBase is the first backoff delay.
+	retries   int
+	retryBase time.Duration
+
+	// queue runs the writes handed to Enqueue.
+	queue *Queue
 }
 
+// Retry defaults, overridable with SUPABASE_RETRIES and SUPABASE_RETRY_BASE_MS.
+const (
+	defaultRetries   = 3
+	defaultRetryBase = 500 * time.Millisecond
+)
+
 // NewWriter creates a Supabase writer using REST APIs.
 // Requires SUPABASE_URL and SUPABASE_KEY env vars.
 // Optionally runs migrations if SUPABASE_DB_URL is also set.
@@ -44,10 +62,26 @@ func NewWriter() (*Writer, error) {
 	}
 
 	sw := &Writer{
-		url:    strings.TrimRight(url, "/"),
-		key:    key,
-		client: &http.Client{Timeout: 30 * time.Second},
+		url:       strings.TrimRight(url, "/"),
+		key:       key,
+		client:    &http.Client{Timeout: 30 * time.Second},
+		retries:   defaultRetries,
+		retryBase: defaultRetryBase,
+	}
+	if n, err := strconv.Atoi(os.Getenv("SUPABASE_RETRIES")); err == nil && n >= 0 {
+		sw.retries = n
 	}
+	if ms, err := strconv.Atoi(os.Getenv("SUPABASE_RETRY_BASE_MS")); err == nil && ms > 0 {
+		sw.retryBase = time.Duration(ms) * time.Millisecond
+	}
+	workers, size := defaultWorkers, defaultQueueSize
+	if n, err := strconv.Atoi(os.Getenv("SUPABASE_WORKERS")); err == nil && n > 0 {
+		workers = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("SUPABASE_QUEUE_SIZE")); err == nil && n >= 0 {
+		size = n
+	}
+	sw.queue = NewQueue(workers, size)
 
 	// Optional: auto-migrate if SUPABASE_DB_URL is set
 	dbURL := os.Getenv("SUPABASE_DB_URL")
@@ -67,42 +101,136 @@ func NewWriter() (*Writer, error) {
 	return sw, nil
 }
 
-// Close is a no-op for the REST-based writer (no persistent connections).
+// Enqueue runs job on the writer's worker pool without blocking. A writer
+// without a pool runs it before returning. It reports false, dropping job,
+// if the pool's buffer is full or the writer is closed.
+func (sw *Writer) Enqueue(job func()) bool {
+	if sw.queue == nil {
+		job()
+		return true
+	}
+	return sw.queue.Enqueue(job)
+}
+
+// Close waits for queued writes to finish. The REST-based writer holds no
+// persistent connections.
 func (sw *Writer) Close() error {
+	if sw.queue != nil {
+		sw.queue.Close()
+	}
 	return nil
 }
 
 // --- PostgREST RPC Calls ---
 
-// rpc calls a Supabase PostgREST RPC function.
+// ErrMessageNotSynced is returned when a change targets a message that
+// hasn't been upserted to Supabase yet.
+var ErrMessageNotSynced = errors.New("message not synced to Supabase")
+
+// rpc calls a Supabase PostgREST RPC function. Transient failures are
+// retried; the final failure is logged, since most callers sync in the
+// background and drop the error.
 func (sw *Writer) rpc(funcName string, params map[string]interface{}) error {
+	_, err := sw.rpcResult(funcName, params)
+	return err
+}
+
+// rpcResult calls a PostgREST RPC function like rpc and returns the
+// response body, which holds the function's JSON result.
+func (sw *Writer) rpcResult(funcName string, params map[string]interface{}) ([]byte, error) {
 	body, err := json.Marshal(params)
 	if err != nil {
-		return fmt.Errorf("marshal RPC params: %w", err)
+		return nil, fmt.Errorf("marshal RPC params: %w", err)
 	}
 
-	req, err := http.NewRequest("POST",
-		fmt.Sprintf("%s/rest/v1/rpc/%s", sw.url, funcName),
-		bytes.NewReader(body),
-	)
+	result, err := sw.send("RPC "+funcName, func() (*http.Request, error) {
+		req, err := http.NewRequest("POST",
+			fmt.Sprintf("%s/rest/v1/rpc/%s", sw.url, funcName),
+			bytes.NewReader(body),
+		)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("apikey", sw.key)
+		req.Header.Set("Authorization", "Bearer "+sw.key)
+		req.Header.Set("Content-Type", "application/json")
+		return req, nil
+	})
 	if err != nil {
-		return err
+		log.Printf("WARN: Supabase %s failed (%s): %v", funcName, summarizeParams(params), err)
 	}
-	req.Header.Set("apikey", sw.key)
-	req.Header.Set("Authorization", "Bearer "+sw.key)
-	req.Header.Set("Content-Type", "application/json")
+	return result, err
+}
 
-	resp, err := sw.client.Do(req)
-	if err != nil {
-		return fmt.Errorf("RPC %s: %w", funcName, err)
+// send issues the request built by newReq, rebuilding and re-sending it with
+// exponential backoff and jitter while it fails with 429, a 5xx status or a
+// connection error. Other 4xx responses are returned immediately. On success
+// it returns the response body.
+func (sw *Writer) send(name string, newReq func() (*http.Request, error)) ([]byte, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := newReq()
+		if err != nil {
+			return nil, err
+		}
+		resp, err := sw.client.Do(req)
+		if err != nil {
+			err = fmt.Errorf("%s: %w", name, err)
+		} else {
+			respBody, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode < 400 {
+				return respBody, nil
+			}
+			err = fmt.Errorf("%s returned %d: %s", name, resp.StatusCode, string(respBody))
+			if !retryableStatus(resp.StatusCode) {
+				return nil, err
+			}
+		}
+		if attempt >= sw.retries {
+			return nil, err
+		}
+		time.Sleep(sw.backoff(attempt))
 	}
-	defer resp.Body.Close()
+}
 
-	if resp.StatusCode >= 400 {
-		respBody, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("RPC %s returned %d: %s", funcName, resp.StatusCode, string(respBody))
+// retryableStatus reports whether a response status is worth retrying.
+func retryableStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code >= 500
+}
+
+// backoff returns the delay before retry number attempt+1: retryBase doubled
+// per attempt, with the upper half randomized so clients don't retry in step.
+func (sw *Writer) backoff(attempt int) time.Duration {
+	d := sw.retryBase << attempt
+	return d/2 + rand.N(d/2+1)
+}
+
+// summarizeParams describes RPC params for logs without message content.
+func summarizeParams(params map[string]interface{}) string {
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
 	}
-	return nil
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		if k == "p_content" {
+			continue
+		}
+		if rows, ok := params[k].([]MessageRow); ok {
+			parts = append(parts, fmt.Sprintf("%s=[%d rows]", k, len(rows)))
+			continue
+		}
+		v := fmt.Sprint(params[k])
+		if raw, ok := params[k].(json.RawMessage); ok {
+			v = string(raw)
+		}
+		if len(v) > 40 {
+			v = v[:40] + "…"
+		}
+		parts = append(parts, k+"="+v)
+	}
+	return strings.Join(parts, " ")
 }
 
 // UpsertConversation upserts a conversation via PostgREST RPC.
@@ -116,8 +244,10 @@ func (sw *Writer) UpsertConversation(convID, name string, lastMessageTime time.T
 	})
 }
 
-// UpsertMessage upserts a message via PostgREST RPC.
-func (sw *Writer) UpsertMessage(id, conversationID, senderName, senderNumber, content string, timestamp time.Time, isFromMe bool, mediaType, mediaURL string) error {
+// UpsertMessage upserts a message via PostgREST RPC. reactions is the local
+// JSON array of {emoji, count}; empty means no reactions. replyToID is the
+// ID of the message replied to, if any.
+func (sw *Writer) UpsertMessage(id, conversationID, senderName, senderNumber, content string, timestamp time.Time, isFromMe bool, mediaType, mediaURL, reactions, replyToID string) error {
 	if content == "" && mediaType == "" {
 		return nil
 	}
@@ -131,6 +261,51 @@ func (sw *Writer) UpsertMessage(id, conversationID, senderName, senderNumber, co
 		"p_is_from_me":      isFromMe,
 		"p_media_type":      mediaType,
 		"p_media_url":       mediaURL,
+		"p_reactions":       ReactionsJSON(reactions),
+		"p_reply_to_id":     replyToID,
+	})
+}
+
+// ReactionsJSON returns a message's local reactions string as JSON for the
+// reactions column. Empty or malformed input gives an empty array, so
+// removed reactions are cleared remotely too.
+func ReactionsJSON(reactions string) json.RawMessage {
+	if reactions == "" || !json.Valid([]byte(reactions)) {
+		return json.RawMessage("[]")
+	}
+	return json.RawMessage(reactions)
+}
+
+// MessageRow is one message in an UpsertMessagesBatch call.
+type MessageRow struct {
+	ID             string          `json:"id"`
+	ConversationID string          `json:"conversation_id"`
+	SenderName     string          `json:"sender_name"`
+	SenderNumber   string          `json:"sender_number"`
+	Content        string          `json:"content"`
+	Timestamp      time.Time       `json:"timestamp"`
+	IsFromMe       bool            `json:"is_from_me"`
+	MediaType      string          `json:"media_type"`
+	MediaURL       string          `json:"media_url"`
+	Reactions      json.RawMessage `json:"reactions"`
+	ReplyToID      string          `json:"reply_to_id"`
+}
+
+// UpsertMessagesBatch upserts many messages in one PostgREST RPC call, with
+// the same semantics as UpsertMessage. Like UpsertMessage it skips rows with
+// neither content nor media.
+func (sw *Writer) UpsertMessagesBatch(rows []MessageRow) error {
+	keep := make([]MessageRow, 0, len(rows))
+	for _, r := range rows {
+		if r.Content != "" || r.MediaType != "" {
+			keep = append(keep, r)
+		}
+	}
+	if len(keep) == 0 {
+		return nil
+	}
+	return sw.rpc("upsert_messages", map[string]interface{}{
+		"p_messages": keep,
 	})
 }
 
@@ -142,6 +317,161 @@ func (sw *Writer) UpsertContact(number, name string) error {
 	})
 }
 
+// SetMessageStarred sets the starred flag on a synced message via PostgREST
+// RPC. It returns ErrMessageNotSynced if Supabase has no such message yet,
+// so the caller can keep the change pending until the message is upserted.
+func (sw *Writer) SetMessageStarred(id, conversationID string, starred bool) error {
+	result, err := sw.rpcResult("set_message_starred", map[string]interface{}{
+		"p_id":              id,
+		"p_conversation_id": conversationID,
+		"p_starred":         starred,
+	})
+	if err != nil {
+		return err
+	}
+	// Databases without migration 007 return no result; assume the row exists.
+	var found *bool
+	if json.Unmarshal(result, &found) == nil && found != nil && !*found {
+		return ErrMessageNotSynced
+	}
+	return nil
+}
+
+// SetMessageDeleted flags a synced message as unsent and clears its content
+// via PostgREST RPC.
+func (sw *Writer) SetMessageDeleted(id, conversationID string) error {
+	return sw.rpc("set_message_deleted", map[string]interface{}{
+		"p_id":              id,
+		"p_conversation_id": conversationID,
+	})
+}
+
+// ListStarredMessageIDs returns the IDs of all messages starred in Supabase.
+func (sw *Writer) ListStarredMessageIDs() ([]string, error) {
+	req, err := http.NewRequest("GET", sw.url+"/rest/v1/messages?select=id&starred=is.true", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("apikey", sw.key)
+	req.Header.Set("Authorization", "Bearer "+sw.key)
+
+	resp, err := sw.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("list starred: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		respBody, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("list starred returned %d: %s", resp.StatusCode, string(respBody))
+	}
+	var rows []struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&rows); err != nil {
+		return nil, fmt.Errorf("decode starred: %w", err)
+	}
+	ids := make([]string, len(rows))
+	for i, r := range rows {
+		ids[i] = r.ID
+	}
+	return ids, nil
+}
+
+// messageStarsBatch bounds how many IDs go into one MessageStars request, so
+// the query string stays well under URL length limits.
+const messageStarsBatch = 100
+
+// listValueEscaper escapes a value for a double-quoted PostgREST in.() list.
+var listValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// MessageStars returns the starred flag in Supabase of each message in ids
+// that exists there, keyed by message ID. IDs missing from the result
+// haven't been synced.
+func (sw *Writer) MessageStars(ids []string) (map[string]bool, error) {
+	stars := make(map[string]bool, len(ids))
+	for start := 0; start < len(ids); start += messageStarsBatch {
+		batch := ids[start:min(start+messageStarsBatch, len(ids))]
+		quoted := make([]string, len(batch))
+		for i, id := range batch {
+			quoted[i] = `"` + listValueEscaper.Replace(id) + `"`
+		}
+		filter := url.QueryEscape("in.(" + strings.Join(quoted, ",") + ")")
+		req, err := http.NewRequest("GET", sw.url+"/rest/v1/messages?select=id,starred&id="+filter, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("apikey", sw.key)
+		req.Header.Set("Authorization", "Bearer "+sw.key)
+
+		resp, err := sw.client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("get message stars: %w", err)
+		}
+		var rows []struct {
+			ID      string `json:"id"`
+			Starred bool   `json:"starred"`
+		}
+		if resp.StatusCode >= 400 {
+			respBody, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			return nil, fmt.Errorf("get message stars returned %d: %s", resp.StatusCode, string(respBody))
+		}
+		err = json.NewDecoder(resp.Body).Decode(&rows)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("decode message stars: %w", err)
+		}
+		for _, r := range rows {
+			stars[r.ID] = r.Starred
+		}
+	}
+	return stars, nil
+}
+
+// SetConversationRead advances a conversation's last-read position via
+// PostgREST RPC. The remote position never moves backwards.
+func (sw *Writer) SetConversationRead(convID string, lastRead time.Time) error {
+	return sw.rpc("set_conversation_read", map[string]interface{}{
+		"p_conversation_id": convID,
+		"p_last_read_at":    lastRead.UTC().Format(time.RFC3339Nano),
+	})
+}
+
+// ListConversationReads returns the last-read position of every conversation
+// that has one in Supabase, keyed by conversation ID.
+func (sw *Writer) ListConversationReads() (map[string]time.Time, error) {
+	req, err := http.NewRequest("GET", sw.url+"/rest/v1/conversations?select=conversation_id,last_read_at&last_read_at=not.is.null", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("apikey", sw.key)
+	req.Header.Set("Authorization", "Bearer "+sw.key)
+
+	resp, err := sw.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("list read positions: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		respBody, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("list read positions returned %d: %s", resp.StatusCode, string(respBody))
+	}
+	var rows []struct {
+		ConversationID string    `json:"conversation_id"`
+		LastReadAt     time.Time `json:"last_read_at"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&rows); err != nil {
+		return nil, fmt.Errorf("decode read positions: %w", err)
+	}
+	reads := make(map[string]time.Time, len(rows))
+	for _, r := range rows {
+		reads[r.ConversationID] = r.LastReadAt
+	}
+	return reads, nil
+}
+
 // --- Supabase Storage ---
 
 const storageBucket = "gmessages-media"
@@ -187,28 +517,24 @@ func (sw *Writer) ensureStorageBucket() {
 // UploadMedia uploads a file to Supabase Storage and returns its public URL.
 // path is relative within the bucket (e.g., "conversationid/filename.jpg").
 func (sw *Writer) UploadMedia(path string, data []byte, contentType string) (string, error) {
-	req, err := http.NewRequest("POST",
-		fmt.Sprintf("%s/storage/v1/object/%s/%s", sw.url, storageBucket, path),
-		bytes.NewReader(data),
-	)
+	_, err := sw.send("media upload", func() (*http.Request, error) {
+		req, err := http.NewRequest("POST",
+			fmt.Sprintf("%s/storage/v1/object/%s/%s", sw.url, storageBucket, path),
+			bytes.NewReader(data),
+		)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("apikey", sw.key)
+		req.Header.Set("Authorization", "Bearer "+sw.key)
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("x-upsert", "true")
+		return req, nil
+	})
 	if err != nil {
+		log.Printf("WARN: Supabase media upload failed (path=%s, %d bytes): %v", path, len(data), err)
 		return "", err
 	}
-	req.Header.Set("apikey", sw.key)
-	req.Header.Set("Authorization", "Bearer "+sw.key)
-	req.Header.Set("Content-Type", contentType)
-	req.Header.Set("x-upsert", "true")
-
-	resp, err := sw.client.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("media upload failed: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode >= 400 {
-		respBody, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("media upload returned %d: %s", resp.StatusCode, string(respBody))
-	}
 
 	publicURL := fmt.Sprintf("%s/storage/v1/object/public/%s/%s", sw.url, storageBucket, path)
 	return publicURL, nil
@@ -0,0 +1,186 @@
+package supabase
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testWriter returns a Writer for srv that retries quickly.
+func testWriter(srv *httptest.Server) *Writer {
+	return &Writer{url: srv.URL, key: "key", client: srv.Client(), retries: 3, retryBase: time.Millisecond}
+}
+
+func TestRPCRetriesTransientErrors(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) <= 2 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		if r.URL.Path != "/rest/v1/rpc/upsert_contact" {
+			t.Errorf("path = %s", r.URL.Path)
+		}
+	}))
+	defer srv.Close()
+
+	if err := testWriter(srv).UpsertContact("+15551234567", "Alice"); err != nil {
+		t.Fatalf("UpsertContact: %v", err)
+	}
+	if n := calls.Load(); n != 3 {
+		t.Errorf("calls = %d, want 3", n)
+	}
+}
+
+func TestRPCDoesNotRetryClientErrors(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		http.Error(w, "bad params", http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	err := testWriter(srv).UpsertContact("+15551234567", "Alice")
+	if err == nil || !strings.Contains(err.Error(), "returned 400") {
+		t.Errorf("err = %v, want 400 error", err)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("calls = %d, want 1", n)
+	}
+}
+
+func TestUploadMediaGivesUpAfterRetries(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	if _, err := testWriter(srv).UploadMedia("c1/a.jpg", []byte("jpeg"), "image/jpeg"); err == nil {
+		t.Error("expected error after exhausting retries")
+	}
+	if n := calls.Load(); n != 4 {
+		t.Errorf("calls = %d, want 4 (1 + 3 retries)", n)
+	}
+}
+
+func TestSummarizeParamsOmitsContent(t *testing.T) {
+	got := summarizeParams(map[string]interface{}{
+		"p_id":      "m1",
+		"p_content": "secret",
+		"p_name":    strings.Repeat("x", 50),
+	})
+	if strings.Contains(got, "secret") || !strings.HasPrefix(got, "p_id=m1 p_name=") || len(got) > 70 {
+		t.Errorf("summary = %q", got)
+	}
+}
+
+func TestUpsertMessagesBatchSendsArray(t *testing.T) {
+	var got struct {
+		Messages []map[string]any `json:"p_messages"`
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/rest/v1/rpc/upsert_messages" {
+			t.Errorf("path = %s", r.URL.Path)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("decode body: %v", err)
+		}
+	}))
+	defer srv.Close()
+
+	rows := make([]MessageRow, 0, 101)
+	for i := 0; i < 100; i++ {
+		rows = append(rows, MessageRow{ID: fmt.Sprintf("m%d", i), ConversationID: "c1", Content: "hi", Timestamp: time.UnixMilli(1000)})
+	}
+	rows = append(rows, MessageRow{ID: "empty", ConversationID: "c1"})
+
+	if err := testWriter(srv).UpsertMessagesBatch(rows); err != nil {
+		t.Fatalf("UpsertMessagesBatch: %v", err)
+	}
+	if len(got.Messages) != 100 {
+		t.Fatalf("sent %d rows, want 100 (empty row skipped)", len(got.Messages))
+	}
+	if got.Messages[0]["id"] != "m0" || got.Messages[0]["conversation_id"] != "c1" || got.Messages[0]["content"] != "hi" {
+		t.Errorf("first row = %v", got.Messages[0])
+	}
+	if s := summarizeParams(map[string]interface{}{"p_messages": rows}); s != "p_messages=[101 rows]" {
+		t.Errorf("summary = %q", s)
+	}
+}
+
+func TestUpsertMessageSendsReactionsAndReply(t *testing.T) {
+	var got map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("decode body: %v", err)
+		}
+	}))
+	defer srv.Close()
+
+	err := testWriter(srv).UpsertMessage("m2", "c1", "Alice", "+15551234567", "agreed", time.UnixMilli(2000), false, "", "", `[{"emoji":"👍","count":1}]`, "m1")
+	if err != nil {
+		t.Fatalf("UpsertMessage: %v", err)
+	}
+	if r, ok := got["p_reactions"].([]any); !ok || len(r) != 1 {
+		t.Errorf("p_reactions = %v", got["p_reactions"])
+	}
+	if got["p_reply_to_id"] != "m1" {
+		t.Errorf("p_reply_to_id = %v", got["p_reply_to_id"])
+	}
+}
+
+func TestReactionsJSON(t *testing.T) {
+	for in, want := range map[string]string{
+		"":                "[]",
+		"not json":        "[]",
+		`[{"emoji":"x"}]`: `[{"emoji":"x"}]`,
+	} {
+		if got := string(ReactionsJSON(in)); got != want {
+			t.Errorf("ReactionsJSON(%q) = %s, want %s", in, got, want)
+		}
+	}
+}
+
+func TestSetMessageStarredReportsUnsyncedMessage(t *testing.T) {
+	for _, tc := range []struct {
+		body string
+		want error
+	}{
+		{"true", nil},
+		{"false", ErrMessageNotSynced},
+		{"", nil}, // set_message_starred from before migration 007
+	} {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, tc.body)
+		}))
+		if err := testWriter(srv).SetMessageStarred("m1", "c1", true); err != tc.want {
+			t.Errorf("body %q: err = %v, want %v", tc.body, err, tc.want)
+		}
+		srv.Close()
+	}
+}
+
+func TestMessageStarsQuotesIDs(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.URL.Query().Get("id"); got != `in.("m1","a,\"b")` {
+			t.Errorf("id filter = %s", got)
+		}
+		fmt.Fprint(w, `[{"id":"m1","starred":false}]`)
+	}))
+	defer srv.Close()
+
+	stars, err := testWriter(srv).MessageStars([]string{"m1", `a,"b`})
+	if err != nil {
+		t.Fatalf("MessageStars: %v", err)
+	}
+	if starred, ok := stars["m1"]; !ok || starred || len(stars) != 1 {
+		t.Errorf("stars = %v, want only m1 unstarred", stars)
+	}
+}
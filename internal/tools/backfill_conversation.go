@@ -0,0 +1,49 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+
+	"github.com/maxghenis/openmessage/internal/app"
+)
+
+func backfillConversationTool() mcp.Tool {
+	return mcp.NewTool("backfill_conversation",
+		mcp.WithDescription("Pull a conversation's older history from the phone into the local archive, newest first. Use before get_messages when a thread looks incomplete."),
+		mcp.WithString("conversation_id", mcp.Required(), mcp.Description("Conversation ID")),
+		mcp.WithNumber("max_messages", mcp.Description("Stop after this many messages (default: the whole history)")),
+		mcp.WithBoolean("force", mcp.Description("Fetch again even if the whole history was already fetched (default false)")),
+		mcp.WithDestructiveHintAnnotation(false),
+		mcp.WithIdempotentHintAnnotation(true),
+	)
+}
+
+func backfillConversationHandler(a *app.App) server.ToolHandlerFunc {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args := req.GetArguments()
+		convID := strArg(args, "conversation_id")
+		if convID == "" {
+			return errorResult("conversation_id is required"), nil
+		}
+		maxMessages := intArg(args, "max_messages", 0)
+		if maxMessages < 0 {
+			return errorResult("max_messages must not be negative"), nil
+		}
+		if a.Client == nil {
+			return errorResult("not connected to Google Messages"), nil
+		}
+
+		force, _ := args["force"].(bool)
+		stored, err := a.BackfillConversation(convID, maxMessages, force)
+		if err != nil {
+			return errorResult(fmt.Sprintf("backfill failed after %d messages: %v", stored, err)), nil
+		}
+		if st, err := a.Store.GetBackfillState(convID); err == nil && st != nil && st.Complete && stored == 0 && !force {
+			return textResult(fmt.Sprintf("Conversation %s is already fully backfilled; set force to fetch it again.", convID)), nil
+		}
+		return textResult(fmt.Sprintf("Stored %d messages from conversation %s.", stored, convID)), nil
+	}
+}
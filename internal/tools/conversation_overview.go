@@ -0,0 +1,110 @@
+package tools
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+
+	"github.com/maxghenis/openmessage/internal/app"
+)
+
+func conversationOverviewTool() mcp.Tool {
+	return mcp.NewTool("conversation_overview",
+		mcp.WithDescription("Get everything needed to act on a conversation in one call: participants, message and unread counts, last activity, and the most recent messages"),
+		mcp.WithString("conversation_id", mcp.Required(), mcp.Description("The conversation ID")),
+		mcp.WithNumber("recent", mcp.Description("Number of recent messages to include (default 5)")),
+		mcp.WithReadOnlyHintAnnotation(true),
+		mcp.WithDestructiveHintAnnotation(false),
+	)
+}
+
+func conversationOverviewHandler(a *app.App) server.ToolHandlerFunc {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args := req.GetArguments()
+		convID := strArg(args, "conversation_id")
+		if convID == "" {
+			return errorResult("conversation_id is required"), nil
+		}
+		recent := intArg(args, "recent", 5)
+
+		conv, err := a.Store.GetConversation(convID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return errorResult(fmt.Sprintf("conversation %s not found", convID)), nil
+		}
+		if err != nil {
+			return errorResult(fmt.Sprintf("query failed: %v", err)), nil
+		}
+		stats, err := a.Store.GetConversationStats(convID)
+		if err != nil {
+			return errorResult(fmt.Sprintf("query failed: %v", err)), nil
+		}
+		msgs, err := a.Store.GetMessagesByConversation(convID, recent)
+		if err != nil {
+			return errorResult(fmt.Sprintf("query failed: %v", err)), nil
+		}
+
+		var sb strings.Builder
+		fmt.Fprintf(&sb, "Conversation: %s (ID: %s)\n", conv.Name, conv.ConversationID)
+		if conv.IsGroup {
+			sb.WriteString("Type: Group\n")
+		} else {
+			sb.WriteString("Type: 1:1\n")
+		}
+
+		sb.WriteString("Participants:\n")
+		var participants []struct {
+			Name   string `json:"name"`
+			Number string `json:"number"`
+			IsMe   bool   `json:"is_me,omitempty"`
+		}
+		json.Unmarshal([]byte(conv.Participants), &participants)
+		for _, p := range participants {
+			if p.IsMe {
+				continue
+			}
+			fmt.Fprintf(&sb, "- %s %s\n", p.Name, p.Number)
+		}
+
+		fmt.Fprintf(&sb, "Messages: %d (%d sent, %d received)\n",
+			stats.MessageCount, stats.SentCount, stats.MessageCount-stats.SentCount)
+		fmt.Fprintf(&sb, "Unread: %d\n", conv.UnreadCount)
+		if last := max(conv.LastMessageTS, stats.LastMessageTS); last > 0 {
+			fmt.Fprintf(&sb, "Last activity: %s\n", time.UnixMilli(last).Format(time.RFC3339))
+		} else {
+			sb.WriteString("Last activity: unknown\n")
+		}
+
+		if len(msgs) == 0 {
+			sb.WriteString("Recent messages: none\n")
+			return textResult(sb.String()), nil
+		}
+		sb.WriteString("Recent messages:\n---\n")
+		sb.WriteString(messagePreamble)
+		// Oldest first, so the newest message reads last.
+		for i := len(msgs) - 1; i >= 0; i-- {
+			m := msgs[i]
+			ts := time.UnixMilli(m.TimestampMS).Format(time.RFC3339)
+			direction := "←"
+			if m.IsFromMe {
+				direction = "→"
+			}
+			sender := m.SenderName
+			if sender == "" {
+				sender = m.SenderNumber
+			}
+			if sender == "" {
+				sender = "Unknown"
+			}
+			display := formatMessage(m)
+			fmt.Fprintf(&sb, "[%s] %s %s: «%s»\n", ts, direction, sender, display)
+		}
+		return textResult(sb.String()), nil
+	}
+}
@@ -0,0 +1,50 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+
+	"github.com/maxghenis/openmessage/internal/app"
+	"github.com/maxghenis/openmessage/internal/db"
+)
+
+func countMessagesTool() mcp.Tool {
+	return mcp.NewTool("count_messages",
+		mcp.WithDescription("Count messages matching a text search, without returning them. Cheaper than search_messages when only the number is needed."),
+		mcp.WithString("query", mcp.Required(), mcp.Description("Search text, matched like search_messages")),
+		mcp.WithString("phone_number", mcp.Description("Only count messages from this phone number")),
+		mcp.WithString("conversation_id", mcp.Description("Only count messages in this conversation")),
+		mcp.WithString("after", mcp.Description("Only messages on or after this ISO-8601 date (e.g., 2026-02-01)")),
+		mcp.WithString("before", mcp.Description("Only messages on or before this ISO-8601 date")),
+		mcp.WithReadOnlyHintAnnotation(true),
+		mcp.WithDestructiveHintAnnotation(false),
+	)
+}
+
+func countMessagesHandler(a *app.App) server.ToolHandlerFunc {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args := req.GetArguments()
+		query := strArg(args, "query")
+		if query == "" {
+			return errorResult("query is required"), nil
+		}
+		afterMS, beforeMS, err := dateRangeArgs(args)
+		if err != nil {
+			return errorResult(err.Error()), nil
+		}
+
+		n, err := a.Store.CountSearchMatches(query, db.SearchScope{
+			PhoneNumber:    strArg(args, "phone_number"),
+			ConversationID: strArg(args, "conversation_id"),
+			AfterMS:        afterMS,
+			BeforeMS:       beforeMS,
+		})
+		if err != nil {
+			return errorResult(fmt.Sprintf("count failed: %v", err)), nil
+		}
+		return textResult(fmt.Sprintf("%d messages match '%s'.", n, query)), nil
+	}
+}
@@ -0,0 +1,49 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+
+	"github.com/maxghenis/openmessage/internal/app"
+	"github.com/maxghenis/openmessage/internal/client"
+)
+
+func createGroupTool() mcp.Tool {
+	return mcp.NewTool("create_group",
+		mcp.WithDescription("Start a group conversation with several phone numbers, or open the existing one. Use send_message with the returned conversation_id to message it."),
+		mcp.WithArray("phone_numbers", mcp.Required(), mcp.WithStringItems(), mcp.Description("Members' phone numbers with country code (at least two)")),
+		mcp.WithString("name", mcp.Description("Group name")),
+		mcp.WithDestructiveHintAnnotation(false),
+		mcp.WithIdempotentHintAnnotation(false),
+	)
+}
+
+func createGroupHandler(a *app.App) server.ToolHandlerFunc {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args := req.GetArguments()
+		var numbers []string
+		if raw, ok := args["phone_numbers"].([]any); ok {
+			for _, v := range raw {
+				if n, ok := v.(string); ok && strings.TrimSpace(n) != "" {
+					numbers = append(numbers, n)
+				}
+			}
+		}
+		if len(numbers) < 2 {
+			return errorResult("phone_numbers needs at least two numbers"), nil
+		}
+		if a.Client == nil {
+			return errorResult("not connected to Google Messages"), nil
+		}
+
+		_, conv, err := client.StartConversation(a.Store, a.Client.GM, numbers, strings.TrimSpace(strArg(args, "name")))
+		if err != nil {
+			return errorResult(err.Error()), nil
+		}
+		return textResult(fmt.Sprintf("Group %s ready (ID: %s)", conv.Name, conv.ConversationID)), nil
+	}
+}
@@ -0,0 +1,55 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+
+	"github.com/maxghenis/openmessage/internal/app"
+	"github.com/maxghenis/openmessage/internal/client"
+)
+
+func deleteMessageTool() mcp.Tool {
+	return mcp.NewTool("delete_message",
+		mcp.WithDescription("Delete a message on the phone and from the local store. If not connected, only the local copy is deleted."),
+		mcp.WithString("message_id", mcp.Required(), mcp.Description("ID of the message to delete")),
+		mcp.WithDestructiveHintAnnotation(true),
+		mcp.WithIdempotentHintAnnotation(false),
+	)
+}
+
+func deleteMessageHandler(a *app.App) server.ToolHandlerFunc {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		messageID := strArg(req.GetArguments(), "message_id")
+		if messageID == "" {
+			return errorResult("message_id is required"), nil
+		}
+
+		var gm client.MessageDeleter
+		if a.Client != nil {
+			gm = a.Client.GM
+		}
+		res, err := client.DeleteMessage(a.Store, gm, messageID)
+		if errors.Is(err, client.ErrMessageNotFound) {
+			return errorResult("message not found: " + messageID), nil
+		}
+		if err != nil {
+			return errorResult(fmt.Sprintf("failed to delete: %v", err)), nil
+		}
+
+		var sb strings.Builder
+		fmt.Fprintf(&sb, "Deleted message %s", messageID)
+		if res.RemoteSkipped {
+			sb.WriteString(" locally only (not deleted on the phone)")
+		}
+		sb.WriteString(".")
+		if len(res.AffectedReplies) > 0 {
+			fmt.Fprintf(&sb, "\nReplies that quoted it: %s", strings.Join(res.AffectedReplies, ", "))
+		}
+		return textResult(sb.String()), nil
+	}
+}
@@ -0,0 +1,45 @@
+package tools
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+
+	"github.com/maxghenis/openmessage/internal/app"
+	"github.com/maxghenis/openmessage/internal/client"
+)
+
+func exportConversationTool() mcp.Tool {
+	return mcp.NewTool("export_conversation",
+		mcp.WithDescription("Export a conversation and all its stored messages as JSON, oldest first: sender, timestamp, direction, body, and whether each message has media."),
+		mcp.WithString("conversation_id", mcp.Required(), mcp.Description("Conversation ID")),
+		mcp.WithReadOnlyHintAnnotation(true),
+	)
+}
+
+func exportConversationHandler(a *app.App) server.ToolHandlerFunc {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		convID := strArg(req.GetArguments(), "conversation_id")
+		if convID == "" {
+			return errorResult("conversation_id is required"), nil
+		}
+		conv, err := a.Store.GetConversation(convID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return errorResult("conversation not found: " + convID), nil
+		}
+		if err != nil {
+			return errorResult(fmt.Sprintf("get conversation: %v", err)), nil
+		}
+
+		var out strings.Builder
+		if err := client.WriteConversationJSON(&out, a.Store, conv); err != nil {
+			return errorResult(fmt.Sprintf("export: %v", err)), nil
+		}
+		return textResult(out.String()), nil
+	}
+}
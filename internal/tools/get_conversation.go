@@ -10,6 +10,8 @@ import (
 	"github.com/mark3labs/mcp-go/server"
 
 	"github.com/maxghenis/openmessage/internal/app"
+	"github.com/maxghenis/openmessage/internal/client"
+	"github.com/maxghenis/openmessage/internal/db"
 )
 
 func getConversationTool() mcp.Tool {
@@ -17,7 +19,8 @@ func getConversationTool() mcp.Tool {
 		mcp.WithDescription("Get messages in a specific conversation by ID"),
 		mcp.WithString("conversation_id", mcp.Required(), mcp.Description("The conversation ID")),
 		mcp.WithNumber("limit", mcp.Description("Maximum messages to return (default 50)")),
-		mcp.WithReadOnlyHintAnnotation(true),
+		mcp.WithBoolean("mark_read", mcp.Description("Also mark the conversation as read (default false)")),
+		mcp.WithReadOnlyHintAnnotation(false),
 		mcp.WithDestructiveHintAnnotation(false),
 	)
 }
@@ -36,6 +39,16 @@ func getConversationHandler(a *app.App) server.ToolHandlerFunc {
 			return errorResult(fmt.Sprintf("query failed: %v", err)), nil
 		}
 
+		if markRead, _ := args["mark_read"].(bool); markRead {
+			var gm client.ReadMarker
+			if a.Client != nil {
+				gm = a.Client.GM
+			}
+			if err := client.MarkConversationRead(a.Store, gm, a.SupabaseSync(), convID, msgs, a.SendReadReceipts); err != nil {
+				a.Logger.Warn().Err(err).Str("conv_id", convID).Msg("Failed to mark conversation read")
+			}
+		}
+
 		if len(msgs) == 0 {
 			return textResult("No messages found in this conversation."), nil
 		}
@@ -48,6 +61,9 @@ func getConversationHandler(a *app.App) server.ToolHandlerFunc {
 			if conv.IsGroup {
 				sb.WriteString("Type: Group\n")
 			}
+			if ps, err := a.Store.ConversationParticipants(convID); err == nil {
+				writeParticipants(&sb, ps)
+			}
 			sb.WriteString("---\n")
 		}
 
@@ -65,9 +81,28 @@ func getConversationHandler(a *app.App) server.ToolHandlerFunc {
 			if sender == "" {
 				sender = "Unknown"
 			}
-			display := formatMessageBody(m.Body, m.MediaID, m.MimeType, m.MessageID)
+			display := formatMessage(m)
 			fmt.Fprintf(&sb, "[%s] %s %s: «%s»\n", ts, direction, sender, display)
 		}
 		return textResult(sb.String()), nil
 	}
 }
+
+// writeParticipants lists participants other than me, showing the saved
+// contact name for known numbers and marking the rest unknown.
+func writeParticipants(sb *strings.Builder, ps []*db.Participant) {
+	var names []string
+	for _, p := range ps {
+		if p.IsMe {
+			continue
+		}
+		if p.Known {
+			names = append(names, fmt.Sprintf("%s (%s)", p.ContactName, p.Number))
+		} else {
+			names = append(names, fmt.Sprintf("Unknown (%s)", p.Number))
+		}
+	}
+	if len(names) > 0 {
+		fmt.Fprintf(sb, "Participants: %s\n", strings.Join(names, ", "))
+	}
+}
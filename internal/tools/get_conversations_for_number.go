@@ -0,0 +1,51 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+
+	"github.com/maxghenis/openmessage/internal/app"
+)
+
+func getConversationsForNumberTool() mcp.Tool {
+	return mcp.NewTool("get_conversations_for_number",
+		mcp.WithDescription("List every conversation (1:1 and group) that includes a phone number, most recent first"),
+		mcp.WithString("phone_number", mcp.Required(), mcp.Description("Phone number to look up; formatting is ignored")),
+		mcp.WithReadOnlyHintAnnotation(true),
+		mcp.WithDestructiveHintAnnotation(false),
+	)
+}
+
+func getConversationsForNumberHandler(a *app.App) server.ToolHandlerFunc {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		phone := strArg(req.GetArguments(), "phone_number")
+		if phone == "" {
+			return errorResult("phone_number is required"), nil
+		}
+
+		convs, err := a.Store.ConversationsForNumber(phone)
+		if err != nil {
+			return errorResult(fmt.Sprintf("query failed: %v", err)), nil
+		}
+		if len(convs) == 0 {
+			return textResult("No conversations found with " + phone + "."), nil
+		}
+
+		var sb strings.Builder
+		fmt.Fprintf(&sb, "%d conversations with %s:\n\n", len(convs), phone)
+		for _, c := range convs {
+			kind := "1:1"
+			if c.IsGroup {
+				kind = "group"
+			}
+			ts := time.UnixMilli(c.LastMessageTS).Format(time.RFC3339)
+			fmt.Fprintf(&sb, "- %s [%s] (ID: %s, last: %s)\n", c.Name, kind, c.ConversationID, ts)
+		}
+		return textResult(sb.String()), nil
+	}
+}
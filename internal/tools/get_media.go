@@ -0,0 +1,90 @@
+package tools
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+
+	"github.com/maxghenis/openmessage/internal/app"
+	"github.com/maxghenis/openmessage/internal/db"
+)
+
+// maxInlineMediaBytes caps the images get_media returns inline, so one large
+// photo doesn't flood the context.
+const maxInlineMediaBytes = 5 << 20
+
+func getMediaTool() mcp.Tool {
+	return mcp.NewTool("get_media",
+		mcp.WithDescription("Get the image attached to a message so you can see it. Other attachments (voice messages, videos, files) are summarized with their type and size; use download_media to save them."),
+		mcp.WithString("message_id", mcp.Required(), mcp.Description("The message ID containing the media")),
+		mcp.WithReadOnlyHintAnnotation(true),
+		mcp.WithDestructiveHintAnnotation(false),
+	)
+}
+
+func getMediaHandler(a *app.App) server.ToolHandlerFunc {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args := req.GetArguments()
+		msgID := strArg(args, "message_id")
+		if msgID == "" {
+			return errorResult("message_id is required"), nil
+		}
+
+		msg, err := a.Store.GetMessageByID(msgID)
+		if err != nil {
+			return errorResult(fmt.Sprintf("get message: %v", err)), nil
+		}
+		if msg == nil {
+			return errorResult("message not found"), nil
+		}
+		if msg.MediaID == "" {
+			return errorResult("this message has no media attachment"), nil
+		}
+		if !strings.HasPrefix(msg.MimeType, "image/") {
+			size := "size unknown"
+			if msg.MediaSize > 0 {
+				size = fmt.Sprintf("%d bytes", msg.MediaSize)
+			}
+			return textResult(fmt.Sprintf("%s attachment (%s). Not an image, so it can't be shown inline; use download_media with message_id %s to save it.", msg.MimeType, size, msgID)), nil
+		}
+		if msg.MediaSize > maxInlineMediaBytes {
+			return errorResult(tooLargeMessage(msg.MediaSize)), nil
+		}
+
+		if a.Client == nil {
+			return errorResult("not connected to Google Messages"), nil
+		}
+		return inlineImage(attachments, a.Client.GM, msg), nil
+	}
+}
+
+// inlineImage downloads msg's image through cache and returns it as an image
+// content block, or an error result if it's over maxInlineMediaBytes. The
+// phone doesn't always report sizes, so the limit is checked again here.
+func inlineImage(cache *mediaCache, gm mediaDownloader, msg *db.Message) *mcp.CallToolResult {
+	data, err := cache.get(gm, msg)
+	if err != nil {
+		return errorResult(err.Error())
+	}
+	if len(data) > maxInlineMediaBytes {
+		return errorResult(tooLargeMessage(int64(len(data))))
+	}
+	caption := fmt.Sprintf("%s from %s (%d bytes)", msg.MimeType, senderLabel(msg), len(data))
+	if msg.Body != "" {
+		caption += ": " + msg.Body
+	}
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			mcp.NewTextContent(caption),
+			mcp.NewImageContent(base64.StdEncoding.EncodeToString(data), msg.MimeType),
+		},
+	}
+}
+
+func tooLargeMessage(size int64) string {
+	return fmt.Sprintf("image is %d bytes, over the %d byte limit for inline media; use download_media to save it", size, maxInlineMediaBytes)
+}
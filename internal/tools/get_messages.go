@@ -10,6 +10,7 @@ import (
 	"github.com/mark3labs/mcp-go/server"
 
 	"github.com/maxghenis/openmessage/internal/app"
+	"github.com/maxghenis/openmessage/internal/db"
 )
 
 func getMessagesTool() mcp.Tool {
@@ -18,6 +19,7 @@ func getMessagesTool() mcp.Tool {
 		mcp.WithString("phone_number", mcp.Description("Filter by sender phone number")),
 		mcp.WithString("after", mcp.Description("Only messages after this ISO-8601 date (e.g., 2026-02-01)")),
 		mcp.WithString("before", mcp.Description("Only messages before this ISO-8601 date")),
+		mcp.WithString("filter", mcp.Description("Only messages containing a link or an attachment"), mcp.Enum("link", "media")),
 		mcp.WithNumber("limit", mcp.Description("Maximum messages to return (default 20)")),
 		mcp.WithReadOnlyHintAnnotation(true),
 		mcp.WithDestructiveHintAnnotation(false),
@@ -30,23 +32,23 @@ func getMessagesHandler(a *app.App) server.ToolHandlerFunc {
 		phone := strArg(args, "phone_number")
 		limit := intArg(args, "limit", 20)
 
-		var afterMS, beforeMS int64
-		if after := strArg(args, "after"); after != "" {
-			t, err := time.Parse("2006-01-02", after)
-			if err != nil {
-				return errorResult(fmt.Sprintf("invalid 'after' date: %v", err)), nil
-			}
-			afterMS = t.UnixMilli()
+		afterMS, beforeMS, err := dateRangeArgs(args)
+		if err != nil {
+			return errorResult(err.Error()), nil
 		}
-		if before := strArg(args, "before"); before != "" {
-			t, err := time.Parse("2006-01-02", before)
-			if err != nil {
-				return errorResult(fmt.Sprintf("invalid 'before' date: %v", err)), nil
-			}
-			beforeMS = t.Add(24*time.Hour - time.Millisecond).UnixMilli()
+
+		var filter db.MessageFilter
+		switch f := strArg(args, "filter"); f {
+		case "":
+		case "link":
+			filter.HasLink = true
+		case "media":
+			filter.HasMedia = true
+		default:
+			return errorResult(fmt.Sprintf("invalid filter %q: use link or media", f)), nil
 		}
 
-		msgs, err := a.Store.GetMessages(phone, afterMS, beforeMS, limit)
+		msgs, err := a.Store.GetMessagesFiltered(phone, afterMS, beforeMS, limit, filter)
 		if err != nil {
 			return errorResult(fmt.Sprintf("query failed: %v", err)), nil
 		}
@@ -70,7 +72,7 @@ func getMessagesHandler(a *app.App) server.ToolHandlerFunc {
 			if sender == "" {
 				sender = "Unknown"
 			}
-			display := formatMessageBody(m.Body, m.MediaID, m.MimeType, m.MessageID)
+			display := formatMessage(m)
 			fmt.Fprintf(&sb, "[%s] %s %s: «%s»\n", ts, direction, sender, display)
 		}
 		return textResult(sb.String()), nil
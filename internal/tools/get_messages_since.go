@@ -0,0 +1,65 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+
+	"github.com/maxghenis/openmessage/internal/app"
+	"github.com/maxghenis/openmessage/internal/db"
+)
+
+func getMessagesSinceTool() mcp.Tool {
+	return mcp.NewTool("get_messages_since",
+		mcp.WithDescription("Get messages in a conversation that came after a given message, oldest first. Pass the last message_id returned to follow the conversation."),
+		mcp.WithString("conversation_id", mcp.Required(), mcp.Description("The conversation ID")),
+		mcp.WithString("message_id", mcp.Required(), mcp.Description("Return messages after this one")),
+		mcp.WithNumber("limit", mcp.Description("Maximum messages to return (default 50)")),
+		mcp.WithReadOnlyHintAnnotation(true),
+		mcp.WithDestructiveHintAnnotation(false),
+	)
+}
+
+func getMessagesSinceHandler(a *app.App) server.ToolHandlerFunc {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args := req.GetArguments()
+		convID := strArg(args, "conversation_id")
+		afterID := strArg(args, "message_id")
+		if convID == "" || afterID == "" {
+			return errorResult("conversation_id and message_id are required"), nil
+		}
+		limit := intArg(args, "limit", 50)
+
+		msgs, err := a.Store.GetMessagesAfterID(convID, afterID, limit)
+		if errors.Is(err, db.ErrMessageNotFound) {
+			return errorResult(fmt.Sprintf("message %s not found in conversation %s", afterID, convID)), nil
+		}
+		if err != nil {
+			return errorResult(fmt.Sprintf("query failed: %v", err)), nil
+		}
+		if len(msgs) == 0 {
+			return textResult("No newer messages."), nil
+		}
+
+		var sb strings.Builder
+		sb.WriteString(messagePreamble)
+		for _, m := range msgs {
+			ts := time.UnixMilli(m.TimestampMS).Format(time.RFC3339)
+			sender := m.SenderName
+			if m.IsFromMe {
+				sender = "Me"
+			}
+			if sender == "" {
+				sender = m.SenderNumber
+			}
+			display := formatMessage(m)
+			fmt.Fprintf(&sb, "[%s] (%s) %s: «%s»\n", ts, m.MessageID, sender, display)
+		}
+		return textResult(sb.String()), nil
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/mark3labs/mcp-go/server"
 
 	"github.com/maxghenis/openmessage/internal/app"
+	"github.com/maxghenis/openmessage/internal/client"
 )
 
 func getStatusTool() mcp.Tool {
@@ -26,6 +27,7 @@ func getStatusHandler(a *app.App) server.ToolHandlerFunc {
 		if a.Client == nil {
 			sb.WriteString("Status: not connected\n")
 			sb.WriteString("Run 'gmessages-mcp pair' to connect.\n")
+			writePairingInfo(&sb, a)
 			return textResult(sb.String()), nil
 		}
 
@@ -51,8 +53,23 @@ func getStatusHandler(a *app.App) server.ToolHandlerFunc {
 			fmt.Fprintf(&sb, "Session ID: %s\n", ad.SessionID.String())
 		}
 
+		writePairingInfo(&sb, a)
 		fmt.Fprintf(&sb, "Data dir: %s\n", a.DataDir)
 
 		return textResult(sb.String()), nil
 	}
 }
+
+func writePairingInfo(sb *strings.Builder, a *app.App) {
+	if a.PairingPath == "" {
+		return
+	}
+	info, err := client.LoadPairingInfo(a.PairingPath)
+	if err != nil || info == nil {
+		return
+	}
+	fmt.Fprintf(sb, "Paired with phone %s on %s\n", info.PhoneID, info.PairedAt.Format("2006-01-02"))
+	if info.PhoneChanged() {
+		fmt.Fprintf(sb, "Note: replaced previously paired phone %s\n", info.PreviousPhoneID)
+	}
+}
@@ -0,0 +1,54 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+
+	"github.com/maxghenis/openmessage/internal/app"
+)
+
+func getUnreadTool() mcp.Tool {
+	return mcp.NewTool("get_unread",
+		mcp.WithDescription("Summarize what I've missed: conversations with unread messages, most recent first, with how many are unread and the latest message in each. Muted conversations are left out."),
+		mcp.WithNumber("limit", mcp.Description("Maximum conversations to return (default 20)")),
+		mcp.WithReadOnlyHintAnnotation(true),
+		mcp.WithDestructiveHintAnnotation(false),
+	)
+}
+
+func getUnreadHandler(a *app.App) server.ToolHandlerFunc {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		limit := intArg(req.GetArguments(), "limit", 20)
+
+		convs, err := a.Store.ListUnreadConversations(limit)
+		if err != nil {
+			return errorResult(fmt.Sprintf("query failed: %v", err)), nil
+		}
+		if len(convs) == 0 {
+			return textResult("No unread messages."), nil
+		}
+
+		total := 0
+		var sb strings.Builder
+		for _, c := range convs {
+			total += c.UnreadCount
+			ts := time.UnixMilli(c.LastMessageTS).Format(time.RFC3339)
+			fmt.Fprintf(&sb, "- %s: %d unread (ID: %s, last: %s)\n", c.Name, c.UnreadCount, c.ConversationID, ts)
+			from := c.LastSender
+			if c.LastFromMe {
+				from = "Me"
+			}
+			if from != "" {
+				fmt.Fprintf(&sb, "  %s: %s\n", from, c.LastSnippet)
+			} else {
+				fmt.Fprintf(&sb, "  %s\n", c.LastSnippet)
+			}
+		}
+		return textResult(fmt.Sprintf("%d unread messages in %d conversations:\n\n%s", total, len(convs), sb.String())), nil
+	}
+}
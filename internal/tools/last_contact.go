@@ -0,0 +1,75 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+
+	"github.com/maxghenis/openmessage/internal/app"
+)
+
+func lastContactTool() mcp.Tool {
+	return mcp.NewTool("last_contact",
+		mcp.WithDescription("Show when you last exchanged a message with each contact, longest ago first. Useful for finding people you haven't talked to in a while."),
+		mcp.WithString("phone_number", mcp.Description("Only report this contact's number")),
+		mcp.WithNumber("limit", mcp.Description("Maximum contacts to return (default 20)")),
+		mcp.WithReadOnlyHintAnnotation(true),
+		mcp.WithDestructiveHintAnnotation(false),
+	)
+}
+
+func lastContactHandler(a *app.App) server.ToolHandlerFunc {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args := req.GetArguments()
+		number := strArg(args, "phone_number")
+		limit := intArg(args, "limit", 20)
+
+		activity, err := a.Store.ListContactActivity(number, limit)
+		if err != nil {
+			return errorResult(fmt.Sprintf("query failed: %v", err)), nil
+		}
+		if len(activity) == 0 {
+			if number != "" {
+				return textResult("No contact found with number " + number + "."), nil
+			}
+			return textResult("No contacts found."), nil
+		}
+
+		now := time.Now()
+		var sb strings.Builder
+		fmt.Fprintf(&sb, "Last contact for %d contacts:\n\n", len(activity))
+		for _, c := range activity {
+			if c.LastMessageTS == 0 {
+				fmt.Fprintf(&sb, "- %s (%s): no messages\n", c.Name, c.Number)
+				continue
+			}
+			ts := time.UnixMilli(c.LastMessageTS)
+			fmt.Fprintf(&sb, "- %s (%s): %s (%s)\n", c.Name, c.Number, ts.Format(time.RFC3339), formatElapsed(now.Sub(ts)))
+		}
+		return textResult(sb.String()), nil
+	}
+}
+
+// formatElapsed renders a duration as a coarse "N units ago" string.
+func formatElapsed(d time.Duration) string {
+	plural := func(n int, unit string) string {
+		if n == 1 {
+			return fmt.Sprintf("1 %s ago", unit)
+		}
+		return fmt.Sprintf("%d %ss ago", n, unit)
+	}
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return plural(int(d/time.Minute), "minute")
+	case d < 24*time.Hour:
+		return plural(int(d/time.Hour), "hour")
+	default:
+		return plural(int(d/(24*time.Hour)), "day")
+	}
+}
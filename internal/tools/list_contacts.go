@@ -4,19 +4,25 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"sync/atomic"
 
 	"github.com/mark3labs/mcp-go/mcp"
 	"github.com/mark3labs/mcp-go/server"
 
 	"github.com/maxghenis/openmessage/internal/app"
+	"github.com/maxghenis/openmessage/internal/client"
 	"github.com/maxghenis/openmessage/internal/db"
 )
 
+// contactThumbnailBatch is how many contact photos are asked for at once.
+const contactThumbnailBatch = 50
+
 func listContactsTool() mcp.Tool {
 	return mcp.NewTool("list_contacts",
 		mcp.WithDescription("List or search contacts by name or phone number"),
 		mcp.WithString("query", mcp.Description("Search by name or number")),
 		mcp.WithNumber("limit", mcp.Description("Maximum contacts to return (default 50)")),
+		mcp.WithString("sort", mcp.Description("Order: 'name' (default) or 'recent' for most recently messaged first")),
 		mcp.WithReadOnlyHintAnnotation(true),
 		mcp.WithDestructiveHintAnnotation(false),
 	)
@@ -27,6 +33,10 @@ func listContactsHandler(a *app.App) server.ToolHandlerFunc {
 		args := req.GetArguments()
 		query := strArg(args, "query")
 		limit := intArg(args, "limit", 50)
+		sort := strArg(args, "sort")
+		if sort != "" && sort != "name" && sort != "recent" {
+			return errorResult("sort must be 'name' or 'recent'"), nil
+		}
 
 		// If no contacts in DB yet, try fetching from phone
 		contacts, err := a.Store.ListContacts("", 1)
@@ -36,7 +46,11 @@ func listContactsHandler(a *app.App) server.ToolHandlerFunc {
 			}
 		}
 
-		contacts, err = a.Store.ListContacts(query, limit)
+		if sort == "recent" {
+			contacts, err = a.Store.ListRecentContacts(query, limit)
+		} else {
+			contacts, err = a.Store.ListContacts(query, limit)
+		}
 		if err != nil {
 			return errorResult(fmt.Sprintf("query failed: %v", err)), nil
 		}
@@ -74,6 +88,7 @@ func fetchAndCacheContacts(a *app.App) error {
 	if err != nil {
 		return err
 	}
+	numbers := map[string]string{}
 	for _, c := range resp.GetContacts() {
 		number := ""
 		if n := c.GetNumber(); n != nil {
@@ -87,6 +102,41 @@ func fetchAndCacheContacts(a *app.App) error {
 		if err := a.Store.UpsertContact(contact); err != nil {
 			a.Logger.Warn().Err(err).Str("id", contact.ContactID).Msg("Failed to cache contact")
 		}
+		if contact.ContactID != "" && number != "" {
+			numbers[contact.ContactID] = number
+		}
+	}
+	if cachingAvatars.CompareAndSwap(false, true) {
+		go func() {
+			defer cachingAvatars.Store(false)
+			cacheContactAvatars(a, numbers)
+		}()
 	}
 	return nil
 }
+
+// cachingAvatars is set while cacheContactAvatars runs in the background, so
+// overlapping contact fetches don't ask the phone for the same photos.
+var cachingAvatars atomic.Bool
+
+// cacheContactAvatars fetches the photos of the contacts in numbers, keyed
+// by contact ID, and stores them as avatars. Failures are only logged, since
+// contacts without photos still list fine.
+func cacheContactAvatars(a *app.App, numbers map[string]string) {
+	ids := make([]string, 0, len(numbers))
+	for id := range numbers {
+		ids = append(ids, id)
+	}
+	for len(ids) > 0 {
+		batch := ids[:min(len(ids), contactThumbnailBatch)]
+		ids = ids[len(batch):]
+		resp, err := a.Client.GM.GetContactThumbnail(batch...)
+		if err != nil {
+			a.Logger.Warn().Err(err).Msg("Failed to fetch contact photos")
+			return
+		}
+		if _, err := client.StoreThumbnails(a.Store, resp, numbers); err != nil {
+			a.Logger.Warn().Err(err).Msg("Failed to cache contact photos")
+		}
+	}
+}
@@ -14,8 +14,10 @@ import (
 
 func listConversationsTool() mcp.Tool {
 	return mcp.NewTool("list_conversations",
-		mcp.WithDescription("List recent conversations, sorted by most recent message"),
+		mcp.WithDescription("List recent conversations, pinned first (marked 📌), then by most recent message"),
 		mcp.WithNumber("limit", mcp.Description("Maximum conversations to return (default 20)")),
+		mcp.WithString("active_since", mcp.Description("Only conversations with a message on or after this ISO-8601 date (e.g., 2026-02-01) or RFC 3339 timestamp")),
+		mcp.WithBoolean("include_archived", mcp.Description("Also list archived conversations (default false)")),
 		mcp.WithReadOnlyHintAnnotation(true),
 		mcp.WithDestructiveHintAnnotation(false),
 	)
@@ -26,7 +28,20 @@ func listConversationsHandler(a *app.App) server.ToolHandlerFunc {
 		args := req.GetArguments()
 		limit := intArg(args, "limit", 20)
 
-		convs, err := a.Store.ListConversations(limit)
+		var sinceMS int64
+		if since := strArg(args, "active_since"); since != "" {
+			t, err := parseTimeArg(since)
+			if err != nil {
+				return errorResult(fmt.Sprintf("invalid 'active_since': %v", err)), nil
+			}
+			sinceMS = t.UnixMilli()
+		}
+
+		list := a.Store.ListConversationsActiveSince
+		if includeArchived, _ := args["include_archived"].(bool); includeArchived {
+			list = a.Store.ListConversationsIncludingArchived
+		}
+		convs, err := list(sinceMS, limit)
 		if err != nil {
 			return errorResult(fmt.Sprintf("query failed: %v", err)), nil
 		}
@@ -43,11 +58,21 @@ func listConversationsHandler(a *app.App) server.ToolHandlerFunc {
 			if c.IsGroup {
 				group = " [group]"
 			}
+			if c.Archived {
+				group += " [archived]"
+			}
+			if c.Muted {
+				group += " [muted]"
+			}
 			unread := ""
 			if c.UnreadCount > 0 {
 				unread = fmt.Sprintf(" (%d unread)", c.UnreadCount)
 			}
-			fmt.Fprintf(&sb, "- %s%s%s (ID: %s, last: %s)\n", c.Name, group, unread, c.ConversationID, ts)
+			pin := ""
+			if c.Pinned {
+				pin = "📌 "
+			}
+			fmt.Fprintf(&sb, "- %s%s%s%s (ID: %s, last: %s)\n", pin, c.Name, group, unread, c.ConversationID, ts)
 		}
 		return textResult(sb.String()), nil
 	}
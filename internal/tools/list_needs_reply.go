@@ -0,0 +1,48 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+
+	"github.com/maxghenis/openmessage/internal/app"
+)
+
+func listNeedsReplyTool() mcp.Tool {
+	return mcp.NewTool("list_needs_reply",
+		mcp.WithDescription("List conversations whose latest message is from someone else and hasn't been answered, most recent first"),
+		mcp.WithNumber("limit", mcp.Description("Maximum conversations to return (default 20)")),
+		mcp.WithReadOnlyHintAnnotation(true),
+		mcp.WithDestructiveHintAnnotation(false),
+	)
+}
+
+func listNeedsReplyHandler(a *app.App) server.ToolHandlerFunc {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		limit := intArg(req.GetArguments(), "limit", 20)
+
+		convs, err := a.Store.ListConversationsNeedingReply(limit)
+		if err != nil {
+			return errorResult(fmt.Sprintf("query failed: %v", err)), nil
+		}
+		if len(convs) == 0 {
+			return textResult("No conversations are waiting for a reply."), nil
+		}
+
+		var sb strings.Builder
+		fmt.Fprintf(&sb, "%d conversations need a reply:\n\n", len(convs))
+		for _, c := range convs {
+			ts := time.UnixMilli(c.LastMessageTS).Format(time.RFC3339)
+			unread := ""
+			if c.UnreadCount > 0 {
+				unread = fmt.Sprintf(" (%d unread)", c.UnreadCount)
+			}
+			fmt.Fprintf(&sb, "- %s%s (ID: %s, last: %s)\n", c.Name, unread, c.ConversationID, ts)
+		}
+		return textResult(sb.String()), nil
+	}
+}
@@ -0,0 +1,62 @@
+package tools
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+
+	"github.com/maxghenis/openmessage/internal/app"
+	"github.com/maxghenis/openmessage/internal/client"
+)
+
+// markReadReceiptWindow is how many recent messages mark_read looks through
+// for one with a server ID to send the read receipt for.
+const markReadReceiptWindow = 20
+
+func markReadTool() mcp.Tool {
+	return mcp.NewTool("mark_read",
+		mcp.WithDescription("Mark a conversation as read, clearing its unread count. The phone is told too when read receipts are enabled."),
+		mcp.WithString("conversation_id", mcp.Required(), mcp.Description("The conversation ID")),
+		mcp.WithReadOnlyHintAnnotation(false),
+		mcp.WithDestructiveHintAnnotation(false),
+		mcp.WithIdempotentHintAnnotation(true),
+	)
+}
+
+func markReadHandler(a *app.App) server.ToolHandlerFunc {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		convID := strArg(req.GetArguments(), "conversation_id")
+		if convID == "" {
+			return errorResult("conversation_id is required"), nil
+		}
+
+		conv, err := a.Store.GetConversation(convID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return textResult(fmt.Sprintf("Conversation %s is not stored locally; nothing to mark read.", convID)), nil
+		}
+		if err != nil {
+			return errorResult(fmt.Sprintf("query failed: %v", err)), nil
+		}
+
+		msgs, err := a.Store.GetMessagesByConversation(convID, markReadReceiptWindow)
+		if err != nil {
+			return errorResult(fmt.Sprintf("query failed: %v", err)), nil
+		}
+		var gm client.ReadMarker
+		if a.Client != nil {
+			gm = a.Client.GM
+		}
+		if err := client.MarkConversationRead(a.Store, gm, a.SupabaseSync(), convID, msgs, a.SendReadReceipts); err != nil {
+			return errorResult(err.Error()), nil
+		}
+
+		if conv, err = a.Store.GetConversation(convID); err != nil {
+			return errorResult(fmt.Sprintf("query failed: %v", err)), nil
+		}
+		return textResult(fmt.Sprintf("Marked %s (ID: %s) as read. Unread: %d", conv.Name, convID, conv.UnreadCount)), nil
+	}
+}
@@ -0,0 +1,174 @@
+package tools
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+
+	"github.com/maxghenis/openmessage/internal/app"
+	"github.com/maxghenis/openmessage/internal/db"
+)
+
+const (
+	// mediaURIPrefix is followed by a message ID to name its attachment.
+	mediaURIPrefix = "gmessages://media/"
+
+	// mediaResourceLimit is how many recent media messages resources/list shows.
+	mediaResourceLimit = 50
+
+	// mediaCacheTTL is how long downloaded attachments are kept, so a client
+	// reading the same resource repeatedly doesn't re-fetch it from the phone.
+	mediaCacheTTL = 5 * time.Minute
+
+	// mediaCacheEntries bounds the cache; the oldest entry is evicted first.
+	mediaCacheEntries = 20
+)
+
+// mediaDownloader is the subset of *libgm.Client used to fetch attachments.
+type mediaDownloader interface {
+	DownloadMedia(mediaID string, key []byte) ([]byte, error)
+}
+
+type cachedMedia struct {
+	data    []byte
+	fetched time.Time
+}
+
+// mediaCache keeps recently downloaded attachments by message ID.
+type mediaCache struct {
+	mu      sync.Mutex
+	entries map[string]cachedMedia
+}
+
+func newMediaCache() *mediaCache {
+	return &mediaCache{entries: map[string]cachedMedia{}}
+}
+
+// attachments caches downloads for all media resource reads.
+var attachments = newMediaCache()
+
+// get returns the attachment of msg, downloading it with gm on a cache miss.
+func (c *mediaCache) get(gm mediaDownloader, msg *db.Message) ([]byte, error) {
+	now := time.Now()
+	c.mu.Lock()
+	if e, ok := c.entries[msg.MessageID]; ok && now.Sub(e.fetched) < mediaCacheTTL {
+		c.mu.Unlock()
+		return e.data, nil
+	}
+	c.mu.Unlock()
+
+	key, err := hex.DecodeString(msg.DecryptionKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid decryption key: %w", err)
+	}
+	data, err := gm.DownloadMedia(msg.MediaID, key)
+	if err != nil {
+		return nil, fmt.Errorf("download media: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for id, e := range c.entries {
+		if now.Sub(e.fetched) >= mediaCacheTTL {
+			delete(c.entries, id)
+		}
+	}
+	if len(c.entries) >= mediaCacheEntries {
+		var oldest string
+		for id, e := range c.entries {
+			if oldest == "" || e.fetched.Before(c.entries[oldest].fetched) {
+				oldest = id
+			}
+		}
+		delete(c.entries, oldest)
+	}
+	c.entries[msg.MessageID] = cachedMedia{data: data, fetched: now}
+	return data, nil
+}
+
+func mediaResourceTemplate() mcp.ResourceTemplate {
+	return mcp.NewResourceTemplate(mediaURIPrefix+"{message_id}", "Message attachment",
+		mcp.WithTemplateDescription("The decrypted image, video, audio or file attached to a message"),
+	)
+}
+
+// mediaResource describes the attachment of a stored media message.
+func mediaResource(m *db.Message) mcp.Resource {
+	name := fmt.Sprintf("%s from %s (%s)", m.MimeType, senderLabel(m), time.UnixMilli(m.TimestampMS).Format("2006-01-02 15:04"))
+	opts := []mcp.ResourceOption{mcp.WithMIMEType(m.MimeType)}
+	if m.Body != "" {
+		opts = append(opts, mcp.WithResourceDescription(m.Body))
+	}
+	return mcp.NewResource(mediaURIPrefix+m.MessageID, name, opts...)
+}
+
+func senderLabel(m *db.Message) string {
+	switch {
+	case m.IsFromMe:
+		return "me"
+	case m.SenderName != "":
+		return m.SenderName
+	default:
+		return m.SenderNumber
+	}
+}
+
+// mediaResourceHandler serves the decrypted bytes of a message's attachment.
+func mediaResourceHandler(a *app.App, cache *mediaCache) server.ResourceTemplateHandlerFunc {
+	return func(ctx context.Context, req mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
+		uri := req.Params.URI
+		msgID := strings.TrimPrefix(uri, mediaURIPrefix)
+		if msgID == uri || msgID == "" {
+			return nil, fmt.Errorf("invalid media URI %q", uri)
+		}
+
+		msg, err := a.Store.GetMessageByID(msgID)
+		if err != nil {
+			return nil, fmt.Errorf("get message: %w", err)
+		}
+		if msg == nil {
+			return nil, fmt.Errorf("message %s not found", msgID)
+		}
+		if msg.MediaID == "" {
+			return nil, fmt.Errorf("message %s has no media attachment", msgID)
+		}
+		if a.Client == nil {
+			return nil, errors.New("not connected to Google Messages")
+		}
+
+		data, err := cache.get(a.Client.GM, msg)
+		if err != nil {
+			return nil, err
+		}
+		return []mcp.ResourceContents{mcp.BlobResourceContents{
+			URI:      uri,
+			MIMEType: msg.MimeType,
+			Blob:     base64.StdEncoding.EncodeToString(data),
+		}}, nil
+	}
+}
+
+// RefreshMediaResources replaces the listed resources with the attachments
+// of the most recent media messages. Attachments outside the list can still
+// be read through the gmessages://media/{message_id} template.
+func RefreshMediaResources(s *server.MCPServer, a *app.App) error {
+	msgs, err := a.Store.GetMessagesFiltered("", 0, 0, mediaResourceLimit, db.MessageFilter{HasMedia: true})
+	if err != nil {
+		return err
+	}
+	handler := server.ResourceHandlerFunc(mediaResourceHandler(a, attachments))
+	resources := make([]server.ServerResource, len(msgs))
+	for i, m := range msgs {
+		resources[i] = server.ServerResource{Resource: mediaResource(m), Handler: handler}
+	}
+	s.SetResources(resources...)
+	return nil
+}
@@ -0,0 +1,59 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+
+	"github.com/maxghenis/openmessage/internal/app"
+	"github.com/maxghenis/openmessage/internal/client"
+)
+
+func reactTool() mcp.Tool {
+	return mcp.NewTool("react",
+		mcp.WithDescription("Add, remove, or switch an emoji reaction on a message"),
+		mcp.WithString("message_id", mcp.Required(), mcp.Description("ID of the message to react to")),
+		mcp.WithString("emoji", mcp.Required(), mcp.Description("Reaction emoji, or a shortcode such as :thumbsup:")),
+		mcp.WithString("conversation_id", mcp.Description("Conversation of the message (looked up from the message if omitted)")),
+		mcp.WithString("action", mcp.Description("add (default), remove, or switch"), mcp.Enum("add", "remove", "switch")),
+		mcp.WithReadOnlyHintAnnotation(false),
+		mcp.WithDestructiveHintAnnotation(false),
+		mcp.WithIdempotentHintAnnotation(false),
+	)
+}
+
+func reactHandler(a *app.App) server.ToolHandlerFunc {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args := req.GetArguments()
+		messageID := strArg(args, "message_id")
+		action := strArg(args, "action")
+
+		if messageID == "" {
+			return errorResult("message_id is required"), nil
+		}
+		if strArg(args, "emoji") == "" {
+			return errorResult("emoji is required"), nil
+		}
+		emoji, err := client.NormalizeReactionEmoji(strArg(args, "emoji"))
+		if err != nil {
+			return errorResult(err.Error()), nil
+		}
+		switch strings.ToLower(action) {
+		case "", "add", "remove", "switch":
+		default:
+			return errorResult("action must be add, remove, or switch"), nil
+		}
+		if a.Client == nil {
+			return errorResult("not connected to Google Messages"), nil
+		}
+
+		resp, err := client.SendReaction(a.Store, a.Client.GM, strArg(args, "conversation_id"), messageID, emoji, action)
+		if err != nil {
+			return errorResult(fmt.Sprintf("failed to react: %v", err)), nil
+		}
+		return textResult(fmt.Sprintf("Reaction %s on %s: success=%t", emoji, messageID, resp.GetSuccess())), nil
+	}
+}
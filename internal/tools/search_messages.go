@@ -3,6 +3,7 @@ package tools
 import (
 	"context"
 	"fmt"
+	"regexp"
 	"strings"
 	"time"
 
@@ -10,14 +11,17 @@ import (
 	"github.com/mark3labs/mcp-go/server"
 
 	"github.com/maxghenis/openmessage/internal/app"
+	"github.com/maxghenis/openmessage/internal/db"
 )
 
 func searchMessagesTool() mcp.Tool {
 	return mcp.NewTool("search_messages",
-		mcp.WithDescription("Search messages by text content across all conversations"),
-		mcp.WithString("query", mcp.Required(), mcp.Description("Search text")),
+		mcp.WithDescription("Search messages by text content across all conversations, newest first"),
+		mcp.WithString("query", mcp.Required(), mcp.Description("Search text, or a Go regular expression when regex is true")),
+		mcp.WithBoolean("regex", mcp.Description("Treat query as a Go regular expression, e.g. (?i)gate [a-z]\\d+ (default false)")),
 		mcp.WithString("phone_number", mcp.Description("Filter by phone number")),
 		mcp.WithNumber("limit", mcp.Description("Maximum results (default 20)")),
+		mcp.WithString("order", mcp.Description("newest (default), oldest, or relevance first. Regex searches can't use oldest"), mcp.Enum("relevance", "newest", "oldest")),
 		mcp.WithReadOnlyHintAnnotation(true),
 		mcp.WithDestructiveHintAnnotation(false),
 	)
@@ -31,9 +35,29 @@ func searchMessagesHandler(a *app.App) server.ToolHandlerFunc {
 			return errorResult("query is required"), nil
 		}
 		phone := strArg(args, "phone_number")
-		limit := intArg(args, "limit", 20)
+		defaultLimit := 20
+		if a.SearchLimit > 0 {
+			defaultLimit = a.SearchLimit
+		}
+		limit := intArg(args, "limit", defaultLimit)
+		order, err := db.ParseSearchOrder(strArg(args, "order"))
+		if err != nil {
+			return errorResult(err.Error()), nil
+		}
 
-		msgs, err := a.Store.SearchMessages(query, phone, limit)
+		var msgs []*db.Message
+		if useRegex, _ := args["regex"].(bool); useRegex {
+			if order == db.SearchOldest {
+				return errorResult("order oldest is not supported with regex"), nil
+			}
+			re, rerr := regexp.Compile(query)
+			if rerr != nil {
+				return errorResult(fmt.Sprintf("invalid regex: %v", rerr)), nil
+			}
+			msgs, err = a.Store.SearchMessagesRegex(re, phone, limit)
+		} else {
+			msgs, err = a.Store.SearchMessagesOrdered(query, phone, limit, order)
+		}
 		if err != nil {
 			return errorResult(fmt.Sprintf("search failed: %v", err)), nil
 		}
@@ -58,7 +82,7 @@ func searchMessagesHandler(a *app.App) server.ToolHandlerFunc {
 			if sender == "" {
 				sender = "Unknown"
 			}
-			display := formatMessageBody(m.Body, m.MediaID, m.MimeType, m.MessageID)
+			display := formatMessage(m)
 			fmt.Fprintf(&sb, "[%s] %s %s (conv: %s): «%s»\n", ts, direction, sender, m.ConversationID, display)
 		}
 		return textResult(sb.String()), nil
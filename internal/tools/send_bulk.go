@@ -0,0 +1,68 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+
+	"github.com/maxghenis/openmessage/internal/app"
+	"github.com/maxghenis/openmessage/internal/client"
+)
+
+func sendBulkTool() mcp.Tool {
+	return mcp.NewTool("send_bulk",
+		mcp.WithDescription(fmt.Sprintf("Send the same text message to several existing conversations, e.g. an announcement. Each is sent separately; failures don't stop the rest. At most %d conversations.", client.MaxBulkSend)),
+		mcp.WithArray("conversation_ids", mcp.Required(), mcp.WithStringItems(), mcp.Description("Conversations to send to")),
+		mcp.WithString("message", mcp.Required(), mcp.Description("Message text to send")),
+		mcp.WithDestructiveHintAnnotation(false),
+		mcp.WithIdempotentHintAnnotation(false),
+	)
+}
+
+func sendBulkHandler(a *app.App) server.ToolHandlerFunc {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args := req.GetArguments()
+		var convIDs []string
+		if raw, ok := args["conversation_ids"].([]any); ok {
+			for _, v := range raw {
+				if id, ok := v.(string); ok && strings.TrimSpace(id) != "" {
+					convIDs = append(convIDs, strings.TrimSpace(id))
+				}
+			}
+		}
+		message := strArg(args, "message")
+		if len(convIDs) == 0 {
+			return errorResult("conversation_ids is required"), nil
+		}
+		if len(convIDs) > client.MaxBulkSend {
+			return errorResult(fmt.Sprintf("at most %d conversation_ids per call", client.MaxBulkSend)), nil
+		}
+		if message == "" {
+			return errorResult("message is required"), nil
+		}
+		if a.Client == nil {
+			return errorResult("not connected to Google Messages"), nil
+		}
+
+		results := client.SendBulk(a.Store, a.Client.GM, convIDs, message)
+		return textResult(formatBulkResults(results)), nil
+	}
+}
+
+// formatBulkResults summarizes a bulk send with one line per conversation.
+func formatBulkResults(results []client.BulkSendResult) string {
+	sent := 0
+	var sb strings.Builder
+	for _, r := range results {
+		if r.Success {
+			sent++
+			fmt.Fprintf(&sb, "- %s: sent\n", r.ConversationID)
+		} else {
+			fmt.Fprintf(&sb, "- %s: failed (%s)\n", r.ConversationID, r.Error)
+		}
+	}
+	return fmt.Sprintf("Sent to %d of %d conversations:\n%s", sent, len(results), sb.String())
+}
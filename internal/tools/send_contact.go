@@ -0,0 +1,62 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+
+	"github.com/maxghenis/openmessage/internal/app"
+	"github.com/maxghenis/openmessage/internal/client"
+)
+
+func sendContactTool() mcp.Tool {
+	return mcp.NewTool("send_contact",
+		mcp.WithDescription("Share a contact card (vCard) in a conversation. Pass either contact_id for a saved contact, or name and number."),
+		mcp.WithString("conversation_id", mcp.Required(), mcp.Description("Conversation to send the contact card to")),
+		mcp.WithString("contact_id", mcp.Description("ID of a saved contact to share")),
+		mcp.WithString("name", mcp.Description("Contact name (when contact_id is not given)")),
+		mcp.WithString("number", mcp.Description("Contact phone number (when contact_id is not given)")),
+		mcp.WithDestructiveHintAnnotation(false),
+		mcp.WithIdempotentHintAnnotation(false),
+	)
+}
+
+func sendContactHandler(a *app.App) server.ToolHandlerFunc {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args := req.GetArguments()
+		convID := strArg(args, "conversation_id")
+		name := strArg(args, "name")
+		number := strArg(args, "number")
+
+		if convID == "" {
+			return errorResult("conversation_id is required"), nil
+		}
+		if contactID := strArg(args, "contact_id"); contactID != "" {
+			c, err := a.Store.GetContact(contactID)
+			if err != nil {
+				return errorResult(fmt.Sprintf("get contact: %v", err)), nil
+			}
+			if c == nil {
+				return errorResult("contact not found: " + contactID), nil
+			}
+			name, number = c.Name, c.Number
+		}
+		if _, err := client.BuildVCard(name, number); err != nil {
+			return errorResult(err.Error()), nil
+		}
+		if a.Client == nil {
+			return errorResult("not connected to Google Messages"), nil
+		}
+
+		res, err := client.SendContactCard(a.Store, a.Client.GM, convID, name, number)
+		if err != nil {
+			return errorResult(fmt.Sprintf("failed to send: %v", err)), nil
+		}
+		if !res.Success {
+			return errorResult("failed to send: " + res.FailureReason), nil
+		}
+		return textResult(fmt.Sprintf("Contact card for %s (%s) sent: %s", name, number, res.Status)), nil
+	}
+}
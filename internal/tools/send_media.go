@@ -0,0 +1,124 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+
+	"github.com/maxghenis/openmessage/internal/app"
+	"github.com/maxghenis/openmessage/internal/client"
+)
+
+// maxMediaFileSize matches the upload limit of /api/send-media.
+const maxMediaFileSize = 10 << 20
+
+// mediaTypes covers attachment types that minimal systems often lack a
+// mime.types entry for.
+var mediaTypes = map[string]string{
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".png":  "image/png",
+	".gif":  "image/gif",
+	".webp": "image/webp",
+	".heic": "image/heic",
+	".mp4":  "video/mp4",
+	".3gp":  "video/3gpp",
+	".mov":  "video/quicktime",
+	".mp3":  "audio/mpeg",
+	".m4a":  "audio/mp4",
+	".amr":  "audio/amr",
+	".pdf":  "application/pdf",
+	".vcf":  "text/vcard",
+}
+
+func sendMediaTool() mcp.Tool {
+	return mcp.NewTool("send_media",
+		mcp.WithDescription("Send a local file (photo, video, audio, PDF, ...) as an MMS/RCS attachment. Pass either phone_number or conversation_id."),
+		mcp.WithString("phone_number", mcp.Description("Recipient phone number with country code (e.g., +15551234567)")),
+		mcp.WithString("conversation_id", mcp.Description("Conversation to send to (instead of phone_number)")),
+		mcp.WithString("file_path", mcp.Required(), mcp.Description("Path of the file to send")),
+		mcp.WithString("caption", mcp.Description("Optional text to send with the attachment")),
+		mcp.WithDestructiveHintAnnotation(false),
+		mcp.WithIdempotentHintAnnotation(false),
+	)
+}
+
+func sendMediaHandler(a *app.App) server.ToolHandlerFunc {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args := req.GetArguments()
+		phone := strArg(args, "phone_number")
+		convID := strArg(args, "conversation_id")
+		path := strArg(args, "file_path")
+		caption := strArg(args, "caption")
+
+		if phone == "" && convID == "" {
+			return errorResult("phone_number or conversation_id is required"), nil
+		}
+		if path == "" {
+			return errorResult("file_path is required"), nil
+		}
+		if a.Client == nil {
+			return errorResult("not connected to Google Messages"), nil
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			return errorResult(fmt.Sprintf("read file: %v", err)), nil
+		}
+		if info.IsDir() {
+			return errorResult(path + " is a directory"), nil
+		}
+		if info.Size() > maxMediaFileSize {
+			return errorResult(fmt.Sprintf("file is %d bytes; the limit is %d", info.Size(), maxMediaFileSize)), nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return errorResult(fmt.Sprintf("read file: %v", err)), nil
+		}
+		mimeType, err := mediaMIME(path, data)
+		if err != nil {
+			return errorResult(err.Error()), nil
+		}
+
+		if convID == "" {
+			conv, err := conversationForNumber(a, phone)
+			if err != nil {
+				return errorResult(err.Error()), nil
+			}
+			convID = conv.GetConversationID()
+		}
+
+		res, err := client.SendMedia(a.Store, a.Client.GM, convID, data, filepath.Base(path), mimeType, caption)
+		if err != nil {
+			return errorResult(fmt.Sprintf("failed to send: %v", err)), nil
+		}
+		if !res.Success {
+			return errorResult("failed to send: " + res.FailureReason), nil
+		}
+		return textResult(fmt.Sprintf("Sent %s (%s, %d bytes) to %s: %s", filepath.Base(path), mimeType, len(data), convID, res.Status)), nil
+	}
+}
+
+// mediaMIME picks a file's MIME type from its extension, falling back to
+// sniffing the content. Files that are neither recognized nor sniffable are
+// rejected rather than sent as application/octet-stream.
+func mediaMIME(path string, data []byte) (string, error) {
+	ext := strings.ToLower(filepath.Ext(path))
+	if t := mime.TypeByExtension(ext); t != "" {
+		return strings.TrimSpace(strings.Split(t, ";")[0]), nil
+	}
+	if t, ok := mediaTypes[ext]; ok {
+		return t, nil
+	}
+	if t := http.DetectContentType(data); t != "application/octet-stream" {
+		return strings.TrimSpace(strings.Split(t, ";")[0]), nil
+	}
+	return "", fmt.Errorf("unknown file type for %s", filepath.Base(path))
+}
@@ -4,18 +4,19 @@ import (
 	"context"
 	"fmt"
 
-	"github.com/google/uuid"
 	"github.com/mark3labs/mcp-go/mcp"
 	"github.com/mark3labs/mcp-go/server"
 	"go.mau.fi/mautrix-gmessages/pkg/libgm/gmproto"
 
 	"github.com/maxghenis/openmessage/internal/app"
+	"github.com/maxghenis/openmessage/internal/client"
 )
 
 func sendMessageTool() mcp.Tool {
 	return mcp.NewTool("send_message",
-		mcp.WithDescription("Send a text message (SMS/RCS) to a phone number"),
-		mcp.WithString("phone_number", mcp.Required(), mcp.Description("Recipient phone number with country code (e.g., +15551234567)")),
+		mcp.WithDescription("Send a text message (SMS/RCS) to a phone number or an existing conversation. Pass either phone_number or conversation_id."),
+		mcp.WithString("phone_number", mcp.Description("Recipient phone number with country code (e.g., +15551234567)")),
+		mcp.WithString("conversation_id", mcp.Description("Conversation to send to (instead of phone_number), e.g. a group from create_group")),
 		mcp.WithString("message", mcp.Required(), mcp.Description("Message text to send")),
 		mcp.WithDestructiveHintAnnotation(false),
 		mcp.WithIdempotentHintAnnotation(false),
@@ -26,10 +27,11 @@ func sendMessageHandler(a *app.App) server.ToolHandlerFunc {
 	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		args := req.GetArguments()
 		phone := strArg(args, "phone_number")
+		convID := strArg(args, "conversation_id")
 		message := strArg(args, "message")
 
-		if phone == "" {
-			return errorResult("phone_number is required"), nil
+		if phone == "" && convID == "" {
+			return errorResult("phone_number or conversation_id is required"), nil
 		}
 		if message == "" {
 			return errorResult("message is required"), nil
@@ -38,49 +40,32 @@ func sendMessageHandler(a *app.App) server.ToolHandlerFunc {
 			return errorResult("not connected to Google Messages"), nil
 		}
 
-		// Get or create conversation for this phone number
-		convResp, err := a.Client.GM.GetOrCreateConversation(&gmproto.GetOrCreateConversationRequest{
-			Numbers: []*gmproto.ContactNumber{
-				{
-					MysteriousInt: 7,
-					Number:        phone,
-					Number2:       phone,
-				},
-			},
-		})
-		if err != nil {
-			return errorResult(fmt.Sprintf("failed to get/create conversation: %v", err)), nil
-		}
-
-		conv := convResp.GetConversation()
-		if conv == nil {
-			return errorResult("no conversation returned"), nil
+		if convID == "" {
+			conv, err := conversationForNumber(a, phone)
+			if err != nil {
+				return errorResult(err.Error()), nil
+			}
+			convID = conv.GetConversationID()
 		}
 
-		tmpID := uuid.NewString()
-		_, err = a.Client.GM.SendMessage(&gmproto.SendMessageRequest{
-			ConversationID: conv.GetConversationID(),
-			TmpID:          tmpID,
-			MessagePayload: &gmproto.MessagePayload{
-				TmpID:          tmpID,
-				TmpID2:         tmpID,
-				ConversationID: conv.GetConversationID(),
-				ParticipantID:  conv.GetDefaultOutgoingID(),
-				MessageInfo: []*gmproto.MessageInfo{
-					{
-						Data: &gmproto.MessageInfo_MessageContent{
-							MessageContent: &gmproto.MessageContent{
-								Content: message,
-							},
-						},
-					},
-				},
-			},
-		})
+		res, err := client.SendText(a.Store, a.Client.GM, convID, message, "")
 		if err != nil {
 			return errorResult(fmt.Sprintf("failed to send: %v", err)), nil
 		}
+		if !res.Success {
+			return errorResult("failed to send: " + res.FailureReason), nil
+		}
 
-		return textResult(fmt.Sprintf("Message sent to %s: %s", phone, message)), nil
+		to := phone
+		if to == "" {
+			to = convID
+		}
+		return textResult(fmt.Sprintf("Message sent to %s (%d SMS segments): %s", to, res.Segments, message)), nil
 	}
 }
+
+// conversationForNumber gets or creates the conversation with a phone number.
+func conversationForNumber(a *app.App, phone string) (*gmproto.Conversation, error) {
+	conv, _, err := client.StartConversation(a.Store, a.Client.GM, []string{phone}, "")
+	return conv, err
+}
@@ -2,6 +2,11 @@ package tools
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -10,6 +15,7 @@ import (
 	"github.com/rs/zerolog"
 
 	"github.com/maxghenis/openmessage/internal/app"
+	"github.com/maxghenis/openmessage/internal/client"
 	"github.com/maxghenis/openmessage/internal/db"
 )
 
@@ -153,6 +159,26 @@ func TestSearchMessages(t *testing.T) {
 	}
 }
 
+func TestSearchMessagesOrder(t *testing.T) {
+	a := testApp(t)
+	a.Store.UpsertMessage(&db.Message{MessageID: "1", ConversationID: "c1", Body: "lunch first", TimestampMS: 1000})
+	a.Store.UpsertMessage(&db.Message{MessageID: "2", ConversationID: "c1", Body: "lunch second", TimestampMS: 2000})
+	a.SearchLimit = 1
+
+	req := mcp.CallToolRequest{}
+	req.Params.Arguments = map[string]any{"query": "lunch", "order": "oldest"}
+	result, _ := searchMessagesHandler(a)(context.Background(), req)
+	text := result.Content[0].(mcp.TextContent).Text
+	if !contains(text, "lunch first") || contains(text, "lunch second") {
+		t.Errorf("expected only the oldest match, got: %s", text)
+	}
+
+	req.Params.Arguments = map[string]any{"query": "lunch", "order": "sideways"}
+	if result, _ := searchMessagesHandler(a)(context.Background(), req); !result.IsError {
+		t.Error("expected error for unknown order")
+	}
+}
+
 func TestListConversations(t *testing.T) {
 	a := testApp(t)
 	now := time.Now().UnixMilli()
@@ -181,6 +207,66 @@ func TestListConversations(t *testing.T) {
 	}
 }
 
+func TestListConversationsActiveSince(t *testing.T) {
+	a := testApp(t)
+	now := time.Now()
+
+	a.Store.UpsertConversation(&db.Conversation{
+		ConversationID: "c1", Name: "Today", LastMessageTS: now.UnixMilli(),
+	})
+	a.Store.UpsertConversation(&db.Conversation{
+		ConversationID: "c2", Name: "Last Year", LastMessageTS: now.AddDate(-1, 0, 0).UnixMilli(),
+	})
+
+	handler := listConversationsHandler(a)
+	req := mcp.CallToolRequest{}
+	req.Params.Arguments = map[string]any{"active_since": now.AddDate(0, 0, -1).Format("2006-01-02")}
+
+	result, err := handler(context.Background(), req)
+	if err != nil {
+		t.Fatalf("handler error: %v", err)
+	}
+	text := result.Content[0].(mcp.TextContent).Text
+	if !contains(text, "Today") {
+		t.Errorf("expected Today, got: %s", text)
+	}
+	if contains(text, "Last Year") {
+		t.Errorf("should not contain Last Year, got: %s", text)
+	}
+
+	req.Params.Arguments = map[string]any{"active_since": "yesterday"}
+	result, _ = handler(context.Background(), req)
+	if !result.IsError {
+		t.Error("expected error for invalid active_since")
+	}
+}
+
+func TestListNeedsReply(t *testing.T) {
+	a := testApp(t)
+	now := time.Now().UnixMilli()
+
+	a.Store.UpsertConversation(&db.Conversation{ConversationID: "c1", Name: "Alice", LastMessageTS: now})
+	a.Store.UpsertConversation(&db.Conversation{ConversationID: "c2", Name: "Bob", LastMessageTS: now})
+	a.Store.UpsertMessage(&db.Message{MessageID: "m1", ConversationID: "c1", Body: "Dinner?", TimestampMS: now})
+	a.Store.UpsertMessage(&db.Message{MessageID: "m2", ConversationID: "c2", Body: "See you", IsFromMe: true, TimestampMS: now})
+
+	handler := listNeedsReplyHandler(a)
+	req := mcp.CallToolRequest{}
+	req.Params.Arguments = map[string]any{}
+
+	result, err := handler(context.Background(), req)
+	if err != nil {
+		t.Fatalf("handler error: %v", err)
+	}
+	text := result.Content[0].(mcp.TextContent).Text
+	if !contains(text, "Alice") {
+		t.Errorf("expected Alice, got: %s", text)
+	}
+	if contains(text, "Bob") {
+		t.Errorf("should not contain Bob, got: %s", text)
+	}
+}
+
 func TestGetConversation(t *testing.T) {
 	a := testApp(t)
 	now := time.Now().UnixMilli()
@@ -217,6 +303,50 @@ func TestGetConversation(t *testing.T) {
 	}
 }
 
+func TestConversationOverview(t *testing.T) {
+	a := testApp(t)
+	now := time.Now().UnixMilli()
+
+	a.Store.UpsertConversation(&db.Conversation{
+		ConversationID: "c1", Name: "Hiking", IsGroup: true, UnreadCount: 2, LastMessageTS: now,
+		Participants: `[{"name":"Emily Park","number":"+13105553456"},{"name":"Me","number":"+15550000000","is_me":true}]`,
+	})
+	a.Store.UpsertMessage(&db.Message{MessageID: "m1", ConversationID: "c1", Body: "Saturday?", SenderName: "Emily Park", TimestampMS: now - 2000})
+	a.Store.UpsertMessage(&db.Message{MessageID: "m2", ConversationID: "c1", Body: "I'm in", IsFromMe: true, TimestampMS: now - 1000})
+	a.Store.UpsertMessage(&db.Message{MessageID: "m3", ConversationID: "c1", Body: "9am trailhead", SenderName: "Emily Park", TimestampMS: now})
+
+	handler := conversationOverviewHandler(a)
+	req := mcp.CallToolRequest{}
+	req.Params.Arguments = map[string]any{"conversation_id": "c1", "recent": float64(2)}
+
+	result, err := handler(context.Background(), req)
+	if err != nil {
+		t.Fatalf("handler error: %v", err)
+	}
+	text := result.Content[0].(mcp.TextContent).Text
+	for _, want := range []string{
+		"Conversation: Hiking", "Type: Group", "Participants:", "Emily Park +13105553456",
+		"Messages: 3 (1 sent, 2 received)", "Unread: 2", "Last activity:", "Recent messages:",
+		"I'm in", "9am trailhead",
+	} {
+		if !contains(text, want) {
+			t.Errorf("missing %q in:\n%s", want, text)
+		}
+	}
+	if contains(text, "Saturday?") {
+		t.Error("recent limit not applied")
+	}
+	if contains(text, "+15550000000") {
+		t.Error("own number should not be listed as a participant")
+	}
+
+	req.Params.Arguments = map[string]any{"conversation_id": "missing"}
+	result, _ = handler(context.Background(), req)
+	if !result.IsError {
+		t.Error("expected error for unknown conversation")
+	}
+}
+
 func TestSendMessageNotConnected(t *testing.T) {
 	a := testApp(t)
 
@@ -240,6 +370,175 @@ func TestSendMessageNotConnected(t *testing.T) {
 	}
 }
 
+func TestSendMediaNotConnected(t *testing.T) {
+	a := testApp(t)
+
+	handler := sendMediaHandler(a)
+	req := mcp.CallToolRequest{}
+	req.Params.Arguments = map[string]any{
+		"phone_number": "+15551234567",
+		"file_path":    "/tmp/photo.jpg",
+	}
+
+	result, err := handler(context.Background(), req)
+	if err != nil {
+		t.Fatalf("handler error: %v", err)
+	}
+	if !result.IsError {
+		t.Error("expected error when not connected")
+	}
+	text := result.Content[0].(mcp.TextContent).Text
+	if !contains(text, "not connected") {
+		t.Errorf("expected 'not connected' error, got: %s", text)
+	}
+
+	req.Params.Arguments = map[string]any{"file_path": "/tmp/photo.jpg"}
+	result, _ = handler(context.Background(), req)
+	if text := result.Content[0].(mcp.TextContent).Text; !result.IsError || !contains(text, "phone_number or conversation_id") {
+		t.Errorf("missing recipient: got %s", text)
+	}
+}
+
+func TestMediaMIME(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n0000")
+	tests := []struct {
+		path string
+		data []byte
+		want string
+	}{
+		{"photo.JPG", nil, "image/jpeg"},
+		{"clip.mp4", nil, "video/mp4"},
+		{"noext", png, "image/png"},
+	}
+	for _, tt := range tests {
+		if got, err := mediaMIME(tt.path, tt.data); err != nil || got != tt.want {
+			t.Errorf("mediaMIME(%q) = %q, %v; want %q", tt.path, got, err, tt.want)
+		}
+	}
+	if _, err := mediaMIME("blob.xyz123", []byte{0x00, 0x01, 0x02}); err == nil {
+		t.Error("expected error for unknown type")
+	}
+}
+
+func TestReactErrors(t *testing.T) {
+	a := testApp(t)
+	handler := reactHandler(a)
+
+	tests := []struct {
+		name string
+		args map[string]any
+		want string
+	}{
+		{"missing emoji", map[string]any{"message_id": "m1"}, "emoji is required"},
+		{"bad action", map[string]any{"message_id": "m1", "emoji": "👍", "action": "explode"}, "action must be"},
+		{"not connected", map[string]any{"message_id": "m1", "emoji": ":thumbsup:"}, "not connected"},
+	}
+	for _, tt := range tests {
+		req := mcp.CallToolRequest{}
+		req.Params.Arguments = tt.args
+		result, err := handler(context.Background(), req)
+		if err != nil {
+			t.Fatalf("%s: handler error: %v", tt.name, err)
+		}
+		text := result.Content[0].(mcp.TextContent).Text
+		if !result.IsError || !contains(text, tt.want) {
+			t.Errorf("%s: got %q, want error containing %q", tt.name, text, tt.want)
+		}
+	}
+}
+
+func TestMarkRead(t *testing.T) {
+	a := testApp(t)
+	a.Store.UpsertConversation(&db.Conversation{ConversationID: "c1", Name: "Alice", UnreadCount: 3})
+	a.Store.UpsertMessage(&db.Message{MessageID: "m1", ConversationID: "c1", Body: "hi", TimestampMS: 1000})
+
+	handler := markReadHandler(a)
+	req := mcp.CallToolRequest{}
+	req.Params.Arguments = map[string]any{"conversation_id": "c1"}
+	result, err := handler(context.Background(), req)
+	if err != nil {
+		t.Fatalf("handler error: %v", err)
+	}
+	text := result.Content[0].(mcp.TextContent).Text
+	if result.IsError || !contains(text, "Unread: 0") {
+		t.Errorf("unexpected result: %s", text)
+	}
+	if c, _ := a.Store.GetConversation("c1"); c.UnreadCount != 0 {
+		t.Errorf("unread = %d, want 0", c.UnreadCount)
+	}
+
+	req.Params.Arguments = map[string]any{"conversation_id": "missing"}
+	result, _ = handler(context.Background(), req)
+	text = result.Content[0].(mcp.TextContent).Text
+	if result.IsError || !contains(text, "not stored locally") {
+		t.Errorf("missing conversation: got %q (error=%v)", text, result.IsError)
+	}
+}
+
+func TestCreateGroupErrors(t *testing.T) {
+	a := testApp(t)
+	handler := createGroupHandler(a)
+
+	tests := []struct {
+		name string
+		args map[string]any
+		want string
+	}{
+		{"one number", map[string]any{"phone_numbers": []any{"+15551110000"}}, "at least two"},
+		{"not connected", map[string]any{"phone_numbers": []any{"+15551110000", "+15552220000"}, "name": "Trip"}, "not connected"},
+	}
+	for _, tt := range tests {
+		req := mcp.CallToolRequest{}
+		req.Params.Arguments = tt.args
+		result, err := handler(context.Background(), req)
+		if err != nil {
+			t.Fatalf("%s: handler error: %v", tt.name, err)
+		}
+		text := result.Content[0].(mcp.TextContent).Text
+		if !result.IsError || !contains(text, tt.want) {
+			t.Errorf("%s: got %q, want error containing %q", tt.name, text, tt.want)
+		}
+	}
+}
+
+func TestSendBulkErrors(t *testing.T) {
+	a := testApp(t)
+	handler := sendBulkHandler(a)
+
+	tests := []struct {
+		name string
+		args map[string]any
+		want string
+	}{
+		{"no conversations", map[string]any{"conversation_ids": []any{" "}, "message": "hi"}, "conversation_ids is required"},
+		{"no message", map[string]any{"conversation_ids": []any{"c1"}}, "message is required"},
+		{"not connected", map[string]any{"conversation_ids": []any{"c1", "c2"}, "message": "hi"}, "not connected"},
+	}
+	for _, tt := range tests {
+		req := mcp.CallToolRequest{}
+		req.Params.Arguments = tt.args
+		result, err := handler(context.Background(), req)
+		if err != nil {
+			t.Fatalf("%s: handler error: %v", tt.name, err)
+		}
+		text := result.Content[0].(mcp.TextContent).Text
+		if !result.IsError || !contains(text, tt.want) {
+			t.Errorf("%s: got %q, want error containing %q", tt.name, text, tt.want)
+		}
+	}
+}
+
+func TestFormatBulkResults(t *testing.T) {
+	got := formatBulkResults([]client.BulkSendResult{
+		{ConversationID: "c1", Success: true},
+		{ConversationID: "c2", Error: "phone rejected the message"},
+	})
+	want := "Sent to 1 of 2 conversations:\n- c1: sent\n- c2: failed (phone rejected the message)\n"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
 func TestGetStatus(t *testing.T) {
 	a := testApp(t)
 
@@ -257,6 +556,29 @@ func TestGetStatus(t *testing.T) {
 	}
 }
 
+func TestGetStatusPairingInfo(t *testing.T) {
+	a := testApp(t)
+	a.PairingPath = filepath.Join(t.TempDir(), "pairing.json")
+	client.RecordPairing(a.PairingPath, "phone-a", time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC))
+	client.RecordPairing(a.PairingPath, "phone-b", time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC))
+
+	handler := getStatusHandler(a)
+	req := mcp.CallToolRequest{}
+	req.Params.Arguments = map[string]any{}
+
+	result, err := handler(context.Background(), req)
+	if err != nil {
+		t.Fatalf("handler error: %v", err)
+	}
+	text := result.Content[0].(mcp.TextContent).Text
+	if !contains(text, "Paired with phone phone-b on 2026-04-01") {
+		t.Errorf("expected pairing line, got: %s", text)
+	}
+	if !contains(text, "phone-a") {
+		t.Errorf("expected previous phone note, got: %s", text)
+	}
+}
+
 func TestListContacts(t *testing.T) {
 	a := testApp(t)
 
@@ -276,6 +598,110 @@ func TestListContacts(t *testing.T) {
 	}
 }
 
+func TestLastContact(t *testing.T) {
+	a := testApp(t)
+
+	now := time.Now()
+	a.Store.UpsertContact(&db.Contact{ContactID: "1", Name: "Alice", Number: "+15551111111"})
+	a.Store.UpsertContact(&db.Contact{ContactID: "2", Name: "Bob", Number: "+15552222222"})
+	a.Store.UpsertContact(&db.Contact{ContactID: "3", Name: "Carol", Number: "+15553333333"})
+	a.Store.UpsertConversation(&db.Conversation{ConversationID: "ca", Participants: `[{"name":"Alice","number":"+15551111111"}]`})
+	a.Store.UpsertConversation(&db.Conversation{ConversationID: "cb", Participants: `[{"name":"Bob","number":"+15552222222"}]`})
+	a.Store.UpsertMessage(&db.Message{MessageID: "m1", ConversationID: "ca", Body: "hi", TimestampMS: now.Add(-3*24*time.Hour - time.Hour).UnixMilli()})
+	a.Store.UpsertMessage(&db.Message{MessageID: "m2", ConversationID: "cb", Body: "yo", IsFromMe: true, TimestampMS: now.Add(-2*time.Hour - time.Minute).UnixMilli()})
+
+	handler := lastContactHandler(a)
+	req := mcp.CallToolRequest{}
+	req.Params.Arguments = map[string]any{}
+	result, err := handler(context.Background(), req)
+	if err != nil {
+		t.Fatalf("handler error: %v", err)
+	}
+	text := result.Content[0].(mcp.TextContent).Text
+	for _, want := range []string{"Alice (+15551111111)", "3 days ago", "2 hours ago", "Carol (+15553333333): no messages"} {
+		if !contains(text, want) {
+			t.Errorf("expected %q in:\n%s", want, text)
+		}
+	}
+	if strings.Index(text, "Alice") > strings.Index(text, "Bob") {
+		t.Errorf("expected longest-ago contact first:\n%s", text)
+	}
+
+	req.Params.Arguments = map[string]any{"phone_number": "+15552222222"}
+	result, _ = handler(context.Background(), req)
+	text = result.Content[0].(mcp.TextContent).Text
+	if !contains(text, "Bob") || contains(text, "Alice") {
+		t.Errorf("expected only Bob, got: %s", text)
+	}
+}
+
+func TestGetConversationsForNumber(t *testing.T) {
+	a := testApp(t)
+
+	a.Store.UpsertConversation(&db.Conversation{ConversationID: "dm", Name: "Alice",
+		Participants: `[{"name":"Alice","number":"+15551234567"}]`})
+	a.Store.UpsertConversation(&db.Conversation{ConversationID: "g1", Name: "Family", IsGroup: true,
+		Participants: `[{"name":"Alice","number":"+15551234567"},{"name":"Bob","number":"+15552222222"}]`})
+	a.Store.UpsertConversation(&db.Conversation{ConversationID: "g2", Name: "Book club", IsGroup: true,
+		Participants: `[{"name":"Alice","number":"(555) 123-4567"},{"name":"Carol","number":"+15553333333"}]`})
+	a.Store.UpsertConversation(&db.Conversation{ConversationID: "bob", Name: "Bob",
+		Participants: `[{"name":"Bob","number":"+15552222222"}]`})
+
+	handler := getConversationsForNumberHandler(a)
+	req := mcp.CallToolRequest{}
+	req.Params.Arguments = map[string]any{"phone_number": "+1 555 123 4567"}
+	result, err := handler(context.Background(), req)
+	if err != nil {
+		t.Fatalf("handler error: %v", err)
+	}
+	text := result.Content[0].(mcp.TextContent).Text
+	if !contains(text, "3 conversations") {
+		t.Errorf("expected 3 conversations, got: %s", text)
+	}
+	for _, want := range []string{"ID: dm", "ID: g1", "ID: g2", "[group]"} {
+		if !contains(text, want) {
+			t.Errorf("expected %q in: %s", want, text)
+		}
+	}
+	if contains(text, "ID: bob") {
+		t.Errorf("unexpected conversation without the number: %s", text)
+	}
+}
+
+func TestGetConversationMarkRead(t *testing.T) {
+	a := testApp(t)
+	a.Store.UpsertConversation(&db.Conversation{ConversationID: "c1", Name: "Alice", UnreadCount: 1})
+	a.Store.UpsertMessage(&db.Message{MessageID: "m1", ConversationID: "c1", Body: "hi", TimestampMS: 1000})
+
+	handler := getConversationHandler(a)
+	req := mcp.CallToolRequest{}
+	req.Params.Arguments = map[string]any{"conversation_id": "c1"}
+	handler(context.Background(), req)
+	if c, _ := a.Store.GetConversation("c1"); c.UnreadCount != 1 {
+		t.Errorf("read without mark_read changed unread to %d", c.UnreadCount)
+	}
+
+	req.Params.Arguments = map[string]any{"conversation_id": "c1", "mark_read": true}
+	handler(context.Background(), req)
+	if c, _ := a.Store.GetConversation("c1"); c.UnreadCount != 0 {
+		t.Errorf("unread = %d after mark_read, want 0", c.UnreadCount)
+	}
+}
+
+func TestFormatElapsed(t *testing.T) {
+	for d, want := range map[time.Duration]string{
+		30 * time.Second:     "just now",
+		time.Minute:          "1 minute ago",
+		5 * time.Hour:        "5 hours ago",
+		49 * time.Hour:       "2 days ago",
+		400 * 24 * time.Hour: "400 days ago",
+	} {
+		if got := formatElapsed(d); got != want {
+			t.Errorf("formatElapsed(%v) = %q, want %q", d, got, want)
+		}
+	}
+}
+
 func TestFormatMessageBody(t *testing.T) {
 	// Plain text message — no media
 	got := formatMessageBody("Hello!", "", "", "msg-1")
@@ -314,6 +740,39 @@ func TestFormatMessageBody(t *testing.T) {
 	}
 }
 
+func TestFormatMessageStructured(t *testing.T) {
+	loc := &db.Location{Latitude: 37.7955, Longitude: -122.3937, Name: "Ferry Building"}
+	got := formatMessage(&db.Message{MessageID: "m1", MediaID: "media-1", MimeType: "application/vnd.gsma.rcspushlocation+xml", Location: loc})
+	if got != "📍 Ferry Building (37.7955,-122.3937)" {
+		t.Errorf("location: got %q", got)
+	}
+	got = formatMessage(&db.Message{MessageID: "m2", Location: &db.Location{Latitude: 1.5, Longitude: 2}})
+	if got != "📍 (1.5,2)" {
+		t.Errorf("unnamed location: got %q", got)
+	}
+	card := &db.SharedContact{Name: "Jane Doe", Numbers: []string{"+15550100200"}}
+	got = formatMessage(&db.Message{MessageID: "m4", Body: card.Summary(), MediaID: "media-2", MimeType: "text/vcard", SharedContact: card})
+	if got != "📇 Shared contact: Jane Doe +15550100200" {
+		t.Errorf("shared contact: got %q", got)
+	}
+	got = formatMessage(&db.Message{MessageID: "m5", MediaID: "media-3", MimeType: "image/gif", Subtype: db.SubtypeGIF})
+	if got != "[GIF, message_id: m5]" {
+		t.Errorf("GIF: got %q", got)
+	}
+	got = formatMessage(&db.Message{MessageID: "m6", Body: "lol", MediaID: "media-4", MimeType: "image/webp", Subtype: db.SubtypeSticker})
+	if got != "lol [sticker, message_id: m6]" {
+		t.Errorf("sticker: got %q", got)
+	}
+	got = formatMessage(&db.Message{MessageID: "m7", MediaID: "media-5", MimeType: "image/jpeg"})
+	if got != "[image, message_id: m7]" {
+		t.Errorf("image: got %q", got)
+	}
+	got = formatMessage(&db.Message{MessageID: "m3", Body: "Hello!"})
+	if got != "Hello!" {
+		t.Errorf("plain text: got %q", got)
+	}
+}
+
 func TestGetMessagesMediaIndicator(t *testing.T) {
 	a := testApp(t)
 	now := time.Now().UnixMilli()
@@ -428,6 +887,204 @@ func TestDownloadMediaNotConnected(t *testing.T) {
 	}
 }
 
+func TestDeleteMessageLocalOnly(t *testing.T) {
+	a := testApp(t)
+	a.Store.UpsertMessage(&db.Message{MessageID: "m1", ConversationID: "c1", Body: "hi"})
+	a.Store.UpsertMessage(&db.Message{MessageID: "m2", ConversationID: "c1", ReplyToID: "m1"})
+
+	handler := deleteMessageHandler(a)
+	req := mcp.CallToolRequest{}
+	req.Params.Arguments = map[string]any{"message_id": "m1"}
+	result, err := handler(context.Background(), req)
+	if err != nil {
+		t.Fatalf("handler error: %v", err)
+	}
+	text := result.Content[0].(mcp.TextContent).Text
+	if result.IsError || !contains(text, "locally only") || !contains(text, "m2") {
+		t.Errorf("unexpected result: %s", text)
+	}
+	if m, _ := a.Store.GetMessageByID("m1"); m != nil {
+		t.Error("m1 not deleted")
+	}
+
+	result, _ = handler(context.Background(), req)
+	if !result.IsError {
+		t.Error("expected error deleting a missing message")
+	}
+}
+
+func TestGetMessagesSince(t *testing.T) {
+	a := testApp(t)
+	a.Store.UpsertMessage(&db.Message{MessageID: "m1", ConversationID: "c1", Body: "first", TimestampMS: 1000})
+	a.Store.UpsertMessage(&db.Message{MessageID: "m2", ConversationID: "c1", Body: "second", TimestampMS: 2000})
+
+	handler := getMessagesSinceHandler(a)
+	req := mcp.CallToolRequest{}
+	req.Params.Arguments = map[string]any{"conversation_id": "c1", "message_id": "m1"}
+	result, err := handler(context.Background(), req)
+	if err != nil {
+		t.Fatalf("handler error: %v", err)
+	}
+	text := result.Content[0].(mcp.TextContent).Text
+	if !contains(text, "second") || contains(text, "first") {
+		t.Errorf("unexpected result: %s", text)
+	}
+
+	req.Params.Arguments = map[string]any{"conversation_id": "c1", "message_id": "nope"}
+	result, _ = handler(context.Background(), req)
+	if !result.IsError {
+		t.Error("expected error for missing anchor")
+	}
+}
+
+func TestListConversationsIncludeArchived(t *testing.T) {
+	a := testApp(t)
+	a.Store.UpsertConversation(&db.Conversation{ConversationID: "c1", Name: "Old Chat", LastMessageTS: 1000})
+	a.Store.UpsertConversation(&db.Conversation{ConversationID: "c2", Name: "New Chat", LastMessageTS: 2000})
+	a.Store.SetConversationArchived("c1", true)
+
+	handler := listConversationsHandler(a)
+	req := mcp.CallToolRequest{}
+	req.Params.Arguments = map[string]any{}
+	result, _ := handler(context.Background(), req)
+	if text := result.Content[0].(mcp.TextContent).Text; contains(text, "Old Chat") {
+		t.Errorf("archived conversation listed by default: %s", text)
+	}
+
+	req.Params.Arguments = map[string]any{"include_archived": true}
+	result, _ = handler(context.Background(), req)
+	if text := result.Content[0].(mcp.TextContent).Text; !contains(text, "Old Chat [archived]") {
+		t.Errorf("expected archived conversation: %s", text)
+	}
+}
+
+func TestListConversationsPinnedMarker(t *testing.T) {
+	a := testApp(t)
+	a.Store.UpsertConversation(&db.Conversation{ConversationID: "c1", Name: "Family", LastMessageTS: 1000})
+	a.Store.UpsertConversation(&db.Conversation{ConversationID: "c2", Name: "Work", LastMessageTS: 2000})
+	a.Store.SetConversationPinned("c1", true)
+
+	req := mcp.CallToolRequest{}
+	req.Params.Arguments = map[string]any{}
+	result, _ := listConversationsHandler(a)(context.Background(), req)
+	text := result.Content[0].(mcp.TextContent).Text
+	if !contains(text, "- 📌 Family") || contains(text, "📌 Work") {
+		t.Errorf("unexpected output: %s", text)
+	}
+}
+
+func TestGetMessagesFilter(t *testing.T) {
+	a := testApp(t)
+	a.Store.UpsertMessage(&db.Message{MessageID: "m1", ConversationID: "c1", SenderName: "Alice", Body: "lunch?", TimestampMS: 1000})
+	a.Store.UpsertMessage(&db.Message{MessageID: "m2", ConversationID: "c1", SenderName: "Alice", Body: "menu: https://nopa.com/menu", TimestampMS: 2000})
+
+	req := mcp.CallToolRequest{}
+	req.Params.Arguments = map[string]any{"filter": "link"}
+	result, _ := getMessagesHandler(a)(context.Background(), req)
+	text := result.Content[0].(mcp.TextContent).Text
+	if !contains(text, "nopa.com/menu") || contains(text, "lunch?") {
+		t.Errorf("link filter output: %s", text)
+	}
+
+	req.Params.Arguments = map[string]any{"filter": "stickers"}
+	result, _ = getMessagesHandler(a)(context.Background(), req)
+	if !result.IsError {
+		t.Error("expected error for invalid filter")
+	}
+}
+
+func TestSearchMessagesRegex(t *testing.T) {
+	a := testApp(t)
+	a.Store.UpsertMessage(&db.Message{MessageID: "m1", ConversationID: "c1", SenderName: "Bob", Body: "code 48213", TimestampMS: 1000})
+	a.Store.UpsertMessage(&db.Message{MessageID: "m2", ConversationID: "c1", SenderName: "Bob", Body: "code soon", TimestampMS: 2000})
+
+	req := mcp.CallToolRequest{}
+	req.Params.Arguments = map[string]any{"query": `code \d+`, "regex": true}
+	result, _ := searchMessagesHandler(a)(context.Background(), req)
+	text := result.Content[0].(mcp.TextContent).Text
+	if !contains(text, "48213") || contains(text, "code soon") {
+		t.Errorf("regex search output: %s", text)
+	}
+
+	req.Params.Arguments = map[string]any{"query": "code [", "regex": true}
+	result, _ = searchMessagesHandler(a)(context.Background(), req)
+	if !result.IsError || !contains(result.Content[0].(mcp.TextContent).Text, "invalid regex") {
+		t.Error("expected invalid regex error")
+	}
+}
+
+func TestCountMessages(t *testing.T) {
+	a := testApp(t)
+	for i, body := range []string{"invoice sent", "invoice paid", "lunch?"} {
+		a.Store.UpsertMessage(&db.Message{MessageID: fmt.Sprintf("m%d", i), ConversationID: "c1", SenderNumber: "+1555", Body: body, TimestampMS: 1000})
+	}
+
+	req := mcp.CallToolRequest{}
+	req.Params.Arguments = map[string]any{"query": "invoice", "phone_number": "+1555"}
+	result, _ := countMessagesHandler(a)(context.Background(), req)
+	if text := result.Content[0].(mcp.TextContent).Text; result.IsError || !contains(text, "2 messages match") {
+		t.Errorf("count output: %s", text)
+	}
+
+	req.Params.Arguments = map[string]any{"query": "invoice", "after": "Jan 1"}
+	result, _ = countMessagesHandler(a)(context.Background(), req)
+	if !result.IsError || !contains(result.Content[0].(mcp.TextContent).Text, "invalid 'after' date") {
+		t.Error("expected invalid date error")
+	}
+}
+
+func TestBackfillConversationErrors(t *testing.T) {
+	a := testApp(t)
+	handler := backfillConversationHandler(a)
+
+	for _, tc := range []struct {
+		args map[string]any
+		want string
+	}{
+		{map[string]any{}, "conversation_id is required"},
+		{map[string]any{"conversation_id": "c1", "max_messages": float64(-1)}, "must not be negative"},
+		{map[string]any{"conversation_id": "c1"}, "not connected"},
+	} {
+		req := mcp.CallToolRequest{}
+		req.Params.Arguments = tc.args
+		result, _ := handler(context.Background(), req)
+		text := result.Content[0].(mcp.TextContent).Text
+		if !result.IsError || !contains(text, tc.want) {
+			t.Errorf("args %v: got %q, want error containing %q", tc.args, text, tc.want)
+		}
+	}
+}
+
+func TestExportConversation(t *testing.T) {
+	a := testApp(t)
+	a.Store.UpsertConversation(&db.Conversation{ConversationID: "c1", Name: "Sarah"})
+	a.Store.UpsertMessage(&db.Message{MessageID: "m1", ConversationID: "c1", SenderName: "Sarah", Body: "hello", TimestampMS: 1000})
+
+	handler := exportConversationHandler(a)
+	req := mcp.CallToolRequest{}
+	req.Params.Arguments = map[string]any{"conversation_id": "c1"}
+	result, err := handler(context.Background(), req)
+	if err != nil || result.IsError {
+		t.Fatalf("export: %v %+v", err, result)
+	}
+	text := result.Content[0].(mcp.TextContent).Text
+	var out struct {
+		Messages []map[string]any `json:"messages"`
+	}
+	if err := json.Unmarshal([]byte(text), &out); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, text)
+	}
+	if len(out.Messages) != 1 || out.Messages[0]["body"] != "hello" || out.Messages[0]["direction"] != "incoming" {
+		t.Errorf("messages = %+v", out.Messages)
+	}
+
+	req.Params.Arguments = map[string]any{"conversation_id": "missing"}
+	result, _ = handler(context.Background(), req)
+	if !result.IsError || !contains(result.Content[0].(mcp.TextContent).Text, "not found") {
+		t.Error("expected not found error")
+	}
+}
+
 func TestDownloadMediaMissingID(t *testing.T) {
 	a := testApp(t)
 
@@ -444,6 +1101,140 @@ func TestDownloadMediaMissingID(t *testing.T) {
 	}
 }
 
+type fakeDownloader struct {
+	calls int
+}
+
+func (f *fakeDownloader) DownloadMedia(mediaID string, key []byte) ([]byte, error) {
+	f.calls++
+	return []byte("img:" + mediaID), nil
+}
+
+func TestMediaCacheReusesDownloads(t *testing.T) {
+	cache := newMediaCache()
+	gm := &fakeDownloader{}
+	msg := &db.Message{MessageID: "m1", MediaID: "mid-1", DecryptionKey: "deadbeef"}
+
+	for i := 0; i < 2; i++ {
+		data, err := cache.get(gm, msg)
+		if err != nil || string(data) != "img:mid-1" {
+			t.Fatalf("get = %q, %v", data, err)
+		}
+	}
+	if gm.calls != 1 {
+		t.Errorf("downloads = %d, want 1", gm.calls)
+	}
+
+	// Expired entries are fetched again.
+	cache.entries["m1"] = cachedMedia{data: []byte("stale"), fetched: time.Now().Add(-mediaCacheTTL)}
+	if data, _ := cache.get(gm, msg); string(data) != "img:mid-1" || gm.calls != 2 {
+		t.Errorf("after expiry: data = %q, downloads = %d", data, gm.calls)
+	}
+
+	for i := 0; i < mediaCacheEntries+5; i++ {
+		cache.get(gm, &db.Message{MessageID: fmt.Sprintf("x%d", i), MediaID: "x"})
+	}
+	if len(cache.entries) > mediaCacheEntries {
+		t.Errorf("cache holds %d entries, want at most %d", len(cache.entries), mediaCacheEntries)
+	}
+}
+
+func TestGetMedia(t *testing.T) {
+	a := testApp(t)
+	a.Store.UpsertMessage(&db.Message{MessageID: "photo", ConversationID: "c1", MediaID: "mid-1", MimeType: "image/jpeg", DecryptionKey: "deadbeef"})
+	a.Store.UpsertMessage(&db.Message{MessageID: "huge", ConversationID: "c1", MediaID: "mid-2", MimeType: "image/png", MediaSize: maxInlineMediaBytes + 1})
+	a.Store.UpsertMessage(&db.Message{MessageID: "voice", ConversationID: "c1", MediaID: "mid-3", MimeType: "audio/ogg", MediaSize: 4096})
+	a.Store.UpsertMessage(&db.Message{MessageID: "text", ConversationID: "c1", Body: "hi"})
+
+	handler := getMediaHandler(a)
+	tests := []struct {
+		id      string
+		isError bool
+		want    string
+	}{
+		{"photo", true, "not connected"},
+		{"huge", true, "over the"},
+		{"voice", false, "audio/ogg attachment (4096 bytes)"},
+		{"text", true, "no media"},
+		{"missing", true, "not found"},
+		{"", true, "message_id is required"},
+	}
+	for _, tt := range tests {
+		req := mcp.CallToolRequest{}
+		req.Params.Arguments = map[string]any{"message_id": tt.id}
+		result, err := handler(context.Background(), req)
+		if err != nil {
+			t.Fatalf("%s: handler error: %v", tt.id, err)
+		}
+		text := result.Content[0].(mcp.TextContent).Text
+		if result.IsError != tt.isError || !contains(text, tt.want) {
+			t.Errorf("%s: IsError = %v, text = %q; want %v, %q", tt.id, result.IsError, text, tt.isError, tt.want)
+		}
+	}
+}
+
+func TestInlineImage(t *testing.T) {
+	msg := &db.Message{MessageID: "photo", MediaID: "mid-1", MimeType: "image/jpeg", SenderName: "Ann", Body: "look"}
+	result := inlineImage(newMediaCache(), &fakeDownloader{}, msg)
+	if result.IsError || len(result.Content) != 2 {
+		t.Fatalf("result = %+v", result)
+	}
+	img, ok := result.Content[1].(mcp.ImageContent)
+	if !ok {
+		t.Fatalf("content[1] = %T, want mcp.ImageContent", result.Content[1])
+	}
+	if img.MIMEType != "image/jpeg" || img.Data != base64.StdEncoding.EncodeToString([]byte("img:mid-1")) {
+		t.Errorf("image = %+v", img)
+	}
+	if text := result.Content[0].(mcp.TextContent).Text; !contains(text, "Ann") || !contains(text, "look") {
+		t.Errorf("caption = %q", text)
+	}
+
+	cache := newMediaCache()
+	cache.entries["big"] = cachedMedia{data: make([]byte, maxInlineMediaBytes+1), fetched: time.Now()}
+	result = inlineImage(cache, &fakeDownloader{}, &db.Message{MessageID: "big", MediaID: "mid-2", MimeType: "image/png"})
+	if !result.IsError || !contains(result.Content[0].(mcp.TextContent).Text, fmt.Sprint(maxInlineMediaBytes+1)) {
+		t.Errorf("oversized image: result = %+v", result.Content)
+	}
+}
+
+func TestMediaResources(t *testing.T) {
+	a := testApp(t)
+	a.Store.UpsertMessage(&db.Message{MessageID: "photo", ConversationID: "c1", MediaID: "mid-1", MimeType: "image/jpeg", DecryptionKey: "deadbeef", TimestampMS: 2000})
+	a.Store.UpsertMessage(&db.Message{MessageID: "text", ConversationID: "c1", Body: "hi", TimestampMS: 1000})
+
+	s := server.NewMCPServer("gmessages-test", "0.1.0")
+	Register(s, a)
+	resp := s.HandleMessage(context.Background(), []byte(`{"jsonrpc":"2.0","id":1,"method":"resources/list"}`))
+	list, ok := resp.(mcp.JSONRPCResponse)
+	if !ok {
+		t.Fatalf("resources/list response = %#v", resp)
+	}
+	res := list.Result.(mcp.ListResourcesResult).Resources
+	if len(res) != 1 || res[0].URI != "gmessages://media/photo" || res[0].MIMEType != "image/jpeg" {
+		t.Errorf("resources = %+v", res)
+	}
+
+	handler := mediaResourceHandler(a, newMediaCache())
+	tests := []struct {
+		uri  string
+		want string
+	}{
+		{"gmessages://media/photo", "not connected"},
+		{"gmessages://media/text", "no media attachment"},
+		{"gmessages://media/missing", "not found"},
+		{"other://photo", "invalid media URI"},
+	}
+	for _, tt := range tests {
+		req := mcp.ReadResourceRequest{}
+		req.Params.URI = tt.uri
+		_, err := handler(context.Background(), req)
+		if err == nil || !contains(err.Error(), tt.want) {
+			t.Errorf("%s: err = %v, want %q", tt.uri, err, tt.want)
+		}
+	}
+}
+
 func contains(s, substr string) bool {
 	return len(s) >= len(substr) && (s == substr || len(s) > 0 && containsStr(s, substr))
 }
@@ -0,0 +1,106 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// accessLog wraps h to log one line per request. Query values and bodies can
+// hold search text or message content, so only query key names with value
+// lengths and the request body size are recorded. Phone numbers in the path
+// are replaced by a placeholder; see redactPath.
+func accessLog(h http.Handler, logger zerolog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(sw, r)
+
+		ev := logger.Info().
+			Str("method", r.Method).
+			Str("path", redactPath(r.URL.Path)).
+			Int("status", sw.status).
+			Dur("duration", time.Since(start))
+		if q := redactQuery(r.URL.Query()); q != "" {
+			ev = ev.Str("query", q)
+		}
+		if r.ContentLength > 0 {
+			ev = ev.Int64("body_bytes", r.ContentLength)
+		}
+		ev.Msg("HTTP request")
+	})
+}
+
+// numberPaths are the path prefixes whose next segment is a phone number.
+var numberPaths = []string{"/api/contacts/"}
+
+// redactPath replaces a phone number in path with "{number}", e.g.
+// "/api/contacts/+15551234567/avatar" becomes "/api/contacts/{number}/avatar".
+func redactPath(path string) string {
+	for _, prefix := range numberPaths {
+		rest, ok := strings.CutPrefix(path, prefix)
+		if !ok || rest == "" {
+			continue
+		}
+		_, tail, found := strings.Cut(rest, "/")
+		if found {
+			tail = "/" + tail
+		}
+		return prefix + "{number}" + tail
+	}
+	return path
+}
+
+// redactQuery renders query parameters as "key:len" pairs, e.g. "limit:2 q:11".
+func redactQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		n := 0
+		for _, v := range q[k] {
+			n += len(v)
+		}
+		parts = append(parts, fmt.Sprintf("%s:%d", k, n))
+	}
+	return strings.Join(parts, " ")
+}
+
+// statusWriter records the response status. It passes through Flush so
+// streaming endpoints keep working when logging is on.
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.status = code
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,76 @@
+package web
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+
+	"github.com/maxghenis/openmessage/internal/db"
+)
+
+func TestAccessLogRedactsContent(t *testing.T) {
+	store, err := db.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	var buf bytes.Buffer
+	logger := zerolog.New(&buf)
+	srv := httptest.NewServer(APIHandlerWithOptions(store, nil, logger, nil, Options{AccessLog: true}))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/api/search?q=dinner+at+eight&limit=5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	resp, err = http.Post(srv.URL+"/api/send", "application/json",
+		strings.NewReader(`{"conversation_id":"c1","message":"my secret plans"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	resp, err = http.Get(srv.URL + "/api/contacts/+15551234567/avatar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	out := buf.String()
+	for _, leaked := range []string{"dinner", "eight", "secret", "plans", "5551234567"} {
+		if strings.Contains(out, leaked) {
+			t.Errorf("access log leaked %q:\n%s", leaked, out)
+		}
+	}
+	for _, want := range []string{`"path":"/api/search"`, `"query":"limit:1 q:15"`, `"status":200`, `"path":"/api/send"`, `"status":503`, `"body_bytes":`, `"path":"/api/contacts/{number}/avatar"`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %s in access log:\n%s", want, out)
+		}
+	}
+}
+
+func TestAccessLogOff(t *testing.T) {
+	store, err := db.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	var buf bytes.Buffer
+	srv := httptest.NewServer(APIHandlerWithOptions(store, nil, zerolog.New(&buf), nil, Options{}))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/api/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if strings.Contains(buf.String(), "HTTP request") {
+		t.Errorf("expected no access log when disabled, got:\n%s", buf.String())
+	}
+}
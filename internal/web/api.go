@@ -1,15 +1,19 @@
 package web
 
 import (
+	"context"
+	"database/sql"
 	"embed"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
-	"math/rand"
+	"mime/multipart"
 	"time"
 	"net/http"
+	"regexp"
 	"strconv"
 	"strings"
 
@@ -37,18 +41,196 @@ type UnpairFunc func() error
 // Returns the public URL. If nil, download endpoint is not available.
 type MediaUploader func(messageID string) (string, error)
 
+// SyncRetrier re-sends pending and failed Supabase upserts, returning how
+// many succeeded and failed. If nil, /api/sync/retry is not available.
+type SyncRetrier func() (succeeded, failed int, err error)
+
 func APIHandler(store *db.Store, cli *client.Client, logger zerolog.Logger, mcpHandler http.Handler, onDeepBackfill ...func()) http.Handler {
 	return APIHandlerFull(store, cli, logger, mcpHandler, nil, nil, nil, onDeepBackfill...)
 }
 
 func APIHandlerFull(store *db.Store, cli *client.Client, logger zerolog.Logger, mcpHandler http.Handler, isConnected StatusChecker, unpair UnpairFunc, mediaUploader MediaUploader, onDeepBackfill ...func()) http.Handler {
+	opts := Options{IsConnected: isConnected, Unpair: unpair, MediaUploader: mediaUploader}
+	if len(onDeepBackfill) > 0 {
+		f := onDeepBackfill[0]
+		opts.OnDeepBackfill = func(bool) error {
+			go f()
+			return nil
+		}
+	}
+	return APIHandlerWithOptions(store, cli, logger, mcpHandler, opts)
+}
+
+// Options holds the optional hooks and limits for APIHandlerWithOptions.
+// The zero value is valid.
+type Options struct {
+	IsConnected   StatusChecker
+	Unpair        UnpairFunc
+	MediaUploader MediaUploader
+	RetrySync     SyncRetrier
+
+	// BackfillConversation starts fetching up to maxMessages (0 for all) of
+	// a conversation's history from Google in the background for POST
+	// /api/conversations/{id}/backfill. Fully backfilled conversations are
+	// skipped unless force is set. client.ErrBackfillRunning and
+	// client.ErrSyncPaused are answered with 409.
+	BackfillConversation func(convID string, maxMessages int, force bool) error
+
+	// BackfillProgress reports how many messages the latest
+	// BackfillConversation run for a conversation has stored and whether it
+	// is still running, for GET /api/conversations/{id}/backfill. Nil
+	// reports nothing stored.
+	BackfillProgress func(convID string) (stored int, running bool)
+
+	// OnDeepBackfill starts a deep backfill in the background for POST
+	// /api/backfill; force re-fetches fully backfilled conversations. An
+	// error means none was started: client.ErrBackfillRunning and
+	// client.ErrSyncPaused are answered with 409 and client.ErrNotConnected
+	// with 503.
+	OnDeepBackfill func(force bool) error
+
+	// PairingPath is the pairing metadata file reported by /api/status.
+	PairingPath string
+
+	// MaxMediaDownloads caps concurrent /api/media downloads from the phone.
+	// Zero uses defaultMaxMediaDownloads.
+	MaxMediaDownloads int
+
+	// Supabase receives starred-flag changes and read positions. Nil keeps
+	// both local.
+	Supabase client.SupabaseSync
+
+	// SendReadReceipts tells the phone when a thread is read via mark_read=1.
+	SendReadReceipts bool
+
+	// AccessLog logs each request's method, path, status, and duration.
+	// Bodies and query values are never logged.
+	AccessLog bool
+
+	// Typing provides live typing indicators for /api/typing. Nil reports
+	// nobody typing.
+	Typing *client.TypingTracker
+
+	// Deliveries backs /api/send's wait_for_delivery option.
+	Deliveries *client.DeliveryWaiter
+
+	// Events feeds the /api/events stream. Nil disables it.
+	Events EventSource
+
+	// Publish, if set, announces store changes made outside the event
+	// handler, such as a background upload's status, to /api/events.
+	Publish func(client.StoreEvent)
+
+	// Go runs background work that must finish before the store closes.
+	// Nil starts a plain goroutine.
+	Go func(func())
+
+	// Sync backs /api/sync/pause and /api/sync/resume. Nil disables them.
+	Sync SyncPauser
+
+	// SearchLimit is the /api/search result count when no limit is given.
+	// Zero uses defaultSearchLimit.
+	SearchLimit int
+
+	// MaxUploadBytes caps the size of a /api/send-media upload. Zero uses
+	// defaultMaxUploadBytes.
+	MaxUploadBytes int64
+
+	// APIKey, if set, is required as a bearer token on every request.
+	APIKey string
+
+	// CORSOrigins lists the origins allowed to call the API from a browser;
+	// "*" allows any. Empty keeps it same-origin only.
+	CORSOrigins []string
+}
+
+// SyncPauser pauses and resumes processing of inbound events and backfill.
+// Reads and sends keep working while paused.
+type SyncPauser interface {
+	PauseSync(buffer bool)
+	ResumeSync() (replayed int)
+	SyncState() client.SyncGateState
+}
+
+// EventSource hands out subscriptions to store events. The returned
+// function unsubscribes; the channel closes if the subscriber falls behind.
+type EventSource interface {
+	Subscribe() (<-chan client.StoreEvent, func())
+}
+
+// defaultDeliveryTimeout and maxDeliveryTimeout bound how long, in seconds,
+// /api/send waits when wait_for_delivery is set. SMS often never reports
+// delivery, so the wait must end on its own.
+const (
+	defaultDeliveryTimeout = 30
+	maxDeliveryTimeout     = 120
+)
+
+// deliveryResult is added to /api/send's response when wait_for_delivery is set.
+type deliveryResult struct {
+	MessageID     string `json:"message_id"`
+	MessageStatus string `json:"message_status"`
+	Delivered     bool   `json:"delivered"`
+	TimedOut      bool   `json:"timed_out"`
+}
+
+// maxTypingWait caps how long, in seconds, /api/typing?wait= holds a request.
+const maxTypingWait = 30
+
+// conversationPage is /api/conversations' response with paginated=true.
+type conversationPage struct {
+	Conversations []*db.Conversation `json:"conversations"`
+	Total         int                `json:"total"`
+	Offset        int                `json:"offset"`
+	Limit         int                `json:"limit"`
+}
+
+// defaultSearchLimit is how many /api/search results are returned by default.
+const defaultSearchLimit = 50
+
+// defaultMaxUploadBytes is the default /api/send-media size limit, in line
+// with the largest files RCS carries.
+const defaultMaxUploadBytes = 100 << 20
+
+// uploadFormOverhead allows for the multipart boundaries and form fields
+// around an uploaded file, so a file of exactly the limit is accepted.
+const uploadFormOverhead = 64 << 10
+
+// defaultMaxMediaDownloads is enough to fill a thread's visible images without
+// flooding the phone with requests.
+const defaultMaxMediaDownloads = 4
+
+func APIHandlerWithOptions(store *db.Store, cli *client.Client, logger zerolog.Logger, mcpHandler http.Handler, opts Options) http.Handler {
 	mux := http.NewServeMux()
+	isConnected, unpair, mediaUploader := opts.IsConnected, opts.Unpair, opts.MediaUploader
+	downloads := newDownloadLimiter(opts.MaxMediaDownloads)
+	thumbs := newThumbnailCache()
+	started := time.Now()
+	searchLimit := defaultSearchLimit
+	if opts.SearchLimit > 0 {
+		searchLimit = opts.SearchLimit
+	}
+	maxUpload := int64(defaultMaxUploadBytes)
+	if opts.MaxUploadBytes > 0 {
+		maxUpload = opts.MaxUploadBytes
+	}
+	goBackground := opts.Go
+	if goBackground == nil {
+		goBackground = func(fn func()) { go fn() }
+	}
 
 	_ = mcpHandler // used in the return wrapper below
 
 	mux.HandleFunc("/api/conversations", func(w http.ResponseWriter, r *http.Request) {
 		limit := queryInt(r, "limit", 50)
-		convos, err := store.ListConversations(limit)
+		offset := max(queryInt(r, "offset", 0), 0)
+		since, err := queryTime(r, "active_since")
+		if err != nil {
+			httpError(w, "invalid active_since: "+err.Error(), 400)
+			return
+		}
+		includeArchived := r.URL.Query().Get("include_archived") == "true"
+		convos, err := store.ListConversationsPage(since, includeArchived, offset, limit)
 		if err != nil {
 			httpError(w, "list conversations: "+err.Error(), 500)
 			return
@@ -56,41 +238,154 @@ func APIHandlerFull(store *db.Store, cli *client.Client, logger zerolog.Logger,
 		if convos == nil {
 			convos = []*db.Conversation{}
 		}
-		writeJSON(w, convos)
+		if r.URL.Query().Get("paginated") != "true" {
+			writeJSON(w, convos)
+			return
+		}
+		total, err := store.CountConversations(since, includeArchived)
+		if err != nil {
+			httpError(w, "count conversations: "+err.Error(), 500)
+			return
+		}
+		writeJSON(w, conversationPage{Conversations: convos, Total: total, Offset: offset, Limit: limit})
 	})
 
 	mux.HandleFunc("/api/conversations/", func(w http.ResponseWriter, r *http.Request) {
-		// Parse: /api/conversations/{id}/messages
+		// Parse: /api/conversations/{id} or
+		// /api/conversations/{id}/{messages,older,participants,send-as,pin-message,pinned,export,gaps,message-status,archive,unarchive,pin,unpin,mute,unmute,stats}
 		path := strings.TrimPrefix(r.URL.Path, "/api/conversations/")
 		parts := strings.SplitN(path, "/", 2)
-		if len(parts) != 2 || parts[1] != "messages" {
+		if len(parts) == 1 && parts[0] != "" {
+			handlePatchConversation(w, r, store, parts[0])
+			return
+		}
+		if len(parts) != 2 {
+			httpError(w, "not found", 404)
+			return
+		}
+		switch parts[1] {
+		case "messages":
+		case "send-as":
+			handleSendAs(w, r, store, parts[0])
+			return
+		case "pin-message":
+			handlePinMessage(w, r, store, parts[0])
+			return
+		case "participants":
+			ps, err := store.ConversationParticipants(parts[0])
+			if errors.Is(err, sql.ErrNoRows) {
+				httpError(w, "conversation not found", 404)
+				return
+			}
+			if err != nil {
+				httpError(w, "get participants: "+err.Error(), 500)
+				return
+			}
+			if ps == nil {
+				ps = []*db.Participant{}
+			}
+			writeJSON(w, ps)
+			return
+		case "stats":
+			handleConversationStats(w, store, parts[0])
+			return
+		case "archive", "unarchive":
+			handleConversationFlag(w, r, parts[0], "archived", store.SetConversationArchived, parts[1] == "archive")
+			return
+		case "pin", "unpin":
+			handleConversationFlag(w, r, parts[0], "pinned", store.SetConversationPinned, parts[1] == "pin")
+			return
+		case "mute", "unmute":
+			handleConversationFlag(w, r, parts[0], "muted", store.SetConversationMuted, parts[1] == "mute")
+			return
+		case "older":
+			var gm client.MessageFetcher
+			if cli != nil {
+				gm = cli.GM
+			}
+			beforeTS := int64(queryInt(r, "before_ts", 0))
+			msgs, fetched, err := client.FetchOlderMessages(store, gm, opts.Supabase, parts[0], beforeTS, queryInt(r, "limit", 50))
+			if err != nil {
+				httpError(w, "fetch older messages: "+err.Error(), 502)
+				return
+			}
+			if len(msgs) > 0 {
+				w.Header().Set("X-Oldest-TS", strconv.FormatInt(msgs[len(msgs)-1].TimestampMS, 10))
+			}
+			w.Header().Set("X-Fetched-Remote", strconv.Itoa(fetched))
+			if msgs == nil {
+				msgs = []*db.Message{}
+			}
+			writeJSON(w, msgs)
+			return
+		case "message-status":
+			handleSetMessagesStatus(w, r, store, parts[0])
+			return
+		case "backfill":
+			handleBackfillConversation(w, r, store, cli, opts, parts[0])
+			return
+		case "gaps":
+			threshold := time.Duration(queryInt(r, "min_hours", 0)) * time.Hour
+			gaps, err := store.ConversationGaps(parts[0], threshold)
+			if err != nil {
+				httpError(w, "find gaps: "+err.Error(), 500)
+				return
+			}
+			if gaps == nil {
+				gaps = []*db.TimelineGap{}
+			}
+			writeJSON(w, gaps)
+			return
+		case "export":
+			var download MediaDownloader
+			if cli != nil {
+				download = func(mediaID string, key []byte) ([]byte, error) {
+					return downloads.do(r.Context(), func() ([]byte, error) {
+						return cli.GM.DownloadMedia(mediaID, key)
+					})
+				}
+			}
+			handleExport(w, r, store, download, parts[0])
+			return
+		case "pinned":
+			msgs, err := store.ListPinnedMessages(parts[0])
+			if err != nil {
+				httpError(w, "list pinned: "+err.Error(), 500)
+				return
+			}
+			if msgs == nil {
+				msgs = []*db.Message{}
+			}
+			writeJSON(w, msgs)
+			return
+		default:
 			httpError(w, "not found", 404)
 			return
 		}
 		convID := parts[0]
 		limit := queryInt(r, "limit", 100)
-		msgs, err := store.GetMessagesByConversation(convID, limit)
+		beforeTS := int64(queryInt(r, "before_ts", 0))
+		filter := db.MessageFilter{
+			HasLink:  r.URL.Query().Get("has_link") == "1",
+			HasMedia: r.URL.Query().Get("has_media") == "1",
+		}
+		msgs, err := store.GetMessagesByConversationFiltered(convID, beforeTS, limit, filter)
 		if err != nil {
 			httpError(w, "get messages: "+err.Error(), 500)
 			return
 		}
-		if msgs == nil {
-			msgs = []*db.Message{}
-		}
-		writeJSON(w, msgs)
-	})
-
-	mux.HandleFunc("/api/search", func(w http.ResponseWriter, r *http.Request) {
-		q := r.URL.Query().Get("q")
-		if q == "" {
-			httpError(w, "query parameter 'q' is required", 400)
-			return
+		// The oldest timestamp in the page is the before_ts for the next one.
+		if len(msgs) > 0 {
+			w.Header().Set("X-Oldest-TS", strconv.FormatInt(msgs[len(msgs)-1].TimestampMS, 10))
 		}
-		limit := queryInt(r, "limit", 50)
-		msgs, err := store.SearchMessages(q, "", limit)
-		if err != nil {
-			httpError(w, "search: "+err.Error(), 500)
-			return
+		if r.URL.Query().Get("mark_read") == "1" {
+			var gm client.ReadMarker
+			if cli != nil {
+				gm = cli.GM
+			}
+			if err := client.MarkConversationRead(store, gm, opts.Supabase, convID, msgs, opts.SendReadReceipts); err != nil {
+				logger.Warn().Err(err).Str("conv_id", convID).Msg("Failed to mark conversation read")
+			}
 		}
 		if msgs == nil {
 			msgs = []*db.Message{}
@@ -98,569 +393,1115 @@ func APIHandlerFull(store *db.Store, cli *client.Client, logger zerolog.Logger,
 		writeJSON(w, msgs)
 	})
 
-	mux.HandleFunc("/api/send", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/api/conversations/bulk", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			httpError(w, "method not allowed", 405)
 			return
 		}
 		var req struct {
-			ConversationID string `json:"conversation_id"`
-			Message        string `json:"message"`
-			ReplyToID      string `json:"reply_to_id,omitempty"`
+			IDs    []string `json:"ids"`
+			Action string   `json:"action"`
 		}
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			httpError(w, "invalid JSON: "+err.Error(), 400)
 			return
 		}
-		if req.ConversationID == "" || req.Message == "" {
-			httpError(w, "conversation_id and message are required", 400)
+		if len(req.IDs) == 0 {
+			httpError(w, "at least one id is required", 400)
 			return
 		}
-		if cli == nil {
-			httpError(w, "not connected to Google Messages", 503)
+		if len(req.IDs) > maxBulkConversations {
+			httpError(w, fmt.Sprintf("at most %d ids per request", maxBulkConversations), 400)
 			return
 		}
-		// Fetch conversation to get SIM and participant info
-		conv, err := cli.GM.GetConversation(req.ConversationID)
-		if err != nil {
-			httpError(w, "get conversation: "+err.Error(), 502)
+		switch req.Action {
+		case db.BulkArchive, db.BulkMute, db.BulkRead, db.BulkDelete:
+		default:
+			httpError(w, "action must be archive, mute, read, or delete", 400)
 			return
 		}
-
-		// Find our participant ID and SIM payload
-		var myParticipantID string
-		var simPayload *gmproto.SIMPayload
-		for _, p := range conv.GetParticipants() {
-			if p.GetIsMe() {
-				if id := p.GetID(); id != nil {
-					myParticipantID = id.GetNumber()
-				}
-				simPayload = p.GetSimPayload()
-				break
-			}
-		}
-
-		// Also try SIM card from conversation itself
-		var convSIMPayload *gmproto.SIMPayload
-		if sc := conv.GetSimCard(); sc != nil {
-			convSIMPayload = sc.GetSIMData().GetSIMPayload()
-		}
-		if simPayload == nil {
-			simPayload = convSIMPayload
-		}
-
-		payload := BuildSendPayload(req.ConversationID, req.Message, req.ReplyToID, myParticipantID, simPayload)
-
-		logger.Info().
-			Str("conv_id", req.ConversationID).
-			Str("participant_id", myParticipantID).
-			Bool("has_sim", simPayload != nil).
-			Msg("Sending message")
-
-		resp, err := cli.GM.SendMessage(payload)
+		results, err := store.BulkConversations(req.IDs, req.Action)
 		if err != nil {
-			httpError(w, "send message: "+err.Error(), 502)
+			httpError(w, "bulk "+req.Action+": "+err.Error(), 500)
 			return
 		}
-		success := resp.GetStatus() == gmproto.SendMessageResponse_SUCCESS
-		if success {
-			// Store sent message in DB immediately so UI shows it
-			now := time.Now().UnixMilli()
-			store.UpsertMessage(&db.Message{
-				MessageID:      payload.TmpID,
-				ConversationID: req.ConversationID,
-				Body:           req.Message,
-				IsFromMe:       true,
-				TimestampMS:    now,
-				Status:         "OUTGOING_SENDING",
-				ReplyToID:      req.ReplyToID,
-			})
-			// Bump conversation to top of list
-			store.UpdateConversationTimestamp(req.ConversationID, now)
-		}
-		writeJSON(w, map[string]any{
-			"status":  resp.GetStatus().String(),
-			"success": success,
-		})
+		writeJSON(w, map[string]any{"results": results})
 	})
 
-	mux.HandleFunc("/api/send-media", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			httpError(w, "method not allowed", 405)
+	mux.HandleFunc("/api/unread", func(w http.ResponseWriter, r *http.Request) {
+		convs, err := store.ListUnreadConversations(queryInt(r, "limit", 50))
+		if err != nil {
+			httpError(w, "list unread: "+err.Error(), 500)
 			return
 		}
-		if cli == nil {
-			httpError(w, "not connected to Google Messages", 503)
-			return
+		if convs == nil {
+			convs = []*db.UnreadConversation{}
 		}
+		writeJSON(w, convs)
+	})
 
-		// Parse multipart form (max 10MB)
-		if err := r.ParseMultipartForm(10 << 20); err != nil {
-			httpError(w, "invalid multipart form: "+err.Error(), 400)
+	mux.HandleFunc("/api/conversations-for-number", func(w http.ResponseWriter, r *http.Request) {
+		number := r.URL.Query().Get("number")
+		if number == "" {
+			httpError(w, "query parameter 'number' is required", 400)
 			return
 		}
-
-		convID := r.FormValue("conversation_id")
-		if convID == "" {
-			httpError(w, "conversation_id is required", 400)
+		convos, err := store.ConversationsForNumber(number)
+		if err != nil {
+			httpError(w, "list conversations: "+err.Error(), 500)
 			return
 		}
+		if convos == nil {
+			convos = []*db.Conversation{}
+		}
+		writeJSON(w, convos)
+	})
 
-		file, header, err := r.FormFile("file")
+	mux.HandleFunc("/api/needs-reply", func(w http.ResponseWriter, r *http.Request) {
+		limit := queryInt(r, "limit", 50)
+		convos, err := store.ListConversationsNeedingReply(limit)
 		if err != nil {
-			httpError(w, "file is required: "+err.Error(), 400)
+			httpError(w, "list conversations: "+err.Error(), 500)
 			return
 		}
-		defer file.Close()
+		if convos == nil {
+			convos = []*db.Conversation{}
+		}
+		writeJSON(w, convos)
+	})
 
-		data, err := io.ReadAll(file)
+	mux.HandleFunc("/api/last-contact", func(w http.ResponseWriter, r *http.Request) {
+		limit := queryInt(r, "limit", 50)
+		activity, err := store.ListContactActivity(r.URL.Query().Get("number"), limit)
 		if err != nil {
-			httpError(w, "read file: "+err.Error(), 500)
+			httpError(w, "contact activity: "+err.Error(), 500)
 			return
 		}
-
-		mime := header.Header.Get("Content-Type")
-		if mime == "" {
-			mime = "application/octet-stream"
+		type lastContact struct {
+			ContactID      string `json:"contact_id"`
+			Name           string `json:"name"`
+			Number         string `json:"number"`
+			LastMessageTS  int64  `json:"last_message_ts"`
+			ElapsedSeconds *int64 `json:"elapsed_seconds"`
+		}
+		now := time.Now()
+		out := make([]lastContact, 0, len(activity))
+		for _, c := range activity {
+			lc := lastContact{ContactID: c.ContactID, Name: c.Name, Number: c.Number, LastMessageTS: c.LastMessageTS}
+			if c.LastMessageTS > 0 {
+				elapsed := int64(now.Sub(time.UnixMilli(c.LastMessageTS)) / time.Second)
+				lc.ElapsedSeconds = &elapsed
+			}
+			out = append(out, lc)
 		}
+		writeJSON(w, out)
+	})
+
+	mux.HandleFunc("/api/star", func(w http.ResponseWriter, r *http.Request) {
+		handleStarMessage(w, r, store, opts.Supabase, logger)
+	})
 
-		// Upload media via libgm
-		media, err := cli.GM.UploadMedia(data, header.Filename, mime)
+	mux.HandleFunc("/api/starred", func(w http.ResponseWriter, r *http.Request) {
+		msgs, err := store.ListStarredMessages(queryInt(r, "limit", 100))
 		if err != nil {
-			httpError(w, "upload media: "+err.Error(), 502)
+			httpError(w, "list starred: "+err.Error(), 500)
 			return
 		}
+		if msgs == nil {
+			msgs = []*db.Message{}
+		}
+		writeJSON(w, msgs)
+	})
 
-		// Get SIM and participant info
-		conv, err := cli.GM.GetConversation(convID)
-		if err != nil {
-			httpError(w, "get conversation: "+err.Error(), 502)
+	mux.HandleFunc("/api/events", func(w http.ResponseWriter, r *http.Request) {
+		if opts.Events == nil {
+			httpError(w, "event stream not available", 503)
 			return
 		}
+		serveEvents(w, r, opts.Events)
+	})
 
-		var myParticipantID string
-		var simPayload *gmproto.SIMPayload
-		for _, p := range conv.GetParticipants() {
-			if p.GetIsMe() {
-				if id := p.GetID(); id != nil {
-					myParticipantID = id.GetNumber()
-				}
-				simPayload = p.GetSimPayload()
-				break
-			}
+	mux.HandleFunc("/api/typing", func(w http.ResponseWriter, r *http.Request) {
+		if opts.Typing == nil {
+			writeJSON(w, []client.TypingState{})
+			return
 		}
-		if simPayload == nil {
-			if sc := conv.GetSimCard(); sc != nil {
-				simPayload = sc.GetSIMData().GetSIMPayload()
-			}
+		// ?wait=N long-polls up to N seconds for the next change.
+		if wait := queryInt(r, "wait", 0); wait > 0 {
+			opts.Typing.Wait(r.Context(), time.Duration(min(wait, maxTypingWait))*time.Second)
 		}
+		writeJSON(w, opts.Typing.Active())
+	})
 
-		payload := BuildSendMediaPayload(convID, media, myParticipantID, simPayload)
-
-		logger.Info().
-			Str("conv_id", convID).
-			Str("mime", mime).
-			Str("filename", header.Filename).
-			Int("size", len(data)).
-			Msg("Sending media message")
-
-		resp, err := cli.GM.SendMessage(payload)
-		if err != nil {
-			httpError(w, "send message: "+err.Error(), 502)
-			return
-		}
-		success := resp.GetStatus() == gmproto.SendMessageResponse_SUCCESS
-		if success {
-			now := time.Now().UnixMilli()
-			store.UpsertMessage(&db.Message{
-				MessageID:      payload.TmpID,
-				ConversationID: convID,
-				Body:           "",
-				IsFromMe:       true,
-				TimestampMS:    now,
-				Status:         "OUTGOING_SENDING",
-				MediaID:        media.MediaID,
-				MimeType:       media.MimeType,
-				DecryptionKey:  hex.EncodeToString(media.DecryptionKey),
-			})
-			store.UpdateConversationTimestamp(convID, now)
+	mux.HandleFunc("/api/metrics", func(w http.ResponseWriter, r *http.Request) {
+		latency, err := store.SendLatencyStats()
+		if err != nil {
+			httpError(w, "send latency: "+err.Error(), 500)
+			return
 		}
-		writeJSON(w, map[string]any{
-			"status":  resp.GetStatus().String(),
-			"success": success,
-		})
+		writeJSON(w, map[string]any{"send_latency": latency})
 	})
 
-	mux.HandleFunc("/api/media/", func(w http.ResponseWriter, r *http.Request) {
-		msgID := strings.TrimPrefix(r.URL.Path, "/api/media/")
-		if msgID == "" {
-			httpError(w, "message_id required", 400)
+	mux.HandleFunc("/api/search", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query().Get("q")
+		if q == "" {
+			httpError(w, "query parameter 'q' is required", 400)
 			return
 		}
-		msg, err := store.GetMessageByID(msgID)
+		limit := queryInt(r, "limit", searchLimit)
+		order, err := db.ParseSearchOrder(r.URL.Query().Get("order"))
 		if err != nil {
-			httpError(w, "get message: "+err.Error(), 500)
+			httpError(w, err.Error(), 400)
 			return
 		}
-		if msg == nil || msg.MediaID == "" {
-			httpError(w, "no media for this message", 404)
-			return
+		var msgs []*db.Message
+		if r.URL.Query().Get("regex") == "1" {
+			if order == db.SearchOldest {
+				httpError(w, "order=oldest is not supported with regex", 400)
+				return
+			}
+			re, rerr := regexp.Compile(q)
+			if rerr != nil {
+				httpError(w, "invalid regex: "+rerr.Error(), 400)
+				return
+			}
+			msgs, err = store.SearchMessagesRegex(re, "", limit)
+		} else {
+			msgs, err = store.SearchMessagesOrdered(q, "", limit, order)
 		}
-		if cli == nil {
-			httpError(w, "not connected to Google Messages", 503)
+		if err != nil {
+			httpError(w, "search: "+err.Error(), 500)
 			return
 		}
-		// Decode hex decryption key
-		key, err := hex.DecodeString(msg.DecryptionKey)
-		if err != nil {
-			httpError(w, "invalid decryption key", 500)
+		if msgs == nil {
+			msgs = []*db.Message{}
+		}
+		writeJSON(w, msgs)
+	})
+
+	mux.HandleFunc("/api/search/count", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query().Get("q")
+		if q == "" {
+			httpError(w, "query parameter 'q' is required", 400)
 			return
 		}
-		data, err := cli.GM.DownloadMedia(msg.MediaID, key)
+		n, err := store.CountSearchMatches(q, db.SearchScope{
+			PhoneNumber:    r.URL.Query().Get("phone_number"),
+			ConversationID: r.URL.Query().Get("conversation_id"),
+			AfterMS:        int64(queryInt(r, "after_ts", 0)),
+			BeforeMS:       int64(queryInt(r, "before_ts", 0)),
+		})
 		if err != nil {
-			httpError(w, "download media: "+err.Error(), 502)
+			httpError(w, "count: "+err.Error(), 500)
 			return
 		}
-		w.Header().Set("Content-Type", msg.MimeType)
-		w.Header().Set("Cache-Control", "public, max-age=86400")
-		w.Write(data)
+		writeJSON(w, map[string]int{"count": n})
 	})
 
-	mux.HandleFunc("/api/react", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/api/contacts/rename", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			httpError(w, "method not allowed", 405)
 			return
 		}
 		var req struct {
-			ConversationID string `json:"conversation_id"`
-			MessageID      string `json:"message_id"`
-			Emoji          string `json:"emoji"`
-			Action         string `json:"action"` // "add", "remove", "switch"; default "add"
+			ContactID string `json:"contact_id"`
+			Name      string `json:"name"`
 		}
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			httpError(w, "invalid JSON: "+err.Error(), 400)
 			return
 		}
-		if req.MessageID == "" || req.Emoji == "" {
-			httpError(w, "message_id and emoji are required", 400)
-			return
-		}
-		if cli == nil {
-			httpError(w, "not connected to Google Messages", 503)
+		req.Name = strings.TrimSpace(req.Name)
+		if req.ContactID == "" || req.Name == "" {
+			httpError(w, "contact_id and name are required", 400)
 			return
 		}
-
-		// Get SIM payload from conversation
-		var sim *gmproto.SIMPayload
-		if req.ConversationID != "" {
-			if conv, err := cli.GM.GetConversation(req.ConversationID); err == nil {
-				if sc := conv.GetSimCard(); sc != nil {
-					sim = sc.GetSIMData().GetSIMPayload()
-				}
+		if err := store.RenameAutoContact(req.ContactID, req.Name); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				httpError(w, "no auto-created contact with that ID", 404)
+				return
 			}
+			httpError(w, "rename contact: "+err.Error(), 500)
+			return
 		}
+		writeJSON(w, map[string]any{"contact_id": req.ContactID, "name": req.Name})
+	})
 
-		payload := BuildReactionPayload(req.MessageID, req.Emoji, req.Action, sim)
-		resp, err := cli.GM.SendReaction(payload)
-		if err != nil {
-			httpError(w, "send reaction: "+err.Error(), 502)
+	mux.HandleFunc("/api/contacts/", func(w http.ResponseWriter, r *http.Request) {
+		// Parse: /api/contacts/{number}/avatar
+		number, sub, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/contacts/"), "/")
+		if number == "" || sub != "avatar" {
+			httpError(w, "not found", 404)
 			return
 		}
-		writeJSON(w, map[string]any{
-			"success": resp.GetSuccess(),
-		})
+		handleAvatar(w, r, store, number)
 	})
 
-	mux.HandleFunc("/api/new-conversation", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			httpError(w, "method not allowed", 405)
+	mux.HandleFunc("/api/search-all", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query().Get("q")
+		if q == "" {
+			httpError(w, "query parameter 'q' is required", 400)
 			return
 		}
-		var req struct {
-			PhoneNumber string `json:"phone_number"`
+		limit := queryInt(r, "limit", 10) // per result type
+
+		contacts, err := store.ListContacts(q, limit)
+		if err == nil && len(contacts) == 0 {
+			contacts, err = store.ListContactsFromConversations(q, limit)
 		}
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			httpError(w, "invalid JSON: "+err.Error(), 400)
+		if err != nil {
+			httpError(w, "search contacts: "+err.Error(), 500)
 			return
 		}
-		if req.PhoneNumber == "" {
-			httpError(w, "phone_number is required", 400)
+		convos, err := store.SearchConversations(q, limit)
+		if err != nil {
+			httpError(w, "search conversations: "+err.Error(), 500)
 			return
 		}
-		if cli == nil {
-			httpError(w, "not connected to Google Messages", 503)
+		msgs, err := store.SearchMessages(q, "", limit)
+		if err != nil {
+			httpError(w, "search messages: "+err.Error(), 500)
 			return
 		}
 
-		convResp, err := cli.GM.GetOrCreateConversation(&gmproto.GetOrCreateConversationRequest{
-			Numbers: []*gmproto.ContactNumber{
-				{
-					MysteriousInt: 7,
-					Number:        req.PhoneNumber,
-					Number2:       req.PhoneNumber,
-				},
-			},
-		})
-		if err != nil {
-			httpError(w, "failed to get/create conversation: "+err.Error(), 502)
+		results := []searchResult{}
+		for _, c := range contacts {
+			results = append(results, searchResult{Type: "contact", Contact: c})
+		}
+		for _, c := range convos {
+			results = append(results, searchResult{Type: "conversation", Conversation: c})
+		}
+		for _, m := range msgs {
+			results = append(results, searchResult{Type: "message", Message: m})
+		}
+		writeJSON(w, results)
+	})
+
+	mux.HandleFunc("/api/send", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			httpError(w, "method not allowed", 405)
+			return
+		}
+		var req struct {
+			ConversationID string `json:"conversation_id"`
+			Message        string `json:"message"`
+			ReplyToID      string `json:"reply_to_id,omitempty"`
+			QuoteOriginal  bool   `json:"quote_original,omitempty"`
+			// WaitForDelivery holds the response until the message is
+			// delivered, fails, or DeliveryTimeout seconds pass.
+			WaitForDelivery bool `json:"wait_for_delivery,omitempty"`
+			DeliveryTimeout int  `json:"delivery_timeout,omitempty"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			httpError(w, "invalid JSON: "+err.Error(), 400)
 			return
 		}
-		conv := convResp.GetConversation()
-		if conv == nil {
-			httpError(w, "no conversation returned", 502)
+		if req.ConversationID == "" || req.Message == "" {
+			httpError(w, "conversation_id and message are required", 400)
 			return
 		}
-
-		convoID := conv.GetConversationID()
-		name := req.PhoneNumber
-		// Try to get a name from participants
-		for _, p := range conv.GetParticipants() {
-			if !p.GetIsMe() {
-				if fn := p.GetFormattedNumber(); fn != "" {
-					name = fn
-				}
-				if cn := p.GetFullName(); cn != "" {
-					name = cn
-				}
+		if cli == nil {
+			notConnected(w)
+			return
+		}
+		if req.WaitForDelivery && opts.Deliveries == nil {
+			httpError(w, "delivery tracking is not available", 503)
+			return
+		}
+		// SMS recipients don't see native replies, so optionally inline a
+		// quote of the original message in the outgoing text.
+		body := req.Message
+		if req.QuoteOriginal && req.ReplyToID != "" {
+			if orig, err := store.GetMessageByID(req.ReplyToID); err == nil && orig != nil {
+				body = QuoteReplyBody(orig.Body, req.Message)
 			}
 		}
 
-		// Upsert into local DB so it shows in the sidebar
-		store.UpsertConversation(&db.Conversation{
-			ConversationID: convoID,
-			Name:           name,
-			LastMessageTS:  time.Now().UnixMilli(),
-		})
+		logger.Info().
+			Str("conv_id", req.ConversationID).
+			Msg("Sending message")
 
-		writeJSON(w, map[string]any{
-			"conversation_id": convoID,
-			"name":            name,
-		})
+		// Watch before sending so a fast echo can't slip past.
+		tmpID := client.NewTmpID()
+		var watch *client.DeliveryWatch
+		if req.WaitForDelivery {
+			watch = opts.Deliveries.Watch(tmpID)
+			defer watch.Close()
+		}
+
+		res, err := client.SendTextWithTmpID(store, cli.GM, req.ConversationID, tmpID, body, req.ReplyToID)
+		if err != nil {
+			httpError(w, err.Error(), 502)
+			return
+		}
+		result := struct {
+			*client.SendResult
+			*deliveryResult
+		}{SendResult: res}
+		if res.Success && watch != nil {
+			timeout := req.DeliveryTimeout
+			if timeout <= 0 {
+				timeout = defaultDeliveryTimeout
+			}
+			u, final := watch.Wait(r.Context(), time.Duration(min(timeout, maxDeliveryTimeout))*time.Second)
+			result.deliveryResult = &deliveryResult{
+				MessageID:     u.MessageID,
+				MessageStatus: u.Status,
+				Delivered:     u.Delivered(),
+				TimedOut:      !final,
+			}
+		}
+		writeJSON(w, result)
 	})
 
-	mux.HandleFunc("/api/mark-read", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/api/send-bulk", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			httpError(w, "method not allowed", 405)
 			return
 		}
 		var req struct {
-			ConversationID string `json:"conversation_id"`
+			ConversationIDs []string `json:"conversation_ids"`
+			Message         string   `json:"message"`
 		}
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			httpError(w, "invalid JSON: "+err.Error(), 400)
 			return
 		}
-		if req.ConversationID == "" {
-			httpError(w, "conversation_id is required", 400)
+		if len(req.ConversationIDs) == 0 || req.Message == "" {
+			httpError(w, "conversation_ids and message are required", 400)
 			return
 		}
-		if err := store.MarkConversationRead(req.ConversationID); err != nil {
-			httpError(w, "mark read: "+err.Error(), 500)
+		if len(req.ConversationIDs) > client.MaxBulkSend {
+			httpError(w, fmt.Sprintf("at most %d conversation_ids per request", client.MaxBulkSend), 400)
 			return
 		}
-		writeJSON(w, map[string]string{"status": "ok"})
+		var gm client.TextClient
+		if cli != nil {
+			gm = cli.GM
+		}
+		logger.Info().Int("conversations", len(req.ConversationIDs)).Msg("Sending bulk message")
+		results := client.SendBulk(store, gm, req.ConversationIDs, req.Message)
+		sent := 0
+		for _, res := range results {
+			if res.Success {
+				sent++
+			}
+		}
+		writeJSON(w, map[string]any{"sent": sent, "failed": len(results) - sent, "results": results})
 	})
 
-	mux.HandleFunc("/api/drafts", func(w http.ResponseWriter, r *http.Request) {
-		conversationID := r.URL.Query().Get("conversation_id")
-		if conversationID == "" {
+	mux.HandleFunc("/api/send-media", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			httpError(w, "method not allowed", 405)
+			return
+		}
+		// Reject uploads that say they're too large before reading them.
+		if r.ContentLength > maxUpload+uploadFormOverhead {
+			uploadTooLarge(w, maxUpload)
+			return
+		}
+		if cli == nil {
+			notConnected(w)
+			return
+		}
+
+		// Files beyond 10MB are buffered on disk while parsing.
+		r.Body = http.MaxBytesReader(w, r.Body, maxUpload+uploadFormOverhead)
+		if err := r.ParseMultipartForm(10 << 20); err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				uploadTooLarge(w, maxUpload)
+				return
+			}
+			httpError(w, "invalid multipart form: "+err.Error(), 400)
+			return
+		}
+		defer r.MultipartForm.RemoveAll()
+
+		convID := r.FormValue("conversation_id")
+		if convID == "" {
 			httpError(w, "conversation_id is required", 400)
 			return
 		}
-		drafts, err := store.ListDrafts(conversationID)
+
+		headers := r.MultipartForm.File["file"]
+		if len(headers) == 0 {
+			httpError(w, "file is required", 400)
+			return
+		}
+		files, err := readUploadedFiles(headers)
 		if err != nil {
-			httpError(w, "list drafts: "+err.Error(), 500)
+			httpError(w, "read file: "+err.Error(), 500)
 			return
 		}
-		if drafts == nil {
-			drafts = []*db.Draft{}
+		caption := r.FormValue("caption")
+
+		total := 0
+		for _, f := range files {
+			total += len(f.Data)
+			logger.Info().
+				Str("conv_id", convID).
+				Str("mime", f.MimeType).
+				Str("filename", f.Filename).
+				Int("size", len(f.Data)).
+				Msg("Sending media message")
 		}
-		writeJSON(w, drafts)
+
+		tmpID := client.NewTmpID()
+
+		// Large uploads can take a while, so store an optimistic placeholder
+		// and finish in the background. /api/events announces the message
+		// as it goes from uploading to sent or failed.
+		if total > asyncMediaThreshold {
+			if err := store.UpsertMessage(&db.Message{
+				MessageID:       tmpID,
+				ConversationID:  convID,
+				Body:            caption,
+				IsFromMe:        true,
+				TimestampMS:     time.Now().UnixMilli(),
+				Status:          client.StatusUploading,
+				MimeType:        files[0].MimeType,
+				AttachmentCount: len(files),
+			}); err != nil {
+				httpError(w, "store message: "+err.Error(), 500)
+				return
+			}
+			publish := func() {
+				if opts.Publish != nil {
+					opts.Publish(client.StoreEvent{Type: client.StoreEventMessage, ConversationID: convID, MessageID: tmpID})
+				}
+			}
+			publish()
+			goBackground(func() {
+				defer publish()
+				if _, err := client.SendFiles(store, cli.GM, convID, tmpID, files, caption, caption); err != nil {
+					logger.Warn().Err(err).Str("conv_id", convID).Str("tmp_id", tmpID).Msg("Background media send failed")
+				}
+			})
+			writeJSON(w, &client.SendResult{Status: client.StatusUploading, Success: true, TmpID: tmpID})
+			return
+		}
+
+		res, err := client.SendFiles(store, cli.GM, convID, tmpID, files, caption, caption)
+		if err != nil {
+			httpError(w, err.Error(), 502)
+			return
+		}
+		writeJSON(w, res)
 	})
 
-	mux.HandleFunc("/api/drafts/send", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/api/send-contact", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			httpError(w, "method not allowed", 405)
 			return
 		}
 		var req struct {
-			DraftID string `json:"draft_id"`
-			Body    string `json:"body"`
+			ConversationID string `json:"conversation_id"`
+			ContactID      string `json:"contact_id,omitempty"`
+			Name           string `json:"name,omitempty"`
+			Number         string `json:"number,omitempty"`
 		}
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			httpError(w, "invalid JSON: "+err.Error(), 400)
 			return
 		}
-		if req.DraftID == "" || req.Body == "" {
-			httpError(w, "draft_id and body are required", 400)
+		if req.ConversationID == "" {
+			httpError(w, "conversation_id is required", 400)
+			return
+		}
+		if req.ContactID != "" {
+			c, err := store.GetContact(req.ContactID)
+			if err != nil {
+				httpError(w, "get contact: "+err.Error(), 500)
+				return
+			}
+			if c == nil {
+				httpError(w, "contact not found", 404)
+				return
+			}
+			req.Name, req.Number = c.Name, c.Number
+		}
+		if _, err := client.BuildVCard(req.Name, req.Number); err != nil {
+			httpError(w, err.Error(), 400)
 			return
 		}
 		if cli == nil {
-			httpError(w, "not connected to Google Messages", 503)
+			notConnected(w)
 			return
 		}
 
-		// Look up the draft to get conversation_id
-		draft, err := store.GetDraft(req.DraftID)
+		res, err := client.SendContactCard(store, cli.GM, req.ConversationID, req.Name, req.Number)
 		if err != nil {
-			httpError(w, "get draft: "+err.Error(), 500)
+			httpError(w, err.Error(), 502)
 			return
 		}
-		if draft == nil {
-			httpError(w, "draft not found", 404)
+		writeJSON(w, res)
+	})
+
+	mux.HandleFunc("/api/media/", func(w http.ResponseWriter, r *http.Request) {
+		msgID := strings.TrimPrefix(r.URL.Path, "/api/media/")
+		if msgID == "" {
+			httpError(w, "message_id required", 400)
 			return
 		}
-
-		// Use the same send logic as /api/send
-		conv, err := cli.GM.GetConversation(draft.ConversationID)
+		msg, err := store.GetMessageByID(msgID)
 		if err != nil {
-			httpError(w, "get conversation: "+err.Error(), 502)
+			httpError(w, "get message: "+err.Error(), 500)
 			return
 		}
-
-		var myParticipantID string
-		var simPayload *gmproto.SIMPayload
-		for _, p := range conv.GetParticipants() {
-			if p.GetIsMe() {
-				if id := p.GetID(); id != nil {
-					myParticipantID = id.GetNumber()
-				}
-				simPayload = p.GetSimPayload()
-				break
-			}
+		if msg == nil || msg.MediaID == "" {
+			httpError(w, "no media for this message", 404)
+			return
 		}
-		if simPayload == nil {
-			if sc := conv.GetSimCard(); sc != nil {
-				simPayload = sc.GetSIMData().GetSIMPayload()
-			}
+		width, err := thumbnailWidth(r)
+		if err != nil {
+			httpError(w, err.Error(), 400)
+			return
 		}
+		// Media for a message never changes, so the message ID is a stable
+		// ETag. Revalidations are answered without touching the phone.
+		etag := `"` + msgID + `"`
+		if width > 0 {
+			etag = thumbnailETag(msgID, width)
+		}
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		var gm mediaDownloader
+		if cli != nil {
+			gm = cli.GM
+		}
+		if width > 0 {
+			serveThumbnail(w, r, msg, gm, downloads, thumbs, width)
+			return
+		}
+		serveMedia(w, r, msg, gm, downloads)
+	})
 
-		payload := BuildSendPayload(draft.ConversationID, req.Body, "", myParticipantID, simPayload)
-
-		logger.Info().
-			Str("conv_id", draft.ConversationID).
-			Str("draft_id", req.DraftID).
-			Msg("Sending draft message")
-
-		resp, err := cli.GM.SendMessage(payload)
+	mux.HandleFunc("/api/media-usage", func(w http.ResponseWriter, r *http.Request) {
+		if convID := r.URL.Query().Get("conversation_id"); convID != "" {
+			count, bytes, err := store.ConversationMediaUsage(convID)
+			if err != nil {
+				httpError(w, "media usage: "+err.Error(), 500)
+				return
+			}
+			writeJSON(w, map[string]any{
+				"conversation_id": convID,
+				"media_count":     count,
+				"total_bytes":     bytes,
+			})
+			return
+		}
+		usage, err := store.ListMediaUsage()
 		if err != nil {
-			httpError(w, "send message: "+err.Error(), 502)
+			httpError(w, "media usage: "+err.Error(), 500)
 			return
 		}
-		success := resp.GetStatus() == gmproto.SendMessageResponse_SUCCESS
-		if success {
-			now := time.Now().UnixMilli()
-			store.UpsertMessage(&db.Message{
-				MessageID:      payload.TmpID,
-				ConversationID: draft.ConversationID,
-				Body:           req.Body,
-				IsFromMe:       true,
-				TimestampMS:    now,
-				Status:         "OUTGOING_SENDING",
-			})
-			store.UpdateConversationTimestamp(draft.ConversationID, now)
-			store.DeleteDraft(req.DraftID)
+		if usage == nil {
+			usage = []*db.MediaUsage{}
+		}
+		var total int64
+		for _, u := range usage {
+			total += u.TotalBytes
 		}
 		writeJSON(w, map[string]any{
-			"status":  resp.GetStatus().String(),
-			"success": success,
+			"conversations": usage,
+			"total_bytes":   total,
 		})
 	})
 
-	mux.HandleFunc("/api/drafts/", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodDelete {
+	mux.HandleFunc("/api/react", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
 			httpError(w, "method not allowed", 405)
 			return
 		}
-		draftID := strings.TrimPrefix(r.URL.Path, "/api/drafts/")
-		if draftID == "" {
-			httpError(w, "draft_id required", 400)
+		var req struct {
+			ConversationID string `json:"conversation_id"`
+			MessageID      string `json:"message_id"`
+			Emoji          string `json:"emoji"`
+			Action         string `json:"action"` // "add", "remove", "switch"; default "add"
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			httpError(w, "invalid JSON: "+err.Error(), 400)
 			return
 		}
-		if err := store.DeleteDraft(draftID); err != nil {
-			httpError(w, "delete draft: "+err.Error(), 500)
+		if req.MessageID == "" || req.Emoji == "" {
+			httpError(w, "message_id and emoji are required", 400)
 			return
 		}
-		writeJSON(w, map[string]string{"status": "ok"})
+		emoji, err := client.NormalizeReactionEmoji(req.Emoji)
+		if err != nil {
+			httpError(w, err.Error(), 400)
+			return
+		}
+		if cli == nil {
+			notConnected(w)
+			return
+		}
+
+		resp, err := client.SendReaction(store, cli.GM, req.ConversationID, req.MessageID, emoji, req.Action)
+		if errors.Is(err, client.ErrReactionPending) {
+			httpError(w, err.Error(), 409)
+			return
+		}
+		if err != nil {
+			httpError(w, "send reaction: "+err.Error(), 502)
+			return
+		}
+		writeJSON(w, map[string]any{
+			"success": resp.GetSuccess(),
+		})
 	})
 
-	mux.HandleFunc("/api/download", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/api/react-batch", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			httpError(w, "method not allowed", 405)
 			return
 		}
-		if mediaUploader == nil {
-			httpError(w, "media download not available (Supabase not configured)", 501)
+		var items []ReactionItem
+		if err := json.NewDecoder(r.Body).Decode(&items); err != nil {
+			httpError(w, "invalid JSON: "+err.Error(), 400)
 			return
 		}
-		var req struct {
-			MessageID      string `json:"message_id"`
-			ConversationID string `json:"conversation_id"`
-		}
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			httpError(w, "invalid JSON: "+err.Error(), 400)
+		if len(items) == 0 {
+			httpError(w, "at least one reaction is required", 400)
 			return
 		}
-		if req.MessageID == "" {
-			httpError(w, "message_id is required", 400)
+		if len(items) > maxReactionBatch {
+			httpError(w, fmt.Sprintf("at most %d reactions per batch", maxReactionBatch), 400)
 			return
 		}
-		url, err := mediaUploader(req.MessageID)
-		if err != nil {
-			httpError(w, "download media: "+err.Error(), 502)
+		if cli == nil {
+			notConnected(w)
 			return
 		}
-		writeJSON(w, map[string]string{"url": url})
+		writeJSON(w, map[string]any{
+			"results": applyReactionBatch(store, cli.GM, items, reactionBatchDelay),
+		})
 	})
 
-	mux.HandleFunc("/api/backfill", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/api/new-conversation", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			httpError(w, "method not allowed", 405)
 			return
 		}
-		if len(onDeepBackfill) > 0 && onDeepBackfill[0] != nil {
-			go onDeepBackfill[0]()
-			writeJSON(w, map[string]string{"status": "started"})
-		} else {
-			httpError(w, "deep backfill not available", 501)
+		var req struct {
+			PhoneNumber  string   `json:"phone_number"`
+			PhoneNumbers []string `json:"phone_numbers"`
+			Name         string   `json:"name"` // group name; ignored for one number
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			httpError(w, "invalid JSON: "+err.Error(), 400)
+			return
+		}
+		numbers := req.PhoneNumbers
+		if req.PhoneNumber != "" {
+			numbers = append([]string{req.PhoneNumber}, numbers...)
+		}
+		if len(numbers) == 0 {
+			httpError(w, "phone_number or phone_numbers is required", 400)
+			return
+		}
+		if cli == nil {
+			notConnected(w)
+			return
 		}
-	})
 
-	mux.HandleFunc("/api/status", func(w http.ResponseWriter, r *http.Request) {
-		connected := cli != nil
-		if isConnected != nil {
-			connected = isConnected()
+		_, conv, err := client.StartConversation(store, cli.GM, numbers, strings.TrimSpace(req.Name))
+		if err != nil {
+			httpError(w, err.Error(), 502)
+			return
 		}
 		writeJSON(w, map[string]any{
-			"connected": connected,
+			"conversation_id": conv.ConversationID,
+			"name":            conv.Name,
+			"is_group":        conv.IsGroup,
 		})
 	})
 
-	mux.HandleFunc("/api/unpair", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/api/mark-read", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			httpError(w, "method not allowed", 405)
 			return
 		}
-		if unpair != nil {
-			if err := unpair(); err != nil {
-				httpError(w, "unpair: "+err.Error(), 500)
-				return
-			}
+		var req struct {
+			ConversationID string `json:"conversation_id"`
 		}
-		writeJSON(w, map[string]string{"status": "ok"})
-	})
-
-	// Serve embedded static files at root
-	staticContent, err := fs.Sub(staticFS, "static")
-	if err != nil {
-		logger.Fatal().Err(err).Msg("Failed to create static sub-filesystem")
-	}
-	staticHandler := http.FileServer(http.FS(staticContent))
-	mux.Handle("/", staticHandler)
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			httpError(w, "invalid JSON: "+err.Error(), 400)
+			return
+		}
+		if req.ConversationID == "" {
+			httpError(w, "conversation_id is required", 400)
+			return
+		}
+		if err := store.MarkConversationRead(req.ConversationID); err != nil {
+			httpError(w, "mark read: "+err.Error(), 500)
+			return
+		}
+		if opts.Supabase != nil {
+			client.QueueReadPosition(store, opts.Supabase, req.ConversationID)
+		}
+		writeJSON(w, map[string]string{"status": "ok"})
+	})
+
+	mux.HandleFunc("/api/drafts", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			handleSaveDraft(w, r, store)
+			return
+		}
+		conversationID := r.URL.Query().Get("conversation_id")
+		if conversationID == "" {
+			httpError(w, "conversation_id is required", 400)
+			return
+		}
+		drafts, err := store.ListDrafts(conversationID)
+		if err != nil {
+			httpError(w, "list drafts: "+err.Error(), 500)
+			return
+		}
+		if drafts == nil {
+			drafts = []*db.Draft{}
+		}
+		writeJSON(w, drafts)
+	})
+
+	mux.HandleFunc("/api/drafts/send", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			httpError(w, "method not allowed", 405)
+			return
+		}
+		var req struct {
+			DraftID string `json:"draft_id"`
+			Body    string `json:"body"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			httpError(w, "invalid JSON: "+err.Error(), 400)
+			return
+		}
+		if req.DraftID == "" || req.Body == "" {
+			httpError(w, "draft_id and body are required", 400)
+			return
+		}
+		if cli == nil {
+			notConnected(w)
+			return
+		}
+
+		// Look up the draft to get conversation_id
+		draft, err := store.GetDraft(req.DraftID)
+		if err != nil {
+			httpError(w, "get draft: "+err.Error(), 500)
+			return
+		}
+		if draft == nil {
+			httpError(w, "draft not found", 404)
+			return
+		}
+
+		logger.Info().
+			Str("conv_id", draft.ConversationID).
+			Str("draft_id", req.DraftID).
+			Msg("Sending draft message")
+
+		res, err := client.SendText(store, cli.GM, draft.ConversationID, req.Body, "")
+		if err != nil {
+			httpError(w, err.Error(), 502)
+			return
+		}
+		if res.Success {
+			store.DeleteDraft(req.DraftID)
+		}
+		writeJSON(w, res)
+	})
+
+	// Clients hold the tmp_ ID from a send until the phone echoes the
+	// message back under its real ID; this resolves one to the other.
+	mux.HandleFunc("/api/messages/by-tmp/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			httpError(w, "method not allowed", 405)
+			return
+		}
+		tmpID := strings.TrimPrefix(r.URL.Path, "/api/messages/by-tmp/")
+		if tmpID == "" {
+			httpError(w, "tmp_id required", 400)
+			return
+		}
+		msg, err := store.GetMessageByTmpID(tmpID)
+		if err != nil {
+			httpError(w, "get message: "+err.Error(), 500)
+			return
+		}
+		if msg == nil {
+			httpError(w, "message not found", 404)
+			return
+		}
+		writeJSON(w, msg)
+	})
+
+	mux.HandleFunc("/api/messages/", func(w http.ResponseWriter, r *http.Request) {
+		// Parse: /api/messages/{id}, /api/messages/{id}/status or /api/messages/{id}/edits
+		messageID, sub, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/messages/"), "/")
+		if messageID == "" {
+			httpError(w, "message_id required", 400)
+			return
+		}
+		if sub == "status" {
+			handleStatusHistory(w, r, store, messageID)
+			return
+		}
+		if sub == "edits" {
+			handleEditHistory(w, r, store, messageID)
+			return
+		}
+		if sub != "" {
+			httpError(w, "not found", 404)
+			return
+		}
+		if r.Method != http.MethodDelete {
+			httpError(w, "method not allowed", 405)
+			return
+		}
+		var gm client.MessageDeleter
+		if cli != nil {
+			gm = cli.GM
+		}
+		res, err := client.DeleteMessage(store, gm, messageID)
+		if errors.Is(err, client.ErrMessageNotFound) {
+			httpError(w, "message not found", 404)
+			return
+		}
+		if err != nil {
+			httpError(w, err.Error(), 502)
+			return
+		}
+		writeJSON(w, res)
+	})
+
+	mux.HandleFunc("/api/drafts/", func(w http.ResponseWriter, r *http.Request) {
+		draftID := strings.TrimPrefix(r.URL.Path, "/api/drafts/")
+		if draftID == "" {
+			httpError(w, "draft_id required", 400)
+			return
+		}
+		if r.Method == http.MethodPatch {
+			handleUpdateDraft(w, r, store, draftID)
+			return
+		}
+		if r.Method != http.MethodDelete {
+			httpError(w, "method not allowed", 405)
+			return
+		}
+		if err := store.DeleteDraft(draftID); err != nil {
+			httpError(w, "delete draft: "+err.Error(), 500)
+			return
+		}
+		writeJSON(w, map[string]string{"status": "ok"})
+	})
+
+	mux.HandleFunc("/api/download", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			httpError(w, "method not allowed", 405)
+			return
+		}
+		if mediaUploader == nil {
+			httpError(w, "media download not available (Supabase not configured)", 501)
+			return
+		}
+		var req struct {
+			MessageID      string `json:"message_id"`
+			ConversationID string `json:"conversation_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			httpError(w, "invalid JSON: "+err.Error(), 400)
+			return
+		}
+		if req.MessageID == "" {
+			httpError(w, "message_id is required", 400)
+			return
+		}
+		// Media already copied to cloud storage is returned without
+		// downloading it from the phone again.
+		if msg, err := store.GetMessageByID(req.MessageID); err == nil && msg != nil && msg.MediaCloudURL != "" {
+			writeJSON(w, map[string]string{"url": msg.MediaCloudURL})
+			return
+		}
+		url, err := mediaUploader(req.MessageID)
+		if err != nil {
+			httpError(w, "download media: "+err.Error(), 502)
+			return
+		}
+		if err := store.SetMessageMediaCloudURL(req.MessageID, url); err != nil {
+			logger.Warn().Err(err).Str("msg_id", req.MessageID).Msg("Failed to record uploaded media URL")
+		}
+		writeJSON(w, map[string]string{"url": url})
+	})
+
+	mux.HandleFunc("/api/backfill", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			httpError(w, "method not allowed", 405)
+			return
+		}
+		if opts.Sync != nil && opts.Sync.SyncState().Paused {
+			httpError(w, "sync is paused", 409)
+			return
+		}
+		if opts.OnDeepBackfill == nil {
+			httpError(w, "deep backfill not available", 501)
+			return
+		}
+		var req struct {
+			Force bool `json:"force"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+			httpError(w, "invalid JSON: "+err.Error(), 400)
+			return
+		}
+		err := opts.OnDeepBackfill(req.Force)
+		switch {
+		case errors.Is(err, client.ErrBackfillRunning), errors.Is(err, client.ErrSyncPaused):
+			httpError(w, err.Error(), 409)
+			return
+		case errors.Is(err, client.ErrNotConnected):
+			notConnected(w)
+			return
+		case err != nil:
+			httpError(w, "backfill: "+err.Error(), 500)
+			return
+		}
+		writeJSON(w, map[string]string{"status": "started"})
+	})
+
+	mux.HandleFunc("/api/maintenance/rebuild", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			httpError(w, "method not allowed", 405)
+			return
+		}
+		steps, err := store.Rebuild(func(s db.RebuildStep) {
+			logger.Info().Str("step", s.Name).Int64("rows", s.Rows).Msg("Rebuilt derived data")
+		})
+		if err != nil {
+			httpError(w, "rebuild: "+err.Error(), 500)
+			return
+		}
+		writeJSON(w, map[string]any{"steps": steps})
+	})
+
+	mux.HandleFunc("/api/sync/retry", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			httpError(w, "method not allowed", 405)
+			return
+		}
+		if opts.RetrySync == nil {
+			httpError(w, "Supabase sync not configured", 404)
+			return
+		}
+		succeeded, failed, err := opts.RetrySync()
+		if errors.Is(err, client.ErrSyncInProgress) {
+			httpError(w, err.Error(), 409)
+			return
+		}
+		if err != nil {
+			httpError(w, "retry sync: "+err.Error(), 500)
+			return
+		}
+		writeJSON(w, map[string]int{
+			"succeeded": succeeded,
+			"failed":    failed,
+		})
+	})
+
+	mux.HandleFunc("/api/sync/pause", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			httpError(w, "method not allowed", 405)
+			return
+		}
+		if opts.Sync == nil {
+			httpError(w, "sync pausing not available", 404)
+			return
+		}
+		// Events are buffered for replay unless {"buffer": false} is sent.
+		req := struct {
+			Buffer *bool `json:"buffer"`
+		}{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+			httpError(w, "invalid JSON: "+err.Error(), 400)
+			return
+		}
+		opts.Sync.PauseSync(req.Buffer == nil || *req.Buffer)
+		writeJSON(w, opts.Sync.SyncState())
+	})
+
+	mux.HandleFunc("/api/sync/resume", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			httpError(w, "method not allowed", 405)
+			return
+		}
+		if opts.Sync == nil {
+			httpError(w, "sync pausing not available", 404)
+			return
+		}
+		replayed := opts.Sync.ResumeSync()
+		writeJSON(w, map[string]any{"replayed": replayed, "state": opts.Sync.SyncState()})
+	})
+
+	mux.HandleFunc("/api/status", func(w http.ResponseWriter, r *http.Request) {
+		connected := cli != nil
+		if isConnected != nil {
+			connected = isConnected()
+		}
+		status := map[string]any{
+			"connected": connected,
+		}
+		if n, err := store.TotalUnread(); err == nil {
+			status["unread"] = n
+		}
+		if opts.Sync != nil {
+			status["sync"] = opts.Sync.SyncState()
+		}
+		if opts.PairingPath != "" {
+			if info, err := client.LoadPairingInfo(opts.PairingPath); err == nil && info != nil {
+				status["pairing"] = info
+			}
+		}
+		writeJSON(w, status)
+	})
+
+	mux.HandleFunc("/api/health", func(w http.ResponseWriter, r *http.Request) {
+		connected := cli != nil
+		if isConnected != nil {
+			connected = isConnected()
+		}
+		handleHealth(w, r, store, cli, connected, opts.Supabase != nil, started)
+	})
+
+	mux.HandleFunc("/api/unpair", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			httpError(w, "method not allowed", 405)
+			return
+		}
+		if unpair != nil {
+			if err := unpair(); err != nil {
+				httpError(w, "unpair: "+err.Error(), 500)
+				return
+			}
+		}
+		writeJSON(w, map[string]string{"status": "ok"})
+	})
+
+	// Serve embedded static files at root
+	staticContent, err := fs.Sub(staticFS, "static")
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Failed to create static sub-filesystem")
+	}
+	staticHandler := http.FileServer(http.FS(staticContent))
+	mux.Handle("/", staticHandler)
 
 	// Wrap the mux to intercept /mcp/ requests before the mux's catch-all
+	var handler http.Handler = mux
 	if mcpHandler != nil {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			if strings.HasPrefix(r.URL.Path, "/mcp/") {
 				mcpHandler.ServeHTTP(w, r)
 				return
@@ -668,79 +1509,690 @@ func APIHandlerFull(store *db.Store, cli *client.Client, logger zerolog.Logger,
 			mux.ServeHTTP(w, r)
 		})
 	}
+	if opts.APIKey != "" {
+		handler = requireAPIKey(handler, opts.APIKey)
+	}
+	if len(opts.CORSOrigins) > 0 {
+		handler = allowCORS(handler, opts.CORSOrigins)
+	}
+	if opts.AccessLog {
+		handler = accessLog(handler, logger)
+	}
+	return handler
+}
 
-	return mux
-}
-
-// BuildSendPayload constructs a SendMessageRequest matching the format used by
-// the mautrix bridge: MessageInfo array (not MessagePayloadContent), TmpID in 3
-// places, SIMPayload, and ParticipantID.
-func BuildSendPayload(conversationID, message, replyToID, participantID string, sim *gmproto.SIMPayload) *gmproto.SendMessageRequest {
-	tmpID := fmt.Sprintf("tmp_%012d", rand.Int63n(1e12))
-	req := &gmproto.SendMessageRequest{
-		ConversationID: conversationID,
-		MessagePayload: &gmproto.MessagePayload{
-			TmpID:                 tmpID,
-			MessagePayloadContent: nil,
-			MessageInfo: []*gmproto.MessageInfo{{
-				Data: &gmproto.MessageInfo_MessageContent{MessageContent: &gmproto.MessageContent{
-					Content: message,
-				}},
-			}},
-			ConversationID: conversationID,
-			ParticipantID:  participantID,
-			TmpID2:         tmpID,
-		},
-		SIMPayload: sim,
-		TmpID:      tmpID,
-	}
-	if replyToID != "" {
-		req.Reply = &gmproto.ReplyPayload{
-			MessageID: replyToID,
-		}
-	}
-	return req
-}
-
-// BuildSendMediaPayload constructs a SendMessageRequest with a MediaContent attachment
-// instead of text. Uses the same MessageInfo array format as BuildSendPayload.
-func BuildSendMediaPayload(conversationID string, media *gmproto.MediaContent, participantID string, sim *gmproto.SIMPayload) *gmproto.SendMessageRequest {
-	tmpID := fmt.Sprintf("tmp_%012d", rand.Int63n(1e12))
-	return &gmproto.SendMessageRequest{
-		ConversationID: conversationID,
-		MessagePayload: &gmproto.MessagePayload{
-			TmpID:                 tmpID,
-			MessagePayloadContent: nil,
-			MessageInfo: []*gmproto.MessageInfo{{
-				Data: &gmproto.MessageInfo_MediaContent{MediaContent: media},
-			}},
-			ConversationID: conversationID,
-			ParticipantID:  participantID,
-			TmpID2:         tmpID,
-		},
-		SIMPayload: sim,
-		TmpID:      tmpID,
-	}
-}
-
-// BuildReactionPayload constructs a SendReactionRequest using gmproto.MakeReactionData
-// for proper emoji type mapping, matching the mautrix bridge format.
-func BuildReactionPayload(messageID, emoji, action string, sim *gmproto.SIMPayload) *gmproto.SendReactionRequest {
-	var a gmproto.SendReactionRequest_Action
-	switch strings.ToLower(action) {
-	case "remove":
-		a = gmproto.SendReactionRequest_REMOVE
-	case "switch":
-		a = gmproto.SendReactionRequest_SWITCH
+// handleSendAs serves GET and POST /api/conversations/{id}/send-as, which
+// read and update the conversation's preferred transport.
+func handleSendAs(w http.ResponseWriter, r *http.Request, store *db.Store, convID string) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, map[string]string{"send_as": client.ConversationSendAs(store, convID)})
+	case http.MethodPost:
+		var req struct {
+			SendAs string `json:"send_as"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			httpError(w, "invalid JSON: "+err.Error(), 400)
+			return
+		}
+		err := store.SetConversationSendAs(convID, req.SendAs)
+		if errors.Is(err, sql.ErrNoRows) {
+			httpError(w, "conversation not found", 404)
+			return
+		}
+		if err != nil {
+			httpError(w, err.Error(), 400)
+			return
+		}
+		writeJSON(w, map[string]string{"send_as": req.SendAs})
 	default:
-		a = gmproto.SendReactionRequest_ADD
+		httpError(w, "method not allowed", 405)
+	}
+}
+
+// handleConversationFlag serves the POST endpoints that set or clear a
+// local conversation flag: /archive and /unarchive, /pin and /unpin, or
+// /mute and /unmute.
+func handleConversationFlag(w http.ResponseWriter, r *http.Request, convID, name string, set func(string, bool) error, value bool) {
+	if r.Method != http.MethodPost {
+		httpError(w, "method not allowed", 405)
+		return
+	}
+	err := set(convID, value)
+	if errors.Is(err, sql.ErrNoRows) {
+		httpError(w, "conversation not found", 404)
+		return
+	}
+	if err != nil {
+		httpError(w, "set "+name+": "+err.Error(), 500)
+		return
+	}
+	writeJSON(w, map[string]any{"conversation_id": convID, name: value})
+}
+
+// handlePatchConversation serves PATCH /api/conversations/{id}, which
+// updates local-only conversation settings and returns the conversation.
+func handlePatchConversation(w http.ResponseWriter, r *http.Request, store *db.Store, convID string) {
+	if r.Method != http.MethodPatch {
+		httpError(w, "method not allowed", 405)
+		return
+	}
+	var req struct {
+		Color *string `json:"color"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, "invalid JSON: "+err.Error(), 400)
+		return
+	}
+	if req.Color != nil {
+		err := store.SetConversationColor(convID, *req.Color)
+		if errors.Is(err, sql.ErrNoRows) {
+			httpError(w, "conversation not found", 404)
+			return
+		}
+		if err != nil {
+			httpError(w, err.Error(), 400)
+			return
+		}
+	}
+	conv, err := store.GetConversation(convID)
+	if errors.Is(err, sql.ErrNoRows) {
+		httpError(w, "conversation not found", 404)
+		return
+	}
+	if err != nil {
+		httpError(w, "get conversation: "+err.Error(), 500)
+		return
+	}
+	writeJSON(w, conv)
+}
+
+// mediaDownloader is the subset of *libgm.Client used to fetch attachments.
+type mediaDownloader interface {
+	DownloadMedia(mediaID string, key []byte) ([]byte, error)
+}
+
+// serveMedia writes msg's attachment, downloaded from the phone with gm (nil
+// when not connected). If that fails, e.g. because Google has expired the
+// blob, and a copy was uploaded by /api/download, the request is redirected
+// to that copy instead.
+func serveMedia(w http.ResponseWriter, r *http.Request, msg *db.Message, gm mediaDownloader, downloads *downloadLimiter) {
+	data, ok := fetchMedia(w, r, msg, gm, downloads, msg.MediaID, msg.DecryptionKey)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", msg.MimeType)
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Header().Set("ETag", `"`+msg.MessageID+`"`)
+	w.Write(data)
+}
+
+// fetchMedia downloads mediaID, one of msg's attachments, decrypting it with
+// the hex-encoded key. On failure it answers the request, redirecting to the
+// cloud copy of msg's attachment if there is one, and returns false.
+func fetchMedia(w http.ResponseWriter, r *http.Request, msg *db.Message, gm mediaDownloader, downloads *downloadLimiter, mediaID, hexKey string) ([]byte, bool) {
+	fail := func(code, errMsg string, status int) {
+		if msg.MediaCloudURL != "" {
+			http.Redirect(w, r, msg.MediaCloudURL, http.StatusFound)
+			return
+		}
+		httpErrorCode(w, code, errMsg, status)
+	}
+	if gm == nil {
+		fail(ErrCodeNotConnected, "not connected to Google Messages", 503)
+		return nil, false
+	}
+	// Decode hex decryption key
+	key, err := hex.DecodeString(hexKey)
+	if err != nil {
+		fail(ErrCodeInternal, "invalid decryption key", 500)
+		return nil, false
+	}
+	data, err := downloads.do(r.Context(), func() ([]byte, error) {
+		return gm.DownloadMedia(mediaID, key)
+	})
+	if err != nil {
+		fail(ErrCodeUpstream, "download media: "+err.Error(), 502)
+		return nil, false
+	}
+	return data, true
+}
+
+// downloadLimiter bounds the number of concurrent media downloads. Callers
+// beyond the limit queue until a slot frees up or their request is cancelled.
+type downloadLimiter struct {
+	slots chan struct{}
+}
+
+func newDownloadLimiter(n int) *downloadLimiter {
+	if n <= 0 {
+		n = defaultMaxMediaDownloads
+	}
+	return &downloadLimiter{slots: make(chan struct{}, n)}
+}
+
+// do runs fetch once a slot is available.
+func (l *downloadLimiter) do(ctx context.Context, fetch func() ([]byte, error)) ([]byte, error) {
+	select {
+	case l.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-l.slots }()
+	return fetch()
+}
+
+// handlePinMessage serves POST /api/conversations/{id}/pin-message, which
+// pins or unpins (with "pinned": false) a message in the conversation.
+func handlePinMessage(w http.ResponseWriter, r *http.Request, store *db.Store, convID string) {
+	if r.Method != http.MethodPost {
+		httpError(w, "method not allowed", 405)
+		return
+	}
+	var req struct {
+		MessageID string `json:"message_id"`
+		Pinned    *bool  `json:"pinned,omitempty"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, "invalid JSON: "+err.Error(), 400)
+		return
+	}
+	if req.MessageID == "" {
+		httpError(w, "message_id is required", 400)
+		return
+	}
+	msg, err := store.GetMessageByID(req.MessageID)
+	if err != nil {
+		httpError(w, "get message: "+err.Error(), 500)
+		return
+	}
+	if msg == nil || msg.ConversationID != convID {
+		httpError(w, "message not found in conversation", 404)
+		return
+	}
+	pinned := req.Pinned == nil || *req.Pinned
+	if err := store.SetMessagePinned(req.MessageID, pinned); err != nil {
+		httpError(w, "pin message: "+err.Error(), 500)
+		return
+	}
+	writeJSON(w, map[string]any{"message_id": req.MessageID, "pinned": pinned})
+}
+
+// handleStatusHistory serves GET /api/messages/{id}/status: the message's
+// status transitions, oldest first.
+func handleStatusHistory(w http.ResponseWriter, r *http.Request, store *db.Store, messageID string) {
+	if r.Method != http.MethodGet {
+		httpError(w, "method not allowed", 405)
+		return
+	}
+	m, err := store.GetMessageByID(messageID)
+	if err != nil {
+		httpError(w, "get message: "+err.Error(), 500)
+		return
+	}
+	if m == nil {
+		httpError(w, "message not found", 404)
+		return
+	}
+	events, err := store.GetStatusHistory(messageID)
+	if err != nil {
+		httpError(w, "get status history: "+err.Error(), 500)
+		return
+	}
+	if events == nil {
+		events = []db.StatusEvent{}
+	}
+	writeJSON(w, map[string]any{
+		"message_id": messageID,
+		"status":     m.Status,
+		"events":     events,
+	})
+}
+
+// handleEditHistory serves GET /api/messages/{id}/edits: the current body and
+// the bodies it replaced, oldest first.
+func handleEditHistory(w http.ResponseWriter, r *http.Request, store *db.Store, messageID string) {
+	if r.Method != http.MethodGet {
+		httpError(w, "method not allowed", 405)
+		return
+	}
+	m, err := store.GetMessageByID(messageID)
+	if err != nil {
+		httpError(w, "get message: "+err.Error(), 500)
+		return
+	}
+	if m == nil {
+		httpError(w, "message not found", 404)
+		return
+	}
+	edits, err := store.GetMessageEdits(messageID)
+	if err != nil {
+		httpError(w, "get edits: "+err.Error(), 500)
+		return
+	}
+	if edits == nil {
+		edits = []db.MessageEdit{}
+	}
+	writeJSON(w, map[string]any{
+		"message_id":   messageID,
+		"body":         m.Body,
+		"edited_at_ms": m.EditedAtMS,
+		"edits":        edits,
+	})
+}
+
+// handleSaveDraft serves PUT /api/drafts, the web UI's autosave. It keeps one
+// draft per conversation, creating it on the first save and updating it after;
+// an empty body discards it.
+func handleSaveDraft(w http.ResponseWriter, r *http.Request, store *db.Store) {
+	var req struct {
+		ConversationID string `json:"conversation_id"`
+		Body           string `json:"body"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, "invalid JSON: "+err.Error(), 400)
+		return
+	}
+	if req.ConversationID == "" {
+		httpError(w, "conversation_id is required", 400)
+		return
+	}
+	if strings.TrimSpace(req.Body) == "" {
+		if err := store.DeleteConversationDrafts(req.ConversationID); err != nil {
+			httpError(w, "delete draft: "+err.Error(), 500)
+			return
+		}
+		writeJSON(w, map[string]string{"draft_id": ""})
+		return
+	}
+	now := time.Now()
+	d := &db.Draft{
+		DraftID:        fmt.Sprintf("draft_%d", now.UnixNano()),
+		ConversationID: req.ConversationID,
+		Body:           req.Body,
+		CreatedAt:      now.UnixMilli(),
+	}
+	if err := store.SaveConversationDraft(d); err != nil {
+		httpError(w, "save draft: "+err.Error(), 500)
+		return
+	}
+	writeJSON(w, map[string]string{"draft_id": d.DraftID})
+}
+
+// handleUpdateDraft serves PATCH /api/drafts/{id}, which changes a draft's
+// target conversation and/or body.
+func handleUpdateDraft(w http.ResponseWriter, r *http.Request, store *db.Store, draftID string) {
+	var req struct {
+		ConversationID *string `json:"conversation_id,omitempty"`
+		Body           *string `json:"body,omitempty"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, "invalid JSON: "+err.Error(), 400)
+		return
+	}
+	if req.ConversationID == nil && req.Body == nil {
+		httpError(w, "conversation_id or body is required", 400)
+		return
+	}
+	if req.ConversationID != nil && *req.ConversationID == "" {
+		httpError(w, "conversation_id must not be empty", 400)
+		return
 	}
-	return &gmproto.SendReactionRequest{
-		MessageID:    messageID,
-		ReactionData: gmproto.MakeReactionData(emoji),
-		Action:       a,
-		SIMPayload:   sim,
+	draft, err := store.GetDraft(draftID)
+	if err != nil {
+		httpError(w, "get draft: "+err.Error(), 500)
+		return
+	}
+	if draft == nil {
+		httpError(w, "draft not found", 404)
+		return
+	}
+
+	if req.ConversationID != nil {
+		err := store.MoveDraft(draftID, *req.ConversationID)
+		if errors.Is(err, db.ErrConversationNotFound) {
+			httpError(w, "target conversation not found", 400)
+			return
+		}
+		if errors.Is(err, db.ErrDraftExists) {
+			httpError(w, "target conversation already has a draft", 409)
+			return
+		}
+		if err != nil {
+			httpError(w, "move draft: "+err.Error(), 500)
+			return
+		}
+		draft.ConversationID = *req.ConversationID
+	}
+	if req.Body != nil {
+		if err := store.UpdateDraftBody(draftID, *req.Body); err != nil {
+			httpError(w, "update draft: "+err.Error(), 500)
+			return
+		}
+		draft.Body = *req.Body
+	}
+	writeJSON(w, draft)
+}
+
+// handleSetMessagesStatus serves POST /api/conversations/{id}/message-status,
+// an admin fix-up that fills in status on imported messages. Only empty or
+// "unknown" statuses are replaced unless force is set.
+func handleSetMessagesStatus(w http.ResponseWriter, r *http.Request, store *db.Store, convID string) {
+	if r.Method != http.MethodPost {
+		httpError(w, "method not allowed", 405)
+		return
+	}
+	var req struct {
+		Status string `json:"status"`
+		Force  bool   `json:"force,omitempty"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, "invalid JSON: "+err.Error(), 400)
+		return
+	}
+	if _, ok := gmproto.MessageStatusType_value[req.Status]; !ok {
+		httpError(w, "status must be a message status name such as INCOMING_COMPLETE or OUTGOING_DELIVERED", 400)
+		return
+	}
+	n, err := store.SetConversationMessagesStatus(convID, req.Status, req.Force)
+	if err != nil {
+		httpError(w, "set status: "+err.Error(), 500)
+		return
+	}
+	writeJSON(w, map[string]any{"updated": n})
+}
+
+// handleConversationStats serves message counts for a conversation, with a
+// breakdown by message kind and media MIME type.
+func handleConversationStats(w http.ResponseWriter, store *db.Store, convID string) {
+	if _, err := store.GetConversation(convID); errors.Is(err, sql.ErrNoRows) {
+		httpError(w, "conversation not found", 404)
+		return
+	} else if err != nil {
+		httpError(w, "get conversation: "+err.Error(), 500)
+		return
+	}
+	st, err := store.GetConversationStats(convID)
+	if err != nil {
+		httpError(w, "stats: "+err.Error(), 500)
+		return
+	}
+	types, err := store.GetMessageTypeCounts(convID)
+	if err != nil {
+		httpError(w, "message types: "+err.Error(), 500)
+		return
 	}
+	writeJSON(w, struct {
+		*db.ConversationStats
+		Types *db.MessageTypeCounts
+	}{st, types})
+}
+
+// handleBackfillConversation serves /api/conversations/{id}/backfill. POST,
+// with an optional {"max_messages": n, "force": true} body, starts a
+// backfill of the conversation in the background and answers 202. GET
+// reports whether that run is still going and how many messages it stored,
+// along with the saved progress: the oldest message timestamp fetched so
+// far and deep_backfilled_at, when its whole history was last fetched (0
+// until then).
+func handleBackfillConversation(w http.ResponseWriter, r *http.Request, store *db.Store, cli *client.Client, opts Options, convID string) {
+	if r.Method == http.MethodGet {
+		st, err := store.GetBackfillState(convID)
+		if err != nil {
+			httpError(w, err.Error(), 500)
+			return
+		}
+		resp := map[string]any{"running": false, "stored": 0, "complete": false, "oldest_ts": int64(0), "deep_backfilled_at": int64(0)}
+		if opts.BackfillProgress != nil {
+			resp["stored"], resp["running"] = opts.BackfillProgress(convID)
+		}
+		if st != nil {
+			resp["complete"], resp["oldest_ts"] = st.Complete, st.OldestTS
+			if st.Complete {
+				resp["deep_backfilled_at"] = st.UpdatedAt
+			}
+		}
+		writeJSON(w, resp)
+		return
+	}
+	if r.Method != http.MethodPost {
+		httpError(w, "method not allowed", 405)
+		return
+	}
+	if opts.BackfillConversation == nil {
+		httpError(w, "conversation backfill not available", 501)
+		return
+	}
+	if cli == nil {
+		notConnected(w)
+		return
+	}
+	if opts.Sync != nil && opts.Sync.SyncState().Paused {
+		httpError(w, "sync is paused", 409)
+		return
+	}
+	var req struct {
+		MaxMessages int  `json:"max_messages"`
+		Force       bool `json:"force"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		httpError(w, "invalid JSON: "+err.Error(), 400)
+		return
+	}
+	if req.MaxMessages < 0 {
+		httpError(w, "max_messages must not be negative", 400)
+		return
+	}
+	err := opts.BackfillConversation(convID, req.MaxMessages, req.Force)
+	switch {
+	case errors.Is(err, client.ErrBackfillRunning), errors.Is(err, client.ErrSyncPaused):
+		httpError(w, err.Error(), 409)
+		return
+	case errors.Is(err, client.ErrNotConnected):
+		notConnected(w)
+		return
+	case err != nil:
+		httpError(w, "backfill: "+err.Error(), 502)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"status": "started"})
+}
+
+// handleStarMessage serves POST /api/star. The star is saved locally and
+// marked pending, then pushed to Supabase in the background; "queued" says
+// whether the push was queued. A failed or unqueued push is retried by the
+// sync retrier.
+func handleStarMessage(w http.ResponseWriter, r *http.Request, store *db.Store, sb client.SupabaseSync, logger zerolog.Logger) {
+	if r.Method != http.MethodPost {
+		httpError(w, "method not allowed", 405)
+		return
+	}
+	var req struct {
+		MessageID string `json:"message_id"`
+		Starred   *bool  `json:"starred,omitempty"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, "invalid JSON: "+err.Error(), 400)
+		return
+	}
+	if req.MessageID == "" {
+		httpError(w, "message_id is required", 400)
+		return
+	}
+	msg, err := store.GetMessageByID(req.MessageID)
+	if err != nil {
+		httpError(w, "get message: "+err.Error(), 500)
+		return
+	}
+	if msg == nil {
+		httpError(w, "message not found", 404)
+		return
+	}
+	msg.Starred = req.Starred == nil || *req.Starred
+	if err := store.SetMessageStarred(msg.MessageID, msg.Starred); err != nil {
+		httpError(w, "star message: "+err.Error(), 500)
+		return
+	}
+	queued := false
+	if sb != nil {
+		store.MarkSyncPending(db.SyncKindStar, msg.MessageID)
+		queued = sb.Enqueue(func() {
+			if err := client.SyncStarred(store, sb, msg); err != nil {
+				logger.Warn().Err(err).Str("msg_id", msg.MessageID).Msg("Failed to sync star to Supabase")
+			}
+		})
+	}
+	writeJSON(w, map[string]any{"message_id": msg.MessageID, "starred": msg.Starred, "queued": queued})
+}
+
+// searchResult is one entry in /api/search-all. Type says which field is set.
+type searchResult struct {
+	Type         string           `json:"type"` // "contact", "conversation", or "message"
+	Contact      *db.Contact      `json:"contact,omitempty"`
+	Conversation *db.Conversation `json:"conversation,omitempty"`
+	Message      *db.Message      `json:"message,omitempty"`
+}
+
+// uploadTooLarge answers a /api/send-media upload over the size limit.
+func uploadTooLarge(w http.ResponseWriter, limit int64) {
+	shown := fmt.Sprintf("%d bytes", limit)
+	if limit%(1<<20) == 0 {
+		shown = fmt.Sprintf("%d MB", limit>>20)
+	}
+	httpError(w, "upload too large: the limit is "+shown, 413)
+}
+
+// asyncMediaThreshold is the upload size above which /api/send-media returns
+// immediately and finishes the upload in the background.
+const asyncMediaThreshold = 1 << 20
+
+// readUploadedFiles reads the files of a multipart form field in order. A
+// part without a Content-Type is sent as application/octet-stream.
+func readUploadedFiles(headers []*multipart.FileHeader) ([]client.MediaFile, error) {
+	files := make([]client.MediaFile, 0, len(headers))
+	for _, h := range headers {
+		f, err := h.Open()
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		mime := h.Header.Get("Content-Type")
+		if mime == "" {
+			mime = "application/octet-stream"
+		}
+		files = append(files, client.MediaFile{Data: data, Filename: h.Filename, MimeType: mime})
+	}
+	return files, nil
+}
+
+// quoteSnippetLen is the maximum number of characters of the original message
+// included by QuoteReplyBody.
+const quoteSnippetLen = 80
+
+// QuoteReplyBody prepends a "> " quoted snippet of the original message to a
+// reply. Newlines in the original are collapsed and long text is truncated.
+// Returns the reply unchanged if the original has no text.
+func QuoteReplyBody(original, reply string) string {
+	snippet := strings.Join(strings.Fields(original), " ")
+	if snippet == "" {
+		return reply
+	}
+	if runes := []rune(snippet); len(runes) > quoteSnippetLen {
+		snippet = string(runes[:quoteSnippetLen]) + "…"
+	}
+	return "> " + snippet + "\n" + reply
+}
+
+// maxBulkConversations caps /api/conversations/bulk.
+const maxBulkConversations = 500
+
+// maxReactionBatch caps /api/react-batch so one request can't flood the phone.
+const maxReactionBatch = 50
+
+// reactionBatchDelay spaces out reactions within a batch to stay under
+// Google Messages rate limits.
+var reactionBatchDelay = 200 * time.Millisecond
+
+// ReactionItem is one entry in a /api/react-batch request.
+type ReactionItem struct {
+	MessageID string `json:"message_id"`
+	Emoji     string `json:"emoji"`
+	Action    string `json:"action,omitempty"` // "add", "remove", "switch"; default "add"
+}
+
+// ReactionResult reports the outcome of one ReactionItem.
+type ReactionResult struct {
+	MessageID string `json:"message_id"`
+	Success   bool   `json:"success"`
+	Error     string `json:"error,omitempty"`
+}
+
+// applyReactionBatch sends each reaction in order, looking up the SIM once
+// per conversation and pausing delay between sends.
+func applyReactionBatch(store *db.Store, gm client.ReactionClient, items []ReactionItem, delay time.Duration) []ReactionResult {
+	sims := map[string]*gmproto.SIMPayload{}
+	results := make([]ReactionResult, len(items))
+	sent := 0
+	for i, item := range items {
+		res := &results[i]
+		res.MessageID = item.MessageID
+		if item.MessageID == "" || item.Emoji == "" {
+			res.Error = "message_id and emoji are required"
+			continue
+		}
+		switch strings.ToLower(item.Action) {
+		case "", "add", "remove", "switch":
+		default:
+			res.Error = "action must be add, remove, or switch"
+			continue
+		}
+		emoji, err := client.NormalizeReactionEmoji(item.Emoji)
+		if err != nil {
+			res.Error = err.Error()
+			continue
+		}
+		msg, err := store.GetMessageByID(item.MessageID)
+		if err != nil {
+			res.Error = "get message: " + err.Error()
+			continue
+		}
+		if msg == nil {
+			res.Error = "message not found"
+			continue
+		}
+
+		if strings.HasPrefix(msg.MessageID, "tmp_") {
+			res.Error = client.ErrReactionPending.Error()
+			continue
+		}
+
+		sim, ok := sims[msg.ConversationID]
+		if !ok {
+			sim = client.ReactionSIM(gm, msg.ConversationID)
+			sims[msg.ConversationID] = sim
+		}
+
+		if sent > 0 && delay > 0 {
+			time.Sleep(delay)
+		}
+		sent++
+		resp, err := gm.SendReaction(client.BuildReactionPayload(item.MessageID, emoji, item.Action, sim))
+		if err != nil {
+			res.Error = "send reaction: " + err.Error()
+			continue
+		}
+		res.Success = resp.GetSuccess()
+		if !res.Success {
+			res.Error = "rejected by phone"
+		}
+	}
+	return results
 }
 
 func writeJSON(w http.ResponseWriter, v any) {
@@ -748,10 +2200,96 @@ func writeJSON(w http.ResponseWriter, v any) {
 	json.NewEncoder(w).Encode(v)
 }
 
-func httpError(w http.ResponseWriter, msg string, code int) {
+// Error codes reported in the "code" field of error responses. Clients should
+// match on these rather than on messages, which may change.
+const (
+	ErrCodeInvalidRequest   = "invalid_request"
+	ErrCodeUnauthorized     = "unauthorized"
+	ErrCodeForbidden        = "forbidden"
+	ErrCodeNotFound         = "not_found"
+	ErrCodeMethodNotAllowed = "method_not_allowed"
+	ErrCodeConflict         = "conflict"
+	ErrCodeTooLarge         = "too_large"
+	ErrCodeInternal         = "internal_error"
+	ErrCodeNotImplemented   = "not_implemented"
+	ErrCodeUpstream         = "upstream_error"
+	ErrCodeNotConnected     = "not_connected"
+	ErrCodeUnavailable      = "unavailable"
+)
+
+// apiError is the body of every error response: {"error": {"code", "message"}}.
+type apiError struct {
+	Error struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// httpError writes an error response with the code that goes with status.
+func httpError(w http.ResponseWriter, msg string, status int) {
+	httpErrorCode(w, errorCodeForStatus(status), msg, status)
+}
+
+// httpErrorCode writes an error response with an explicit code, for errors
+// that need telling apart from others with the same status.
+func httpErrorCode(w http.ResponseWriter, code, msg string, status int) {
+	var body apiError
+	body.Error.Code = code
+	body.Error.Message = msg
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(code)
-	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// notConnected answers a request that needs the phone while it's not
+// connected.
+func notConnected(w http.ResponseWriter) {
+	httpErrorCode(w, ErrCodeNotConnected, "not connected to Google Messages", http.StatusServiceUnavailable)
+}
+
+func errorCodeForStatus(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return ErrCodeInvalidRequest
+	case http.StatusUnauthorized:
+		return ErrCodeUnauthorized
+	case http.StatusForbidden:
+		return ErrCodeForbidden
+	case http.StatusNotFound:
+		return ErrCodeNotFound
+	case http.StatusMethodNotAllowed:
+		return ErrCodeMethodNotAllowed
+	case http.StatusConflict:
+		return ErrCodeConflict
+	case http.StatusRequestEntityTooLarge:
+		return ErrCodeTooLarge
+	case http.StatusNotImplemented:
+		return ErrCodeNotImplemented
+	case http.StatusBadGateway:
+		return ErrCodeUpstream
+	case http.StatusServiceUnavailable:
+		return ErrCodeUnavailable
+	default:
+		return ErrCodeInternal
+	}
+}
+
+// queryTime reads an ISO-8601 date (2006-01-02) or RFC 3339 timestamp from
+// the query, as the MCP tools accept, and returns it in milliseconds. A
+// missing parameter gives 0.
+func queryTime(r *http.Request, key string) (int64, error) {
+	s := r.URL.Query().Get(key)
+	if s == "" {
+		return 0, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t.UnixMilli(), nil
+	}
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return 0, fmt.Errorf("want an ISO-8601 date or RFC 3339 timestamp, got %q", s)
+	}
+	return t.UnixMilli(), nil
 }
 
 func queryInt(r *http.Request, key string, defaultVal int) int {
@@ -1,15 +1,24 @@
 package web
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
+	"errors"
+	"io"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/rs/zerolog"
 	"go.mau.fi/mautrix-gmessages/pkg/libgm/gmproto"
 
+	"github.com/maxghenis/openmessage/internal/client"
 	"github.com/maxghenis/openmessage/internal/db"
 )
 
@@ -89,6 +98,40 @@ func TestListConversations(t *testing.T) {
 	}
 }
 
+func TestListConversationsActiveSince(t *testing.T) {
+	ts := newTestServer(t)
+
+	ts.store.UpsertConversation(&db.Conversation{
+		ConversationID: "c1", Name: "Alice", LastMessageTS: 2000,
+	})
+	ts.store.UpsertConversation(&db.Conversation{
+		ConversationID: "c2", Name: "Bob", LastMessageTS: 500,
+	})
+
+	resp, err := http.Get(ts.server.URL + "/api/conversations?active_since=1970-01-01T00:00:01Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var convos []db.Conversation
+	if err := json.NewDecoder(resp.Body).Decode(&convos); err != nil {
+		t.Fatal(err)
+	}
+	if len(convos) != 1 || convos[0].Name != "Alice" {
+		t.Fatalf("got %+v, want only Alice", convos)
+	}
+
+	resp, err = http.Get(ts.server.URL + "/api/conversations?active_since=1000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 400 {
+		t.Errorf("numeric active_since: status %d, want 400", resp.StatusCode)
+	}
+}
+
 func TestGetMessages(t *testing.T) {
 	ts := newTestServer(t)
 
@@ -153,6 +196,56 @@ func TestGetMessagesWithLimit(t *testing.T) {
 	}
 }
 
+func TestGetMessagesPagination(t *testing.T) {
+	ts := newTestServer(t)
+	for i := 1; i <= 5; i++ {
+		ts.store.UpsertMessage(&db.Message{
+			MessageID:      "m" + string(rune('0'+i)),
+			ConversationID: "c1",
+			Body:           "msg",
+			TimestampMS:    int64(i * 100),
+		})
+	}
+
+	page := func(query string) ([]db.Message, string) {
+		resp, err := http.Get(ts.server.URL + "/api/conversations/c1/messages?" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var msgs []db.Message
+		json.NewDecoder(resp.Body).Decode(&msgs)
+		return msgs, resp.Header.Get("X-Oldest-TS")
+	}
+	ids := func(msgs []db.Message) string {
+		var out []string
+		for _, m := range msgs {
+			out = append(out, m.MessageID)
+		}
+		return strings.Join(out, ",")
+	}
+
+	msgs, cursor := page("limit=2")
+	if ids(msgs) != "m5,m4" || cursor != "400" {
+		t.Fatalf("first page = %s (cursor %s), want m5,m4 (cursor 400)", ids(msgs), cursor)
+	}
+	msgs, cursor = page("limit=2&before_ts=" + cursor)
+	if ids(msgs) != "m3,m2" || cursor != "200" {
+		t.Fatalf("second page = %s (cursor %s), want m3,m2 (cursor 200)", ids(msgs), cursor)
+	}
+	msgs, _ = page("limit=2&before_ts=" + cursor)
+	if ids(msgs) != "m1" {
+		t.Errorf("last page = %s, want m1", ids(msgs))
+	}
+	msgs, cursor = page("limit=2&before_ts=100")
+	if len(msgs) != 0 || cursor != "" {
+		t.Errorf("past the end = %s (cursor %q), want empty", ids(msgs), cursor)
+	}
+	if msgs, _ := page("limit=2&before_ts=0"); ids(msgs) != "m5,m4" {
+		t.Errorf("before_ts=0 = %s, want newest page", ids(msgs))
+	}
+}
+
 func TestSearchMessages(t *testing.T) {
 	ts := newTestServer(t)
 
@@ -187,6 +280,97 @@ func TestSearchMessages(t *testing.T) {
 	}
 }
 
+func TestSearchOrderAndDefaultLimit(t *testing.T) {
+	store, err := db.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	for i := 1; i <= 3; i++ {
+		store.UpsertMessage(&db.Message{MessageID: fmt.Sprintf("m%d", i), ConversationID: "c1", Body: "lunch", TimestampMS: int64(i * 100)})
+	}
+	srv := httptest.NewServer(APIHandlerWithOptions(store, nil, zerolog.Nop(), nil, Options{SearchLimit: 2}))
+	defer srv.Close()
+
+	search := func(query string) (int, []db.Message) {
+		resp, err := http.Get(srv.URL + "/api/search?" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var msgs []db.Message
+		json.NewDecoder(resp.Body).Decode(&msgs)
+		return resp.StatusCode, msgs
+	}
+
+	if code, msgs := search("q=lunch&order=oldest"); code != 200 || len(msgs) != 2 || msgs[0].MessageID != "m1" || msgs[1].MessageID != "m2" {
+		t.Errorf("oldest: got %d %v, want the two earliest", code, msgs)
+	}
+	if _, msgs := search("q=lunch&order=newest&limit=5"); len(msgs) != 3 || msgs[0].MessageID != "m3" {
+		t.Errorf("newest: got %v, want 3 with m3 first", msgs)
+	}
+	if code, _ := search("q=lunch&order=sideways"); code != 400 {
+		t.Errorf("unknown order: got %d, want 400", code)
+	}
+	if code, _ := search("q=lunch&regex=1&order=oldest"); code != 400 {
+		t.Errorf("regex with oldest: got %d, want 400", code)
+	}
+}
+
+func TestSearchAll(t *testing.T) {
+	ts := newTestServer(t)
+
+	ts.store.UpsertContact(&db.Contact{ContactID: "ct1", Name: "Dana Scully", Number: "+15550001111"})
+	ts.store.UpsertConversation(&db.Conversation{
+		ConversationID: "c1", Name: "Dana Scully", LastMessageTS: 100,
+	})
+	ts.store.UpsertMessage(&db.Message{
+		MessageID: "m1", ConversationID: "c2", Body: "ask Dana about the files", TimestampMS: 100,
+	})
+
+	resp, err := http.Get(ts.server.URL + "/api/search-all?q=Dana")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		t.Fatalf("got status %d, want 200", resp.StatusCode)
+	}
+	var results []struct {
+		Type         string           `json:"type"`
+		Contact      *db.Contact      `json:"contact"`
+		Conversation *db.Conversation `json:"conversation"`
+		Message      *db.Message      `json:"message"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+		t.Fatal(err)
+	}
+	seen := map[string]bool{}
+	for _, r := range results {
+		seen[r.Type] = true
+		switch r.Type {
+		case "contact":
+			if r.Contact == nil || r.Contact.Name != "Dana Scully" {
+				t.Errorf("bad contact result: %+v", r.Contact)
+			}
+		case "conversation":
+			if r.Conversation == nil || r.Conversation.ConversationID != "c1" {
+				t.Errorf("bad conversation result: %+v", r.Conversation)
+			}
+		case "message":
+			if r.Message == nil || r.Message.MessageID != "m1" {
+				t.Errorf("bad message result: %+v", r.Message)
+			}
+		}
+	}
+	for _, typ := range []string{"contact", "conversation", "message"} {
+		if !seen[typ] {
+			t.Errorf("missing %s result in %+v", typ, results)
+		}
+	}
+}
+
 func TestSearchRequiresQuery(t *testing.T) {
 	ts := newTestServer(t)
 
@@ -216,6 +400,71 @@ func TestSendMessage(t *testing.T) {
 	if resp.StatusCode != 503 {
 		t.Fatalf("got status %d, want 503 (no client)", resp.StatusCode)
 	}
+	var apiErr apiError
+	if err := json.NewDecoder(resp.Body).Decode(&apiErr); err != nil {
+		t.Fatalf("decode error body: %v", err)
+	}
+	if apiErr.Error.Code != ErrCodeNotConnected || apiErr.Error.Message == "" {
+		t.Errorf("error = %+v, want code %q with a message", apiErr.Error, ErrCodeNotConnected)
+	}
+}
+
+func TestErrorCodes(t *testing.T) {
+	ts := newTestServer(t)
+
+	tests := []struct {
+		method, path, body string
+		status             int
+		code               string
+	}{
+		{"POST", "/api/send", `{"message": "Hello!"}`, 400, ErrCodeInvalidRequest},
+		{"GET", "/api/send", "", 405, ErrCodeMethodNotAllowed},
+		{"GET", "/api/messages/m1/bogus", "", 404, ErrCodeNotFound},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest(tt.method, ts.server.URL+tt.path, strings.NewReader(tt.body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body apiError
+		json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+		if resp.StatusCode != tt.status || body.Error.Code != tt.code {
+			t.Errorf("%s %s = %d %q, want %d %q", tt.method, tt.path, resp.StatusCode, body.Error.Code, tt.status, tt.code)
+		}
+	}
+}
+
+func TestSendBulkNotConnected(t *testing.T) {
+	ts := newTestServer(t)
+
+	body := `{"conversation_ids": ["c1", "c2", "c1"], "message": "Party at 8"}`
+	resp, err := http.Post(ts.server.URL+"/api/send-bulk", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("got status %d, want 200 with per-conversation failures", resp.StatusCode)
+	}
+	var out struct {
+		Sent    int                     `json:"sent"`
+		Failed  int                     `json:"failed"`
+		Results []client.BulkSendResult `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Sent != 0 || out.Failed != 2 || len(out.Results) != 2 {
+		t.Fatalf("got %+v, want two failed conversations", out)
+	}
+	for i, want := range []string{"c1", "c2"} {
+		r := out.Results[i]
+		if r.ConversationID != want || r.Success || r.Code != ErrCodeNotConnected || r.Error == "" {
+			t.Errorf("results[%d] = %+v, want %s failed as not_connected", i, r, want)
+		}
+	}
 }
 
 func TestSendMessageStoresInDB(t *testing.T) {
@@ -442,37 +691,6 @@ func TestMessagesIncludeReactionsAndReplyTo(t *testing.T) {
 	}
 }
 
-func TestBuildReactionPayload(t *testing.T) {
-	sim := &gmproto.SIMPayload{SIMNumber: 1}
-
-	// ADD reaction
-	payload := BuildReactionPayload("msg-123", "😂", "add", sim)
-	if payload.MessageID != "msg-123" {
-		t.Errorf("MessageID = %q, want msg-123", payload.MessageID)
-	}
-	if payload.ReactionData == nil || payload.ReactionData.Unicode != "😂" {
-		t.Errorf("ReactionData.Unicode = %v, want 😂", payload.ReactionData)
-	}
-	if payload.Action != gmproto.SendReactionRequest_ADD {
-		t.Errorf("Action = %v, want ADD", payload.Action)
-	}
-	if payload.SIMPayload == nil || payload.SIMPayload.SIMNumber != 1 {
-		t.Error("SIMPayload not set correctly")
-	}
-
-	// REMOVE reaction
-	payload2 := BuildReactionPayload("msg-456", "👍", "remove", sim)
-	if payload2.Action != gmproto.SendReactionRequest_REMOVE {
-		t.Errorf("Action = %v, want REMOVE", payload2.Action)
-	}
-
-	// Default to ADD
-	payload3 := BuildReactionPayload("msg-789", "❤️", "", sim)
-	if payload3.Action != gmproto.SendReactionRequest_ADD {
-		t.Errorf("Action = %v, want ADD for empty action string", payload3.Action)
-	}
-}
-
 func TestSendReactionValidation(t *testing.T) {
 	ts := newTestServer(t)
 
@@ -504,134 +722,30 @@ func TestSendReactionNoClient(t *testing.T) {
 	}
 }
 
-func TestBuildSendPayload(t *testing.T) {
-	sim := &gmproto.SIMPayload{SIMNumber: 1}
-	payload := BuildSendPayload("conv-1", "Hello world", "", "+15551234567", sim)
-
-	// Must use MessageInfo array (not MessagePayloadContent)
-	if payload.MessagePayload.MessagePayloadContent != nil {
-		t.Error("MessagePayloadContent must be nil; use MessageInfo instead")
-	}
-	if len(payload.MessagePayload.MessageInfo) != 1 {
-		t.Fatalf("expected 1 MessageInfo entry, got %d", len(payload.MessagePayload.MessageInfo))
-	}
-	mc := payload.MessagePayload.MessageInfo[0].GetMessageContent()
-	if mc == nil || mc.Content != "Hello world" {
-		t.Errorf("MessageContent mismatch: %+v", mc)
-	}
-
-	// TmpID format: tmp_ followed by 12 digits
-	if !strings.HasPrefix(payload.TmpID, "tmp_") || len(payload.TmpID) != 16 {
-		t.Errorf("TmpID format wrong: %q (want tmp_ + 12 digits)", payload.TmpID)
-	}
-	// TmpID must be in all 3 places
-	if payload.MessagePayload.TmpID != payload.TmpID {
-		t.Error("MessagePayload.TmpID must match root TmpID")
-	}
-	if payload.MessagePayload.TmpID2 != payload.TmpID {
-		t.Error("MessagePayload.TmpID2 must match root TmpID")
-	}
-
-	// SIM payload must be set
-	if payload.SIMPayload == nil {
-		t.Error("SIMPayload must not be nil")
-	}
-	if payload.SIMPayload.SIMNumber != 1 {
-		t.Errorf("SIMNumber = %d, want 1", payload.SIMPayload.SIMNumber)
-	}
-
-	// ParticipantID
-	if payload.MessagePayload.ParticipantID != "+15551234567" {
-		t.Errorf("ParticipantID = %q, want +15551234567", payload.MessagePayload.ParticipantID)
-	}
-
-	// ConversationID in both places
-	if payload.ConversationID != "conv-1" {
-		t.Errorf("root ConversationID = %q", payload.ConversationID)
-	}
-	if payload.MessagePayload.ConversationID != "conv-1" {
-		t.Errorf("payload ConversationID = %q", payload.MessagePayload.ConversationID)
-	}
-}
-
-func TestBuildSendPayloadWithReply(t *testing.T) {
-	payload := BuildSendPayload("conv-1", "Reply text", "orig-msg-id", "+15551234567", nil)
-	if payload.Reply == nil {
-		t.Fatal("Reply must be set when replyToID is provided")
-	}
-	if payload.Reply.MessageID != "orig-msg-id" {
-		t.Errorf("Reply.MessageID = %q, want orig-msg-id", payload.Reply.MessageID)
-	}
-}
-
-func TestBuildSendPayloadNoReply(t *testing.T) {
-	payload := BuildSendPayload("conv-1", "No reply", "", "+15551234567", nil)
-	if payload.Reply != nil {
-		t.Error("Reply must be nil when replyToID is empty")
-	}
-}
-
-func TestBuildSendMediaPayload(t *testing.T) {
-	sim := &gmproto.SIMPayload{SIMNumber: 1}
-	media := &gmproto.MediaContent{
-		Format:    4, // image
-		MediaID:   "media-abc-123",
-		MediaName: "photo.jpg",
-		Size:      54321,
-		MimeType:  "image/jpeg",
-	}
-	payload := BuildSendMediaPayload("conv-1", media, "+15551234567", sim)
-
-	// Must use MessageInfo with MediaContent (not MessageContent)
-	if payload.MessagePayload.MessagePayloadContent != nil {
-		t.Error("MessagePayloadContent must be nil; use MessageInfo instead")
-	}
-	if len(payload.MessagePayload.MessageInfo) != 1 {
-		t.Fatalf("expected 1 MessageInfo entry, got %d", len(payload.MessagePayload.MessageInfo))
+func TestQuoteReplyBody(t *testing.T) {
+	got := QuoteReplyBody("Are we still on\nfor lunch?", "Yes!")
+	want := "> Are we still on for lunch?\nYes!"
+	if got != want {
+		t.Errorf("QuoteReplyBody = %q, want %q", got, want)
 	}
 
-	// Should have MediaContent, not MessageContent
+	// The quoted body is what ends up in the outgoing payload
+	payload := client.BuildSendPayload("conv-1", got, "orig-msg-id", "+15551234567", nil)
 	mc := payload.MessagePayload.MessageInfo[0].GetMessageContent()
-	if mc != nil {
-		t.Error("MessageContent should be nil for media messages")
-	}
-	mediaCont := payload.MessagePayload.MessageInfo[0].GetMediaContent()
-	if mediaCont == nil {
-		t.Fatal("MediaContent must be set")
-	}
-	if mediaCont.MediaID != "media-abc-123" {
-		t.Errorf("MediaID = %q, want media-abc-123", mediaCont.MediaID)
-	}
-	if mediaCont.MimeType != "image/jpeg" {
-		t.Errorf("MimeType = %q, want image/jpeg", mediaCont.MimeType)
+	if mc == nil || !strings.HasPrefix(mc.Content, "> Are we still on") {
+		t.Errorf("payload content missing quote: %+v", mc)
 	}
 
-	// TmpID format: tmp_ followed by 12 digits
-	if !strings.HasPrefix(payload.TmpID, "tmp_") || len(payload.TmpID) != 16 {
-		t.Errorf("TmpID format wrong: %q (want tmp_ + 12 digits)", payload.TmpID)
-	}
-	// TmpID must be in all 3 places
-	if payload.MessagePayload.TmpID != payload.TmpID {
-		t.Error("MessagePayload.TmpID must match root TmpID")
-	}
-	if payload.MessagePayload.TmpID2 != payload.TmpID {
-		t.Error("MessagePayload.TmpID2 must match root TmpID")
-	}
-
-	// SIM payload must be set
-	if payload.SIMPayload == nil || payload.SIMPayload.SIMNumber != 1 {
-		t.Error("SIMPayload not set correctly")
+	// Long originals are truncated
+	long := strings.Repeat("a", 200)
+	got = QuoteReplyBody(long, "ok")
+	if !strings.HasPrefix(got, "> "+strings.Repeat("a", quoteSnippetLen)+"…\n") {
+		t.Errorf("long quote not truncated: %q", got)
 	}
 
-	// ParticipantID and ConversationID
-	if payload.MessagePayload.ParticipantID != "+15551234567" {
-		t.Errorf("ParticipantID = %q, want +15551234567", payload.MessagePayload.ParticipantID)
-	}
-	if payload.ConversationID != "conv-1" {
-		t.Errorf("root ConversationID = %q", payload.ConversationID)
-	}
-	if payload.MessagePayload.ConversationID != "conv-1" {
-		t.Errorf("payload ConversationID = %q", payload.MessagePayload.ConversationID)
+	// Nothing to quote (e.g. media-only original)
+	if got := QuoteReplyBody("", "ok"); got != "ok" {
+		t.Errorf("empty original: got %q, want %q", got, "ok")
 	}
 }
 
@@ -652,6 +766,36 @@ func TestSendMediaEndpointNoClient(t *testing.T) {
 	}
 }
 
+func TestSendMediaUploadTooLarge(t *testing.T) {
+	store, err := db.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	srv := httptest.NewServer(APIHandlerWithOptions(store, nil, zerolog.Nop(), nil, Options{MaxUploadBytes: 1024}))
+	defer srv.Close()
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("conversation_id", "c1")
+	fw, _ := mw.CreateFormFile("file", "big.bin")
+	fw.Write(make([]byte, 1024+uploadFormOverhead+1))
+	mw.Close()
+
+	resp, err := http.Post(srv.URL+"/api/send-media", mw.FormDataContentType(), &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status %d (%s), want 413", resp.StatusCode, msg)
+	}
+	if !strings.Contains(string(msg), "1024 bytes") {
+		t.Errorf("error %q doesn't state the limit", msg)
+	}
+}
+
 func TestMediaEndpointWithMimeTypeButNoMediaID(t *testing.T) {
 	ts := newTestServer(t)
 
@@ -694,3 +838,1382 @@ func TestStaticFileServing(t *testing.T) {
 		t.Fatalf("got content-type %q, want text/html", ct)
 	}
 }
+
+func TestSendContactValidation(t *testing.T) {
+	ts := newTestServer(t)
+
+	for _, body := range []string{
+		`{"name":"Sarah","number":"+15551234567"}`,
+		`{"conversation_id":"c1","name":"Sarah"}`,
+		`{"conversation_id":"c1","number":"+15551234567"}`,
+	} {
+		resp, err := http.Post(ts.server.URL+"/api/send-contact", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != 400 {
+			t.Errorf("%s: status = %d, want 400", body, resp.StatusCode)
+		}
+	}
+
+	resp, err := http.Post(ts.server.URL+"/api/send-contact", "application/json",
+		strings.NewReader(`{"conversation_id":"c1","contact_id":"missing"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 404 {
+		t.Errorf("unknown contact: status = %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestRenameAutoContact(t *testing.T) {
+	ts := newTestServer(t)
+	ts.store.EnsureAutoContact("+15559999999", "")
+	ts.store.UpsertContact(&db.Contact{ContactID: "c1", Name: "Alice", Number: "+15551111111"})
+
+	post := func(body string) int {
+		resp, err := http.Post(ts.server.URL+"/api/contacts/rename", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if code := post(`{"contact_id":"auto:+15559999999","name":"Plumber"}`); code != 200 {
+		t.Fatalf("rename: status = %d", code)
+	}
+	c, _ := ts.store.GetContact("auto:+15559999999")
+	if c == nil || c.Name != "Plumber" {
+		t.Errorf("contact after rename = %+v", c)
+	}
+	if code := post(`{"contact_id":"c1","name":"Bob"}`); code != 404 {
+		t.Errorf("phone contact: status = %d, want 404", code)
+	}
+	if code := post(`{"contact_id":"auto:+15559999999","name":" "}`); code != 400 {
+		t.Errorf("blank name: status = %d, want 400", code)
+	}
+}
+
+type fakeReactionClient struct {
+	convLookups int
+	sim         *gmproto.SIMPayload // returned on every conversation, if set
+	sent        []*gmproto.SendReactionRequest
+}
+
+func (f *fakeReactionClient) GetConversation(conversationID string) (*gmproto.Conversation, error) {
+	f.convLookups++
+	conv := &gmproto.Conversation{ConversationID: conversationID}
+	if f.sim != nil {
+		conv.SimCard = &gmproto.SIMCard{SIMData: &gmproto.SIMData{SIMPayload: f.sim}}
+	}
+	return conv, nil
+}
+
+func (f *fakeReactionClient) SendReaction(payload *gmproto.SendReactionRequest) (*gmproto.SendReactionResponse, error) {
+	if payload.MessageID == "m-fail" {
+		return nil, errors.New("phone offline")
+	}
+	f.sent = append(f.sent, payload)
+	return &gmproto.SendReactionResponse{Success: true}, nil
+}
+
+func TestApplyReactionBatch(t *testing.T) {
+	store, err := db.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	for _, id := range []string{"m1", "m2", "m-fail"} {
+		store.UpsertMessage(&db.Message{MessageID: id, ConversationID: "c1", Body: "hi", TimestampMS: 1000})
+	}
+
+	fake := &fakeReactionClient{}
+	results := applyReactionBatch(store, fake, []ReactionItem{
+		{MessageID: "m1", Emoji: "👍"},
+		{MessageID: "m2", Emoji: "👍", Action: "remove"},
+		{MessageID: "missing", Emoji: "👍"},
+		{MessageID: "m1", Emoji: ""},
+		{MessageID: "m2", Emoji: "👍", Action: "explode"},
+		{MessageID: "m-fail", Emoji: "👍"},
+	}, 0)
+
+	want := []bool{true, true, false, false, false, false}
+	for i, r := range results {
+		if r.Success != want[i] {
+			t.Errorf("item %d (%s): success = %v, want %v (error %q)", i, r.MessageID, r.Success, want[i], r.Error)
+		}
+		if !r.Success && r.Error == "" {
+			t.Errorf("item %d: failure without error message", i)
+		}
+	}
+	if len(fake.sent) != 2 {
+		t.Errorf("sent %d reactions, want 2", len(fake.sent))
+	}
+	if fake.sent[1].Action != gmproto.SendReactionRequest_REMOVE {
+		t.Errorf("second action = %v, want REMOVE", fake.sent[1].Action)
+	}
+	if fake.convLookups != 1 {
+		t.Errorf("conversation looked up %d times, want 1", fake.convLookups)
+	}
+}
+
+// fakeStarSync records starred-flag pushes; other SupabaseSync calls are no-ops.
+type fakeStarSync struct {
+	starred map[string]bool
+}
+
+func (f *fakeStarSync) UpsertConversation(convID, name string, lastMessageTime time.Time, isGroup bool, lastPreview string) error {
+	return nil
+}
+
+func (f *fakeStarSync) UpsertMessage(id, conversationID, senderName, senderNumber, content string, timestamp time.Time, isFromMe bool, mediaType, mediaURL, reactions, replyToID string) error {
+	return nil
+}
+
+func (f *fakeStarSync) UpsertContact(number, name string) error { return nil }
+
+func (f *fakeStarSync) SetMessageStarred(id, conversationID string, starred bool) error {
+	f.starred[id] = starred
+	return nil
+}
+
+func (f *fakeStarSync) SetMessageDeleted(id, conversationID string) error { return nil }
+
+func (f *fakeStarSync) ListStarredMessageIDs() ([]string, error) { return nil, nil }
+
+func (f *fakeStarSync) MessageStars(ids []string) (map[string]bool, error) { return nil, nil }
+
+func (f *fakeStarSync) SetConversationRead(convID string, lastRead time.Time) error { return nil }
+
+func (f *fakeStarSync) ListConversationReads() (map[string]time.Time, error) { return nil, nil }
+
+func (f *fakeStarSync) Enqueue(job func()) bool {
+	job()
+	return true
+}
+
+func TestStarMessageSyncsToSupabase(t *testing.T) {
+	store, err := db.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	store.UpsertMessage(&db.Message{MessageID: "m1", ConversationID: "c1", Body: "hi", TimestampMS: 1000})
+
+	sb := &fakeStarSync{starred: map[string]bool{}}
+	srv := httptest.NewServer(APIHandlerWithOptions(store, nil, zerolog.Nop(), nil, Options{Supabase: sb}))
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"/api/star", "application/json", strings.NewReader(`{"message_id":"m1"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]any
+	json.NewDecoder(resp.Body).Decode(&got)
+	resp.Body.Close()
+	if got["starred"] != true || got["queued"] != true {
+		t.Errorf("response = %v", got)
+	}
+	if !sb.starred["m1"] {
+		t.Error("starred flag was not propagated to Supabase")
+	}
+	if m, _ := store.GetMessageByID("m1"); !m.Starred {
+		t.Error("message not starred locally")
+	}
+
+	resp, _ = http.Post(srv.URL+"/api/star", "application/json", strings.NewReader(`{"message_id":"m1","starred":false}`))
+	resp.Body.Close()
+	if sb.starred["m1"] {
+		t.Error("unstar was not propagated to Supabase")
+	}
+
+	resp, _ = http.Post(srv.URL+"/api/star", "application/json", strings.NewReader(`{"message_id":"missing"}`))
+	resp.Body.Close()
+	if resp.StatusCode != 404 {
+		t.Errorf("missing message: status = %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestStarMessageWithoutSupabase(t *testing.T) {
+	ts := newTestServer(t)
+	ts.store.UpsertMessage(&db.Message{MessageID: "m1", ConversationID: "c1", Body: "hi", TimestampMS: 1000})
+
+	resp, err := http.Post(ts.server.URL+"/api/star", "application/json", strings.NewReader(`{"message_id":"m1"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("status = %d", resp.StatusCode)
+	}
+
+	resp, _ = http.Get(ts.server.URL + "/api/starred")
+	var msgs []db.Message
+	json.NewDecoder(resp.Body).Decode(&msgs)
+	resp.Body.Close()
+	if len(msgs) != 1 || msgs[0].MessageID != "m1" {
+		t.Errorf("starred = %+v, want m1", msgs)
+	}
+}
+
+func TestPutDraft(t *testing.T) {
+	ts := newTestServer(t)
+
+	put := func(body string) (int, string) {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPut, ts.server.URL+"/api/drafts", strings.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var out struct {
+			DraftID string `json:"draft_id"`
+		}
+		json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out.DraftID
+	}
+
+	code, first := put(`{"conversation_id":"c1","body":"see you"}`)
+	if code != 200 || first == "" {
+		t.Fatalf("first save = %d %q", code, first)
+	}
+	code, second := put(`{"conversation_id":"c1","body":"see you soon"}`)
+	if code != 200 || second != first {
+		t.Errorf("second save = %d %q, want the same draft %q", code, second, first)
+	}
+	drafts, _ := ts.store.ListDrafts("c1")
+	if len(drafts) != 1 || drafts[0].Body != "see you soon" {
+		t.Errorf("drafts = %+v, want one updated draft", drafts)
+	}
+
+	if code, id := put(`{"conversation_id":"c1","body":""}`); code != 200 || id != "" {
+		t.Errorf("clearing = %d %q", code, id)
+	}
+	if drafts, _ := ts.store.ListDrafts("c1"); len(drafts) != 0 {
+		t.Errorf("drafts after clearing = %+v", drafts)
+	}
+	if code, _ := put(`{"body":"hi"}`); code != 400 {
+		t.Errorf("missing conversation_id = %d, want 400", code)
+	}
+}
+
+func TestPatchDraft(t *testing.T) {
+	ts := newTestServer(t)
+	ts.store.UpsertConversation(&db.Conversation{ConversationID: "c1"})
+	ts.store.UpsertConversation(&db.Conversation{ConversationID: "c2"})
+	ts.store.UpsertDraft(&db.Draft{DraftID: "d1", ConversationID: "c1", Body: "hi", CreatedAt: 1000})
+
+	patch := func(id, body string) *http.Response {
+		req, _ := http.NewRequest(http.MethodPatch, ts.server.URL+"/api/drafts/"+id, strings.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	resp := patch("d1", `{"conversation_id":"c2","body":"hi again"}`)
+	var got db.Draft
+	json.NewDecoder(resp.Body).Decode(&got)
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("status = %d", resp.StatusCode)
+	}
+	if got.ConversationID != "c2" || got.Body != "hi again" {
+		t.Errorf("response = %+v", got)
+	}
+	if d, _ := ts.store.GetDraft("d1"); d.ConversationID != "c2" || d.Body != "hi again" {
+		t.Errorf("stored draft = %+v", d)
+	}
+
+	for _, tc := range []struct {
+		id, body string
+		want     int
+	}{
+		{"d1", `{"body":"only body"}`, 200},
+		{"d1", `{"conversation_id":"nope"}`, 400},
+		{"d1", `{}`, 400},
+		{"missing", `{"body":"x"}`, 404},
+	} {
+		resp := patch(tc.id, tc.body)
+		resp.Body.Close()
+		if resp.StatusCode != tc.want {
+			t.Errorf("PATCH %s %s: status = %d, want %d", tc.id, tc.body, resp.StatusCode, tc.want)
+		}
+	}
+	if d, _ := ts.store.GetDraft("d1"); d.ConversationID != "c2" || d.Body != "only body" {
+		t.Errorf("draft after body-only patch = %+v", d)
+	}
+}
+
+func TestConversationGapsEndpoint(t *testing.T) {
+	ts := newTestServer(t)
+	base := time.Now().Add(-30 * 24 * time.Hour).UnixMilli()
+	ts.store.UpsertMessage(&db.Message{MessageID: "m1", ConversationID: "c1", Body: "a", TimestampMS: base})
+	ts.store.UpsertMessage(&db.Message{MessageID: "m2", ConversationID: "c1", Body: "b", TimestampMS: base + int64(48*time.Hour/time.Millisecond)})
+
+	get := func(path string) []db.TimelineGap {
+		resp, err := http.Get(ts.server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var gaps []db.TimelineGap
+		json.NewDecoder(resp.Body).Decode(&gaps)
+		return gaps
+	}
+	if gaps := get("/api/conversations/c1/gaps"); len(gaps) != 0 {
+		t.Errorf("default threshold: got %+v, want none", gaps)
+	}
+	if gaps := get("/api/conversations/c1/gaps?min_hours=24"); len(gaps) != 1 || gaps[0].BeforeMessageID != "m2" {
+		t.Errorf("24h threshold: got %+v, want one gap before m2", gaps)
+	}
+}
+
+func TestGetMessagesMarkRead(t *testing.T) {
+	ts := newTestServer(t)
+	ts.store.UpsertConversation(&db.Conversation{ConversationID: "c1", UnreadCount: 2})
+	ts.store.UpsertMessage(&db.Message{MessageID: "m1", ConversationID: "c1", Body: "hi", TimestampMS: 1000})
+
+	get := func(path string) {
+		resp, err := http.Get(ts.server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	get("/api/conversations/c1/messages")
+	if c, _ := ts.store.GetConversation("c1"); c.UnreadCount != 2 {
+		t.Errorf("plain fetch changed unread count to %d", c.UnreadCount)
+	}
+	get("/api/conversations/c1/messages?mark_read=1")
+	if c, _ := ts.store.GetConversation("c1"); c.UnreadCount != 0 {
+		t.Errorf("unread = %d after mark_read=1, want 0", c.UnreadCount)
+	}
+}
+
+func TestSetMessagesStatusEndpoint(t *testing.T) {
+	ts := newTestServer(t)
+	ts.store.UpsertMessage(&db.Message{MessageID: "m1", ConversationID: "c1", Body: "a", TimestampMS: 100, Status: "unknown"})
+	ts.store.UpsertMessage(&db.Message{MessageID: "m2", ConversationID: "c1", Body: "b", TimestampMS: 200, Status: "OUTGOING_DELIVERED"})
+
+	resp, err := http.Post(ts.server.URL+"/api/conversations/c1/message-status", "application/json",
+		strings.NewReader(`{"status":"INCOMING_COMPLETE"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]int
+	json.NewDecoder(resp.Body).Decode(&got)
+	resp.Body.Close()
+	if got["updated"] != 1 {
+		t.Errorf("updated = %d, want 1", got["updated"])
+	}
+	if m, _ := ts.store.GetMessageByID("m2"); m.Status != "OUTGOING_DELIVERED" {
+		t.Errorf("known status overwritten: %q", m.Status)
+	}
+
+	resp, _ = http.Post(ts.server.URL+"/api/conversations/c1/message-status", "application/json",
+		strings.NewReader(`{"status":"delivered-ish"}`))
+	resp.Body.Close()
+	if resp.StatusCode != 400 {
+		t.Errorf("invalid status: code = %d, want 400", resp.StatusCode)
+	}
+}
+
+func TestConversationParticipantsEndpoint(t *testing.T) {
+	ts := newTestServer(t)
+	ts.store.UpsertContact(&db.Contact{ContactID: "c1", Name: "Alice Smith", Number: "+15551234567"})
+	ts.store.UpsertConversation(&db.Conversation{ConversationID: "g1",
+		Participants: `[{"name":"Alice","number":"+15551234567"},{"name":"","number":"+15559999999"}]`})
+
+	resp, err := http.Get(ts.server.URL + "/api/conversations/g1/participants")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ps []db.Participant
+	json.NewDecoder(resp.Body).Decode(&ps)
+	resp.Body.Close()
+	if len(ps) != 2 || !ps[0].Known || ps[0].ContactName != "Alice Smith" || ps[1].Known {
+		t.Errorf("participants = %+v", ps)
+	}
+
+	resp, _ = http.Get(ts.server.URL + "/api/conversations/missing/participants")
+	resp.Body.Close()
+	if resp.StatusCode != 404 {
+		t.Errorf("missing conversation: status = %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestDeleteMessageOffline(t *testing.T) {
+	ts := newTestServer(t)
+	ts.store.UpsertMessage(&db.Message{MessageID: "m1", ConversationID: "c1", Body: "hi"})
+	ts.store.UpsertMessage(&db.Message{MessageID: "m2", ConversationID: "c1", ReplyToID: "m1"})
+
+	del := func(id string) *http.Response {
+		req, _ := http.NewRequest(http.MethodDelete, ts.server.URL+"/api/messages/"+id, nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	resp := del("m1")
+	var res client.DeleteResult
+	json.NewDecoder(resp.Body).Decode(&res)
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("status = %d, want 200", resp.StatusCode)
+	}
+	if !res.RemoteSkipped || len(res.AffectedReplies) != 1 || res.AffectedReplies[0] != "m2" {
+		t.Errorf("result = %+v", res)
+	}
+	if m, _ := ts.store.GetMessageByID("m1"); m != nil {
+		t.Error("m1 not deleted locally")
+	}
+
+	resp = del("m1")
+	resp.Body.Close()
+	if resp.StatusCode != 404 {
+		t.Errorf("repeat delete: status = %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestMessageStatusHistory(t *testing.T) {
+	ts := newTestServer(t)
+	ts.store.UpsertMessage(&db.Message{MessageID: "m1", ConversationID: "c1", Status: "OUTGOING_DELIVERED"})
+	ts.store.RecordStatusChange("m1", "OUTGOING_COMPLETE", time.UnixMilli(1000))
+	ts.store.RecordStatusChange("m1", "OUTGOING_DELIVERED", time.UnixMilli(2000))
+
+	resp, err := http.Get(ts.server.URL + "/api/messages/m1/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body struct {
+		Status string           `json:"status"`
+		Events []db.StatusEvent `json:"events"`
+	}
+	json.NewDecoder(resp.Body).Decode(&body)
+	resp.Body.Close()
+	if body.Status != "OUTGOING_DELIVERED" || len(body.Events) != 2 ||
+		body.Events[0].Status != "OUTGOING_COMPLETE" || body.Events[1].TimestampMS != 2000 {
+		t.Errorf("body = %+v", body)
+	}
+
+	resp, _ = http.Get(ts.server.URL + "/api/messages/missing/status")
+	resp.Body.Close()
+	if resp.StatusCode != 404 {
+		t.Errorf("missing message: status = %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestMessageEditHistory(t *testing.T) {
+	ts := newTestServer(t)
+	ts.store.UpsertMessage(&db.Message{MessageID: "m1", ConversationID: "c1", Body: "helo"})
+	ts.store.UpsertMessage(&db.Message{MessageID: "m1", ConversationID: "c1", Body: "hello"})
+
+	resp, err := http.Get(ts.server.URL + "/api/messages/m1/edits")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body struct {
+		Body       string           `json:"body"`
+		EditedAtMS int64            `json:"edited_at_ms"`
+		Edits      []db.MessageEdit `json:"edits"`
+	}
+	json.NewDecoder(resp.Body).Decode(&body)
+	resp.Body.Close()
+	if body.Body != "hello" || body.EditedAtMS == 0 || len(body.Edits) != 1 || body.Edits[0].Body != "helo" {
+		t.Errorf("body = %+v", body)
+	}
+
+	resp, _ = http.Get(ts.server.URL + "/api/messages/missing/edits")
+	resp.Body.Close()
+	if resp.StatusCode != 404 {
+		t.Errorf("missing message: status = %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestMessageByTmpID(t *testing.T) {
+	ts := newTestServer(t)
+	ts.store.UpsertMessage(&db.Message{MessageID: "tmp_000000000001", ConversationID: "c1", IsFromMe: true, Status: client.StatusSending})
+
+	get := func(tmpID string) (int, db.Message) {
+		resp, err := http.Get(ts.server.URL + "/api/messages/by-tmp/" + tmpID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var m db.Message
+		json.NewDecoder(resp.Body).Decode(&m)
+		return resp.StatusCode, m
+	}
+
+	if code, m := get("tmp_000000000001"); code != 200 || m.MessageID != "tmp_000000000001" {
+		t.Errorf("before reconcile: %d %+v, want the placeholder", code, m)
+	}
+
+	ts.store.UpsertMessage(&db.Message{MessageID: "real-1", ConversationID: "c1", IsFromMe: true, TmpID: "tmp_000000000001"})
+	if code, m := get("tmp_000000000001"); code != 200 || m.MessageID != "real-1" {
+		t.Errorf("after reconcile: %d %+v, want real-1", code, m)
+	}
+
+	if code, _ := get("tmp_999999999999"); code != 404 {
+		t.Errorf("unknown tmp ID: status = %d, want 404", code)
+	}
+}
+
+func TestPatchConversationColor(t *testing.T) {
+	ts := newTestServer(t)
+	ts.store.UpsertConversation(&db.Conversation{ConversationID: "c1", Name: "Alice"})
+
+	patch := func(id, body string) *http.Response {
+		req, _ := http.NewRequest(http.MethodPatch, ts.server.URL+"/api/conversations/"+id, strings.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	resp := patch("c1", `{"color":"#0b8043"}`)
+	var conv db.Conversation
+	json.NewDecoder(resp.Body).Decode(&conv)
+	resp.Body.Close()
+	if resp.StatusCode != 200 || conv.Color != "#0b8043" {
+		t.Errorf("patch: status=%d conv=%+v", resp.StatusCode, conv)
+	}
+
+	resp, _ = http.Get(ts.server.URL + "/api/conversations")
+	var convs []db.Conversation
+	json.NewDecoder(resp.Body).Decode(&convs)
+	resp.Body.Close()
+	if len(convs) != 1 || convs[0].Color != "#0b8043" {
+		t.Errorf("list = %+v, want color in conversation JSON", convs)
+	}
+
+	resp = patch("c1", `{"color":"not a color!"}`)
+	resp.Body.Close()
+	if resp.StatusCode != 400 {
+		t.Errorf("invalid color: status = %d, want 400", resp.StatusCode)
+	}
+	resp = patch("missing", `{"color":"#fff"}`)
+	resp.Body.Close()
+	if resp.StatusCode != 404 {
+		t.Errorf("missing conversation: status = %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestOlderMessagesOffline(t *testing.T) {
+	ts := newTestServer(t)
+	ts.store.UpsertMessage(&db.Message{MessageID: "m1", ConversationID: "c1", TimestampMS: 1000})
+	ts.store.UpsertMessage(&db.Message{MessageID: "m2", ConversationID: "c1", TimestampMS: 2000})
+
+	resp, err := http.Get(ts.server.URL + "/api/conversations/c1/older?before_ts=2000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msgs []db.Message
+	json.NewDecoder(resp.Body).Decode(&msgs)
+	resp.Body.Close()
+	if len(msgs) != 1 || msgs[0].MessageID != "m1" {
+		t.Errorf("msgs = %+v, want only m1", msgs)
+	}
+	if got := resp.Header.Get("X-Fetched-Remote"); got != "0" {
+		t.Errorf("X-Fetched-Remote = %q, want 0 while disconnected", got)
+	}
+}
+
+func TestTypingLongPoll(t *testing.T) {
+	store, err := db.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	typing := client.NewTypingTracker(time.Minute)
+	srv := httptest.NewServer(APIHandlerWithOptions(store, nil, zerolog.Nop(), nil, Options{Typing: typing}))
+	defer srv.Close()
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		typing.Start("c1", "+15551234567", "Alice")
+	}()
+	resp, err := http.Get(srv.URL + "/api/typing?wait=5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var states []client.TypingState
+	json.NewDecoder(resp.Body).Decode(&states)
+	resp.Body.Close()
+	if len(states) != 1 || states[0].Name != "Alice" {
+		t.Errorf("states = %+v, want Alice typing", states)
+	}
+}
+
+func TestListConversationsPaginated(t *testing.T) {
+	ts := newTestServer(t)
+	for i := 0; i < 5; i++ {
+		ts.store.UpsertConversation(&db.Conversation{ConversationID: fmt.Sprintf("c%d", i), LastMessageTS: int64(1000 * (i + 1))})
+	}
+
+	page := func(query string) conversationPage {
+		resp, err := http.Get(ts.server.URL + "/api/conversations?paginated=true" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var p conversationPage
+		if err := json.NewDecoder(resp.Body).Decode(&p); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		return p
+	}
+
+	p := page("&limit=2&offset=2")
+	if p.Total != 5 || p.Offset != 2 || p.Limit != 2 || len(p.Conversations) != 2 || p.Conversations[0].ConversationID != "c2" {
+		t.Errorf("second page = %+v, want c2 and c1 of 5", p)
+	}
+	p = page("&limit=2&offset=10")
+	if p.Total != 5 || p.Conversations == nil || len(p.Conversations) != 0 {
+		t.Errorf("page beyond the end = %+v, want an empty list and the total", p)
+	}
+}
+
+func TestArchiveConversation(t *testing.T) {
+	ts := newTestServer(t)
+	ts.store.UpsertConversation(&db.Conversation{ConversationID: "c1", LastMessageTS: 1000, UnreadCount: 2})
+	ts.store.UpsertConversation(&db.Conversation{ConversationID: "c2", LastMessageTS: 2000})
+
+	post := func(path string) int {
+		resp, err := http.Post(ts.server.URL+path, "application/json", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	list := func(query string) []db.Conversation {
+		resp, err := http.Get(ts.server.URL + "/api/conversations" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var convs []db.Conversation
+		json.NewDecoder(resp.Body).Decode(&convs)
+		return convs
+	}
+
+	if code := post("/api/conversations/c1/archive"); code != 200 {
+		t.Fatalf("archive: status %d", code)
+	}
+	if convs := list(""); len(convs) != 1 || convs[0].ConversationID != "c2" {
+		t.Errorf("default list = %+v, want only c2", convs)
+	}
+	convs := list("?include_archived=true")
+	if len(convs) != 2 || !convs[1].Archived || convs[1].UnreadCount != 2 {
+		t.Errorf("list with archived = %+v, want archived c1 with its unread count", convs)
+	}
+
+	if code := post("/api/conversations/c1/unarchive"); code != 200 {
+		t.Fatalf("unarchive: status %d", code)
+	}
+	if convs := list(""); len(convs) != 2 {
+		t.Errorf("after unarchive = %+v, want both", convs)
+	}
+	if code := post("/api/conversations/missing/archive"); code != 404 {
+		t.Errorf("missing: status %d, want 404", code)
+	}
+}
+
+func TestListConversationsTransport(t *testing.T) {
+	ts := newTestServer(t)
+	ts.store.UpsertConversation(&db.Conversation{ConversationID: "rcs1", LastMessageTS: 2000, Transport: db.TransportRCS})
+	ts.store.UpsertConversation(&db.Conversation{ConversationID: "sms1", LastMessageTS: 1000, Transport: db.TransportSMS})
+
+	resp, err := http.Get(ts.server.URL + "/api/conversations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var convs []db.Conversation
+	json.NewDecoder(resp.Body).Decode(&convs)
+	if len(convs) != 2 || convs[0].Transport != "rcs" || convs[1].Transport != "sms" {
+		t.Errorf("conversations = %+v, want cached rcs and sms transports", convs)
+	}
+}
+
+func TestPinConversation(t *testing.T) {
+	ts := newTestServer(t)
+	ts.store.UpsertConversation(&db.Conversation{ConversationID: "c1", LastMessageTS: 1000})
+	ts.store.UpsertConversation(&db.Conversation{ConversationID: "c2", LastMessageTS: 2000})
+
+	resp, err := http.Post(ts.server.URL+"/api/conversations/c1/pin", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("pin: status %d", resp.StatusCode)
+	}
+
+	resp, _ = http.Get(ts.server.URL + "/api/conversations")
+	var convs []db.Conversation
+	json.NewDecoder(resp.Body).Decode(&convs)
+	resp.Body.Close()
+	if len(convs) != 2 || convs[0].ConversationID != "c1" || !convs[0].Pinned || convs[1].Pinned {
+		t.Errorf("conversations = %+v, want pinned c1 first", convs)
+	}
+
+	resp, _ = http.Post(ts.server.URL+"/api/conversations/c1/unpin", "application/json", nil)
+	resp.Body.Close()
+	if c, _ := ts.store.GetConversation("c1"); c.Pinned {
+		t.Error("c1 still pinned after unpin")
+	}
+}
+
+func TestMaintenanceRebuild(t *testing.T) {
+	ts := newTestServer(t)
+	ts.store.UpsertConversation(&db.Conversation{ConversationID: "c1"})
+	ts.store.UpsertMessage(&db.Message{MessageID: "m1", ConversationID: "c1", Body: "hi", TimestampMS: 5000})
+
+	resp, err := http.Get(ts.server.URL + "/api/maintenance/rebuild")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 405 {
+		t.Errorf("GET status = %d, want 405", resp.StatusCode)
+	}
+
+	resp, err = http.Post(ts.server.URL+"/api/maintenance/rebuild", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var out struct {
+		Steps []db.RebuildStep `json:"steps"`
+	}
+	json.NewDecoder(resp.Body).Decode(&out)
+	if resp.StatusCode != 200 || len(out.Steps) == 0 {
+		t.Fatalf("status %d, steps %+v", resp.StatusCode, out.Steps)
+	}
+	if c, _ := ts.store.GetConversation("c1"); c.LastMessageTS != 5000 {
+		t.Errorf("last_message_ts = %d, want 5000", c.LastMessageTS)
+	}
+}
+
+func TestMuteConversation(t *testing.T) {
+	ts := newTestServer(t)
+	ts.store.UpsertConversation(&db.Conversation{ConversationID: "c1", UnreadCount: 4})
+	ts.store.UpsertConversation(&db.Conversation{ConversationID: "c2", UnreadCount: 1})
+
+	resp, err := http.Post(ts.server.URL+"/api/conversations/c1/mute", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("mute: status %d", resp.StatusCode)
+	}
+
+	resp, _ = http.Get(ts.server.URL + "/api/status")
+	var status map[string]any
+	json.NewDecoder(resp.Body).Decode(&status)
+	resp.Body.Close()
+	if status["unread"] != float64(1) {
+		t.Errorf("status unread = %v, want 1", status["unread"])
+	}
+
+	resp, _ = http.Post(ts.server.URL+"/api/conversations/missing/mute", "application/json", nil)
+	resp.Body.Close()
+	if resp.StatusCode != 404 {
+		t.Errorf("missing: status %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestConversationMessagesFilters(t *testing.T) {
+	ts := newTestServer(t)
+	ts.store.UpsertMessage(&db.Message{MessageID: "m1", ConversationID: "c1", Body: "hi", TimestampMS: 1000})
+	ts.store.UpsertMessage(&db.Message{MessageID: "m2", ConversationID: "c1", Body: "https://example.com", TimestampMS: 2000})
+	ts.store.UpsertMessage(&db.Message{MessageID: "m3", ConversationID: "c1", MediaID: "media-1", TimestampMS: 3000})
+
+	for query, want := range map[string]string{"has_link=1": "m2", "has_media=1": "m3"} {
+		resp, err := http.Get(ts.server.URL + "/api/conversations/c1/messages?" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var msgs []db.Message
+		json.NewDecoder(resp.Body).Decode(&msgs)
+		resp.Body.Close()
+		if len(msgs) != 1 || msgs[0].MessageID != want {
+			t.Errorf("?%s returned %+v, want only %s", query, msgs, want)
+		}
+	}
+}
+
+func TestBulkConversations(t *testing.T) {
+	ts := newTestServer(t)
+	ts.store.UpsertConversation(&db.Conversation{ConversationID: "c1", UnreadCount: 1})
+	ts.store.UpsertConversation(&db.Conversation{ConversationID: "c2", UnreadCount: 1})
+
+	post := func(body string) (int, []db.ConversationResult) {
+		t.Helper()
+		resp, err := http.Post(ts.server.URL+"/api/conversations/bulk", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var out struct {
+			Results []db.ConversationResult `json:"results"`
+		}
+		json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out.Results
+	}
+
+	code, results := post(`{"ids":["c1","nope","c2"],"action":"mute"}`)
+	if code != 200 || len(results) != 3 {
+		t.Fatalf("status %d, results %+v", code, results)
+	}
+	if !results[0].Success || results[1].Success || !results[2].Success {
+		t.Errorf("results = %+v", results)
+	}
+	if c, _ := ts.store.GetConversation("c2"); !c.Muted {
+		t.Error("c2 not muted")
+	}
+
+	if code, _ := post(`{"ids":["c1"],"action":"explode"}`); code != 400 {
+		t.Errorf("unknown action: status %d, want 400", code)
+	}
+	if code, _ := post(`{"ids":[],"action":"read"}`); code != 400 {
+		t.Errorf("no ids: status %d, want 400", code)
+	}
+}
+
+func TestSearchRegex(t *testing.T) {
+	ts := newTestServer(t)
+	ts.store.UpsertMessage(&db.Message{MessageID: "m1", ConversationID: "c1", Body: "order #4521 shipped", TimestampMS: 1000})
+	ts.store.UpsertMessage(&db.Message{MessageID: "m2", ConversationID: "c1", Body: "order soon", TimestampMS: 2000})
+
+	resp, err := http.Get(ts.server.URL + "/api/search?regex=1&q=" + url.QueryEscape(`#\d{4}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msgs []db.Message
+	json.NewDecoder(resp.Body).Decode(&msgs)
+	resp.Body.Close()
+	if resp.StatusCode != 200 || len(msgs) != 1 || msgs[0].MessageID != "m1" {
+		t.Errorf("status %d, messages %+v; want only m1", resp.StatusCode, msgs)
+	}
+
+	resp, err = http.Get(ts.server.URL + "/api/search?regex=1&q=" + url.QueryEscape("order ("))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 400 {
+		t.Errorf("invalid pattern: status %d, want 400", resp.StatusCode)
+	}
+}
+
+func TestSearchCount(t *testing.T) {
+	ts := newTestServer(t)
+	for i, body := range []string{"invoice sent", "invoice paid", "lunch?"} {
+		ts.store.UpsertMessage(&db.Message{MessageID: fmt.Sprintf("m%d", i), ConversationID: "c1", Body: body, TimestampMS: int64(1000 * (i + 1))})
+	}
+	ts.store.UpsertMessage(&db.Message{MessageID: "other", ConversationID: "c2", Body: "invoice", TimestampMS: 5000})
+
+	for query, want := range map[string]int{
+		"q=invoice":                    3,
+		"q=invoice&conversation_id=c1": 2,
+		"q=invoice&after_ts=2000":      2,
+		"q=dinner":                     0,
+	} {
+		resp, err := http.Get(ts.server.URL + "/api/search/count?" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body struct {
+			Count int `json:"count"`
+		}
+		json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+		if resp.StatusCode != 200 || body.Count != want {
+			t.Errorf("%s: status %d, count %d; want %d", query, resp.StatusCode, body.Count, want)
+		}
+	}
+
+	resp, err := http.Get(ts.server.URL + "/api/search/count")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 400 {
+		t.Errorf("missing q: status %d, want 400", resp.StatusCode)
+	}
+}
+
+// fakePauser is a SyncPauser backed by a real gate with nothing to replay.
+type fakePauser struct {
+	gate client.SyncGate
+}
+
+func (f *fakePauser) PauseSync(buffer bool)           { f.gate.Pause(buffer) }
+func (f *fakePauser) ResumeSync() int                 { return f.gate.Resume(func(any) {}) }
+func (f *fakePauser) SyncState() client.SyncGateState { return f.gate.State() }
+
+func TestBackfillAlreadyRunning(t *testing.T) {
+	store, err := db.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	running, connected, paused := false, false, true
+	srv := httptest.NewServer(APIHandlerWithOptions(store, nil, zerolog.Nop(), nil, Options{
+		OnDeepBackfill: func(bool) error {
+			if !connected {
+				connected = true
+				return client.ErrNotConnected
+			}
+			if paused {
+				paused = false
+				return client.ErrSyncPaused
+			}
+			if running {
+				return client.ErrBackfillRunning
+			}
+			running = true
+			return nil
+		},
+	}))
+	defer srv.Close()
+
+	for i, want := range []int{503, 409, 200, 409} {
+		resp, err := http.Post(srv.URL+"/api/backfill", "application/json", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("request %d: status %d, want %d", i+1, resp.StatusCode, want)
+		}
+	}
+}
+
+func TestBackfillConversationEndpoint(t *testing.T) {
+	store, err := db.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	srv := httptest.NewServer(APIHandlerWithOptions(store, nil, zerolog.Nop(), nil, Options{
+		BackfillConversation: func(convID string, maxMessages int, force bool) error { return nil },
+		BackfillProgress:     func(convID string) (int, bool) { return 7, true },
+	}))
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"/api/conversations/c1/backfill", "application/json", strings.NewReader(`{"max_messages": 10}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 503 {
+		t.Errorf("without a client: status %d, want 503", resp.StatusCode)
+	}
+
+	store.SaveBackfillState(&db.BackfillState{ConversationID: "c1", OldestTS: 1000, Complete: true})
+	resp, err = http.Get(srv.URL + "/api/conversations/c1/backfill")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var got struct {
+		Running          bool  `json:"running"`
+		Stored           int   `json:"stored"`
+		Complete         bool  `json:"complete"`
+		OldestTS         int64 `json:"oldest_ts"`
+		DeepBackfilledAt int64 `json:"deep_backfilled_at"`
+	}
+	json.NewDecoder(resp.Body).Decode(&got)
+	if resp.StatusCode != 200 || !got.Complete || got.OldestTS != 1000 || got.DeepBackfilledAt == 0 {
+		t.Errorf("GET: status %d, %+v; want complete with oldest_ts 1000", resp.StatusCode, got)
+	}
+	if !got.Running || got.Stored != 7 {
+		t.Errorf("GET: running %v, stored %d; want the run's progress", got.Running, got.Stored)
+	}
+}
+
+func TestSyncPauseResume(t *testing.T) {
+	store, err := db.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	p := &fakePauser{}
+	backfills := 0
+	srv := httptest.NewServer(APIHandlerWithOptions(store, nil, zerolog.Nop(), nil, Options{
+		Sync:           p,
+		OnDeepBackfill: func(bool) error { backfills++; return nil },
+	}))
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"/api/sync/pause", "application/json", strings.NewReader(`{"buffer": false}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if st := p.gate.State(); resp.StatusCode != 200 || !st.Paused || st.Buffering {
+		t.Fatalf("pause: status %d, state %+v", resp.StatusCode, st)
+	}
+
+	var status struct {
+		Sync client.SyncGateState `json:"sync"`
+	}
+	resp, _ = http.Get(srv.URL + "/api/status")
+	json.NewDecoder(resp.Body).Decode(&status)
+	resp.Body.Close()
+	if !status.Sync.Paused {
+		t.Errorf("/api/status sync = %+v, want paused", status.Sync)
+	}
+
+	resp, _ = http.Post(srv.URL+"/api/backfill", "application/json", nil)
+	resp.Body.Close()
+	if resp.StatusCode != 409 {
+		t.Errorf("backfill while paused: status %d, want 409", resp.StatusCode)
+	}
+
+	// Reads keep working while paused.
+	resp, _ = http.Get(srv.URL + "/api/conversations")
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Errorf("conversations while paused: status %d", resp.StatusCode)
+	}
+
+	resp, _ = http.Post(srv.URL+"/api/sync/resume", "application/json", nil)
+	resp.Body.Close()
+	if resp.StatusCode != 200 || p.gate.Paused() {
+		t.Errorf("resume: status %d, paused %v", resp.StatusCode, p.gate.Paused())
+	}
+	if backfills != 0 {
+		t.Errorf("backfill ran %d times while paused", backfills)
+	}
+
+	// Pausing with no body buffers by default.
+	resp, _ = http.Post(srv.URL+"/api/sync/pause", "application/json", nil)
+	resp.Body.Close()
+	if !p.gate.State().Buffering {
+		t.Error("default pause should buffer")
+	}
+}
+
+func TestConversationStats(t *testing.T) {
+	ts := newTestServer(t)
+	ts.store.UpsertConversation(&db.Conversation{ConversationID: "c1"})
+	ts.store.UpsertMessage(&db.Message{MessageID: "t1", ConversationID: "c1", Body: "hi", IsFromMe: true, TimestampMS: 1000})
+	ts.store.UpsertMessage(&db.Message{MessageID: "p1", ConversationID: "c1", MediaID: "a", MimeType: "image/png", TimestampMS: 2000})
+
+	resp, err := http.Get(ts.server.URL + "/api/conversations/c1/stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		MessageCount int
+		SentCount    int
+		Types        db.MessageTypeCounts
+	}
+	json.NewDecoder(resp.Body).Decode(&got)
+	resp.Body.Close()
+	if resp.StatusCode != 200 || got.MessageCount != 2 || got.SentCount != 1 {
+		t.Fatalf("status %d, stats %+v", resp.StatusCode, got)
+	}
+	if got.Types.Text != 1 || got.Types.Media != 1 || got.Types.MimeTypes["image/png"] != 1 {
+		t.Errorf("types = %+v", got.Types)
+	}
+
+	resp, err = http.Get(ts.server.URL + "/api/conversations/missing/stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 404 {
+		t.Errorf("missing conversation: status %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestDownloadReusesCloudCopy(t *testing.T) {
+	ts := newTestServer(t)
+	ts.store.UpsertMessage(&db.Message{MessageID: "m1", ConversationID: "c1", MediaID: "media-1", MimeType: "image/jpeg"})
+	ts.store.SetMessageMediaCloudURL("m1", "https://cdn.example/c1/m1.jpg")
+
+	uploads := 0
+	srv := httptest.NewServer(APIHandlerWithOptions(ts.store, nil, zerolog.Nop(), nil, Options{
+		MediaUploader: func(messageID string) (string, error) { uploads++; return "https://cdn.example/new", nil },
+	}))
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"/api/download", "application/json", strings.NewReader(`{"message_id":"m1"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var body map[string]string
+	json.NewDecoder(resp.Body).Decode(&body)
+	if body["url"] != "https://cdn.example/c1/m1.jpg" || uploads != 0 {
+		t.Errorf("got url %q after %d uploads, want the stored URL without uploading", body["url"], uploads)
+	}
+}
+
+type failingDownloader struct{}
+
+func (failingDownloader) DownloadMedia(mediaID string, key []byte) ([]byte, error) {
+	return nil, errors.New("blob expired")
+}
+
+func TestMediaFallsBackToCloudCopy(t *testing.T) {
+	store, err := db.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	store.UpsertMessage(&db.Message{MessageID: "m1", ConversationID: "c1", MediaID: "media-1", MimeType: "image/jpeg", DecryptionKey: "deadbeef"})
+
+	const cloudURL = "https://example.supabase.co/storage/v1/object/public/media/c1/m1.jpg"
+	srv := httptest.NewServer(APIHandlerWithOptions(store, nil, zerolog.Nop(), nil, Options{
+		MediaUploader: func(messageID string) (string, error) { return cloudURL, nil },
+	}))
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"/api/download", "application/json", strings.NewReader(`{"message_id":"m1"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	msg, _ := store.GetMessageByID("m1")
+	if msg.MediaCloudURL != cloudURL {
+		t.Fatalf("stored cloud URL = %q, want %q", msg.MediaCloudURL, cloudURL)
+	}
+
+	// The live download fails, so the cloud copy is served instead.
+	rec := httptest.NewRecorder()
+	serveMedia(rec, httptest.NewRequest("GET", "/api/media/m1", nil), msg, failingDownloader{}, newDownloadLimiter(1))
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != cloudURL {
+		t.Errorf("got %d to %q, want redirect to cloud copy", rec.Code, rec.Header().Get("Location"))
+	}
+
+	// Without a live client the cloud copy is served too.
+	noRedirect := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err = noRedirect.Get(srv.URL + "/api/media/m1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound || resp.Header.Get("Location") != cloudURL {
+		t.Errorf("got %d to %q, want redirect to cloud copy", resp.StatusCode, resp.Header.Get("Location"))
+	}
+
+	// Without a cloud copy the download error is reported.
+	msg.MediaCloudURL = ""
+	rec = httptest.NewRecorder()
+	serveMedia(rec, httptest.NewRequest("GET", "/api/media/m1", nil), msg, failingDownloader{}, newDownloadLimiter(1))
+	if rec.Code != http.StatusBadGateway {
+		t.Errorf("got %d, want 502", rec.Code)
+	}
+}
+
+func TestSendAsEndpoint(t *testing.T) {
+	ts := newTestServer(t)
+	ts.store.UpsertConversation(&db.Conversation{ConversationID: "c1", Name: "Alice"})
+	url := ts.server.URL + "/api/conversations/c1/send-as"
+
+	resp, err := http.Post(url, "application/json", strings.NewReader(`{"send_as":"rcs"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("set: got status %d, want 200", resp.StatusCode)
+	}
+
+	resp, err = http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]string
+	json.NewDecoder(resp.Body).Decode(&got)
+	resp.Body.Close()
+	if got["send_as"] != "rcs" {
+		t.Errorf("get: send_as = %q, want rcs", got["send_as"])
+	}
+
+	resp, _ = http.Post(url, "application/json", strings.NewReader(`{"send_as":"fax"}`))
+	resp.Body.Close()
+	if resp.StatusCode != 400 {
+		t.Errorf("invalid value: got status %d, want 400", resp.StatusCode)
+	}
+
+	resp, _ = http.Post(ts.server.URL+"/api/conversations/missing/send-as", "application/json", strings.NewReader(`{"send_as":"sms"}`))
+	resp.Body.Close()
+	if resp.StatusCode != 404 {
+		t.Errorf("missing conversation: got status %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestDownloadLimiterQueuesExcess(t *testing.T) {
+	l := newDownloadLimiter(1)
+	release := make(chan struct{})
+	started := make(chan string, 2)
+
+	blocking := func(name string) func() ([]byte, error) {
+		return func() ([]byte, error) {
+			started <- name
+			<-release
+			return []byte(name), nil
+		}
+	}
+
+	go l.do(context.Background(), blocking("first"))
+	if got := <-started; got != "first" {
+		t.Fatalf("started %q, want first", got)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		l.do(context.Background(), blocking("second"))
+		close(done)
+	}()
+
+	select {
+	case <-started:
+		t.Fatal("second download started while the first held the only slot")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatal("second download never started")
+	}
+	<-done
+}
+
+func TestDownloadLimiterCancelWhileQueued(t *testing.T) {
+	l := newDownloadLimiter(1)
+	l.slots <- struct{}{} // occupy the only slot
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := l.do(ctx, func() ([]byte, error) { return nil, nil }); err != context.Canceled {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+}
+
+func TestMediaNotModified(t *testing.T) {
+	ts := newTestServer(t)
+	ts.store.UpsertMessage(&db.Message{MessageID: "m1", ConversationID: "c1", MediaID: "media-1", MimeType: "image/png"})
+
+	req, _ := http.NewRequest("GET", ts.server.URL+"/api/media/m1", nil)
+	req.Header.Set("If-None-Match", `"m1"`)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	// Answered from the ETag even though no client is connected.
+	if resp.StatusCode != http.StatusNotModified {
+		t.Errorf("got status %d, want 304", resp.StatusCode)
+	}
+}
+
+func TestSyncRetryEndpoint(t *testing.T) {
+	store, err := db.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	var retryErr error
+	h := APIHandlerWithOptions(store, nil, zerolog.Nop(), nil, Options{
+		RetrySync: func() (int, int, error) { return 3, 1, retryErr },
+	})
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"/api/sync/retry", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]int
+	json.NewDecoder(resp.Body).Decode(&got)
+	resp.Body.Close()
+	if got["succeeded"] != 3 || got["failed"] != 1 {
+		t.Errorf("got %v, want succeeded=3 failed=1", got)
+	}
+
+	retryErr = client.ErrSyncInProgress
+	resp, _ = http.Post(srv.URL+"/api/sync/retry", "application/json", nil)
+	resp.Body.Close()
+	if resp.StatusCode != 409 {
+		t.Errorf("concurrent run: got status %d, want 409", resp.StatusCode)
+	}
+
+	// Not configured
+	ts := newTestServer(t)
+	resp, _ = http.Post(ts.server.URL+"/api/sync/retry", "application/json", nil)
+	resp.Body.Close()
+	if resp.StatusCode != 404 {
+		t.Errorf("without Supabase: got status %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestPinMessageEndpoints(t *testing.T) {
+	ts := newTestServer(t)
+	ts.store.UpsertMessage(&db.Message{MessageID: "m1", ConversationID: "c1", Body: "remember this", TimestampMS: 100})
+	ts.store.UpsertMessage(&db.Message{MessageID: "m2", ConversationID: "c2", Body: "other thread", TimestampMS: 200})
+	base := ts.server.URL + "/api/conversations/c1"
+
+	pinnedIDs := func() []string {
+		resp, err := http.Get(base + "/pinned")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var msgs []db.Message
+		json.NewDecoder(resp.Body).Decode(&msgs)
+		var ids []string
+		for _, m := range msgs {
+			ids = append(ids, m.MessageID)
+		}
+		return ids
+	}
+
+	if ids := pinnedIDs(); len(ids) != 0 {
+		t.Fatalf("initially pinned: %v", ids)
+	}
+
+	resp, _ := http.Post(base+"/pin-message", "application/json", strings.NewReader(`{"message_id":"m1"}`))
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("pin: got status %d", resp.StatusCode)
+	}
+	if ids := pinnedIDs(); len(ids) != 1 || ids[0] != "m1" {
+		t.Errorf("after pin: %v", ids)
+	}
+
+	// Messages from another conversation can't be pinned here.
+	resp, _ = http.Post(base+"/pin-message", "application/json", strings.NewReader(`{"message_id":"m2"}`))
+	resp.Body.Close()
+	if resp.StatusCode != 404 {
+		t.Errorf("foreign message: got status %d, want 404", resp.StatusCode)
+	}
+
+	resp, _ = http.Post(base+"/pin-message", "application/json", strings.NewReader(`{"message_id":"m1","pinned":false}`))
+	resp.Body.Close()
+	if ids := pinnedIDs(); len(ids) != 0 {
+		t.Errorf("after unpin: %v", ids)
+	}
+}
@@ -0,0 +1,115 @@
+package web
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"html/template"
+	"net/http"
+	"strings"
+)
+
+// authCookie holds a browser's session once it has shown the API key. Its
+// value is derived from the key, so the key itself never sits in a cookie.
+const authCookie = "openmessage_session"
+
+// loginPath accepts the login form posted from loginPage.
+const loginPath = "/login"
+
+// requireAPIKey wraps h so every request, including static files and /mcp/,
+// must carry "Authorization: Bearer <key>" or the session cookie. Browsers,
+// which can't add the header to page loads or EventSource, get the cookie by
+// posting the key to /login from the login page served in place of a 401,
+// or by opening any page with ?token=<key> once. Others get a 401.
+func requireAPIKey(h http.Handler, key string) http.Handler {
+	want := []byte(key)
+	session := sessionValue(key)
+	valid := func(token string) bool {
+		return subtle.ConstantTimeCompare([]byte(strings.TrimSpace(token)), want) == 1
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == loginPath && r.Method == http.MethodPost {
+			if !valid(r.PostFormValue("key")) {
+				serveLogin(w, "Wrong key.")
+				return
+			}
+			setSessionCookie(w, r, session)
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+		if token := r.URL.Query().Get("token"); token != "" && r.Method == http.MethodGet && valid(token) {
+			// Swap the key for a cookie and drop it from the address bar.
+			setSessionCookie(w, r, session)
+			u := *r.URL
+			q := u.Query()
+			q.Del("token")
+			u.RawQuery = q.Encode()
+			http.Redirect(w, r, u.RequestURI(), http.StatusSeeOther)
+			return
+		}
+
+		if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && valid(token) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if c, err := r.Cookie(authCookie); err == nil && subtle.ConstantTimeCompare([]byte(c.Value), []byte(session)) == 1 {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if r.Method == http.MethodGet && strings.Contains(r.Header.Get("Accept"), "text/html") {
+			serveLogin(w, "")
+			return
+		}
+		w.Header().Set("WWW-Authenticate", `Bearer realm="openmessage"`)
+		httpError(w, "unauthorized", http.StatusUnauthorized)
+	})
+}
+
+// sessionValue derives the session cookie's value from the API key.
+func sessionValue(key string) string {
+	sum := sha256.Sum256([]byte("openmessage-session\x00" + key))
+	return hex.EncodeToString(sum[:])
+}
+
+func setSessionCookie(w http.ResponseWriter, r *http.Request, value string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     authCookie,
+		Value:    value,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+var loginPage = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>OpenMessage</title>
+<style>
+  body { font-family: system-ui, sans-serif; background: #1a1a2e; color: #e0e0e0; display: flex; align-items: center; justify-content: center; height: 100vh; margin: 0; }
+  form { display: flex; flex-direction: column; gap: 12px; width: 280px; }
+  input, button { font: inherit; padding: 8px 10px; border-radius: 6px; border: 1px solid #444; }
+  button { background: #4a6cf7; color: #fff; border: none; cursor: pointer; }
+  .error { color: #f77; margin: 0; }
+</style>
+</head>
+<body>
+<form method="post" action="/login">
+  <label for="key">API key (OPENMESSAGES_API_KEY)</label>
+  <input id="key" name="key" type="password" autocomplete="current-password" autofocus required>
+  {{if .}}<p class="error">{{.}}</p>{{end}}
+  <button type="submit">Sign in</button>
+</form>
+</body>
+</html>
+`))
+
+// serveLogin answers an unauthenticated page load with the login form.
+func serveLogin(w http.ResponseWriter, msg string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusUnauthorized)
+	loginPage.Execute(w, msg)
+}
@@ -0,0 +1,139 @@
+package web
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+
+	"github.com/maxghenis/openmessage/internal/db"
+)
+
+func TestAPIKeyRequired(t *testing.T) {
+	store, err := db.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	mcp := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("mcp"))
+	})
+	srv := httptest.NewServer(APIHandlerWithOptions(store, nil, zerolog.Nop(), mcp, Options{APIKey: "s3cret"}))
+	defer srv.Close()
+
+	get := func(path, auth string) int {
+		t.Helper()
+		req, _ := http.NewRequest("GET", srv.URL+path, nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	for _, path := range []string{"/api/conversations", "/", "/mcp/sse"} {
+		for _, auth := range []string{"", "Bearer wrong", "s3cret", "Basic s3cret"} {
+			if code := get(path, auth); code != http.StatusUnauthorized {
+				t.Errorf("GET %s with %q = %d, want 401", path, auth, code)
+			}
+		}
+		if code := get(path, "Bearer s3cret"); code != http.StatusOK {
+			t.Errorf("GET %s with the key = %d, want 200", path, code)
+		}
+	}
+}
+
+func TestAPIKeyUnset(t *testing.T) {
+	store, err := db.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	srv := httptest.NewServer(APIHandlerWithOptions(store, nil, zerolog.Nop(), nil, Options{}))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/api/conversations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("GET /api/conversations without a key configured = %d, want 200", resp.StatusCode)
+	}
+}
+
+func TestAPIKeyBrowserSession(t *testing.T) {
+	store, err := db.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	srv := httptest.NewServer(APIHandlerWithOptions(store, nil, zerolog.Nop(), nil, Options{APIKey: "s3cret"}))
+	defer srv.Close()
+	noRedirect := func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+
+	// A page load without credentials gets the login form.
+	req, _ := http.NewRequest("GET", srv.URL+"/", nil)
+	req.Header.Set("Accept", "text/html")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized || !strings.Contains(string(body), `action="/login"`) {
+		t.Errorf("page load = %d, want 401 with the login form", resp.StatusCode)
+	}
+
+	c := &http.Client{CheckRedirect: noRedirect}
+	resp, _ = c.PostForm(srv.URL+"/login", url.Values{"key": {"wrong"}})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized || len(resp.Cookies()) != 0 {
+		t.Errorf("login with the wrong key = %d, cookies %v", resp.StatusCode, resp.Cookies())
+	}
+
+	resp, _ = c.PostForm(srv.URL+"/login", url.Values{"key": {"s3cret"}})
+	resp.Body.Close()
+	cookies := resp.Cookies()
+	if resp.StatusCode != http.StatusSeeOther || len(cookies) != 1 || !cookies[0].HttpOnly || strings.Contains(cookies[0].Value, "s3cret") {
+		t.Fatalf("login = %d, cookies %v", resp.StatusCode, cookies)
+	}
+	for _, path := range []string{"/api/conversations", "/api/events"} {
+		req, _ := http.NewRequest("GET", srv.URL+path, nil)
+		req.AddCookie(cookies[0])
+		ctx, cancel := context.WithCancel(context.Background())
+		resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode == http.StatusUnauthorized {
+			t.Errorf("GET %s with the session cookie = 401", path)
+		}
+		cancel()
+		resp.Body.Close()
+	}
+
+	// ?token= is swapped for the cookie and dropped from the URL.
+	resp, _ = c.Get(srv.URL + "/?token=s3cret&c=1")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSeeOther || resp.Header.Get("Location") != "/?c=1" || len(resp.Cookies()) != 1 {
+		t.Errorf("?token= = %d to %q, cookies %v", resp.StatusCode, resp.Header.Get("Location"), resp.Cookies())
+	}
+	resp, _ = c.Get(srv.URL + "/api/conversations?token=wrong")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("wrong ?token= = %d, want 401", resp.StatusCode)
+	}
+}
@@ -0,0 +1,77 @@
+package web
+
+import (
+	"fmt"
+	"hash/fnv"
+	"html"
+	"net/http"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/maxghenis/openmessage/internal/db"
+)
+
+// avatarColors are the backgrounds of initials placeholders, picked by number
+// so a contact keeps the same color.
+var avatarColors = []string{"#1a73e8", "#d93025", "#188038", "#e37400", "#9334e6", "#007b83", "#c5221f", "#5f6368"}
+
+// handleAvatar serves the stored photo for number, or an SVG with the
+// contact's initials when the phone hasn't sent one.
+func handleAvatar(w http.ResponseWriter, r *http.Request, store *db.Store, number string) {
+	if r.Method != http.MethodGet {
+		httpError(w, "method not allowed", 405)
+		return
+	}
+	if !strings.ContainsAny(number, "0123456789") {
+		httpError(w, "a phone number is required", 400)
+		return
+	}
+	avatar, err := store.GetAvatar(number)
+	if err != nil {
+		httpError(w, "get avatar: "+err.Error(), 500)
+		return
+	}
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	if avatar != nil {
+		w.Header().Set("Content-Type", avatar.MimeType)
+		w.Write(avatar.Data)
+		return
+	}
+	name, err := store.ContactNameByNumber(number)
+	if err != nil {
+		httpError(w, "look up contact: "+err.Error(), 500)
+		return
+	}
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Write(initialsAvatar(name, number))
+}
+
+// initialsAvatar draws a round badge with the initials of name's first and
+// last words, or "#" if name has no letters.
+func initialsAvatar(name, number string) []byte {
+	h := fnv.New32a()
+	h.Write([]byte(number))
+	color := avatarColors[h.Sum32()%uint32(len(avatarColors))]
+	return []byte(fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="96" height="96" viewBox="0 0 96 96">`+
+		`<circle cx="48" cy="48" r="48" fill="%s"/>`+
+		`<text x="48" y="48" dy=".35em" text-anchor="middle" font-family="sans-serif" font-size="40" fill="#fff">%s</text>`+
+		`</svg>`, color, html.EscapeString(initials(name))))
+}
+
+func initials(name string) string {
+	var letters []rune
+	for _, word := range strings.Fields(name) {
+		r, _ := utf8.DecodeRuneInString(word)
+		if unicode.IsLetter(r) {
+			letters = append(letters, unicode.ToUpper(r))
+		}
+	}
+	switch len(letters) {
+	case 0:
+		return "#"
+	case 1:
+		return string(letters)
+	}
+	return string([]rune{letters[0], letters[len(letters)-1]})
+}
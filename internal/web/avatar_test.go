@@ -0,0 +1,80 @@
+package web
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/maxghenis/openmessage/internal/db"
+)
+
+func TestContactAvatar(t *testing.T) {
+	ts := newTestServer(t)
+	photo := testPNG(t, 4, 4)
+	if err := ts.store.SetAvatar("+15551234567", photo, "image/png"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Any formatting of the number finds the photo.
+	resp, err := http.Get(ts.server.URL + "/api/contacts/5551234567/avatar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("status = %d, want 200", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "image/png" {
+		t.Errorf("Content-Type = %q, want image/png", ct)
+	}
+	if body, _ := io.ReadAll(resp.Body); !bytes.Equal(body, photo) {
+		t.Error("served bytes differ from the stored photo")
+	}
+}
+
+func TestContactAvatarPlaceholder(t *testing.T) {
+	ts := newTestServer(t)
+	ts.store.UpsertContact(&db.Contact{ContactID: "c1", Name: "Alice van Smith", Number: "+15551234567"})
+
+	resp, err := http.Get(ts.server.URL + "/api/contacts/+15551234567/avatar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "image/svg+xml" {
+		t.Errorf("Content-Type = %q, want image/svg+xml", ct)
+	}
+	if body, _ := io.ReadAll(resp.Body); !strings.Contains(string(body), ">AS</text>") {
+		t.Errorf("placeholder = %s, want initials AS", body)
+	}
+
+	for path, want := range map[string]int{
+		"/api/contacts/unknown/avatar":     400,
+		"/api/contacts/+15551234567/photo": 404,
+	} {
+		resp, err := http.Get(ts.server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("GET %s = %d, want %d", path, resp.StatusCode, want)
+		}
+	}
+}
+
+func TestInitials(t *testing.T) {
+	for name, want := range map[string]string{
+		"Alice":           "A",
+		"alice smith":     "AS",
+		"Ana María López": "AL",
+		"+1 555 123 4567": "#",
+		"":                "#",
+	} {
+		if got := initials(name); got != want {
+			t.Errorf("initials(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package web
+
+import (
+	"net/http"
+	"strings"
+)
+
+const (
+	corsAllowMethods = "GET, POST, PATCH, PUT, DELETE, OPTIONS"
+	corsAllowHeaders = "Authorization, Content-Type"
+	corsMaxAge       = "600"
+)
+
+// allowCORS wraps h to let pages on the listed origins call the API. Allowed
+// origins are echoed back in Access-Control-Allow-Origin; "*" allows any.
+// Preflight requests to /api/ are answered here, ahead of any API key check,
+// since browsers send them without credentials. Other origins get no CORS
+// headers, so browsers keep them same-origin.
+func allowCORS(h http.Handler, origins []string) http.Handler {
+	allowed := make(map[string]bool, len(origins))
+	for _, o := range origins {
+		allowed[strings.TrimRight(o, "/")] = true
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+		ok := allowed[origin] || allowed["*"]
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" &&
+			strings.HasPrefix(r.URL.Path, "/api/")
+		if !ok {
+			if preflight {
+				httpError(w, "origin not allowed", http.StatusForbidden)
+				return
+			}
+			h.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		if preflight {
+			w.Header().Set("Access-Control-Allow-Methods", corsAllowMethods)
+			w.Header().Set("Access-Control-Allow-Headers", corsAllowHeaders)
+			w.Header().Set("Access-Control-Max-Age", corsMaxAge)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
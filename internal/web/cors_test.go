@@ -0,0 +1,88 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rs/zerolog"
+
+	"github.com/maxghenis/openmessage/internal/db"
+)
+
+func newCORSServer(t *testing.T, opts Options) *httptest.Server {
+	t.Helper()
+	store, err := db.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	srv := httptest.NewServer(APIHandlerWithOptions(store, nil, zerolog.Nop(), nil, opts))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestCORSPreflight(t *testing.T) {
+	srv := newCORSServer(t, Options{CORSOrigins: []string{"https://ui.example.com"}, APIKey: "k"})
+
+	req, _ := http.NewRequest(http.MethodOptions, srv.URL+"/api/send", nil)
+	req.Header.Set("Origin", "https://ui.example.com")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	req.Header.Set("Access-Control-Request-Headers", "authorization, content-type")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("preflight status = %d, want 204 without the API key", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Access-Control-Allow-Origin"); got != "https://ui.example.com" {
+		t.Errorf("Allow-Origin = %q", got)
+	}
+	if got := resp.Header.Get("Access-Control-Allow-Headers"); got != corsAllowHeaders {
+		t.Errorf("Allow-Headers = %q", got)
+	}
+
+	// The actual request still needs the key, and gets the CORS header.
+	req, _ = http.NewRequest(http.MethodGet, srv.URL+"/api/conversations", nil)
+	req.Header.Set("Origin", "https://ui.example.com")
+	req.Header.Set("Authorization", "Bearer k")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 || resp.Header.Get("Access-Control-Allow-Origin") != "https://ui.example.com" {
+		t.Errorf("GET = %d with Allow-Origin %q", resp.StatusCode, resp.Header.Get("Access-Control-Allow-Origin"))
+	}
+}
+
+func TestCORSDisallowedOrigin(t *testing.T) {
+	for _, opts := range []Options{{}, {CORSOrigins: []string{"https://ui.example.com"}}} {
+		srv := newCORSServer(t, opts)
+
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"/api/conversations", nil)
+		req.Header.Set("Origin", "https://evil.example")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if got := resp.Header.Get("Access-Control-Allow-Origin"); got != "" {
+			t.Errorf("origins %v: Allow-Origin = %q for a disallowed origin", opts.CORSOrigins, got)
+		}
+
+		req, _ = http.NewRequest(http.MethodOptions, srv.URL+"/api/send", nil)
+		req.Header.Set("Origin", "https://evil.example")
+		req.Header.Set("Access-Control-Request-Method", "POST")
+		resp, err = http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if got := resp.Header.Get("Access-Control-Allow-Origin"); got != "" {
+			t.Errorf("origins %v: preflight Allow-Origin = %q for a disallowed origin", opts.CORSOrigins, got)
+		}
+	}
+}
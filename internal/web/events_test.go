@@ -0,0 +1,91 @@
+package web
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/maxghenis/openmessage/internal/client"
+	"github.com/maxghenis/openmessage/internal/db"
+)
+
+// fakeEventSource is a single-subscriber EventSource.
+type fakeEventSource struct {
+	mu     sync.Mutex
+	ch     chan client.StoreEvent
+	closed chan struct{}
+}
+
+func (f *fakeEventSource) Subscribe() (<-chan client.StoreEvent, func()) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.ch = make(chan client.StoreEvent, 1)
+	return f.ch, func() { close(f.closed) }
+}
+
+func (f *fakeEventSource) subscribed() chan client.StoreEvent {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.ch
+}
+
+func TestEventStream(t *testing.T) {
+	store, err := db.New(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	src := &fakeEventSource{closed: make(chan struct{})}
+	srv := httptest.NewServer(APIHandlerWithOptions(store, nil, zerolog.Nop(), nil, Options{Events: src}))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/api/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("content type = %q", ct)
+	}
+
+	src.subscribed() <- client.StoreEvent{Type: client.StoreEventMessage, ConversationID: "c1", MessageID: "m1"}
+	sc := bufio.NewScanner(resp.Body)
+	var lines []string
+	for sc.Scan() {
+		if line := sc.Text(); strings.HasPrefix(line, "event:") || strings.HasPrefix(line, "data:") {
+			lines = append(lines, line)
+			if strings.HasPrefix(line, "data:") {
+				break
+			}
+		}
+	}
+	want := []string{"event: message", `data: {"type":"message","conversation_id":"c1","message_id":"m1"}`}
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Errorf("stream = %q, want %q", lines, want)
+	}
+
+	// Disconnecting unsubscribes.
+	resp.Body.Close()
+	select {
+	case <-src.closed:
+	case <-time.After(2 * time.Second):
+		t.Error("subscription not cleaned up after disconnect")
+	}
+}
+
+func TestEventStreamUnavailable(t *testing.T) {
+	ts := newTestServer(t)
+	resp, err := http.Get(ts.server.URL + "/api/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 503 {
+		t.Errorf("status = %d, want 503", resp.StatusCode)
+	}
+}
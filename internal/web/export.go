@@ -0,0 +1,189 @@
+package web
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/maxghenis/openmessage/internal/client"
+	"github.com/maxghenis/openmessage/internal/db"
+)
+
+const (
+	// maxExportMessages bounds how much of a conversation an HTML export covers.
+	maxExportMessages = 100000
+	// maxExportMediaBytes caps the total size of media inlined in one HTML
+	// export; media past the cap is replaced with a placeholder.
+	maxExportMediaBytes = 50 << 20
+	// maxExportItemBytes skips any single attachment larger than this.
+	maxExportItemBytes = 10 << 20
+)
+
+// MediaDownloader fetches and decrypts an attachment from the phone.
+type MediaDownloader func(mediaID string, key []byte) ([]byte, error)
+
+// handleExport serves GET /api/conversations/{id}/export. The default format
+// is JSON; format=csv returns one row per message and format=html a
+// standalone transcript with images inlined. JSON and CSV are streamed
+// straight from the store, oldest message first.
+func handleExport(w http.ResponseWriter, r *http.Request, store *db.Store, download MediaDownloader, convID string) {
+	if r.Method != http.MethodGet {
+		httpError(w, "method not allowed", 405)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "csv" && format != "html" {
+		httpError(w, "format must be json, csv or html", 400)
+		return
+	}
+
+	conv, err := store.GetConversation(convID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			httpError(w, "conversation not found", 404)
+			return
+		}
+		httpError(w, "get conversation: "+err.Error(), 500)
+		return
+	}
+
+	filename := "conversation-" + sanitizeFilename(convID) + "." + format
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	// Once streaming has started the status is already sent, so a failure
+	// part way through can only truncate the download.
+	switch format {
+	case "json":
+		w.Header().Set("Content-Type", "application/json")
+		client.WriteConversationJSON(w, store, conv)
+		return
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		client.WriteConversationCSV(w, store, convID)
+		return
+	}
+
+	msgs, err := store.GetMessagesByConversation(convID, maxExportMessages)
+	if err != nil {
+		httpError(w, "get messages: "+err.Error(), 500)
+		return
+	}
+	// Oldest first reads naturally in a transcript.
+	for i, j := 0, len(msgs)-1; i < j; i, j = i+1, j-1 {
+		msgs[i], msgs[j] = msgs[j], msgs[i]
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := renderHTMLExport(w, conv, msgs, download, maxExportMediaBytes); err != nil {
+		httpError(w, "render export: "+err.Error(), 500)
+	}
+}
+
+type exportMessage struct {
+	Sender   string
+	Time     string
+	Body     string
+	FromMe   bool
+	ImageURI template.URL
+	Note     string
+}
+
+var exportTemplate = template.Must(template.New("export").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>{{.Title}}</title>
+<style>
+body{font-family:system-ui,sans-serif;max-width:720px;margin:2em auto;padding:0 1em;background:#fafafa}
+.msg{margin:.6em 0;padding:.5em .8em;border-radius:12px;background:#fff;max-width:80%;box-shadow:0 1px 2px rgba(0,0,0,.1)}
+.me{margin-left:auto;background:#d3e3fd}
+.meta{font-size:.75em;color:#666;margin-bottom:.2em}
+.body{white-space:pre-wrap}
+img{max-width:100%;border-radius:8px;display:block;margin-top:.3em}
+.note{font-style:italic;color:#888}
+</style></head><body>
+<h1>{{.Title}}</h1>
+<p class="meta">Exported {{.Exported}} · {{len .Messages}} messages</p>
+{{range .Messages}}<div class="msg{{if .FromMe}} me{{end}}">
+<div class="meta">{{.Sender}} · {{.Time}}</div>
+{{if .Body}}<div class="body">{{.Body}}</div>{{end}}
+{{if .ImageURI}}<img src="{{.ImageURI}}" alt="image">{{end}}
+{{if .Note}}<div class="note">{{.Note}}</div>{{end}}
+</div>
+{{end}}</body></html>
+`))
+
+// renderHTMLExport writes a self-contained HTML transcript. Images are
+// downloaded and embedded as data URIs until budget bytes are used up.
+func renderHTMLExport(w io.Writer, conv *db.Conversation, msgs []*db.Message, download MediaDownloader, budget int64) error {
+	title := conv.Name
+	if title == "" {
+		title = conv.ConversationID
+	}
+	out := make([]exportMessage, 0, len(msgs))
+	for _, m := range msgs {
+		em := exportMessage{
+			Sender: client.ExportSender(m),
+			Body:   m.Body,
+			FromMe: m.IsFromMe,
+		}
+		if m.TimestampMS > 0 {
+			em.Time = time.UnixMilli(m.TimestampMS).Format("2006-01-02 15:04")
+		}
+		if m.MediaID != "" {
+			em.ImageURI, em.Note = exportMedia(m, download, &budget)
+		}
+		out = append(out, em)
+	}
+	return exportTemplate.Execute(w, map[string]any{
+		"Title":    title,
+		"Exported": time.Now().Format("2006-01-02 15:04"),
+		"Messages": out,
+	})
+}
+
+// exportMedia returns a data URI for an image attachment, or a placeholder
+// note when the media can't or shouldn't be inlined.
+func exportMedia(m *db.Message, download MediaDownloader, budget *int64) (template.URL, string) {
+	if !strings.HasPrefix(m.MimeType, "image/") {
+		kind, _, _ := strings.Cut(m.MimeType, "/")
+		if kind == "" || kind == "application" || kind == "text" {
+			kind = "attachment"
+		}
+		return "", fmt.Sprintf("[%s not included]", kind)
+	}
+	if m.MediaSize > maxExportItemBytes || m.MediaSize > *budget {
+		return "", "[image omitted: too large]"
+	}
+	if download == nil {
+		return "", "[image unavailable: not connected]"
+	}
+	key, err := hex.DecodeString(m.DecryptionKey)
+	if err != nil {
+		return "", "[image unavailable]"
+	}
+	data, err := download(m.MediaID, key)
+	if err != nil {
+		return "", "[image unavailable]"
+	}
+	if int64(len(data)) > maxExportItemBytes || int64(len(data)) > *budget {
+		return "", "[image omitted: too large]"
+	}
+	*budget -= int64(len(data))
+	return template.URL("data:" + m.MimeType + ";base64," + base64.StdEncoding.EncodeToString(data)), ""
+}
+
+// sanitizeFilename keeps an ID safe to use in a Content-Disposition header.
+func sanitizeFilename(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '"' || r == '/' || r == '\\' || r < ' ' {
+			return '_'
+		}
+		return r
+	}, s)
+}
@@ -0,0 +1,163 @@
+package web
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/maxghenis/openmessage/internal/client"
+	"github.com/maxghenis/openmessage/internal/db"
+)
+
+func TestRenderHTMLExportInlinesImages(t *testing.T) {
+	conv := &db.Conversation{ConversationID: "c1", Name: "Sarah Chen"}
+	img := []byte("\x89PNG fake image")
+	msgs := []*db.Message{
+		{MessageID: "m1", ConversationID: "c1", SenderName: "Sarah", Body: "look <here>", TimestampMS: 1000},
+		{MessageID: "m2", ConversationID: "c1", SenderName: "Sarah", MediaID: "media-1", MimeType: "image/png", DecryptionKey: "01", TimestampMS: 2000},
+		{MessageID: "m3", ConversationID: "c1", SenderName: "Sarah", MediaID: "media-2", MimeType: "image/jpeg", DecryptionKey: "01", MediaSize: maxExportItemBytes + 1, TimestampMS: 3000},
+		{MessageID: "m4", ConversationID: "c1", IsFromMe: true, MediaID: "media-3", MimeType: "video/mp4", DecryptionKey: "01", TimestampMS: 4000},
+	}
+
+	var downloaded []string
+	download := func(mediaID string, key []byte) ([]byte, error) {
+		downloaded = append(downloaded, mediaID)
+		return img, nil
+	}
+
+	var buf bytes.Buffer
+	if err := renderHTMLExport(&buf, conv, msgs, download, maxExportMediaBytes); err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	out := buf.String()
+
+	if !strings.Contains(out, `src="data:image/png;base64,`+base64.StdEncoding.EncodeToString(img)+`"`) {
+		t.Errorf("image not inlined as data URI:\n%s", out)
+	}
+	if !strings.Contains(out, "look &lt;here&gt;") {
+		t.Error("message body should be HTML-escaped")
+	}
+	if !strings.Contains(out, "[image omitted: too large]") {
+		t.Error("expected placeholder for oversized image")
+	}
+	if !strings.Contains(out, "[video not included]") {
+		t.Error("expected placeholder for video")
+	}
+	if len(downloaded) != 1 || downloaded[0] != "media-1" {
+		t.Errorf("downloaded %v, want only media-1", downloaded)
+	}
+}
+
+func TestRenderHTMLExportBudget(t *testing.T) {
+	conv := &db.Conversation{ConversationID: "c1"}
+	msgs := []*db.Message{
+		{MessageID: "m1", MediaID: "a", MimeType: "image/png", DecryptionKey: "01"},
+		{MessageID: "m2", MediaID: "b", MimeType: "image/png", DecryptionKey: "01"},
+	}
+	download := func(mediaID string, key []byte) ([]byte, error) {
+		return make([]byte, 600), nil
+	}
+
+	var buf bytes.Buffer
+	if err := renderHTMLExport(&buf, conv, msgs, download, 1000); err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(buf.String(), "data:image/png"); n != 1 {
+		t.Errorf("inlined %d images, want 1 within the budget", n)
+	}
+}
+
+func TestExportEndpoint(t *testing.T) {
+	ts := newTestServer(t)
+	ts.store.UpsertConversation(&db.Conversation{ConversationID: "c1", Name: "Sarah"})
+	ts.store.UpsertMessage(&db.Message{MessageID: "m1", ConversationID: "c1", Body: "first", TimestampMS: 1000})
+	ts.store.UpsertMessage(&db.Message{MessageID: "m2", ConversationID: "c1", Body: "second", TimestampMS: 2000})
+
+	resp, err := http.Get(ts.server.URL + "/api/conversations/c1/export?format=html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("status = %d: %s", resp.StatusCode, body)
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if i, j := bytes.Index(body, []byte("first")), bytes.Index(body, []byte("second")); i < 0 || j < i {
+		t.Error("expected messages oldest first")
+	}
+
+	for path, want := range map[string]int{
+		"/api/conversations/c1/export?format=pdf": 400,
+		"/api/conversations/nope/export":          404,
+		"/api/conversations/c1/export":            200,
+	} {
+		resp, err := http.Get(ts.server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("%s: status = %d, want %d", path, resp.StatusCode, want)
+		}
+	}
+}
+
+func TestExportCSVAndJSON(t *testing.T) {
+	ts := newTestServer(t)
+	ts.store.UpsertConversation(&db.Conversation{ConversationID: "c1", Name: "Sarah"})
+	ts.store.UpsertMessage(&db.Message{MessageID: "m1", ConversationID: "c1", SenderName: "Sarah", Body: "hi, \"you\"\nthere", TimestampMS: 1000})
+	ts.store.UpsertMessage(&db.Message{MessageID: "m2", ConversationID: "c1", IsFromMe: true, MediaID: "media-1", MimeType: "image/png", TimestampMS: 2000})
+
+	resp, err := http.Get(ts.server.URL + "/api/conversations/c1/export?format=csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/csv") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	rows, err := csv.NewReader(resp.Body).ReadAll()
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("parse CSV: %v", err)
+	}
+	if len(rows) != 3 || rows[0][0] != "timestamp" {
+		t.Fatalf("rows = %q, want header and two messages", rows)
+	}
+	if got := rows[1]; got[1] != "Sarah" || got[3] != "incoming" || got[4] != "hi, \"you\"\nthere" || got[5] != "false" {
+		t.Errorf("row 1 = %q", got)
+	}
+	if got := rows[2]; got[1] != "Me" || got[3] != "outgoing" || got[5] != "true" || got[6] != "image/png" {
+		t.Errorf("row 2 = %q", got)
+	}
+
+	resp, err = http.Get(ts.server.URL + "/api/conversations/c1/export?format=json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var export struct {
+		Conversation db.Conversation       `json:"conversation"`
+		Messages     []client.ExportRecord `json:"messages"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&export)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("decode JSON: %v", err)
+	}
+	if export.Conversation.Name != "Sarah" || len(export.Messages) != 2 {
+		t.Fatalf("export = %+v", export)
+	}
+	if m := export.Messages[0]; m.MessageID != "m1" || m.Timestamp != "1970-01-01T00:00:01Z" || m.HasMedia {
+		t.Errorf("messages[0] = %+v", m)
+	}
+	if m := export.Messages[1]; m.Direction != "outgoing" || !m.HasMedia {
+		t.Errorf("messages[1] = %+v", m)
+	}
+}
@@ -0,0 +1,57 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/maxghenis/openmessage/internal/client"
+	"github.com/maxghenis/openmessage/internal/db"
+)
+
+// healthPingTimeout bounds the database check so a wedged database reports
+// unhealthy instead of hanging the monitor.
+const healthPingTimeout = 2 * time.Second
+
+// healthReport is the body of /api/health.
+type healthReport struct {
+	Healthy            bool   `json:"healthy"`
+	DB                 string `json:"db"` // "ok", or the ping error
+	Connected          bool   `json:"connected"`
+	LoggedIn           bool   `json:"logged_in"`
+	SupabaseConfigured bool   `json:"supabase_configured"`
+	Messages           int    `json:"messages"`
+	Conversations      int    `json:"conversations"`
+	UptimeSeconds      int64  `json:"uptime_seconds"`
+	StartedAtMS        int64  `json:"started_at_ms"`
+}
+
+// handleHealth serves GET /api/health. It answers 503 if the database
+// doesn't respond; a disconnected phone is reported but still healthy, since
+// the server itself keeps working.
+func handleHealth(w http.ResponseWriter, r *http.Request, store *db.Store, cli *client.Client, connected, supabase bool, started time.Time) {
+	rep := healthReport{
+		DB:                 "ok",
+		Connected:          connected,
+		SupabaseConfigured: supabase,
+		UptimeSeconds:      int64(time.Since(started).Seconds()),
+		StartedAtMS:        started.UnixMilli(),
+	}
+	if cli != nil {
+		rep.LoggedIn = cli.GM.IsLoggedIn()
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), healthPingTimeout)
+	defer cancel()
+	if err := store.Ping(ctx); err != nil {
+		rep.DB = err.Error()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		writeJSON(w, rep)
+		return
+	}
+	rep.Healthy = true
+	rep.Messages, _ = store.CountMessages()
+	rep.Conversations, _ = store.CountConversations(0, true)
+	writeJSON(w, rep)
+}
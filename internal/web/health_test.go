@@ -0,0 +1,50 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/maxghenis/openmessage/internal/db"
+)
+
+func TestHealth(t *testing.T) {
+	ts := newTestServer(t)
+	ts.store.UpsertConversation(&db.Conversation{ConversationID: "c1", LastMessageTS: 1000})
+	ts.store.UpsertMessage(&db.Message{MessageID: "m1", ConversationID: "c1", Body: "hi", TimestampMS: 1000})
+	ts.store.UpsertMessage(&db.Message{MessageID: "m2", ConversationID: "c1", Body: "there", TimestampMS: 2000})
+
+	get := func() (int, healthReport) {
+		t.Helper()
+		resp, err := http.Get(ts.server.URL + "/api/health")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var rep healthReport
+		if err := json.NewDecoder(resp.Body).Decode(&rep); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		return resp.StatusCode, rep
+	}
+
+	code, rep := get()
+	if code != 200 || !rep.Healthy || rep.DB != "ok" {
+		t.Fatalf("health = %d %+v, want healthy", code, rep)
+	}
+	if rep.Connected || rep.LoggedIn || rep.SupabaseConfigured {
+		t.Errorf("health = %+v, want disconnected with no Supabase", rep)
+	}
+	if rep.Messages != 2 || rep.Conversations != 1 {
+		t.Errorf("counts = %d messages, %d conversations; want 2 and 1", rep.Messages, rep.Conversations)
+	}
+	if rep.StartedAtMS == 0 || rep.UptimeSeconds < 0 {
+		t.Errorf("uptime = %+v", rep)
+	}
+
+	ts.store.Close()
+	code, rep = get()
+	if code != 503 || rep.Healthy || rep.DB == "ok" {
+		t.Errorf("health with the database closed = %d %+v, want 503", code, rep)
+	}
+}
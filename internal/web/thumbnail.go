@@ -0,0 +1,196 @@
+package web
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif" // registered for image.Decode
+	"image/jpeg"
+	_ "image/png"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/maxghenis/openmessage/internal/db"
+)
+
+const (
+	// defaultThumbnailWidth is the width of thumbnails asked for with thumb=1.
+	defaultThumbnailWidth = 200
+
+	// maxThumbnailWidth bounds w=; wider requests should fetch the original.
+	maxThumbnailWidth = 1024
+
+	// thumbnailCacheEntries bounds the thumbnail cache; the oldest entry is
+	// evicted first.
+	thumbnailCacheEntries = 500
+
+	thumbnailQuality = 80
+
+	// maxThumbnailPixels bounds the images decoded for a thumbnail, since
+	// decoding holds every pixel in memory. Larger images are served as is.
+	maxThumbnailPixels = 25_000_000
+)
+
+// errNoThumbnail means an image can't be, or needn't be, downscaled.
+var errNoThumbnail = errors.New("no thumbnail")
+
+// thumbnailWidth reads the thumbnail width requested by ?thumb=1 or ?w=N,
+// returning 0 when the original is wanted.
+func thumbnailWidth(r *http.Request) (int, error) {
+	q := r.URL.Query()
+	if v := q.Get("w"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("w must be a positive integer")
+		}
+		return min(n, maxThumbnailWidth), nil
+	}
+	if q.Get("thumb") == "1" {
+		return defaultThumbnailWidth, nil
+	}
+	return 0, nil
+}
+
+func thumbnailETag(msgID string, width int) string {
+	return `"` + msgID + "-w" + strconv.Itoa(width) + `"`
+}
+
+type thumbnail struct {
+	data []byte
+	mime string
+}
+
+// thumbnailCache keeps generated thumbnails by message ID and width.
+type thumbnailCache struct {
+	mu      sync.Mutex
+	entries map[string]thumbnail
+	order   []string // keys, oldest first
+}
+
+func newThumbnailCache() *thumbnailCache {
+	return &thumbnailCache{entries: map[string]thumbnail{}}
+}
+
+func (c *thumbnailCache) get(key string) (thumbnail, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t, ok := c.entries[key]
+	return t, ok
+}
+
+func (c *thumbnailCache) put(key string, t thumbnail) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; ok {
+		return
+	}
+	if len(c.order) >= thumbnailCacheEntries {
+		delete(c.entries, c.order[0])
+		c.order = c.order[1:]
+	}
+	c.entries[key] = t
+	c.order = append(c.order, key)
+}
+
+// serveThumbnail writes a JPEG of msg's image at most width pixels wide. A
+// video's thumbnail is made from the preview image the phone sent with it,
+// if any. Attachments that can't be downscaled, such as audio, WebP images
+// and videos without a preview, are served as the original bytes.
+func serveThumbnail(w http.ResponseWriter, r *http.Request, msg *db.Message, gm mediaDownloader, downloads *downloadLimiter, thumbs *thumbnailCache, width int) {
+	key := msg.MessageID + "@" + strconv.Itoa(width)
+	t, ok := thumbs.get(key)
+	if !ok {
+		mediaID, hexKey := msg.MediaID, msg.DecryptionKey
+		if strings.HasPrefix(msg.MimeType, "video/") && msg.ThumbnailMediaID != "" {
+			mediaID, hexKey = msg.ThumbnailMediaID, msg.ThumbnailDecryptionKey
+		}
+		data, fetched := fetchMedia(w, r, msg, gm, downloads, mediaID, hexKey)
+		if !fetched {
+			return
+		}
+		var resized []byte
+		err := errNoThumbnail
+		if mediaID != msg.MediaID || strings.HasPrefix(msg.MimeType, "image/") {
+			resized, err = makeThumbnail(data, width)
+		}
+		switch {
+		case err == nil:
+			t = thumbnail{data: resized, mime: "image/jpeg"}
+			thumbs.put(key, t)
+		case mediaID == msg.MediaID:
+			t = thumbnail{data: data, mime: msg.MimeType}
+		default:
+			t = thumbnail{data: data, mime: http.DetectContentType(data)}
+		}
+	}
+	w.Header().Set("Content-Type", t.mime)
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Header().Set("ETag", thumbnailETag(msg.MessageID, width))
+	w.Write(t.data)
+}
+
+// makeThumbnail decodes a JPEG, PNG or GIF image and returns it as a JPEG
+// scaled down to width, keeping its aspect ratio. It returns errNoThumbnail
+// if the image is already no wider than width or has more than
+// maxThumbnailPixels pixels.
+func makeThumbnail(data []byte, width int) ([]byte, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Width <= width || int64(cfg.Width)*int64(cfg.Height) > maxThumbnailPixels {
+		return nil, errNoThumbnail
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	b := src.Bounds()
+	height := max(1, b.Dy()*width/b.Dx())
+
+	// JPEG has no alpha, so transparent areas are flattened onto white.
+	rgba := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(rgba, rgba.Bounds(), src, b.Min, draw.Over)
+	dst := downscale(rgba, width, height)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: thumbnailQuality}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// downscale resizes src to width x height by averaging the source pixels
+// each destination pixel covers.
+func downscale(src *image.RGBA, width, height int) *image.RGBA {
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0, y1 := y*sh/height, max((y+1)*sh/height, y*sh/height+1)
+		for x := 0; x < width; x++ {
+			x0, x1 := x*sw/width, max((x+1)*sw/width, x*sw/width+1)
+			var sum [4]int
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					p := row[sx*4 : sx*4+4]
+					sum[0] += int(p[0])
+					sum[1] += int(p[1])
+					sum[2] += int(p[2])
+					sum[3] += int(p[3])
+				}
+			}
+			n := (y1 - y0) * (x1 - x0)
+			i := y*dst.Stride + x*4
+			for c := range sum {
+				dst.Pix[i+c] = uint8(sum[c] / n)
+			}
+		}
+	}
+	return dst
+}
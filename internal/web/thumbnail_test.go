@@ -0,0 +1,156 @@
+package web
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/maxghenis/openmessage/internal/db"
+)
+
+// staticDownloader serves fixed bytes per media ID and counts downloads.
+type staticDownloader struct {
+	media map[string][]byte
+	calls int
+}
+
+func (d *staticDownloader) DownloadMedia(mediaID string, key []byte) ([]byte, error) {
+	d.calls++
+	return d.media[mediaID], nil
+}
+
+func testPNG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	// Noise, so the PNG doesn't compress below the size of a thumbnail.
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	seed := uint32(1)
+	for i := range img.Pix {
+		seed = seed*1664525 + 1013904223
+		img.Pix[i] = uint8(seed >> 24)
+		if i%4 == 3 {
+			img.Pix[i] = 255
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestServeThumbnailPNG(t *testing.T) {
+	original := testPNG(t, 800, 600)
+	gm := &staticDownloader{media: map[string][]byte{"media-1": original}}
+	msg := &db.Message{MessageID: "m1", MediaID: "media-1", MimeType: "image/png", DecryptionKey: "deadbeef"}
+	thumbs := newThumbnailCache()
+
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		serveThumbnail(rec, httptest.NewRequest("GET", "/api/media/m1?thumb=1", nil), msg, gm, newDownloadLimiter(1), thumbs, defaultThumbnailWidth)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d: %s", rec.Code, rec.Body)
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != "image/jpeg" {
+			t.Errorf("Content-Type = %q, want image/jpeg", ct)
+		}
+		if cc := rec.Header().Get("Cache-Control"); cc != "public, max-age=86400" {
+			t.Errorf("Cache-Control = %q", cc)
+		}
+		if etag := rec.Header().Get("ETag"); etag != `"m1-w200"` {
+			t.Errorf("ETag = %q", etag)
+		}
+		if rec.Body.Len() >= len(original) {
+			t.Errorf("thumbnail is %d bytes, original %d", rec.Body.Len(), len(original))
+		}
+		img, err := jpeg.Decode(rec.Body)
+		if err != nil {
+			t.Fatalf("decode thumbnail: %v", err)
+		}
+		if b := img.Bounds(); b.Dx() != 200 || b.Dy() != 150 {
+			t.Errorf("thumbnail is %dx%d, want 200x150", b.Dx(), b.Dy())
+		}
+	}
+	if gm.calls != 1 {
+		t.Errorf("downloads = %d, want 1 with the thumbnail cached", gm.calls)
+	}
+}
+
+func TestServeThumbnailFallbacks(t *testing.T) {
+	small := testPNG(t, 100, 80)
+	poster := testPNG(t, 640, 360)
+	gm := &staticDownloader{media: map[string][]byte{
+		"small":  small,
+		"webp":   []byte("RIFF....WEBPVP8 "),
+		"video":  []byte("full video"),
+		"poster": poster,
+	}}
+	tests := []struct {
+		name     string
+		msg      *db.Message
+		wantType string
+		wantBody []byte
+	}{
+		{"already small", &db.Message{MessageID: "a", MediaID: "small", MimeType: "image/png"}, "image/png", small},
+		{"undecodable", &db.Message{MessageID: "b", MediaID: "webp", MimeType: "image/webp"}, "image/webp", gm.media["webp"]},
+		{"video without preview", &db.Message{MessageID: "c", MediaID: "video", MimeType: "video/mp4"}, "video/mp4", gm.media["video"]},
+		{"video preview", &db.Message{MessageID: "d", MediaID: "video", MimeType: "video/mp4", ThumbnailMediaID: "poster"}, "image/jpeg", nil},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		serveThumbnail(rec, httptest.NewRequest("GET", "/api/media/x?w=200", nil), tt.msg, gm, newDownloadLimiter(1), newThumbnailCache(), 200)
+		if ct := rec.Header().Get("Content-Type"); ct != tt.wantType {
+			t.Errorf("%s: Content-Type = %q, want %q", tt.name, ct, tt.wantType)
+		}
+		if tt.wantBody != nil && !bytes.Equal(rec.Body.Bytes(), tt.wantBody) {
+			t.Errorf("%s: body is not the original bytes", tt.name)
+		}
+	}
+}
+
+// pngHeaderOnly returns the start of a PNG claiming to be w x h, enough for
+// image.DecodeConfig but not to decode.
+func pngHeaderOnly(w, h uint32) []byte {
+	ihdr := binary.BigEndian.AppendUint32([]byte("IHDR"), w)
+	ihdr = binary.BigEndian.AppendUint32(ihdr, h)
+	ihdr = append(ihdr, 8, 2, 0, 0, 0) // 8-bit RGB
+	b := []byte("\x89PNG\r\n\x1a\n")
+	b = binary.BigEndian.AppendUint32(b, 13)
+	b = append(b, ihdr...)
+	return binary.BigEndian.AppendUint32(b, crc32.ChecksumIEEE(ihdr))
+}
+
+func TestMakeThumbnailSkipsHugeImages(t *testing.T) {
+	if _, err := makeThumbnail(pngHeaderOnly(20000, 20000), defaultThumbnailWidth); err != errNoThumbnail {
+		t.Errorf("400-megapixel image: err = %v, want errNoThumbnail without decoding", err)
+	}
+	if _, err := makeThumbnail(testPNG(t, 400, 300), defaultThumbnailWidth); err != nil {
+		t.Errorf("small image: %v", err)
+	}
+}
+
+func TestThumbnailWidth(t *testing.T) {
+	tests := []struct {
+		query   string
+		want    int
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"thumb=1", defaultThumbnailWidth, false},
+		{"w=320", 320, false},
+		{"w=99999", maxThumbnailWidth, false},
+		{"w=0", 0, true},
+		{"w=wide", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := thumbnailWidth(httptest.NewRequest("GET", "/api/media/m1?"+tt.query, nil))
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("%q: got %d, %v; want %d, error %v", tt.query, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
@@ -19,6 +19,7 @@ func main() {
 		fmt.Fprintln(os.Stderr, "  pair                          - Pair with your phone via QR code")
 		fmt.Fprintln(os.Stderr, "  serve                         - Start MCP server (stdio)")
 		fmt.Fprintln(os.Stderr, "  send <conversation_id> <msg>  - Send message to a conversation")
+		fmt.Fprintln(os.Stderr, "  rebuild                       - Rebuild the search index and other derived data")
 		os.Exit(1)
 	}
 
@@ -34,6 +35,8 @@ func main() {
 			os.Exit(1)
 		}
 		err = cmd.RunSend(logger, os.Args[2], os.Args[3])
+	case "rebuild":
+		err = cmd.RunRebuild(logger)
 	case "debug-media":
 		if len(os.Args) < 3 {
 			fmt.Fprintln(os.Stderr, "Usage: openmessage debug-media <conversation_id>")